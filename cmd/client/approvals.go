@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/libp2p/go-libp2p/core/network"
+
+	"github.com/hemantsingh443/p2p-git-remote/internal/protocol"
+)
+
+// handleApprovals waits for destructive requests other devices send, and
+// asks whether to approve each one, until Ctrl+C.
+func handleApprovals(stream network.Stream) {
+	payloadBytes, _ := json.Marshal(protocol.ApprovalsRequestPayload{})
+	req := &protocol.Message{Type: protocol.TypeApprovalsRequest, Payload: payloadBytes}
+	protocol.WriteMessage(stream, req)
+
+	resp, err := protocol.ReadMessage(stream)
+	if err != nil {
+		color.Red("Error reading approvals response: %v", err)
+		return
+	}
+	var respPayload protocol.ApprovalsResponsePayload
+	json.Unmarshal(resp.Payload, &respPayload)
+	if !respPayload.Success {
+		color.Red("Error from daemon: %s", respPayload.Error)
+		return
+	}
+
+	// Ctrl+C stops watching instead of quitting the client
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stream.Close()
+	}()
+	fmt.Println("Waiting for requests to approve, press Ctrl+C to stop.")
+	for {
+		msg, err := protocol.ReadMessage(stream)
+		if err != nil {
+			if ctx.Err() == nil {
+				color.Red("Lost connection to the daemon: %v", err)
+			}
+			return
+		}
+		switch msg.Type {
+		case protocol.TypeApprovalRequired:
+			var event protocol.ApprovalRequiredPayload
+			json.Unmarshal(msg.Payload, &event)
+			if time.Now().After(event.Expires) {
+				continue
+			}
+			color.Yellow("\nPeer %s asks for %s in repo '%s' (%s).", event.Peer, event.Action, event.RepoPath, event.Request)
+			fmt.Printf("Approve it? It expires at %s. (y/n): ", event.Expires.Local().Format("15:04:05"))
+			answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+			decision := protocol.ApprovalDecisionPayload{ID: event.ID, Approve: strings.TrimSpace(answer) == "y"}
+			payloadBytes, _ := json.Marshal(decision)
+			if err := protocol.WriteMessage(stream, &protocol.Message{Type: protocol.TypeApprovalDecision, Payload: payloadBytes}); err != nil {
+				return
+			}
+		case protocol.TypeApprovalDecisionResponse:
+			var result protocol.ApprovalDecisionResponsePayload
+			json.Unmarshal(msg.Payload, &result)
+			if !result.Success {
+				color.Red("Error from daemon: %s", result.Error)
+			} else {
+				color.Green("Sent your decision on request %s.", result.ID)
+			}
+		}
+	}
+}
+
+// printApprovalPending tells the user a destructive request they sent waits
+// for another device.
+func printApprovalPending(pending protocol.ApprovalPendingPayload) {
+	color.Yellow("Waiting for another admin device to approve %s (request %s, until %s)...", pending.Action, pending.ID, pending.Expires.Local().Format("15:04:05"))
+}
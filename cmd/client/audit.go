@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/libp2p/go-libp2p/core/network"
+
+	"github.com/hemantsingh443/p2p-git-remote/internal/protocol"
+)
+
+// defaultAuditLimit is how many entries `audit` shows without --limit.
+const defaultAuditLimit = 50
+
+// parseAuditFlags turns the flags of the audit command into a request. The
+// export file, if any, is returned apart.
+func parseAuditFlags(flags map[string]string) (protocol.AuditLogRequestPayload, string, error) {
+	reqPayload := protocol.AuditLogRequestPayload{
+		Peer: flags["peer"],
+		Type: flags["type"],
+		Repo: flags["repo"],
+	}
+	export := flags["export"]
+	if export == "" {
+		reqPayload.Limit = defaultAuditLimit
+	}
+	if since, ok := flags["since"]; ok {
+		d, err := time.ParseDuration(since)
+		if err != nil {
+			return reqPayload, "", fmt.Errorf("invalid --since: %v", err)
+		}
+		reqPayload.Since = time.Now().Add(-d)
+	}
+	if limit, ok := flags["limit"]; ok {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 0 {
+			return reqPayload, "", fmt.Errorf("invalid --limit: %s", limit)
+		}
+		reqPayload.Limit = n
+	}
+	return reqPayload, export, nil
+}
+
+func handleAuditLog(stream network.Stream, reqPayload protocol.AuditLogRequestPayload, exportFile string) {
+	payloadBytes, _ := json.Marshal(reqPayload)
+	req := &protocol.Message{Type: protocol.TypeAuditLogRequest, Payload: payloadBytes}
+	protocol.WriteMessage(stream, req)
+
+	resp, err := protocol.ReadMessage(stream)
+	if err != nil {
+		color.Red("Error reading audit log: %v", err)
+		return
+	}
+	var respPayload protocol.AuditLogResponsePayload
+	json.Unmarshal(resp.Payload, &respPayload)
+	if !respPayload.Success {
+		color.Red("Error from daemon: %s", respPayload.Error)
+		return
+	}
+
+	if exportFile != "" {
+		f, err := os.Create(exportFile)
+		if err != nil {
+			color.Red("Error exporting audit log: %v", err)
+			return
+		}
+		defer f.Close()
+		encoder := json.NewEncoder(f)
+		for _, entry := range respPayload.Entries {
+			if err := encoder.Encode(entry); err != nil {
+				color.Red("Error exporting audit log: %v", err)
+				return
+			}
+		}
+		color.Green("Exported %d audit entries to %s", len(respPayload.Entries), exportFile)
+		return
+	}
+
+	if len(respPayload.Entries) == 0 {
+		fmt.Println("No audit entries.")
+		return
+	}
+	color.Cyan("--- Audit Log ---")
+	for _, entry := range respPayload.Entries {
+		result := color.GreenString("%-8s", entry.Result)
+		if entry.Result != "ok" && entry.Result != "approved" {
+			result = color.RedString("%-8s", entry.Result)
+		}
+		peerID := entry.Peer
+		if len(peerID) > 16 {
+			peerID = peerID[:16]
+		}
+		line := fmt.Sprintf("%s  %s  %s %s", entry.Time.Local().Format("2006-01-02 15:04:05"), peerID, result, entry.Type)
+		if entry.Repo != "" {
+			line += " [" + entry.Repo + "]"
+		}
+		if entry.Args != "" {
+			line += " " + entry.Args
+		}
+		if entry.Error != "" {
+			line += ": " + entry.Error
+		}
+		fmt.Println(line)
+	}
+	color.Cyan("-----------------")
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/libp2p/go-libp2p/core/network"
+
+	"github.com/hemantsingh443/p2p-git-remote/internal/protocol"
+)
+
+// readBatchFile parses a batch file, one change per line:
+//
+//	write <remote-path> <local-file>
+//	rename <old-path> <new-path>
+//	delete <remote-path>
+//
+// Blank lines and lines starting with # are skipped. Local files are read
+// right away, so the batch is complete before anything is sent.
+func readBatchFile(name string) ([]protocol.BatchOperation, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var ops []protocol.BatchOperation
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := splitArgs(line)
+		switch {
+		case fields[0] == protocol.BatchWrite && len(fields) == 3:
+			content, err := os.ReadFile(fields[2])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", n, err)
+			}
+			ops = append(ops, protocol.BatchOperation{Op: protocol.BatchWrite, Path: fields[1], Content: content})
+		case fields[0] == protocol.BatchRename && len(fields) == 3:
+			ops = append(ops, protocol.BatchOperation{Op: protocol.BatchRename, Path: fields[1], NewPath: fields[2]})
+		case fields[0] == protocol.BatchDelete && len(fields) == 2:
+			ops = append(ops, protocol.BatchOperation{Op: protocol.BatchDelete, Path: fields[1]})
+		default:
+			return nil, fmt.Errorf("line %d: expected 'write <remote> <local>', 'rename <old> <new>' or 'delete <path>'", n)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return ops, nil
+}
+
+func handleBatch(stream network.Stream, repoAlias, batchFile string) {
+	ops, err := readBatchFile(batchFile)
+	if err != nil {
+		color.Red("Error reading %s: %v", batchFile, err)
+		return
+	}
+	if len(ops) == 0 {
+		fmt.Println("The batch is empty.")
+		return
+	}
+
+	deletes := 0
+	fmt.Printf("Applying %d changes as one batch:\n", len(ops))
+	for _, op := range ops {
+		switch op.Op {
+		case protocol.BatchWrite:
+			fmt.Printf("  write  %s (%s)\n", op.Path, formatBytes(int64(len(op.Content))))
+		case protocol.BatchRename:
+			fmt.Printf("  rename %s -> %s\n", op.Path, op.NewPath)
+		case protocol.BatchDelete:
+			color.Red("  delete %s", op.Path)
+			deletes++
+		}
+	}
+	if deletes > 0 {
+		color.Red("WARNING: the batch deletes %d file(s), including any uncommitted changes to them.", deletes)
+		fmt.Print("Are you sure you want to proceed? (y/n): ")
+		reader := bufio.NewReader(os.Stdin)
+		answer, _ := reader.ReadString('\n')
+		if strings.TrimSpace(answer) != "y" {
+			fmt.Println("Batch aborted.")
+			return
+		}
+	}
+
+	reqPayload := protocol.BatchRequestPayload{RepoPath: repoAlias, Operations: ops}
+	payloadBytes, _ := json.Marshal(reqPayload)
+	req := &protocol.Message{Type: protocol.TypeBatchRequest, Payload: payloadBytes}
+	protocol.WriteMessage(stream, req)
+
+	resp, err := protocol.ReadMessage(stream)
+	if err != nil {
+		color.Red("Error reading batch response: %v", err)
+		return
+	}
+	var respPayload protocol.BatchResponsePayload
+	json.Unmarshal(resp.Payload, &respPayload)
+	if !respPayload.Success {
+		color.Red("Error from daemon: %s", respPayload.Output)
+		return
+	}
+	color.Green(strings.TrimSpace(respPayload.Output))
+}
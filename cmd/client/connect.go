@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/fatih/color"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+
+	p2p "github.com/hemantsingh443/p2p-git-remote/internal/p2p"
+	"github.com/hemantsingh443/p2p-git-remote/internal/store"
+)
+
+// daemonConn is the client's connection to one daemon.
+type daemonConn struct {
+	info    peer.AddrInfo
+	conn    *p2p.ConnSupervisor
+	session *p2p.SessionKeeper
+}
+
+// repoContext is where the REPL was on a daemon, restored when connecting
+// back to it.
+type repoContext struct {
+	repo   string
+	branch string
+}
+
+// dialDaemon connects to a linked daemon, pairs with it if it isn't trusted
+// yet, and starts a session. resolve, if not nil, looks the daemon up when
+// its address is unknown or stale.
+func dialDaemon(ctx context.Context, h host.Host, cm *ConfigManager, ts *store.TrustStore, resolve p2p.Resolver, name string, quiet bool) (*daemonConn, error) {
+	daemonAddr, ok := cm.Config[name]
+	if !ok {
+		return nil, fmt.Errorf("daemon name '%s' not found in your config file", name)
+	}
+	addrInfo, err := parseDaemonAddr(daemonAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse daemon address: %w", err)
+	}
+	if err := connectDaemon(ctx, h, cm, addrInfo, resolve); err != nil {
+		return nil, fmt.Errorf("failed to connect to daemon: %w", err)
+	}
+
+	conn := p2p.NewConnSupervisor(h, *addrInfo)
+	if resolve != nil {
+		conn.SetResolver(resolve)
+	}
+	session := p2p.NewSessionKeeper(h, addrInfo.ID)
+	session.SetConn(conn)
+	if !ts.IsTrusted(addrInfo.ID) {
+		if err := performHandshake(ctx, h, *addrInfo, ts, session); err != nil {
+			conn.Stop()
+			return nil, fmt.Errorf("handshake failed: %w", err)
+		}
+	} else {
+		if !quiet {
+			fmt.Println("Daemon is already trusted.")
+		}
+		if err := session.Ensure(ctx); err != nil {
+			color.Red("Could not start a session with the daemon: %v", err)
+		}
+	}
+	return &daemonConn{info: *addrInfo, conn: conn, session: session}, nil
+}
+
+// handleConnect leaves the current daemon for another linked one. The repo
+// and branch in use on each daemon are kept, and picked again on coming back.
+func handleConnect(state *clientState, name string) {
+	if name == state.daemonName {
+		fmt.Printf("Already connected to '%s'.\n", name)
+		return
+	}
+	if _, ok := state.config.Config[name]; !ok {
+		color.Red("Error: no daemon is linked as '%s'. Use '%s link %s' to add it.", name, clientCommand, name)
+		if state.exec != nil {
+			state.exec.failed = true
+		}
+		return
+	}
+	fmt.Printf("Connecting to '%s'...\n", name)
+	dc, err := dialDaemon(context.Background(), state.p2pHost, state.config, state.trustStore, state.resolve, name, state.exec != nil)
+	if err != nil {
+		color.Red("Error: could not connect to '%s': %v", name, err)
+		if state.exec != nil {
+			state.exec.failed = true
+		}
+		return
+	}
+
+	state.contexts[state.daemonName] = repoContext{repo: state.currentRepo, branch: state.currentBranch}
+	old := state.conn
+	dc.conn.Notify, old.Notify = old.Notify, nil
+	old.Stop()
+	if state.daemonInfo.ID == dc.info.ID {
+		// Two names linked to the same daemon: keep the connection
+		state.p2pHost.ConnManager().Protect(dc.info.ID, "daemon")
+	} else {
+		state.p2pHost.Network().ClosePeer(state.daemonInfo.ID)
+	}
+
+	state.daemonName = name
+	state.daemonInfo, state.conn, state.session = dc.info, dc.conn, dc.session
+	back := state.contexts[name]
+	state.currentRepo, state.currentBranch, state.statusBadge = back.repo, back.branch, ""
+	if state.currentRepo == "" {
+		color.Green("Connected to '%s'.", name)
+		return
+	}
+	color.Green("Connected to '%s', back in %s.", name, state.currentRepo)
+	if state.exec == nil {
+		refreshStatusBadge(state)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+const daemonsUsage = "daemons list | daemons remove <name> | daemons rename <old> <new>"
+
+// runDaemons manages the daemons linked in the config, for
+// './client daemons ...', and returns the status to exit with.
+func runDaemons(cm *ConfigManager, args []string) int {
+	switch {
+	case len(args) == 0 || (len(args) == 1 && args[0] == "list"):
+		listDaemons(cm)
+		return 0
+	case len(args) == 2 && args[0] == "remove":
+		return removeDaemon(cm, args[1])
+	case len(args) == 3 && args[0] == "rename":
+		return renameDaemon(cm, args[1], args[2])
+	}
+	fmt.Printf("Usage: %s %s\n", clientCommand, daemonsUsage)
+	return 2
+}
+
+// listDaemons prints each linked daemon with its peer ID, address, and when
+// the client last connected to it.
+func listDaemons(cm *ConfigManager) {
+	if len(cm.Config) == 0 {
+		fmt.Printf("No daemons linked. Use '%s link <name>' or '%s discover' to add one.\n", clientCommand, clientCommand)
+		return
+	}
+	names := make([]string, 0, len(cm.Config))
+	for name := range cm.Config {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	lastConnected := loadLastConnected(cm)
+	for _, name := range names {
+		color.New(color.FgYellow, color.Bold).Println(name)
+		info, err := parseDaemonAddr(cm.Config[name])
+		if err != nil {
+			color.Red("  invalid address %s: %v", cm.Config[name], err)
+			continue
+		}
+		fmt.Printf("  peer ID:        %s\n", info.ID)
+		if len(info.Addrs) > 0 {
+			fmt.Printf("  address:        %s\n", info.Addrs[0])
+		} else {
+			fmt.Println("  address:        looked up on the DHT by peer ID")
+		}
+		if last, ok := lastConnected[info.ID.String()]; ok {
+			ago := "just now"
+			if since := time.Since(last); since >= time.Minute {
+				ago = since.Round(time.Minute).String() + " ago"
+			}
+			fmt.Printf("  last connected: %s (%s)\n", last.Local().Format("2006-01-02 15:04"), ago)
+		} else {
+			fmt.Println("  last connected: never")
+		}
+	}
+}
+
+// removeDaemon unlinks a daemon. The client still trusts it, so linking it
+// again doesn't need pairing.
+func removeDaemon(cm *ConfigManager, name string) int {
+	if _, ok := cm.Config[name]; !ok {
+		color.Red("Error: no daemon is linked as '%s'.", name)
+		return 1
+	}
+	delete(cm.Config, name)
+	if err := cm.Save(); err != nil {
+		color.Red("Failed to save config: %v", err)
+		return 1
+	}
+	color.Green("Removed '%s'.", name)
+	return 0
+}
+
+func renameDaemon(cm *ConfigManager, name, newName string) int {
+	addr, ok := cm.Config[name]
+	if !ok {
+		color.Red("Error: no daemon is linked as '%s'.", name)
+		return 1
+	}
+	if _, taken := cm.Config[newName]; taken {
+		color.Red("Error: a daemon is already linked as '%s'.", newName)
+		return 1
+	}
+	delete(cm.Config, name)
+	cm.Config[newName] = addr
+	if err := cm.Save(); err != nil {
+		color.Red("Failed to save config: %v", err)
+		return 1
+	}
+	color.Green("Renamed '%s' to '%s'. Connect with '%s %s'.", name, newName, clientCommand, newName)
+	return 0
+}
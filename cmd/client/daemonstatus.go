@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/libp2p/go-libp2p/core/network"
+
+	"github.com/hemantsingh443/p2p-git-remote/internal/protocol"
+)
+
+// handleDaemonStatus prints what the daemon runs, since when, and how busy
+// it is.
+func handleDaemonStatus(stream network.Stream) {
+	payloadBytes, _ := json.Marshal(protocol.DaemonStatusRequestPayload{})
+	req := &protocol.Message{Type: protocol.TypeDaemonStatusRequest, Payload: payloadBytes}
+	protocol.WriteMessage(stream, req)
+
+	resp, err := protocol.ReadMessage(stream)
+	if err != nil {
+		color.Red("Error reading daemon status response: %v", err)
+		return
+	}
+	var respPayload protocol.DaemonStatusResponsePayload
+	json.Unmarshal(resp.Payload, &respPayload)
+	if printJSON(respPayload) {
+		return
+	}
+	if !respPayload.Success {
+		color.Red("Error from daemon: %s", respPayload.Error)
+		return
+	}
+
+	uptime := (time.Duration(respPayload.UptimeMs) * time.Millisecond).Truncate(time.Second)
+	fmt.Printf("Version:       %s (protocol %s)\n", respPayload.Version, respPayload.ProtocolVersion)
+	fmt.Printf("Up for:        %s, since %s\n", uptime, respPayload.Started.Local().Format("2006-01-02 15:04:05"))
+	fmt.Printf("Linked repos:  %d\n", respPayload.LinkedRepos)
+	fmt.Printf("Trusted peers: %d, %d of them connected\n", respPayload.TrustedPeers, respPayload.ConnectedTrusted)
+	fmt.Printf("Connections:   %d peers, DHT peers included\n", respPayload.ConnectedPeers)
+	fmt.Printf("Load:          %d requests in flight, this one included, and %d jobs\n", respPayload.InFlight, respPayload.Jobs)
+	if respPayload.ReadOnly {
+		color.Yellow("The daemon is in read-only mode.")
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+
+	p2p "github.com/hemantsingh443/p2p-git-remote/internal/p2p"
+)
+
+// discoverWait is how long `discover` listens for daemons on the network.
+const discoverWait = 3 * time.Second
+
+// discoverDaemon lists the daemons announcing themselves on the local
+// network and lets the user pick one, linking it under a name if it's new.
+// It returns the daemon's name, or "" if none was picked.
+func discoverDaemon(cm *ConfigManager) string {
+	fmt.Println("Looking for daemons on the local network...")
+	daemons, err := p2p.DiscoverLocal(context.Background(), discoverWait)
+	if err != nil {
+		color.Red("Local discovery failed: %v", err)
+		return ""
+	}
+	if len(daemons) == 0 {
+		fmt.Println("No daemons found. Is one running on this network, with -mdns?")
+		return ""
+	}
+
+	names := make(map[peer.ID]string)
+	for name, addr := range cm.Config {
+		if info, err := parseDaemonAddr(addr); err == nil {
+			names[info.ID] = name
+		}
+	}
+	for i, d := range daemons {
+		addr := localAddr(d)
+		if name, ok := names[d.ID]; ok {
+			fmt.Printf("  %d) %s  %s  (linked as '%s')\n", i+1, d.ID, addr, name)
+		} else {
+			fmt.Printf("  %d) %s  %s\n", i+1, d.ID, addr)
+		}
+	}
+
+	reader := bufio.NewReader(os.Stdin)
+	fmt.Print("Connect to which one? (number, empty to cancel): ")
+	answer, _ := reader.ReadString('\n')
+	answer = strings.TrimSpace(answer)
+	if answer == "" {
+		return ""
+	}
+	n, err := strconv.Atoi(answer)
+	if err != nil || n < 1 || n > len(daemons) {
+		color.Red("Error: there is no daemon %s.", answer)
+		return ""
+	}
+	d := daemons[n-1]
+	if name, ok := names[d.ID]; ok {
+		return name
+	}
+
+	fmt.Print("Name to link it as: ")
+	name, _ := reader.ReadString('\n')
+	name = strings.TrimSpace(name)
+	if name == "" || name == "link" || name == "discover" {
+		color.Red("Error: invalid name. Aborting.")
+		return ""
+	}
+	addrs, err := peer.AddrInfoToP2pAddrs(&peer.AddrInfo{ID: d.ID, Addrs: []multiaddr.Multiaddr{localAddr(d)}})
+	if err != nil {
+		color.Red("Error: %v", err)
+		return ""
+	}
+	cm.AddDaemon(name, addrs[0].String())
+	if err := cm.Save(); err != nil {
+		color.Red("Failed to save config: %v", err)
+		return ""
+	}
+	color.Green("Linked '%s'. Next time, connect with '%s %s'.", name, clientCommand, name)
+	return name
+}
+
+// localAddr picks the address of a discovered daemon most likely to work on
+// the local network: a private IPv4 one if there is one.
+func localAddr(d peer.AddrInfo) multiaddr.Multiaddr {
+	best := d.Addrs[0]
+	for _, addr := range d.Addrs {
+		if manet.IsIPLoopback(addr) {
+			continue
+		}
+		if manet.IsPrivateAddr(addr) {
+			if _, err := addr.ValueForProtocol(multiaddr.P_IP4); err == nil {
+				return addr
+			}
+		}
+		if manet.IsIPLoopback(best) {
+			best = addr
+		}
+	}
+	return best
+}
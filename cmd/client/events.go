@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/libp2p/go-libp2p/core/network"
+
+	"github.com/hemantsingh443/p2p-git-remote/internal/protocol"
+)
+
+// handleWatch prints a repo's commits, branch changes and changed files as
+// the daemon publishes them, until Ctrl+C. With no repo, it watches every
+// linked one.
+func handleWatch(stream network.Stream, repoAlias string) {
+	reqPayload := protocol.SubscribeEventsRequestPayload{RepoPath: repoAlias, RepoEvents: true}
+	payloadBytes, _ := json.Marshal(reqPayload)
+	protocol.WriteMessage(stream, &protocol.Message{Type: protocol.TypeSubscribeEventsRequest, Payload: payloadBytes})
+
+	resp, err := protocol.ReadMessage(stream)
+	if err != nil {
+		color.Red("Error reading watch response: %v", err)
+		return
+	}
+	var respPayload protocol.SubscribeEventsResponsePayload
+	json.Unmarshal(resp.Payload, &respPayload)
+	if !respPayload.Success {
+		color.Red("Error from daemon: %s", respPayload.Error)
+		return
+	}
+
+	// Ctrl+C stops watching instead of quitting the client
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stream.Close()
+	}()
+	if repoAlias == "" {
+		fmt.Println("Watching every repo, press Ctrl+C to stop.")
+	} else {
+		fmt.Printf("Watching %s (%s), press Ctrl+C to stop.\n", repoAlias, respPayload.Topic)
+	}
+	for {
+		msg, err := protocol.ReadMessage(stream)
+		if err != nil {
+			if ctx.Err() == nil {
+				color.Red("Lost connection to the daemon: %v", err)
+			}
+			return
+		}
+		stamp := time.Now().Format("15:04:05")
+		switch msg.Type {
+		case protocol.TypeFileChangedEvent:
+			var event protocol.FileChangedEventPayload
+			json.Unmarshal(msg.Payload, &event)
+			fmt.Printf("%s [%s] changed: %s\n", stamp, event.RepoPath, strings.Join(event.Paths, ", "))
+		case protocol.TypeRepoEvent:
+			var event protocol.RepoEventPayload
+			json.Unmarshal(msg.Payload, &event)
+			fmt.Printf("%s [%s] %s\n", stamp, event.RepoPath, describeRepoEvent(event))
+		}
+	}
+}
+
+func describeRepoEvent(event protocol.RepoEventPayload) string {
+	switch event.Kind {
+	case protocol.RepoEventCommit:
+		return color.GreenString("%s", event.Branch) + fmt.Sprintf(" is now at %s %s (%s)", shortHash(event.Hash), event.Subject, event.Author)
+	case protocol.RepoEventBranchCreated:
+		return color.GreenString("new branch %s", event.Branch) + " at " + shortHash(event.Hash)
+	case protocol.RepoEventBranchDeleted:
+		return color.RedString("branch %s deleted", event.Branch)
+	case protocol.RepoEventCheckout:
+		return color.YellowString("checked out %s", event.Branch)
+	case protocol.RepoEventRenamed:
+		return color.YellowString("renamed from %s", event.OldAlias)
+	}
+	return event.Kind + " " + event.Branch
+}
+
+func shortHash(hash string) string {
+	if len(hash) > 7 {
+		return hash[:7]
+	}
+	return hash
+}
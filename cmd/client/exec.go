@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/libp2p/go-libp2p/core/network"
+
+	"github.com/hemantsingh443/p2p-git-remote/internal/protocol"
+)
+
+// Exit statuses of `exec`.
+const (
+	exitOK     = 0 // Every command succeeded
+	exitFailed = 1 // A command failed, or couldn't be run
+	exitUsage  = 2 // exec itself was misused
+)
+
+// execRun follows the command `exec` is running, to tell whether it
+// worked: it sent a request, and no response said it failed.
+type execRun struct {
+	sent   bool
+	failed bool
+}
+
+// execStream watches the messages a command sends and reads on a stream,
+// without the command knowing.
+type execStream struct {
+	network.Stream
+	run     *execRun
+	pending []byte // Start of a message not read in full yet
+}
+
+func (s *execStream) Write(p []byte) (int, error) {
+	s.run.sent = true
+	return s.Stream.Write(p)
+}
+
+func (s *execStream) Read(p []byte) (int, error) {
+	n, err := s.Stream.Read(p)
+	s.pending = append(s.pending, p[:n]...)
+	for {
+		i := bytes.IndexByte(s.pending, '\n')
+		if i < 0 {
+			break
+		}
+		s.check(s.pending[:i])
+		s.pending = s.pending[i+1:]
+	}
+	return n, err
+}
+
+// check marks the run failed if a message is a response saying so, or
+// refuses the request. Other messages, like progress or events, have no
+// success field.
+func (s *execStream) check(line []byte) {
+	var msg struct {
+		Type    string `json:"type"`
+		Payload struct {
+			Success *bool `json:"success"`
+		} `json:"payload"`
+	}
+	if json.Unmarshal(line, &msg) != nil {
+		return
+	}
+	if msg.Type == protocol.TypeAccessDenied || (msg.Payload.Success != nil && !*msg.Payload.Success) {
+		s.run.failed = true
+	}
+}
+
+// openStream opens a stream to the daemon for a command. Under `exec`, the
+// stream reports to the command's run.
+func (state *clientState) openStream(ctx context.Context) (network.Stream, error) {
+	stream, err := state.conn.NewStream(ctx)
+	if err != nil || state.exec == nil {
+		return stream, err
+	}
+	return &execStream{Stream: stream, run: state.exec}, nil
+}
+
+// readExecCommands reads the commands to run from r, one per line, skipping
+// blank lines and # comments.
+func readExecCommands(r io.Reader) ([]string, error) {
+	var commands []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			commands = append(commands, line)
+		}
+	}
+	return commands, scanner.Err()
+}
+
+// runExec runs REPL commands one after the other, stopping at the first
+// that fails, and returns the status to exit with. Commands come from the
+// arguments, or from stdin without any (or with "-"), in which case they are
+// all read first: questions like "are you sure?" get no answer, and are
+// taken as a no.
+func runExec(state *clientState, repo string, args []string) int {
+	commands := args
+	if len(args) == 0 || (len(args) == 1 && args[0] == "-") {
+		var err error
+		if commands, err = readExecCommands(os.Stdin); err != nil {
+			color.Red("Error reading commands: %v", err)
+			return exitUsage
+		}
+		os.Stdin.Close()
+	}
+	if len(commands) == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: ./client <daemon-name> exec [--repo <alias>] [--json] <command>... (or commands on stdin, one per line)")
+		return exitUsage
+	}
+	if repo != "" {
+		commands = append([]string{"use " + repo}, commands...)
+	}
+
+	run := executor(state)
+	for _, command := range commands {
+		state.exec = &execRun{}
+		name, _, _ := strings.Cut(command, " ")
+		run(command)
+		if state.exec.failed || (!state.exec.sent && !localCommands[name]) {
+			color.New(color.FgRed).Fprintf(os.Stderr, "Command failed: %s\n", command)
+			return exitFailed
+		}
+	}
+	return exitOK
+}
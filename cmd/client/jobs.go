@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/libp2p/go-libp2p/core/network"
+
+	"github.com/hemantsingh443/p2p-git-remote/internal/protocol"
+)
+
+// handleJobs lists the daemon's queued and running jobs, or with all its
+// recent ones too.
+func handleJobs(stream network.Stream, all bool) {
+	payloadBytes, _ := json.Marshal(protocol.JobsListRequestPayload{All: all})
+	req := &protocol.Message{Type: protocol.TypeJobsListRequest, Payload: payloadBytes}
+	protocol.WriteMessage(stream, req)
+
+	resp, err := protocol.ReadMessage(stream)
+	if err != nil {
+		color.Red("Error reading jobs response: %v", err)
+		return
+	}
+	var respPayload protocol.JobsListResponsePayload
+	json.Unmarshal(resp.Payload, &respPayload)
+	if printJSON(respPayload) {
+		return
+	}
+	if !respPayload.Success {
+		color.Red("Error from daemon: %s", respPayload.Error)
+		return
+	}
+	if len(respPayload.Jobs) == 0 {
+		if all {
+			fmt.Println("No jobs.")
+		} else {
+			fmt.Println("No jobs running. 'jobs --all' shows the recent ones.")
+		}
+		return
+	}
+	for _, j := range respPayload.Jobs {
+		target := j.RepoPath
+		if j.Branch != "" {
+			target += " @ " + j.Branch
+		}
+		fmt.Printf("%s  %-6s %s  %s  %s\n", j.ID, j.Kind, jobState(j.State), target, color.HiBlackString(jobTime(j)))
+	}
+}
+
+// handleJob shows a job's state and output, or follows it until it ends.
+func handleJob(stream network.Stream, id string, follow bool) {
+	payloadBytes, _ := json.Marshal(protocol.JobStatusRequestPayload{ID: id, Follow: follow})
+	req := &protocol.Message{Type: protocol.TypeJobStatusRequest, Payload: payloadBytes}
+	protocol.WriteMessage(stream, req)
+
+	if follow {
+		// Ctrl+C stops following, not the job
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+		go func() {
+			<-ctx.Done()
+			stream.Close()
+		}()
+		fmt.Printf("Following job %s, press Ctrl+C to stop (the job goes on).\n", id)
+	}
+	var printed bool
+	for {
+		resp, err := protocol.ReadMessage(stream)
+		if err != nil {
+			if follow {
+				fmt.Println()
+			} else {
+				color.Red("Error reading job response: %v", err)
+			}
+			return
+		}
+		if resp.Type == protocol.TypeJobProgress {
+			var progress protocol.JobProgressPayload
+			json.Unmarshal(resp.Payload, &progress)
+			fmt.Print(progress.Output)
+			printed = true
+			continue
+		}
+
+		var respPayload protocol.JobStatusResponsePayload
+		json.Unmarshal(resp.Payload, &respPayload)
+		if printJSON(respPayload) {
+			return
+		}
+		if !respPayload.Success {
+			color.Red("Error from daemon: %s", respPayload.Error)
+			return
+		}
+		j := respPayload.Job
+		if printed {
+			fmt.Println()
+		}
+		fmt.Printf("Job %s: %s of %s", j.ID, j.Kind, j.RepoPath)
+		if j.Branch != "" {
+			fmt.Printf(" @ %s", j.Branch)
+		}
+		fmt.Printf(", %s (%s)\n", jobState(j.State), jobTime(j))
+		if j.Output != "" {
+			fmt.Print(j.Output)
+			if j.Output[len(j.Output)-1] != '\n' {
+				fmt.Println()
+			}
+		}
+		if j.Error != "" {
+			color.Red("Error: %s", j.Error)
+		}
+		return
+	}
+}
+
+// handleCancelJob cancels a job of this client's, or any job for an admin.
+func handleCancelJob(stream network.Stream, id string) {
+	payloadBytes, _ := json.Marshal(protocol.JobCancelRequestPayload{ID: id})
+	req := &protocol.Message{Type: protocol.TypeJobCancelRequest, Payload: payloadBytes}
+	protocol.WriteMessage(stream, req)
+
+	resp, err := protocol.ReadMessage(stream)
+	if err != nil {
+		color.Red("Error reading cancel response: %v", err)
+		return
+	}
+	var respPayload protocol.JobCancelResponsePayload
+	json.Unmarshal(resp.Payload, &respPayload)
+	if !respPayload.Success {
+		color.Red("Error from daemon: %s", respPayload.Error)
+		return
+	}
+	color.Yellow("Job %s cancelled.", id)
+}
+
+func jobState(state string) string {
+	switch state {
+	case protocol.JobDone:
+		return color.GreenString("%-11s", state)
+	case protocol.JobFailed, protocol.JobInterrupted:
+		return color.RedString("%-11s", state)
+	case protocol.JobRunning:
+		return color.CyanString("%-11s", state)
+	}
+	return color.YellowString("%-11s", state)
+}
+
+// jobTime says when a job started, and how long it ran or has been running.
+func jobTime(j protocol.JobInfo) string {
+	started := j.Created.Local().Format("2006-01-02 15:04:05")
+	if j.Finished.IsZero() {
+		return fmt.Sprintf("started %s, running for %s", started, time.Since(j.Created).Round(time.Second))
+	}
+	return fmt.Sprintf("started %s, took %s", started, j.Finished.Sub(j.Created).Round(time.Second))
+}
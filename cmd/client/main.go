@@ -2,25 +2,39 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"context"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"os"
 	"os/exec"
-	"os/user"
+	"os/signal"
+	"path"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/c-bata/go-prompt"
 	"github.com/fatih/color"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/crypto"
 	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/metrics"
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
-	"github.com/multiformats/go-multiaddr"
+	"github.com/libp2p/go-libp2p/core/pnet"
 
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/hemantsingh443/p2p-git-remote/internal/git"
 	p2p "github.com/hemantsingh443/p2p-git-remote/internal/p2p"
 	"github.com/hemantsingh443/p2p-git-remote/internal/protocol"
 	"github.com/hemantsingh443/p2p-git-remote/internal/store"
@@ -30,11 +44,20 @@ import (
 // clientState holds the application's current state.
 type clientState struct {
 	p2pHost       host.Host
+	daemonName    string // As linked in the config
 	daemonInfo    peer.AddrInfo
+	config        *ConfigManager
+	resolve       p2p.Resolver           // Looks daemons up on the DHT; nil on a private network
+	contexts      map[string]repoContext // Repo and branch last used on other daemons, by name
 	trustStore    *store.TrustStore
+	session       *p2p.SessionKeeper
+	conn          *p2p.ConnSupervisor // Opens streams to the daemon, reconnecting if needed
+	bandwidth     *metrics.BandwidthCounter
 	currentRepo   string
 	currentBranch string
+	statusBadge   string // e.g. "feature-x ↑2 ↓1 ✚3", refreshed after each command
 	livePrefix    string
+	exec          *execRun // The command 'exec' runs, nil in the REPL
 }
 
 type ClientConfig map[string]string
@@ -44,19 +67,14 @@ type ConfigManager struct {
 	Config ClientConfig
 }
 
-func NewConfigManager() (*ConfigManager, error) {
-	home, err := user.Current()
+// NewConfigManager loads the daemons linked in a profile, "" being the
+// default one.
+func NewConfigManager(profile string) (*ConfigManager, error) {
+	dir, err := profileDir(profile)
 	if err != nil {
-		return nil, fmt.Errorf("could not find home directory: %w", err)
+		return nil, err
 	}
-	configPath := filepath.Join(home.HomeDir, ".p2p-git", "config.json")
-
-	// Ensure the directory exists
-	if err := os.MkdirAll(filepath.Dir(configPath), 0755); err != nil {
-		return nil, fmt.Errorf("could not create config directory: %w", err)
-	}
-
-	cm := &ConfigManager{Path: configPath, Config: make(ClientConfig)}
+	cm := &ConfigManager{Path: filepath.Join(dir, configFile), Config: make(ClientConfig)}
 	return cm, cm.Load()
 }
 
@@ -84,37 +102,58 @@ func (cm *ConfigManager) AddDaemon(name, addr string) {
 	cm.Config[name] = addr
 }
 
+// swarmKeyPath returns the swarm key to join a private network with: the
+// file in $P2P_GIT_SWARM_KEY, or swarm.key next to the config file. It
+// returns "" if there is none.
+func swarmKeyPath(cm *ConfigManager) string {
+	if path := os.Getenv("P2P_GIT_SWARM_KEY"); path != "" {
+		return path
+	}
+	path := filepath.Join(filepath.Dir(cm.Path), "swarm.key")
+	if _, err := os.Stat(path); err != nil {
+		return ""
+	}
+	return path
+}
+
 func main() {
+	// Each profile has its own identity and linked daemons
+	profile, args := takeProfile(os.Args[1:])
+	os.Args = append(os.Args[:1], args...)
 	if len(os.Args) < 2 {
 		// Updated usage message
-		fmt.Println("Usage: ./client <daemon-name> [tui] | link <new-daemon-name>")
+		fmt.Println("Usage: ./client [--profile <name>] <daemon-name> [tui | exec [--repo <alias>] [--json] <command>...] | link <new-daemon-name> | discover [tui] | " + daemonsUsage)
 		os.Exit(1)
 	}
 
 	command := os.Args[1]
 
-	configManager, err := NewConfigManager()
+	configManager, err := NewConfigManager(profile)
 	if err != nil {
 		log.Fatal(err)
 	}
+	if profile != "" {
+		clientCommand += " --profile " + profile
+	}
 
 	// --- MODE 1: Linking a new daemon ---
 	if command == "link" {
 		if len(os.Args) < 3 {
-			fmt.Println("Usage: ./client link <new-daemon-name>")
+			fmt.Printf("Usage: %s link <new-daemon-name>\n", clientCommand)
 			os.Exit(1)
 		}
 		daemonName := os.Args[2]
 
 		// Prompt the user for the multiaddress
-		fmt.Printf("Please scan or paste the multiaddress for '%s':\n> ", daemonName)
+		fmt.Printf("Please scan or paste the multiaddress (or just the peer ID) for '%s':\n> ", daemonName)
 		reader := bufio.NewReader(os.Stdin)
 		daemonAddr, _ := reader.ReadString('\n')
 		daemonAddr = strings.TrimSpace(daemonAddr)
 
-		// Validate the address before saving
-		if _, err := multiaddr.NewMultiaddr(daemonAddr); err != nil {
-			color.Red("Error: Invalid multiaddress provided. Aborting.")
+		// Validate the address before saving. A peer ID alone is looked up
+		// on the DHT when connecting, so the daemon's address may change.
+		if _, err := parseDaemonAddr(daemonAddr); err != nil {
+			color.Red("Error: Invalid multiaddress or peer ID provided. Aborting.")
 			os.Exit(1)
 		}
 
@@ -123,70 +162,136 @@ func main() {
 			color.Red("Failed to save config: %v", err)
 			os.Exit(1)
 		}
-		color.Green("Successfully linked '%s'. You can now connect using './client %s'", daemonName, daemonName)
+		color.Green("Successfully linked '%s'. You can now connect using '%s %s'", daemonName, clientCommand, daemonName)
 		return // Exit after linking
 	}
 
+	// --- MODE 1a: Managing linked daemons ---
+	if command == "daemons" {
+		os.Exit(runDaemons(configManager, os.Args[2:]))
+	}
+
+	// --- MODE 1b: Picking a daemon on the local network ---
+	if command == "discover" {
+		if command = discoverDaemon(configManager); command == "" {
+			return
+		}
+	}
+
 	// --- NEW LOGIC: Check for 'tui' command ---
 	isTuiMode := false
 	daemonName := command
 	if len(os.Args) > 2 && os.Args[2] == "tui" {
 		isTuiMode = true
 	}
+	// exec runs commands given on the command line or stdin, then exits
+	isExecMode := false
+	var execRepo string
+	var execArgs []string
+	var execJSON bool
+	if len(os.Args) > 2 && os.Args[2] == "exec" {
+		isExecMode = true
+		execArgs = os.Args[3:]
+	options:
+		for len(execArgs) > 0 {
+			switch {
+			case len(execArgs) > 1 && (execArgs[0] == "--repo" || execArgs[0] == "-r"):
+				execRepo, execArgs = execArgs[1], execArgs[2:]
+			case strings.HasPrefix(execArgs[0], "--repo="):
+				execRepo, execArgs = strings.TrimPrefix(execArgs[0], "--repo="), execArgs[1:]
+			case execArgs[0] == "--json":
+				execJSON, execArgs = true, execArgs[1:]
+			default:
+				break options
+			}
+		}
+		if execRepo == "" {
+			execRepo = os.Getenv("P2P_GIT_REPO")
+		}
+		// Every command prints JSON, and any other text goes to stderr
+		if execJSON {
+			startJSONOutput()
+		}
+	}
 
 	// --- MODE 2: Connecting to an existing daemon ---
-	daemonAddr, ok := configManager.Config[daemonName]
-	if !ok {
+	if _, ok := configManager.Config[daemonName]; !ok {
 		color.Red("Error: Daemon name '%s' not found in your config file.", daemonName)
-		fmt.Println("Use './client link <name>' to add it.")
+		fmt.Printf("Use '%s link <name>' to add it.\n", clientCommand)
 		os.Exit(1)
 	}
 
 	ctx := context.Background()
 
-	// Load or generate persistent identity
-	privKey, err := p2p.LoadOrGeneratePrivateKey("client_identity.key")
+	// Load or generate persistent identity, in the profile's directory
+	dir := filepath.Dir(configManager.Path)
+	if profile == "" {
+		adoptWorkingDirFiles(dir)
+	}
+	// $P2P_GIT_KEYCHAIN=1 keeps it in the OS keychain instead of a file
+	loadKey := p2p.LoadOrGeneratePrivateKey
+	if os.Getenv("P2P_GIT_KEYCHAIN") == "1" {
+		loadKey = func(path string) (crypto.PrivKey, error) {
+			return p2p.LoadOrGenerateKeychainKeyAs(path, keychainName(profile))
+		}
+	}
+	privKey, err := loadKey(filepath.Join(dir, identityKeyFile))
 	if err != nil {
 		log.Fatalf("Failed to get private key: %v", err)
 	}
 
-	// Create libp2p host
-	h, err := p2p.CreateHost(ctx, privKey, 0) // Port 0 means random port
-	if err != nil {
-		log.Fatalf("Failed to create host: %v", err)
+	// Join the daemon's private network if we have its swarm key
+	var psk pnet.PSK
+	if path := swarmKeyPath(configManager); path != "" {
+		if psk, err = p2p.LoadSwarmKey(path); err != nil {
+			log.Fatalf("Failed to load swarm key: %v", err)
+		}
 	}
-	defer h.Close()
 
-	// Parse the daemon's multiaddress
-	addrInfo, err := peer.AddrInfoFromString(daemonAddr)
+	// Create libp2p host. Listening on QUIC too helps hole punching to
+	// daemons that do, but private networks only run over TCP.
+	var transports []string
+	if psk == nil {
+		transports = []string{p2p.TransportQUIC}
+	}
+	listenOn, err := p2p.ListenTransports(0, transports)
 	if err != nil {
-		log.Fatalf("Failed to parse daemon address: %v", err)
+		log.Fatal(err)
 	}
-
-	// Connect to the daemon
-	if err := h.Connect(ctx, *addrInfo); err != nil {
-		log.Fatalf("Failed to connect to daemon: %v", err)
+	bandwidth := metrics.NewBandwidthCounter()
+	h, err := p2p.CreateHost(ctx, privKey, 0, p2p.PrivateNetwork(psk), listenOn, libp2p.BandwidthReporter(bandwidth)) // Port 0 means random port
+	if err != nil {
+		log.Fatalf("Failed to create host: %v", err)
 	}
+	defer h.Close()
 
 	// Initialize TrustStore
-	trustStore, err := store.NewTrustStore("trusted_daemons.json")
+	trustStore, err := store.NewTrustStore(filepath.Join(dir, trustedDaemonsFile))
 	if err != nil {
 		log.Fatalf("Failed to initialize trust store: %v", err)
 	}
 
-	if !trustStore.IsTrusted(addrInfo.ID) {
-		performHandshake(ctx, h, *addrInfo, trustStore)
-	} else {
-		fmt.Println("Daemon is already trusted.")
+	// Connect to the daemon, looking it up on the DHT if need be. Private
+	// networks can't reach the public DHT.
+	var resolve p2p.Resolver
+	if psk == nil {
+		resolve = p2p.DHTResolver(h)
+	}
+	dc, err := dialDaemon(ctx, h, configManager, trustStore, resolve, daemonName, isExecMode)
+	if err != nil {
+		log.Fatalf("Failed to connect to '%s': %v", daemonName, err)
 	}
+	addrInfo, conn, session := &dc.info, dc.conn, dc.session
 
 	// --- The final part of main is now a switch ---
 	// We pass the core client state to both modes
 	appState := &tui.AppState{
-		P2pHost:       h,
-		DaemonInfo:    *addrInfo,
-		CurrentRepo:   "my-project", // You might want to make this selectable
-		CurrentBranch: "master",
+		P2pHost:     h,
+		DaemonInfo:  *addrInfo,
+		CurrentRepo: "my-project", // You might want to make this selectable
+		Session:     session,
+		Conn:        conn,
+		// CurrentBranch is filled in from the daemon once the branch list loads
 	}
 
 	if isTuiMode {
@@ -197,13 +302,28 @@ func main() {
 		}
 	} else {
 		// --- LAUNCH REPL MODE (your existing code) ---
+		protocol.OnApprovalPending = printApprovalPending
+		conn.Notify = func(msg string) { color.Yellow("\n%s", msg) }
 		state := &clientState{
 			p2pHost:       h,
+			daemonName:    daemonName,
 			daemonInfo:    *addrInfo,
+			config:        configManager,
+			resolve:       resolve,
+			contexts:      make(map[string]repoContext),
 			trustStore:    trustStore,
+			session:       session,
+			conn:          conn,
+			bandwidth:     bandwidth,
 			currentRepo:   "",
-			currentBranch: "master", // Default
-			livePrefix:    "p2p-git(no repo)> ",
+			currentBranch: "", // Set from the daemon by `use`
+			livePrefix:    fmt.Sprintf("p2p-git@%s(no repo)> ", daemonName),
+		}
+		if isExecMode {
+			code := runExec(state, execRepo, execArgs)
+			state.conn.Notify = nil // Closing the host isn't losing the daemon
+			h.Close()
+			os.Exit(code)
 		}
 		p := prompt.New(
 			executor(state),
@@ -216,35 +336,53 @@ func main() {
 	}
 }
 
+// localCommands are the commands that run without the daemon.
+var localCommands = map[string]bool{"exit": true, "quit": true, "help": true, "net-stats": true, "connect": true}
+
 // executor is the heart of the REPL. It parses and executes commands.
 func executor(state *clientState) func(s string) {
 	return func(s string) {
 		s = strings.TrimSpace(s)
-		parts := strings.Fields(s)
+		parts := splitArgs(s)
 		if len(parts) == 0 {
 			return
 		}
 
 		command := parts[0]
-		args := parts[1:]
+		asJSON, args, err := takeOutputFormat(parts[1:])
+		if err != nil {
+			color.Red("Error: %v", err)
+			return
+		}
+		if asJSON && !jsonCommands[command] {
+			color.Red("Error: '%s' has no JSON output.", command)
+			return
+		}
+		if asJSON {
+			defer startJSONOutput()()
+		}
 
 		// --- FIX: Only create a stream for commands that need it ---
-		needsStream := true
-		switch command {
-		case "exit", "quit", "help":
-			needsStream = false
-		}
+		needsStream := !localCommands[command]
 
 		var stream network.Stream
-		var err error
 		if needsStream {
 			ctx := context.Background()
-			stream, err = state.p2pHost.NewStream(ctx, state.daemonInfo.ID, protocol.ProtocolID)
+			// Renew the session first if it has expired, so the command goes through
+			if err := state.session.Ensure(ctx); err != nil {
+				color.Red("Error: could not renew the session: %v", err)
+				return
+			}
+			stream, err = state.openStream(ctx)
 			if err != nil {
 				fmt.Printf("Error: could not create stream: %v\n", err)
 				return
 			}
 			defer stream.Close()
+			// Runs before the stream is closed, once the command has finished
+			if state.exec == nil {
+				defer refreshStatusBadge(state)
+			}
 		}
 
 		// --- Command routing ---
@@ -254,6 +392,12 @@ func executor(state *clientState) func(s string) {
 			os.Exit(0)
 		case "help":
 			printHelp()
+		case "connect":
+			if len(args) != 1 {
+				fmt.Println("Usage: connect <daemon-name>")
+				return
+			}
+			handleConnect(state, args[0])
 		case "use":
 			if len(args) < 1 {
 				fmt.Println("Usage: use <repo-alias>")
@@ -269,7 +413,33 @@ func executor(state *clientState) func(s string) {
 				fmt.Println("No repository selected. Use 'use <repo-alias>' first.")
 				return
 			}
-			handleListFiles(stream, state.currentRepo)
+			flags, rest := splitFlags(args)
+			_, long := flags["long"]
+			_, all := flags["all"]
+			// Short flags: -l, -a, or both as -la
+			for len(rest) > 0 && len(rest[0]) > 1 && rest[0][0] == '-' && strings.Trim(rest[0][1:], "la") == "" {
+				long = long || strings.Contains(rest[0], "l")
+				all = all || strings.Contains(rest[0], "a")
+				rest = rest[1:]
+			}
+			if _, recursive := flags["recursive"]; recursive {
+				prefix := ""
+				if len(rest) > 0 {
+					prefix = rest[0]
+				}
+				handleListFiles(stream, state.currentRepo, prefix, nil, all)
+				return
+			}
+			// Glob patterns are matched on the daemon against the whole tree
+			if len(rest) > 0 && strings.ContainsAny(strings.Join(rest, " "), "*?[") {
+				handleListFiles(stream, state.currentRepo, "", rest, all)
+				return
+			}
+			dir := ""
+			if len(rest) > 0 {
+				dir = rest[0]
+			}
+			handleListDir(stream, state.currentRepo, dir, long, all)
 		case "branch":
 			if state.currentRepo == "" {
 				fmt.Println("No repository selected.")
@@ -285,12 +455,72 @@ func executor(state *clientState) func(s string) {
 				fmt.Println("No repository selected.")
 				return
 			}
-			if len(args) < 1 {
-				fmt.Println("Usage: commit <message>")
+			flags, rest := splitFlags(args)
+			_, amend := flags["amend"]
+			_, force := flags["force"]
+			_, signOff := flags["signoff"]
+			_, sign := flags["sign"]
+			_, setUpstream := flags["set-upstream"]
+			_, stagedOnly := flags["staged"]
+			_, allowSecrets := flags["allow-secrets"]
+			_, background := flags["background"]
+			if _, dryRun := flags["dry-run"]; dryRun {
+				handleCommit(stream, protocol.GitCommitRequestPayload{RepoPath: state.currentRepo, DryRun: true, StagedOnly: stagedOnly})
 				return
 			}
-			msg := strings.Join(args, " ")
-			handleCommit(stream, state.currentRepo, state.currentBranch, msg)
+			if len(rest) < 1 && !amend {
+				// No message: compose one in $EDITOR, starting from the repo's template
+				message, err := composeCommitMessage(stream, state.currentRepo)
+				if err != nil {
+					color.Red("Error: %v", err)
+					return
+				}
+				if message == "" {
+					fmt.Println("Usage: commit [--amend [--force]] [--author=\"Name <email>\"] [--signoff] [--sign] [--set-upstream] [--staged] [--remote=<name>] [--allow-secrets] [--background] <message>")
+					return
+				}
+				rest = []string{message}
+
+				// The template request used up this command's stream
+				stream, err = state.openStream(context.Background())
+				if err != nil {
+					fmt.Printf("Error: could not create stream: %v\n", err)
+					return
+				}
+				defer stream.Close()
+			}
+			reqPayload := protocol.GitCommitRequestPayload{
+				RepoPath:    state.currentRepo,
+				Message:     strings.Join(rest, " "),
+				Branch:      state.currentBranch,
+				Amend:       amend,
+				Force:       force,
+				SignOff:     signOff,
+				Sign:        sign,
+				SetUpstream: setUpstream,
+				StagedOnly:  stagedOnly,
+				Remote:      flags["remote"],
+
+				AllowSecrets: allowSecrets,
+				Background:   background,
+			}
+			if author, ok := flags["author"]; ok {
+				name, email, err := parseAuthor(author)
+				if err != nil {
+					color.Red("Error: %v", err)
+					return
+				}
+				reqPayload.AuthorName, reqPayload.AuthorEmail = name, email
+			}
+			if amend && force {
+				color.Red("WARNING: If the commit was pushed, this rewrites it and force-pushes the branch.")
+				if !confirmRepoName(state.currentRepo) {
+					fmt.Println("Commit aborted.")
+					return
+				}
+				reqPayload.Confirm = state.currentRepo
+			}
+			handleCommit(stream, reqPayload)
 		case "branches":
 			if state.currentRepo == "" {
 				fmt.Println("No repository selected.")
@@ -313,6 +543,100 @@ func executor(state *clientState) func(s string) {
 				return
 			}
 			handleLinkRepo(stream, args[0], args[1])
+		case "rename-repo":
+			if len(args) != 2 {
+				fmt.Println("Usage: rename-repo <alias> <new-alias>")
+				return
+			}
+			handleRenameRepo(stream, state, args[0], args[1])
+		case "peers":
+			handleListPeers(stream)
+		case "revoke":
+			if len(args) != 1 {
+				fmt.Println("Usage: revoke <peer-id>")
+				return
+			}
+			handleRevokePeer(stream, args[0])
+		case "pairing":
+			minutes := 10
+			if len(args) == 1 {
+				minutes, err = strconv.Atoi(args[0])
+			}
+			if len(args) > 1 || err != nil || minutes < 0 {
+				fmt.Println("Usage: pairing [minutes]  (default 10, 0 closes the window)")
+				return
+			}
+			handlePairingWindow(stream, minutes)
+		case "block":
+			if len(args) > 1 {
+				fmt.Println("Usage: block [<peer-id|ip|cidr>]")
+				return
+			}
+			entry := ""
+			if len(args) == 1 {
+				entry = args[0]
+			}
+			handleBlock(stream, entry, false)
+		case "unblock":
+			if len(args) != 1 {
+				fmt.Println("Usage: unblock <peer-id|ip|cidr>")
+				return
+			}
+			handleBlock(stream, args[0], true)
+		case "approvals":
+			handleApprovals(stream)
+		case "net-status":
+			handleNetStatus(stream)
+		case "status-daemon":
+			handleDaemonStatus(stream)
+		case "jobs":
+			flags, _ := splitFlags(args)
+			_, all := flags["all"]
+			handleJobs(stream, all)
+		case "job":
+			flags, rest := splitFlags(args)
+			switch {
+			case len(rest) == 2 && rest[0] == "cancel":
+				handleCancelJob(stream, rest[1])
+			case len(rest) == 1:
+				_, follow := flags["follow"]
+				handleJob(stream, rest[0], follow)
+			default:
+				fmt.Println("Usage: job <id> [--follow] | job cancel <id>")
+			}
+		case "net-stats":
+			handleNetStats(state)
+		case "ping":
+			count := 4
+			if len(args) == 1 {
+				count, err = strconv.Atoi(args[0])
+			}
+			if len(args) > 1 || err != nil || count < 1 {
+				fmt.Println("Usage: ping [count]")
+				return
+			}
+			handlePing(stream, count)
+		case "readonly":
+			var enabled *bool
+			if len(args) == 1 && (args[0] == "on" || args[0] == "off") {
+				on := args[0] == "on"
+				enabled = &on
+			} else if len(args) > 0 {
+				fmt.Println("Usage: readonly [on|off]")
+				return
+			}
+			handleReadOnly(stream, enabled)
+		case "audit":
+			flags, rest := splitFlags(args)
+			reqPayload, exportFile, err := parseAuditFlags(flags)
+			if err != nil || len(rest) > 0 {
+				if err != nil {
+					color.Red("%v", err)
+				}
+				fmt.Println("Usage: audit [--peer=<id>] [--type=<request>] [--repo=<alias>] [--since=24h] [--limit=N] [--export=<file>]")
+				return
+			}
+			handleAuditLog(stream, reqPayload, exportFile)
 		case "rename":
 			if state.currentRepo == "" {
 				fmt.Println("No repository selected.")
@@ -334,52 +658,367 @@ func executor(state *clientState) func(s string) {
 				fmt.Println("No repository selected.")
 				return
 			}
-			handleGitLog(stream, state.currentRepo)
+			flags, _ := splitFlags(args)
+			_, showSignatures := flags["signatures"]
+			handleGitLog(stream, state.currentRepo, showSignatures)
+		case "history":
+			if state.currentRepo == "" {
+				fmt.Println("No repository selected.")
+				return
+			}
+			if len(args) != 1 {
+				fmt.Println("Usage: history <file>")
+				return
+			}
+			handleFileLog(stream, state.currentRepo, args[0])
 		case "diff":
 			if state.currentRepo == "" {
 				fmt.Println("No repository selected.")
 				return
 			}
-			filePath := ""
-			if len(args) > 0 {
-				filePath = args[0]
+			flags, rest := splitFlags(args)
+			reqPayload := protocol.GitDiffRequestPayload{RepoPath: state.currentRepo}
+			_, reqPayload.Stat = flags["stat"]
+			_, reqPayload.WordDiff = flags["word-diff"]
+			_, reqPayload.IgnoreAllSpace = flags["ignore-all-space"]
+			if value, ok := flags["context"]; ok {
+				n, err := strconv.Atoi(value)
+				if err != nil || n < 0 {
+					fmt.Println("--context must be a non-negative number, e.g. 'diff --context=1'")
+					return
+				}
+				reqPayload.ContextLines = n
+				if n == 0 {
+					reqPayload.ContextLines = -1 // 0 means "default" on the wire
+				}
+			}
+			for _, arg := range rest {
+				if arg == "-w" {
+					reqPayload.IgnoreAllSpace = true
+				} else if reqPayload.FilePath == "" {
+					reqPayload.FilePath = arg
+				}
 			}
-			handleGitDiff(stream, state.currentRepo, filePath)
+			handleGitDiff(stream, reqPayload)
 		case "stash":
 			if state.currentRepo == "" {
 				fmt.Println("No repository selected.")
 				return
 			}
-			handleGitStashSave(stream, state.currentRepo)
+			handleStashCommand(stream, state.currentRepo, args)
 		case "stash-pop":
 			if state.currentRepo == "" {
 				fmt.Println("No repository selected.")
 				return
 			}
-			handleGitStashPop(stream, state.currentRepo)
+			handleGitStashPop(stream, state.currentRepo, 0)
 		case "reset":
 			if state.currentRepo == "" {
 				fmt.Println("No repository selected.")
 				return
 			}
 			handleGitReset(stream, state.currentRepo)
-
-		// --- Commands that need context but not a direct stream ---
-		case "cat":
+		case "new":
+			if state.currentRepo == "" {
+				fmt.Println("No repository selected.")
+				return
+			}
+			if len(args) != 1 {
+				fmt.Println("Usage: new <path>")
+				return
+			}
+			handleCreateFile(stream, state.currentRepo, args[0])
+		case "mkdir":
+			if state.currentRepo == "" {
+				fmt.Println("No repository selected.")
+				return
+			}
+			if len(args) != 1 {
+				fmt.Println("Usage: mkdir <path>")
+				return
+			}
+			handleMkdir(stream, state.currentRepo, args[0])
+		case "rm":
+			if state.currentRepo == "" {
+				fmt.Println("No repository selected.")
+				return
+			}
+			flags, rest := splitFlags(args)
+			_, force := flags["force"]
+			if len(rest) != 1 {
+				fmt.Println("Usage: rm [--force] <path>")
+				return
+			}
+			handleDeletePath(stream, state.currentRepo, rest[0], force)
+		case "discard":
+			if state.currentRepo == "" {
+				fmt.Println("No repository selected.")
+				return
+			}
+			if len(args) != 1 {
+				fmt.Println("Usage: discard <file>")
+				return
+			}
+			handleCheckoutFile(stream, state.currentRepo, args[0])
+		case "config":
+			if state.currentRepo == "" {
+				fmt.Println("No repository selected.")
+				return
+			}
+			flags, rest := splitFlags(args)
+			_, unset := flags["unset"]
+			switch {
+			case unset && len(rest) == 1:
+				handleGitConfigSet(stream, protocol.GitConfigSetRequestPayload{RepoPath: state.currentRepo, Key: rest[0], Unset: true})
+			case unset:
+				fmt.Println("Usage: config --unset <key>")
+			case len(rest) <= 1:
+				key := ""
+				if len(rest) == 1 {
+					key = rest[0]
+				}
+				handleGitConfigGet(stream, state.currentRepo, key)
+			default:
+				handleGitConfigSet(stream, protocol.GitConfigSetRequestPayload{RepoPath: state.currentRepo, Key: rest[0], Value: strings.Join(rest[1:], " ")})
+			}
+		case "ignore":
+			if state.currentRepo == "" {
+				fmt.Println("No repository selected.")
+				return
+			}
+			flags, rest := splitFlags(args)
+			_, untrack := flags["untrack"]
+			if len(rest) != 1 {
+				fmt.Println("Usage: ignore [--untrack] <pattern>")
+				return
+			}
+			handleIgnore(stream, state.currentRepo, rest[0], untrack)
+		case "chmod":
+			if state.currentRepo == "" {
+				fmt.Println("No repository selected.")
+				return
+			}
+			if len(args) != 2 {
+				fmt.Println("Usage: chmod +x|-x|<octal-mode> <file>")
+				return
+			}
+			handleChmod(stream, state.currentRepo, args[1], args[0])
+		case "tail":
+			if state.currentRepo == "" {
+				fmt.Println("No repository selected.")
+				return
+			}
+			lines, file := 10, ""
+			for i := 0; i < len(args); i++ {
+				switch {
+				case (args[i] == "-n" || args[i] == "--lines") && i+1 < len(args):
+					i++
+					lines, err = strconv.Atoi(args[i])
+				case strings.HasPrefix(args[i], "--lines="):
+					lines, err = strconv.Atoi(strings.TrimPrefix(args[i], "--lines="))
+				case file == "":
+					file = args[i]
+				default:
+					err = fmt.Errorf("unexpected argument %s", args[i])
+				}
+			}
+			if file == "" || err != nil || lines < 0 {
+				fmt.Println("Usage: tail [-n <lines>] <file>")
+				return
+			}
+			handleTail(stream, state.currentRepo, file, lines)
+		case "watch":
+			handleWatch(stream, state.currentRepo)
+		case "batch":
+			if state.currentRepo == "" {
+				fmt.Println("No repository selected.")
+				return
+			}
+			if len(args) != 1 {
+				fmt.Println("Usage: batch <batch-file>")
+				return
+			}
+			handleBatch(stream, state.currentRepo, args[0])
+		case "grep":
+			if state.currentRepo == "" {
+				fmt.Println("No repository selected.")
+				return
+			}
+			flags, rest := splitFlags(args)
+			_, ignoreCase := flags["ignore-case"]
+			if len(rest) > 0 && rest[0] == "-i" {
+				ignoreCase, rest = true, rest[1:]
+			}
+			if len(rest) < 1 {
+				fmt.Println("Usage: grep [-i] <pattern> [path...]")
+				return
+			}
+			handleGrep(stream, state.currentRepo, rest[0], rest[1:], ignoreCase)
+		case "unignore":
+			if state.currentRepo == "" {
+				fmt.Println("No repository selected.")
+				return
+			}
+			if len(args) != 1 {
+				fmt.Println("Usage: unignore <pattern>")
+				return
+			}
+			handleUnignore(stream, state.currentRepo, args[0])
+		case "remotes":
+			if state.currentRepo == "" {
+				fmt.Println("No repository selected.")
+				return
+			}
+			handleListRemotes(stream, state.currentRepo)
+		case "remote":
+			if state.currentRepo == "" {
+				fmt.Println("No repository selected.")
+				return
+			}
+			if len(args) != 2 || args[0] != "default" {
+				fmt.Println("Usage: remote default <name>")
+				return
+			}
+			handleSetDefaultRemote(stream, state.currentRepo, args[1])
+		case "hunks":
+			if state.currentRepo == "" {
+				fmt.Println("No repository selected.")
+				return
+			}
+			if len(args) != 1 {
+				fmt.Println("Usage: hunks <file>")
+				return
+			}
+			handleListHunks(stream, state.currentRepo, args[0])
+		case "stage":
+			if state.currentRepo == "" {
+				fmt.Println("No repository selected.")
+				return
+			}
+			if len(args) < 2 {
+				fmt.Println("Usage: stage <file> <hunk-id>...")
+				return
+			}
+			handleStageHunks(stream, state.currentRepo, args[0], args[1:])
+		case "reflog":
+			if state.currentRepo == "" {
+				fmt.Println("No repository selected.")
+				return
+			}
+			handleReflogCommand(stream, state, args)
+		case "clean":
+			if state.currentRepo == "" {
+				fmt.Println("No repository selected.")
+				return
+			}
+			flags, _ := splitFlags(args)
+			_, dirs := flags["dirs"]
+			_, ignored := flags["ignored"]
+			handleGitClean(stream, state, dirs, ignored)
+		case "worktrees":
+			if state.currentRepo == "" {
+				fmt.Println("No repository selected.")
+				return
+			}
+			handleListWorktrees(stream, state.currentRepo)
+		case "worktree":
+			if state.currentRepo == "" {
+				fmt.Println("No repository selected.")
+				return
+			}
+			flags, rest := splitFlags(args)
+			if len(rest) < 2 || rest[0] != "add" {
+				fmt.Println("Usage: worktree add <branch> [path] [--new]")
+				return
+			}
+			reqPayload := protocol.AddWorktreeRequestPayload{RepoPath: state.currentRepo, Branch: rest[1]}
+			if len(rest) > 2 {
+				reqPayload.Path = rest[2]
+			}
+			_, reqPayload.NewBranch = flags["new"]
+			handleAddWorktree(stream, reqPayload)
+		case "conflicts":
+			if state.currentRepo == "" {
+				fmt.Println("No repository selected.")
+				return
+			}
+			handleConflictsList(stream, state.currentRepo)
+		case "continue":
+			if state.currentRepo == "" {
+				fmt.Println("No repository selected.")
+				return
+			}
+			handleContinueMerge(stream, state.currentRepo)
+		case "hook":
 			if state.currentRepo == "" {
 				fmt.Println("No repository selected.")
 				return
 			}
 			if len(args) < 1 {
-				fmt.Println("Usage: cat <file-path>")
+				fmt.Println("Usage: hook pre-commit|pre-push")
 				return
 			}
-			content, err := readFileRemote(context.Background(), state, args[0])
-			if err != nil {
-				fmt.Printf("Error: %v\n", err)
+			handleRunHook(stream, state.currentRepo, args[0])
+		case "bisect":
+			if state.currentRepo == "" {
+				fmt.Println("No repository selected.")
+				return
+			}
+			handleBisectCommand(stream, state.currentRepo, args)
+		case "stats":
+			// Without a repo (or with --all) show every linked repo side by side
+			flags, _ := splitFlags(args)
+			repo := state.currentRepo
+			if _, all := flags["all"]; all {
+				repo = ""
+			}
+			handleRepoStats(stream, repo)
+		case "bundle":
+			if state.currentRepo == "" {
+				fmt.Println("No repository selected.")
+				return
+			}
+			if len(args) >= 2 && args[0] == "create" {
+				handleBundleCreate(stream, state.currentRepo, args[1], args[2:])
+			} else if len(args) == 2 && args[0] == "fetch" {
+				handleBundleUpload(stream, state.currentRepo, args[1])
 			} else {
-				fmt.Println(string(content))
+				fmt.Println("Usage: bundle create <local-file> [refs...] | bundle fetch <local-file>")
+			}
+		case "download":
+			if state.currentRepo == "" {
+				fmt.Println("No repository selected.")
+				return
+			}
+			if len(args) != 2 {
+				fmt.Println("Usage: download <remote-file> <local-path>")
+				return
 			}
+			handleDownloadFile(stream, state.currentRepo, args[0], args[1])
+		case "upload":
+			if state.currentRepo == "" {
+				fmt.Println("No repository selected.")
+				return
+			}
+			if len(args) != 2 {
+				fmt.Println("Usage: upload <local-file> <remote-path>")
+				return
+			}
+			handleUploadFile(stream, state.currentRepo, args[0], args[1])
+
+		// --- Commands that need context but not a direct stream ---
+		case "cat":
+			if state.currentRepo == "" {
+				fmt.Println("No repository selected.")
+				return
+			}
+			flags, rest := splitFlags(args)
+			if len(rest) < 1 {
+				fmt.Println("Usage: cat [--hex] <file-path>[:<start>-<end>]")
+				return
+			}
+			_, hexdump := flags["hex"]
+			handleCat(context.Background(), state, rest[0], hexdump)
 		case "edit":
 			if state.currentRepo == "" {
 				fmt.Println("No repository selected.")
@@ -390,6 +1029,16 @@ func executor(state *clientState) func(s string) {
 				return
 			}
 			handleEditFile(context.Background(), state, args[0])
+		case "resolve":
+			if state.currentRepo == "" {
+				fmt.Println("No repository selected.")
+				return
+			}
+			if len(args) < 2 {
+				fmt.Println("Usage: resolve <file> ours|theirs|edit")
+				return
+			}
+			handleResolveConflict(context.Background(), state, args[0], args[1])
 		default:
 			fmt.Println("Unknown command. Type 'help' for a list of commands.")
 		}
@@ -398,35 +1047,84 @@ func executor(state *clientState) func(s string) {
 
 // --- All the helper functions for executor go here ---
 
-func performHandshake(ctx context.Context, h host.Host, addrInfo peer.AddrInfo, ts *store.TrustStore) {
+func performHandshake(ctx context.Context, h host.Host, addrInfo peer.AddrInfo, ts *store.TrustStore, session *p2p.SessionKeeper) error {
 	fmt.Println("Performing first-time handshake...")
 	stream, err := h.NewStream(ctx, addrInfo.ID, protocol.ProtocolID)
 	if err != nil {
-		log.Fatalf("Failed to open stream for handshake: %v", err)
+		return fmt.Errorf("failed to open stream for handshake: %w", err)
 	}
 	defer stream.Close()
+	stream = protocol.Buffered(stream)
 
-	handshakeReq := &protocol.Message{Type: "HANDSHAKE_REQUEST"}
+	// A pairing token from the daemon's owner gets us approved without them
+	// at the daemon's terminal
+	token := strings.TrimSpace(os.Getenv("P2P_GIT_PAIRING_TOKEN"))
+	reqPayload, _ := json.Marshal(protocol.HandshakeRequestPayload{Token: token})
+	handshakeReq := &protocol.Message{Type: "HANDSHAKE_REQUEST", Payload: reqPayload}
 	if err := protocol.WriteMessage(stream, handshakeReq); err != nil {
-		log.Fatalf("Failed to send handshake: %v", err)
+		return fmt.Errorf("failed to send handshake: %w", err)
 	}
 
+	// Prove this client holds the key behind its peer ID
 	response, err := protocol.ReadMessage(stream)
 	if err != nil {
-		log.Fatalf("Failed to read handshake response: %v", err)
+		return fmt.Errorf("failed to read handshake challenge: %w", err)
+	}
+	if response.Type == protocol.TypeHandshakeChallenge {
+		var challenge protocol.HandshakeChallengePayload
+		json.Unmarshal(response.Payload, &challenge)
+		pubKey, signature, err := p2p.SignChallenge(h.Peerstore().PrivKey(h.ID()), challenge.Nonce, addrInfo.ID, h.ID())
+		if err != nil {
+			return fmt.Errorf("failed to sign handshake challenge: %w", err)
+		}
+		payloadBytes, _ := json.Marshal(protocol.HandshakeProofPayload{PublicKey: pubKey, Signature: signature})
+		if err := protocol.WriteMessage(stream, &protocol.Message{Type: protocol.TypeHandshakeProof, Payload: payloadBytes}); err != nil {
+			return fmt.Errorf("failed to send handshake proof: %w", err)
+		}
+
+		if token != "" {
+			fmt.Println("Pairing with the token in $P2P_GIT_PAIRING_TOKEN...")
+		} else {
+			code := p2p.PairingCode(h.ID(), addrInfo.ID)
+			fmt.Println("Waiting for the daemon's owner to approve this client.")
+			color.New(color.Bold).Printf("Pairing code: %s\n", code)
+			fmt.Println("Type it at the daemon's prompt, or run 'p2p-gitctl approve <code>' on a headless daemon, to approve this client.")
+			fmt.Printf("Client fingerprint: %s\n", p2p.WordFingerprint(h.ID()))
+			fmt.Printf("Daemon fingerprint: %s\n", p2p.WordFingerprint(addrInfo.ID))
+			fmt.Println("The daemon shows the same two fingerprints if you're pairing with the right one.")
+		}
+
+		if response, err = protocol.ReadMessage(stream); err != nil {
+			return fmt.Errorf("failed to read handshake response: %w", err)
+		}
 	}
 
 	var payload protocol.HandshakeResponsePayload
 	if err := json.Unmarshal(response.Payload, &payload); err != nil {
-		log.Fatalf("Failed to parse handshake payload: %v", err)
+		return fmt.Errorf("failed to parse handshake payload: %w", err)
 	}
 
 	if payload.Approved {
 		fmt.Println("Handshake successful! Daemon approved us.")
-		ts.AddTrustedPeer(addrInfo.ID)
-	} else {
-		log.Println("Handshake failed. Daemon rejected the connection.")
+		if payload.Role != "" {
+			fmt.Printf("Access granted: %s\n", payload.Role)
+		}
+		verified := false
+		if token == "" {
+			fmt.Print("Did the daemon show the same two fingerprints? (y/n, Enter to skip): ")
+			answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+			switch strings.TrimSpace(strings.ToLower(answer)) {
+			case "y":
+				verified = true
+			case "n":
+				return errors.New("the fingerprints differ: this may not be your daemon, so it isn't trusted")
+			}
+		}
+		ts.AddTrustedPeerAs(addrInfo.ID, store.TrustedPeer{Role: store.RoleReadWrite, Verified: verified})
+		session.Set(payload.Session, payload.SessionExpires)
+		return nil
 	}
+	return errors.New("the daemon rejected the connection")
 }
 
 func handleListRepos(stream network.Stream) {
@@ -440,6 +1138,9 @@ func handleListRepos(stream network.Stream) {
 
 	var payload protocol.ListReposResponsePayload
 	json.Unmarshal(resp.Payload, &payload)
+	if printJSON(payload) {
+		return
+	}
 	color.Cyan("--- Available Repositories ---")
 	for _, repo := range payload.Repos {
 		color.Yellow("- %s", repo)
@@ -447,9 +1148,73 @@ func handleListRepos(stream network.Stream) {
 	color.Cyan("------------------------------")
 }
 
-func handleListFiles(stream network.Stream, repoAlias string) {
+func handleListDir(stream network.Stream, repoAlias, dir string, long, includeIgnored bool) {
+	reqPayload := protocol.ListDirRequestPayload{RepoPath: repoAlias, Path: dir, IncludeIgnored: includeIgnored}
+	payloadBytes, _ := json.Marshal(reqPayload)
+	req := &protocol.Message{Type: protocol.TypeListDirRequest, Payload: payloadBytes}
+	protocol.WriteMessage(stream, req)
+
+	resp, err := protocol.ReadMessage(stream)
+	if err != nil {
+		color.Red("Error reading 'ls' response: %v", err)
+		return
+	}
+	var respPayload protocol.ListDirResponsePayload
+	json.Unmarshal(resp.Payload, &respPayload)
+	if printJSON(respPayload) {
+		return
+	}
+	if !respPayload.Success {
+		color.Red("Error from daemon: %s", respPayload.Error)
+		return
+	}
+
+	color.Cyan("--- /%s ---", respPayload.Path)
+	if len(respPayload.Entries) == 0 {
+		fmt.Println("(empty)")
+	}
+	for _, e := range respPayload.Entries {
+		name := e.Name
+		if e.IsDir {
+			name = color.BlueString(name + "/")
+		} else if e.Status != "" {
+			name = statusColor(e.Status).Sprint(name)
+		}
+		if !long {
+			fmt.Println(name)
+			continue
+		}
+		size := ""
+		if !e.IsDir {
+			size = formatBytes(e.Size)
+		}
+		fmt.Printf("%s %10s %s %s %s\n",
+			e.Mode, size,
+			e.ModTime.Local().Format("2006-01-02 15:04"),
+			statusColor(e.Status).Sprintf("%-10s", e.Status),
+			name)
+	}
+	color.Cyan("---------------------------")
+}
+
+// statusColor picks the color for a file's git status in listings.
+func statusColor(status string) *color.Color {
+	switch status {
+	case "modified":
+		return color.New(color.FgYellow)
+	case "staged":
+		return color.New(color.FgGreen)
+	case "untracked":
+		return color.New(color.FgRed)
+	case "conflicted":
+		return color.New(color.FgMagenta, color.Bold)
+	}
+	return color.New(color.Reset)
+}
+
+func handleListFiles(stream network.Stream, repoAlias, prefix string, patterns []string, includeIgnored bool) {
 	// 1. Create and send the request
-	reqPayload := protocol.ListFilesRequestPayload{RepoPath: repoAlias}
+	reqPayload := protocol.ListFilesRequestPayload{RepoPath: repoAlias, Prefix: prefix, Patterns: patterns, IncludeIgnored: includeIgnored}
 	payloadBytes, _ := json.Marshal(reqPayload)
 	req := &protocol.Message{Type: protocol.TypeListFilesRequest, Payload: payloadBytes}
 	if err := protocol.WriteMessage(stream, req); err != nil {
@@ -470,6 +1235,9 @@ func handleListFiles(stream network.Stream, repoAlias string) {
 		color.Red("Error parsing 'ls' response payload: %v", err)
 		return
 	}
+	if printJSON(respPayload) {
+		return
+	}
 
 	// 4. Check for an error message from the daemon
 	if !respPayload.Success {
@@ -477,6 +1245,11 @@ func handleListFiles(stream network.Stream, repoAlias string) {
 		return
 	}
 
+	if len(respPayload.Files) == 0 && len(patterns) > 0 {
+		fmt.Println("No files match.")
+		return
+	}
+
 	// 5. THIS IS THE CRITICAL PART: Print the files
 	color.Cyan("--- Files in Repository ---")
 	for _, file := range respPayload.Files {
@@ -543,17 +1316,83 @@ func handleRenameFile(stream network.Stream, repoAlias, oldPath, newPath string)
 	}
 }
 
+// readFileRemote returns the content of a text file on the daemon.
 func readFileRemote(ctx context.Context, state *clientState, filePath string) ([]byte, error) {
-	stream, err := state.p2pHost.NewStream(ctx, state.daemonInfo.ID, protocol.ProtocolID)
+	file, err := fetchFileRemote(ctx, state, protocol.ReadFileRequestPayload{FilePath: filePath})
+	if err != nil {
+		return nil, err
+	}
+	if file.Binary {
+		return nil, fmt.Errorf("%s is a binary file (%s, %s); use `download` to copy it", filePath, file.MimeType, formatBytes(file.Size))
+	}
+	return []byte(file.Content), nil
+}
+
+// lineRangeArg matches a cat argument with a line range, like main.go:100-160,
+// main.go:100- or main.go:100.
+var lineRangeArg = regexp.MustCompile(`^(.+):(\d+)(-(\d*))?$`)
+
+// parseLineRange splits a cat argument into the file and its line range; 0
+// means the start or end of the file.
+func parseLineRange(arg string) (filePath string, start, end int) {
+	m := lineRangeArg.FindStringSubmatch(arg)
+	if m == nil {
+		return arg, 0, 0
+	}
+	start, _ = strconv.Atoi(m[2])
+	end = start
+	if m[3] != "" {
+		end, _ = strconv.Atoi(m[4]) // 0 if empty: to the end
+	}
+	return m[1], start, end
+}
+
+// handleCat prints a file, or a range of its lines. Binary files are only
+// described, or hexdumped if asked for and small enough.
+func handleCat(ctx context.Context, state *clientState, arg string, hexdump bool) {
+	filePath, start, end := parseLineRange(arg)
+	reqPayload := protocol.ReadFileRequestPayload{FilePath: filePath, Hexdump: hexdump, StartLine: start, EndLine: end}
+	file, err := fetchFileRemote(ctx, state, reqPayload)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+	switch {
+	case !file.Binary && file.TotalLines > 0:
+		fmt.Print(file.Content)
+		if end == 0 || end > file.TotalLines {
+			end = file.TotalLines
+		}
+		if start > end {
+			color.Yellow("%s has only %d lines.", filePath, file.TotalLines)
+		} else {
+			fmt.Printf("(lines %d-%d of %d)\n", start, end, file.TotalLines)
+		}
+	case !file.Binary:
+		fmt.Println(file.Content)
+	case file.Data != nil:
+		fmt.Print(hex.Dump(file.Data))
+	default:
+		color.Yellow("%s is a binary file (%s, %s).", filePath, file.MimeType, formatBytes(file.Size))
+		if file.Size <= protocol.MaxHexdumpSize {
+			fmt.Printf("Use `cat --hex %s` to see its bytes, or copy it with:\n", filePath)
+		} else {
+			fmt.Printf("It is too large to hexdump (over %s); copy it with:\n", formatBytes(protocol.MaxHexdumpSize))
+		}
+		fmt.Printf("  download %s\n", filePath)
+	}
+}
+
+// fetchFileRemote asks the daemon for a file of the current repo, text or
+// binary.
+func fetchFileRemote(ctx context.Context, state *clientState, reqPayload protocol.ReadFileRequestPayload) (*protocol.ReadFileResponsePayload, error) {
+	stream, err := state.openStream(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("could not create stream: %v", err)
 	}
 	defer stream.Close()
 
-	reqPayload := protocol.ReadFileRequestPayload{
-		RepoPath: state.currentRepo,
-		FilePath: filePath,
-	}
+	reqPayload.RepoPath = state.currentRepo
 	payloadBytes, _ := json.Marshal(reqPayload)
 	req := &protocol.Message{Type: protocol.TypeReadFileRequest, Payload: payloadBytes}
 
@@ -575,11 +1414,23 @@ func readFileRemote(ctx context.Context, state *clientState, filePath string) ([
 		return nil, fmt.Errorf("daemon error: %s", respPayload.Error)
 	}
 
-	return []byte(respPayload.Content), nil
+	return &respPayload, nil
+}
+
+// staleWriteError is returned by writeFileRemote when the daemon's file no
+// longer has the base hash of the write, with the file as it is now.
+type staleWriteError struct {
+	content []byte
+}
+
+func (e *staleWriteError) Error() string {
+	return "the file changed on the daemon since the edit started"
 }
 
-func writeFileRemote(ctx context.Context, state *clientState, filePath, content string) error {
-	stream, err := state.p2pHost.NewStream(ctx, state.daemonInfo.ID, protocol.ProtocolID)
+// writeFileRemote writes content to filePath on the daemon. With a baseHash
+// the write only happens if the file still has that blob hash.
+func writeFileRemote(ctx context.Context, state *clientState, filePath, content, baseHash string) error {
+	stream, err := state.openStream(ctx)
 	if err != nil {
 		return fmt.Errorf("could not create stream: %v", err)
 	}
@@ -589,6 +1440,7 @@ func writeFileRemote(ctx context.Context, state *clientState, filePath, content
 		RepoPath: state.currentRepo,
 		FilePath: filePath,
 		Content:  content,
+		BaseHash: baseHash,
 	}
 	payloadBytes, _ := json.Marshal(reqPayload)
 	req := &protocol.Message{Type: protocol.TypeWriteFileRequest, Payload: payloadBytes}
@@ -607,16 +1459,79 @@ func writeFileRemote(ctx context.Context, state *clientState, filePath, content
 		return fmt.Errorf("error parsing response: %v", err)
 	}
 
+	if respPayload.Conflict {
+		return &staleWriteError{content: []byte(respPayload.CurrentContent)}
+	}
+
 	if !respPayload.Success {
 		return fmt.Errorf("daemon error: %s", respPayload.Error)
 	}
 
 	fmt.Printf("Successfully wrote changes to %s on the daemon.\n", filePath)
+	reportWritten(filePath, []byte(content), respPayload.Written)
 	return nil
 }
 
+// reportWritten confirms a write from what the daemon saw afterwards: the
+// file's blob hash must match the content sent (if expected isn't nil), and
+// git should see the file as changed.
+func reportWritten(filePath string, expected []byte, written *protocol.WrittenFile) {
+	if written == nil {
+		return // Older daemon, or git couldn't tell
+	}
+	short := written.Hash
+	if len(short) > 7 {
+		short = short[:7]
+	}
+	switch {
+	case expected != nil && written.Hash != plumbing.ComputeHash(plumbing.BlobObject, expected).String():
+		color.Red("Verification failed: %s on the daemon (blob %s) is not what was sent.", filePath, short)
+	case written.Status == "":
+		color.Yellow("Verified %s (blob %s), but git sees no change: it matches the last commit.", filePath, short)
+	default:
+		color.Green("Verified %s (blob %s), git status: %s", filePath, short, written.Status)
+	}
+}
+
+// errPatchConflict means the daemon's copy of a file changed in a way an
+// uploaded patch can't be applied to.
+var errPatchConflict = errors.New("patch does not apply")
+
+// applyPatchRemote has the daemon apply a unified diff of filePath, and
+// returns what the file looks like to git afterwards.
+func applyPatchRemote(ctx context.Context, state *clientState, filePath, patch string) (*protocol.WrittenFile, error) {
+	stream, err := state.openStream(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not create stream: %v", err)
+	}
+	defer stream.Close()
+
+	reqPayload := protocol.ApplyPatchRequestPayload{RepoPath: state.currentRepo, FilePath: filePath, Patch: patch}
+	payloadBytes, _ := json.Marshal(reqPayload)
+	if err := protocol.WriteMessage(stream, &protocol.Message{Type: protocol.TypeApplyPatchRequest, Payload: payloadBytes}); err != nil {
+		return nil, fmt.Errorf("failed to send patch: %v", err)
+	}
+	resp, err := protocol.ReadMessage(stream)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read patch response: %v", err)
+	}
+	var respPayload protocol.ApplyPatchResponsePayload
+	json.Unmarshal(resp.Payload, &respPayload)
+	if respPayload.Conflict {
+		return nil, fmt.Errorf("%w: %s", errPatchConflict, respPayload.Output)
+	}
+	if !respPayload.Success {
+		return nil, fmt.Errorf("daemon error: %s", respPayload.Output)
+	}
+	return respPayload.Written, nil
+}
+
 // The clever `edit` implementation
 func handleEditFile(ctx context.Context, state *clientState, filePath string) {
+	// Watch from before the download, so no change can slip in between
+	changedRemotely, stopWatching := watchRemoteFile(ctx, state, filePath)
+	defer stopWatching()
+
 	fmt.Printf("Downloading %s for editing...\n", filePath)
 	content, err := readFileRemote(ctx, state, filePath)
 	if err != nil {
@@ -624,11 +1539,177 @@ func handleEditFile(ctx context.Context, state *clientState, filePath string) {
 		return
 	}
 
+	newContent, err := editLocally(filePath, content)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	if bytes.Equal(newContent, content) {
+		fmt.Println("No changes to upload.")
+		return
+	}
+
+	// Send only what changed. The daemon applies it with git apply, which
+	// also refuses edits that clash with changes made there meanwhile.
+	var written *protocol.WrittenFile
+	patch, err := unifiedDiff(filePath, string(content), string(newContent))
+	if err == nil {
+		fmt.Printf("Uploading changes as a patch (%d bytes)...\n", len(patch))
+		written, err = applyPatchRemote(ctx, state, filePath, patch)
+	}
+	switch {
+	case err == nil:
+		fmt.Printf("Successfully applied changes to %s on the daemon.\n", filePath)
+		if changedRemotely() {
+			color.Yellow("Note: %s also changed on the daemon while you were editing it; your changes were applied on top.", filePath)
+			// The result then differs from what was edited here
+			reportWritten(filePath, nil, written)
+		} else {
+			reportWritten(filePath, newContent, written)
+		}
+		return
+	case errors.Is(err, errPatchConflict):
+		color.Yellow("Your changes no longer apply as a patch: %s changed on the daemon while you were editing it.", filePath)
+	default:
+		fmt.Printf("Could not upload a patch (%v); uploading the whole file instead.\n", err)
+	}
+	uploadWithMerge(ctx, state, filePath, content, newContent)
+}
+
+// maxMergeAttempts bounds how often an upload is merged and retried against
+// a file that keeps changing on the daemon.
+const maxMergeAttempts = 3
+
+// uploadWithMerge uploads newContent, an edit of base, as long as the
+// daemon's file is still base. If it has changed, the edit is merged
+// three-way into the daemon's version and that is uploaded instead, with any
+// conflicts left to resolve in $EDITOR first.
+func uploadWithMerge(ctx context.Context, state *clientState, filePath string, base, newContent []byte) {
+	for attempt := 1; ; attempt++ {
+		fmt.Println("Uploading changes...")
+		baseHash := plumbing.ComputeHash(plumbing.BlobObject, base).String()
+		err := writeFileRemote(ctx, state, filePath, string(newContent), baseHash)
+		var stale *staleWriteError
+		if !errors.As(err, &stale) {
+			if err != nil {
+				fmt.Printf("Failed to upload changes: %v\n", err)
+			}
+			return
+		}
+
+		color.Yellow("%s changed on the daemon since your edit started.", filePath)
+		if attempt == maxMergeAttempts {
+			fmt.Println("It keeps changing, so your changes were not merged.")
+			if confirmOverwrite(filePath, newContent) {
+				if err := writeFileRemote(ctx, state, filePath, string(newContent), ""); err != nil {
+					fmt.Printf("Failed to upload changes: %v\n", err)
+				}
+			}
+			return
+		}
+		merged, conflicts, err := git.MergeText(newContent, base, stale.content, "yours", "daemon")
+		if err != nil {
+			fmt.Printf("Could not merge your changes into it: %v\n", err)
+			if confirmOverwrite(filePath, newContent) {
+				if err := writeFileRemote(ctx, state, filePath, string(newContent), ""); err != nil {
+					fmt.Printf("Failed to upload changes: %v\n", err)
+				}
+			}
+			return
+		}
+		if conflicts == 0 {
+			fmt.Println("Merged your changes into the daemon's version.")
+		} else {
+			color.Yellow("Your changes conflict with the daemon's in %d place(s); resolve them in your editor.", conflicts)
+			if merged, err = editLocally(filePath, merged); err != nil {
+				fmt.Println(err)
+				saveLocalCopy(filePath, newContent)
+				return
+			}
+			if bytes.Contains(merged, []byte("<<<<<<<")) || bytes.Contains(merged, []byte(">>>>>>>")) {
+				color.Yellow("Warning: the file still contains conflict markers.")
+				if !confirmOverwrite(filePath, merged) {
+					return
+				}
+			}
+		}
+		base, newContent = stale.content, merged
+	}
+}
+
+// confirmOverwrite asks whether to upload content over the daemon's file
+// anyway. If not, it is saved to a temp file so the edit isn't lost.
+func confirmOverwrite(filePath string, content []byte) bool {
+	fmt.Print("Upload anyway? (y/n): ")
+	reader := bufio.NewReader(os.Stdin)
+	answer, _ := reader.ReadString('\n')
+	if strings.TrimSpace(answer) == "y" {
+		return true
+	}
+	saveLocalCopy(filePath, content)
+	return false
+}
+
+// saveLocalCopy keeps an edit that wasn't uploaded in a temp file.
+func saveLocalCopy(filePath string, content []byte) {
+	if f, err := os.CreateTemp("", "p2p-edit-*-"+filepath.Base(filePath)); err == nil {
+		f.Write(content)
+		f.Close()
+		fmt.Printf("Upload aborted. Your version was saved to %s\n", f.Name())
+	} else {
+		fmt.Println("Upload aborted.")
+	}
+}
+
+// watchRemoteFile subscribes to the daemon's file change events and returns
+// a function reporting whether filePath has changed since, and one to stop
+// watching. Against a daemon without file watching no change is ever reported.
+func watchRemoteFile(ctx context.Context, state *clientState, filePath string) (changed func() bool, stop func()) {
+	noChange := func() bool { return false }
+	stream, err := state.openStream(ctx)
+	if err != nil {
+		return noChange, func() {}
+	}
+	reqPayload := protocol.SubscribeEventsRequestPayload{RepoPath: state.currentRepo}
+	payloadBytes, _ := json.Marshal(reqPayload)
+	protocol.WriteMessage(stream, &protocol.Message{Type: protocol.TypeSubscribeEventsRequest, Payload: payloadBytes})
+	resp, err := protocol.ReadMessage(stream)
+	var respPayload protocol.SubscribeEventsResponsePayload
+	if err == nil {
+		json.Unmarshal(resp.Payload, &respPayload)
+	}
+	if !respPayload.Success {
+		stream.Close()
+		return noChange, func() {}
+	}
+
+	var seen atomic.Bool
+	target := path.Clean(filepath.ToSlash(filePath))
+	go func() {
+		for {
+			msg, err := protocol.ReadMessage(stream)
+			if err != nil {
+				return // Closed by stop
+			}
+			var event protocol.FileChangedEventPayload
+			json.Unmarshal(msg.Payload, &event)
+			for _, p := range event.Paths {
+				if p == target {
+					seen.Store(true)
+				}
+			}
+		}
+	}()
+	return seen.Load, func() { stream.Close() }
+}
+
+// editLocally opens content in $EDITOR via a temp file and returns the result.
+func editLocally(filePath string, content []byte) ([]byte, error) {
 	// Create a temporary file
 	tmpfile, err := ioutil.TempFile("", "p2p-edit-*.tmp")
 	if err != nil {
-		fmt.Printf("Could not create temp file: %v\n", err)
-		return
+		return nil, fmt.Errorf("Could not create temp file: %v", err)
 	}
 	defer os.Remove(tmpfile.Name()) // Clean up
 
@@ -647,255 +1728,1633 @@ func handleEditFile(ctx context.Context, state *clientState, filePath string) {
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	if err := cmd.Run(); err != nil {
-		fmt.Printf("Editor command failed: %v\n", err)
-		return
+		return nil, fmt.Errorf("Editor command failed: %v", err)
 	}
 
 	// Read the (potentially) modified content back
 	newContent, err := ioutil.ReadFile(tmpfile.Name())
 	if err != nil {
-		fmt.Printf("Could not read modified file: %v\n", err)
-		return
-	}
-
-	// Upload the new content
-	fmt.Println("Uploading changes...")
-	if err := writeFileRemote(ctx, state, filePath, string(newContent)); err != nil {
-		fmt.Printf("Failed to upload changes: %v\n", err)
+		return nil, fmt.Errorf("Could not read modified file: %v", err)
 	}
+	return newContent, nil
 }
 
-func handleCommit(stream network.Stream, repoAlias, branch, message string) {
-	reqPayload := protocol.GitCommitRequestPayload{
-		RepoPath: repoAlias,
-		Message:  message,
-		Branch:   branch,
-	}
+// composeCommitMessage opens $EDITOR pre-filled with the repo's commit
+// template. It returns "" if the repo has no template or the message was
+// left empty.
+func composeCommitMessage(stream network.Stream, repo string) (string, error) {
+	reqPayload := protocol.GetCommitTemplateRequestPayload{RepoPath: repo}
 	payloadBytes, _ := json.Marshal(reqPayload)
-	req := &protocol.Message{Type: protocol.TypeGitCommitRequest, Payload: payloadBytes}
+	req := &protocol.Message{Type: protocol.TypeGetCommitTemplateRequest, Payload: payloadBytes}
 	protocol.WriteMessage(stream, req)
 
 	resp, err := protocol.ReadMessage(stream)
 	if err != nil {
-		color.Red("Error reading commit response: %v", err)
-		return
+		return "", fmt.Errorf("could not read commit template: %v", err)
 	}
-
-	var respPayload protocol.GitCommitResponsePayload
-	if err := json.Unmarshal(resp.Payload, &respPayload); err != nil {
-		color.Red("Error parsing commit response payload: %v", err)
-		return
+	var respPayload protocol.GetCommitTemplateResponsePayload
+	json.Unmarshal(resp.Payload, &respPayload)
+	if !respPayload.Success {
+		return "", fmt.Errorf("could not get commit template: %s", respPayload.Error)
+	}
+	if respPayload.Template == "" {
+		return "", nil
 	}
 
-	if !respPayload.Success {
-		color.Red("Commit failed:\n%s", respPayload.Output)
-	} else {
-		color.Green("Commit successful!")
-		color.Cyan("Output:")
-		fmt.Println(respPayload.Output)
+	content := respPayload.Template + "\n# Lines starting with '#' are ignored. An empty message aborts the commit.\n"
+	edited, err := editLocally("commit message", []byte(content))
+	if err != nil {
+		return "", err
 	}
+	var lines []string
+	for _, line := range strings.Split(string(edited), "\n") {
+		if !strings.HasPrefix(line, "#") {
+			lines = append(lines, line)
+		}
+	}
+	message := strings.TrimSpace(strings.Join(lines, "\n"))
+	if message == strings.TrimSpace(respPayload.Template) {
+		return "", nil // Saved without filling anything in
+	}
+	return message, nil
 }
 
-func handleListBranches(stream network.Stream, repoAlias string) {
-	reqPayload := protocol.ListBranchesRequestPayload{RepoPath: repoAlias}
+func handleConflictsList(stream network.Stream, repo string) {
+	reqPayload := protocol.ConflictsListRequestPayload{RepoPath: repo}
 	payloadBytes, _ := json.Marshal(reqPayload)
-	req := &protocol.Message{Type: protocol.TypeListBranchesRequest, Payload: payloadBytes}
+	req := &protocol.Message{Type: protocol.TypeConflictsListRequest, Payload: payloadBytes}
 	protocol.WriteMessage(stream, req)
 
 	resp, err := protocol.ReadMessage(stream)
 	if err != nil {
-		fmt.Printf("Error reading branches response: %v\n", err)
+		color.Red("Error reading conflicts response: %v", err)
 		return
 	}
-
-	var respPayload protocol.ListBranchesResponsePayload
-	if err := json.Unmarshal(resp.Payload, &respPayload); err != nil {
-		fmt.Printf("Error parsing branches response payload: %v\n", err)
+	var respPayload protocol.ConflictsListResponsePayload
+	json.Unmarshal(resp.Payload, &respPayload)
+	if !respPayload.Success {
+		color.Red("Error from daemon: %s", respPayload.Error)
 		return
 	}
-
-	if !respPayload.Success {
-		fmt.Printf("Error from daemon: %s\n", respPayload.Error)
+	if len(respPayload.Conflicts) == 0 {
+		color.Green("No conflicts. Use 'continue' to finish an in-progress merge.")
 		return
 	}
 
-	fmt.Println("--- Available Branches ---")
-	for _, branch := range respPayload.Branches {
-		fmt.Println(branch)
+	for _, c := range respPayload.Conflicts {
+		color.Cyan("=== %s (%d conflict(s)) ===", c.Path, len(c.Hunks))
+		for _, h := range c.Hunks {
+			color.Yellow("--- line %d ---", h.Line)
+			color.Green("<<<<<<< ours")
+			fmt.Print(h.Ours)
+			color.White("||||||| base")
+			fmt.Print(h.Base)
+			color.White("=======")
+			fmt.Print(h.Theirs)
+			color.Red(">>>>>>> theirs")
+		}
 	}
-	fmt.Println("------------------------------")
+	fmt.Println("Resolve with 'resolve <file> ours|theirs|edit', then run 'continue'.")
 }
 
-func handleLinkRepo(stream network.Stream, alias, path string) {
-	reqPayload := protocol.LinkRepoRequestPayload{Alias: alias, Path: path}
-	payloadBytes, _ := json.Marshal(reqPayload)
-	req := &protocol.Message{Type: protocol.TypeLinkRepoRequest, Payload: payloadBytes}
-	protocol.WriteMessage(stream, req)
+// handleResolveConflict resolves one file. "edit" downloads the file with its
+// conflict markers, opens it in $EDITOR and uploads the merged result.
+func handleResolveConflict(ctx context.Context, state *clientState, filePath, resolution string) {
+	reqPayload := protocol.ResolveConflictRequestPayload{RepoPath: state.currentRepo, FilePath: filePath}
+	switch resolution {
+	case protocol.ResolveOurs, protocol.ResolveTheirs:
+		reqPayload.Resolution = resolution
+	case "edit":
+		content, err := readFileRemote(ctx, state, filePath)
+		if err != nil {
+			fmt.Printf("Could not read remote file: %v\n", err)
+			return
+		}
+		merged, err := editLocally(filePath, content)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		if strings.Contains(string(merged), "<<<<<<<") || strings.Contains(string(merged), ">>>>>>>") {
+			color.Yellow("Warning: the file still contains conflict markers.")
+			fmt.Print("Mark it as resolved anyway? (y/N): ")
+			reader := bufio.NewReader(os.Stdin)
+			answer, _ := reader.ReadString('\n')
+			if strings.ToLower(strings.TrimSpace(answer)) != "y" {
+				fmt.Println("Resolution aborted. Your edits were not uploaded.")
+				return
+			}
+		}
+		reqPayload.Resolution = protocol.ResolveManual
+		reqPayload.Content = string(merged)
+	default:
+		fmt.Println("Usage: resolve <file> ours|theirs|edit")
+		return
+	}
 
-	resp, err := protocol.ReadMessage(stream)
+	stream, err := state.openStream(ctx)
 	if err != nil {
-		fmt.Printf("Error reading link response: %v\n", err)
+		color.Red("Error: could not create stream: %v", err)
 		return
 	}
+	defer stream.Close()
 
-	var respPayload protocol.LinkRepoResponsePayload
-	if err := json.Unmarshal(resp.Payload, &respPayload); err != nil {
-		fmt.Printf("Error parsing link response payload: %v\n", err)
+	payloadBytes, _ := json.Marshal(reqPayload)
+	req := &protocol.Message{Type: protocol.TypeResolveConflictRequest, Payload: payloadBytes}
+	protocol.WriteMessage(stream, req)
+
+	resp, err := protocol.ReadMessage(stream)
+	if err != nil {
+		color.Red("Error reading resolve response: %v", err)
 		return
 	}
-
+	var respPayload protocol.ResolveConflictResponsePayload
+	json.Unmarshal(resp.Payload, &respPayload)
 	if !respPayload.Success {
-		fmt.Printf("Failed to link repo: %s\n", respPayload.Error)
+		color.Red("Failed to resolve %s:\n%s", filePath, respPayload.Output)
 	} else {
-		fmt.Printf("Successfully linked '%s' on daemon.\n", alias)
+		color.Green("%s", respPayload.Output)
 	}
 }
 
-func handleSwitchBranch(stream network.Stream, state *clientState, branchName string) {
-	reqPayload := protocol.SwitchBranchRequestPayload{
-		RepoPath:   state.currentRepo,
-		BranchName: branchName,
-	}
+func handleContinueMerge(stream network.Stream, repo string) {
+	reqPayload := protocol.ContinueMergeRequestPayload{RepoPath: repo}
 	payloadBytes, _ := json.Marshal(reqPayload)
-	req := &protocol.Message{Type: protocol.TypeSwitchBranchRequest, Payload: payloadBytes}
+	req := &protocol.Message{Type: protocol.TypeContinueMergeRequest, Payload: payloadBytes}
 	protocol.WriteMessage(stream, req)
 
 	resp, err := protocol.ReadMessage(stream)
 	if err != nil {
-		fmt.Printf("Error reading switch response: %v\n", err)
+		color.Red("Error reading continue response: %v", err)
+		return
+	}
+	var respPayload protocol.ContinueMergeResponsePayload
+	json.Unmarshal(resp.Payload, &respPayload)
+	if !respPayload.Success {
+		color.Red("Could not continue:\n%s", respPayload.Output)
 		return
 	}
+	if respPayload.Operation != "" {
+		color.Green("Finished %s.", respPayload.Operation)
+	}
+	fmt.Print(respPayload.Output)
+}
 
-	var respPayload protocol.SwitchBranchResponsePayload
-	if err := json.Unmarshal(resp.Payload, &respPayload); err != nil {
-		fmt.Printf("Error parsing switch response payload: %v\n", err)
+// handleBisectCommand routes the `bisect` subcommands.
+func handleBisectCommand(stream network.Stream, repo string, args []string) {
+	usage := "Usage: bisect start <good> [bad] | bisect good|bad [commit] | bisect reset"
+	if len(args) == 0 {
+		fmt.Println(usage)
 		return
 	}
 
-	if !respPayload.Success {
-		color.Red("Error from daemon:\n%s", respPayload.Output)
-	} else {
-		color.Green("Daemon switched to branch '%s'.", branchName)
-		state.currentBranch = branchName
+	var reqType string
+	var reqPayload interface{}
+	switch args[0] {
+	case "start":
+		if len(args) < 2 {
+			fmt.Println("Usage: bisect start <good> [bad]")
+			return
+		}
+		p := protocol.BisectStartRequestPayload{RepoPath: repo, Good: args[1]}
+		if len(args) > 2 {
+			p.Bad = args[2]
+		}
+		reqType, reqPayload = protocol.TypeBisectStartRequest, p
+	case "good", "bad":
+		p := protocol.BisectMarkRequestPayload{RepoPath: repo}
+		if len(args) > 1 {
+			p.Commit = args[1]
+		}
+		reqType = protocol.TypeBisectGoodRequest
+		if args[0] == "bad" {
+			reqType = protocol.TypeBisectBadRequest
+		}
+		reqPayload = p
+	case "reset":
+		reqType, reqPayload = protocol.TypeBisectResetRequest, protocol.BisectResetRequestPayload{RepoPath: repo}
+	default:
+		fmt.Println(usage)
+		return
 	}
-}
 
-func handleGitStatus(stream network.Stream, repoAlias string) {
-	reqPayload := protocol.GitStatusRequestPayload{RepoPath: repoAlias}
 	payloadBytes, _ := json.Marshal(reqPayload)
-	req := &protocol.Message{Type: protocol.TypeGitStatusRequest, Payload: payloadBytes}
-	protocol.WriteMessage(stream, req)
-	resp, _ := protocol.ReadMessage(stream)
-	var respPayload protocol.GitStatusResponsePayload
-	json.Unmarshal(resp.Payload, &respPayload)
+	protocol.WriteMessage(stream, &protocol.Message{Type: reqType, Payload: payloadBytes})
 
-	color.Cyan("--- Git Status ---")
-	if !respPayload.Success {
-		color.Red("Error from daemon: %s", respPayload.Output)
-	} else {
+	resp, err := protocol.ReadMessage(stream)
+	if err != nil {
+		color.Red("Error reading bisect response: %v", err)
+		return
+	}
+	var respPayload protocol.BisectResponsePayload
+	json.Unmarshal(resp.Payload, &respPayload)
+	switch {
+	case !respPayload.Success:
+		color.Red("Bisect failed: %s", respPayload.Output)
+	case respPayload.Culprit != nil:
+		c := respPayload.Culprit
+		color.Green("First bad commit: %s %s <%s>", c.AbbrevHash, c.Subject, c.Author)
+		fmt.Println("Run 'bisect reset' to return to your branch.")
+	case respPayload.Current != nil:
+		c := respPayload.Current
+		color.Cyan("Testing %s %s (%d revisions left after this)", c.AbbrevHash, c.Subject, respPayload.Remaining)
+		fmt.Println("Check it, then run 'bisect good' or 'bisect bad'.")
+	default:
 		fmt.Print(respPayload.Output)
 	}
-	color.Cyan("------------------")
 }
 
-func handleGitLog(stream network.Stream, repoAlias string) {
-	reqPayload := protocol.GitLogRequestPayload{RepoPath: repoAlias}
+func handleRepoStats(stream network.Stream, repo string) {
+	reqPayload := protocol.RepoStatsRequestPayload{RepoPath: repo}
 	payloadBytes, _ := json.Marshal(reqPayload)
-	req := &protocol.Message{Type: protocol.TypeGitLogRequest, Payload: payloadBytes}
+	req := &protocol.Message{Type: protocol.TypeRepoStatsRequest, Payload: payloadBytes}
 	protocol.WriteMessage(stream, req)
 
-	resp, _ := protocol.ReadMessage(stream)
+	resp, err := protocol.ReadMessage(stream)
+	if err != nil {
+		color.Red("Error reading stats response: %v", err)
+		return
+	}
+	var respPayload protocol.RepoStatsResponsePayload
+	json.Unmarshal(resp.Payload, &respPayload)
+	if !respPayload.Success {
+		color.Red("Error from daemon: %s", respPayload.Error)
+		return
+	}
+
+	for _, r := range respPayload.Repos {
+		color.Cyan("--- %s ---", r.Alias)
+		if r.Error != "" {
+			color.Red("  %s", r.Error)
+			continue
+		}
+		fmt.Printf("  Size:          %s (%d objects)\n", formatBytes(r.SizeBytes), r.Objects)
+		fmt.Printf("  Branches/tags: %d / %d\n", r.Branches, r.Tags)
+		fmt.Printf("  Last commit:   %s\n", formatAge(r.LastCommit))
+		fmt.Printf("  Last activity: %s\n", formatAge(r.LastActivity))
+		if len(r.Contributors) > 0 {
+			fmt.Println("  Top contributors:")
+			for _, c := range r.Contributors {
+				fmt.Printf("    %5d  %s <%s>\n", c.Commits, c.Name, c.Email)
+			}
+		}
+	}
+}
+
+// formatBytes renders a size like "12.3 MiB".
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// formatAge renders a timestamp with how long ago it was, e.g. "2024-01-02 (3 months ago)".
+func formatAge(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	days := int(time.Since(t).Hours() / 24)
+	var ago string
+	switch {
+	case days < 1:
+		ago = "today"
+	case days < 60:
+		ago = fmt.Sprintf("%d days ago", days)
+	case days < 730:
+		ago = fmt.Sprintf("%d months ago", days/30)
+	default:
+		ago = fmt.Sprintf("%d years ago", days/365)
+	}
+	return fmt.Sprintf("%s (%s)", t.Local().Format("2006-01-02"), ago)
+}
+
+// handleBundleCreate has the daemon bundle refs and saves the bundle to a
+// local file as it arrives.
+func handleBundleCreate(stream network.Stream, repo, localPath string, refs []string) {
+	f, err := os.Create(localPath)
+	if err != nil {
+		color.Red("Could not create %s: %v", localPath, err)
+		return
+	}
+	defer f.Close()
+
+	reqPayload := protocol.BundleCreateRequestPayload{RepoPath: repo, Refs: refs}
+	payloadBytes, _ := json.Marshal(reqPayload)
+	req := &protocol.Message{Type: protocol.TypeBundleCreateRequest, Payload: payloadBytes}
+	protocol.WriteMessage(stream, req)
+
+	progress := newTransferProgress("Receiving bundle", 0)
+	for {
+		resp, err := protocol.ReadMessage(stream)
+		if err != nil {
+			progress.finish()
+			color.Red("Error reading bundle: %v", err)
+			os.Remove(localPath)
+			return
+		}
+		if resp.Type == protocol.TypeBundleData {
+			var chunk protocol.BundleDataPayload
+			json.Unmarshal(resp.Payload, &chunk)
+			progress.setTotal(chunk.Total)
+			if _, err := f.Write(chunk.Data); err != nil {
+				progress.finish()
+				color.Red("Error writing %s: %v", localPath, err)
+				os.Remove(localPath)
+				return
+			}
+			progress.add(len(chunk.Data))
+			continue
+		}
+		progress.finish()
+
+		var respPayload protocol.BundleCreateResponsePayload
+		json.Unmarshal(resp.Payload, &respPayload)
+		if !respPayload.Success {
+			color.Red("Error from daemon: %s", respPayload.Error)
+			os.Remove(localPath)
+			return
+		}
+		color.Green("Saved bundle to %s (%d bytes).", localPath, respPayload.Size)
+		return
+	}
+}
+
+// handleBundleUpload sends a local bundle file to the daemon, which fetches
+// its branches into refs/remotes/bundle/*.
+func handleBundleUpload(stream network.Stream, repo, localPath string) {
+	f, err := os.Open(localPath)
+	if err != nil {
+		color.Red("Could not open %s: %v", localPath, err)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		color.Red("Could not read %s: %v", localPath, err)
+		return
+	}
+
+	reqPayload := protocol.BundleUploadRequestPayload{RepoPath: repo, Size: info.Size()}
+	payloadBytes, _ := json.Marshal(reqPayload)
+	req := &protocol.Message{Type: protocol.TypeBundleUploadRequest, Payload: payloadBytes}
+	protocol.WriteMessage(stream, req)
+
+	sendChunks(stream, protocol.TypeBundleData, f, newTransferProgress("Sending bundle", info.Size()))
+
+	resp, err := protocol.ReadMessage(stream)
+	if err != nil {
+		color.Red("Error reading bundle response: %v", err)
+		return
+	}
+	var respPayload protocol.BundleUploadResponsePayload
+	json.Unmarshal(resp.Payload, &respPayload)
+	if !respPayload.Success {
+		color.Red("Error from daemon: %s", respPayload.Output)
+		return
+	}
+	color.Green("Fetched bundle into refs/remotes/bundle/*:")
+	fmt.Print(respPayload.Output)
+}
+
+// sendChunks sends everything read from r as data chunk messages of msgType
+// (BUNDLE_DATA or FILE_DATA), showing how far it got on progress.
+func sendChunks(stream network.Stream, msgType string, r io.Reader, progress *transferProgress) {
+	defer progress.finish()
+	buf := make([]byte, protocol.FileChunkSize)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			chunkBytes, _ := json.Marshal(protocol.FileDataPayload{Data: buf[:n]})
+			if werr := protocol.WriteMessage(stream, &protocol.Message{Type: msgType, Payload: chunkBytes}); werr != nil {
+				return // The daemon gave up early; its response says why
+			}
+			progress.add(n)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// handleDownloadFile saves a file from the daemon's repo to localPath, or
+// into it when it is a directory. An existing local file is only replaced
+// once the whole file has arrived.
+func handleDownloadFile(stream network.Stream, repo, remotePath, localPath string) {
+	if info, err := os.Stat(localPath); err == nil && info.IsDir() {
+		localPath = filepath.Join(localPath, path.Base(filepath.ToSlash(remotePath)))
+	}
+	f, err := os.CreateTemp(filepath.Dir(localPath), ".download-*")
+	if err != nil {
+		color.Red("Could not create %s: %v", localPath, err)
+		return
+	}
+	defer os.Remove(f.Name()) // No-op once renamed
+	defer f.Close()
+
+	reqPayload := protocol.DownloadFileRequestPayload{RepoPath: repo, FilePath: remotePath}
+	payloadBytes, _ := json.Marshal(reqPayload)
+	req := &protocol.Message{Type: protocol.TypeDownloadFileRequest, Payload: payloadBytes}
+	protocol.WriteMessage(stream, req)
+
+	// Older daemons don't send the size, and get a bar without ETA
+	progress := newTransferProgress("Downloading "+path.Base(filepath.ToSlash(remotePath)), 0)
+	for {
+		resp, err := protocol.ReadMessage(stream)
+		if err != nil {
+			progress.finish()
+			color.Red("Error reading file: %v", err)
+			return
+		}
+		if resp.Type == protocol.TypeFileData {
+			var chunk protocol.FileDataPayload
+			json.Unmarshal(resp.Payload, &chunk)
+			progress.setTotal(chunk.Total)
+			if _, err := f.Write(chunk.Data); err != nil {
+				progress.finish()
+				color.Red("Error writing %s: %v", localPath, err)
+				return
+			}
+			progress.add(len(chunk.Data))
+			continue
+		}
+		progress.finish()
+
+		var respPayload protocol.DownloadFileResponsePayload
+		json.Unmarshal(resp.Payload, &respPayload)
+		if !respPayload.Success {
+			color.Red("Error from daemon: %s", respPayload.Error)
+			return
+		}
+		if err := f.Close(); err != nil {
+			color.Red("Error writing %s: %v", localPath, err)
+			return
+		}
+		if err := os.Rename(f.Name(), localPath); err != nil {
+			color.Red("Could not save %s: %v", localPath, err)
+			return
+		}
+		color.Green("Downloaded %s to %s (%d bytes).", remotePath, localPath, respPayload.Size)
+		return
+	}
+}
+
+// handleUploadFile sends a local file to remotePath in the daemon's repo, or
+// into it when it ends with a slash.
+func handleUploadFile(stream network.Stream, repo, localPath, remotePath string) {
+	f, err := os.Open(localPath)
+	if err != nil {
+		color.Red("Could not open %s: %v", localPath, err)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		color.Red("Could not read %s: %v", localPath, err)
+		return
+	}
+	if !info.Mode().IsRegular() {
+		color.Red("%s is not a regular file", localPath)
+		return
+	}
+	if strings.HasSuffix(remotePath, "/") {
+		remotePath += filepath.Base(localPath)
+	}
+
+	reqPayload := protocol.UploadFileRequestPayload{RepoPath: repo, FilePath: remotePath, Size: info.Size()}
+	payloadBytes, _ := json.Marshal(reqPayload)
+	req := &protocol.Message{Type: protocol.TypeUploadFileRequest, Payload: payloadBytes}
+	protocol.WriteMessage(stream, req)
+
+	sendChunks(stream, protocol.TypeFileData, f, newTransferProgress("Uploading "+filepath.Base(localPath), info.Size()))
+
+	resp, err := protocol.ReadMessage(stream)
+	if err != nil {
+		color.Red("Error reading upload response: %v", err)
+		return
+	}
+	var respPayload protocol.UploadFileResponsePayload
+	json.Unmarshal(resp.Payload, &respPayload)
+	if !respPayload.Success {
+		color.Red("Error from daemon: %s", respPayload.Output)
+		return
+	}
+	color.Green(respPayload.Output)
+}
+
+// handleRunHook prints the hook's output as the daemon streams it back.
+func handleRunHook(stream network.Stream, repo, hook string) {
+	reqPayload := protocol.RunHookRequestPayload{RepoPath: repo, Hook: hook}
+	payloadBytes, _ := json.Marshal(reqPayload)
+	req := &protocol.Message{Type: protocol.TypeRunHookRequest, Payload: payloadBytes}
+	protocol.WriteMessage(stream, req)
+
+	color.Cyan("--- Running %s ---", hook)
+	for {
+		resp, err := protocol.ReadMessage(stream)
+		if err != nil {
+			color.Red("Error reading hook output: %v", err)
+			return
+		}
+		if resp.Type == protocol.TypeRunHookOutput {
+			var chunk protocol.RunHookOutputPayload
+			json.Unmarshal(resp.Payload, &chunk)
+			fmt.Print(chunk.Data)
+			continue
+		}
+
+		var respPayload protocol.RunHookResponsePayload
+		json.Unmarshal(resp.Payload, &respPayload)
+		if !respPayload.Success {
+			color.Red("%s", respPayload.Error)
+		} else {
+			color.Green("%s passed.", hook)
+		}
+		return
+	}
+}
+
+func handleCommit(stream network.Stream, reqPayload protocol.GitCommitRequestPayload) {
+	payloadBytes, _ := json.Marshal(reqPayload)
+	req := &protocol.Message{Type: protocol.TypeGitCommitRequest, Payload: payloadBytes}
+	protocol.WriteMessage(stream, req)
+
+	resp, err := protocol.ReadMessage(stream)
+	if err != nil {
+		color.Red("Error reading commit response: %v", err)
+		return
+	}
+
+	var respPayload protocol.GitCommitResponsePayload
+	if err := json.Unmarshal(resp.Payload, &respPayload); err != nil {
+		color.Red("Error parsing commit response payload: %v", err)
+		return
+	}
+
+	if len(respPayload.Secrets) > 0 {
+		color.Red("Commit blocked: the staged changes look like they contain secrets.")
+		for _, s := range respPayload.Secrets {
+			fmt.Printf("  %s:%d  %s  %s\n", s.File, s.Line, color.YellowString(s.Kind), s.Match)
+		}
+		fmt.Println("Remove them, or commit anyway with --allow-secrets if they are not real.")
+	} else if !respPayload.Success {
+		color.Red("Commit failed:\n%s", respPayload.Output)
+	} else if reqPayload.DryRun {
+		if len(respPayload.Files) == 0 {
+			fmt.Println(respPayload.Output)
+			return
+		}
+		color.Cyan("--- Would commit ---")
+		for _, f := range respPayload.Files {
+			line := color.YellowString("%-2s", f.Status) + " " + f.Path
+			if f.Binary {
+				line += color.MagentaString(" (binary)")
+			} else {
+				line += color.GreenString(" +%d", f.Insertions) + color.RedString(" -%d", f.Deletions)
+			}
+			fmt.Println(line)
+		}
+		color.Cyan("--------------------")
+		fmt.Print(respPayload.Output)
+	} else if reqPayload.Background {
+		color.Green("Committing and pushing in the background as job %s.", respPayload.JobID)
+		fmt.Printf("Follow it with 'job %s --follow', or check on it with 'jobs'.\n", respPayload.JobID)
+	} else {
+		color.Green("Commit successful!")
+		if respPayload.Upstream != "" {
+			color.Green("Branch now tracks %s.", respPayload.Upstream)
+		}
+		color.Cyan("Output:")
+		fmt.Println(respPayload.Output)
+	}
+}
+
+func handleListBranches(stream network.Stream, repoAlias string) {
+	reqPayload := protocol.ListBranchesRequestPayload{RepoPath: repoAlias}
+	payloadBytes, _ := json.Marshal(reqPayload)
+	req := &protocol.Message{Type: protocol.TypeListBranchesRequest, Payload: payloadBytes}
+	protocol.WriteMessage(stream, req)
+
+	resp, err := protocol.ReadMessage(stream)
+	if err != nil {
+		fmt.Printf("Error reading branches response: %v\n", err)
+		return
+	}
+
+	var respPayload protocol.ListBranchesResponsePayload
+	if err := json.Unmarshal(resp.Payload, &respPayload); err != nil {
+		fmt.Printf("Error parsing branches response payload: %v\n", err)
+		return
+	}
+	if printJSON(respPayload) {
+		return
+	}
+
+	if !respPayload.Success {
+		fmt.Printf("Error from daemon: %s\n", respPayload.Error)
+		return
+	}
+
+	fmt.Println("--- Available Branches ---")
+	for _, branch := range respPayload.Branches {
+		fmt.Println(branch)
+	}
+	fmt.Println("------------------------------")
+}
+
+func handleLinkRepo(stream network.Stream, alias, path string) {
+	reqPayload := protocol.LinkRepoRequestPayload{Alias: alias, Path: path}
+	payloadBytes, _ := json.Marshal(reqPayload)
+	req := &protocol.Message{Type: protocol.TypeLinkRepoRequest, Payload: payloadBytes}
+	protocol.WriteMessage(stream, req)
+
+	resp, err := protocol.ReadMessage(stream)
+	if err != nil {
+		fmt.Printf("Error reading link response: %v\n", err)
+		return
+	}
+
+	var respPayload protocol.LinkRepoResponsePayload
+	if err := json.Unmarshal(resp.Payload, &respPayload); err != nil {
+		fmt.Printf("Error parsing link response payload: %v\n", err)
+		return
+	}
+
+	if !respPayload.Success {
+		fmt.Printf("Failed to link repo: %s\n", respPayload.Error)
+	} else {
+		fmt.Printf("Successfully linked '%s' on daemon.\n", alias)
+	}
+}
+
+// handleRenameRepo renames a linked repo on the daemon, following it if it's
+// the current one.
+func handleRenameRepo(stream network.Stream, state *clientState, alias, newAlias string) {
+	reqPayload := protocol.RenameAliasRequestPayload{Alias: alias, NewAlias: newAlias}
+	payloadBytes, _ := json.Marshal(reqPayload)
+	req := &protocol.Message{Type: protocol.TypeRenameAliasRequest, Payload: payloadBytes}
+	protocol.WriteMessage(stream, req)
+
+	resp, err := protocol.ReadMessage(stream)
+	if err != nil {
+		color.Red("Error reading rename response: %v", err)
+		return
+	}
+	var respPayload protocol.RenameAliasResponsePayload
+	json.Unmarshal(resp.Payload, &respPayload)
+	if !respPayload.Success {
+		color.Red("Error from daemon: %s", respPayload.Error)
+		return
+	}
+	if state.currentRepo == alias {
+		state.currentRepo = newAlias
+	}
+	color.Green("Renamed '%s' to '%s'.", alias, newAlias)
+}
+
+func handleSwitchBranch(stream network.Stream, state *clientState, branchName string) {
+	reqPayload := protocol.SwitchBranchRequestPayload{
+		RepoPath:   state.currentRepo,
+		BranchName: branchName,
+	}
+	payloadBytes, _ := json.Marshal(reqPayload)
+	req := &protocol.Message{Type: protocol.TypeSwitchBranchRequest, Payload: payloadBytes}
+	protocol.WriteMessage(stream, req)
+
+	resp, err := protocol.ReadMessage(stream)
+	if err != nil {
+		fmt.Printf("Error reading switch response: %v\n", err)
+		return
+	}
+
+	var respPayload protocol.SwitchBranchResponsePayload
+	if err := json.Unmarshal(resp.Payload, &respPayload); err != nil {
+		fmt.Printf("Error parsing switch response payload: %v\n", err)
+		return
+	}
+
+	if !respPayload.Success {
+		color.Red("Error from daemon:\n%s", respPayload.Output)
+	} else {
+		color.Green("Daemon switched to branch '%s'.", branchName)
+		state.currentBranch = branchName
+	}
+}
+
+func handleGitStatus(stream network.Stream, repoAlias string) {
+	reqPayload := protocol.GitStatusRequestPayload{RepoPath: repoAlias}
+	payloadBytes, _ := json.Marshal(reqPayload)
+	req := &protocol.Message{Type: protocol.TypeGitStatusRequest, Payload: payloadBytes}
+	protocol.WriteMessage(stream, req)
+	resp, err := protocol.ReadMessage(stream)
+	if err != nil {
+		color.Red("Error reading status response: %v", err)
+		return
+	}
+	var respPayload protocol.GitStatusResponsePayload
+	json.Unmarshal(resp.Payload, &respPayload)
+	if printJSON(respPayload) {
+		return
+	}
+
+	color.Cyan("--- Git Status ---")
+	if !respPayload.Success {
+		color.Red("Error from daemon: %s", respPayload.Output)
+	} else {
+		if respPayload.Branch != "" {
+			fmt.Printf("On branch %s", color.YellowString(respPayload.Branch))
+			if respPayload.Upstream != "" {
+				fmt.Printf(", tracking %s (ahead %d, behind %d)", respPayload.Upstream, respPayload.Ahead, respPayload.Behind)
+			}
+			fmt.Printf("\nStaged: %d  Unstaged: %d  Untracked: %d\n\n", respPayload.Staged, respPayload.Unstaged, respPayload.Untracked)
+		}
+		fmt.Print(respPayload.Output)
+	}
+	color.Cyan("------------------")
+}
+
+// refreshStatusBadge fetches the repo's status so the prompt can show the
+// branch, ahead/behind counts and pending changes.
+func refreshStatusBadge(state *clientState) {
+	if state.currentRepo == "" {
+		state.statusBadge = ""
+		return
+	}
+	stream, err := state.conn.NewStream(context.Background())
+	if err != nil {
+		return
+	}
+	defer stream.Close()
+
+	payloadBytes, _ := json.Marshal(protocol.GitStatusRequestPayload{RepoPath: state.currentRepo})
+	protocol.WriteMessage(stream, &protocol.Message{Type: protocol.TypeGitStatusRequest, Payload: payloadBytes})
+	resp, err := protocol.ReadMessage(stream)
+	if err != nil {
+		return
+	}
+	var respPayload protocol.GitStatusResponsePayload
+	json.Unmarshal(resp.Payload, &respPayload)
+	if !respPayload.Success || respPayload.Branch == "" {
+		// Older daemons don't send the structured fields
+		state.statusBadge = ""
+		return
+	}
+	state.currentBranch = respPayload.Branch
+	state.statusBadge = respPayload.Badge()
+}
+
+func handleGitLog(stream network.Stream, repoAlias string, showSignatures bool) {
+	reqPayload := protocol.GitLogRequestPayload{RepoPath: repoAlias, ShowSignatures: showSignatures}
+	payloadBytes, _ := json.Marshal(reqPayload)
+	req := &protocol.Message{Type: protocol.TypeGitLogRequest, Payload: payloadBytes}
+	protocol.WriteMessage(stream, req)
+
+	resp, err := protocol.ReadMessage(stream)
+	if err != nil {
+		color.Red("Error reading log response: %v", err)
+		return
+	}
 	var respPayload protocol.GitLogResponsePayload
 	json.Unmarshal(resp.Payload, &respPayload)
+	if printJSON(respPayload) {
+		return
+	}
+
+	if !respPayload.Success {
+		color.Red("Error from daemon: %s", respPayload.Output)
+	} else {
+		color.Cyan("--- Git Log ---")
+		if len(respPayload.Commits) == 0 {
+			// Older daemons only send the pre-formatted graph
+			fmt.Println(respPayload.Output)
+		}
+		for _, c := range respPayload.Commits {
+			line := color.RedString(c.AbbrevHash)
+			if len(c.Refs) > 0 {
+				line += color.YellowString(" (%s)", strings.Join(c.Refs, ", "))
+			}
+			line += " " + c.Subject
+			line += color.GreenString(" (%s)", c.Date.Local().Format("2006-01-02 15:04"))
+			line += color.BlueString(" <%s>", c.Author)
+			if c.Signature != "" {
+				line += color.MagentaString(" [sig: %s]", c.Signature)
+			}
+			fmt.Println(line)
+		}
+		color.Cyan("---------------")
+	}
+}
+
+func handleFileLog(stream network.Stream, repoAlias, filePath string) {
+	reqPayload := protocol.FileLogRequestPayload{RepoPath: repoAlias, FilePath: filePath}
+	payloadBytes, _ := json.Marshal(reqPayload)
+	req := &protocol.Message{Type: protocol.TypeFileLogRequest, Payload: payloadBytes}
+	protocol.WriteMessage(stream, req)
+
+	resp, err := protocol.ReadMessage(stream)
+	if err != nil {
+		color.Red("Error reading log response: %v", err)
+		return
+	}
+	var respPayload protocol.FileLogResponsePayload
+	json.Unmarshal(resp.Payload, &respPayload)
+	if printJSON(respPayload) {
+		return
+	}
+
+	if !respPayload.Success {
+		color.Red("Error from daemon: %s", respPayload.Output)
+		return
+	}
+	color.Cyan("--- History of %s ---", filePath)
+	if len(respPayload.Commits) == 0 {
+		fmt.Println(respPayload.Output)
+	}
+	for _, c := range respPayload.Commits {
+		line := color.RedString(c.AbbrevHash)
+		line += " " + c.Subject
+		line += color.GreenString(" (%s)", c.Date.Local().Format("2006-01-02 15:04"))
+		line += color.BlueString(" <%s>", c.Author)
+		if c.Path != "" && c.Path != filePath {
+			// The file had a different name at this commit
+			line += color.YellowString(" [as %s]", c.Path)
+		}
+		fmt.Println(line)
+	}
+	color.Cyan("---------------")
+}
+
+func handleGitDiff(stream network.Stream, reqPayload protocol.GitDiffRequestPayload) {
+	payloadBytes, _ := json.Marshal(reqPayload)
+	req := &protocol.Message{Type: protocol.TypeGitDiffRequest, Payload: payloadBytes}
+	protocol.WriteMessage(stream, req)
+
+	resp, err := protocol.ReadMessage(stream)
+	if err != nil {
+		color.Red("Error reading diff response: %v", err)
+		return
+	}
+	var respPayload protocol.GitDiffResponsePayload
+	json.Unmarshal(resp.Payload, &respPayload)
+
+	if !respPayload.Success {
+		color.Red("Error from daemon: %s", respPayload.Output)
+	} else {
+		color.Cyan("--- Git Diff ---")
+		fmt.Println(respPayload.Output)
+		color.Cyan("----------------")
+	}
+}
+
+// handleStashCommand routes the `stash` subcommands. A bare `stash` saves.
+func handleStashCommand(stream network.Stream, repoAlias string, args []string) {
+	if len(args) == 0 {
+		handleGitStashSave(stream, repoAlias, "")
+		return
+	}
+
+	// apply/pop/drop take an optional stash index, defaulting to the latest
+	index := 0
+	if len(args) > 1 {
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 0 {
+			fmt.Println("Stash index must be a non-negative number, e.g. 'stash apply 2'")
+			return
+		}
+		index = n
+	}
+
+	switch args[0] {
+	case "save", "push":
+		handleGitStashSave(stream, repoAlias, strings.Join(args[1:], " "))
+	case "list":
+		handleGitStashList(stream, repoAlias)
+	case "apply":
+		handleGitStashApply(stream, repoAlias, index)
+	case "pop":
+		handleGitStashPop(stream, repoAlias, index)
+	case "drop":
+		if len(args) < 2 {
+			fmt.Println("Usage: stash drop <index>")
+			return
+		}
+		handleGitStashDrop(stream, repoAlias, index)
+	default:
+		fmt.Println("Usage: stash [save [msg] | list | apply [n] | pop [n] | drop <n>]")
+	}
+}
+
+func handleGitStashSave(stream network.Stream, repoAlias, message string) {
+	reqPayload := protocol.GitStashSaveRequestPayload{RepoPath: repoAlias, Message: message}
+	payloadBytes, _ := json.Marshal(reqPayload)
+	req := &protocol.Message{Type: protocol.TypeGitStashSaveRequest, Payload: payloadBytes}
+	protocol.WriteMessage(stream, req)
+
+	resp, err := protocol.ReadMessage(stream)
+	if err != nil {
+		color.Red("Error reading stash response: %v", err)
+		return
+	}
+	var respPayload protocol.GitStashSaveResponsePayload
+	json.Unmarshal(resp.Payload, &respPayload)
+
+	if !respPayload.Success {
+		color.Red("Error stashing changes:\n%s", respPayload.Output)
+	} else {
+		color.Green("--- Stash Result ---")
+		fmt.Print(respPayload.Output)
+		color.Green("--------------------")
+	}
+}
+
+func handleGitStashPop(stream network.Stream, repoAlias string, index int) {
+	reqPayload := protocol.GitStashPopRequestPayload{RepoPath: repoAlias, Index: index}
+	payloadBytes, _ := json.Marshal(reqPayload)
+	req := &protocol.Message{Type: protocol.TypeGitStashPopRequest, Payload: payloadBytes}
+	protocol.WriteMessage(stream, req)
+
+	resp, err := protocol.ReadMessage(stream)
+	if err != nil {
+		color.Red("Error reading stash pop response: %v", err)
+		return
+	}
+	var respPayload protocol.GitStashPopResponsePayload
+	json.Unmarshal(resp.Payload, &respPayload)
+
+	if !respPayload.Success {
+		color.Red("Error popping stash:\n%s", respPayload.Output)
+	} else {
+		color.Green("--- Stash Pop Result ---")
+		fmt.Print(respPayload.Output)
+		color.Green("------------------------")
+	}
+}
+
+func handleGitStashList(stream network.Stream, repoAlias string) {
+	reqPayload := protocol.GitStashListRequestPayload{RepoPath: repoAlias}
+	payloadBytes, _ := json.Marshal(reqPayload)
+	req := &protocol.Message{Type: protocol.TypeGitStashListRequest, Payload: payloadBytes}
+	protocol.WriteMessage(stream, req)
+
+	resp, err := protocol.ReadMessage(stream)
+	if err != nil {
+		color.Red("Error reading stash list response: %v", err)
+		return
+	}
+	var respPayload protocol.GitStashListResponsePayload
+	json.Unmarshal(resp.Payload, &respPayload)
+
+	if !respPayload.Success {
+		color.Red("Error from daemon: %s", respPayload.Error)
+		return
+	}
+	color.Cyan("--- Stashes ---")
+	if len(respPayload.Stashes) == 0 {
+		fmt.Println("No stashes.")
+	}
+	for _, st := range respPayload.Stashes {
+		fmt.Printf("%s %s %s %s\n",
+			color.YellowString("%d:", st.Index),
+			color.GreenString("[%s]", st.Branch),
+			st.Message,
+			color.BlueString("(%s)", st.Date.Local().Format("2006-01-02 15:04")))
+	}
+	color.Cyan("---------------")
+}
+
+func handleGitStashApply(stream network.Stream, repoAlias string, index int) {
+	reqPayload := protocol.GitStashApplyRequestPayload{RepoPath: repoAlias, Index: index}
+	payloadBytes, _ := json.Marshal(reqPayload)
+	req := &protocol.Message{Type: protocol.TypeGitStashApplyRequest, Payload: payloadBytes}
+	protocol.WriteMessage(stream, req)
+
+	resp, err := protocol.ReadMessage(stream)
+	if err != nil {
+		color.Red("Error reading stash apply response: %v", err)
+		return
+	}
+	var respPayload protocol.GitStashApplyResponsePayload
+	json.Unmarshal(resp.Payload, &respPayload)
+
+	if !respPayload.Success {
+		color.Red("Error applying stash:\n%s", respPayload.Output)
+	} else {
+		color.Green("--- Stash Apply Result ---")
+		fmt.Print(respPayload.Output)
+		color.Green("--------------------------")
+	}
+}
+
+func handleGitStashDrop(stream network.Stream, repoAlias string, index int) {
+	reqPayload := protocol.GitStashDropRequestPayload{RepoPath: repoAlias, Index: index}
+	payloadBytes, _ := json.Marshal(reqPayload)
+	req := &protocol.Message{Type: protocol.TypeGitStashDropRequest, Payload: payloadBytes}
+	protocol.WriteMessage(stream, req)
+
+	resp, err := protocol.ReadMessage(stream)
+	if err != nil {
+		color.Red("Error reading stash drop response: %v", err)
+		return
+	}
+	var respPayload protocol.GitStashDropResponsePayload
+	json.Unmarshal(resp.Payload, &respPayload)
+
+	if !respPayload.Success {
+		color.Red("Error dropping stash:\n%s", respPayload.Output)
+	} else {
+		color.Green("%s", strings.TrimSpace(respPayload.Output))
+	}
+}
+
+// confirmRepoName asks the user to type the repo's name before a request
+// that throws work away. The daemon refuses such requests without it.
+func confirmRepoName(repoAlias string) bool {
+	fmt.Printf("Type the repository name (%s) to proceed: ", repoAlias)
+	reader := bufio.NewReader(os.Stdin)
+	answer, _ := reader.ReadString('\n')
+	return strings.TrimSpace(answer) == repoAlias
+}
+
+func handleGitReset(stream network.Stream, repoAlias string) {
+	color.Red("WARNING: This is a destructive operation. It will discard all uncommitted changes on the daemon.")
+	if !confirmRepoName(repoAlias) {
+		fmt.Println("Reset aborted.")
+		return
+	}
+
+	reqPayload := protocol.GitResetRequestPayload{RepoPath: repoAlias, Confirm: repoAlias}
+	payloadBytes, _ := json.Marshal(reqPayload)
+	req := &protocol.Message{Type: protocol.TypeGitResetRequest, Payload: payloadBytes}
+	protocol.WriteMessage(stream, req)
+
+	resp, err := protocol.ReadMessage(stream)
+	if err != nil {
+		color.Red("Error reading reset response: %v", err)
+		return
+	}
+	var respPayload protocol.GitResetResponsePayload
+	json.Unmarshal(resp.Payload, &respPayload)
+
+	if !respPayload.Success {
+		color.Red("Error from daemon: %s", respPayload.Output)
+	} else {
+		color.Green("--- Reset Result ---")
+		fmt.Print(respPayload.Output)
+		color.Green("--------------------")
+	}
+}
+
+func handleGitConfigGet(stream network.Stream, repoAlias, key string) {
+	reqPayload := protocol.GitConfigGetRequestPayload{RepoPath: repoAlias, Key: key}
+	payloadBytes, _ := json.Marshal(reqPayload)
+	req := &protocol.Message{Type: protocol.TypeGitConfigGetRequest, Payload: payloadBytes}
+	protocol.WriteMessage(stream, req)
+
+	resp, err := protocol.ReadMessage(stream)
+	if err != nil {
+		color.Red("Error reading config response: %v", err)
+		return
+	}
+	var respPayload protocol.GitConfigGetResponsePayload
+	json.Unmarshal(resp.Payload, &respPayload)
+	if !respPayload.Success {
+		color.Red("Error from daemon: %s", respPayload.Error)
+		return
+	}
+	if len(respPayload.Entries) == 0 {
+		if key == "" {
+			fmt.Println("No settings configured.")
+		} else {
+			fmt.Printf("%s is not set.\n", key)
+		}
+		return
+	}
+	for _, e := range respPayload.Entries {
+		fmt.Printf("%s = %s %s\n", color.YellowString(e.Key), e.Value, color.BlueString("(%s)", e.Scope))
+	}
+}
+
+func handleGitConfigSet(stream network.Stream, reqPayload protocol.GitConfigSetRequestPayload) {
+	payloadBytes, _ := json.Marshal(reqPayload)
+	req := &protocol.Message{Type: protocol.TypeGitConfigSetRequest, Payload: payloadBytes}
+	protocol.WriteMessage(stream, req)
+
+	resp, err := protocol.ReadMessage(stream)
+	if err != nil {
+		color.Red("Error reading config response: %v", err)
+		return
+	}
+	var respPayload protocol.GitConfigSetResponsePayload
+	json.Unmarshal(resp.Payload, &respPayload)
+	if !respPayload.Success {
+		color.Red("Error from daemon: %s", respPayload.Output)
+	} else {
+		color.Green(respPayload.Output)
+	}
+}
+
+func handleIgnore(stream network.Stream, repoAlias, pattern string, untrack bool) {
+	reqPayload := protocol.IgnoreRequestPayload{RepoPath: repoAlias, Pattern: pattern, Untrack: untrack}
+	payloadBytes, _ := json.Marshal(reqPayload)
+	req := &protocol.Message{Type: protocol.TypeIgnoreRequest, Payload: payloadBytes}
+	protocol.WriteMessage(stream, req)
+
+	resp, err := protocol.ReadMessage(stream)
+	if err != nil {
+		color.Red("Error reading ignore response: %v", err)
+		return
+	}
+	var respPayload protocol.IgnoreResponsePayload
+	json.Unmarshal(resp.Payload, &respPayload)
+	if !respPayload.Success {
+		color.Red("Error from daemon: %s", respPayload.Output)
+		return
+	}
+
+	color.Green(respPayload.Output)
+	if len(respPayload.Ignored) > 0 {
+		fmt.Println("Now ignored:")
+		for _, path := range respPayload.Ignored {
+			color.Yellow("  %s", path)
+		}
+	}
+	if len(respPayload.Tracked) > 0 {
+		if untrack {
+			fmt.Println("No longer tracked (kept on disk, commit to record it):")
+		} else {
+			color.Red("These files match but are still tracked; use 'ignore --untrack %s' to stop tracking them:", pattern)
+		}
+		for _, path := range respPayload.Tracked {
+			color.Yellow("  %s", path)
+		}
+	}
+}
+
+func handleUnignore(stream network.Stream, repoAlias, pattern string) {
+	reqPayload := protocol.UnignoreRequestPayload{RepoPath: repoAlias, Pattern: pattern}
+	payloadBytes, _ := json.Marshal(reqPayload)
+	req := &protocol.Message{Type: protocol.TypeUnignoreRequest, Payload: payloadBytes}
+	protocol.WriteMessage(stream, req)
+
+	resp, err := protocol.ReadMessage(stream)
+	if err != nil {
+		color.Red("Error reading unignore response: %v", err)
+		return
+	}
+	var respPayload protocol.UnignoreResponsePayload
+	json.Unmarshal(resp.Payload, &respPayload)
+	if !respPayload.Success {
+		color.Red("Error from daemon: %s", respPayload.Output)
+	} else {
+		color.Green(respPayload.Output)
+	}
+}
+
+func handleChmod(stream network.Stream, repoAlias, filePath, mode string) {
+	reqPayload := protocol.ChmodRequestPayload{RepoPath: repoAlias, FilePath: filePath, Mode: mode}
+	payloadBytes, _ := json.Marshal(reqPayload)
+	req := &protocol.Message{Type: protocol.TypeChmodRequest, Payload: payloadBytes}
+	protocol.WriteMessage(stream, req)
+
+	resp, err := protocol.ReadMessage(stream)
+	if err != nil {
+		color.Red("Error reading chmod response: %v", err)
+		return
+	}
+	var respPayload protocol.ChmodResponsePayload
+	json.Unmarshal(resp.Payload, &respPayload)
+	if !respPayload.Success {
+		color.Red("Error from daemon: %s", respPayload.Output)
+		return
+	}
+	color.Green(respPayload.Output)
+}
+
+// handleTail prints the last lines of a file and then follows it, like
+// `tail -f`, until Ctrl+C.
+func handleTail(stream network.Stream, repoAlias, filePath string, lines int) {
+	reqPayload := protocol.TailRequestPayload{RepoPath: repoAlias, FilePath: filePath, Lines: lines}
+	payloadBytes, _ := json.Marshal(reqPayload)
+	req := &protocol.Message{Type: protocol.TypeTailRequest, Payload: payloadBytes}
+	protocol.WriteMessage(stream, req)
+
+	resp, err := protocol.ReadMessage(stream)
+	if err != nil {
+		color.Red("Error reading tail response: %v", err)
+		return
+	}
+	var respPayload protocol.TailResponsePayload
+	json.Unmarshal(resp.Payload, &respPayload)
+	if !respPayload.Success {
+		color.Red("Error from daemon: %s", respPayload.Error)
+		return
+	}
+
+	// Ctrl+C stops following instead of quitting the client
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stream.Close()
+	}()
+	fmt.Printf("Following %s, press Ctrl+C to stop.\n", filePath)
+	for {
+		msg, err := protocol.ReadMessage(stream)
+		if err != nil {
+			if ctx.Err() == nil {
+				color.Red("\nLost connection to the daemon: %v", err)
+			}
+			fmt.Println()
+			return
+		}
+		var data protocol.TailDataPayload
+		json.Unmarshal(msg.Payload, &data)
+		if data.Truncated {
+			color.Yellow("\n--- %s was truncated or replaced ---", filePath)
+		}
+		fmt.Print(data.Data)
+	}
+}
+
+func handleGrep(stream network.Stream, repoAlias, pattern string, paths []string, ignoreCase bool) {
+	reqPayload := protocol.GrepRequestPayload{RepoPath: repoAlias, Pattern: pattern, IgnoreCase: ignoreCase, Paths: paths}
+	payloadBytes, _ := json.Marshal(reqPayload)
+	req := &protocol.Message{Type: protocol.TypeGrepRequest, Payload: payloadBytes}
+	protocol.WriteMessage(stream, req)
+
+	resp, err := protocol.ReadMessage(stream)
+	if err != nil {
+		color.Red("Error reading grep response: %v", err)
+		return
+	}
+	var respPayload protocol.GrepResponsePayload
+	json.Unmarshal(resp.Payload, &respPayload)
+	if printJSON(respPayload) {
+		return
+	}
+	if !respPayload.Success {
+		color.Red("Error from daemon: %s", respPayload.Error)
+		return
+	}
+	if len(respPayload.Matches) == 0 {
+		fmt.Println("No matches.")
+		return
+	}
+
+	// Highlight the matches locally; git's extended regexps are close enough
+	// to Go's for this, and lines are just printed plain if the pattern differs
+	expr := pattern
+	if ignoreCase {
+		expr = "(?i)" + expr
+	}
+	re, _ := regexp.Compile(expr)
+	for _, m := range respPayload.Matches {
+		text := m.Text
+		if re != nil {
+			text = re.ReplaceAllStringFunc(text, func(s string) string { return color.New(color.FgRed, color.Bold).Sprint(s) })
+		}
+		fmt.Printf("%s:%s: %s\n", color.MagentaString(m.Path), color.GreenString("%d", m.Line), text)
+	}
+	if respPayload.Truncated {
+		color.Yellow("Showing the first %d matches; narrow the pattern or add a path to see the rest.", len(respPayload.Matches))
+	}
+}
+
+func handleListRemotes(stream network.Stream, repoAlias string) {
+	reqPayload := protocol.ListRemotesRequestPayload{RepoPath: repoAlias}
+	payloadBytes, _ := json.Marshal(reqPayload)
+	req := &protocol.Message{Type: protocol.TypeListRemotesRequest, Payload: payloadBytes}
+	protocol.WriteMessage(stream, req)
+
+	resp, err := protocol.ReadMessage(stream)
+	if err != nil {
+		color.Red("Error reading remotes response: %v", err)
+		return
+	}
+	var respPayload protocol.ListRemotesResponsePayload
+	json.Unmarshal(resp.Payload, &respPayload)
+	if printJSON(respPayload) {
+		return
+	}
+	if !respPayload.Success {
+		color.Red("Error from daemon: %s", respPayload.Error)
+		return
+	}
+
+	color.Cyan("--- Remotes ---")
+	if len(respPayload.Remotes) == 0 {
+		fmt.Println("No remotes configured.")
+	}
+	for _, r := range respPayload.Remotes {
+		if r.Name == respPayload.Default {
+			fmt.Printf("%s %s %s\n", color.GreenString("* %s", r.Name), r.URL, color.YellowString("(default push remote)"))
+		} else {
+			fmt.Printf("  %s %s\n", r.Name, r.URL)
+		}
+	}
+	color.Cyan("---------------")
+}
+
+func handleSetDefaultRemote(stream network.Stream, repoAlias, remote string) {
+	reqPayload := protocol.SetDefaultRemoteRequestPayload{RepoPath: repoAlias, Remote: remote}
+	payloadBytes, _ := json.Marshal(reqPayload)
+	req := &protocol.Message{Type: protocol.TypeSetDefaultRemoteRequest, Payload: payloadBytes}
+	protocol.WriteMessage(stream, req)
 
+	resp, err := protocol.ReadMessage(stream)
+	if err != nil {
+		color.Red("Error reading remote response: %v", err)
+		return
+	}
+	var respPayload protocol.SetDefaultRemoteResponsePayload
+	json.Unmarshal(resp.Payload, &respPayload)
 	if !respPayload.Success {
 		color.Red("Error from daemon: %s", respPayload.Output)
 	} else {
-		color.Cyan("--- Git Log ---")
-		fmt.Println(respPayload.Output)
-		color.Cyan("---------------")
+		color.Green(respPayload.Output)
 	}
 }
 
-func handleGitDiff(stream network.Stream, repoAlias, filePath string) {
-	reqPayload := protocol.GitDiffRequestPayload{RepoPath: repoAlias, FilePath: filePath}
+func handleListHunks(stream network.Stream, repoAlias, filePath string) {
+	reqPayload := protocol.ListHunksRequestPayload{RepoPath: repoAlias, FilePath: filePath}
 	payloadBytes, _ := json.Marshal(reqPayload)
-	req := &protocol.Message{Type: protocol.TypeGitDiffRequest, Payload: payloadBytes}
+	req := &protocol.Message{Type: protocol.TypeListHunksRequest, Payload: payloadBytes}
 	protocol.WriteMessage(stream, req)
 
-	resp, _ := protocol.ReadMessage(stream)
-	var respPayload protocol.GitDiffResponsePayload
+	resp, err := protocol.ReadMessage(stream)
+	if err != nil {
+		color.Red("Error reading hunks response: %v", err)
+		return
+	}
+	var respPayload protocol.ListHunksResponsePayload
 	json.Unmarshal(resp.Payload, &respPayload)
+	if !respPayload.Success {
+		color.Red("Error from daemon: %s", respPayload.Error)
+		return
+	}
+	if len(respPayload.Hunks) == 0 {
+		fmt.Printf("No unstaged changes in %s.\n", filePath)
+		return
+	}
+
+	for _, h := range respPayload.Hunks {
+		fmt.Printf("%s %s %s\n",
+			color.YellowString("hunk %s", h.ID),
+			color.GreenString("+%d", h.Insertions),
+			color.RedString("-%d", h.Deletions))
+		for _, line := range strings.Split(strings.TrimRight(h.Patch, "\n"), "\n") {
+			switch {
+			case strings.HasPrefix(line, "@@"):
+				color.Cyan(line)
+			case strings.HasPrefix(line, "+"):
+				color.Green(line)
+			case strings.HasPrefix(line, "-"):
+				color.Red(line)
+			default:
+				fmt.Println(line)
+			}
+		}
+		fmt.Println()
+	}
+	fmt.Printf("Stage hunks with 'stage %s <hunk-id>...', then 'commit --staged <msg>'.\n", filePath)
+}
+
+func handleStageHunks(stream network.Stream, repoAlias, filePath string, hunkIDs []string) {
+	reqPayload := protocol.StageHunksRequestPayload{RepoPath: repoAlias, FilePath: filePath, HunkIDs: hunkIDs}
+	payloadBytes, _ := json.Marshal(reqPayload)
+	req := &protocol.Message{Type: protocol.TypeStageHunksRequest, Payload: payloadBytes}
+	protocol.WriteMessage(stream, req)
 
+	resp, err := protocol.ReadMessage(stream)
+	if err != nil {
+		color.Red("Error reading stage response: %v", err)
+		return
+	}
+	var respPayload protocol.StageHunksResponsePayload
+	json.Unmarshal(resp.Payload, &respPayload)
 	if !respPayload.Success {
 		color.Red("Error from daemon: %s", respPayload.Output)
 	} else {
-		color.Cyan("--- Git Diff ---")
-		fmt.Println(respPayload.Output)
-		color.Cyan("----------------")
+		color.Green(strings.TrimSpace(respPayload.Output))
 	}
 }
 
-func handleGitStashSave(stream network.Stream, repoAlias string) {
-	reqPayload := protocol.GitStashSaveRequestPayload{RepoPath: repoAlias}
+func handleCreateFile(stream network.Stream, repoAlias, filePath string) {
+	reqPayload := protocol.CreateFileRequestPayload{RepoPath: repoAlias, FilePath: filePath}
 	payloadBytes, _ := json.Marshal(reqPayload)
-	req := &protocol.Message{Type: protocol.TypeGitStashSaveRequest, Payload: payloadBytes}
+	req := &protocol.Message{Type: protocol.TypeCreateFileRequest, Payload: payloadBytes}
 	protocol.WriteMessage(stream, req)
 
-	resp, _ := protocol.ReadMessage(stream)
-	var respPayload protocol.GitStashSaveResponsePayload
+	resp, err := protocol.ReadMessage(stream)
+	if err != nil {
+		color.Red("Error reading create response: %v", err)
+		return
+	}
+	var respPayload protocol.CreateFileResponsePayload
 	json.Unmarshal(resp.Payload, &respPayload)
-
 	if !respPayload.Success {
-		color.Red("Error stashing changes:\n%s", respPayload.Output)
+		color.Red("Error from daemon: %s", respPayload.Output)
 	} else {
-		color.Green("--- Stash Result ---")
-		fmt.Print(respPayload.Output)
-		color.Green("--------------------")
+		color.Green(respPayload.Output)
+		fmt.Printf("Use 'edit %s' to fill it in.\n", filePath)
 	}
 }
 
-func handleGitStashPop(stream network.Stream, repoAlias string) {
-	reqPayload := protocol.GitStashPopRequestPayload{RepoPath: repoAlias}
+func handleMkdir(stream network.Stream, repoAlias, path string) {
+	reqPayload := protocol.MkdirRequestPayload{RepoPath: repoAlias, Path: path}
 	payloadBytes, _ := json.Marshal(reqPayload)
-	req := &protocol.Message{Type: protocol.TypeGitStashPopRequest, Payload: payloadBytes}
+	req := &protocol.Message{Type: protocol.TypeMkdirRequest, Payload: payloadBytes}
 	protocol.WriteMessage(stream, req)
 
-	resp, _ := protocol.ReadMessage(stream)
-	var respPayload protocol.GitStashPopResponsePayload
+	resp, err := protocol.ReadMessage(stream)
+	if err != nil {
+		color.Red("Error reading mkdir response: %v", err)
+		return
+	}
+	var respPayload protocol.MkdirResponsePayload
 	json.Unmarshal(resp.Payload, &respPayload)
+	if !respPayload.Success {
+		color.Red("Error from daemon: %s", respPayload.Output)
+	} else {
+		color.Green(respPayload.Output)
+	}
+}
+
+func handleDeletePath(stream network.Stream, repoAlias, path string, force bool) {
+	// Without force only tracked, unmodified content is deleted, and git can bring that back
+	reqPayload := protocol.DeletePathRequestPayload{RepoPath: repoAlias, Path: path, Force: force}
+	if force {
+		color.Red("WARNING: --force deletes %s even if it is untracked or has uncommitted changes. This can't be undone.", path)
+		if !confirmRepoName(repoAlias) {
+			fmt.Println("Delete aborted.")
+			return
+		}
+		reqPayload.Confirm = repoAlias
+	}
+	payloadBytes, _ := json.Marshal(reqPayload)
+	req := &protocol.Message{Type: protocol.TypeDeletePathRequest, Payload: payloadBytes}
+	protocol.WriteMessage(stream, req)
 
+	resp, err := protocol.ReadMessage(stream)
+	if err != nil {
+		color.Red("Error reading delete response: %v", err)
+		return
+	}
+	var respPayload protocol.DeletePathResponsePayload
+	json.Unmarshal(resp.Payload, &respPayload)
 	if !respPayload.Success {
-		color.Red("Error popping stash:\n%s", respPayload.Output)
+		color.Red("Error from daemon: %s", respPayload.Output)
 	} else {
-		color.Green("--- Stash Pop Result ---")
 		fmt.Print(respPayload.Output)
-		color.Green("------------------------")
 	}
 }
 
-func handleGitReset(stream network.Stream, repoAlias string) {
-	color.Red("WARNING: This is a destructive operation. It will discard all uncommitted changes on the daemon.")
-	fmt.Print("Are you sure you want to proceed? (y/n): ")
-	reader := bufio.NewReader(os.Stdin)
-	answer, _ := reader.ReadString('\n')
-	answer = strings.TrimSpace(answer)
-	if answer != "y" {
-		fmt.Println("Reset aborted.")
+func handleCheckoutFile(stream network.Stream, repoAlias, filePath string) {
+	color.Red("WARNING: This discards all uncommitted changes to %s on the daemon.", filePath)
+	if !confirmRepoName(repoAlias) {
+		fmt.Println("Discard aborted.")
 		return
 	}
 
-	reqPayload := protocol.GitResetRequestPayload{RepoPath: repoAlias}
+	reqPayload := protocol.CheckoutFileRequestPayload{RepoPath: repoAlias, FilePath: filePath, Confirm: repoAlias}
 	payloadBytes, _ := json.Marshal(reqPayload)
-	req := &protocol.Message{Type: protocol.TypeGitResetRequest, Payload: payloadBytes}
+	req := &protocol.Message{Type: protocol.TypeCheckoutFileRequest, Payload: payloadBytes}
 	protocol.WriteMessage(stream, req)
 
-	resp, _ := protocol.ReadMessage(stream)
-	var respPayload protocol.GitResetResponsePayload
+	resp, err := protocol.ReadMessage(stream)
+	if err != nil {
+		color.Red("Error reading discard response: %v", err)
+		return
+	}
+	var respPayload protocol.CheckoutFileResponsePayload
 	json.Unmarshal(resp.Payload, &respPayload)
 
 	if !respPayload.Success {
 		color.Red("Error from daemon: %s", respPayload.Output)
+	} else {
+		color.Green(strings.TrimSpace(respPayload.Output))
+	}
+}
+
+// handleReflogCommand dispatches `reflog [n]` and `reflog reset <n>`.
+func handleReflogCommand(stream network.Stream, state *clientState, args []string) {
+	const usage = "Usage: reflog [n] | reflog reset <n>"
+	if len(args) > 0 && args[0] == "reset" {
+		if len(args) != 2 {
+			fmt.Println(usage)
+			return
+		}
+		index, err := strconv.Atoi(args[1])
+		if err != nil || index < 0 {
+			fmt.Println("Reflog index must be a non-negative number, e.g. 'reflog reset 2'")
+			return
+		}
+		handleResetToReflog(stream, state, index)
+		return
+	}
+
+	limit := 0
+	if len(args) == 1 {
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n <= 0 {
+			fmt.Println(usage)
+			return
+		}
+		limit = n
+	} else if len(args) > 1 {
+		fmt.Println(usage)
+		return
+	}
+
+	entries, ok := fetchReflog(stream, state.currentRepo, limit)
+	if !ok {
+		return
+	}
+	color.Cyan("--- Reflog ---")
+	if len(entries) == 0 {
+		fmt.Println("Reflog is empty.")
+	}
+	for _, e := range entries {
+		printReflogEntry(e)
+	}
+	color.Cyan("--------------")
+}
+
+func fetchReflog(stream network.Stream, repoAlias string, limit int) ([]protocol.ReflogEntry, bool) {
+	reqPayload := protocol.ReflogRequestPayload{RepoPath: repoAlias, Limit: limit}
+	payloadBytes, _ := json.Marshal(reqPayload)
+	req := &protocol.Message{Type: protocol.TypeReflogRequest, Payload: payloadBytes}
+	protocol.WriteMessage(stream, req)
+
+	resp, err := protocol.ReadMessage(stream)
+	if err != nil {
+		color.Red("Error reading reflog response: %v", err)
+		return nil, false
+	}
+	var respPayload protocol.ReflogResponsePayload
+	json.Unmarshal(resp.Payload, &respPayload)
+	if !respPayload.Success {
+		color.Red("Error from daemon: %s", respPayload.Error)
+		return nil, false
+	}
+	return respPayload.Entries, true
+}
+
+func printReflogEntry(e protocol.ReflogEntry) {
+	fmt.Printf("%s %s %s %s %s\n",
+		color.YellowString("%d:", e.Index),
+		color.YellowString("%.7s", e.Hash),
+		color.GreenString("[%s]", e.Action),
+		e.Message,
+		color.BlueString("(%s)", e.Date.Local().Format("2006-01-02 15:04")))
+}
+
+func handleResetToReflog(stream network.Stream, state *clientState, index int) {
+	// Look the entry up first so the user confirms against the actual commit
+	entries, ok := fetchReflog(stream, state.currentRepo, index+1)
+	if !ok {
+		return
+	}
+	if index >= len(entries) {
+		color.Red("No reflog entry %d (the reflog has %d entries).", index, len(entries))
+		return
+	}
+	entry := entries[index]
+	printReflogEntry(entry)
+	color.Red("WARNING: This hard-resets the current branch to %.7s and discards all uncommitted changes on the daemon.", entry.Hash)
+	if !confirmRepoName(state.currentRepo) {
+		fmt.Println("Reset aborted.")
+		return
+	}
+
+	// The daemon handles one request per stream, so the reset needs a new one
+	resetStream, err := state.openStream(context.Background())
+	if err != nil {
+		color.Red("Error: could not create stream: %v", err)
+		return
+	}
+	defer resetStream.Close()
+
+	reqPayload := protocol.ResetToReflogRequestPayload{RepoPath: state.currentRepo, Index: index, Hash: entry.Hash, Confirm: state.currentRepo}
+	payloadBytes, _ := json.Marshal(reqPayload)
+	req := &protocol.Message{Type: protocol.TypeResetToReflogRequest, Payload: payloadBytes}
+	protocol.WriteMessage(resetStream, req)
+
+	resp, err := protocol.ReadMessage(resetStream)
+	if err != nil {
+		color.Red("Error reading reset response: %v", err)
+		return
+	}
+	var respPayload protocol.ResetToReflogResponsePayload
+	json.Unmarshal(resp.Payload, &respPayload)
+	if !respPayload.Success {
+		color.Red("Reset failed: %s", respPayload.Output)
 	} else {
 		color.Green("--- Reset Result ---")
 		fmt.Print(respPayload.Output)
@@ -903,6 +3362,131 @@ func handleGitReset(stream network.Stream, repoAlias string) {
 	}
 }
 
+// handleGitClean always runs a dry run first, shows what would be deleted,
+// and only then sends the forced clean with the dry run's confirmation token.
+func handleGitClean(stream network.Stream, state *clientState, dirs, ignored bool) {
+	reqPayload := protocol.GitCleanRequestPayload{
+		RepoPath:       state.currentRepo,
+		Directories:    dirs,
+		IncludeIgnored: ignored,
+	}
+	payloadBytes, _ := json.Marshal(reqPayload)
+	req := &protocol.Message{Type: protocol.TypeGitCleanRequest, Payload: payloadBytes}
+	protocol.WriteMessage(stream, req)
+
+	resp, err := protocol.ReadMessage(stream)
+	if err != nil {
+		color.Red("Error reading clean response: %v", err)
+		return
+	}
+	var preview protocol.GitCleanResponsePayload
+	json.Unmarshal(resp.Payload, &preview)
+	if !preview.Success {
+		color.Red("Error from daemon: %s", preview.Output)
+		return
+	}
+	if len(preview.Paths) == 0 {
+		fmt.Println("Nothing to clean.")
+		return
+	}
+
+	color.Cyan("--- Would remove ---")
+	for _, path := range preview.Paths {
+		color.Yellow(path)
+	}
+	color.Cyan("--------------------")
+	color.Red("WARNING: This permanently deletes %d untracked path(s) on the daemon.", len(preview.Paths))
+	if !confirmRepoName(state.currentRepo) {
+		fmt.Println("Clean aborted.")
+		return
+	}
+
+	// The daemon handles one request per stream, so the forced clean needs a new one
+	forceStream, err := state.openStream(context.Background())
+	if err != nil {
+		color.Red("Error: could not create stream: %v", err)
+		return
+	}
+	defer forceStream.Close()
+
+	reqPayload.Force = true
+	reqPayload.ConfirmToken = preview.ConfirmToken
+	reqPayload.Confirm = state.currentRepo
+	payloadBytes, _ = json.Marshal(reqPayload)
+	req = &protocol.Message{Type: protocol.TypeGitCleanRequest, Payload: payloadBytes}
+	protocol.WriteMessage(forceStream, req)
+
+	resp, err = protocol.ReadMessage(forceStream)
+	if err != nil {
+		color.Red("Error reading clean response: %v", err)
+		return
+	}
+	var respPayload protocol.GitCleanResponsePayload
+	json.Unmarshal(resp.Payload, &respPayload)
+	if !respPayload.Success {
+		color.Red("Clean failed:\n%s", respPayload.Output)
+	} else {
+		color.Green("--- Clean Result ---")
+		fmt.Print(respPayload.Output)
+		color.Green("--------------------")
+	}
+}
+
+func handleListWorktrees(stream network.Stream, repo string) {
+	reqPayload := protocol.ListWorktreesRequestPayload{RepoPath: repo}
+	payloadBytes, _ := json.Marshal(reqPayload)
+	req := &protocol.Message{Type: protocol.TypeListWorktreesRequest, Payload: payloadBytes}
+	protocol.WriteMessage(stream, req)
+
+	resp, err := protocol.ReadMessage(stream)
+	if err != nil {
+		color.Red("Error reading worktrees response: %v", err)
+		return
+	}
+	var respPayload protocol.ListWorktreesResponsePayload
+	json.Unmarshal(resp.Payload, &respPayload)
+	if !respPayload.Success {
+		color.Red("Error from daemon: %s", respPayload.Error)
+		return
+	}
+
+	base, _, _ := strings.Cut(repo, "/")
+	color.Cyan("--- Worktrees ---")
+	for _, wt := range respPayload.Worktrees {
+		target := base
+		if wt.Name != "" {
+			target = base + "/" + wt.Name
+		}
+		branch := wt.Branch
+		if wt.Detached {
+			branch = "(detached)"
+		}
+		fmt.Printf("%-30s %-20s %s\n", color.YellowString(target), branch, wt.Path)
+	}
+	color.Cyan("-----------------")
+	fmt.Println("Use 'use <alias>/<name>' to work in a linked worktree.")
+}
+
+func handleAddWorktree(stream network.Stream, reqPayload protocol.AddWorktreeRequestPayload) {
+	payloadBytes, _ := json.Marshal(reqPayload)
+	req := &protocol.Message{Type: protocol.TypeAddWorktreeRequest, Payload: payloadBytes}
+	protocol.WriteMessage(stream, req)
+
+	resp, err := protocol.ReadMessage(stream)
+	if err != nil {
+		color.Red("Error reading worktree response: %v", err)
+		return
+	}
+	var respPayload protocol.AddWorktreeResponsePayload
+	json.Unmarshal(resp.Payload, &respPayload)
+	if !respPayload.Success {
+		color.Red("Failed to add worktree:\n%s", respPayload.Output)
+		return
+	}
+	base, _, _ := strings.Cut(reqPayload.RepoPath, "/")
+	color.Green("Worktree created. Switch to it with 'use %s/%s'", base, respPayload.Name)
+}
+
 func printHelp() {
 	fmt.Println("Available commands:")
 	c := color.New(color.FgYellow)
@@ -911,28 +3495,107 @@ func printHelp() {
 	c.Println("  help          ", d.Sprint("Show this help message"))
 	c.Println("  ls-repos      ", d.Sprint("List available repositories on the daemon"))
 	c.Println("  use <repo>    ", d.Sprint("Switch context to a repository"))
-	c.Println("  ls            ", d.Sprint("List files in the current repository"))
+	c.Println("  connect <daemon> ", d.Sprint("Switch to another linked daemon, back in the repo last used there"))
+	c.Println("  ls [dir]      ", d.Sprint("List one directory of the current repository (default: the root)"))
+	c.Println("  ls -l [dir]   ", d.Sprint("Long listing with mode, size, modification time and git status"))
+	c.Println("  ls --recursive [dir] ", d.Sprint("List every file in the repository, or under dir"))
+	c.Println("  ls <glob>...  ", d.Sprint("List files matching globs, e.g. ls src/**/*.go (** matches any directories)"))
+	c.Println("  ls -a ...     ", d.Sprint("Also list files ignored by .gitignore (works with the other ls forms)"))
 	c.Println("  cat <file>    ", d.Sprint("Display content of a remote file"))
+	c.Println("  cat <file>:100-160 ", d.Sprint("Display only some lines (file:100- to the end, file:100 just one)"))
+	c.Println("  cat --hex <file> ", d.Sprint("Hexdump a small binary file (cat only describes binary files)"))
+	c.Println("  grep [-i] <pattern> [path...] ", d.Sprint("Search file contents for a regular expression (-i ignores case)"))
 	c.Println("  edit <file>   ", d.Sprint("Download, edit, and upload a file"))
 	c.Println("  rename <old> <new> ", d.Sprint("Rename a remote file"))
+	c.Println("  chmod +x|-x <file> ", d.Sprint("Make a file executable or not (an octal mode like 644 also works)"))
+	c.Println("  tail [-n N] <file> ", d.Sprint("Show the last lines of a file and follow it as it grows (Ctrl+C stops)"))
+	c.Println("  watch              ", d.Sprint("Show commits, branch changes and changed files as they happen, in the current repo or every repo (Ctrl+C stops)"))
+	c.Println("  batch <file>  ", d.Sprint("Apply the writes, renames and deletes listed in a local file, all or nothing"))
 	c.Println("  branch <name> ", d.Sprint("Create a new branch on the daemon"))
 	c.Println("  commit <msg>  ", d.Sprint("Commit all changes in the repo and push to the current branch"))
+	c.Println("  commit --amend [msg] ", d.Sprint("Amend the last commit (--force to rewrite a pushed commit)"))
+	c.Println("  commit --author=\"Name <email>\" --signoff <msg> ", d.Sprint("Override the author and add a sign-off"))
+	c.Println("  commit --sign <msg>  ", d.Sprint("Create a GPG/SSH signed commit with the repo's signing key"))
+	c.Println("  commit        ", d.Sprint("Without a message, write one in $EDITOR starting from the repo's template"))
+	c.Println("  commit --dry-run ", d.Sprint("Show which files a commit would include, without committing"))
+	c.Println("  commit --set-upstream <msg> ", d.Sprint("Push with -u when the branch has no upstream yet"))
+	c.Println("  commit --staged <msg> ", d.Sprint("Commit only what is staged (see 'stage') instead of all changes"))
+	c.Println("  commit --allow-secrets <msg> ", d.Sprint("Commit even if the daemon's secret scan finds credentials"))
+	c.Println("  commit --background <msg> ", d.Sprint("Commit and push as a job, without waiting for the push (see jobs)"))
+	c.Println("  commit --remote=<name> <msg> ", d.Sprint("Push to this remote instead of the repo's default"))
+	c.Println("  remotes       ", d.Sprint("List the repo's remotes and the default push remote"))
+	c.Println("  ignore [--untrack] <pattern> ", d.Sprint("Add a pattern to .gitignore (--untrack stops tracking matching files)"))
+	c.Println("  unignore <pattern> ", d.Sprint("Remove a pattern from .gitignore"))
+	c.Println("  config [key]  ", d.Sprint("Show the repo's git settings (user.name, pull.rebase, ...) or one of them"))
+	c.Println("  config <key> <value> ", d.Sprint("Set a git setting in the repo's local config (--unset <key> removes it)"))
+	c.Println("  remote default <name> ", d.Sprint("Make <name> the remote commits are pushed to"))
+	c.Println("  hunks <file>  ", d.Sprint("List the unstaged hunks of a file with their IDs"))
+	c.Println("  stage <file> <hunk-id>... ", d.Sprint("Stage only the chosen hunks of a file, like git add -p"))
 	c.Println("  branches      ", d.Sprint("List branches in the current repository"))
 	c.Println("  switch <name> ", d.Sprint("Switch to a different branch"))
 	c.Println("  link <alias> <path>  ", d.Sprint("Dynamically link a new repository on the daemon"))
+	c.Println("  rename-repo <alias> <new-alias>", d.Sprint("Rename a linked repository, keeping its settings (admin only)"))
+	c.Println("  peers         ", d.Sprint("List the peers the daemon trusts and their roles (admin only)"))
+	c.Println("  revoke <peer-id> ", d.Sprint("Revoke a peer's trust; a unique prefix of its ID is enough (admin only)"))
+	c.Println("  pairing [minutes] ", d.Sprint("Let new clients connect to a gated daemon to pair, 10 minutes by default (admin only)"))
+	c.Println("  block [<peer-id|ip|cidr>] ", d.Sprint("Refuse all connections from a peer or address range; without one, list the blocklist (admin only)"))
+	c.Println("  unblock <entry> ", d.Sprint("Remove a peer or address range from the blocklist (admin only)"))
+	c.Println("  status-daemon ", d.Sprint("Show the daemon's version, uptime, linked repos, peers and current load"))
+	c.Println("  jobs [--all]  ", d.Sprint("List the daemon's running jobs, like pushes, or its recent ones too"))
+	c.Println("  job <id> [--follow] ", d.Sprint("Show a job's state and output, or follow it until it ends"))
+	c.Println("  job cancel <id> ", d.Sprint("Cancel a job you started (admins can cancel any)"))
+	c.Println("  net-status    ", d.Sprint("Show whether the daemon is publicly reachable, behind NAT or relay-only, and its addresses"))
+	c.Println("  net-stats     ", d.Sprint("Show bytes sent and received per peer and protocol, and whether connections are direct or relayed"))
+	c.Println("  ping [count]  ", d.Sprint("Measure the round-trip time to the daemon over the application protocol (4 pings by default)"))
+	c.Println("  approvals     ", d.Sprint("Approve or reject the destructive requests other devices send, with -confirm-destructive admin (admin only)"))
+	c.Println("  readonly [on|off] ", d.Sprint("Show or toggle the daemon's read-only mode, which refuses all changes (admin only)"))
+	c.Println("  audit [--peer=<id>] [--type=<request>] [--repo=<alias>] [--since=24h] [--limit=N] ", d.Sprint("Show what peers asked the daemon to do (admin only)"))
+	c.Println("  audit --export=<file> ", d.Sprint("Save the matching audit entries to a local JSONL file"))
 	c.Println("  status        ", d.Sprint("Show the working tree status on the daemon"))
-	c.Println("  log           ", d.Sprint("Show recent commit history"))
+	c.Println("  log [--signatures] ", d.Sprint("Show recent commit history, optionally with signature status"))
+	c.Println("  history <file>", d.Sprint("Show who changed a file and when, following renames"))
 	c.Println("  diff [file]   ", d.Sprint("Show changes between commits, commit and working tree, etc"))
-	c.Println("  stash         ", d.Sprint("Stash changes in the current repository"))
+	c.Println("  diff --stat [file] ", d.Sprint("Summarize insertions/deletions per changed file"))
+	c.Println("  diff --word-diff -w --context=<n> [file] ", d.Sprint("Word-level diff, ignore whitespace, set context lines"))
+	c.Println("  stash [save <msg>] ", d.Sprint("Stash changes in the current repository"))
+	c.Println("  stash list    ", d.Sprint("List stashes"))
+	c.Println("  stash apply|pop [n] ", d.Sprint("Apply (and for pop, remove) stash n, default the latest"))
+	c.Println("  stash drop <n>", d.Sprint("Delete stash n"))
 	c.Println("  stash-pop     ", d.Sprint("Apply the most recent stash"))
 	c.Println("  reset         ", d.Sprint("Discard all local changes (DESTRUCTIVE)"))
+	c.Println("  new <path>    ", d.Sprint("Create an empty file (and its directories) and stage it"))
+	c.Println("  mkdir <path>  ", d.Sprint("Create a directory and any missing parents"))
+	c.Println("  rm [--force] <path> ", d.Sprint("Delete a file or directory with git rm (--force for untracked or modified files)"))
+	c.Println("  discard <file>", d.Sprint("Discard local changes to a single file (DESTRUCTIVE)"))
+	c.Println("  reflog [n]    ", d.Sprint("Show the last n HEAD reflog entries (default 20)"))
+	c.Println("  reflog reset <n> ", d.Sprint("Hard-reset the branch to reflog entry n to recover lost work (DESTRUCTIVE)"))
+	c.Println("  clean [--dirs] [--ignored] ", d.Sprint("Preview and delete untracked files (DESTRUCTIVE)"))
+	c.Println("  worktrees     ", d.Sprint("List worktrees of the current repository"))
+	c.Println("  worktree add <branch> [path] [--new] ", d.Sprint("Check out a branch in a separate worktree"))
+	c.Println("  conflicts     ", d.Sprint("Show conflicted files with their ours/base/theirs hunks"))
+	c.Println("  resolve <file> ours|theirs|edit ", d.Sprint("Resolve a conflicted file"))
+	c.Println("  continue      ", d.Sprint("Finish the merge/rebase once all conflicts are resolved"))
+	c.Println("  hook pre-commit|pre-push ", d.Sprint("Run a repository hook on the daemon and show its output"))
+	c.Println("  bisect start <good> [bad] ", d.Sprint("Hunt for the commit that introduced a regression"))
+	c.Println("  bisect good|bad [commit] ", d.Sprint("Mark the checked out commit and move to the next candidate"))
+	c.Println("  bisect reset  ", d.Sprint("End the bisect and return to the original branch"))
+	c.Println("  stats [--all] ", d.Sprint("Show size, activity and top contributors (every repo with --all)"))
+	c.Println("  bundle create <file> [refs...] ", d.Sprint("Download a git bundle of the repo (default all refs)"))
+	c.Println("  bundle fetch <file> ", d.Sprint("Upload a bundle and fetch it into refs/remotes/bundle/*"))
+	c.Println("  download <remote> <local> ", d.Sprint("Copy a file from the repo to this machine (any size, binary is fine)"))
+	c.Println("  upload <local> <remote> ", d.Sprint("Copy a local file into the repo (a trailing / keeps the file name)"))
+	c.Println("  <cmd> -o json ", d.Sprint("Print the result as JSON (ls-repos, ls, branches, status, log, history, grep, remotes, peers, jobs, job, status-daemon, net-status)"))
 	c.Println("  exit, quit    ", d.Sprint("Close the application"))
 }
 
 func (s *clientState) changeLivePrefix() (string, bool) {
-	s.livePrefix = fmt.Sprintf("p2p-git(%s @ %s)> ", s.currentRepo, s.currentBranch)
+	branch := s.currentBranch
+	if s.statusBadge != "" {
+		branch = s.statusBadge
+	}
+	s.livePrefix = fmt.Sprintf("p2p-git@%s(%s @ %s)> ", s.daemonName, s.currentRepo, branch)
 	if s.currentRepo == "" {
-		s.livePrefix = "p2p-git(no repo)> "
+		s.livePrefix = fmt.Sprintf("p2p-git@%s(no repo)> ", s.daemonName)
 	}
 	return s.livePrefix, true
 }
@@ -941,10 +3604,16 @@ func completer(d prompt.Document) []prompt.Suggest {
 	// Simple completer
 	s := []prompt.Suggest{
 		{Text: "help", Description: "Show help"},
+		{Text: "connect", Description: "Switch to another linked daemon"},
 		{Text: "ls-repos", Description: "List available repositories"},
 		{Text: "use", Description: "Switch to a repository context. Usage: use <repo-alias>"},
-		{Text: "ls", Description: "List files in the current repository"},
+		{Text: "ls", Description: "List a directory of the current repository"},
 		{Text: "cat", Description: "Display the content of a remote file"},
+		{Text: "grep", Description: "Search file contents for a regular expression"},
+		{Text: "chmod", Description: "Change a file's mode. Usage: chmod +x|-x <file>"},
+		{Text: "tail", Description: "Follow a file as it grows, like tail -f. Usage: tail [-n <lines>] <file>"},
+		{Text: "watch", Description: "Show commits, branch changes and changed files live"},
+		{Text: "batch", Description: "Apply the writes, renames and deletes of a local batch file, all or nothing"},
 		{Text: "edit", Description: "Edit a remote file locally"},
 		{Text: "rename", Description: "Rename a file. Usage: rename <old> <new>"},
 		{Text: "branch", Description: "Create a new git branch"},
@@ -952,17 +3621,123 @@ func completer(d prompt.Document) []prompt.Suggest {
 		{Text: "branches", Description: "List branches in the current repository"},
 		{Text: "switch", Description: "Switch to a different branch"},
 		{Text: "link", Description: "Link a new repository on the daemon"},
+		{Text: "rename-repo", Description: "Rename a linked repository. Usage: rename-repo <alias> <new-alias>"},
+		{Text: "peers", Description: "List the daemon's trusted peers (admin only)"},
+		{Text: "revoke", Description: "Revoke a trusted peer. Usage: revoke <peer-id>"},
+		{Text: "pairing", Description: "Open a pairing window on a gated daemon. Usage: pairing [minutes]"},
+		{Text: "block", Description: "Block a peer or address range, or list the blocklist. Usage: block [<peer-id|ip|cidr>]"},
+		{Text: "unblock", Description: "Remove an entry from the blocklist. Usage: unblock <entry>"},
+		{Text: "status-daemon", Description: "Show the daemon's version, uptime and load"},
+		{Text: "jobs", Description: "List running jobs. Usage: jobs [--all]"},
+		{Text: "job", Description: "Show, follow or cancel a job. Usage: job <id> [--follow] | job cancel <id>"},
+		{Text: "net-status", Description: "Show the daemon's reachability and addresses"},
+		{Text: "net-stats", Description: "Show this client's traffic and connections"},
+		{Text: "ping", Description: "Measure the round-trip time to the daemon. Usage: ping [count]"},
+		{Text: "approvals", Description: "Approve destructive requests sent from other devices (admin only)"},
+		{Text: "readonly", Description: "Show or toggle the daemon's read-only mode. Usage: readonly [on|off]"},
+		{Text: "audit", Description: "Show or export the daemon's audit log (admin only)"},
 		{Text: "status", Description: "Show the daemon's git status"},
 		{Text: "log", Description: "Show recent commit history"},
+		{Text: "history", Description: "Show a file's commit history. Usage: history <file>"},
 		{Text: "diff", Description: "Show changes to files"},
 		{Text: "stash", Description: "Stash changes in the current repository"},
 		{Text: "stash-pop", Description: "Apply the most recent stash"},
 		{Text: "reset", Description: "Discard all local changes (DESTRUCTIVE)"},
+		{Text: "config", Description: "Get or set the repo's git config (user.name, pull.rebase, ...)"},
+		{Text: "ignore", Description: "Add a pattern to .gitignore"},
+		{Text: "unignore", Description: "Remove a pattern from .gitignore"},
+		{Text: "remotes", Description: "List remotes and the default push remote"},
+		{Text: "remote", Description: "Set the default push remote with 'remote default <name>'"},
+		{Text: "hunks", Description: "List the unstaged hunks of a file"},
+		{Text: "stage", Description: "Stage selected hunks of a file"},
+		{Text: "new", Description: "Create and stage a new file"},
+		{Text: "mkdir", Description: "Create a directory"},
+		{Text: "rm", Description: "Delete a file or directory (git rm)"},
+		{Text: "discard", Description: "Discard local changes to a single file (DESTRUCTIVE)"},
+		{Text: "reflog", Description: "Show the HEAD reflog, or reset to an entry with 'reflog reset <n>'"},
+		{Text: "clean", Description: "Delete untracked files after a dry run (DESTRUCTIVE)"},
+		{Text: "worktrees", Description: "List worktrees of the current repository"},
+		{Text: "worktree", Description: "Add a worktree. Usage: worktree add <branch> [path] [--new]"},
+		{Text: "conflicts", Description: "Show merge conflicts"},
+		{Text: "resolve", Description: "Resolve a conflict. Usage: resolve <file> ours|theirs|edit"},
+		{Text: "continue", Description: "Finish the merge once conflicts are resolved"},
+		{Text: "hook", Description: "Run a hook. Usage: hook pre-commit|pre-push"},
+		{Text: "bisect", Description: "Find a regression. Usage: bisect start|good|bad|reset"},
+		{Text: "stats", Description: "Show repository size and activity. Usage: stats [--all]"},
+		{Text: "bundle", Description: "Sync via git bundles. Usage: bundle create|fetch <file>"},
+		{Text: "download", Description: "Copy a repo file here. Usage: download <remote> <local>"},
+		{Text: "upload", Description: "Copy a local file into the repo. Usage: upload <local> <remote>"},
 		{Text: "exit", Description: "Exit the shell"},
 	}
 	return prompt.FilterHasPrefix(s, d.GetWordBeforeCursor(), true)
 }
 
+// splitArgs splits a command line on whitespace, keeping single- or
+// double-quoted sections together (e.g. commit "Fix the build").
+func splitArgs(s string) []string {
+	var args []string
+	var current strings.Builder
+	var quote rune
+	inArg := false
+	for _, r := range s {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote = r
+			inArg = true
+		case r == ' ' || r == '\t':
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteRune(r)
+			inArg = true
+		}
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+	return args
+}
+
+// parseAuthor splits a git-style "Name <email>" string.
+func parseAuthor(author string) (string, string, error) {
+	open := strings.Index(author, "<")
+	end := strings.LastIndex(author, ">")
+	if open < 0 || end < open {
+		return "", "", fmt.Errorf("author must look like \"Name <email>\"")
+	}
+	name := strings.TrimSpace(author[:open])
+	email := strings.TrimSpace(author[open+1 : end])
+	if name == "" || email == "" {
+		return "", "", fmt.Errorf("author must look like \"Name <email>\"")
+	}
+	return name, email, nil
+}
+
+// splitFlags separates `--name` and `--name=value` arguments from the rest of
+// a command line. Flags without a value map to an empty string.
+func splitFlags(args []string) (map[string]string, []string) {
+	flags := make(map[string]string)
+	var rest []string
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "--") || arg == "--" {
+			rest = append(rest, arg)
+			continue
+		}
+		name, value, _ := strings.Cut(strings.TrimPrefix(arg, "--"), "=")
+		flags[name] = value
+	}
+	return flags, rest
+}
+
 func handleUseRepo(stream network.Stream, state *clientState, repoAlias string) {
 	// We validate the repo by asking for its branches. If this succeeds, the repo exists.
 	reqPayload := protocol.ListBranchesRequestPayload{RepoPath: repoAlias}
@@ -987,6 +3762,15 @@ func handleUseRepo(stream network.Stream, state *clientState, repoAlias string)
 	} else {
 		// Only change state on success!
 		state.currentRepo = repoAlias
-		fmt.Printf("Switched to repo: %s\n", state.currentRepo)
+		state.currentBranch = respPayload.Current
+		if state.currentBranch == "" {
+			// Older daemons don't report HEAD
+			state.currentBranch = respPayload.Default
+		}
+		fmt.Printf("Switched to repo: %s (on %s", state.currentRepo, state.currentBranch)
+		if respPayload.Default != "" && respPayload.Default != state.currentBranch {
+			fmt.Printf(", default branch %s", respPayload.Default)
+		}
+		fmt.Println(")")
 	}
 }
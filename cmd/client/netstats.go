@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/fatih/color"
+	"github.com/libp2p/go-libp2p/core/metrics"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/multiformats/go-multiaddr"
+)
+
+// handleNetStats prints how much this client has sent and received, per peer
+// and per protocol, and how it's connected, to explain a slow link: a relayed
+// connection, say, or many streams at once.
+func handleNetStats(state *clientState) {
+	if state.bandwidth == nil {
+		color.Red("Bandwidth isn't being counted.")
+		return
+	}
+	fmt.Printf("Total: %s\n", formatTraffic(state.bandwidth.GetBandwidthTotals()))
+
+	fmt.Println("Per peer:")
+	byPeer := state.bandwidth.GetBandwidthByPeer()
+	peers := make([]peer.ID, 0, len(byPeer))
+	for id := range byPeer {
+		peers = append(peers, id)
+	}
+	sort.Slice(peers, func(i, j int) bool { return peers[i] < peers[j] })
+	for _, id := range peers {
+		label := id.String()
+		if id == state.daemonInfo.ID {
+			label += " (daemon)"
+		}
+		fmt.Printf("  %s: %s\n", label, formatTraffic(byPeer[id]))
+		for _, conn := range state.p2pHost.Network().ConnsToPeer(id) {
+			how := "direct"
+			if _, err := conn.RemoteMultiaddr().ValueForProtocol(multiaddr.P_CIRCUIT); err == nil {
+				how = "relayed"
+			}
+			fmt.Printf("      %s over %s, %d open stream(s)\n", how, conn.RemoteMultiaddr(), len(conn.GetStreams()))
+		}
+	}
+
+	fmt.Println("Per protocol:")
+	byProtocol := state.bandwidth.GetBandwidthByProtocol()
+	protocols := make([]protocol.ID, 0, len(byProtocol))
+	for proto := range byProtocol {
+		protocols = append(protocols, proto)
+	}
+	sort.Slice(protocols, func(i, j int) bool { return protocols[i] < protocols[j] })
+	for _, proto := range protocols {
+		fmt.Printf("  %s: %s\n", proto, formatTraffic(byProtocol[proto]))
+	}
+}
+
+// formatTraffic renders bytes in and out, with the current rates.
+func formatTraffic(stats metrics.Stats) string {
+	return fmt.Sprintf("%s in, %s out (%s/s in, %s/s out)",
+		formatBytes(stats.TotalIn), formatBytes(stats.TotalOut),
+		formatBytes(int64(stats.RateIn)), formatBytes(int64(stats.RateOut)))
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/fatih/color"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/multiformats/go-multiaddr"
+
+	p2p "github.com/hemantsingh443/p2p-git-remote/internal/p2p"
+	"github.com/hemantsingh443/p2p-git-remote/internal/protocol"
+)
+
+// handleNetStatus prints how reachable the daemon is and how this client is
+// connected to it, for debugging connection problems.
+func handleNetStatus(stream network.Stream) {
+	payloadBytes, _ := json.Marshal(protocol.NetStatusRequestPayload{})
+	req := &protocol.Message{Type: protocol.TypeNetStatusRequest, Payload: payloadBytes}
+	protocol.WriteMessage(stream, req)
+
+	resp, err := protocol.ReadMessage(stream)
+	if err != nil {
+		color.Red("Error reading net-status response: %v", err)
+		return
+	}
+	var respPayload protocol.NetStatusResponsePayload
+	json.Unmarshal(resp.Payload, &respPayload)
+	if printJSON(respPayload) {
+		return
+	}
+	if !respPayload.Success {
+		color.Red("Error from daemon: %s", respPayload.Error)
+		return
+	}
+
+	switch respPayload.Status {
+	case p2p.StatusPublic:
+		color.Green("The daemon is publicly reachable.")
+	case p2p.StatusRelayOnly:
+		color.Yellow("The daemon is only reachable through relays; hole punching may upgrade connections.")
+	case p2p.StatusBehindNAT:
+		color.Yellow("The daemon is behind NAT: peers outside its network can't dial it. Give it -relays to fix that.")
+	default:
+		fmt.Println("The daemon doesn't know yet whether it's reachable; AutoNAT needs a few peers to ask.")
+	}
+	printAddrs("Listening on", respPayload.ListenAddrs)
+	printAddrs("Seen by other peers as", respPayload.ObservedAddrs)
+	printAddrs("Relayed through", respPayload.RelayAddrs)
+	printAddrs("Confirmed reachable", respPayload.ReachableAddrs)
+	printAddrs("Confirmed unreachable", respPayload.UnreachableAddrs)
+
+	// And how we reach it
+	conn := stream.Conn()
+	how := "directly"
+	if _, err := conn.RemoteMultiaddr().ValueForProtocol(multiaddr.P_CIRCUIT); err == nil {
+		how = "through a relay"
+	}
+	fmt.Printf("This client is connected %s, to %s\n", how, conn.RemoteMultiaddr())
+}
+
+func printAddrs(title string, addrs []string) {
+	if len(addrs) == 0 {
+		return
+	}
+	fmt.Printf("%s:\n", title)
+	for _, addr := range addrs {
+		fmt.Printf("  %s\n", addr)
+	}
+}
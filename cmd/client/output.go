@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/fatih/color"
+)
+
+// jsonCommands are the commands that can print their results as JSON.
+var jsonCommands = map[string]bool{
+	"ls-repos": true, "ls": true, "branches": true, "status": true, "log": true,
+	"history": true, "grep": true, "remotes": true, "peers": true, "jobs": true,
+	"job": true, "status-daemon": true, "net-status": true,
+}
+
+// jsonOut is where results go as JSON while a command runs with -o json,
+// nil otherwise. Everything else the command prints goes to stderr then, so
+// stdout only holds JSON.
+var (
+	jsonOut io.Writer
+	stdout  = os.Stdout
+)
+
+// takeOutputFormat takes "-o json" or "--json" off a command's arguments,
+// reporting whether it was there.
+func takeOutputFormat(args []string) (asJSON bool, rest []string, err error) {
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "--json" || args[i] == "-o=json" || args[i] == "--output=json":
+			asJSON = true
+		case args[i] == "-o" && i+1 < len(args):
+			if args[i+1] != "json" {
+				return false, nil, fmt.Errorf("unknown output format %q, only json is supported", args[i+1])
+			}
+			asJSON = true
+			i++
+		default:
+			rest = append(rest, args[i])
+		}
+	}
+	return asJSON, rest, nil
+}
+
+// startJSONOutput sends the text printed from now on to stderr, keeping
+// stdout for JSON, and returns the function putting things back.
+func startJSONOutput() (stop func()) {
+	prevOut, prevColorOut, prevJSONOut := os.Stdout, color.Output, jsonOut
+	jsonOut = stdout
+	os.Stdout, color.Output = os.Stderr, color.Error
+	return func() {
+		os.Stdout, color.Output, jsonOut = prevOut, prevColorOut, prevJSONOut
+	}
+}
+
+// printJSON prints a response as one line of JSON, if the command runs with
+// -o json, and reports whether it did, so the caller skips its text.
+func printJSON(v any) bool {
+	if jsonOut == nil {
+		return false
+	}
+	out, err := json.Marshal(v)
+	if err != nil {
+		color.Red("Error encoding the response: %v", err)
+		return true
+	}
+	fmt.Fprintln(jsonOut, string(out))
+	return true
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	fdiff "github.com/go-git/go-git/v5/plumbing/format/diff"
+	"github.com/go-git/go-git/v5/utils/diff"
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// unifiedDiff returns a git-style unified diff with 3 lines of context that
+// turns oldContent into newContent, for `git apply` on the daemon.
+func unifiedDiff(path, oldContent, newContent string) (string, error) {
+	var chunks []fdiff.Chunk
+	for _, d := range diff.Do(oldContent, newContent) {
+		op := fdiff.Equal
+		switch d.Type {
+		case diffmatchpatch.DiffInsert:
+			op = fdiff.Add
+		case diffmatchpatch.DiffDelete:
+			op = fdiff.Delete
+		}
+		chunks = append(chunks, textChunk{content: d.Text, op: op})
+	}
+	p := textPatch{
+		from:   textFile{path: path, content: oldContent},
+		to:     textFile{path: path, content: newContent},
+		chunks: chunks,
+	}
+
+	var b strings.Builder
+	if err := fdiff.NewUnifiedEncoder(&b, fdiff.DefaultContextLines).Encode(p); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// The types below adapt a single text file change to go-git's patch
+// interfaces, so its unified encoder can write the diff.
+
+type textPatch struct {
+	from, to textFile
+	chunks   []fdiff.Chunk
+}
+
+func (p textPatch) FilePatches() []fdiff.FilePatch { return []fdiff.FilePatch{p} }
+func (p textPatch) Message() string                { return "" }
+func (p textPatch) IsBinary() bool                 { return false }
+func (p textPatch) Files() (from, to fdiff.File)   { return p.from, p.to }
+func (p textPatch) Chunks() []fdiff.Chunk          { return p.chunks }
+
+type textFile struct{ path, content string }
+
+func (f textFile) Hash() plumbing.Hash {
+	return plumbing.ComputeHash(plumbing.BlobObject, []byte(f.content))
+}
+func (f textFile) Mode() filemode.FileMode { return filemode.Regular }
+func (f textFile) Path() string            { return f.path }
+
+type textChunk struct {
+	content string
+	op      fdiff.Operation
+}
+
+func (c textChunk) Content() string       { return c.content }
+func (c textChunk) Type() fdiff.Operation { return c.op }
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/libp2p/go-libp2p/core/network"
+
+	"github.com/hemantsingh443/p2p-git-remote/internal/protocol"
+)
+
+func handleListPeers(stream network.Stream) {
+	req := &protocol.Message{Type: protocol.TypeListPeersRequest, Payload: json.RawMessage("{}")}
+	protocol.WriteMessage(stream, req)
+
+	resp, err := protocol.ReadMessage(stream)
+	if err != nil {
+		color.Red("Error reading peer list: %v", err)
+		return
+	}
+	var respPayload protocol.ListPeersResponsePayload
+	json.Unmarshal(resp.Payload, &respPayload)
+	if printJSON(respPayload) {
+		return
+	}
+	if !respPayload.Success {
+		color.Red("Error from daemon: %s", respPayload.Error)
+		return
+	}
+
+	color.Cyan("--- Trusted Peers ---")
+	for _, p := range respPayload.Peers {
+		line := fmt.Sprintf("%-10s  %s", p.Role, p.ID)
+		switch {
+		case p.Expired:
+			line += color.RedString("  expired")
+		case !p.ExpiresAt.IsZero():
+			line += fmt.Sprintf("  until %s", p.ExpiresAt.Local().Format("2006-01-02"))
+		}
+		if p.Verified {
+			line += "  verified"
+		}
+		if p.Connected {
+			line += color.GreenString("  connected")
+		}
+		if p.Self {
+			line += color.YellowString("  (this client)")
+		}
+		fmt.Println(line)
+	}
+	color.Cyan("---------------------")
+}
+
+func handleRevokePeer(stream network.Stream, peerID string) {
+	color.Red("WARNING: the peer will lose access and has to pair again to reconnect.")
+	fmt.Print("Are you sure you want to proceed? (y/n): ")
+	reader := bufio.NewReader(os.Stdin)
+	answer, _ := reader.ReadString('\n')
+	if strings.TrimSpace(answer) != "y" {
+		fmt.Println("Revoke aborted.")
+		return
+	}
+
+	reqPayload := protocol.RevokePeerRequestPayload{PeerID: peerID}
+	payloadBytes, _ := json.Marshal(reqPayload)
+	req := &protocol.Message{Type: protocol.TypeRevokePeerRequest, Payload: payloadBytes}
+	protocol.WriteMessage(stream, req)
+
+	resp, err := protocol.ReadMessage(stream)
+	if err != nil {
+		color.Red("Error reading revoke response: %v", err)
+		return
+	}
+	var respPayload protocol.RevokePeerResponsePayload
+	json.Unmarshal(resp.Payload, &respPayload)
+	if !respPayload.Success {
+		color.Red("Error from daemon: %s", respPayload.Output)
+		return
+	}
+	color.Green(respPayload.Output)
+}
+
+// handlePairingWindow lets untrusted peers connect to a gated daemon for a
+// few minutes, so a new client can pair.
+func handlePairingWindow(stream network.Stream, minutes int) {
+	reqPayload := protocol.PairingWindowRequestPayload{Minutes: minutes}
+	payloadBytes, _ := json.Marshal(reqPayload)
+	req := &protocol.Message{Type: protocol.TypePairingWindowRequest, Payload: payloadBytes}
+	protocol.WriteMessage(stream, req)
+
+	resp, err := protocol.ReadMessage(stream)
+	if err != nil {
+		color.Red("Error reading pairing response: %v", err)
+		return
+	}
+	var respPayload protocol.PairingWindowResponsePayload
+	json.Unmarshal(resp.Payload, &respPayload)
+	if !respPayload.Success {
+		color.Red("Error from daemon: %s", respPayload.Error)
+		return
+	}
+	switch {
+	case !respPayload.Gated:
+		color.Yellow("The daemon isn't gated (-gate): untrusted peers can always connect to pair.")
+	case minutes == 0:
+		color.Green("Pairing window closed; only trusted peers can connect.")
+	default:
+		color.Green("New clients can connect to pair until %s.", respPayload.Until.Local().Format("15:04:05"))
+	}
+}
+
+// handleBlock adds an entry to the daemon's blocklist, or removes one, and
+// prints the list. An empty entry only lists it.
+func handleBlock(stream network.Stream, entry string, unblock bool) {
+	reqPayload := protocol.BlockRequestPayload{Entry: entry, Unblock: unblock}
+	payloadBytes, _ := json.Marshal(reqPayload)
+	req := &protocol.Message{Type: protocol.TypeBlockRequest, Payload: payloadBytes}
+	protocol.WriteMessage(stream, req)
+
+	resp, err := protocol.ReadMessage(stream)
+	if err != nil {
+		color.Red("Error reading blocklist response: %v", err)
+		return
+	}
+	var respPayload protocol.BlockResponsePayload
+	json.Unmarshal(resp.Payload, &respPayload)
+	if !respPayload.Success {
+		color.Red("Error from daemon: %s", respPayload.Output)
+		return
+	}
+	if respPayload.Output != "" {
+		color.Green(respPayload.Output)
+	}
+	if len(respPayload.Peers) == 0 && len(respPayload.Ranges) == 0 {
+		fmt.Println("The blocklist is empty.")
+		return
+	}
+	for _, p := range respPayload.Peers {
+		fmt.Printf("  peer   %s\n", p)
+	}
+	for _, r := range respPayload.Ranges {
+		fmt.Printf("  range  %s\n", r)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/libp2p/go-libp2p/core/network"
+
+	p2p "github.com/hemantsingh443/p2p-git-remote/internal/p2p"
+)
+
+// pingInterval is the time between two pings of the `ping` command.
+const pingInterval = time.Second
+
+// handlePing pings the daemon count times, a second apart, over the
+// application protocol, and prints the round-trip times like ping(8).
+// Ctrl+C stops early.
+func handlePing(stream network.Stream, count int) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	var rtts []time.Duration
+	for seq := 1; seq <= count && ctx.Err() == nil; seq++ {
+		rtt, err := p2p.Ping(stream, seq)
+		if err != nil {
+			color.Red("Ping %d failed: %v", seq, err)
+			break
+		}
+		rtts = append(rtts, rtt)
+		fmt.Printf("Reply from daemon: seq=%d time=%s\n", seq, formatRTT(rtt))
+		if seq < count {
+			select {
+			case <-ctx.Done():
+			case <-time.After(pingInterval):
+			}
+		}
+	}
+	if len(rtts) == 0 {
+		return
+	}
+
+	lowest, highest, sum := rtts[0], rtts[0], time.Duration(0)
+	for _, rtt := range rtts {
+		lowest, highest, sum = min(lowest, rtt), max(highest, rtt), sum+rtt
+	}
+	fmt.Printf("%d ping(s) answered, round trip min/avg/max = %s/%s/%s\n",
+		len(rtts), formatRTT(lowest), formatRTT(sum/time.Duration(len(rtts))), formatRTT(highest))
+}
+
+// formatRTT renders a round-trip time to a tenth of a millisecond.
+func formatRTT(rtt time.Duration) string {
+	return fmt.Sprintf("%.1fms", float64(rtt)/float64(time.Millisecond))
+}
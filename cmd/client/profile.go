@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/fatih/color"
+
+	"github.com/hemantsingh443/p2p-git-remote/internal/store"
+)
+
+// Files of a client profile, besides known_addrs.json and swarm.key.
+const (
+	configFile         = "config.json"
+	identityKeyFile    = "client_identity.key"
+	trustedDaemonsFile = "trusted_daemons.json"
+)
+
+var profileName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// clientCommand is how to run the client in the profile in use, for
+// messages telling what to run next.
+var clientCommand = "./client"
+
+// takeProfile takes --profile <name> off the front of the arguments. The
+// profile defaults to $P2P_GIT_PROFILE; "" is the default profile.
+func takeProfile(args []string) (profile string, rest []string) {
+	profile = os.Getenv("P2P_GIT_PROFILE")
+	switch {
+	case len(args) > 1 && args[0] == "--profile":
+		return args[1], args[2:]
+	case len(args) > 0 && strings.HasPrefix(args[0], "--profile="):
+		return strings.TrimPrefix(args[0], "--profile="), args[1:]
+	}
+	return profile, args
+}
+
+// clientDir is where the client keeps its files: ~/.p2p-git, or
+// $XDG_CONFIG_HOME/p2p-git when that is set and an older client didn't
+// create ~/.p2p-git already.
+func clientDir() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("could not find home directory: %w", err)
+	}
+	legacy := filepath.Join(home, ".p2p-git")
+	xdg := os.Getenv("XDG_CONFIG_HOME")
+	if !filepath.IsAbs(xdg) {
+		return legacy, nil
+	}
+	if _, err := os.Stat(legacy); err == nil {
+		return legacy, nil
+	}
+	return filepath.Join(xdg, "p2p-git"), nil
+}
+
+// profileDir creates and returns the directory of a profile, with its linked
+// daemons, identity key and trusted daemons: the client directory for the
+// default profile, profiles/<name> in it for the others.
+func profileDir(profile string) (string, error) {
+	dir, err := clientDir()
+	if err != nil {
+		return "", err
+	}
+	if profile != "" {
+		if !profileName.MatchString(profile) {
+			return "", fmt.Errorf("invalid profile name '%s': use letters, digits, '.', '_' and '-'", profile)
+		}
+		dir = filepath.Join(dir, "profiles", profile)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("could not create config directory: %w", err)
+	}
+	return dir, nil
+}
+
+// keychainName is what a profile's identity key is kept under in the OS
+// keychain. The default profile's is the one older clients used.
+func keychainName(profile string) string {
+	if profile == "" {
+		return identityKeyFile
+	}
+	return identityKeyFile + "@" + profile
+}
+
+// adoptWorkingDirFiles moves the identity key and trusted daemons, which
+// older clients kept in the directory they were started from, into the
+// default profile, so the client keeps its peer ID and pairings. Files the
+// profile has already are left alone.
+func adoptWorkingDirFiles(dir string) {
+	cwd, err := os.Getwd()
+	if err != nil || store.SameDir(cwd, dir) {
+		return
+	}
+	for _, name := range []string{identityKeyFile, trustedDaemonsFile} {
+		from, to := filepath.Join(cwd, name), filepath.Join(dir, name)
+		if _, err := os.Stat(from); err != nil {
+			continue
+		}
+		if _, err := os.Stat(to); err == nil {
+			color.Yellow("Ignoring %s, %s has its own.", from, dir)
+			continue
+		}
+		if err := store.MoveFile(from, to); err != nil {
+			color.Yellow("Could not move %s to %s: %v", from, dir, err)
+			continue
+		}
+		fmt.Printf("Moved %s to %s\n", name, dir)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// Progress bar drawing: how wide the bar is, and how often it's redrawn.
+const (
+	progressBarWidth = 30
+	progressInterval = 100 * time.Millisecond
+)
+
+// transferProgress draws a progress bar for a download or upload on the
+// terminal, with the throughput and, when the size is known, how long is
+// left. Off a terminal, in scripts, it draws nothing. A nil transferProgress
+// is ignored.
+type transferProgress struct {
+	label string
+	total int64 // 0 when unknown, as for bundles
+	done  int64
+	start time.Time
+	drawn time.Time
+	shown bool
+}
+
+func newTransferProgress(label string, total int64) *transferProgress {
+	if !term.IsTerminal(int(os.Stdout.Fd())) {
+		return nil
+	}
+	return &transferProgress{label: label, total: total, start: time.Now()}
+}
+
+// setTotal sets the size once the other side tells it.
+func (p *transferProgress) setTotal(total int64) {
+	if p != nil && total > 0 {
+		p.total = total
+	}
+}
+
+// add counts n more bytes transferred, redrawing the bar now and then.
+func (p *transferProgress) add(n int) {
+	if p == nil {
+		return
+	}
+	p.done += int64(n)
+	if time.Since(p.drawn) < progressInterval {
+		return
+	}
+	p.drawn = time.Now()
+	p.shown = true
+	fmt.Fprintf(os.Stdout, "\r%s\x1b[K", p.line())
+}
+
+// finish clears the bar, for the result to be printed in its place.
+func (p *transferProgress) finish() {
+	if p != nil && p.shown {
+		fmt.Fprint(os.Stdout, "\r\x1b[K")
+	}
+}
+
+func (p *transferProgress) line() string {
+	elapsed := time.Since(p.start).Seconds()
+	var rate float64
+	if elapsed > 0 {
+		rate = float64(p.done) / elapsed
+	}
+	speed := formatBytes(int64(rate)) + "/s"
+	if p.total <= 0 {
+		return fmt.Sprintf("%s %s  %s", p.label, formatBytes(p.done), speed)
+	}
+
+	fraction := min(float64(p.done)/float64(p.total), 1)
+	filled := int(fraction * progressBarWidth)
+	bar := strings.Repeat("=", filled) + strings.Repeat(" ", progressBarWidth-filled)
+	eta := "--"
+	if rate > 0 {
+		eta = time.Duration(float64(p.total-p.done) / rate * float64(time.Second)).Round(time.Second).String()
+	}
+	return fmt.Sprintf("%s [%s] %3.0f%% %s/%s  %s  ETA %s",
+		p.label, bar, fraction*100, formatBytes(p.done), formatBytes(p.total), speed, eta)
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/fatih/color"
+	"github.com/libp2p/go-libp2p/core/network"
+
+	"github.com/hemantsingh443/p2p-git-remote/internal/protocol"
+)
+
+// handleReadOnly shows whether the daemon is in read-only mode, or turns it
+// on or off.
+func handleReadOnly(stream network.Stream, enabled *bool) {
+	reqPayload := protocol.ReadOnlyRequestPayload{Enabled: enabled}
+	payloadBytes, _ := json.Marshal(reqPayload)
+	req := &protocol.Message{Type: protocol.TypeReadOnlyRequest, Payload: payloadBytes}
+	protocol.WriteMessage(stream, req)
+
+	resp, err := protocol.ReadMessage(stream)
+	if err != nil {
+		color.Red("Error reading read-only response: %v", err)
+		return
+	}
+	var respPayload protocol.ReadOnlyResponsePayload
+	json.Unmarshal(resp.Payload, &respPayload)
+	if !respPayload.Success {
+		color.Red("Error from daemon: %s", respPayload.Error)
+		return
+	}
+	if respPayload.ReadOnly {
+		color.Yellow("The daemon is in read-only mode: peers can browse, but not change anything.")
+	} else {
+		fmt.Println("The daemon is not in read-only mode.")
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+
+	p2p "github.com/hemantsingh443/p2p-git-remote/internal/p2p"
+)
+
+// lookupTimeout bounds looking a daemon up on the DHT when connecting.
+const lookupTimeout = 30 * time.Second
+
+// parseDaemonAddr parses a linked daemon's address: a full multiaddress, or
+// only its peer ID (bare or as /p2p/<id>), to be looked up on the DHT.
+func parseDaemonAddr(s string) (*peer.AddrInfo, error) {
+	if id, err := peer.Decode(s); err == nil {
+		return &peer.AddrInfo{ID: id}, nil
+	}
+	return peer.AddrInfoFromString(s)
+}
+
+// knownAddrsPath is where the address each daemon was last reached at is
+// kept, next to the config file.
+func knownAddrsPath(cm *ConfigManager) string {
+	return filepath.Join(filepath.Dir(cm.Path), "known_addrs.json")
+}
+
+func loadKnownAddrs(cm *ConfigManager) map[string]string {
+	known := make(map[string]string)
+	if data, err := os.ReadFile(knownAddrsPath(cm)); err == nil {
+		json.Unmarshal(data, &known)
+	}
+	return known
+}
+
+// lastConnectedPath is where the time the client last connected to each
+// daemon is kept, by peer ID, for 'daemons list'.
+func lastConnectedPath(cm *ConfigManager) string {
+	return filepath.Join(filepath.Dir(cm.Path), "last_connected.json")
+}
+
+func loadLastConnected(cm *ConfigManager) map[string]time.Time {
+	last := make(map[string]time.Time)
+	if data, err := os.ReadFile(lastConnectedPath(cm)); err == nil {
+		json.Unmarshal(data, &last)
+	}
+	return last
+}
+
+// rememberAddr records the address a daemon is connected at, to fall back on
+// when it can't be looked up next time, and when that was.
+func rememberAddr(cm *ConfigManager, h host.Host, id peer.ID) {
+	conns := h.Network().ConnsToPeer(id)
+	if len(conns) == 0 {
+		return
+	}
+	known := loadKnownAddrs(cm)
+	known[id.String()] = conns[0].RemoteMultiaddr().String()
+	if data, err := json.MarshalIndent(known, "", "  "); err == nil {
+		os.WriteFile(knownAddrsPath(cm), data, 0644)
+	}
+	last := loadLastConnected(cm)
+	last[id.String()] = time.Now().UTC()
+	if data, err := json.MarshalIndent(last, "", "  "); err == nil {
+		os.WriteFile(lastConnectedPath(cm), data, 0644)
+	}
+}
+
+// connectDaemon connects to a daemon. A daemon linked by a full multiaddress
+// is dialed there first; one linked by its peer ID alone, or one that moved,
+// is looked up with resolve, if not nil. The address it was last reached at
+// is the last resort. info gets every address tried.
+func connectDaemon(ctx context.Context, h host.Host, cm *ConfigManager, info *peer.AddrInfo, resolve p2p.Resolver) error {
+	var lastKnown []multiaddr.Multiaddr
+	if s, ok := loadKnownAddrs(cm)[info.ID.String()]; ok {
+		if addr, err := multiaddr.NewMultiaddr(s); err == nil {
+			lastKnown = append(lastKnown, addr)
+		}
+	}
+
+	var err error
+	if len(info.Addrs) > 0 {
+		if err = h.Connect(ctx, *info); err == nil {
+			rememberAddr(cm, h, info.ID)
+			return nil
+		}
+	}
+	if resolve != nil {
+		fmt.Printf("Looking up %s on the DHT...\n", info.ID)
+		lookupCtx, cancel := context.WithTimeout(ctx, lookupTimeout)
+		found, lookupErr := resolve(lookupCtx, info.ID)
+		cancel()
+		if lookupErr == nil && len(found.Addrs) > 0 {
+			info.Addrs = multiaddr.Unique(append(found.Addrs, info.Addrs...))
+		} else {
+			color.Yellow("Could not look the daemon up: %v", lookupErr)
+		}
+	}
+	info.Addrs = multiaddr.Unique(append(info.Addrs, lastKnown...))
+	if len(info.Addrs) == 0 {
+		if err == nil {
+			err = fmt.Errorf("no known address for %s", info.ID)
+		}
+		return err
+	}
+	if err = h.Connect(ctx, *info); err != nil {
+		return err
+	}
+	rememberAddr(cm, h, info.ID)
+	return nil
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+
+	"github.com/hemantsingh443/p2p-git-remote/internal/protocol"
+	"github.com/hemantsingh443/p2p-git-remote/internal/store"
+)
+
+// approvalTimeout is how long a parked destructive request waits for another
+// admin device, set by -approval-timeout.
+var approvalTimeout = 5 * time.Minute
+
+// pendingApproval is a destructive request parked until an admin decides.
+type pendingApproval struct {
+	event    protocol.ApprovalRequiredPayload
+	decision chan bool
+}
+
+// approvalQueue holds the parked requests and the admin clients watching for
+// them.
+type approvalQueue struct {
+	mu       sync.Mutex
+	pending  map[string]*pendingApproval
+	watchers map[chan protocol.ApprovalRequiredPayload]peer.ID
+}
+
+var approvals = &approvalQueue{
+	pending:  make(map[string]*pendingApproval),
+	watchers: make(map[chan protocol.ApprovalRequiredPayload]peer.ID),
+}
+
+// await parks a request and waits for an admin other than its sender to
+// decide on it. It returns why the request is refused, or "" if approved.
+func (q *approvalQueue) await(stream network.Stream, msgType, action, repo string) string {
+	remotePeer := stream.Conn().RemotePeer()
+	id := make([]byte, 4)
+	rand.Read(id)
+	p := &pendingApproval{
+		event: protocol.ApprovalRequiredPayload{
+			ID:       hex.EncodeToString(id),
+			Peer:     remotePeer.String(),
+			Request:  msgType,
+			Action:   action,
+			RepoPath: repo,
+			Expires:  time.Now().Add(approvalTimeout),
+		},
+		decision: make(chan bool, 1),
+	}
+
+	q.mu.Lock()
+	var notified int
+	for ch, watcher := range q.watchers {
+		if watcher == remotePeer {
+			continue // Approving needs a second device
+		}
+		select {
+		case ch <- p.event:
+			notified++
+		default:
+		}
+	}
+	if notified == 0 {
+		q.mu.Unlock()
+		return fmt.Sprintf("%s needs approval from another admin device, and none is watching; run `approvals` on one first", action)
+	}
+	q.pending[p.event.ID] = p
+	q.mu.Unlock()
+	defer func() {
+		q.mu.Lock()
+		delete(q.pending, p.event.ID)
+		q.mu.Unlock()
+	}()
+
+	trustLog.Info("Parked a request until another admin approves it", "action", action, "repo", repo, "peer", remotePeer, "id", p.event.ID)
+	payloadBytes, _ := json.Marshal(protocol.ApprovalPendingPayload{ID: p.event.ID, Action: action, Expires: p.event.Expires})
+	protocol.WriteMessage(stream, &protocol.Message{Type: protocol.TypeApprovalPending, Payload: payloadBytes})
+
+	select {
+	case approved := <-p.decision:
+		if !approved {
+			return fmt.Sprintf("another admin device rejected %s", action)
+		}
+		return ""
+	case <-time.After(approvalTimeout):
+		trustLog.Info("Approval expired", "id", p.event.ID)
+		return fmt.Sprintf("no admin device approved %s within %s", action, approvalTimeout)
+	}
+}
+
+// decide records an admin's decision on a parked request.
+func (q *approvalQueue) decide(admin peer.ID, id string, approve bool) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	p, ok := q.pending[id]
+	if !ok {
+		return fmt.Errorf("no request %s is waiting for approval; it may have expired", id)
+	}
+	if p.event.Peer == admin.String() {
+		return fmt.Errorf("a request has to be approved from another device than the one that sent it")
+	}
+	if role, _ := trustStore.Role(admin); !role.Allows(store.RoleAdmin) {
+		return fmt.Errorf("only admin clients can approve requests")
+	}
+	delete(q.pending, id)
+	p.decision <- approve
+	return nil
+}
+
+func (q *approvalQueue) watch(admin peer.ID) chan protocol.ApprovalRequiredPayload {
+	ch := make(chan protocol.ApprovalRequiredPayload, 16)
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.watchers[ch] = admin
+
+	// Catch up on requests parked before it started watching
+	var waiting []protocol.ApprovalRequiredPayload
+	for _, p := range q.pending {
+		if p.event.Peer != admin.String() {
+			waiting = append(waiting, p.event)
+		}
+	}
+	sort.Slice(waiting, func(i, j int) bool { return waiting[i].Expires.Before(waiting[j].Expires) })
+	for _, event := range waiting {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+	return ch
+}
+
+func (q *approvalQueue) unwatch(ch chan protocol.ApprovalRequiredPayload) {
+	q.mu.Lock()
+	delete(q.watchers, ch)
+	q.mu.Unlock()
+}
+
+// handleApprovals keeps an admin client's stream open, sending it the
+// destructive requests other peers send and applying its decisions, until
+// the client closes it.
+func handleApprovals(stream network.Stream) {
+	remotePeer := stream.Conn().RemotePeer()
+	trustLog.Info("Handling Approvals request", "peer", remotePeer)
+
+	respPayload := protocol.ApprovalsResponsePayload{Success: true}
+	if destructiveConfirmation != confirmBySecondDevice {
+		respPayload.Success = false
+		respPayload.Error = fmt.Sprintf("the daemon doesn't ask other devices to approve destructive requests (start it with -confirm-destructive %s)", confirmBySecondDevice)
+	}
+	payloadBytes, _ := json.Marshal(respPayload)
+	response := &protocol.Message{Type: protocol.TypeApprovalsResponse, Payload: payloadBytes}
+	if err := protocol.WriteMessage(stream, response); err != nil || !respPayload.Success {
+		return
+	}
+
+	events := approvals.watch(remotePeer)
+	defer approvals.unwatch(events)
+
+	decisions := make(chan protocol.ApprovalDecisionPayload)
+	closed, done := make(chan struct{}), make(chan struct{})
+	defer close(done)
+	go func() {
+		defer close(closed)
+		for {
+			msg, err := protocol.ReadMessage(stream)
+			if err != nil {
+				return
+			}
+			if msg.Type != protocol.TypeApprovalDecision {
+				continue
+			}
+			var decision protocol.ApprovalDecisionPayload
+			json.Unmarshal(msg.Payload, &decision)
+			select {
+			case decisions <- decision:
+			case <-done:
+				return
+			}
+		}
+	}()
+	for {
+		select {
+		case event := <-events:
+			payloadBytes, _ := json.Marshal(event)
+			if err := protocol.WriteMessage(stream, &protocol.Message{Type: protocol.TypeApprovalRequired, Payload: payloadBytes}); err != nil {
+				return
+			}
+		case decision := <-decisions:
+			resp := protocol.ApprovalDecisionResponsePayload{ID: decision.ID, Success: true}
+			result := "rejected"
+			if decision.Approve {
+				result = "approved"
+			}
+			if err := approvals.decide(remotePeer, decision.ID, decision.Approve); err != nil {
+				resp.Success = false
+				resp.Error = err.Error()
+				result = "failed"
+			} else {
+				trustLog.Info("Approval decided", "peer", remotePeer, "result", result, "id", decision.ID)
+			}
+			recordAudit(store.AuditEntry{
+				Time:   time.Now(),
+				Peer:   remotePeer.String(),
+				Type:   protocol.TypeApprovalDecision,
+				Args:   "id=" + decision.ID,
+				Result: result,
+				Error:  resp.Error,
+			})
+			payloadBytes, _ := json.Marshal(resp)
+			if err := protocol.WriteMessage(stream, &protocol.Message{Type: protocol.TypeApprovalDecisionResponse, Payload: payloadBytes}); err != nil {
+				return
+			}
+		case <-closed:
+			trustLog.Info("Approver disconnected", "peer", remotePeer)
+			return
+		}
+	}
+}
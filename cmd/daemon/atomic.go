@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/hemantsingh443/p2p-git-remote/internal/git"
+)
+
+// tempFilePrefix starts the names of the temporary files of writeAtomic.
+const tempFilePrefix = ".p2p-write-"
+
+// writeFileAtomic replaces fullPath with content, or creates it.
+func writeFileAtomic(fullPath string, content []byte) error {
+	return writeAtomic(fullPath, func(f *os.File) error {
+		_, err := f.Write(content)
+		return err
+	})
+}
+
+// writeAtomic replaces fullPath with what fill writes, without ever leaving
+// it partly written: fill writes a temporary file in the same directory,
+// which is synced to disk and then renamed over fullPath. If anything fails,
+// including the daemon dying halfway, the old file is untouched. A replaced
+// file keeps its mode.
+func writeAtomic(fullPath string, fill func(f *os.File) error) error {
+	if info, err := os.Stat(fullPath); err == nil && info.IsDir() {
+		return fmt.Errorf("%s is a directory", filepath.Base(fullPath))
+	}
+	dir := filepath.Dir(fullPath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, tempFilePrefix+"*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name()) // No-op once renamed
+
+	err = fill(f)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(fullPath); err == nil {
+		mode = info.Mode().Perm()
+	}
+	if err := os.Chmod(f.Name(), mode); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), fullPath); err != nil {
+		return err
+	}
+	// Make the rename itself durable; not every platform can sync a directory
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+// backupDir is where previous versions of overwritten files are kept, inside
+// the repo's git directory so they never show up as changes.
+const backupDir = "p2p-backups"
+
+// backupPrevious saves the current version of a file about to be overwritten,
+// if the repo's "write_backups" setting asks for it, keeping that many of the
+// newest versions of each file.
+func backupPrevious(alias, repoPath, relPath string) {
+	keep := getRepoConfig(alias).WriteBackups
+	if keep <= 0 {
+		return
+	}
+	content, err := os.ReadFile(filepath.Join(repoPath, relPath))
+	if err != nil {
+		return // Nothing to back up yet
+	}
+	root, err := git.GitPath(repoPath, backupDir)
+	if err != nil {
+		filesLog.Warn("Cannot back up file", "file", relPath, "err", err)
+		return
+	}
+	dir := filepath.Join(root, filepath.Clean(relPath))
+	name := filepath.Join(dir, time.Now().Format("20060102-150405.000000000"))
+	if err := writeFileAtomic(name, content); err != nil {
+		filesLog.Warn("Cannot back up file", "file", relPath, "err", err)
+		return
+	}
+	filesLog.Info("Backed up the previous version", "file", relPath, "backup", name)
+
+	// Names sort by time, oldest first
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for i := 0; i < len(entries)-keep; i++ {
+		os.Remove(filepath.Join(dir, entries[i].Name()))
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+
+	"github.com/hemantsingh443/p2p-git-remote/internal/protocol"
+	"github.com/hemantsingh443/p2p-git-remote/internal/store"
+)
+
+const auditLogFile = "audit_log.jsonl"
+
+// auditLog is opened in the state directory once it is known.
+var auditLog *store.AuditLog
+
+// maxAuditedResponse bounds how much of a response is kept to find out
+// whether the request succeeded. Bigger ones, like a large file read, are
+// recorded without a result.
+const maxAuditedResponse = 1024 * 1024
+
+// redactedArgs hold file contents, which the log should not keep.
+var redactedArgs = map[string]bool{"content": true, "data": true, "patch": true}
+
+// auditStream remembers the first message a handler writes, its response.
+type auditStream struct {
+	network.Stream
+	response []byte
+	done     bool
+}
+
+func (s *auditStream) Write(p []byte) (int, error) {
+	if !s.done {
+		if i := bytes.IndexByte(p, '\n'); i >= 0 {
+			s.response, s.done = append(s.response, p[:i]...), true
+			if bytes.Contains(s.response, []byte(`"type":"`+protocol.TypeHandshakeChallenge+`"`)) {
+				s.response, s.done = nil, false // The response comes after the proof
+			} else if bytes.Contains(s.response, []byte(`"type":"`+protocol.TypeApprovalPending+`"`)) {
+				s.response, s.done = nil, false // Or after another device's approval
+			}
+		} else if len(s.response)+len(p) > maxAuditedResponse {
+			s.response, s.done = nil, true
+		} else {
+			s.response = append(s.response, p...)
+		}
+	}
+	return s.Stream.Write(p)
+}
+
+// result says how the request went, from its response.
+func (s *auditStream) result() (string, string) {
+	var msg protocol.Message
+	if len(s.response) == 0 || json.Unmarshal(s.response, &msg) != nil {
+		if s.done {
+			return "unknown", ""
+		}
+		return "no response", ""
+	}
+	var payload struct {
+		Success *bool  `json:"success"`
+		Error   string `json:"error"`
+		Output  string `json:"output"`
+	}
+	json.Unmarshal(msg.Payload, &payload)
+	switch {
+	case msg.Type == protocol.TypeAccessDenied:
+		return "denied", payload.Error
+	case msg.Type == protocol.TypeRateLimited:
+		return "limited", payload.Error
+	case msg.Type == protocol.TypeSessionExpired:
+		return "expired", payload.Error
+	case payload.Success != nil && !*payload.Success:
+		reason := payload.Error
+		if reason == "" {
+			reason = payload.Output
+		}
+		return "failed", truncate(strings.TrimSpace(reason), 200)
+	}
+	return "ok", ""
+}
+
+// summarizeRequest returns the repo a request is about and its other
+// arguments, briefly, as key=value pairs.
+func summarizeRequest(rawPayload json.RawMessage) (string, string) {
+	var fields map[string]any
+	if json.Unmarshal(rawPayload, &fields) != nil {
+		return "", ""
+	}
+	repo, _ := fields["repo_path"].(string)
+	delete(fields, "repo_path")
+
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var args []string
+	for _, k := range keys {
+		var value string
+		switch v := fields[k].(type) {
+		case nil:
+			continue
+		case string:
+			switch {
+			case v == "":
+				continue
+			case redactedArgs[k]:
+				value = fmt.Sprintf("<%d chars>", len(v))
+			default:
+				value = truncate(v, 80)
+				if strings.ContainsAny(value, " \t\n\"") {
+					value = strconv.Quote(value)
+				}
+			}
+		case bool:
+			if !v {
+				continue
+			}
+			value = "true"
+		case float64:
+			if v == 0 {
+				continue
+			}
+			value = strconv.FormatFloat(v, 'f', -1, 64)
+		case []any:
+			if len(v) == 0 {
+				continue
+			}
+			value = fmt.Sprintf("<%d items>", len(v))
+		default:
+			value = "<...>"
+		}
+		args = append(args, k+"="+value)
+	}
+	return repo, strings.Join(args, " ")
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return strings.ToValidUTF8(s[:n], "") + "..."
+}
+
+func recordAudit(entry store.AuditEntry) {
+	console.recordRequest(entry)
+	if err := auditLog.Record(entry); err != nil {
+		trustLog.Warn("Failed to write audit log", "err", err)
+	}
+}
+
+func handleAuditLog(stream network.Stream, rawPayload json.RawMessage) {
+	var payload protocol.AuditLogRequestPayload
+	json.Unmarshal(rawPayload, &payload)
+	trustLog.Info("Handling AuditLog request", "peer", payload.Peer, "type", payload.Type, "repo", payload.Repo, "limit", payload.Limit)
+
+	respPayload := protocol.AuditLogResponsePayload{}
+	entries, err := auditLog.Query(store.AuditQuery{
+		Peer:  payload.Peer,
+		Type:  payload.Type,
+		Repo:  payload.Repo,
+		Since: payload.Since,
+		Limit: payload.Limit,
+	})
+	if err != nil {
+		respPayload.Success = false
+		respPayload.Error = err.Error()
+	} else {
+		respPayload.Success = true
+		for _, entry := range entries {
+			respPayload.Entries = append(respPayload.Entries, protocol.AuditEntry(entry))
+		}
+	}
+	payloadBytes, _ := json.Marshal(respPayload)
+	response := &protocol.Message{Type: protocol.TypeAuditLogResponse, Payload: payloadBytes}
+	protocol.WriteMessage(stream, response)
+}
+
+// auditCommand is what the -audit flags ask for.
+type auditCommand struct {
+	show   bool
+	export string // File to write the entries to as JSONL, - for stdout
+	peer   string
+	typ    string
+	repo   string
+	since  time.Duration
+	limit  int
+}
+
+// showAudit prints or exports the audit log, then exits.
+func showAudit(cmd auditCommand) {
+	q := store.AuditQuery{Peer: cmd.peer, Type: cmd.typ, Repo: cmd.repo, Limit: cmd.limit}
+	if cmd.since > 0 {
+		q.Since = time.Now().Add(-cmd.since)
+	}
+	entries, err := auditLog.Query(q)
+	if err != nil {
+		fatal("Failed to read audit log", "err", err)
+	}
+
+	if cmd.export != "" {
+		var out io.Writer = os.Stdout
+		if cmd.export != "-" {
+			f, err := os.Create(cmd.export)
+			if err != nil {
+				fatal("Failed to export audit log", "err", err)
+			}
+			defer f.Close()
+			out = f
+		}
+		encoder := json.NewEncoder(out)
+		for _, entry := range entries {
+			if err := encoder.Encode(entry); err != nil {
+				fatal("Failed to export audit log", "err", err)
+			}
+		}
+		if cmd.export != "-" {
+			fmt.Printf("Exported %d audit entries to %s\n", len(entries), cmd.export)
+		}
+		return
+	}
+
+	if len(entries) == 0 {
+		fmt.Println("No audit entries.")
+		return
+	}
+	for _, entry := range entries {
+		fmt.Println(formatAuditEntry(entry))
+	}
+}
+
+func formatAuditEntry(entry store.AuditEntry) string {
+	line := fmt.Sprintf("%s  %s  %-8s %s", entry.Time.Local().Format("2006-01-02 15:04:05"), shortPeerID(entry.Peer), entry.Result, entry.Type)
+	if entry.Repo != "" {
+		line += " [" + entry.Repo + "]"
+	}
+	if entry.Args != "" {
+		line += " " + entry.Args
+	}
+	if entry.Error != "" {
+		line += ": " + entry.Error
+	}
+	return line
+}
+
+// shortPeerID shortens a peer ID for display; FindPeer accepts the prefix.
+func shortPeerID(id string) string {
+	if len(id) > 16 {
+		return id[:16]
+	}
+	return id
+}
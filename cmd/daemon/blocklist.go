@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	manet "github.com/multiformats/go-multiaddr/net"
+
+	"github.com/hemantsingh443/p2p-git-remote/internal/protocol"
+	"github.com/hemantsingh443/p2p-git-remote/internal/store"
+)
+
+const blocklistFile = "blocklist.json"
+
+// blocklist holds the peers and IP ranges refused at connection time,
+// before they can reach the pairing prompt.
+var blocklist *store.Blocklist
+
+func loadBlocklist() {
+	var err error
+	if blocklist, err = store.NewBlocklist(statePath(blocklistFile)); err != nil {
+		fatal("Failed to load blocklist", "err", err)
+	}
+}
+
+// blockCommand is what the -block, -unblock and -blocked flags ask for.
+type blockCommand struct {
+	list           bool
+	block, unblock string
+}
+
+func (c blockCommand) any() bool {
+	return c.list || c.block != "" || c.unblock != ""
+}
+
+// manageBlocklist runs a blockCommand and exits. A running daemon picks up
+// the change with the next connection.
+func manageBlocklist(cmd blockCommand) {
+	loadBlocklist()
+	var err error
+	if trustStore, err = store.NewTrustStore(statePath(trustedPeersFile)); err != nil {
+		fatal("Failed to initialize trust store", "err", err)
+	}
+	for _, change := range []struct {
+		entry   string
+		unblock bool
+	}{{cmd.block, false}, {cmd.unblock, true}} {
+		if change.entry == "" {
+			continue
+		}
+		out, err := changeBlocklist(change.entry, change.unblock)
+		if err != nil {
+			fatal("Failed to update blocklist", "err", err)
+		}
+		fmt.Println(out)
+	}
+	if cmd.list {
+		peers, ranges := blocklist.Entries()
+		if len(peers)+len(ranges) == 0 {
+			fmt.Println("Nothing is blocked.")
+		}
+		for _, p := range peers {
+			fmt.Printf("peer   %s\n", p)
+		}
+		for _, r := range ranges {
+			fmt.Printf("range  %s\n", r)
+		}
+	}
+}
+
+// resolveBlockEntry turns a prefix of a trusted peer's ID into the full ID,
+// so a former device can be blocked the way it's revoked.
+func resolveBlockEntry(entry string) (string, error) {
+	if _, _, err := store.ParseBlockEntry(entry); err == nil {
+		return entry, nil
+	}
+	p, err := trustStore.FindPeer(entry)
+	if err != nil {
+		return "", fmt.Errorf("'%s' is neither a peer ID, an IP address, a CIDR range nor a trusted peer", entry)
+	}
+	return p.String(), nil
+}
+
+// changeBlocklist blocks or unblocks an entry, and describes what it did.
+func changeBlocklist(entry string, unblock bool) (string, error) {
+	entry, err := resolveBlockEntry(entry)
+	if err != nil {
+		return "", err
+	}
+	if unblock {
+		if err := blocklist.Remove(entry); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Unblocked %s.", entry), nil
+	}
+	if err := blocklist.Add(entry); err != nil {
+		return "", err
+	}
+	dropBlockedConns()
+	return fmt.Sprintf("Blocked %s. It can't connect to the daemon anymore.", entry), nil
+}
+
+// blockedConn reports whether the blocklist refuses a connection's peer or
+// address.
+func blockedConn(p peer.ID, addrs network.ConnMultiaddrs) bool {
+	if blocklist.BlocksPeer(p) {
+		return true
+	}
+	ip, err := manet.ToIP(addrs.RemoteMultiaddr())
+	return err == nil && blocklist.BlocksIP(ip)
+}
+
+// blocksRequester reports whether an entry would block the connection
+// asking for it.
+func blocksRequester(entry string, conn network.Conn) bool {
+	if entry == conn.RemotePeer().String() {
+		return true
+	}
+	_, cidr, err := net.ParseCIDR(entry)
+	if err != nil {
+		if _, normalized, err := store.ParseBlockEntry(entry); err == nil {
+			_, cidr, _ = net.ParseCIDR(normalized)
+		}
+	}
+	ip, err := manet.ToIP(conn.RemoteMultiaddr())
+	return cidr != nil && err == nil && cidr.Contains(ip)
+}
+
+// dropBlockedConns closes the running daemon's connections that are blocked
+// now.
+func dropBlockedConns() {
+	if daemonHost == nil {
+		return
+	}
+	for _, conn := range daemonHost.Network().Conns() {
+		if blockedConn(conn.RemotePeer(), conn) {
+			trustLog.Info("Dropping connection of blocked peer", "peer", conn.RemotePeer())
+			conn.Close()
+		}
+	}
+}
+
+func handleBlock(stream network.Stream, rawPayload json.RawMessage) {
+	var payload protocol.BlockRequestPayload
+	json.Unmarshal(rawPayload, &payload)
+	trustLog.Info("Handling Block request", "entry", payload.Entry, "unblock", payload.Unblock)
+
+	respPayload := protocol.BlockResponsePayload{}
+	if payload.Entry != "" {
+		entry, err := resolveBlockEntry(payload.Entry)
+		if err == nil && !payload.Unblock && blocksRequester(entry, stream.Conn()) {
+			err = fmt.Errorf("refusing to block the peer asking for it")
+		}
+		if err == nil {
+			respPayload.Output, err = changeBlocklist(entry, payload.Unblock)
+		}
+		if err != nil {
+			respPayload.Success = false
+			respPayload.Output = err.Error()
+		} else {
+			respPayload.Success = true
+		}
+	} else {
+		respPayload.Success = true
+	}
+	respPayload.Peers, respPayload.Ranges = blocklist.Entries()
+	payloadBytes, _ := json.Marshal(respPayload)
+	response := &protocol.Message{Type: protocol.TypeBlockResponse, Payload: payloadBytes}
+	protocol.WriteMessage(stream, response)
+}
@@ -0,0 +1,20 @@
+package main
+
+import "github.com/libp2p/go-libp2p/core/peer"
+
+// trustedTag protects the connections of trusted peers from the connection
+// manager, so DHT traffic never gets a client's connection closed in the
+// middle of a session.
+const trustedTag = "trusted"
+
+func protectPeer(p peer.ID) {
+	if daemonHost != nil {
+		daemonHost.ConnManager().Protect(p, trustedTag)
+	}
+}
+
+func unprotectPeer(p peer.ID) {
+	if daemonHost != nil {
+		daemonHost.ConnManager().Unprotect(p, trustedTag)
+	}
+}
@@ -0,0 +1,485 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/skip2/go-qrcode"
+
+	"github.com/hemantsingh443/p2p-git-remote/internal/p2p"
+	"github.com/hemantsingh443/p2p-git-remote/internal/store"
+)
+
+// How much of the daemon's activity the console keeps to show.
+const (
+	consoleRequestsKept = 200
+	consoleLogLinesKept = 200
+)
+
+// console is the daemon's terminal UI (-tui), or nil without one. Pairings
+// and destructive requests wait in it for the owner instead of blocking on
+// stdin.
+var console *daemonConsole
+
+// daemonConsole gathers what the daemon does, for the console to show.
+type daemonConsole struct {
+	addr string // Address clients connect to, shown as a QR code on demand
+
+	mu       sync.Mutex
+	program  *tea.Program // Non-nil while the console is on screen
+	requests []store.AuditEntry
+	repos    map[string]*repoActivity
+	logs     []string
+	partial  string // Log output not ended by a newline yet
+	confirms []*consoleConfirmation
+}
+
+// repoActivity is what clients did to a repo since the daemon started.
+type repoActivity struct {
+	requests int
+	failed   int
+	last     store.AuditEntry
+}
+
+// consoleConfirmation is a destructive request waiting for the owner.
+type consoleConfirmation struct {
+	peer   peer.ID
+	action string
+	repo   string
+	since  time.Time
+	answer chan bool
+}
+
+func newDaemonConsole() *daemonConsole {
+	return &daemonConsole{repos: make(map[string]*repoActivity)}
+}
+
+// Write takes the daemon's logs while the console is on screen, where they'd
+// garble it, and passes them to stderr otherwise.
+func (c *daemonConsole) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	lines := strings.Split(c.partial+string(p), "\n")
+	c.partial = lines[len(lines)-1]
+	c.logs = append(c.logs, lines[:len(lines)-1]...)
+	if len(c.logs) > consoleLogLinesKept {
+		c.logs = c.logs[len(c.logs)-consoleLogLinesKept:]
+	}
+	if c.program == nil {
+		return os.Stderr.Write(p)
+	}
+	return len(p), nil
+}
+
+// recordRequest adds a request the daemon served to the console.
+func (c *daemonConsole) recordRequest(entry store.AuditEntry) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.requests = append(c.requests, entry)
+	if len(c.requests) > consoleRequestsKept {
+		c.requests = c.requests[len(c.requests)-consoleRequestsKept:]
+	}
+	if entry.Repo == "" {
+		return
+	}
+	alias, _, _ := strings.Cut(entry.Repo, "/")
+	a, ok := c.repos[alias]
+	if !ok {
+		a = &repoActivity{}
+		c.repos[alias] = a
+	}
+	a.requests++
+	if entry.Result != "ok" {
+		a.failed++
+	}
+	a.last = entry
+}
+
+// confirm asks the owner whether to allow a destructive request, waiting
+// up to approvalTimeout for an answer.
+func (c *daemonConsole) confirm(p peer.ID, action, repo string) bool {
+	conf := &consoleConfirmation{peer: p, action: action, repo: repo, since: time.Now(), answer: make(chan bool, 1)}
+	c.mu.Lock()
+	c.confirms = append(c.confirms, conf)
+	c.mu.Unlock()
+	defer c.dropConfirmation(conf)
+
+	trustLog.Info("Destructive request waits for the owner in the console", "action", action, "repo", repo, "peer", p)
+	select {
+	case ok := <-conf.answer:
+		return ok
+	case <-time.After(approvalTimeout):
+		trustLog.Info("Destructive request was not answered in time", "action", action, "repo", repo, "after", approvalTimeout)
+		return false
+	}
+}
+
+func (c *daemonConsole) dropConfirmation(conf *consoleConfirmation) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, other := range c.confirms {
+		if other == conf {
+			c.confirms = append(c.confirms[:i], c.confirms[i+1:]...)
+			return
+		}
+	}
+}
+
+// run shows the console until the owner quits it or stop is closed.
+func (c *daemonConsole) run(stop <-chan struct{}) error {
+	program := tea.NewProgram(newConsoleModel(c), tea.WithAltScreen())
+	c.mu.Lock()
+	c.program = program
+	c.mu.Unlock()
+	go func() {
+		<-stop
+		program.Quit()
+	}()
+	_, err := program.Run()
+	c.mu.Lock()
+	c.program = nil
+	c.mu.Unlock()
+	return err
+}
+
+// waitingItem is something waiting for the owner: a pairing or a
+// destructive request.
+type waitingItem struct {
+	pairing *pendingPairing
+	confirm *consoleConfirmation
+}
+
+func (c *daemonConsole) waiting() []waitingItem {
+	var items []waitingItem
+	for _, p := range listPendingPairings() {
+		items = append(items, waitingItem{pairing: p})
+	}
+	c.mu.Lock()
+	for _, conf := range c.confirms {
+		items = append(items, waitingItem{confirm: conf})
+	}
+	c.mu.Unlock()
+	return items
+}
+
+var (
+	consoleTitleStyle   = lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("212"))
+	consoleSectionStyle = lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("39"))
+	consoleDimStyle     = lipgloss.NewStyle().Foreground(lipgloss.Color("245"))
+	consoleWaitStyle    = lipgloss.NewStyle().Foreground(lipgloss.Color("214"))
+	consoleSelStyle     = lipgloss.NewStyle().Reverse(true)
+	consoleErrStyle     = lipgloss.NewStyle().Foreground(lipgloss.Color("196"))
+	consoleOkStyle      = lipgloss.NewStyle().Foreground(lipgloss.Color("42"))
+)
+
+type consoleTickMsg struct{}
+
+func consoleTick() tea.Cmd {
+	return tea.Tick(time.Second, func(time.Time) tea.Msg { return consoleTickMsg{} })
+}
+
+// consoleModel is the Bubble Tea model of the console.
+type consoleModel struct {
+	c             *daemonConsole
+	width, height int
+	selected      int // Index in the waiting list
+	showLogs      bool
+	showQR        bool
+	status        string
+
+	// Approving a pairing: typing the client's code, then picking its role
+	input      textinput.Model
+	typingCode bool
+	code       string
+}
+
+func newConsoleModel(c *daemonConsole) consoleModel {
+	input := textinput.New()
+	input.Prompt = "Pairing code the client shows: "
+	return consoleModel{c: c, input: input}
+}
+
+func (m consoleModel) Init() tea.Cmd {
+	return consoleTick()
+}
+
+func (m consoleModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.width, m.height = msg.Width, msg.Height
+	case consoleTickMsg:
+		// Pairings and requests stop waiting on their own
+		if n := len(m.c.waiting()); m.selected >= n {
+			m.selected = max(n-1, 0)
+		}
+		return m, consoleTick()
+	case tea.KeyMsg:
+		return m.handleKey(msg)
+	}
+	return m, nil
+}
+
+func (m consoleModel) handleKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	if msg.String() == "ctrl+c" {
+		return m, tea.Quit
+	}
+	if m.typingCode {
+		switch msg.String() {
+		case "esc":
+			m.typingCode = false
+			m.input.Blur()
+		case "enter":
+			m.typingCode = false
+			m.input.Blur()
+			m.code = strings.TrimSpace(m.input.Value())
+			m.status = "Allow it to (r)ead only, (w)rite, or (a)dminister the daemon? (Esc cancels)"
+		default:
+			var cmd tea.Cmd
+			m.input, cmd = m.input.Update(msg)
+			return m, cmd
+		}
+		return m, nil
+	}
+	if m.code != "" {
+		role := map[string]store.Role{"r": store.RoleReadOnly, "w": store.RoleReadWrite, "a": store.RoleAdmin}[msg.String()]
+		if role == "" {
+			m.code, m.status = "", "Approval cancelled."
+			return m, nil
+		}
+		if p, err := decidePairing(m.code, role); err != nil {
+			m.status = consoleErrStyle.Render("Error: " + err.Error())
+		} else {
+			m.status = consoleOkStyle.Render(fmt.Sprintf("Approved %s as %s.", p, role))
+		}
+		m.code = ""
+		return m, nil
+	}
+
+	waiting := m.c.waiting()
+	m.status = ""
+	switch msg.String() {
+	case "q":
+		return m, tea.Quit
+	case "up", "k":
+		if m.selected > 0 {
+			m.selected--
+		}
+	case "down", "j":
+		if m.selected < len(waiting)-1 {
+			m.selected++
+		}
+	case "l":
+		m.showLogs = !m.showLogs
+	case "c":
+		m.showQR = !m.showQR
+	case "a", "d":
+		if m.selected >= len(waiting) {
+			m.status = "Nothing is waiting for you."
+			return m, nil
+		}
+		item := waiting[m.selected]
+		switch {
+		case item.confirm != nil:
+			item.confirm.answer <- msg.String() == "a"
+			m.c.dropConfirmation(item.confirm)
+			if msg.String() == "a" {
+				trustLog.Info("Owner approved a destructive request", "action", item.confirm.action, "repo", item.confirm.repo, "peer", item.confirm.peer)
+			}
+		case msg.String() == "d":
+			if p, err := decidePairing(item.pairing.peer.String(), ""); err != nil {
+				m.status = consoleErrStyle.Render("Error: " + err.Error())
+			} else {
+				m.status = fmt.Sprintf("Rejected %s.", p)
+			}
+		default:
+			// Typing the code the client shows proves it's the device the owner thinks
+			m.typingCode = true
+			m.input.SetValue("")
+			m.input.Focus()
+			return m, textinput.Blink
+		}
+	}
+	return m, nil
+}
+
+func (m consoleModel) View() string {
+	if m.width == 0 {
+		return "Starting..."
+	}
+	var b strings.Builder
+	line := func(format string, args ...any) {
+		b.WriteString(truncateLine(fmt.Sprintf(format, args...), m.width) + "\n")
+	}
+
+	title := fmt.Sprintf("p2p-git daemon %s · up %s · %d repos", daemonVersion(), time.Since(started).Truncate(time.Second), linkedRepoCount())
+	if readOnlyMode.Load() {
+		title += " · read-only"
+	}
+	line("%s", consoleTitleStyle.Render(title))
+	if daemonHost != nil {
+		line("%s", consoleDimStyle.Render("Peer ID "+daemonHost.ID().String()))
+	}
+	line("%s", consoleDimStyle.Render(m.c.addr))
+
+	if m.showQR {
+		if qrc, err := qrcode.New(m.c.addr, qrcode.Medium); err == nil {
+			b.WriteString(qrc.ToSmallString(false))
+		}
+		line("%s", consoleDimStyle.Render("c: hide the QR code"))
+		return b.String()
+	}
+
+	waiting := m.c.waiting()
+	if len(waiting) > 0 {
+		line("")
+		line("%s", consoleSectionStyle.Render("Waiting for you")+consoleDimStyle.Render("  a: approve  d: deny  ↑/↓: select"))
+		for i, item := range waiting {
+			var text string
+			if item.pairing != nil {
+				p := item.pairing
+				text = fmt.Sprintf("Pairing  %s  %s  waiting %s", shortPeer(p.peer), p2p.WordFingerprint(p.peer), time.Since(p.since).Truncate(time.Second))
+			} else {
+				conf := item.confirm
+				text = fmt.Sprintf("%s in '%s' by %s, waiting %s", conf.action, conf.repo, shortPeer(conf.peer), time.Since(conf.since).Truncate(time.Second))
+			}
+			if i == m.selected {
+				text = consoleSelStyle.Render(text)
+			} else {
+				text = consoleWaitStyle.Render(text)
+			}
+			line("  %s", text)
+		}
+	}
+
+	line("")
+	var connected []string
+	for _, p := range listPeers("") {
+		if p.Connected {
+			connected = append(connected, fmt.Sprintf("%s (%s)", shortPeerString(p.ID), p.Role))
+		}
+	}
+	others := 0
+	if daemonHost != nil {
+		others = len(daemonHost.Network().Peers()) - len(connected)
+	}
+	line("%s", consoleSectionStyle.Render("Connected peers"))
+	if len(connected) == 0 {
+		line("  %s", consoleDimStyle.Render("No trusted clients connected"))
+	} else {
+		line("  %s", strings.Join(connected, ", "))
+	}
+	if others > 0 {
+		line("  %s", consoleDimStyle.Render(fmt.Sprintf("and %d other peers (DHT, relays, pairing clients)", others)))
+	}
+
+	line("")
+	line("%s", consoleSectionStyle.Render("Repos"))
+	busy := make(map[string]int)
+	for _, s := range listStreams() {
+		alias, _, _ := strings.Cut(s.repo, "/")
+		busy[alias]++
+	}
+	aliases := getRepoAliases()
+	m.c.mu.Lock()
+	for _, alias := range aliases {
+		a := m.c.repos[alias]
+		activity := consoleDimStyle.Render("no requests yet")
+		if a != nil {
+			activity = fmt.Sprintf("%d requests, %d failed, last %s %s ago", a.requests, a.failed, shortType(a.last.Type), time.Since(a.last.Time).Truncate(time.Second))
+		}
+		if busy[alias] > 0 {
+			activity += consoleWaitStyle.Render(fmt.Sprintf(" · %d running", busy[alias]))
+		}
+		line("  %-20s %s", alias, activity)
+	}
+	m.c.mu.Unlock()
+
+	// The rest of the screen, less the status and help lines, goes to the
+	// recent requests or the logs
+	used := strings.Count(b.String(), "\n")
+	room := m.height - used - 4
+	line("")
+	m.c.mu.Lock()
+	if m.showLogs {
+		line("%s", consoleSectionStyle.Render("Log"))
+		for _, l := range lastLines(m.c.logs, room) {
+			line("  %s", consoleDimStyle.Render(l))
+		}
+	} else {
+		line("%s", consoleSectionStyle.Render("Recent requests"))
+		requests := lastLines(m.c.requests, room)
+		if len(requests) == 0 {
+			line("  %s", consoleDimStyle.Render("None yet"))
+		}
+		for _, e := range requests {
+			result := e.Result
+			switch result {
+			case "ok", "approved":
+				result = consoleOkStyle.Render(result)
+			default:
+				result = consoleErrStyle.Render(result)
+			}
+			line("  %s  %s  %-22s %-16s %s", e.Time.Local().Format("15:04:05"), shortPeerString(e.Peer), shortType(e.Type), e.Repo, result)
+		}
+	}
+	m.c.mu.Unlock()
+
+	for used = strings.Count(b.String(), "\n"); used < m.height-2; used++ {
+		b.WriteString("\n")
+	}
+	switch {
+	case m.typingCode:
+		line("%s", m.input.View())
+	case m.status != "":
+		line("%s", m.status)
+	default:
+		line("")
+	}
+	b.WriteString(consoleDimStyle.Render(truncateLine("l: log/requests  c: QR code  q: stop the daemon", m.width)))
+	return b.String()
+}
+
+// lastLines returns the last n items of s, or none if n isn't positive.
+func lastLines[T any](s []T, n int) []T {
+	if n <= 0 {
+		return nil
+	}
+	if len(s) > n {
+		return s[len(s)-n:]
+	}
+	return s
+}
+
+func truncateLine(s string, width int) string {
+	if width <= 0 || lipgloss.Width(s) <= width {
+		return s
+	}
+	return lipgloss.NewStyle().MaxWidth(width).Render(s)
+}
+
+func shortPeer(p peer.ID) string {
+	return shortPeerString(p.String())
+}
+
+// shortPeerString shortens a peer ID, e.g. to "12D3Ko*abc123".
+func shortPeerString(id string) string {
+	if len(id) <= 14 {
+		return id
+	}
+	return id[:6] + "*" + id[len(id)-6:]
+}
+
+// shortType turns GIT_COMMIT_REQUEST into GIT_COMMIT.
+func shortType(t string) string {
+	return strings.TrimSuffix(t, "_REQUEST")
+}
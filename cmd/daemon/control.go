@@ -0,0 +1,227 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hemantsingh443/p2p-git-remote/internal/control"
+	p2p "github.com/hemantsingh443/p2p-git-remote/internal/p2p"
+	"github.com/hemantsingh443/p2p-git-remote/internal/store"
+)
+
+// startControlSocket serves admin commands from p2p-gitctl on a unix socket
+// only this user can open. Closing the returned listener stops it.
+func startControlSocket(socketPath string) net.Listener {
+	ln, err := control.Listen(socketPath, controlCommand)
+	if err != nil {
+		slog.Warn("No admin socket", "err", err)
+		return nil
+	}
+	slog.Info("Admin socket listening (use p2p-gitctl)", "path", socketPath)
+	return ln
+}
+
+// controlCommand runs an admin command from the socket.
+func controlCommand(req control.Request) control.Response {
+	slog.Info("Admin socket command", "command", req.Command, "args", strings.Join(req.Args, " "))
+	output, err := runControlCommand(req.Command, req.Args)
+	if err != nil {
+		return control.Response{Success: false, Error: err.Error()}
+	}
+	return control.Response{Success: true, Output: output}
+}
+
+func runControlCommand(command string, args []string) (string, error) {
+	usage := func(u string) (string, error) { return "", fmt.Errorf("usage: %s", u) }
+	var out strings.Builder
+	switch command {
+	case "peers":
+		peers := listPeers("")
+		if len(peers) == 0 {
+			return "No trusted peers.\n", nil
+		}
+		for _, p := range peers {
+			connected := ""
+			if p.Connected {
+				connected = ", connected"
+			}
+			fmt.Fprintf(&out, "%-10s  %s  %s%s\n", p.Role, p.ID, describeExpiry(p), connected)
+		}
+	case "revoke":
+		if len(args) != 1 {
+			return usage("revoke <peer-id>")
+		}
+		p, err := revokePeer(args[0])
+		if err != nil {
+			return "", err
+		}
+		daemonHost.Network().ClosePeer(p)
+		fmt.Fprintf(&out, "Revoked peer %s. It has to pair again to reconnect.\n", p)
+	case "repos":
+		repos := copyLinkedRepos()
+		aliases := make([]string, 0, len(repos))
+		for alias := range repos {
+			aliases = append(aliases, alias)
+		}
+		sort.Strings(aliases)
+		for _, alias := range aliases {
+			fmt.Fprintf(&out, "%-20s  %s\n", alias, repos[alias])
+		}
+	case "link":
+		if len(args) != 2 {
+			return usage("link <alias> <path>")
+		}
+		if err := linkRepo(args[0], args[1]); err != nil {
+			return "", err
+		}
+		repoPath, _ := linkedRepo(args[0])
+		fmt.Fprintf(&out, "Linked '%s' to %s.\n", args[0], repoPath)
+	case "unlink":
+		if len(args) != 1 {
+			return usage("unlink <alias>")
+		}
+		if err := unlinkRepo(args[0]); err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&out, "Unlinked '%s'. Its files are untouched.\n", args[0])
+	case "rename":
+		if len(args) != 2 {
+			return usage("rename <alias> <new-alias>")
+		}
+		if err := renameAlias(args[0], args[1]); err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&out, "Renamed '%s' to '%s'.\n", args[0], args[1])
+	case "reload":
+		summary, err := reloadConfig()
+		if err != nil {
+			return "", fmt.Errorf("%w; nothing was changed", err)
+		}
+		fmt.Fprintf(&out, "Reloaded %s.\n", summary)
+	case "readonly":
+		switch {
+		case len(args) == 1 && (args[0] == "on" || args[0] == "off"):
+			readOnlyMode.Store(args[0] == "on")
+			slog.Info("Read-only mode changed from the admin socket", "mode", args[0])
+		case len(args) > 0:
+			return usage("readonly [on|off]")
+		}
+		fmt.Fprintf(&out, "Read-only mode is %s.\n", onOff(readOnlyMode.Load()))
+	case "streams":
+		streams := listStreams()
+		if len(streams) == 0 {
+			return "No active streams.\n", nil
+		}
+		for _, s := range streams {
+			fmt.Fprintf(&out, "%s  %-8s  %-28s  %s\n", s.peer, time.Since(s.started).Round(time.Second), s.request, s.repo)
+		}
+	case "pending":
+		pending := listPendingPairings()
+		if len(pending) == 0 {
+			return "No clients are waiting to pair.\n", nil
+		}
+		for _, p := range pending {
+			fmt.Fprintf(&out, "%s  waiting %-8s  %s\n", p.peer, time.Since(p.since).Round(time.Second), p2p.WordFingerprint(p.peer))
+		}
+		out.WriteString("Approve one with 'approve <pairing-code> [role]', typing the code the client shows.\n")
+	case "approve":
+		if len(args) == 0 {
+			return usage("approve <pairing-code> [read-only|read-write|admin]")
+		}
+		// The code has a space in it, quoted or not
+		role := store.RoleReadWrite
+		if r, err := store.ParseRole(args[len(args)-1]); err == nil && len(args) > 1 {
+			role, args = r, args[:len(args)-1]
+		}
+		p, err := decidePairing(strings.Join(args, " "), role)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&out, "Approved %s as %s.\n", p, role)
+	case "reject":
+		if len(args) != 1 {
+			return usage("reject <peer-id>")
+		}
+		p, err := decidePairing(args[0], "")
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&out, "Rejected %s.\n", p)
+	case "token":
+		if len(args) > 2 {
+			return usage("token [read-only|read-write|admin] [valid-for, e.g. 24h]")
+		}
+		role, ttl := store.RoleReadWrite, 24*time.Hour
+		var err error
+		if len(args) > 0 {
+			if role, err = store.ParseRole(args[0]); err != nil {
+				return "", err
+			}
+		}
+		if len(args) > 1 {
+			if ttl, err = time.ParseDuration(args[1]); err != nil {
+				return "", fmt.Errorf("invalid duration '%s': %w", args[1], err)
+			}
+		}
+		token, err := newPairingToken(role, ttl)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&out, "%s\nPairs one %s client within %s. On the client, set P2P_GIT_PAIRING_TOKEN to it before connecting.\n", token, role, ttl)
+	case "auto-approve":
+		if len(args) < 1 || len(args) > 2 {
+			return usage("auto-approve <minutes> [read-only|read-write|admin] (0 minutes to stop)")
+		}
+		minutes, err := strconv.Atoi(args[0])
+		if err != nil || minutes < 0 {
+			return "", fmt.Errorf("invalid number of minutes '%s'", args[0])
+		}
+		role := store.RoleReadOnly
+		if len(args) == 2 {
+			if role, err = store.ParseRole(args[1]); err != nil {
+				return "", err
+			}
+		}
+		until := autoApprove.open(time.Duration(minutes)*time.Minute, role)
+		if minutes == 0 {
+			trustLog.Info("Auto-approve window closed from the admin socket")
+			out.WriteString("New clients need approval again.\n")
+		} else {
+			trustLog.Info("Auto-approve window opened from the admin socket", "role", role, "until", until.Format("15:04:05"))
+			fmt.Fprintf(&out, "Approving every new client as %s until %s.\n", role, until.Format("15:04:05"))
+		}
+	default:
+		names := make([]string, len(control.Commands))
+		for i, c := range control.Commands {
+			names[i] = c.Name
+		}
+		return "", fmt.Errorf("unknown command '%s' (%s)", command, strings.Join(names, ", "))
+	}
+	return out.String(), nil
+}
+
+// unlinkRepo stops exposing a repo. Its settings are kept, for when it's
+// linked again.
+func unlinkRepo(alias string) error {
+	configMu.Lock()
+	repoPath, ok := linkedRepos[alias]
+	if !ok {
+		configMu.Unlock()
+		return fmt.Errorf("unknown repository alias '%s'", alias)
+	}
+	delete(linkedRepos, alias)
+	if err := saveLinkedRepos(); err != nil {
+		linkedRepos[alias] = repoPath
+		configMu.Unlock()
+		return fmt.Errorf("failed to save repo list: %w", err)
+	}
+	configMu.Unlock()
+	watcher.removeRepo(repoPath)
+	slog.Info("Unlinked repository", "alias", alias)
+	return nil
+}
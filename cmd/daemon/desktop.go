@@ -0,0 +1,38 @@
+package main
+
+import (
+	"log/slog"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// desktopNotify, set by -notify, makes pairing requests pop up a desktop
+// notification too, for when the daemon's terminal is buried.
+var desktopNotify = true
+
+// notifyDesktop shows a desktop notification with notify-send on Linux and
+// the BSDs, or osascript on macOS. It doesn't wait, and does nothing where
+// neither is available, like on a headless server.
+func notifyDesktop(title, body string) {
+	if !desktopNotify {
+		return
+	}
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		quote := func(s string) string {
+			return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+		}
+		cmd = exec.Command("osascript", "-e", "display notification "+quote(body)+" with title "+quote(title))
+	case "windows":
+		return
+	default:
+		cmd = exec.Command("notify-send", "--app-name=p2p-git-remote", "--urgency=critical", title, body)
+	}
+	go func() {
+		if err := cmd.Run(); err != nil {
+			slog.Debug("No desktop notification", "err", err)
+		}
+	}()
+}
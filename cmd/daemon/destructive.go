@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/libp2p/go-libp2p/core/network"
+
+	"github.com/hemantsingh443/p2p-git-remote/internal/protocol"
+)
+
+// How destructive requests are confirmed, set by -confirm-destructive.
+const (
+	confirmByToken = "token" // The request repeats the repo name, typed by the user
+	confirmLocally = "local" // The daemon's owner approves each one on the console
+	// Another admin client approves each one, see approvalQueue
+	confirmBySecondDevice = "admin"
+)
+
+var destructiveConfirmation = confirmByToken
+
+// consoleMutex keeps questions to the daemon's owner from interleaving.
+var consoleMutex sync.Mutex
+
+// destructiveAction describes what a request throws away, or returns "" if
+// it isn't destructive.
+func destructiveAction(msgType string, rawPayload json.RawMessage) string {
+	var payload struct {
+		Amend bool `json:"amend"`
+		Force bool `json:"force"`
+	}
+	json.Unmarshal(rawPayload, &payload)
+	switch {
+	case msgType == protocol.TypeGitResetRequest:
+		return "a hard reset"
+	case msgType == protocol.TypeResetToReflogRequest:
+		return "a hard reset to a reflog entry"
+	case msgType == protocol.TypeGitCleanRequest && payload.Force:
+		return "deleting untracked files"
+	case msgType == protocol.TypeGitCommitRequest && payload.Amend && payload.Force:
+		// The only force push there is; branches can't be deleted by peers
+		return "amending a pushed commit and force-pushing it"
+	case msgType == protocol.TypeCheckoutFileRequest:
+		return "discarding the changes to a file"
+	case msgType == protocol.TypeDeletePathRequest && payload.Force:
+		return "deleting a path with its uncommitted changes"
+	}
+	return ""
+}
+
+// confirmDestructive checks that a destructive request was confirmed, and
+// returns why it's refused if not.
+func confirmDestructive(stream network.Stream, msgType string, rawPayload json.RawMessage) string {
+	action := destructiveAction(msgType, rawPayload)
+	if action == "" {
+		return ""
+	}
+	var payload struct {
+		RepoPath string `json:"repo_path"`
+		Confirm  string `json:"confirm"`
+	}
+	json.Unmarshal(rawPayload, &payload)
+	remotePeer := stream.Conn().RemotePeer()
+
+	if destructiveConfirmation == confirmBySecondDevice {
+		return approvals.await(stream, msgType, action, payload.RepoPath)
+	}
+	if destructiveConfirmation == confirmLocally && console != nil {
+		if !console.confirm(remotePeer, action, payload.RepoPath) {
+			return fmt.Sprintf("the daemon's owner refused %s", action)
+		}
+		return ""
+	}
+	if destructiveConfirmation == confirmLocally {
+		consoleMutex.Lock()
+		defer consoleMutex.Unlock()
+		fmt.Printf("\n>>> Peer %s asks for %s in repo '%s'.\n", remotePeer, action, payload.RepoPath)
+		fmt.Print(">>> Allow it? (y/n): ")
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if strings.TrimSpace(answer) != "y" {
+			return fmt.Sprintf("the daemon's owner refused %s", action)
+		}
+		trustLog.Info("Owner approved a destructive request", "action", action, "repo", payload.RepoPath, "peer", remotePeer)
+		return ""
+	}
+	if payload.RepoPath == "" || payload.Confirm != payload.RepoPath {
+		return fmt.Sprintf("%s can't be undone; confirm it by typing the repository name ('%s')", action, payload.RepoPath)
+	}
+	return ""
+}
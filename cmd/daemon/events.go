@@ -0,0 +1,191 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hemantsingh443/p2p-git-remote/internal/git"
+	"github.com/hemantsingh443/p2p-git-remote/internal/protocol"
+)
+
+// repoState is what a repo's branches looked like when last checked, to tell
+// what changed.
+type repoState struct {
+	current  string
+	branches map[string]string // Name -> tip
+}
+
+// watchGitDir watches the refs of a repo, so commits and branch changes are
+// published as REPO_EVENTs whether a client or someone on this machine made
+// them. Only HEAD, packed-refs and refs/heads matter.
+func (w *repoWatcher) watchGitDir(alias, repoPath string) {
+	gitDir := filepath.Join(repoPath, ".git")
+	if info, err := os.Stat(gitDir); err != nil || !info.IsDir() {
+		return // A worktree or submodule; its refs live elsewhere
+	}
+	if err := w.watcher.Add(gitDir); err != nil {
+		gitLog.Warn("Cannot watch git directory", "path", gitDir, "err", err)
+		return
+	}
+	filepath.WalkDir(filepath.Join(gitDir, "refs", "heads"), func(path string, d os.DirEntry, err error) error {
+		if err == nil && d.IsDir() {
+			w.watcher.Add(path)
+		}
+		return nil
+	})
+	w.mu.Lock()
+	w.states[alias] = readRepoState(repoPath)
+	w.mu.Unlock()
+}
+
+// isRefChange reports whether rel, a path inside .git, is one whose change
+// may move a branch.
+func isRefChange(rel string) bool {
+	rel = filepath.ToSlash(rel)
+	switch rel {
+	case ".git/HEAD", ".git/packed-refs":
+		return true
+	}
+	return strings.HasPrefix(rel, ".git/refs/heads/") && !strings.HasSuffix(rel, ".lock")
+}
+
+// refsChanged checks a repo's refs once changes to them settle.
+func (w *repoWatcher) refsChanged(alias string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.refChecks[alias] {
+		return
+	}
+	w.refChecks[alias] = true
+	time.AfterFunc(changeBatchDelay, func() { w.publishRefs(alias) })
+}
+
+func readRepoState(repoPath string) repoState {
+	state := repoState{branches: make(map[string]string)}
+	branches, err := git.Branches(repoPath)
+	if err != nil {
+		return state
+	}
+	for _, b := range branches {
+		state.branches[b.Name] = b.Hash
+		if b.Current {
+			state.current = b.Name
+		}
+	}
+	return state
+}
+
+// publishRefs compares a repo's branches with what they were and sends what
+// changed to the subscribers of repo events.
+func (w *repoWatcher) publishRefs(alias string) {
+	repoPath, ok := resolveRepo(alias)
+	w.mu.Lock()
+	delete(w.refChecks, alias)
+	old := w.states[alias]
+	w.mu.Unlock()
+	if !ok {
+		return
+	}
+	now := readRepoState(repoPath)
+
+	var events []protocol.RepoEventPayload
+	for name, hash := range now.branches {
+		event := protocol.RepoEventPayload{Topic: protocol.EventTopic(alias), RepoPath: alias, Branch: name, Hash: hash}
+		switch oldHash, existed := old.branches[name]; {
+		case !existed:
+			event.Kind = protocol.RepoEventBranchCreated
+		case oldHash != hash:
+			event.Kind = protocol.RepoEventCommit
+			if c, err := git.DescribeCommit(repoPath, hash); err == nil {
+				event.Subject, event.Author = c.Subject, c.AuthorName
+			}
+		default:
+			continue
+		}
+		events = append(events, event)
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].Branch < events[j].Branch })
+	for name := range old.branches {
+		if _, ok := now.branches[name]; !ok {
+			events = append(events, protocol.RepoEventPayload{Topic: protocol.EventTopic(alias), RepoPath: alias, Kind: protocol.RepoEventBranchDeleted, Branch: name})
+		}
+	}
+	if now.current != old.current && now.current != "" {
+		events = append(events, protocol.RepoEventPayload{Topic: protocol.EventTopic(alias), RepoPath: alias, Kind: protocol.RepoEventCheckout, Branch: now.current, Hash: now.branches[now.current]})
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.states[alias] = now
+	for _, event := range events {
+		if event.Kind == protocol.RepoEventCommit {
+			fireWebhooks(webhookEvent{Event: webhookCommit, Repo: alias, Branch: event.Branch, Hash: event.Hash, Subject: event.Subject, Author: event.Author})
+		}
+		for ch, filter := range w.repoSubs {
+			if filter != "" && filter != alias {
+				continue
+			}
+			select {
+			case ch <- event:
+			default:
+				// Like file events, dropped for subscribers that can't keep up
+			}
+		}
+	}
+}
+
+func (w *repoWatcher) subscribeRepo(alias string) chan protocol.RepoEventPayload {
+	ch := make(chan protocol.RepoEventPayload, 16)
+	w.mu.Lock()
+	w.repoSubs[ch] = alias
+	w.mu.Unlock()
+	return ch
+}
+
+func (w *repoWatcher) unsubscribeRepo(ch chan protocol.RepoEventPayload) {
+	w.mu.Lock()
+	delete(w.repoSubs, ch)
+	w.mu.Unlock()
+}
+
+// renameRepo follows a repo's new alias, and tells the clients watching it.
+// Those only watching its files keep getting their events, under the new
+// alias.
+func (w *repoWatcher) renameRepo(alias, newAlias string) {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for root, a := range w.roots {
+		if a == alias {
+			w.roots[root] = newAlias
+		}
+	}
+	if state, ok := w.states[alias]; ok {
+		w.states[newAlias] = state
+		delete(w.states, alias)
+	}
+	for ch, filter := range w.subs {
+		if filter == alias {
+			w.subs[ch] = newAlias
+		}
+	}
+
+	event := protocol.RepoEventPayload{Topic: protocol.EventTopic(newAlias), RepoPath: newAlias, Kind: protocol.RepoEventRenamed, OldAlias: alias}
+	for ch, filter := range w.repoSubs {
+		if filter != "" && filter != alias {
+			continue
+		}
+		if filter == alias {
+			w.repoSubs[ch] = newAlias
+		}
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"sync/atomic"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/control"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+
+	"github.com/hemantsingh443/p2p-git-remote/internal/protocol"
+)
+
+// trustGater refuses connections from blocked peers and addresses and, if
+// enabled, from peers the daemon doesn't trust, so strangers who find its
+// address can't even reach the pairing prompt. New clients pair during a
+// pairing window, opened by -pairing-window or by an admin client.
+type trustGater struct {
+	enabled      bool
+	pairingUntil atomic.Int64 // Unix nanoseconds
+}
+
+var gater = &trustGater{}
+
+// openPairing lets untrusted peers connect for d from now on, or closes the
+// window if d is 0.
+func (g *trustGater) openPairing(d time.Duration) time.Time {
+	until := time.Now().Add(d)
+	if d <= 0 {
+		until = time.Time{}
+	}
+	g.pairingUntil.Store(until.UnixNano())
+	return until
+}
+
+func (g *trustGater) pairingOpen() bool {
+	return time.Now().UnixNano() < g.pairingUntil.Load()
+}
+
+// admits reports whether a peer may talk to the daemon at all.
+func (g *trustGater) admits(p peer.ID) bool {
+	return !g.enabled || g.pairingOpen() || trustStore.IsTrusted(p)
+}
+
+func (g *trustGater) InterceptPeerDial(p peer.ID) bool {
+	return !blocklist.BlocksPeer(p)
+}
+
+func (g *trustGater) InterceptAddrDial(peer.ID, multiaddr.Multiaddr) bool { return true }
+
+// InterceptAccept refuses blocked addresses before any handshake.
+func (g *trustGater) InterceptAccept(addrs network.ConnMultiaddrs) bool {
+	ip, err := manet.ToIP(addrs.RemoteMultiaddr())
+	if err == nil && blocklist.BlocksIP(ip) {
+		p2pLog.Info("Refused connection from blocked address", "addr", addrs.RemoteMultiaddr())
+		return false
+	}
+	return true
+}
+
+// InterceptSecured is the first point at which an inbound peer's identity
+// is known.
+func (g *trustGater) InterceptSecured(dir network.Direction, p peer.ID, addrs network.ConnMultiaddrs) bool {
+	if blocklist.BlocksPeer(p) {
+		p2pLog.Info("Refused connection from blocked peer", "peer", p)
+		return false
+	}
+	if dir == network.DirOutbound || g.admits(p) {
+		return true
+	}
+	p2pLog.Info("Refused connection from untrusted peer: no pairing window is open", "peer", p, "addr", addrs.RemoteMultiaddr())
+	return false
+}
+
+func (g *trustGater) InterceptUpgraded(network.Conn) (bool, control.DisconnectReason) {
+	return true, 0
+}
+
+func handlePairingWindow(stream network.Stream, rawPayload json.RawMessage) {
+	var payload protocol.PairingWindowRequestPayload
+	json.Unmarshal(rawPayload, &payload)
+	trustLog.Info("Handling PairingWindow request", "minutes", payload.Minutes)
+
+	respPayload := protocol.PairingWindowResponsePayload{Gated: gater.enabled}
+	if payload.Minutes < 0 {
+		respPayload.Success = false
+		respPayload.Error = "the pairing window can't be negative"
+	} else {
+		respPayload.Success = true
+		respPayload.Until = gater.openPairing(time.Duration(payload.Minutes) * time.Minute)
+		if payload.Minutes > 0 {
+			trustLog.Info("Pairing window open", "until", respPayload.Until.Format("15:04:05"))
+		} else {
+			trustLog.Info("Pairing window closed")
+		}
+	}
+	payloadBytes, _ := json.Marshal(respPayload)
+	response := &protocol.Message{Type: protocol.TypePairingWindowResponse, Payload: payloadBytes}
+	protocol.WriteMessage(stream, response)
+}
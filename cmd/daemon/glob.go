@@ -0,0 +1,54 @@
+package main
+
+import (
+	"path"
+	"strings"
+)
+
+// matchGlob reports whether the slash-separated relPath matches pattern.
+// Besides the path.Match syntax, a "**" segment matches any number of
+// directories, so "src/**/*.go" matches both src/a.go and src/x/y/b.go.
+func matchGlob(pattern, relPath string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(relPath, "/"))
+}
+
+// matchSegments matches path segments against pattern segments.
+func matchSegments(pattern, segments []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			// Match zero segments, or one and try again
+			if matchSegments(pattern[1:], segments) {
+				return true
+			}
+			return len(segments) > 0 && matchSegments(pattern, segments[1:])
+		}
+		if len(segments) == 0 {
+			return false
+		}
+		if ok, err := path.Match(pattern[0], segments[0]); err != nil || !ok {
+			return false
+		}
+		pattern, segments = pattern[1:], segments[1:]
+	}
+	return len(segments) == 0
+}
+
+// anyGlob reports whether match accepts relPath for any of patterns.
+func anyGlob(patterns []string, relPath string, match func(pattern, relPath string) bool) bool {
+	for _, pattern := range patterns {
+		if match(pattern, relPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// validGlob reports whether pattern is well formed.
+func validGlob(pattern string) bool {
+	for _, segment := range strings.Split(pattern, "/") {
+		if _, err := path.Match(segment, ""); err != nil {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	libp2pprotocol "github.com/libp2p/go-libp2p/core/protocol"
+
+	"github.com/hemantsingh443/p2p-git-remote/internal/protocol"
+)
+
+// requestHandler serves one request a trusted peer sent on a stream.
+type requestHandler func(stream network.Stream, payload json.RawMessage)
+
+// handlerKey identifies a handler by the protocol version the stream speaks
+// and the request's message type.
+type handlerKey struct {
+	version libp2pprotocol.ID
+	msgType string
+}
+
+// handlers holds every request the daemon serves. The daemon listens on each
+// protocol version that has handlers, so during an upgrade the old version
+// keeps working while a new one registers its handlers under its own ID.
+var handlers = make(map[handlerKey]requestHandler)
+
+// handle registers the handler of a message type for a protocol version.
+// Registering one twice is a bug, and panics.
+func handle(version libp2pprotocol.ID, msgType string, h requestHandler) {
+	key := handlerKey{version, msgType}
+	if _, ok := handlers[key]; ok {
+		panic("two handlers for " + msgType + " on " + string(version))
+	}
+	handlers[key] = h
+}
+
+func lookupHandler(version libp2pprotocol.ID, msgType string) (requestHandler, bool) {
+	h, ok := handlers[handlerKey{version, msgType}]
+	return h, ok
+}
+
+// handlerVersions returns the protocol versions with handlers, to listen on.
+func handlerVersions() []libp2pprotocol.ID {
+	seen := make(map[libp2pprotocol.ID]bool)
+	var versions []libp2pprotocol.ID
+	for key := range handlers {
+		if !seen[key.version] {
+			seen[key.version] = true
+			versions = append(versions, key.version)
+		}
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i] < versions[j] })
+	return versions
+}
+
+// withoutPayload adapts a handler that takes no payload.
+func withoutPayload(h func(network.Stream)) requestHandler {
+	return func(stream network.Stream, _ json.RawMessage) { h(stream) }
+}
+
+func init() {
+	v1 := libp2pprotocol.ID(protocol.ProtocolID)
+	handle(v1, protocol.TypeGitCommitRequest, handleGitCommit)
+	handle(v1, protocol.TypeListReposRequest, withoutPayload(handleListRepos))
+	handle(v1, protocol.TypeReadFileRequest, handleReadFile)
+	handle(v1, protocol.TypeListFilesRequest, handleListFiles)
+	handle(v1, protocol.TypeCreateBranchRequest, handleCreateBranch)
+	handle(v1, protocol.TypeRenameFileRequest, handleRenameFile)
+	handle(v1, protocol.TypeWriteFileRequest, handleWriteFile)
+	handle(v1, protocol.TypeListBranchesRequest, handleListBranches)
+	handle(v1, protocol.TypeLinkRepoRequest, handleLinkRepo)
+	handle(v1, protocol.TypeSwitchBranchRequest, handleSwitchBranch)
+	handle(v1, protocol.TypeGitStatusRequest, handleGitStatus)
+	handle(v1, protocol.TypeGitLogRequest, handleGitLog)
+	handle(v1, protocol.TypeFileLogRequest, handleFileLog)
+	handle(v1, protocol.TypeGitDiffRequest, handleGitDiff)
+	handle(v1, protocol.TypeGitStashSaveRequest, handleGitStashSave)
+	handle(v1, protocol.TypeGitStashPopRequest, handleGitStashPop)
+	handle(v1, protocol.TypeGitStashListRequest, handleGitStashList)
+	handle(v1, protocol.TypeGitStashApplyRequest, handleGitStashApply)
+	handle(v1, protocol.TypeGitStashDropRequest, handleGitStashDrop)
+	handle(v1, protocol.TypeGitResetRequest, handleGitReset)
+	handle(v1, protocol.TypeGitCleanRequest, handleGitClean)
+	handle(v1, protocol.TypeListDirRequest, handleListDir)
+	handle(v1, protocol.TypeApplyPatchRequest, handleApplyPatch)
+	handle(v1, protocol.TypeBatchRequest, handleBatch)
+	handle(v1, protocol.TypeAuditLogRequest, handleAuditLog)
+	handle(v1, protocol.TypeListPeersRequest, withoutPayload(handleListPeers))
+	handle(v1, protocol.TypeRevokePeerRequest, handleRevokePeer)
+	handle(v1, protocol.TypeSessionRequest, withoutPayload(handleSessionRequest))
+	handle(v1, protocol.TypeReadOnlyRequest, handleReadOnly)
+	handle(v1, protocol.TypePairingWindowRequest, handlePairingWindow)
+	handle(v1, protocol.TypeBlockRequest, handleBlock)
+	handle(v1, protocol.TypeApprovalsRequest, withoutPayload(handleApprovals))
+	handle(v1, protocol.TypeNetStatusRequest, withoutPayload(handleNetStatus))
+	handle(v1, protocol.TypePingRequest, handlePing)
+	handle(v1, protocol.TypeRenameAliasRequest, handleRenameAlias)
+	handle(v1, protocol.TypeTailRequest, handleTail)
+	handle(v1, protocol.TypeChmodRequest, handleChmod)
+	handle(v1, protocol.TypeGrepRequest, handleGrep)
+	handle(v1, protocol.TypeCreateFileRequest, handleCreateFile)
+	handle(v1, protocol.TypeMkdirRequest, handleMkdir)
+	handle(v1, protocol.TypeDeletePathRequest, handleDeletePath)
+	handle(v1, protocol.TypeCheckoutFileRequest, handleCheckoutFile)
+	handle(v1, protocol.TypeListHunksRequest, handleListHunks)
+	handle(v1, protocol.TypeStageHunksRequest, handleStageHunks)
+	handle(v1, protocol.TypeReflogRequest, handleReflog)
+	handle(v1, protocol.TypeResetToReflogRequest, handleResetToReflog)
+	handle(v1, protocol.TypeListWorktreesRequest, handleListWorktrees)
+	handle(v1, protocol.TypeAddWorktreeRequest, handleAddWorktree)
+	handle(v1, protocol.TypeConflictsListRequest, handleConflictsList)
+	handle(v1, protocol.TypeResolveConflictRequest, handleResolveConflict)
+	handle(v1, protocol.TypeContinueMergeRequest, handleContinueMerge)
+	handle(v1, protocol.TypeBisectStartRequest, handleBisectStart)
+	handle(v1, protocol.TypeBisectGoodRequest, func(stream network.Stream, payload json.RawMessage) {
+		handleBisectMark(stream, payload, "good")
+	})
+	handle(v1, protocol.TypeBisectBadRequest, func(stream network.Stream, payload json.RawMessage) {
+		handleBisectMark(stream, payload, "bad")
+	})
+	handle(v1, protocol.TypeBisectResetRequest, handleBisectReset)
+	handle(v1, protocol.TypeGetCommitTemplateRequest, handleGetCommitTemplate)
+	handle(v1, protocol.TypeRepoStatsRequest, handleRepoStats)
+	handle(v1, protocol.TypeBundleCreateRequest, handleBundleCreate)
+	handle(v1, protocol.TypeBundleUploadRequest, handleBundleUpload)
+	handle(v1, protocol.TypeSubscribeEventsRequest, handleSubscribeEvents)
+	handle(v1, protocol.TypeDownloadFileRequest, handleDownloadFile)
+	handle(v1, protocol.TypeUploadFileRequest, handleUploadFile)
+	handle(v1, protocol.TypeListRemotesRequest, handleListRemotes)
+	handle(v1, protocol.TypeSetDefaultRemoteRequest, handleSetDefaultRemote)
+	handle(v1, protocol.TypeGitConfigGetRequest, handleGitConfigGet)
+	handle(v1, protocol.TypeGitConfigSetRequest, handleGitConfigSet)
+	handle(v1, protocol.TypeIgnoreRequest, handleIgnore)
+	handle(v1, protocol.TypeUnignoreRequest, handleUnignore)
+	handle(v1, protocol.TypeRunHookRequest, handleRunHook)
+	handle(v1, protocol.TypeDaemonStatusRequest, withoutPayload(handleDaemonStatus))
+	handle(v1, protocol.TypeJobsListRequest, handleJobsList)
+	handle(v1, protocol.TypeJobStatusRequest, handleJobStatus)
+	handle(v1, protocol.TypeJobCancelRequest, handleJobCancel)
+}
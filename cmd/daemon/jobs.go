@@ -0,0 +1,387 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+
+	"github.com/hemantsingh443/p2p-git-remote/internal/git"
+	"github.com/hemantsingh443/p2p-git-remote/internal/protocol"
+	"github.com/hemantsingh443/p2p-git-remote/internal/store"
+)
+
+// jobsFile keeps the recent jobs across restarts, so a client can still
+// find out how a push it started ended, or that the daemon stopped during
+// it.
+const jobsFile = "jobs.json"
+
+// jobsKept is how many finished jobs are remembered.
+const jobsKept = 50
+
+// job is a long operation, like a push, run for a client. Jobs on the same
+// repo run one at a time, in the order they were started.
+type job struct {
+	mu      sync.Mutex
+	info    protocol.JobInfo
+	output  bytes.Buffer  // What it printed so far, until it ends
+	updated chan struct{} // Closed, and replaced, when output or state change
+
+	abort     chan struct{}
+	abortOnce sync.Once
+}
+
+var (
+	jobsMu     sync.Mutex
+	jobsSaveMu sync.Mutex                       // So an older list is never written over a newer one
+	jobs       []*job                           // Oldest first
+	repoQueues = make(map[string]chan struct{}) // Repo path -> slot of the running job
+)
+
+// loadJobs reads the jobs of the daemon's previous run. Those that hadn't
+// ended never will.
+func loadJobs() {
+	data, err := os.ReadFile(statePath(jobsFile))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			slog.Warn("Failed to read jobs file", "err", err)
+		}
+		return
+	}
+	var infos []protocol.JobInfo
+	if err := json.Unmarshal(data, &infos); err != nil {
+		slog.Warn("Failed to parse jobs file", "err", err)
+		return
+	}
+	interrupted := 0
+	for _, info := range infos {
+		if info.State == protocol.JobQueued || info.State == protocol.JobRunning {
+			info.State = protocol.JobInterrupted
+			info.Error = "the daemon stopped before it ended"
+			interrupted++
+		}
+		jobs = append(jobs, &job{info: info, updated: make(chan struct{}), abort: make(chan struct{})})
+	}
+	if interrupted > 0 {
+		gitLog.Warn("Jobs were interrupted by the daemon stopping", "count", interrupted)
+		saveJobs()
+	}
+}
+
+// saveJobs writes jobs.json. Output is only kept for finished jobs.
+func saveJobs() {
+	jobsSaveMu.Lock()
+	defer jobsSaveMu.Unlock()
+	jobsMu.Lock()
+	infos := make([]protocol.JobInfo, 0, len(jobs))
+	for _, j := range jobs {
+		info := j.snapshot(true)
+		if info.State == protocol.JobQueued || info.State == protocol.JobRunning {
+			info.Output = ""
+		}
+		infos = append(infos, info)
+	}
+	jobsMu.Unlock()
+	data, _ := json.MarshalIndent(infos, "", "  ")
+	if err := writeFileAtomic(statePath(jobsFile), data); err != nil {
+		slog.Warn("Failed to save jobs", "err", err)
+	}
+}
+
+// newJob queues a job for a repo, forgetting the oldest finished jobs if
+// there are too many.
+func newJob(kind, repo, branch string, p peer.ID) *job {
+	id := make([]byte, 4)
+	rand.Read(id)
+	j := &job{
+		info: protocol.JobInfo{
+			ID:       hex.EncodeToString(id),
+			Kind:     kind,
+			RepoPath: repo,
+			Branch:   branch,
+			Peer:     p.String(),
+			State:    protocol.JobQueued,
+			Created:  time.Now(),
+		},
+		updated: make(chan struct{}),
+		abort:   make(chan struct{}),
+	}
+	jobsMu.Lock()
+	jobs = append(jobs, j)
+	finished := 0
+	for _, other := range jobs {
+		if other.ended() {
+			finished++
+		}
+	}
+	for i := 0; finished > jobsKept && i < len(jobs); {
+		if jobs[i].ended() {
+			jobs = append(jobs[:i], jobs[i+1:]...)
+			finished--
+		} else {
+			i++
+		}
+	}
+	jobsMu.Unlock()
+	saveJobs()
+	return j
+}
+
+// run waits for the repo at repoPath to be free, then runs fn, which is
+// given where to print its progress and a channel closed if the job is
+// cancelled.
+func (j *job) run(repoPath string, fn func(progress io.Writer, abort <-chan struct{}) (string, error)) (string, error) {
+	jobsMu.Lock()
+	slot, ok := repoQueues[repoPath]
+	if !ok {
+		slot = make(chan struct{}, 1)
+		repoQueues[repoPath] = slot
+	}
+	jobsMu.Unlock()
+
+	select {
+	case slot <- struct{}{}:
+	case <-j.abort:
+		err := fmt.Errorf("%w before it started", git.ErrCancelled)
+		j.finish("", err)
+		return "", err
+	}
+	j.setState(protocol.JobRunning)
+	gitLog.Info("Job started", "job", j.info.ID, "kind", j.info.Kind, "repo", j.info.RepoPath)
+	output, err := fn(j, j.abort)
+	<-slot
+	j.finish(output, err)
+	return output, err
+}
+
+// Write adds to the job's output, for whoever follows it.
+func (j *job) Write(p []byte) (int, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.output.Write(p)
+	j.notify()
+	return len(p), nil
+}
+
+// notify wakes up followers; j.mu must be held.
+func (j *job) notify() {
+	close(j.updated)
+	j.updated = make(chan struct{})
+}
+
+func (j *job) setState(state string) {
+	j.mu.Lock()
+	j.info.State = state
+	j.notify()
+	j.mu.Unlock()
+	saveJobs()
+}
+
+// finish records how the job ended. output replaces what it printed as it
+// ran.
+func (j *job) finish(output string, err error) {
+	j.mu.Lock()
+	j.info.Finished = time.Now()
+	switch {
+	case err == nil:
+		j.info.State = protocol.JobDone
+	case errors.Is(err, git.ErrCancelled):
+		j.info.State = protocol.JobCancelled
+	default:
+		j.info.State = protocol.JobFailed
+	}
+	if err != nil {
+		j.info.Error = err.Error()
+	}
+	if output != "" {
+		j.info.Output = output
+	} else {
+		j.info.Output = j.output.String()
+	}
+	j.output.Reset()
+	j.notify()
+	state, took := j.info.State, j.info.Finished.Sub(j.info.Created)
+	j.mu.Unlock()
+	gitLog.Info("Job ended", "job", j.info.ID, "state", state, "took", took.Round(time.Millisecond))
+	saveJobs()
+}
+
+func (j *job) ended() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.info.State != protocol.JobQueued && j.info.State != protocol.JobRunning
+}
+
+// snapshot returns the job's info, with its output so far if withOutput.
+func (j *job) snapshot(withOutput bool) protocol.JobInfo {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	info := j.info
+	if info.State == protocol.JobQueued || info.State == protocol.JobRunning {
+		info.Output = j.output.String()
+	}
+	if !withOutput {
+		info.Output = ""
+	}
+	return info
+}
+
+// cancel kills the job, or takes it out of the queue.
+func (j *job) cancel() error {
+	if j.ended() {
+		return fmt.Errorf("job %s has already ended", j.info.ID)
+	}
+	j.abortOnce.Do(func() { close(j.abort) })
+	return nil
+}
+
+func findJob(id string) *job {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	for _, j := range jobs {
+		if j.info.ID == id {
+			return j
+		}
+	}
+	return nil
+}
+
+// cancelJobs cancels every queued or running job.
+func cancelJobs() {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	for _, j := range jobs {
+		if !j.ended() {
+			gitLog.Warn("Cancelling job", "job", j.info.ID, "kind", j.info.Kind, "repo", j.info.RepoPath)
+			j.cancel()
+		}
+	}
+}
+
+// runningJobs returns how many jobs are queued or running.
+func runningJobs() int {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	n := 0
+	for _, j := range jobs {
+		if !j.ended() {
+			n++
+		}
+	}
+	return n
+}
+
+func handleJobsList(stream network.Stream, rawPayload json.RawMessage) {
+	var payload protocol.JobsListRequestPayload
+	json.Unmarshal(rawPayload, &payload)
+	gitLog.Info("Handling JobsList request")
+
+	respPayload := protocol.JobsListResponsePayload{Success: true, Jobs: []protocol.JobInfo{}}
+	jobsMu.Lock()
+	for _, j := range jobs {
+		if payload.All || !j.ended() {
+			respPayload.Jobs = append(respPayload.Jobs, j.snapshot(false))
+		}
+	}
+	jobsMu.Unlock()
+	payloadBytes, _ := json.Marshal(respPayload)
+	response := &protocol.Message{Type: protocol.TypeJobsListResponse, Payload: payloadBytes}
+	protocol.WriteMessage(stream, response)
+}
+
+func handleJobStatus(stream network.Stream, rawPayload json.RawMessage) {
+	var payload protocol.JobStatusRequestPayload
+	json.Unmarshal(rawPayload, &payload)
+	gitLog.Info("Handling JobStatus request", "job", payload.ID, "follow", payload.Follow)
+
+	respPayload := protocol.JobStatusResponsePayload{}
+	j := findJob(payload.ID)
+	if j == nil {
+		respPayload.Success = false
+		respPayload.Error = fmt.Sprintf("no job %s", payload.ID)
+	} else {
+		respPayload.Success = true
+		if payload.Follow && !followJob(stream, j) {
+			return
+		}
+		respPayload.Job = j.snapshot(true)
+	}
+	payloadBytes, _ := json.Marshal(respPayload)
+	response := &protocol.Message{Type: protocol.TypeJobStatusResponse, Payload: payloadBytes}
+	protocol.WriteMessage(stream, response)
+}
+
+// followJob sends what the job prints until it ends. It returns false if
+// the client went away first.
+func followJob(stream network.Stream, j *job) bool {
+	// The client sends nothing more; a read only returns once it goes away
+	closed := make(chan struct{})
+	go func() {
+		protocol.ReadMessage(stream)
+		close(closed)
+	}()
+
+	sent := 0
+	for {
+		j.mu.Lock()
+		running := j.info.State == protocol.JobQueued || j.info.State == protocol.JobRunning
+		var chunk string
+		if running && j.output.Len() > sent {
+			chunk = j.output.String()[sent:]
+			sent = j.output.Len()
+		}
+		updated := j.updated
+		j.mu.Unlock()
+		if !running {
+			return true
+		}
+		if chunk != "" {
+			payloadBytes, _ := json.Marshal(protocol.JobProgressPayload{ID: j.info.ID, Output: chunk})
+			if err := protocol.WriteMessage(stream, &protocol.Message{Type: protocol.TypeJobProgress, Payload: payloadBytes}); err != nil {
+				return false
+			}
+		}
+		select {
+		case <-updated:
+		case <-closed:
+			return false
+		}
+	}
+}
+
+func handleJobCancel(stream network.Stream, rawPayload json.RawMessage) {
+	var payload protocol.JobCancelRequestPayload
+	json.Unmarshal(rawPayload, &payload)
+	remotePeer := stream.Conn().RemotePeer()
+	gitLog.Info("Handling JobCancel request", "job", payload.ID, "peer", remotePeer)
+
+	respPayload := protocol.JobCancelResponsePayload{}
+	j := findJob(payload.ID)
+	role, _ := trustStore.Role(remotePeer)
+	switch {
+	case j == nil:
+		respPayload.Error = fmt.Sprintf("no job %s", payload.ID)
+	case j.info.Peer != remotePeer.String() && !role.Allows(store.RoleAdmin):
+		// Another client's push is theirs to cancel
+		respPayload.Error = "only the peer that started a job, or an admin, can cancel it"
+	default:
+		if err := j.cancel(); err != nil {
+			respPayload.Error = err.Error()
+		} else {
+			respPayload.Success = true
+		}
+	}
+	payloadBytes, _ := json.Marshal(respPayload)
+	response := &protocol.Message{Type: protocol.TypeJobCancelResponse, Payload: payloadBytes}
+	protocol.WriteMessage(stream, response)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// lockFile, in the state directory, is locked by the running daemon and
+// holds its process ID, so a second one started on the same state doesn't
+// fight it over its files.
+const lockFile = "daemon.lock"
+
+// errLocked is returned by tryLock when another process holds the lock.
+var errLocked = errors.New("locked")
+
+// instanceLock stays open, and so locked, until the daemon exits.
+var instanceLock *os.File
+
+// lockInstance makes sure this is the only daemon using the state
+// directory. With replace, it stops the running one and waits for it to
+// shut down cleanly; otherwise it fails, saying which process has the lock.
+func lockInstance(replace bool) error {
+	f, err := os.OpenFile(statePath(lockFile), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	err = tryLock(f)
+	if errors.Is(err, errLocked) {
+		pid := lockHolder(f)
+		if !replace {
+			f.Close()
+			return fmt.Errorf("another daemon (pid %d) is already running with state directory %s; stop it, start this one with -replace to take over, or give it another -state-dir", pid, stateDir)
+		}
+		err = replaceInstance(f, pid)
+	}
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	f.Truncate(0)
+	f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	f.Sync()
+	instanceLock = f
+	return nil
+}
+
+// replaceInstance asks the daemon holding the lock to stop, and waits for
+// it to let go of the lock: up to its shutdown timeout, if it's the same as
+// this one's, and a bit more.
+func replaceInstance(f *os.File, pid int) error {
+	if pid <= 0 {
+		return errors.New("another daemon is running, but its process ID is unknown; stop it by hand")
+	}
+	slog.Info("Replacing the running daemon: asking it to stop", "pid", pid)
+	if err := terminate(pid); err != nil {
+		return fmt.Errorf("failed to stop the running daemon (pid %d): %w", pid, err)
+	}
+	deadline := time.Now().Add(shutdownTimeout + 15*time.Second)
+	for {
+		err := tryLock(f)
+		if !errors.Is(err, errLocked) {
+			if err == nil {
+				slog.Info("The replaced daemon stopped", "pid", pid)
+			}
+			return err
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("the running daemon (pid %d) didn't stop in time", pid)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// lockHolder reads the process ID the daemon holding the lock wrote.
+func lockHolder(f *os.File) int {
+	buf := make([]byte, 32)
+	n, _ := f.ReadAt(buf, 0)
+	pid, _ := strconv.Atoi(strings.TrimSpace(string(buf[:n])))
+	return pid
+}
@@ -0,0 +1,24 @@
+//go:build !windows
+
+package main
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// tryLock takes an exclusive lock on f without waiting. The kernel drops it
+// when the process exits, however it exits.
+func tryLock(f *os.File) error {
+	err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		return errLocked
+	}
+	return err
+}
+
+// terminate asks a daemon to shut down, as SIGTERM from systemd would.
+func terminate(pid int) error {
+	return syscall.Kill(pid, syscall.SIGTERM)
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+
+	"github.com/hemantsingh443/p2p-git-remote/internal/logging"
+)
+
+// Loggers of the daemon's subsystems, each of which can be given its own
+// level, e.g. -log-level info,files=debug. Everything else logs through
+// slog's default logger.
+var (
+	p2pLog   = logging.Scope("p2p")
+	gitLog   = logging.Scope("git")
+	filesLog = logging.Scope("files")
+	trustLog = logging.Scope("trust")
+)
+
+// fatal logs an error and exits, like log.Fatal.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
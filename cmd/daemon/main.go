@@ -2,80 +2,322 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"io/fs"
 	"log"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/url"
 	"os"
-	"os/exec"
+	"os/signal"
+	"path"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
-
+	"sync"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/metrics"
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/pnet"
+	"github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
 	"github.com/skip2/go-qrcode"
 
+	"github.com/hemantsingh443/p2p-git-remote/internal/control"
 	"github.com/hemantsingh443/p2p-git-remote/internal/git"
+	"github.com/hemantsingh443/p2p-git-remote/internal/logging"
 	p2p "github.com/hemantsingh443/p2p-git-remote/internal/p2p"
 	"github.com/hemantsingh443/p2p-git-remote/internal/protocol"
+	"github.com/hemantsingh443/p2p-git-remote/internal/sandbox"
 	"github.com/hemantsingh443/p2p-git-remote/internal/store"
+	"github.com/hemantsingh443/p2p-git-remote/internal/systemd"
 )
 
 var trustStore *store.TrustStore
 var linkedRepos map[string]string // Alias -> Path
 
+// configMu guards linkedRepos, repoConfigs, policy and webhooks: the admin
+// socket, SIGHUP and peers' requests change them while other requests read
+// them. Loading them at startup, before anything else runs, goes without.
+var configMu sync.RWMutex
+
 const linkedReposFile = "linked_repos.json"
 
 func main() {
 	// Command-line flags
 	listenPort := flag.Int("port", 4001, "Port to listen on")
 	repoFlag := flag.String("repo", "", "Alias and path to a git repo (e.g., my-project:/path/to/your/repo)")
+	flag.StringVar(&stateDir, "state-dir", stateDir, "Directory of the daemon's identity, trusted peers, linked repos and other files (an older daemon's files in the working directory are moved there)")
+	replace := flag.Bool("replace", false, "If a daemon is already running with the same -state-dir, stop it and take over")
+	scanDir := flag.String("scan", "", "Link every git repo found under this directory, named after its directory")
+	trustTTL := flag.Duration("trust-ttl", 30*24*time.Hour, "How long a client's approval lasts before it has to pair again (0: forever)")
+	flag.DurationVar(&sessionTTL, "session-ttl", sessionTTL, "How long a client's session lasts before it signs a new challenge (0: no sessions)")
+	var peerCmd peerCommand
+	flag.BoolVar(&peerCmd.list, "peers", false, "List the trusted peers, their roles and when they expire, then exit")
+	flag.StringVar(&peerCmd.revoke, "revoke", "", "Revoke trust in a peer (its ID or a unique prefix of it), then exit")
+	flag.StringVar(&peerCmd.extend, "extend", "", "Renew a peer's approval from now on, then exit")
+	flag.StringVar(&peerCmd.pin, "pin", "", "Make a peer's approval never expire, then exit")
+	flag.StringVar(&peerCmd.unpin, "unpin", "", "Make a pinned peer's approval expire again, then exit")
+	var auditCmd auditCommand
+	var blockCmd blockCommand
+	flag.BoolVar(&blockCmd.list, "blocked", false, "List the blocked peers and IP ranges, then exit")
+	flag.StringVar(&blockCmd.block, "block", "", "Block a peer (ID or prefix of a trusted one), an IP address or a CIDR range, then exit")
+	flag.StringVar(&blockCmd.unblock, "unblock", "", "Remove an entry from the blocklist, then exit")
+	flag.BoolVar(&auditCmd.show, "audit", false, "Print the audit log of peer requests, then exit")
+	flag.StringVar(&auditCmd.export, "audit-export", "", "Write the audit log to a JSONL file (- for stdout), then exit")
+	flag.StringVar(&auditCmd.peer, "audit-peer", "", "Only audit entries of this peer (ID or prefix)")
+	flag.StringVar(&auditCmd.typ, "audit-type", "", "Only audit entries of this request type, e.g. WRITE_FILE_REQUEST")
+	flag.StringVar(&auditCmd.repo, "audit-repo", "", "Only audit entries for this repo alias")
+	flag.DurationVar(&auditCmd.since, "audit-since", 0, "Only audit entries of the last duration, e.g. 24h")
+	flag.IntVar(&auditCmd.limit, "audit-limit", 0, "Only the latest N audit entries")
+	flag.Var(rateLimits[classRead], "rate-read", "Requests a peer may send per second, minute or hour that only read, e.g. 600/m (0: no limit)")
+	flag.Var(rateLimits[classWrite], "rate-write", "Requests a peer may send that change files, e.g. 120/m (0: no limit)")
+	flag.Var(rateLimits[classGit], "rate-git", "Requests a peer may send that run git operations like commit or reset, e.g. 60/m (0: no limit)")
+	flag.BoolVar(&gater.enabled, "gate", false, "Refuse connections from untrusted peers, except during a pairing window")
+	pairingWindow := flag.Duration("pairing-window", 0, "With -gate, let untrusted peers connect to pair for this long after startup, e.g. 10m")
+	swarmKeyFile := flag.String("swarm-key", "", "Run on a private network: only peers holding this pre-shared swarm key can connect (generated if missing)")
+	flag.BoolVar(&scanSecrets, "scan-secrets", false, "Refuse commits whose staged changes look like they contain credentials (clients can override with --allow-secrets)")
+	transports := flag.String("transports", "tcp", "Comma-separated transports to listen on, on the -port number: tcp, quic (UDP), webtransport, ws (WebSocket, for browser clients)")
+	relayList := flag.String("relays", "", "Comma-separated circuit relay addresses (ending in /p2p/<relay-id>) to stay reachable through, e.g. behind carrier-grade NAT")
+	namespace := flag.String("discovery-namespace", p2p.DefaultNamespace, "DHT rendezvous to advertise the daemon under")
+	namespaceSecret := flag.String("discovery-secret", "", "Derive a private DHT rendezvous from this secret, shared by a deployment's daemons (or set $P2P_GIT_DISCOVERY_SECRET)")
+	connsLow := flag.Int("conns-low", p2p.DefaultConnsLow, "Connections the connection manager trims down to (trusted peers are never trimmed)")
+	connsHigh := flag.Int("conns-high", p2p.DefaultConnsHigh, "Connections above which the connection manager starts trimming")
+	connsGrace := flag.Duration("conns-grace", p2p.DefaultConnsGrace, "How long new connections are spared from trimming")
+	flag.DurationVar(&shutdownTimeout, "shutdown-timeout", shutdownTimeout, "On SIGINT or SIGTERM, how long to wait for requests in flight before stopping anyway")
+	flag.DurationVar(&git.LocalTimeout, "git-timeout", git.LocalTimeout, "Kill git commands that only work on the repo, like commit or stash, after this long (0: never)")
+	flag.DurationVar(&git.NetworkTimeout, "git-network-timeout", git.NetworkTimeout, "Kill git commands that talk to a remote, like push, and hooks after this long (0: never)")
+	metricsAddr := flag.String("metrics", "", "Serve Prometheus metrics on this address, e.g. 127.0.0.1:9464 (empty: off)")
+	adminSocket := flag.String("admin-socket", control.DefaultSocket, "Unix socket p2p-gitctl administers the running daemon through, relative to -state-dir (empty to disable)")
+	useKeychain := flag.Bool("keychain", false, "Keep the identity key in the OS keychain instead of daemon_identity.key (moving it there), falling back to the file without one")
+	useMDNS := flag.Bool("mdns", true, "Announce the daemon on the local network, so clients there can find it with 'discover'")
+	readOnly := flag.Bool("read-only", false, "Refuse every request that changes a repo or the daemon, for review-only access (admins can turn it off at runtime)")
+	flag.StringVar(&destructiveConfirmation, "confirm-destructive", confirmByToken, "How resets, forced cleans and force-pushes are confirmed: token (the client types the repo name), local (approve each one here) or admin (another admin client approves each one)")
+	flag.DurationVar(&approvalTimeout, "approval-timeout", approvalTimeout, "With -confirm-destructive admin, how long a destructive request waits for approval")
+	flag.BoolVar(&headless, "headless", false, "Never prompt on the terminal: new clients pair with a pairing token, during an -auto-approve window, or once approved with 'p2p-gitctl approve'")
+	autoApproveFor := flag.Duration("auto-approve", 0, "Approve every new client for this long after startup, e.g. 10m, to set up devices without prompts")
+	autoApproveRole := flag.String("auto-approve-role", string(store.RoleReadOnly), "Role of clients approved by -auto-approve: read-only, read-write or admin")
+	newToken := flag.String("pairing-token", "", "Create a one-time pairing token for a client with this role (read-only, read-write or admin), print it, then exit")
+	tokenTTL := flag.Duration("pairing-token-ttl", 24*time.Hour, "How long a token made by -pairing-token stays valid")
+	flag.BoolVar(&desktopNotify, "notify", desktopNotify, "Show a desktop notification when a new client asks to pair")
+	var logOpts logging.Options
+	flag.StringVar(&logOpts.Levels, "log-level", "info", "Log level (debug, info, warn, error), optionally followed by levels for the p2p, git, files and trust subsystems, e.g. info,git=debug")
+	flag.BoolVar(&logOpts.JSON, "log-json", false, "Write logs as JSON instead of text")
+	flag.StringVar(&logOpts.File, "log-file", "", "Write logs to this file instead of stderr")
+	logMaxSize := flag.Int64("log-max-size", 10, "With -log-file, rotate the file when it grows past this many megabytes (0: never)")
+	flag.IntVar(&logOpts.MaxFiles, "log-max-files", 5, "With -log-file, how many rotated files to keep")
+	useTUI := flag.Bool("tui", false, "Show a console of connected peers, requests, repos and pairings to approve, instead of prompting on stdin")
 	flag.Parse()
+	if *useTUI {
+		if headless {
+			log.Fatalf("-tui needs a terminal, so it doesn't work with -headless")
+		}
+		// Logs go to the console rather than over it
+		console = newDaemonConsole()
+		logOpts.Output = console
+	}
+	logOpts.MaxSize = *logMaxSize << 20
+	logFile, err := logging.Setup(logOpts)
+	if err != nil {
+		log.Fatalf("Invalid logging flags: %v", err)
+	}
+	defer logFile.Close()
+	switch destructiveConfirmation {
+	case confirmByToken, confirmLocally, confirmBySecondDevice:
+	default:
+		fatal(fmt.Sprintf("-confirm-destructive must be %s, %s or %s", confirmByToken, confirmLocally, confirmBySecondDevice))
+	}
+
+	if headless && destructiveConfirmation == confirmLocally {
+		fatal("-confirm-destructive local prompts on the terminal, so it doesn't work with -headless")
+	}
+
+	if err := setupStateDir(); err != nil {
+		fatal("Invalid state directory", "err", err)
+	}
+	auditLog = store.NewAuditLog(statePath(auditLogFile))
+	if *adminSocket != "" && !filepath.IsAbs(*adminSocket) {
+		*adminSocket = statePath(*adminSocket)
+	}
+
+	if *newToken != "" {
+		printPairingToken(*newToken, *tokenTTL)
+		return
+	}
+	if peerCmd.any() {
+		managePeers(peerCmd, *trustTTL)
+		return
+	}
+	if blockCmd.any() {
+		manageBlocklist(blockCmd)
+		return
+	}
+	if auditCmd.show || auditCmd.export != "" {
+		showAudit(auditCmd)
+		return
+	}
+
+	// Only one daemon serves a state directory; the commands above work alongside it
+	if err := lockInstance(*replace); err != nil {
+		fatal("Not starting", "err", err)
+	}
 
 	// --- NEW: Load linked repos from file ---
 	loadLinkedRepos()
-
-	// If the file is empty and no flag is provided, we still need one repo.
-	if len(linkedRepos) == 0 && *repoFlag == "" {
-		log.Fatal("You must link at least one repository using the -repo flag on first run, or have a linked_repos.json file.")
+	loadRepoConfigs()
+	loadPolicy()
+	loadWebhooks()
+	loadJobs()
+	loadBlocklist()
+	if *readOnly {
+		readOnlyMode.Store(true)
+		slog.Info("Read-only mode: requests that change repos are refused")
 	}
+
 	// The flag can be used to add a repo on startup
 	if *repoFlag != "" {
 		parseRepoFlag(*repoFlag)
 		saveLinkedRepos() // Save it immediately
 	}
+	// After -repo, so a repo it names keeps its alias
+	if *scanDir != "" && scanRepos(*scanDir) > 0 {
+		saveLinkedRepos()
+	}
+
+	// If the file is empty and no flag is provided, we still need one repo.
+	if len(linkedRepos) == 0 {
+		fatal("You must link at least one repository using the -repo or -scan flag on first run, or have a linked_repos.json file")
+	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
 	// Load or generate persistent identity
-	privKey, err := p2p.LoadOrGeneratePrivateKey("daemon_identity.key")
+	loadKey := p2p.LoadOrGeneratePrivateKey
+	if *useKeychain {
+		loadKey = p2p.LoadOrGenerateKeychainKey
+	}
+	privKey, err := loadKey(statePath(identityKeyFile))
 	if err != nil {
-		log.Fatalf("Failed to get private key: %v", err)
+		fatal("Failed to get private key", "err", err)
 	}
 
 	// Initialize TrustStore
-	trustStore, err = store.NewTrustStore("trusted_peers.json")
+	trustStore, err = store.NewTrustStore(statePath(trustedPeersFile))
 	if err != nil {
-		log.Fatalf("Failed to initialize trust store: %v", err)
+		fatal("Failed to initialize trust store", "err", err)
+	}
+	trustStore.SetDefaultTTL(*trustTTL)
+
+	// Load the swarm key of a private network
+	var psk pnet.PSK
+	if *swarmKeyFile != "" {
+		if psk, err = p2p.LoadOrGenerateSwarmKey(*swarmKeyFile); err != nil {
+			fatal("Failed to load swarm key", "err", err)
+		}
+	}
+
+	if *namespaceSecret == "" {
+		*namespaceSecret = os.Getenv("P2P_GIT_DISCOVERY_SECRET")
+	}
+	if *namespaceSecret != "" {
+		if *namespace != p2p.DefaultNamespace {
+			fatal("Use either -discovery-namespace or -discovery-secret, not both")
+		}
+		*namespace = p2p.NamespaceFromSecret(*namespaceSecret)
+		p2pLog.Info("Advertising under a private discovery namespace", "namespace", *namespace)
+	}
+
+	relays, err := p2p.ParseRelays(*relayList)
+	if err != nil {
+		fatal("Invalid -relays", "err", err)
+	}
+	listenOn, err := p2p.ListenTransports(*listenPort, strings.Split(*transports, ","))
+	if err != nil {
+		fatal("Invalid -transports", "err", err)
+	}
+	for _, t := range strings.Split(*transports, ",") {
+		if t = strings.TrimSpace(t); psk != nil && t != "" && t != "tcp" && t != p2p.TransportWebSocket {
+			fatal(fmt.Sprintf("-transports %s doesn't work with -swarm-key: private networks only run over TCP and WebSocket", t))
+		}
 	}
 
 	// Create libp2p host
-	h, err := p2p.CreateHost(ctx, privKey, *listenPort)
+	if gater.enabled {
+		if *pairingWindow > 0 {
+			until := gater.openPairing(*pairingWindow)
+			trustLog.Info("Gated: untrusted peers may connect to pair for now", "until", until.Format("15:04:05"))
+		} else {
+			trustLog.Info("Gated: only trusted peers may connect. Admins can open a pairing window with 'pairing <minutes>'")
+		}
+	}
+	if *autoApproveFor > 0 {
+		role, err := store.ParseRole(*autoApproveRole)
+		if err != nil {
+			fatal("Invalid -auto-approve-role", "err", err)
+		}
+		until := autoApprove.open(*autoApproveFor, role)
+		trustLog.Info("Approving every new client for now", "role", role, "until", until.Format("15:04:05"))
+	}
+	connManager, err := p2p.ConnManager(*connsLow, *connsHigh, *connsGrace)
 	if err != nil {
-		log.Fatalf("Failed to create host: %v", err)
+		fatal("Invalid connection manager limits", "err", err)
+	}
+	bandwidth := metrics.NewBandwidthCounter()
+	h, err := p2p.CreateHost(ctx, privKey, *listenPort, p2p.PrivateNetwork(psk), listenOn, p2p.StaticRelays(relays), libp2p.ConnectionGater(gater), connManager, libp2p.BandwidthReporter(bandwidth))
+	if err != nil {
+		fatal("Failed to create host", "err", err)
 	}
 	defer h.Close()
+	daemonHost = h
+	if *metricsAddr != "" {
+		startMetrics(*metricsAddr, h, bandwidth)
+	}
+	if *adminSocket != "" {
+		if ln := startControlSocket(*adminSocket); ln != nil {
+			defer ln.Close()
+		}
+	}
+	if netStatus, err = p2p.WatchNetStatus(h, func(status string) {
+		p2pLog.Info("Reachability changed", "status", status)
+	}); err != nil {
+		p2pLog.Warn("Cannot follow reachability", "err", err)
+	}
 
-	// Start discovery
-	go func() {
-		if err := p2p.StartDiscovery(ctx, h); err != nil {
-			log.Printf("Warning: Discovery failed: %v", err)
+	// Start discovery. The public DHT can't be reached from a private
+	// network, and the daemon stays invisible on it.
+	if psk == nil {
+		go func() {
+			if err := p2p.StartDiscovery(ctx, h, *namespace); err != nil {
+				p2pLog.Warn("Discovery failed", "err", err)
+			}
+		}()
+	} else {
+		p2pLog.Info("Private network: skipping public discovery. Give clients the swarm key to connect")
+	}
+	if *useMDNS {
+		if mdns, err := p2p.StartMDNS(h); err != nil {
+			p2pLog.Warn("Local network discovery failed", "err", err)
+		} else {
+			defer mdns.Close()
 		}
-	}()
+	}
 
 	// Generate and display QR code
 	addrInfo := peer.AddrInfo{
@@ -84,54 +326,129 @@ func main() {
 	}
 	addrs, err := peer.AddrInfoToP2pAddrs(&addrInfo)
 	if err != nil {
-		log.Fatalf("Failed to get p2p addresses: %v", err)
+		fatal("Failed to get p2p addresses", "err", err)
 	}
 
-	// We'll print the first public-facing address we find
+	// We'll print the first public-facing address we find, or with relays,
+	// one through them, which works wherever the client is
+	shownAddr := addrs[0]
+	var wsAddr multiaddr.Multiaddr
+	for _, addr := range addrs {
+		if p2p.IsWebSocket(addr) {
+			if wsAddr == nil || manet.IsIPLoopback(wsAddr) {
+				wsAddr = addr
+			}
+		} else if p2p.IsWebSocket(shownAddr) {
+			shownAddr = addr
+		}
+	}
+	if len(relays) > 0 {
+		p2pLog.Info("Reserving a slot on relays", "count", len(relays))
+		if relayAddr := p2p.WaitForRelayAddr(ctx, h, 30*time.Second); relayAddr != nil {
+			shownAddr = relayAddr
+		} else {
+			p2pLog.Warn("No relay accepted a reservation yet; showing a direct address")
+		}
+	}
 	fmt.Println("====================================================================")
 	fmt.Println("Scan the QR code with the mobile client to connect.")
 	fmt.Println("Or copy the multiaddress below:")
-	fmt.Println(addrs[0].String())
+	fmt.Println(shownAddr.String())
 	fmt.Println("====================================================================")
-	qrc, err := qrcode.New(addrs[0].String(), qrcode.Medium)
+	qrc, err := qrcode.New(shownAddr.String(), qrcode.Medium)
 	if err != nil {
-		log.Fatalf("Failed to generate QR code: %v", err)
+		fatal("Failed to generate QR code", "err", err)
 	}
 	fmt.Println(qrc.ToString(true))
+	if console != nil {
+		console.addr = shownAddr.String()
+	}
+	if wsAddr != nil {
+		fmt.Println("Browser clients connect over WebSocket:")
+		fmt.Println(wsAddr.String())
+		if qrc, err := qrcode.New(wsAddr.String(), qrcode.Medium); err == nil {
+			fmt.Println(qrc.ToString(true))
+		}
+	}
+
+	// Watch the linked repos so clients can subscribe to file changes
+	startWatcher()
+
+	// Set a stream handler for each protocol version we serve
+	for _, version := range handlerVersions() {
+		h.SetStreamHandler(version, handleStream)
+	}
 
-	// Set a stream handler for our protocol
-	h.SetStreamHandler(protocol.ProtocolID, handleStream)
+	reloadOnHangup()
+	systemd.Notify("READY=1")
+	slog.Info("Daemon is running. Waiting for connections")
 
-	log.Println("Daemon is running. Waiting for connections...")
-	select {} // Keep the daemon running
+	// Run until SIGINT or SIGTERM, then let requests in flight finish
+	signalCtx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	if console != nil {
+		// Quitting the console stops the daemon too
+		if err := console.run(signalCtx.Done()); err != nil {
+			slog.Error("Console failed", "err", err)
+		}
+	} else {
+		<-signalCtx.Done()
+	}
+	stop() // A second signal kills the daemon at once
+	shutdown(h)
 }
 
 func parseRepoFlag(repoFlag string) {
 	parts := strings.Split(repoFlag, ":")
 	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
-		log.Fatalf("Invalid repo flag format. Use 'alias:/path/to/repo'")
+		fatal("Invalid repo flag format. Use 'alias:/path/to/repo'")
 	}
 
 	// Convert the provided path to an absolute path
 	absPath, err := filepath.Abs(parts[1])
 	if err != nil {
-		log.Fatalf("Could not get absolute path for repo: %v", err)
+		fatal("Could not get absolute path for repo", "err", err)
 	}
 
 	linkedRepos[parts[0]] = absPath
-	log.Printf("Linked repository '%s' to absolute path '%s'", parts[0], absPath)
+	slog.Info("Linked repository", "alias", parts[0], "path", absPath)
 }
 
 func handleStream(stream network.Stream) {
+	stream = protocol.Buffered(stream)
 	remotePeer := stream.Conn().RemotePeer()
-	log.Printf("New stream from %s", remotePeer)
+	p2pLog.Debug("New stream", "peer", remotePeer)
 	defer stream.Close()
+	defer trackStream(stream)()
 
+	if blockedConn(remotePeer, stream.Conn()) {
+		// Blocked after it connected
+		trustLog.Info("Refusing stream from blocked peer", "peer", remotePeer)
+		stream.Conn().Close()
+		return
+	}
+	if !gater.admits(remotePeer) {
+		// Connected during a pairing window that has closed since
+		trustLog.Info("Refusing stream from untrusted peer: no pairing window is open", "peer", remotePeer)
+		return
+	}
 	if trustStore.IsTrusted(remotePeer) {
-		log.Printf("Peer %s is already trusted. Listening for commands...", remotePeer)
+		fingerprint, err := remoteKeyFingerprint(stream)
+		if err == nil {
+			err = trustStore.CheckKey(remotePeer, fingerprint)
+		}
+		if err != nil {
+			trustLog.Warn("Refusing stream from trusted peer", "peer", remotePeer, "err", err)
+			return
+		}
+		trustLog.Debug("Peer is already trusted. Listening for commands", "peer", remotePeer)
+		protectPeer(remotePeer)
 		handleTrustedStream(stream)
+	} else if _, known := trustStore.Peers()[remotePeer]; known {
+		trustLog.Info("Approval of peer has expired. Initiating handshake", "peer", remotePeer)
+		unprotectPeer(remotePeer)
+		handleHandshake(stream)
 	} else {
-		log.Printf("Peer %s is not trusted. Initiating handshake...", remotePeer)
+		trustLog.Info("Peer is not trusted. Initiating handshake", "peer", remotePeer)
 		handleHandshake(stream)
 	}
 }
@@ -142,25 +459,40 @@ func handleHandshake(stream network.Stream) {
 	// Wait for a handshake request
 	msg, err := protocol.ReadMessage(stream)
 	if err != nil {
-		log.Printf("Failed to read handshake request from %s: %v", remotePeer, err)
+		trustLog.Warn("Failed to read handshake request", "peer", remotePeer, "err", err)
 		return
 	}
 
 	if msg.Type != "HANDSHAKE_REQUEST" {
-		log.Printf("Expected HANDSHAKE_REQUEST from %s, but got %s", remotePeer, msg.Type)
+		trustLog.Warn("Expected HANDSHAKE_REQUEST", "peer", remotePeer, "got", msg.Type)
 		return
 	}
 
-	// Ask for user approval
-	fmt.Printf("\n>>> New connection request from PeerID: %s\n", remotePeer)
-	fmt.Print(">>> Approve this client? (y/n): ")
+	// Make the client prove it holds the key behind its peer ID before
+	// bothering the owner
+	fingerprint, err := challengePeer(stream)
+	if err != nil {
+		trustLog.Warn("Peer failed the handshake challenge", "peer", remotePeer, "err", err)
+		recordAudit(store.AuditEntry{Time: time.Now(), Peer: remotePeer.String(), Type: msg.Type, Result: "rejected", Error: err.Error()})
+		payloadBytes, _ := json.Marshal(protocol.HandshakeResponsePayload{Approved: false})
+		protocol.WriteMessage(stream, &protocol.Message{Type: protocol.TypeHandshakeResponse, Payload: payloadBytes})
+		return
+	}
 
-	reader := bufio.NewReader(os.Stdin)
-	answer, _ := reader.ReadString('\n')
-	approved := strings.TrimSpace(strings.ToLower(answer)) == "y"
+	var request protocol.HandshakeRequestPayload
+	json.Unmarshal(msg.Payload, &request) // Older clients send none
+	approved, role, verified, via := approveHandshake(stream, request.Token)
 
 	// Send response
 	responsePayload := protocol.HandshakeResponsePayload{Approved: approved}
+	if approved {
+		responsePayload.Role = string(role)
+		session, expires, err := issueSession(remotePeer)
+		if err != nil {
+			trustLog.Error("Failed to issue a session", "peer", remotePeer, "err", err)
+		}
+		responsePayload.Session, responsePayload.SessionExpires = session, expires
+	}
 	payloadBytes, _ := json.Marshal(responsePayload)
 	responseMsg := &protocol.Message{
 		Type:    "HANDSHAKE_RESPONSE",
@@ -168,19 +500,159 @@ func handleHandshake(stream network.Stream) {
 	}
 
 	if err := protocol.WriteMessage(stream, responseMsg); err != nil {
-		log.Printf("Failed to send handshake response to %s: %v", remotePeer, err)
+		trustLog.Warn("Failed to send handshake response", "peer", remotePeer, "err", err)
 		return
 	}
 
+	entry := store.AuditEntry{Time: time.Now(), Peer: remotePeer.String(), Type: msg.Type, Result: "rejected"}
+	if approved {
+		entry.Result, entry.Args = "approved", fmt.Sprintf("role=%s verified=%t via=%s", role, verified, via)
+	}
+	recordAudit(entry)
+	pairing := webhookEvent{Event: webhookPairing, Peer: remotePeer.String(), Result: entry.Result}
+	if approved {
+		pairing.Action = string(role)
+	}
+	fireWebhooks(pairing)
+
 	if approved {
-		if err := trustStore.AddTrustedPeer(remotePeer); err != nil {
-			log.Printf("Failed to add peer %s to trust store: %v", remotePeer, err)
+		trusted := store.TrustedPeer{Role: role, KeyFingerprint: fingerprint, Verified: verified}
+		if err := trustStore.AddTrustedPeerAs(remotePeer, trusted); err != nil {
+			trustLog.Error("Failed to add peer to trust store", "peer", remotePeer, "err", err)
 		} else {
-			log.Printf("Peer %s approved and added to trust store.", remotePeer)
+			trustLog.Info("Peer approved and added to trust store", "peer", remotePeer, "role", role, "via", via)
+			protectPeer(remotePeer)
 		}
 	} else {
-		log.Printf("Peer %s rejected.", remotePeer)
+		trustLog.Info("Peer rejected", "peer", remotePeer)
+	}
+}
+
+// approveHandshake decides whether to trust a client that proved its
+// identity: with its pairing token if it has one, automatically during an
+// auto-approve window, from the admin socket when headless, in the console
+// with -tui, or else by asking on the terminal. It returns the client's role, whether the owner
+// checked the fingerprints, and how it was approved.
+func approveHandshake(stream network.Stream, token string) (approved bool, role store.Role, verified bool, via string) {
+	remotePeer := stream.Conn().RemotePeer()
+	if token != "" {
+		role, err := redeemPairingToken(token)
+		if err != nil {
+			trustLog.Warn("Refused pairing token", "peer", remotePeer, "err", err)
+			return false, "", false, "token"
+		}
+		return true, role, false, "token"
+	}
+	if role, ok := autoApprove.active(); ok {
+		return true, role, false, "auto-approve"
+	}
+	notifyDesktop("p2p-git-remote: pairing request", fmt.Sprintf("A new client wants to pair: %s", p2p.WordFingerprint(remotePeer)))
+	if headless || console != nil {
+		role, ok := awaitPairing(stream)
+		if console != nil {
+			return ok, role, false, "console"
+		}
+		return ok, role, false, "admin socket"
+	}
+	approved, role, verified = promptApproval(stream)
+	return approved, role, verified, "prompt"
+}
+
+// promptApproval asks the daemon's owner on the terminal whether to trust a
+// client, and with which role.
+func promptApproval(stream network.Stream) (bool, store.Role, bool) {
+	remotePeer := stream.Conn().RemotePeer()
+
+	// Ask for user approval. Typing the pairing code the client shows, rather
+	// than just y, makes sure it's the device the owner thinks it is.
+	code := p2p.PairingCode(stream.Conn().LocalPeer(), remotePeer)
+	consoleMutex.Lock()
+	fmt.Printf("\n>>> New connection request from PeerID: %s\n", remotePeer)
+	fmt.Printf(">>> Client fingerprint: %s\n", p2p.WordFingerprint(remotePeer))
+	fmt.Printf(">>> Daemon fingerprint: %s\n", p2p.WordFingerprint(stream.Conn().LocalPeer()))
+	fmt.Print(">>> To approve it, type the pairing code the client shows (empty to reject): ")
+
+	reader := bufio.NewReader(os.Stdin)
+	answer, _ := reader.ReadString('\n')
+	approved := p2p.SamePairingCode(answer, code)
+	if !approved && strings.TrimSpace(answer) != "" {
+		fmt.Printf(">>> That doesn't match this connection's code (%s); rejecting the client.\n", code)
+	}
+	verified := false
+	if approved {
+		approved, verified = askFingerprints(reader)
+	}
+	role := store.RoleReadWrite
+	if approved {
+		role = askRole(reader)
+	}
+	consoleMutex.Unlock()
+	return approved, role, verified
+}
+
+// askFingerprints asks the daemon's owner whether the client shows the same
+// word fingerprints. It returns whether to go on approving the client, and
+// whether the owner checked.
+func askFingerprints(reader *bufio.Reader) (bool, bool) {
+	fmt.Print(">>> Does the client show the same two fingerprints? (y/n, Enter to skip): ")
+	answer, _ := reader.ReadString('\n')
+	switch strings.TrimSpace(strings.ToLower(answer)) {
+	case "y":
+		return true, true
+	case "n":
+		fmt.Println(">>> The fingerprints differ; rejecting the client.")
+		return false, false
+	}
+	return true, false
+}
+
+// askRole asks the daemon's owner what a newly approved client may do.
+func askRole(reader *bufio.Reader) store.Role {
+	fmt.Print(">>> Allow it to (r)ead only, (w)rite (default), or (a)dminister the daemon? [r/w/a]: ")
+	answer, _ := reader.ReadString('\n')
+	switch strings.TrimSpace(strings.ToLower(answer)) {
+	case "r":
+		return store.RoleReadOnly
+	case "a":
+		return store.RoleAdmin
+	}
+	return store.RoleReadWrite
+}
+
+// challengePeer sends the client a nonce to sign with its private key, and
+// returns the fingerprint of its public key once the signature checks out.
+func challengePeer(stream network.Stream) (string, error) {
+	nonce, err := p2p.NewNonce()
+	if err != nil {
+		return "", err
+	}
+	payloadBytes, _ := json.Marshal(protocol.HandshakeChallengePayload{Nonce: nonce})
+	if err := protocol.WriteMessage(stream, &protocol.Message{Type: protocol.TypeHandshakeChallenge, Payload: payloadBytes}); err != nil {
+		return "", err
+	}
+
+	msg, err := protocol.ReadMessage(stream)
+	if err != nil {
+		return "", err
+	}
+	if msg.Type != protocol.TypeHandshakeProof {
+		return "", fmt.Errorf("expected %s, got %s", protocol.TypeHandshakeProof, msg.Type)
+	}
+	var proof protocol.HandshakeProofPayload
+	if err := json.Unmarshal(msg.Payload, &proof); err != nil {
+		return "", err
 	}
+	return p2p.VerifyChallenge(proof.PublicKey, proof.Signature, nonce, stream.Conn().LocalPeer(), stream.Conn().RemotePeer())
+}
+
+// remoteKeyFingerprint returns the fingerprint of the public key the peer
+// authenticated the connection with.
+func remoteKeyFingerprint(stream network.Stream) (string, error) {
+	key := stream.Conn().RemotePublicKey()
+	if key == nil {
+		return "", fmt.Errorf("the connection has no public key")
+	}
+	return p2p.KeyFingerprint(key)
 }
 
 func handleTrustedStream(stream network.Stream) {
@@ -190,65 +662,112 @@ func handleTrustedStream(stream network.Stream) {
 	msg, err := protocol.ReadMessage(stream)
 	if err != nil {
 		if err.Error() != "EOF" { // It's normal for a client to close the stream (EOF)
-			log.Printf("Failed to read command from trusted peer %s: %v", remotePeer, err)
-		}
-		return
-	}
-
-	log.Printf("Received command '%s' from trusted peer %s", msg.Type, remotePeer)
-
-	// --- FIX: Use a switch to route to the correct handler ---
-	switch msg.Type {
-	case protocol.TypeGitCommitRequest:
-		handleGitCommit(stream, msg.Payload)
-	case protocol.TypeListReposRequest:
-		handleListRepos(stream)
-	case protocol.TypeReadFileRequest:
-		handleReadFile(stream, msg.Payload)
-	// case protocol.TypeWriteFileRequest:
-	// 	handleWriteFile(stream, msg.Payload)
-	// --- NEW CASES ---
-	case protocol.TypeListFilesRequest:
-		handleListFiles(stream, msg.Payload)
-	case protocol.TypeCreateBranchRequest:
-		handleCreateBranch(stream, msg.Payload)
-	case protocol.TypeRenameFileRequest:
-		handleRenameFile(stream, msg.Payload)
-	case protocol.TypeWriteFileRequest:
-		handleWriteFile(stream, msg.Payload)
-	case protocol.TypeListBranchesRequest:
-		handleListBranches(stream, msg.Payload)
-	case protocol.TypeLinkRepoRequest:
-		handleLinkRepo(stream, msg.Payload)
-	case protocol.TypeSwitchBranchRequest:
-		handleSwitchBranch(stream, msg.Payload)
-	case protocol.TypeGitStatusRequest:
-		handleGitStatus(stream, msg.Payload)
-	case protocol.TypeGitLogRequest:
-		handleGitLog(stream, msg.Payload)
-	case protocol.TypeGitDiffRequest:
-		handleGitDiff(stream, msg.Payload)
-	case protocol.TypeGitStashSaveRequest:
-		handleGitStashSave(stream, msg.Payload)
-	case protocol.TypeGitStashPopRequest:
-		handleGitStashPop(stream, msg.Payload)
-	case protocol.TypeGitResetRequest:
-		handleGitReset(stream, msg.Payload)
-	default:
-		log.Printf("Received unknown message type from trusted peer: %s", msg.Type)
+			p2pLog.Warn("Failed to read command from trusted peer", "peer", remotePeer, "err", err)
+		}
+		return
+	}
+
+	slog.Info("Received command", "type", msg.Type, "peer", remotePeer)
+
+	// Record the command and how it went in the audit log
+	repo, args := summarizeRequest(msg.Payload)
+	setStreamRequest(stream, msg.Type, repo)
+	audited := &auditStream{Stream: stream}
+	stream = audited
+	received := time.Now()
+	defer func() {
+		result, reason := audited.result()
+		observeRequest(remotePeer, msg.Type, result, time.Since(received))
+		notifyRequest(remotePeer, msg.Type, msg.Payload, repo, result, reason)
+		recordAudit(store.AuditEntry{
+			Time:   received,
+			Peer:   remotePeer.String(),
+			Type:   msg.Type,
+			Repo:   repo,
+			Args:   args,
+			Result: result,
+			Error:  reason,
+		})
+	}()
+
+	if msg.Type != protocol.TypeSessionRequest {
+		if reason := checkSession(remotePeer, msg.Session); reason != "" {
+			trustLog.Info("Refused request", "type", msg.Type, "peer", remotePeer, "reason", reason)
+			refuseSessionExpired(stream, msg.Type, reason)
+			return
+		}
+	}
+
+	role, _ := trustStore.Role(remotePeer)
+	if required := requiredRole(msg.Type); !role.Allows(required) {
+		trustLog.Info("Denied request: role too low", "type", msg.Type, "peer", remotePeer, "role", role, "required", required)
+		denyRequest(stream, msg.Type, role, fmt.Sprintf("this client is %s, but %s needs %s access", role, msg.Type, required))
+		return
 	}
+	if reason := currentPolicy().check(remotePeer, msg.Type, time.Now()); reason != "" {
+		trustLog.Info("Denied request by policy", "type", msg.Type, "peer", remotePeer)
+		denyRequest(stream, msg.Type, role, reason)
+		return
+	}
+	if refusedInReadOnlyMode(msg.Type) {
+		trustLog.Info("Denied request: read-only mode", "type", msg.Type, "peer", remotePeer)
+		denyRequest(stream, msg.Type, role, "the daemon is in read-only mode; it only serves requests that don't change anything")
+		return
+	}
+	if reason := confirmDestructive(stream, msg.Type, msg.Payload); reason != "" {
+		trustLog.Info("Denied request: not confirmed", "type", msg.Type, "peer", remotePeer)
+		denyRequest(stream, msg.Type, role, reason)
+		return
+	}
+	class := requestClass(msg.Type)
+	if ok, retryAfter := takeToken(remotePeer, class); !ok {
+		trustLog.Info("Rate limited request", "type", msg.Type, "peer", remotePeer, "class", class)
+		refuseRateLimited(stream, msg.Type, class, retryAfter)
+		return
+	}
+
+	handler, ok := lookupHandler(stream.Protocol(), msg.Type)
+	if !ok {
+		slog.Warn("Received unknown message type from trusted peer", "type", msg.Type, "protocol", stream.Protocol())
+		return
+	}
+	handler(stream, msg.Payload)
+}
+
+// scanSecrets checks every repo's commits for credentials, set by
+// -scan-secrets. Repos can also opt in with "scan_secrets".
+var scanSecrets bool
+
+// checkPushTarget checks a remote and branch a peer asked to push to. Only a
+// configured remote may be named: anything else could be any URL, or an option
+// such as --receive-pack that runs a command. An empty remote is the default.
+func checkPushTarget(repoPath, remote, branch string) error {
+	if strings.HasPrefix(branch, "-") || strings.HasPrefix(remote, "-") {
+		return fmt.Errorf("invalid remote or branch name")
+	}
+	if remote == "" {
+		return nil
+	}
+	found, err := git.HasRemote(repoPath, remote)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("repository has no remote named '%s'", remote)
+	}
+	return nil
 }
 
 // --- NEW: A dedicated handler for git commits ---
 func handleGitCommit(stream network.Stream, rawPayload json.RawMessage) {
 	var payload protocol.GitCommitRequestPayload
 	if err := json.Unmarshal(rawPayload, &payload); err != nil {
-		log.Printf("Error unmarshalling git request: %v", err)
+		gitLog.Warn("Error unmarshalling git request", "err", err)
 		// Consider sending an error response back
 		return
 	}
 
-	repoPath, ok := linkedRepos[payload.RepoPath]
+	repoPath, ok := resolveRepo(payload.RepoPath)
 	if !ok {
 		// Send an error response back to the client
 		errOutput := fmt.Sprintf("Error: Unknown repository alias '%s'. Known aliases: %v", payload.RepoPath, getRepoAliases())
@@ -259,20 +778,123 @@ func handleGitCommit(stream network.Stream, rawPayload json.RawMessage) {
 		return
 	}
 
-	log.Printf("Executing 'git commit & push' on '%s' for branch '%s'", repoPath, payload.Branch)
-	output, err := git.CommitAndPush(repoPath, payload.Message, "origin", payload.Branch)
+	repoCfg := getRepoConfig(payload.RepoPath)
+	opts := git.CommitOptions{
+		AuthorName:    payload.AuthorName,
+		AuthorEmail:   payload.AuthorEmail,
+		SignOff:       payload.SignOff,
+		Sign:          payload.Sign,
+		SigningKey:    repoCfg.SigningKey,
+		SigningFormat: repoCfg.SigningFormat,
+		Hooks:         git.HookPolicy(repoCfg.HookPolicy),
+		StagedOnly:    payload.StagedOnly,
+		ScanSecrets:   (scanSecrets || repoCfg.ScanSecrets) && !payload.AllowSecrets,
+		SSHCommand:    repoCfg.SSHCommand,
+	}
+	if payload.AllowSecrets {
+		gitLog.Info("Secret scan overridden by the client for this commit")
+	}
+	// Only a branch without an upstream gets one; an existing one is never replaced
+	hasUpstream, _ := git.HasUpstream(repoPath, payload.Branch)
+	opts.SetUpstream = payload.SetUpstream && !hasUpstream
+
+	if payload.DryRun {
+		gitLog.Info("Previewing commit", "repo", repoPath)
+		responsePayload := protocol.GitCommitResponsePayload{}
+		preview, err := git.PreviewCommit(repoPath, payload.StagedOnly)
+		responsePayload.Success = (err == nil)
+		if err != nil {
+			responsePayload.Output = err.Error()
+		} else if len(preview.Files) == 0 && payload.StagedOnly {
+			responsePayload.Output = "Nothing staged. Nothing to commit."
+		} else if len(preview.Files) == 0 {
+			responsePayload.Output = "Working tree is clean. Nothing to commit."
+		} else {
+			responsePayload.Output = preview.Stat
+			for _, f := range preview.Files {
+				responsePayload.Files = append(responsePayload.Files, protocol.DiffStat{
+					Path:       f.Path,
+					Status:     f.Status,
+					Insertions: f.Insertions,
+					Deletions:  f.Deletions,
+					Binary:     f.Binary,
+				})
+			}
+		}
+		payloadBytes, _ := json.Marshal(responsePayload)
+		protocol.WriteMessage(stream, &protocol.Message{Type: protocol.TypeGitCommitResponse, Payload: payloadBytes})
+		return
+	}
+
+	remote := payload.Remote
+	if remote == "" {
+		remote = pushRemote(payload.RepoPath)
+	}
+	var invalid string
+	if payload.Message == "" && !payload.Amend {
+		invalid = "Error: a commit message is required"
+	} else if err := checkPushTarget(repoPath, payload.Remote, payload.Branch); err != nil {
+		invalid = "Error: " + err.Error()
+	}
+	if invalid != "" {
+		responsePayload := protocol.GitCommitResponsePayload{Success: false, Output: invalid}
+		payloadBytes, _ := json.Marshal(responsePayload)
+		protocol.WriteMessage(stream, &protocol.Message{Type: protocol.TypeGitCommitResponse, Payload: payloadBytes})
+		return
+	}
+	kind := "push"
+	if payload.Amend {
+		kind = "amend"
+	}
+	remotePeer := stream.Conn().RemotePeer()
+	j := newJob(kind, payload.RepoPath, payload.Branch, remotePeer)
+	commitAndPush := func(progress io.Writer, abort <-chan struct{}) (string, error) {
+		opts.Progress, opts.Abort = progress, abort
+		if payload.Amend {
+			gitLog.Info("Executing 'git commit --amend & push'", "repo", repoPath, "branch", payload.Branch, "force", payload.Force)
+			return git.AmendAndPush(repoPath, payload.Message, remote, payload.Branch, payload.Force, opts)
+		}
+		gitLog.Info("Executing 'git commit & push'", "repo", repoPath, "branch", payload.Branch)
+		return git.CommitAndPush(repoPath, payload.Message, remote, payload.Branch, opts)
+	}
+
+	if payload.Background {
+		go func() {
+			// Its request has long been answered, so the push is announced here
+			if _, err := j.run(repoPath, commitAndPush); err == nil {
+				fireWebhooks(webhookEvent{Event: webhookPush, Peer: remotePeer.String(), Repo: payload.RepoPath, Branch: payload.Branch, Subject: payload.Message})
+			}
+		}()
+		responsePayload := protocol.GitCommitResponsePayload{Success: true, JobID: j.info.ID, Output: "Queued as job " + j.info.ID}
+		payloadBytes, _ := json.Marshal(responsePayload)
+		protocol.WriteMessage(stream, &protocol.Message{Type: protocol.TypeGitCommitResponse, Payload: payloadBytes})
+		return
+	}
+	output, err := j.run(repoPath, commitAndPush)
 
-	responsePayload := protocol.GitCommitResponsePayload{Success: err == nil, Output: output}
+	responsePayload := protocol.GitCommitResponsePayload{Success: err == nil, Output: output, JobID: j.info.ID}
+	var secrets *git.SecretsFoundError
+	if errors.As(err, &secrets) {
+		for _, f := range secrets.Findings {
+			responsePayload.Secrets = append(responsePayload.Secrets, protocol.SecretFinding(f))
+		}
+		responsePayload.Output += "\nRemove them, or commit anyway with --allow-secrets if they are not real."
+	}
+	if err == nil && opts.SetUpstream {
+		responsePayload.Upstream = remote + "/" + payload.Branch
+	} else if err != nil && secrets == nil && !hasUpstream && !payload.SetUpstream {
+		responsePayload.Output += "\nBranch '" + payload.Branch + "' has no upstream. Retry with --set-upstream to push and track " + remote + "/" + payload.Branch + "."
+	}
 	payloadBytes, _ := json.Marshal(responsePayload)
 	responseMsg := &protocol.Message{Type: protocol.TypeGitCommitResponse, Payload: payloadBytes}
 
 	if err := protocol.WriteMessage(stream, responseMsg); err != nil {
-		log.Printf("Failed to send git response: %v", err)
+		gitLog.Warn("Failed to send git response", "err", err)
 	}
 }
 
 func handleListRepos(stream network.Stream) {
-	log.Println("Handling ListRepos request")
+	slog.Info("Handling ListRepos request")
 	payload := protocol.ListReposResponsePayload{Repos: getRepoAliases()}
 	payloadBytes, _ := json.Marshal(payload)
 	response := &protocol.Message{
@@ -280,7 +902,7 @@ func handleListRepos(stream network.Stream) {
 		Payload: payloadBytes,
 	}
 	if err := protocol.WriteMessage(stream, response); err != nil {
-		log.Printf("Failed to send repo list: %v", err)
+		slog.Warn("Failed to send repo list", "err", err)
 	}
 }
 
@@ -289,30 +911,39 @@ func handleReadFile(stream network.Stream, rawPayload json.RawMessage) {
 	var payload protocol.ReadFileRequestPayload
 	if err := json.Unmarshal(rawPayload, &payload); err != nil {
 		// You should send a proper error response here too
-		log.Printf("Error unmarshalling read file request: %v", err)
+		filesLog.Warn("Error unmarshalling read file request", "err", err)
 		return
 	}
-	log.Printf("Handling ReadFile request for %s in repo %s", payload.FilePath, payload.RepoPath)
+	filesLog.Info("Handling ReadFile request", "file", payload.FilePath, "repo", payload.RepoPath)
 
 	respPayload := protocol.ReadFileResponsePayload{}
-	repoRoot, ok := linkedRepos[payload.RepoPath]
+	repoRoot, ok := resolveRepo(payload.RepoPath)
 	if !ok {
 		respPayload.Success = false
 		respPayload.Error = fmt.Sprintf("Unknown repository alias: %s", payload.RepoPath)
 	} else {
-		// !!! SECURITY CRITICAL: Your path traversal prevention logic is good! Let's use it. !!!
-		// This ensures the client can't request a file like `../../.ssh/id_rsa`
-		fullPath := filepath.Join(repoRoot, payload.FilePath)
-		cleanRepoRoot := filepath.Clean(repoRoot)
-
-		if !strings.HasPrefix(fullPath, cleanRepoRoot) {
+		// !!! SECURITY CRITICAL: The sandbox ensures the client can't request
+		// a file like `../../.ssh/id_rsa`, even through a symlink
+		fullPath, err := sandboxPath(payload.RepoPath, repoRoot, payload.FilePath)
+		if err != nil {
 			respPayload.Success = false
-			respPayload.Error = "Access denied: path is outside of repository root"
+			respPayload.Error = err.Error()
 		} else {
 			content, err := os.ReadFile(fullPath)
 			if err != nil {
 				respPayload.Success = false
 				respPayload.Error = err.Error()
+			} else if isBinary(content) {
+				respPayload.Success = true
+				respPayload.Binary = true
+				respPayload.Size = int64(len(content))
+				respPayload.MimeType = http.DetectContentType(content)
+				if payload.Hexdump && len(content) <= protocol.MaxHexdumpSize {
+					respPayload.Data = content
+				}
+			} else if payload.StartLine > 0 || payload.EndLine > 0 {
+				respPayload.Success = true
+				respPayload.Content, respPayload.TotalLines = lineRange(string(content), payload.StartLine, payload.EndLine)
 			} else {
 				respPayload.Success = true
 				respPayload.Content = string(content)
@@ -326,35 +957,72 @@ func handleReadFile(stream network.Stream, rawPayload json.RawMessage) {
 	protocol.WriteMessage(stream, response)
 }
 
+// lineRange returns lines start to end (1-based, inclusive, 0 for the start or
+// end of the file) of content, and how many lines content has.
+func lineRange(content string, start, end int) (string, int) {
+	lines := strings.SplitAfter(content, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1] // A final newline ends the last line
+	}
+	total := len(lines)
+	if start < 1 {
+		start = 1
+	}
+	if end < 1 || end > total {
+		end = total
+	}
+	if start > end {
+		return "", total
+	}
+	return strings.Join(lines[start-1:end], ""), total
+}
+
+// isBinary reports whether content looks binary, using git's heuristic of a
+// NUL byte in the first 8000 bytes. Content that isn't valid UTF-8 counts as
+// binary too, since it wouldn't survive being sent as a JSON string.
+func isBinary(content []byte) bool {
+	head := content
+	if len(head) > 8000 {
+		head = head[:8000]
+	}
+	return bytes.IndexByte(head, 0) >= 0 || !utf8.Valid(content)
+}
+
 func handleWriteFile(stream network.Stream, rawPayload json.RawMessage) {
 	var payload protocol.WriteFileRequestPayload
 	if err := json.Unmarshal(rawPayload, &payload); err != nil {
 		// handle error properly
 		return
 	}
-	log.Printf("Handling WriteFile request for %s in repo %s", payload.FilePath, payload.RepoPath)
+	filesLog.Info("Handling WriteFile request", "file", payload.FilePath, "repo", payload.RepoPath)
 
 	respPayload := protocol.WriteFileResponsePayload{}
-	repoRoot, ok := linkedRepos[payload.RepoPath]
+	repoRoot, ok := resolveRepo(payload.RepoPath)
 	if !ok {
 		respPayload.Success = false
 		respPayload.Error = "unknown repository alias"
 	} else {
 		// !!! SECURITY CRITICAL: Path validation is essential here too!
-		fullPath := filepath.Join(repoRoot, payload.FilePath)
-		cleanRepoRoot := filepath.Clean(repoRoot)
-
-		if !strings.HasPrefix(fullPath, cleanRepoRoot) {
+		fullPath, err := sandboxPath(payload.RepoPath, repoRoot, payload.FilePath)
+		if err != nil {
+			respPayload.Success = false
+			respPayload.Error = err.Error()
+		} else if current, hash, changed := changedSince(fullPath, payload.BaseHash); changed {
 			respPayload.Success = false
-			respPayload.Error = "Access denied: path is outside of repository root"
+			respPayload.Error = "the file has changed since the version this edit is based on"
+			respPayload.Conflict = true
+			respPayload.CurrentContent = string(current)
+			respPayload.CurrentHash = hash
 		} else {
-			// Write the file, creating it if it doesn't exist. 0644 is standard file permissions.
-			err := os.WriteFile(fullPath, []byte(payload.Content), 0644)
+			// Write the file, creating it if it doesn't exist
+			backupPrevious(payload.RepoPath, repoRoot, payload.FilePath)
+			err := writeFileAtomic(fullPath, []byte(payload.Content))
 			if err != nil {
 				respPayload.Success = false
 				respPayload.Error = err.Error()
 			} else {
 				respPayload.Success = true
+				respPayload.Written = writtenFile(repoRoot, payload.FilePath)
 			}
 		}
 	}
@@ -364,52 +1032,80 @@ func handleWriteFile(stream network.Stream, rawPayload json.RawMessage) {
 	protocol.WriteMessage(stream, response)
 }
 
+// changedSince reports whether the file at fullPath no longer has the blob
+// hash baseHash, returning its current content and hash if so. A missing file
+// has an empty hash. Without a baseHash nothing is checked.
+func changedSince(fullPath, baseHash string) (current []byte, hash string, changed bool) {
+	if baseHash == "" {
+		return nil, "", false
+	}
+	current, err := os.ReadFile(fullPath)
+	if err == nil {
+		hash = plumbing.ComputeHash(plumbing.BlobObject, current).String()
+	}
+	return current, hash, hash != baseHash
+}
+
+// writtenFile reports the hash and git status of a file just written, so the
+// client can check the write landed. It returns nil if git can't tell.
+func writtenFile(repoPath, filePath string) *protocol.WrittenFile {
+	hash, err := git.FileHash(repoPath, filePath)
+	if err != nil {
+		filesLog.Warn("Cannot hash file", "file", filePath, "err", err)
+		return nil
+	}
+	status, err := git.StatusLine(repoPath, filePath)
+	if err != nil {
+		filesLog.Warn("Cannot get file status", "file", filePath, "err", err)
+		return nil
+	}
+	return &protocol.WrittenFile{Hash: hash, Status: status}
+}
+
 func handleListFiles(stream network.Stream, rawPayload json.RawMessage) {
 	var payload protocol.ListFilesRequestPayload
 	if err := json.Unmarshal(rawPayload, &payload); err != nil {
 		// handle error properly
 		return
 	}
-	log.Printf("Handling ListFiles request for repo %s", payload.RepoPath)
+	filesLog.Info("Handling ListFiles request", "repo", payload.RepoPath, "prefix", payload.Prefix, "patterns", payload.Patterns, "ignored", payload.IncludeIgnored)
 
 	respPayload := protocol.ListFilesResponsePayload{}
-	repoRoot, ok := linkedRepos[payload.RepoPath]
+	repoRoot, ok := resolveRepo(payload.RepoPath)
+	validPatterns := true
+	for _, pattern := range payload.Patterns {
+		validPatterns = validPatterns && validGlob(pattern)
+	}
 	if !ok {
 		respPayload.Success = false
 		respPayload.Error = "unknown repository alias"
+	} else if _, err := sandboxPath(payload.RepoPath, repoRoot, payload.Prefix); err != nil {
+		respPayload.Success = false
+		respPayload.Error = err.Error()
+	} else if !validPatterns {
+		respPayload.Success = false
+		respPayload.Error = fmt.Sprintf("invalid glob pattern in %v", payload.Patterns)
 	} else {
-		// Add debugging to see what path we're walking
-		log.Printf("Walking directory: %s", repoRoot)
-
+		prefix := filepath.ToSlash(filepath.Clean("/" + payload.Prefix))[1:]
 		var files []string
-		err := filepath.WalkDir(repoRoot, func(path string, d fs.DirEntry, err error) error {
-			if err != nil {
-				log.Printf("Error walking path %s: %v", path, err)
-				return err
+		var entries []protocol.DirEntry
+		states := fileStates(repoRoot)
+		// git does the walking, so ignored files (node_modules, build output...)
+		// are skipped unless asked for
+		listed, err := git.ListFiles(repoRoot, prefix, payload.IncludeIgnored)
+		box := repoSandbox(payload.RepoPath, repoRoot)
+		for _, relativePath := range listed {
+			if box.Excluded(relativePath) {
+				continue
 			}
-
-			// Debug: log what we're finding
-			log.Printf("Found: %s (isDir: %t)", path, d.IsDir())
-
-			// Skip the .git directory
-			if d.IsDir() && d.Name() == ".git" {
-				log.Printf("Skipping .git directory: %s", path)
-				return filepath.SkipDir
+			if len(payload.Patterns) > 0 && !anyGlob(payload.Patterns, relativePath, matchGlob) {
+				continue
 			}
-
-			// Don't skip the root directory itself, just skip .git
-			if !d.IsDir() {
-				// Make the path relative to the repo root
-				relativePath, err := filepath.Rel(repoRoot, path)
-				if err != nil {
-					log.Printf("Error making path relative: %v", err)
-					return err
-				}
-				log.Printf("Adding file: %s", relativePath)
-				files = append(files, relativePath)
+			files = append(files, relativePath)
+			if info, err := os.Lstat(filepath.Join(repoRoot, relativePath)); err == nil {
+				entries = append(entries, dirEntry(relativePath, info, states[relativePath]))
 			}
-			return nil
-		})
+		}
 
 		if err != nil {
 			respPayload.Success = false
@@ -417,11 +1113,12 @@ func handleListFiles(stream network.Stream, rawPayload json.RawMessage) {
 		} else {
 			respPayload.Success = true
 			respPayload.Files = files
+			respPayload.Entries = entries
 		}
 	}
 
 	// --- ADD THIS DEBUG LINE ---
-	log.Printf("Daemon found %d files to send: %v", len(respPayload.Files), respPayload.Files)
+	filesLog.Debug("Found files to send", "count", len(respPayload.Files), "files", respPayload.Files)
 
 	payloadBytes, _ := json.Marshal(respPayload)
 	response := &protocol.Message{Type: protocol.TypeListFilesResponse, Payload: payloadBytes}
@@ -431,24 +1128,21 @@ func handleListFiles(stream network.Stream, rawPayload json.RawMessage) {
 func handleCreateBranch(stream network.Stream, rawPayload json.RawMessage) {
 	var payload protocol.CreateBranchRequestPayload
 	if err := json.Unmarshal(rawPayload, &payload); err != nil {
-		log.Printf("Error unmarshalling create branch request: %v", err)
+		gitLog.Warn("Error unmarshalling create branch request", "err", err)
 		return
 	}
-	log.Printf("Handling CreateBranch request for repo %s, branch %s", payload.RepoPath, payload.NewBranchName)
+	gitLog.Info("Handling CreateBranch request", "repo", payload.RepoPath, "branch", payload.NewBranchName)
 
 	respPayload := protocol.CreateBranchResponsePayload{}
-	repoPath, ok := linkedRepos[payload.RepoPath]
+	repoPath, ok := resolveRepo(payload.RepoPath)
 	if !ok {
 		respPayload.Success = false
 		respPayload.Output = fmt.Sprintf("Unknown repository alias: %s", payload.RepoPath)
 	} else {
 		// Here we just create the branch, we don't switch to it on the daemon.
-		cmd := exec.Command("git", "branch", payload.NewBranchName)
-		cmd.Dir = repoPath
-		out, err := cmd.CombinedOutput()
-		if err != nil {
+		if err := git.CreateBranch(repoPath, payload.NewBranchName); err != nil {
 			respPayload.Success = false
-			respPayload.Output = string(out)
+			respPayload.Output = err.Error()
 		} else {
 			respPayload.Success = true
 			respPayload.Output = fmt.Sprintf("Branch '%s' created.", payload.NewBranchName)
@@ -463,18 +1157,23 @@ func handleCreateBranch(stream network.Stream, rawPayload json.RawMessage) {
 func handleRenameFile(stream network.Stream, rawPayload json.RawMessage) {
 	var payload protocol.RenameFileRequestPayload
 	json.Unmarshal(rawPayload, &payload)
-	log.Printf("Handling Git-aware Rename request in repo %s from %s to %s", payload.RepoPath, payload.OldPath, payload.NewPath)
+	gitLog.Info("Handling Git-aware Rename request", "repo", payload.RepoPath, "from", payload.OldPath, "to", payload.NewPath)
 
 	respPayload := protocol.RenameFileResponsePayload{}
-	repoPath, ok := linkedRepos[payload.RepoPath]
+	repoPath, ok := resolveRepo(payload.RepoPath)
+	box := repoSandbox(payload.RepoPath, repoPath)
+	_, oldErr := box.Path(payload.OldPath)
+	_, newErr := box.Path(payload.NewPath)
 	if !ok {
 		respPayload.Success = false
 		respPayload.Error = "unknown repository alias"
+	} else if oldErr != nil || newErr != nil {
+		respPayload.Success = false
+		respPayload.Error = errors.Join(oldErr, newErr).Error()
 	} else {
 		// --- THE FIX: Use `git mv` instead of `os.Rename` ---
 		// The paths from the client are already relative to the repo root, which is what `git mv` wants.
-		cmd := exec.Command("git", "mv", payload.OldPath, payload.NewPath)
-		cmd.Dir = repoPath
+		cmd := git.Command(repoPath, "mv", "--", payload.OldPath, payload.NewPath)
 		out, err := cmd.CombinedOutput()
 
 		if err != nil {
@@ -493,35 +1192,28 @@ func handleRenameFile(stream network.Stream, rawPayload json.RawMessage) {
 func handleListBranches(stream network.Stream, rawPayload json.RawMessage) {
 	var payload protocol.ListBranchesRequestPayload
 	if err := json.Unmarshal(rawPayload, &payload); err != nil {
-		log.Printf("Error unmarshalling list branches request: %v", err)
+		gitLog.Warn("Error unmarshalling list branches request", "err", err)
 		return
 	}
-	log.Printf("Handling ListBranches request for repo %s", payload.RepoPath)
+	gitLog.Info("Handling ListBranches request", "repo", payload.RepoPath)
 
 	respPayload := protocol.ListBranchesResponsePayload{}
-	repoPath, ok := linkedRepos[payload.RepoPath]
+	repoPath, ok := resolveRepo(payload.RepoPath)
 	if !ok {
 		respPayload.Success = false
 		respPayload.Error = "unknown repository alias"
 	} else {
-		// git branch --format "%(refname:short)" is a clean way to get just branch names
-		cmd := exec.Command("git", "branch", "--format", "%(refname:short)")
-		cmd.Dir = repoPath
-		out, err := cmd.CombinedOutput()
+		branches, err := git.Branches(repoPath)
 		if err != nil {
 			respPayload.Success = false
-			respPayload.Error = string(out)
+			respPayload.Error = err.Error()
 		} else {
 			respPayload.Success = true
-			// Split by newlines and filter out empty strings
-			branchList := strings.Split(strings.TrimSpace(string(out)), "\n")
-			var branches []string
-			for _, branch := range branchList {
-				if strings.TrimSpace(branch) != "" {
-					branches = append(branches, strings.TrimSpace(branch))
-				}
+			for _, branch := range branches {
+				respPayload.Branches = append(respPayload.Branches, branch.Name)
 			}
-			respPayload.Branches = branches
+			respPayload.Current, _ = git.CurrentBranch(repoPath)
+			respPayload.Default, _ = git.DefaultBranch(repoPath)
 		}
 	}
 
@@ -533,26 +1225,17 @@ func handleListBranches(stream network.Stream, rawPayload json.RawMessage) {
 func handleLinkRepo(stream network.Stream, rawPayload json.RawMessage) {
 	var payload protocol.LinkRepoRequestPayload
 	if err := json.Unmarshal(rawPayload, &payload); err != nil {
-		log.Printf("Error unmarshalling link repo request: %v", err)
+		slog.Warn("Error unmarshalling link repo request", "err", err)
 		return
 	}
-	log.Printf("Handling LinkRepo request for alias %s", payload.Alias)
+	slog.Info("Handling LinkRepo request", "alias", payload.Alias)
 
 	respPayload := protocol.LinkRepoResponsePayload{}
-	// On the daemon, the path is expected to be an absolute path
-	// A real-world app might have more security here.
-	absPath, err := filepath.Abs(payload.Path)
-	if err != nil {
+	if err := linkRepo(payload.Alias, payload.Path); err != nil {
 		respPayload.Success = false
-		respPayload.Error = fmt.Sprintf("Invalid path: %v", err)
+		respPayload.Error = err.Error()
 	} else {
-		linkedRepos[payload.Alias] = absPath
-		if err := saveLinkedRepos(); err != nil {
-			respPayload.Success = false
-			respPayload.Error = fmt.Sprintf("Failed to save repo list: %v", err)
-		} else {
-			respPayload.Success = true
-		}
+		respPayload.Success = true
 	}
 
 	payloadBytes, _ := json.Marshal(respPayload)
@@ -560,11 +1243,33 @@ func handleLinkRepo(stream network.Stream, rawPayload json.RawMessage) {
 	protocol.WriteMessage(stream, response)
 }
 
+// linkRepo links a repo under an alias and saves the list.
+func linkRepo(alias, path string) error {
+	// On the daemon, the path is expected to be an absolute path
+	// A real-world app might have more security here.
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return fmt.Errorf("Invalid path: %v", err)
+	}
+	if alias == "" || strings.Contains(alias, "/") {
+		// "/" is reserved for addressing worktrees as <alias>/<name>
+		return fmt.Errorf("Invalid alias: must be non-empty and must not contain '/'")
+	}
+	configMu.Lock()
+	linkedRepos[alias] = absPath
+	err = saveLinkedRepos()
+	configMu.Unlock()
+	watcher.addRepo(alias, absPath)
+	if err != nil {
+		return fmt.Errorf("Failed to save repo list: %v", err)
+	}
+	return nil
+}
+
 // Helper function to find a specific stash's index
 func findStashIndex(repoPath, stashMessage string) (string, bool) {
 	// This command lists stashes with their index and message, e.g., "stash@{0}: p2p-auto-stash-for-master"
-	cmd := exec.Command("git", "stash", "list")
-	cmd.Dir = repoPath
+	cmd := git.Command(repoPath, "stash", "list")
 	out, err := cmd.CombinedOutput()
 	if err != nil {
 		return "", false
@@ -588,10 +1293,10 @@ func findStashIndex(repoPath, stashMessage string) (string, bool) {
 func handleSwitchBranch(stream network.Stream, rawPayload json.RawMessage) {
 	var payload protocol.SwitchBranchRequestPayload
 	json.Unmarshal(rawPayload, &payload)
-	log.Printf("Handling SmartSwitch request for repo %s to branch %s", payload.RepoPath, payload.BranchName)
+	gitLog.Info("Handling SmartSwitch request", "repo", payload.RepoPath, "branch", payload.BranchName)
 
 	respPayload := protocol.SwitchBranchResponsePayload{}
-	repoPath, ok := linkedRepos[payload.RepoPath]
+	repoPath, ok := resolveRepo(payload.RepoPath)
 	if !ok {
 		respPayload.Success = false
 		respPayload.Output = "Error: unknown repository alias"
@@ -605,28 +1310,21 @@ func handleSwitchBranch(stream network.Stream, rawPayload json.RawMessage) {
 	// --- NEW SMART SWITCH LOGIC ---
 
 	// 1. Get the current branch name on the daemon
-	cmdCurrentBranch := exec.Command("git", "rev-parse", "--abbrev-ref", "HEAD")
-	cmdCurrentBranch.Dir = repoPath
-	currentBranchBytes, err := cmdCurrentBranch.Output()
+	currentBranch, err := git.CurrentBranch(repoPath)
 	if err != nil {
-		// handle error...
-		return
-	}
-	currentBranch := strings.TrimSpace(string(currentBranchBytes))
-
-	if currentBranch == payload.BranchName {
+		respPayload.Success = false
+		respPayload.Output = err.Error()
+	} else if currentBranch == payload.BranchName {
 		respPayload.Success = true
 		respPayload.Output = fmt.Sprintf("Already on branch '%s'.", payload.BranchName)
 	} else {
 		// 2. Stash any current changes on the old branch
 		stashMsg := fmt.Sprintf("p2p-auto-stash-for-%s", currentBranch)
-		cmdStash := exec.Command("git", "stash", "save", "--include-untracked", stashMsg)
-		cmdStash.Dir = repoPath
+		cmdStash := git.Command(repoPath, "stash", "save", "--include-untracked", stashMsg)
 		cmdStash.Run() // We run this even if there are no changes to stash
 
 		// 3. Checkout the new branch
-		cmdCheckout := exec.Command("git", "checkout", payload.BranchName)
-		cmdCheckout.Dir = repoPath
+		cmdCheckout := git.Command(repoPath, "checkout", payload.BranchName)
 		out, err := cmdCheckout.CombinedOutput()
 		if err != nil {
 			respPayload.Success = false
@@ -635,8 +1333,7 @@ func handleSwitchBranch(stream network.Stream, rawPayload json.RawMessage) {
 			// 4. Try to pop the stash for the NEW branch
 			popStashMsg := fmt.Sprintf("p2p-auto-stash-for-%s", payload.BranchName)
 			if index, found := findStashIndex(repoPath, popStashMsg); found {
-				cmdPop := exec.Command("git", "stash", "pop", index)
-				cmdPop.Dir = repoPath
+				cmdPop := git.Command(repoPath, "stash", "pop", index)
 				popOut, _ := cmdPop.CombinedOutput()
 				respPayload.Output = fmt.Sprintf("Switched to branch '%s'.\nRestored previous work for this branch:\n%s", payload.BranchName, string(popOut))
 			} else {
@@ -655,22 +1352,54 @@ func handleSwitchBranch(stream network.Stream, rawPayload json.RawMessage) {
 func handleGitStatus(stream network.Stream, rawPayload json.RawMessage) {
 	var payload protocol.GitStatusRequestPayload
 	json.Unmarshal(rawPayload, &payload)
-	log.Printf("Handling GitStatus request for repo %s", payload.RepoPath)
+	gitLog.Info("Handling GitStatus request", "repo", payload.RepoPath)
 
 	respPayload := protocol.GitStatusResponsePayload{}
-	repoPath, ok := linkedRepos[payload.RepoPath]
+	repoPath, ok := resolveRepo(payload.RepoPath)
 	if !ok {
 		respPayload.Success = false
 		respPayload.Output = "Error: unknown repository alias"
 	} else {
-		cmd := exec.Command("git", "status", "--porcelain")
-		cmd.Dir = repoPath
-		out, _ := cmd.CombinedOutput()
-		respPayload.Success = true
-		if len(out) == 0 {
-			respPayload.Output = "Working tree is clean."
+		files, err := git.Status(repoPath)
+		if err != nil {
+			respPayload.Success = false
+			respPayload.Output = err.Error()
 		} else {
-			respPayload.Output = string(out)
+			respPayload.Success = true
+			var out strings.Builder
+			for _, f := range files {
+				out.WriteString(f.String() + "\n")
+				respPayload.Files = append(respPayload.Files, protocol.StatusEntry{
+					Path:     f.Path,
+					Staging:  string(f.Staging),
+					Worktree: string(f.Worktree),
+				})
+				switch {
+				case f.Worktree == '?':
+					respPayload.Untracked++
+				default:
+					if f.Staging != ' ' {
+						respPayload.Staged++
+					}
+					if f.Worktree != ' ' {
+						respPayload.Unstaged++
+					}
+				}
+			}
+
+			tracking, err := git.BranchTracking(repoPath)
+			if err != nil {
+				gitLog.Warn("Failed to get tracking info", "repo", repoPath, "err", err)
+			}
+			respPayload.Branch = tracking.Branch
+			respPayload.Upstream = tracking.Upstream
+			respPayload.Ahead = tracking.Ahead
+			respPayload.Behind = tracking.Behind
+			if len(files) == 0 {
+				respPayload.Output = "Working tree is clean."
+			} else {
+				respPayload.Output = out.String()
+			}
 		}
 	}
 
@@ -682,20 +1411,54 @@ func handleGitStatus(stream network.Stream, rawPayload json.RawMessage) {
 func handleGitLog(stream network.Stream, rawPayload json.RawMessage) {
 	var payload protocol.GitLogRequestPayload
 	json.Unmarshal(rawPayload, &payload)
-	log.Printf("Handling GitLog request for repo %s", payload.RepoPath)
+	gitLog.Info("Handling GitLog request", "repo", payload.RepoPath)
 
 	respPayload := protocol.GitLogResponsePayload{}
-	repoPath, ok := linkedRepos[payload.RepoPath]
+	repoPath, ok := resolveRepo(payload.RepoPath)
 	if !ok {
 		respPayload.Success = false
 		respPayload.Output = "Error: unknown repository alias"
 	} else {
-		cmd := exec.Command("git", "log", "--graph", "--pretty=format:'%Cred%h%Creset -%C(yellow)%d%Creset %s %Cgreen(%cr) %C(bold blue)<%an>%Creset'", "--abbrev-commit", "-n", "15")
-		cmd.Dir = repoPath
-		out, err := cmd.CombinedOutput()
+		limit := payload.Limit
+		if limit <= 0 {
+			limit = 15
+		}
+		commits, err := git.Log(repoPath, limit)
+		if err == nil && payload.ShowSignatures {
+			// G (good), B (bad), U (unknown validity), N (unsigned), etc.
+			err = git.VerifySignatures(repoPath, commits)
+		}
 
 		respPayload.Success = (err == nil)
-		respPayload.Output = string(out)
+		if err != nil {
+			respPayload.Output = err.Error()
+		} else {
+			var out strings.Builder
+			for _, c := range commits {
+				out.WriteString(c.String())
+				if payload.ShowSignatures {
+					out.WriteString(" [sig: " + c.Signature + "]")
+				}
+				out.WriteString("\n")
+			}
+			respPayload.Output = out.String()
+
+			for _, c := range commits {
+				entry := protocol.LogEntry{
+					Hash:       c.Hash,
+					AbbrevHash: c.AbbrevHash,
+					Author:     c.AuthorName,
+					Email:      c.AuthorEmail,
+					Date:       c.Date,
+					Subject:    c.Subject,
+					Refs:       c.Refs,
+				}
+				if payload.ShowSignatures {
+					entry.Signature = c.Signature
+				}
+				respPayload.Commits = append(respPayload.Commits, entry)
+			}
+		}
 	}
 
 	payloadBytes, _ := json.Marshal(respPayload)
@@ -703,33 +1466,104 @@ func handleGitLog(stream network.Stream, rawPayload json.RawMessage) {
 	protocol.WriteMessage(stream, response)
 }
 
+func handleFileLog(stream network.Stream, rawPayload json.RawMessage) {
+	var payload protocol.FileLogRequestPayload
+	json.Unmarshal(rawPayload, &payload)
+	gitLog.Info("Handling FileLog request", "file", payload.FilePath, "repo", payload.RepoPath)
+
+	respPayload := protocol.FileLogResponsePayload{}
+	repoPath, ok := resolveRepo(payload.RepoPath)
+	if !ok {
+		respPayload.Success = false
+		respPayload.Output = "Error: unknown repository alias"
+	} else if payload.FilePath == "" {
+		respPayload.Success = false
+		respPayload.Output = "no path given"
+	} else if _, err := sandboxPath(payload.RepoPath, repoPath, payload.FilePath); err != nil {
+		respPayload.Success = false
+		respPayload.Output = err.Error()
+	} else {
+		limit := payload.Limit
+		if limit <= 0 {
+			limit = 15
+		}
+		commits, err := git.FileLog(repoPath, payload.FilePath, limit)
+
+		respPayload.Success = (err == nil)
+		if err != nil {
+			respPayload.Output = err.Error()
+		} else if len(commits) == 0 {
+			respPayload.Output = "No history found for " + payload.FilePath
+		} else {
+			var out strings.Builder
+			for _, c := range commits {
+				out.WriteString(c.String())
+				if c.Path != payload.FilePath {
+					out.WriteString(" [as " + c.Path + "]")
+				}
+				out.WriteString("\n")
+
+				respPayload.Commits = append(respPayload.Commits, protocol.LogEntry{
+					Hash:       c.Hash,
+					AbbrevHash: c.AbbrevHash,
+					Author:     c.AuthorName,
+					Email:      c.AuthorEmail,
+					Date:       c.Date,
+					Subject:    c.Subject,
+					Path:       c.Path,
+				})
+			}
+			respPayload.Output = out.String()
+		}
+	}
+
+	payloadBytes, _ := json.Marshal(respPayload)
+	response := &protocol.Message{Type: protocol.TypeFileLogResponse, Payload: payloadBytes}
+	protocol.WriteMessage(stream, response)
+}
+
 func handleGitDiff(stream network.Stream, rawPayload json.RawMessage) {
 	var payload protocol.GitDiffRequestPayload
 	json.Unmarshal(rawPayload, &payload)
-	log.Printf("Handling GitDiff request for repo %s, file %s", payload.RepoPath, payload.FilePath)
+	gitLog.Info("Handling GitDiff request", "repo", payload.RepoPath, "file", payload.FilePath)
 
 	respPayload := protocol.GitDiffResponsePayload{}
-	repoPath, ok := linkedRepos[payload.RepoPath]
+	repoPath, ok := resolveRepo(payload.RepoPath)
 	if !ok {
 		respPayload.Success = false
 		respPayload.Output = "Error: unknown repository alias"
+	} else if _, err := sandboxPath(payload.RepoPath, repoPath, payload.FilePath); err != nil {
+		respPayload.Success = false
+		respPayload.Output = err.Error()
 	} else {
-		var cmd *exec.Cmd
-		if payload.FilePath == "" {
-			// Diff for the whole repo
-			cmd = exec.Command("git", "diff", "--color")
-		} else {
-			// Diff for a specific file
-			cmd = exec.Command("git", "diff", "--color", "--", payload.FilePath)
-		}
-		cmd.Dir = repoPath
-		out, err := cmd.CombinedOutput()
+		// An empty FilePath diffs the whole repo
+		diffs, err := git.Diff(repoPath, payload.FilePath, git.DiffOptions{
+			Context:        payload.ContextLines,
+			WordDiff:       payload.WordDiff,
+			IgnoreAllSpace: payload.IgnoreAllSpace,
+		})
 
 		respPayload.Success = (err == nil)
-		if len(out) == 0 {
+		if err != nil {
+			respPayload.Output = err.Error()
+		} else if len(diffs) == 0 {
 			respPayload.Output = "No differences found."
+		} else if payload.Stat {
+			for _, d := range diffs {
+				respPayload.Stats = append(respPayload.Stats, protocol.DiffStat{
+					Path:       d.Path,
+					Insertions: d.Insertions,
+					Deletions:  d.Deletions,
+					Binary:     d.Binary,
+				})
+			}
+			respPayload.Output = formatDiffStat(diffs)
 		} else {
-			respPayload.Output = string(out)
+			var out strings.Builder
+			for _, d := range diffs {
+				out.WriteString(d.Patch)
+			}
+			respPayload.Output = out.String()
 		}
 	}
 
@@ -738,20 +1572,54 @@ func handleGitDiff(stream network.Stream, rawPayload json.RawMessage) {
 	protocol.WriteMessage(stream, response)
 }
 
+// formatDiffStat renders diffs like `git diff --stat`, scaling the +/- bars
+// to fit a small screen.
+func formatDiffStat(diffs []git.FileDiff) string {
+	const maxBar = 30
+	nameWidth, maxChanges := 0, 0
+	insertions, deletions := 0, 0
+	for _, d := range diffs {
+		nameWidth = max(nameWidth, len(d.Path))
+		maxChanges = max(maxChanges, d.Insertions+d.Deletions)
+		insertions += d.Insertions
+		deletions += d.Deletions
+	}
+
+	var out strings.Builder
+	for _, d := range diffs {
+		if d.Binary {
+			fmt.Fprintf(&out, " %-*s | Bin\n", nameWidth, d.Path)
+			continue
+		}
+		plus, minus := d.Insertions, d.Deletions
+		if maxChanges > maxBar {
+			plus = plus * maxBar / maxChanges
+			minus = minus * maxBar / maxChanges
+		}
+		fmt.Fprintf(&out, " %-*s | %4d %s%s\n", nameWidth, d.Path, d.Insertions+d.Deletions,
+			strings.Repeat("+", plus), strings.Repeat("-", minus))
+	}
+	fmt.Fprintf(&out, " %d file(s) changed, %d insertion(s)(+), %d deletion(s)(-)", len(diffs), insertions, deletions)
+	return out.String()
+}
+
 func handleGitStashSave(stream network.Stream, rawPayload json.RawMessage) {
 	var payload protocol.GitStashSaveRequestPayload
 	json.Unmarshal(rawPayload, &payload)
-	log.Printf("Handling GitStashSave request for repo %s", payload.RepoPath)
+	gitLog.Info("Handling GitStashSave request", "repo", payload.RepoPath)
 
 	respPayload := protocol.GitStashSaveResponsePayload{}
-	repoPath, ok := linkedRepos[payload.RepoPath]
+	repoPath, ok := resolveRepo(payload.RepoPath)
 	if !ok {
 		respPayload.Success = false
 		respPayload.Output = "Error: unknown repository alias"
 	} else {
 		// --- THE FIX: Add the --include-untracked flag ---
-		cmd := exec.Command("git", "stash", "save", "--include-untracked", "p2p-remote-stash") // Optional message
-		cmd.Dir = repoPath
+		stashMsg := payload.Message
+		if stashMsg == "" {
+			stashMsg = "p2p-remote-stash"
+		}
+		cmd := git.Command(repoPath, "stash", "push", "--include-untracked", "-m", stashMsg)
 		out, err := cmd.CombinedOutput()
 
 		respPayload.Success = (err == nil)
@@ -766,17 +1634,16 @@ func handleGitStashSave(stream network.Stream, rawPayload json.RawMessage) {
 func handleGitStashPop(stream network.Stream, rawPayload json.RawMessage) {
 	var payload protocol.GitStashPopRequestPayload
 	json.Unmarshal(rawPayload, &payload)
-	log.Printf("Handling GitStashPop request for repo %s", payload.RepoPath)
+	gitLog.Info("Handling GitStashPop request", "repo", payload.RepoPath)
 
 	respPayload := protocol.GitStashPopResponsePayload{}
-	repoPath, ok := linkedRepos[payload.RepoPath]
+	repoPath, ok := resolveRepo(payload.RepoPath)
 	if !ok {
 		respPayload.Success = false
 		respPayload.Output = "Error: unknown repository alias"
 	} else {
-		// `git stash pop` applies the most recent stash and removes it from the list
-		cmd := exec.Command("git", "stash", "pop")
-		cmd.Dir = repoPath
+		// `git stash pop` applies the stash (the most recent by default) and removes it from the list
+		cmd := git.Command(repoPath, "stash", "pop", fmt.Sprintf("stash@{%d}", payload.Index))
 		out, err := cmd.CombinedOutput()
 
 		respPayload.Success = (err == nil)
@@ -788,19 +1655,99 @@ func handleGitStashPop(stream network.Stream, rawPayload json.RawMessage) {
 	protocol.WriteMessage(stream, response)
 }
 
+func handleGitStashList(stream network.Stream, rawPayload json.RawMessage) {
+	var payload protocol.GitStashListRequestPayload
+	json.Unmarshal(rawPayload, &payload)
+	gitLog.Info("Handling GitStashList request", "repo", payload.RepoPath)
+
+	respPayload := protocol.GitStashListResponsePayload{}
+	repoPath, ok := resolveRepo(payload.RepoPath)
+	if !ok {
+		respPayload.Success = false
+		respPayload.Error = "unknown repository alias"
+	} else {
+		stashes, err := git.StashList(repoPath)
+		if err != nil {
+			respPayload.Success = false
+			respPayload.Error = err.Error()
+		} else {
+			respPayload.Success = true
+			for _, st := range stashes {
+				respPayload.Stashes = append(respPayload.Stashes, protocol.StashEntry{
+					Index:   st.Index,
+					Ref:     st.Ref,
+					Branch:  st.Branch,
+					Message: st.Message,
+					Date:    st.Date,
+				})
+			}
+		}
+	}
+
+	payloadBytes, _ := json.Marshal(respPayload)
+	response := &protocol.Message{Type: protocol.TypeGitStashListResponse, Payload: payloadBytes}
+	protocol.WriteMessage(stream, response)
+}
+
+func handleGitStashApply(stream network.Stream, rawPayload json.RawMessage) {
+	var payload protocol.GitStashApplyRequestPayload
+	json.Unmarshal(rawPayload, &payload)
+	gitLog.Info("Handling GitStashApply request", "repo", payload.RepoPath, "stash", payload.Index)
+
+	respPayload := protocol.GitStashApplyResponsePayload{}
+	repoPath, ok := resolveRepo(payload.RepoPath)
+	if !ok {
+		respPayload.Success = false
+		respPayload.Output = "Error: unknown repository alias"
+	} else {
+		// Unlike pop, apply keeps the entry in the stash list
+		cmd := git.Command(repoPath, "stash", "apply", fmt.Sprintf("stash@{%d}", payload.Index))
+		out, err := cmd.CombinedOutput()
+
+		respPayload.Success = (err == nil)
+		respPayload.Output = string(out)
+	}
+
+	payloadBytes, _ := json.Marshal(respPayload)
+	response := &protocol.Message{Type: protocol.TypeGitStashApplyResponse, Payload: payloadBytes}
+	protocol.WriteMessage(stream, response)
+}
+
+func handleGitStashDrop(stream network.Stream, rawPayload json.RawMessage) {
+	var payload protocol.GitStashDropRequestPayload
+	json.Unmarshal(rawPayload, &payload)
+	gitLog.Info("Handling GitStashDrop request", "repo", payload.RepoPath, "stash", payload.Index)
+
+	respPayload := protocol.GitStashDropResponsePayload{}
+	repoPath, ok := resolveRepo(payload.RepoPath)
+	if !ok {
+		respPayload.Success = false
+		respPayload.Output = "Error: unknown repository alias"
+	} else {
+		cmd := git.Command(repoPath, "stash", "drop", fmt.Sprintf("stash@{%d}", payload.Index))
+		out, err := cmd.CombinedOutput()
+
+		respPayload.Success = (err == nil)
+		respPayload.Output = string(out)
+	}
+
+	payloadBytes, _ := json.Marshal(respPayload)
+	response := &protocol.Message{Type: protocol.TypeGitStashDropResponse, Payload: payloadBytes}
+	protocol.WriteMessage(stream, response)
+}
+
 func handleGitReset(stream network.Stream, rawPayload json.RawMessage) {
 	var payload protocol.GitResetRequestPayload
 	json.Unmarshal(rawPayload, &payload)
-	log.Printf("!!! DESTRUCTIVE ACTION: Handling GitReset request for repo %s", payload.RepoPath)
+	gitLog.Warn("DESTRUCTIVE ACTION: Handling GitReset request", "repo", payload.RepoPath)
 
 	respPayload := protocol.GitResetResponsePayload{}
-	repoPath, ok := linkedRepos[payload.RepoPath]
+	repoPath, ok := resolveRepo(payload.RepoPath)
 	if !ok {
 		respPayload.Success = false
 		respPayload.Output = "Error: unknown repository alias"
 	} else {
-		cmd := exec.Command("git", "reset", "--hard", "HEAD")
-		cmd.Dir = repoPath
+		cmd := git.Command(repoPath, "reset", "--hard", "HEAD")
 		out, err := cmd.CombinedOutput()
 		respPayload.Success = (err == nil)
 		respPayload.Output = string(out)
@@ -811,36 +1758,1445 @@ func handleGitReset(stream network.Stream, rawPayload json.RawMessage) {
 	protocol.WriteMessage(stream, response)
 }
 
-func getRepoAliases() []string {
-	keys := make([]string, 0, len(linkedRepos))
-	for k := range linkedRepos {
-		keys = append(keys, k)
+func handleListHunks(stream network.Stream, rawPayload json.RawMessage) {
+	var payload protocol.ListHunksRequestPayload
+	json.Unmarshal(rawPayload, &payload)
+	gitLog.Info("Handling ListHunks request", "file", payload.FilePath, "repo", payload.RepoPath)
+
+	respPayload := protocol.ListHunksResponsePayload{}
+	repoPath, ok := resolveRepo(payload.RepoPath)
+	if !ok {
+		respPayload.Success = false
+		respPayload.Error = "unknown repository alias"
+	} else if payload.FilePath == "" {
+		respPayload.Success = false
+		respPayload.Error = "no path given"
+	} else if _, err := sandboxPath(payload.RepoPath, repoPath, payload.FilePath); err != nil {
+		respPayload.Success = false
+		respPayload.Error = err.Error()
+	} else {
+		hunks, err := git.Hunks(repoPath, payload.FilePath)
+		if err != nil {
+			respPayload.Success = false
+			respPayload.Error = err.Error()
+		} else {
+			respPayload.Success = true
+			for _, h := range hunks {
+				respPayload.Hunks = append(respPayload.Hunks, protocol.DiffHunk{
+					ID:         h.ID,
+					Header:     h.Header,
+					Patch:      h.Patch,
+					Insertions: h.Insertions,
+					Deletions:  h.Deletions,
+				})
+			}
+		}
 	}
-	return keys
+
+	payloadBytes, _ := json.Marshal(respPayload)
+	response := &protocol.Message{Type: protocol.TypeListHunksResponse, Payload: payloadBytes}
+	protocol.WriteMessage(stream, response)
 }
 
-// NEW Function: Load repos from JSON file
-func loadLinkedRepos() {
-	linkedRepos = make(map[string]string)
-	data, err := os.ReadFile(linkedReposFile)
+func handleStageHunks(stream network.Stream, rawPayload json.RawMessage) {
+	var payload protocol.StageHunksRequestPayload
+	json.Unmarshal(rawPayload, &payload)
+	gitLog.Info("Handling StageHunks request", "hunks", len(payload.HunkIDs), "file", payload.FilePath, "repo", payload.RepoPath)
+
+	respPayload := protocol.StageHunksResponsePayload{}
+	repoPath, ok := resolveRepo(payload.RepoPath)
+	if !ok {
+		respPayload.Success = false
+		respPayload.Output = "Error: unknown repository alias"
+	} else if payload.FilePath == "" {
+		respPayload.Success = false
+		respPayload.Output = "no path given"
+	} else if _, err := sandboxPath(payload.RepoPath, repoPath, payload.FilePath); err != nil {
+		respPayload.Success = false
+		respPayload.Output = err.Error()
+	} else {
+		n, err := git.StageHunks(repoPath, payload.FilePath, payload.HunkIDs)
+		respPayload.Success = (err == nil)
+		if err != nil {
+			respPayload.Output = err.Error()
+		} else {
+			respPayload.Output = fmt.Sprintf("Staged %d hunk(s) of %s\n", n, payload.FilePath)
+		}
+	}
+
+	payloadBytes, _ := json.Marshal(respPayload)
+	response := &protocol.Message{Type: protocol.TypeStageHunksResponse, Payload: payloadBytes}
+	protocol.WriteMessage(stream, response)
+}
+
+func handleListDir(stream network.Stream, rawPayload json.RawMessage) {
+	var payload protocol.ListDirRequestPayload
+	json.Unmarshal(rawPayload, &payload)
+	filesLog.Info("Handling ListDir request", "path", payload.Path, "repo", payload.RepoPath, "ignored", payload.IncludeIgnored)
+
+	respPayload := protocol.ListDirResponsePayload{Path: filepath.ToSlash(filepath.Clean("/" + payload.Path))[1:]}
+	repoPath, ok := resolveRepo(payload.RepoPath)
+	if !ok {
+		respPayload.Success = false
+		respPayload.Error = "unknown repository alias"
+	} else if _, err := sandboxPath(payload.RepoPath, repoPath, payload.Path); err != nil {
+		respPayload.Success = false
+		respPayload.Error = err.Error()
+	} else if entries, err := os.ReadDir(filepath.Join(repoPath, payload.Path)); err != nil {
+		respPayload.Success = false
+		respPayload.Error = err.Error()
+	} else {
+		respPayload.Success = true
+		states := fileStates(repoPath)
+		ignored := make(map[string]bool)
+		if !payload.IncludeIgnored {
+			paths, _ := git.IgnoredPaths(repoPath, respPayload.Path)
+			for _, p := range paths {
+				ignored[strings.TrimSuffix(p, "/")] = true
+			}
+		}
+		box := repoSandbox(payload.RepoPath, repoPath)
+		for _, e := range entries {
+			if box.Excluded(path.Join(respPayload.Path, e.Name())) || ignored[path.Join(respPayload.Path, e.Name())] {
+				continue
+			}
+			info, err := e.Info()
+			if err != nil {
+				continue // Deleted while listing
+			}
+			relPath := path.Join(respPayload.Path, e.Name())
+			state := states[relPath]
+			if e.IsDir() && dirChanged(states, relPath) {
+				state = "modified"
+			}
+			respPayload.Entries = append(respPayload.Entries, dirEntry(e.Name(), info, state))
+		}
+		// ReadDir sorts by name; put directories first
+		sort.SliceStable(respPayload.Entries, func(i, j int) bool {
+			return respPayload.Entries[i].IsDir && !respPayload.Entries[j].IsDir
+		})
+	}
+
+	payloadBytes, _ := json.Marshal(respPayload)
+	response := &protocol.Message{Type: protocol.TypeListDirResponse, Payload: payloadBytes}
+	protocol.WriteMessage(stream, response)
+}
+
+// fileStates maps each changed path (slash-separated, from the repo root) to
+// its git.FileStatus State. Listings still work without it, so errors just
+// leave it empty.
+func fileStates(repoPath string) map[string]string {
+	states := make(map[string]string)
+	files, err := git.Status(repoPath)
 	if err != nil {
-		if os.IsNotExist(err) {
-			log.Println("linked_repos.json not found, starting with empty repo list.")
-			return
+		filesLog.Warn("Could not get status for listing", "repo", repoPath, "err", err)
+	}
+	for _, f := range files {
+		states[f.Path] = f.State()
+	}
+	return states
+}
+
+// dirChanged reports whether anything below dir has a git status.
+func dirChanged(states map[string]string, dir string) bool {
+	for p := range states {
+		if strings.HasPrefix(p, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func dirEntry(name string, info fs.FileInfo, state string) protocol.DirEntry {
+	entry := protocol.DirEntry{
+		Name:    name,
+		IsDir:   info.IsDir(),
+		ModTime: info.ModTime(),
+		Mode:    info.Mode().String(),
+		Status:  state,
+	}
+	if !info.IsDir() {
+		entry.Size = info.Size()
+	}
+	return entry
+}
+
+func handleApplyPatch(stream network.Stream, rawPayload json.RawMessage) {
+	var payload protocol.ApplyPatchRequestPayload
+	json.Unmarshal(rawPayload, &payload)
+	filesLog.Info("Handling ApplyPatch request", "file", payload.FilePath, "repo", payload.RepoPath, "bytes", len(payload.Patch))
+
+	respPayload := protocol.ApplyPatchResponsePayload{}
+	repoPath, ok := resolveRepo(payload.RepoPath)
+	if !ok {
+		respPayload.Success = false
+		respPayload.Output = "Error: unknown repository alias"
+	} else if _, err := sandboxPath(payload.RepoPath, repoPath, payload.FilePath); err != nil {
+		respPayload.Success = false
+		respPayload.Output = err.Error()
+	} else {
+		err := git.ApplyPatch(repoPath, payload.FilePath, payload.Patch)
+		respPayload.Success = (err == nil)
+		respPayload.Conflict = errors.Is(err, git.ErrPatchDoesNotApply)
+		if err != nil {
+			respPayload.Output = err.Error()
+		} else {
+			respPayload.Output = fmt.Sprintf("Applied patch to %s", payload.FilePath)
+			respPayload.Written = writtenFile(repoPath, payload.FilePath)
+		}
+	}
+
+	payloadBytes, _ := json.Marshal(respPayload)
+	response := &protocol.Message{Type: protocol.TypeApplyPatchResponse, Payload: payloadBytes}
+	protocol.WriteMessage(stream, response)
+}
+
+func handleBatch(stream network.Stream, rawPayload json.RawMessage) {
+	var payload protocol.BatchRequestPayload
+	json.Unmarshal(rawPayload, &payload)
+	filesLog.Info("Handling Batch request", "repo", payload.RepoPath, "operations", len(payload.Operations))
+
+	respPayload := protocol.BatchResponsePayload{}
+	repoPath, ok := resolveRepo(payload.RepoPath)
+	if !ok {
+		respPayload.Success = false
+		respPayload.Output = "Error: unknown repository alias"
+	} else {
+		var ops []git.BatchOp
+		var denied error
+		box := repoSandbox(payload.RepoPath, repoPath)
+		for _, op := range payload.Operations {
+			if _, denied = box.Path(op.Path); denied == nil && op.NewPath != "" {
+				_, denied = box.Path(op.NewPath)
+			}
+			if denied != nil {
+				break
+			}
+			ops = append(ops, git.BatchOp{Op: op.Op, Path: op.Path, NewPath: op.NewPath, Content: op.Content})
+		}
+		if denied != nil {
+			respPayload.Success = false
+			respPayload.Output = denied.Error()
+		} else if err := git.ApplyBatch(repoPath, ops); err != nil {
+			respPayload.Success = false
+			respPayload.Output = err.Error()
+		} else {
+			respPayload.Success = true
+			respPayload.Output = fmt.Sprintf("Applied %d changes\n", len(ops))
+		}
+	}
+
+	payloadBytes, _ := json.Marshal(respPayload)
+	response := &protocol.Message{Type: protocol.TypeBatchResponse, Payload: payloadBytes}
+	protocol.WriteMessage(stream, response)
+}
+
+func handleChmod(stream network.Stream, rawPayload json.RawMessage) {
+	var payload protocol.ChmodRequestPayload
+	json.Unmarshal(rawPayload, &payload)
+	filesLog.Info("Handling Chmod request", "file", payload.FilePath, "repo", payload.RepoPath, "mode", payload.Mode)
+
+	respPayload := protocol.ChmodResponsePayload{}
+	repoPath, ok := resolveRepo(payload.RepoPath)
+	if !ok {
+		respPayload.Success = false
+		respPayload.Output = "Error: unknown repository alias"
+	} else if _, err := sandboxPath(payload.RepoPath, repoPath, payload.FilePath); err != nil {
+		respPayload.Success = false
+		respPayload.Output = err.Error()
+	} else {
+		perm, err := git.Chmod(repoPath, payload.FilePath, payload.Mode)
+		respPayload.Success = (err == nil)
+		if err != nil {
+			respPayload.Output = err.Error()
+		} else {
+			respPayload.Output = fmt.Sprintf("Mode of %s is now %s", payload.FilePath, perm)
+		}
+	}
+
+	payloadBytes, _ := json.Marshal(respPayload)
+	response := &protocol.Message{Type: protocol.TypeChmodResponse, Payload: payloadBytes}
+	protocol.WriteMessage(stream, response)
+}
+
+func handleGrep(stream network.Stream, rawPayload json.RawMessage) {
+	var payload protocol.GrepRequestPayload
+	json.Unmarshal(rawPayload, &payload)
+	filesLog.Info("Handling Grep request", "pattern", payload.Pattern, "repo", payload.RepoPath)
+
+	respPayload := protocol.GrepResponsePayload{}
+	repoPath, ok := resolveRepo(payload.RepoPath)
+	var denied error
+	for _, p := range payload.Paths {
+		if _, err := sandboxPath(payload.RepoPath, repoPath, p); err != nil && denied == nil {
+			denied = err
+		}
+	}
+	if !ok {
+		respPayload.Success = false
+		respPayload.Error = "unknown repository alias"
+	} else if denied != nil {
+		respPayload.Success = false
+		respPayload.Error = denied.Error()
+	} else {
+		limit := payload.MaxResults
+		if limit <= 0 || limit > 1000 {
+			limit = 1000
+		}
+		opts := git.GrepOptions{IgnoreCase: payload.IgnoreCase, Paths: payload.Paths, MaxResults: limit}
+		matches, truncated, err := git.Grep(repoPath, payload.Pattern, opts)
+		if err != nil {
+			respPayload.Success = false
+			respPayload.Error = err.Error()
+		} else {
+			respPayload.Success = true
+			respPayload.Truncated = truncated
+			for _, m := range matches {
+				respPayload.Matches = append(respPayload.Matches, protocol.GrepMatch{Path: m.Path, Line: m.Line, Text: m.Text})
+			}
+		}
+	}
+
+	payloadBytes, _ := json.Marshal(respPayload)
+	response := &protocol.Message{Type: protocol.TypeGrepResponse, Payload: payloadBytes}
+	protocol.WriteMessage(stream, response)
+}
+
+func handleCreateFile(stream network.Stream, rawPayload json.RawMessage) {
+	var payload protocol.CreateFileRequestPayload
+	json.Unmarshal(rawPayload, &payload)
+	filesLog.Info("Handling CreateFile request", "file", payload.FilePath, "repo", payload.RepoPath)
+
+	respPayload := protocol.CreateFileResponsePayload{}
+	repoPath, ok := resolveRepo(payload.RepoPath)
+	fullPath := filepath.Join(repoPath, payload.FilePath)
+	if !ok {
+		respPayload.Success = false
+		respPayload.Output = "Error: unknown repository alias"
+	} else if payload.FilePath == "" || fullPath == filepath.Clean(repoPath) {
+		respPayload.Success = false
+		respPayload.Output = "no file path given"
+	} else if _, err := sandboxPath(payload.RepoPath, repoPath, payload.FilePath); err != nil {
+		respPayload.Success = false
+		respPayload.Output = err.Error()
+	} else if err := createFile(fullPath, payload.Content); err != nil {
+		respPayload.Success = false
+		respPayload.Output = err.Error()
+	} else if err := git.Add(repoPath, payload.FilePath); err != nil {
+		// The file exists now, it just isn't staged (it may be ignored)
+		respPayload.Success = true
+		respPayload.Output = fmt.Sprintf("Created %s, but could not stage it: %v", payload.FilePath, err)
+	} else {
+		respPayload.Success = true
+		respPayload.Output = fmt.Sprintf("Created and staged %s", payload.FilePath)
+	}
+
+	payloadBytes, _ := json.Marshal(respPayload)
+	response := &protocol.Message{Type: protocol.TypeCreateFileResponse, Payload: payloadBytes}
+	protocol.WriteMessage(stream, response)
+}
+
+// createFile creates a new file and its missing parent directories. It fails
+// if the file already exists.
+func createFile(fullPath, content string) error {
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(fullPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if os.IsExist(err) {
+		return fmt.Errorf("%s already exists", filepath.Base(fullPath))
+	} else if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(content); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func handleMkdir(stream network.Stream, rawPayload json.RawMessage) {
+	var payload protocol.MkdirRequestPayload
+	json.Unmarshal(rawPayload, &payload)
+	filesLog.Info("Handling Mkdir request", "path", payload.Path, "repo", payload.RepoPath)
+
+	respPayload := protocol.MkdirResponsePayload{}
+	repoPath, ok := resolveRepo(payload.RepoPath)
+	if !ok {
+		respPayload.Success = false
+		respPayload.Output = "Error: unknown repository alias"
+	} else if payload.Path == "" {
+		respPayload.Success = false
+		respPayload.Output = "no path given"
+	} else if _, err := sandboxPath(payload.RepoPath, repoPath, payload.Path); err != nil {
+		respPayload.Success = false
+		respPayload.Output = err.Error()
+	} else if err := os.MkdirAll(filepath.Join(repoPath, payload.Path), 0755); err != nil {
+		respPayload.Success = false
+		respPayload.Output = err.Error()
+	} else {
+		respPayload.Success = true
+		respPayload.Output = fmt.Sprintf("Created directory %s (git only tracks it once it contains a file)", payload.Path)
+	}
+
+	payloadBytes, _ := json.Marshal(respPayload)
+	response := &protocol.Message{Type: protocol.TypeMkdirResponse, Payload: payloadBytes}
+	protocol.WriteMessage(stream, response)
+}
+
+func handleDeletePath(stream network.Stream, rawPayload json.RawMessage) {
+	var payload protocol.DeletePathRequestPayload
+	json.Unmarshal(rawPayload, &payload)
+	filesLog.Warn("DESTRUCTIVE ACTION: Handling DeletePath request", "path", payload.Path, "repo", payload.RepoPath, "force", payload.Force)
+
+	respPayload := protocol.DeletePathResponsePayload{}
+	repoPath, ok := resolveRepo(payload.RepoPath)
+	if !ok {
+		respPayload.Success = false
+		respPayload.Output = "Error: unknown repository alias"
+	} else if payload.Path == "" {
+		respPayload.Success = false
+		respPayload.Output = "no path given"
+	} else if _, err := sandboxPath(payload.RepoPath, repoPath, payload.Path); err != nil {
+		respPayload.Success = false
+		respPayload.Output = err.Error()
+	} else {
+		out, err := git.RemovePath(repoPath, payload.Path, payload.Force)
+		respPayload.Success = (err == nil)
+		if err != nil {
+			respPayload.Output = err.Error()
+		} else {
+			respPayload.Output = out + fmt.Sprintf("Deleted %s\n", payload.Path)
+		}
+	}
+
+	payloadBytes, _ := json.Marshal(respPayload)
+	response := &protocol.Message{Type: protocol.TypeDeletePathResponse, Payload: payloadBytes}
+	protocol.WriteMessage(stream, response)
+}
+
+func handleCheckoutFile(stream network.Stream, rawPayload json.RawMessage) {
+	var payload protocol.CheckoutFileRequestPayload
+	json.Unmarshal(rawPayload, &payload)
+	gitLog.Warn("DESTRUCTIVE ACTION: Handling CheckoutFile request", "file", payload.FilePath, "repo", payload.RepoPath)
+
+	respPayload := protocol.CheckoutFileResponsePayload{}
+	repoPath, ok := resolveRepo(payload.RepoPath)
+	if !ok {
+		respPayload.Success = false
+		respPayload.Output = "Error: unknown repository alias"
+	} else if payload.FilePath == "" {
+		respPayload.Success = false
+		respPayload.Output = "no path given"
+	} else if _, err := sandboxPath(payload.RepoPath, repoPath, payload.FilePath); err != nil {
+		respPayload.Success = false
+		respPayload.Output = err.Error()
+	} else {
+		out, err := git.DiscardFile(repoPath, payload.FilePath)
+		respPayload.Success = (err == nil)
+		if err != nil {
+			respPayload.Output = err.Error()
+		} else {
+			respPayload.Output = out + fmt.Sprintf("Discarded changes to %s\n", payload.FilePath)
+		}
+	}
+
+	payloadBytes, _ := json.Marshal(respPayload)
+	response := &protocol.Message{Type: protocol.TypeCheckoutFileResponse, Payload: payloadBytes}
+	protocol.WriteMessage(stream, response)
+}
+
+func handleReflog(stream network.Stream, rawPayload json.RawMessage) {
+	var payload protocol.ReflogRequestPayload
+	json.Unmarshal(rawPayload, &payload)
+	gitLog.Info("Handling Reflog request", "repo", payload.RepoPath)
+
+	respPayload := protocol.ReflogResponsePayload{}
+	repoPath, ok := resolveRepo(payload.RepoPath)
+	if !ok {
+		respPayload.Success = false
+		respPayload.Error = "unknown repository alias"
+	} else {
+		limit := payload.Limit
+		if limit <= 0 {
+			limit = 20
+		}
+		entries, err := git.Reflog(repoPath, limit)
+		if err != nil {
+			respPayload.Success = false
+			respPayload.Error = err.Error()
+		} else {
+			respPayload.Success = true
+			for _, e := range entries {
+				respPayload.Entries = append(respPayload.Entries, protocol.ReflogEntry{
+					Index:   e.Index,
+					Hash:    e.Hash,
+					Action:  e.Action,
+					Message: e.Message,
+					Date:    e.Date,
+				})
+			}
+		}
+	}
+
+	payloadBytes, _ := json.Marshal(respPayload)
+	response := &protocol.Message{Type: protocol.TypeReflogResponse, Payload: payloadBytes}
+	protocol.WriteMessage(stream, response)
+}
+
+func handleResetToReflog(stream network.Stream, rawPayload json.RawMessage) {
+	var payload protocol.ResetToReflogRequestPayload
+	json.Unmarshal(rawPayload, &payload)
+	gitLog.Warn("DESTRUCTIVE ACTION: Handling ResetToReflog request", "target", fmt.Sprintf("HEAD@{%d}", payload.Index), "repo", payload.RepoPath)
+
+	respPayload := protocol.ResetToReflogResponsePayload{}
+	repoPath, ok := resolveRepo(payload.RepoPath)
+	if !ok {
+		respPayload.Success = false
+		respPayload.Output = "Error: unknown repository alias"
+	} else if payload.Index < 0 || payload.Hash == "" {
+		respPayload.Success = false
+		respPayload.Output = "Error: a reflog index and its commit hash are required"
+	} else {
+		out, err := git.ResetToReflog(repoPath, payload.Index, payload.Hash)
+		respPayload.Success = (err == nil)
+		if err != nil {
+			respPayload.Output = err.Error()
+		} else {
+			// The reset is itself a reflog entry, so HEAD@{1} is where we just were
+			respPayload.Output = out + "To undo this, reset to reflog entry 1.\n"
+		}
+	}
+
+	payloadBytes, _ := json.Marshal(respPayload)
+	response := &protocol.Message{Type: protocol.TypeResetToReflogResponse, Payload: payloadBytes}
+	protocol.WriteMessage(stream, response)
+}
+
+// cleanToken fingerprints a dry-run result so a forced clean only proceeds if
+// the client confirmed exactly the paths that are about to be deleted.
+func cleanToken(paths []string) string {
+	sum := sha256.Sum256([]byte(strings.Join(paths, "\n")))
+	return hex.EncodeToString(sum[:8])
+}
+
+func handleGitClean(stream network.Stream, rawPayload json.RawMessage) {
+	var payload protocol.GitCleanRequestPayload
+	json.Unmarshal(rawPayload, &payload)
+	gitLog.Info("Handling GitClean request", "repo", payload.RepoPath, "force", payload.Force)
+
+	respPayload := protocol.GitCleanResponsePayload{DryRun: !payload.Force}
+	repoPath, ok := resolveRepo(payload.RepoPath)
+	if !ok {
+		respPayload.Success = false
+		respPayload.Output = "Error: unknown repository alias"
+	} else {
+		// Always preview first, even for a forced clean, so the token can be checked
+		paths, err := git.CleanPreview(repoPath, payload.Directories, payload.IncludeIgnored)
+		switch {
+		case err != nil:
+			respPayload.Success = false
+			respPayload.Output = err.Error()
+		case !payload.Force:
+			respPayload.Success = true
+			respPayload.Paths = paths
+			respPayload.ConfirmToken = cleanToken(paths)
+		case payload.ConfirmToken != cleanToken(paths):
+			respPayload.Success = false
+			respPayload.Paths = paths
+			respPayload.Output = "Error: the set of untracked files changed since the dry run. Run the dry run again and re-confirm."
+		default:
+			gitLog.Warn("DESTRUCTIVE ACTION: Cleaning untracked paths", "count", len(paths), "repo", repoPath)
+			out, err := git.Clean(repoPath, payload.Directories, payload.IncludeIgnored)
+			respPayload.Success = (err == nil)
+			respPayload.Paths = paths
+			respPayload.Output = out
+		}
+	}
+
+	payloadBytes, _ := json.Marshal(respPayload)
+	response := &protocol.Message{Type: protocol.TypeGitCleanResponse, Payload: payloadBytes}
+	protocol.WriteMessage(stream, response)
+}
+
+// resolveRepo maps a repo alias to its path on disk. An alias of the form
+// "<alias>/<worktree>" resolves to the linked worktree of that name, so a
+// client can work in a separate checkout without disturbing the main one.
+func resolveRepo(alias string) (string, bool) {
+	base, worktreeName, isWorktree := strings.Cut(alias, "/")
+	repoPath, ok := linkedRepo(base)
+	if !ok || !isWorktree {
+		return repoPath, ok
+	}
+
+	worktrees, err := git.Worktrees(repoPath)
+	if err != nil {
+		gitLog.Warn("Failed to list worktrees", "repo", repoPath, "err", err)
+		return "", false
+	}
+	for _, wt := range worktrees {
+		if wt.Path != repoPath && filepath.Base(wt.Path) == worktreeName {
+			return wt.Path, true
+		}
+	}
+	return "", false
+}
+
+func handleListWorktrees(stream network.Stream, rawPayload json.RawMessage) {
+	var payload protocol.ListWorktreesRequestPayload
+	json.Unmarshal(rawPayload, &payload)
+	gitLog.Info("Handling ListWorktrees request", "repo", payload.RepoPath)
+
+	respPayload := protocol.ListWorktreesResponsePayload{}
+	// Always list from the main checkout, even when addressed through a worktree
+	base, _, _ := strings.Cut(payload.RepoPath, "/")
+	repoPath, ok := linkedRepo(base)
+	if !ok {
+		respPayload.Success = false
+		respPayload.Error = "unknown repository alias"
+	} else {
+		worktrees, err := git.Worktrees(repoPath)
+		if err != nil {
+			respPayload.Success = false
+			respPayload.Error = err.Error()
+		} else {
+			respPayload.Success = true
+			for _, wt := range worktrees {
+				if wt.Bare {
+					continue
+				}
+				entry := protocol.WorktreeEntry{
+					Path:     wt.Path,
+					Branch:   wt.Branch,
+					Head:     wt.Head,
+					Detached: wt.Detached,
+				}
+				if wt.Path != repoPath {
+					entry.Name = filepath.Base(wt.Path)
+				}
+				respPayload.Worktrees = append(respPayload.Worktrees, entry)
+			}
+		}
+	}
+
+	payloadBytes, _ := json.Marshal(respPayload)
+	response := &protocol.Message{Type: protocol.TypeListWorktreesResponse, Payload: payloadBytes}
+	protocol.WriteMessage(stream, response)
+}
+
+func handleAddWorktree(stream network.Stream, rawPayload json.RawMessage) {
+	var payload protocol.AddWorktreeRequestPayload
+	json.Unmarshal(rawPayload, &payload)
+	gitLog.Info("Handling AddWorktree request", "repo", payload.RepoPath, "branch", payload.Branch)
+
+	respPayload := protocol.AddWorktreeResponsePayload{}
+	base, _, _ := strings.Cut(payload.RepoPath, "/")
+	repoPath, ok := linkedRepo(base)
+	if !ok {
+		respPayload.Success = false
+		respPayload.Output = "Error: unknown repository alias"
+	} else if payload.Branch == "" {
+		respPayload.Success = false
+		respPayload.Output = "Error: a branch name is required"
+	} else if strings.HasPrefix(payload.Branch, "-") {
+		// It would be taken for an option of git worktree add
+		respPayload.Success = false
+		respPayload.Output = "Error: invalid branch name"
+	} else if wtPath, err := worktreePath(repoPath, payload.Path, payload.Branch); err != nil {
+		respPayload.Success = false
+		respPayload.Output = "Error: " + err.Error()
+	} else {
+		out, err := git.AddWorktree(repoPath, wtPath, payload.Branch, payload.NewBranch)
+		respPayload.Success = (err == nil)
+		respPayload.Output = out
+		if err == nil {
+			respPayload.Name = filepath.Base(wtPath)
+		}
+	}
+
+	payloadBytes, _ := json.Marshal(respPayload)
+	response := &protocol.Message{Type: protocol.TypeAddWorktreeResponse, Payload: payloadBytes}
+	protocol.WriteMessage(stream, response)
+}
+
+// worktreePath returns where to check out a new worktree: next to the main
+// checkout, at the path a peer asked for or at <repo>-<branch>. A peer can
+// only place it in the directory holding the repo, not anywhere on disk, and
+// not through a symlink there either.
+func worktreePath(repoPath, path, branch string) (string, error) {
+	if path == "" {
+		path = filepath.Base(repoPath) + "-" + strings.ReplaceAll(branch, "/", "-")
+	}
+	parent := filepath.Dir(repoPath)
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(parent, path)
+	}
+	path = filepath.Clean(path)
+	rel, err := filepath.Rel(parent, path)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("the worktree must be in %s, next to the repository", parent)
+	}
+	if _, err := sandbox.New(parent, nil).Path(rel); err != nil {
+		return "", fmt.Errorf("the worktree must be in %s, next to the repository", parent)
+	}
+	return path, nil
+}
+
+func handleConflictsList(stream network.Stream, rawPayload json.RawMessage) {
+	var payload protocol.ConflictsListRequestPayload
+	json.Unmarshal(rawPayload, &payload)
+	gitLog.Info("Handling ConflictsList request", "repo", payload.RepoPath)
+
+	respPayload := protocol.ConflictsListResponsePayload{}
+	repoPath, ok := resolveRepo(payload.RepoPath)
+	if !ok {
+		respPayload.Success = false
+		respPayload.Error = "unknown repository alias"
+	} else {
+		conflicts, err := git.Conflicts(repoPath)
+		if err != nil {
+			respPayload.Success = false
+			respPayload.Error = err.Error()
+		} else {
+			respPayload.Success = true
+			for _, c := range conflicts {
+				entry := protocol.ConflictEntry{Path: c.Path}
+				for _, h := range c.Hunks {
+					entry.Hunks = append(entry.Hunks, protocol.ConflictHunk{Line: h.Line, Ours: h.Ours, Base: h.Base, Theirs: h.Theirs})
+				}
+				respPayload.Conflicts = append(respPayload.Conflicts, entry)
+			}
+		}
+	}
+
+	payloadBytes, _ := json.Marshal(respPayload)
+	response := &protocol.Message{Type: protocol.TypeConflictsListResponse, Payload: payloadBytes}
+	protocol.WriteMessage(stream, response)
+}
+
+func handleResolveConflict(stream network.Stream, rawPayload json.RawMessage) {
+	var payload protocol.ResolveConflictRequestPayload
+	json.Unmarshal(rawPayload, &payload)
+	gitLog.Info("Handling ResolveConflict request", "file", payload.FilePath, "repo", payload.RepoPath, "resolution", payload.Resolution)
+
+	respPayload := protocol.ResolveConflictResponsePayload{}
+	repoPath, ok := resolveRepo(payload.RepoPath)
+	fullPath := filepath.Join(repoPath, payload.FilePath)
+	if !ok {
+		respPayload.Success = false
+		respPayload.Output = "Error: unknown repository alias"
+	} else if _, err := sandboxPath(payload.RepoPath, repoPath, payload.FilePath); err != nil {
+		respPayload.Success = false
+		respPayload.Output = err.Error()
+	} else {
+		var out string
+		var err error
+		switch payload.Resolution {
+		case protocol.ResolveOurs, protocol.ResolveTheirs:
+			out, err = git.ResolveConflict(repoPath, payload.FilePath, payload.Resolution)
+		case protocol.ResolveManual:
+			if err = writeFileAtomic(fullPath, []byte(payload.Content)); err == nil {
+				out, err = git.MarkResolved(repoPath, payload.FilePath)
+			} else {
+				out = err.Error()
+			}
+		default:
+			err = fmt.Errorf("unknown resolution")
+			out = fmt.Sprintf("Error: unknown resolution '%s' (use ours, theirs or manual)", payload.Resolution)
+		}
+		respPayload.Success = (err == nil)
+		respPayload.Output = out
+	}
+
+	payloadBytes, _ := json.Marshal(respPayload)
+	response := &protocol.Message{Type: protocol.TypeResolveConflictResponse, Payload: payloadBytes}
+	protocol.WriteMessage(stream, response)
+}
+
+func handleContinueMerge(stream network.Stream, rawPayload json.RawMessage) {
+	var payload protocol.ContinueMergeRequestPayload
+	json.Unmarshal(rawPayload, &payload)
+	gitLog.Info("Handling ContinueMerge request", "repo", payload.RepoPath)
+
+	respPayload := protocol.ContinueMergeResponsePayload{}
+	repoPath, ok := resolveRepo(payload.RepoPath)
+	if !ok {
+		respPayload.Success = false
+		respPayload.Output = "Error: unknown repository alias"
+	} else {
+		operation, out, err := git.ContinueMerge(repoPath)
+		respPayload.Success = (err == nil)
+		respPayload.Operation = operation
+		respPayload.Output = out
+	}
+
+	payloadBytes, _ := json.Marshal(respPayload)
+	response := &protocol.Message{Type: protocol.TypeContinueMergeResponse, Payload: payloadBytes}
+	protocol.WriteMessage(stream, response)
+}
+
+// logEntry converts a commit to its wire form.
+func logEntry(c git.Commit) *protocol.LogEntry {
+	return &protocol.LogEntry{
+		Hash:       c.Hash,
+		AbbrevHash: c.AbbrevHash,
+		Author:     c.AuthorName,
+		Email:      c.AuthorEmail,
+		Date:       c.Date,
+		Subject:    c.Subject,
+	}
+}
+
+// writeBisectResponse reports the outcome of any bisect step.
+func writeBisectResponse(stream network.Stream, state git.BisectState, err error) {
+	respPayload := protocol.BisectResponsePayload{Success: err == nil, Output: state.Output}
+	if err != nil {
+		respPayload.Output = err.Error()
+	} else if state.Done {
+		respPayload.Culprit = logEntry(state.Culprit)
+	} else if state.Current.Hash != "" {
+		respPayload.Current = logEntry(state.Current)
+		respPayload.Remaining = state.Remaining
+	}
+
+	payloadBytes, _ := json.Marshal(respPayload)
+	response := &protocol.Message{Type: protocol.TypeBisectResponse, Payload: payloadBytes}
+	protocol.WriteMessage(stream, response)
+}
+
+func handleBisectStart(stream network.Stream, rawPayload json.RawMessage) {
+	var payload protocol.BisectStartRequestPayload
+	json.Unmarshal(rawPayload, &payload)
+	gitLog.Info("Handling BisectStart request", "repo", payload.RepoPath, "good", payload.Good, "bad", payload.Bad)
+
+	repoPath, ok := resolveRepo(payload.RepoPath)
+	if !ok {
+		writeBisectResponse(stream, git.BisectState{}, errors.New("Error: unknown repository alias"))
+		return
+	}
+	state, err := git.BisectStart(repoPath, payload.Bad, payload.Good)
+	writeBisectResponse(stream, state, err)
+}
+
+// handleBisectMark handles both BISECT_GOOD and BISECT_BAD requests.
+func handleBisectMark(stream network.Stream, rawPayload json.RawMessage, verdict string) {
+	var payload protocol.BisectMarkRequestPayload
+	json.Unmarshal(rawPayload, &payload)
+	gitLog.Info("Handling Bisect request", "verdict", verdict, "repo", payload.RepoPath)
+
+	repoPath, ok := resolveRepo(payload.RepoPath)
+	if !ok {
+		writeBisectResponse(stream, git.BisectState{}, errors.New("Error: unknown repository alias"))
+		return
+	}
+	state, err := git.BisectMark(repoPath, verdict, payload.Commit)
+	writeBisectResponse(stream, state, err)
+}
+
+func handleBisectReset(stream network.Stream, rawPayload json.RawMessage) {
+	var payload protocol.BisectResetRequestPayload
+	json.Unmarshal(rawPayload, &payload)
+	gitLog.Info("Handling BisectReset request", "repo", payload.RepoPath)
+
+	repoPath, ok := resolveRepo(payload.RepoPath)
+	if !ok {
+		writeBisectResponse(stream, git.BisectState{}, errors.New("Error: unknown repository alias"))
+		return
+	}
+	out, err := git.BisectReset(repoPath)
+	writeBisectResponse(stream, git.BisectState{Output: out}, err)
+}
+
+// hookOutputWriter forwards hook output to the client as RUN_HOOK_OUTPUT
+// messages as soon as it is written.
+type hookOutputWriter struct {
+	stream network.Stream
+}
+
+func (w *hookOutputWriter) Write(p []byte) (int, error) {
+	payloadBytes, _ := json.Marshal(protocol.RunHookOutputPayload{Data: string(p)})
+	if err := protocol.WriteMessage(w.stream, &protocol.Message{Type: protocol.TypeRunHookOutput, Payload: payloadBytes}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// remoteURLFor returns a remote URL as a peer with role may see it. Below
+// read-write, peers can't read git config, so the user and password, often an
+// access token, are dropped from http(s) URLs.
+func remoteURLFor(role store.Role, remoteURL string) string {
+	if role.Allows(store.RoleReadWrite) {
+		return remoteURL
+	}
+	u, err := url.Parse(remoteURL)
+	if err != nil || u.User == nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return remoteURL
+	}
+	u.User = nil
+	return u.String()
+}
+
+func handleListRemotes(stream network.Stream, rawPayload json.RawMessage) {
+	var payload protocol.ListRemotesRequestPayload
+	json.Unmarshal(rawPayload, &payload)
+	gitLog.Info("Handling ListRemotes request", "repo", payload.RepoPath)
+
+	respPayload := protocol.ListRemotesResponsePayload{}
+	repoPath, ok := resolveRepo(payload.RepoPath)
+	if !ok {
+		respPayload.Success = false
+		respPayload.Error = "unknown repository alias"
+	} else {
+		remotes, err := git.Remotes(repoPath)
+		if err != nil {
+			respPayload.Success = false
+			respPayload.Error = err.Error()
+		} else {
+			respPayload.Success = true
+			respPayload.Default = pushRemote(payload.RepoPath)
+			role, _ := trustStore.Role(stream.Conn().RemotePeer())
+			for _, r := range remotes {
+				respPayload.Remotes = append(respPayload.Remotes, protocol.RemoteInfo{Name: r.Name, URL: remoteURLFor(role, r.URL)})
+			}
+		}
+	}
+
+	payloadBytes, _ := json.Marshal(respPayload)
+	response := &protocol.Message{Type: protocol.TypeListRemotesResponse, Payload: payloadBytes}
+	protocol.WriteMessage(stream, response)
+}
+
+func handleSetDefaultRemote(stream network.Stream, rawPayload json.RawMessage) {
+	var payload protocol.SetDefaultRemoteRequestPayload
+	json.Unmarshal(rawPayload, &payload)
+	gitLog.Info("Handling SetDefaultRemote request", "repo", payload.RepoPath, "remote", payload.Remote)
+
+	respPayload := protocol.SetDefaultRemoteResponsePayload{}
+	repoPath, ok := resolveRepo(payload.RepoPath)
+	if !ok {
+		respPayload.Success = false
+		respPayload.Output = "Error: unknown repository alias"
+	} else if found, err := git.HasRemote(repoPath, payload.Remote); err != nil {
+		respPayload.Success = false
+		respPayload.Output = err.Error()
+	} else if !found {
+		respPayload.Success = false
+		respPayload.Output = fmt.Sprintf("Error: repository has no remote named '%s'", payload.Remote)
+	} else {
+		err := updateRepoConfig(payload.RepoPath, func(cfg *RepoConfig) {
+			cfg.Remote = payload.Remote
+		})
+		if err != nil {
+			respPayload.Success = false
+			respPayload.Output = "Error: failed to save repo config: " + err.Error()
+		} else {
+			respPayload.Success = true
+			respPayload.Output = fmt.Sprintf("Commits will now be pushed to '%s'.", payload.Remote)
+		}
+	}
+
+	payloadBytes, _ := json.Marshal(respPayload)
+	response := &protocol.Message{Type: protocol.TypeSetDefaultRemoteResponse, Payload: payloadBytes}
+	protocol.WriteMessage(stream, response)
+}
+
+func handleGitConfigGet(stream network.Stream, rawPayload json.RawMessage) {
+	var payload protocol.GitConfigGetRequestPayload
+	json.Unmarshal(rawPayload, &payload)
+	gitLog.Info("Handling GitConfigGet request", "repo", payload.RepoPath, "key", payload.Key)
+
+	respPayload := protocol.GitConfigGetResponsePayload{}
+	repoPath, ok := resolveRepo(payload.RepoPath)
+	if !ok {
+		respPayload.Success = false
+		respPayload.Error = "unknown repository alias"
+	} else {
+		var entries []git.ConfigEntry
+		var err error
+		if payload.Key == "" {
+			entries, err = git.ListConfig(repoPath)
+		} else {
+			var entry git.ConfigEntry
+			var found bool
+			if entry, found, err = git.GetConfig(repoPath, payload.Key); found {
+				entries = append(entries, entry)
+			}
+		}
+		respPayload.Success = (err == nil)
+		if err != nil {
+			respPayload.Error = err.Error()
+		}
+		for _, e := range entries {
+			respPayload.Entries = append(respPayload.Entries, protocol.ConfigEntry{Key: e.Key, Value: e.Value, Scope: e.Scope})
+		}
+	}
+
+	payloadBytes, _ := json.Marshal(respPayload)
+	response := &protocol.Message{Type: protocol.TypeGitConfigGetResponse, Payload: payloadBytes}
+	protocol.WriteMessage(stream, response)
+}
+
+func handleGitConfigSet(stream network.Stream, rawPayload json.RawMessage) {
+	var payload protocol.GitConfigSetRequestPayload
+	json.Unmarshal(rawPayload, &payload)
+	gitLog.Info("Handling GitConfigSet request", "repo", payload.RepoPath, "key", payload.Key, "unset", payload.Unset)
+
+	respPayload := protocol.GitConfigSetResponsePayload{}
+	repoPath, ok := resolveRepo(payload.RepoPath)
+	if !ok {
+		respPayload.Success = false
+		respPayload.Output = "Error: unknown repository alias"
+	} else {
+		var err error
+		if payload.Unset {
+			err = git.UnsetConfig(repoPath, payload.Key)
+		} else {
+			err = git.SetConfig(repoPath, payload.Key, payload.Value)
+		}
+		respPayload.Success = (err == nil)
+		switch {
+		case err != nil:
+			respPayload.Output = err.Error()
+		case payload.Unset:
+			respPayload.Output = fmt.Sprintf("Unset %s", payload.Key)
+		default:
+			respPayload.Output = fmt.Sprintf("Set %s = %s", payload.Key, payload.Value)
+		}
+	}
+
+	payloadBytes, _ := json.Marshal(respPayload)
+	response := &protocol.Message{Type: protocol.TypeGitConfigSetResponse, Payload: payloadBytes}
+	protocol.WriteMessage(stream, response)
+}
+
+func handleIgnore(stream network.Stream, rawPayload json.RawMessage) {
+	var payload protocol.IgnoreRequestPayload
+	json.Unmarshal(rawPayload, &payload)
+	gitLog.Info("Handling Ignore request", "repo", payload.RepoPath, "pattern", payload.Pattern)
+
+	respPayload := protocol.IgnoreResponsePayload{}
+	repoPath, ok := resolveRepo(payload.RepoPath)
+	if !ok {
+		respPayload.Success = false
+		respPayload.Output = "Error: unknown repository alias"
+	} else {
+		result, err := git.Ignore(repoPath, payload.Pattern, payload.Untrack)
+		respPayload.Success = (err == nil)
+		respPayload.Ignored = result.Ignored
+		respPayload.Tracked = result.Tracked
+		if err != nil {
+			respPayload.Output = err.Error()
+		} else {
+			respPayload.Output = fmt.Sprintf("Added '%s' to .gitignore", payload.Pattern)
+		}
+	}
+
+	payloadBytes, _ := json.Marshal(respPayload)
+	response := &protocol.Message{Type: protocol.TypeIgnoreResponse, Payload: payloadBytes}
+	protocol.WriteMessage(stream, response)
+}
+
+func handleUnignore(stream network.Stream, rawPayload json.RawMessage) {
+	var payload protocol.UnignoreRequestPayload
+	json.Unmarshal(rawPayload, &payload)
+	gitLog.Info("Handling Unignore request", "repo", payload.RepoPath, "pattern", payload.Pattern)
+
+	respPayload := protocol.UnignoreResponsePayload{}
+	repoPath, ok := resolveRepo(payload.RepoPath)
+	if !ok {
+		respPayload.Success = false
+		respPayload.Output = "Error: unknown repository alias"
+	} else {
+		err := git.Unignore(repoPath, payload.Pattern)
+		respPayload.Success = (err == nil)
+		if err != nil {
+			respPayload.Output = err.Error()
+		} else {
+			respPayload.Output = fmt.Sprintf("Removed '%s' from .gitignore", payload.Pattern)
+		}
+	}
+
+	payloadBytes, _ := json.Marshal(respPayload)
+	response := &protocol.Message{Type: protocol.TypeUnignoreResponse, Payload: payloadBytes}
+	protocol.WriteMessage(stream, response)
+}
+
+func handleRunHook(stream network.Stream, rawPayload json.RawMessage) {
+	var payload protocol.RunHookRequestPayload
+	json.Unmarshal(rawPayload, &payload)
+	gitLog.Info("Handling RunHook request", "repo", payload.RepoPath, "hook", payload.Hook)
+
+	respPayload := protocol.RunHookResponsePayload{}
+	repoPath, ok := resolveRepo(payload.RepoPath)
+	if !ok {
+		respPayload.Success = false
+		respPayload.Error = "unknown repository alias"
+	} else {
+		err := git.RunHook(repoPath, payload.Hook, pushRemote(payload.RepoPath), &hookOutputWriter{stream: stream})
+		respPayload.Success = (err == nil)
+		if err != nil {
+			respPayload.Error = err.Error()
+			var hookErr *git.HookError
+			if errors.As(err, &hookErr) {
+				respPayload.ExitCode = hookErr.ExitCode
+			}
+		}
+	}
+
+	payloadBytes, _ := json.Marshal(respPayload)
+	response := &protocol.Message{Type: protocol.TypeRunHookResponse, Payload: payloadBytes}
+	protocol.WriteMessage(stream, response)
+}
+
+// ticketPattern matches issue IDs like PROJ-123 in branch names.
+var ticketPattern = regexp.MustCompile(`(?i)\b[a-z][a-z0-9]+-\d+\b`)
+
+// expandCommitTemplate fills in the {branch} and {ticket} placeholders.
+func expandCommitTemplate(template, branch string) string {
+	ticket := strings.ToUpper(ticketPattern.FindString(branch))
+	return strings.NewReplacer("{branch}", branch, "{ticket}", ticket).Replace(template)
+}
+
+func handleGetCommitTemplate(stream network.Stream, rawPayload json.RawMessage) {
+	var payload protocol.GetCommitTemplateRequestPayload
+	json.Unmarshal(rawPayload, &payload)
+	gitLog.Info("Handling GetCommitTemplate request", "repo", payload.RepoPath)
+
+	respPayload := protocol.GetCommitTemplateResponsePayload{}
+	repoPath, ok := resolveRepo(payload.RepoPath)
+	if !ok {
+		respPayload.Success = false
+		respPayload.Error = "unknown repository alias"
+	} else {
+		template := getRepoConfig(payload.RepoPath).CommitTemplate
+		var err error
+		if template == "" {
+			template, err = git.CommitTemplate(repoPath)
+		}
+		respPayload.Success = (err == nil)
+		if err != nil {
+			respPayload.Error = err.Error()
+		} else {
+			branch, _ := git.CurrentBranch(repoPath)
+			respPayload.Template = expandCommitTemplate(template, branch)
+		}
+	}
+
+	payloadBytes, _ := json.Marshal(respPayload)
+	response := &protocol.Message{Type: protocol.TypeGetCommitTemplateResponse, Payload: payloadBytes}
+	protocol.WriteMessage(stream, response)
+}
+
+func handleRepoStats(stream network.Stream, rawPayload json.RawMessage) {
+	var payload protocol.RepoStatsRequestPayload
+	json.Unmarshal(rawPayload, &payload)
+	gitLog.Info("Handling RepoStats request", "repo", payload.RepoPath)
+
+	respPayload := protocol.RepoStatsResponsePayload{Success: true}
+	aliases := []string{payload.RepoPath}
+	if payload.RepoPath == "" {
+		aliases = getRepoAliases()
+	}
+	for _, alias := range aliases {
+		repoPath, ok := resolveRepo(alias)
+		if !ok {
+			respPayload.Success = false
+			respPayload.Error = fmt.Sprintf("Unknown repository alias: %s", alias)
+			break
+		}
+		entry := protocol.RepoStats{Alias: alias}
+		st, err := git.RepoStats(repoPath, 5)
+		if err != nil {
+			// Report the broken repo but keep going, it's likely a candidate for unlinking
+			entry.Error = err.Error()
+		} else {
+			entry.SizeBytes = st.SizeBytes
+			entry.Objects = st.Objects
+			entry.Branches = st.Branches
+			entry.Tags = st.Tags
+			entry.LastCommit = st.LastCommit
+			entry.LastActivity = st.LastActivity
+			for _, c := range st.Contributors {
+				entry.Contributors = append(entry.Contributors, protocol.Contributor{Name: c.Name, Email: c.Email, Commits: c.Commits})
+			}
+		}
+		respPayload.Repos = append(respPayload.Repos, entry)
+	}
+
+	payloadBytes, _ := json.Marshal(respPayload)
+	response := &protocol.Message{Type: protocol.TypeRepoStatsResponse, Payload: payloadBytes}
+	protocol.WriteMessage(stream, response)
+}
+
+// chunkWriter sends everything written to it as data chunk messages of
+// msgType (BUNDLE_DATA or FILE_DATA, whose payloads have the same shape).
+type chunkWriter struct {
+	stream  network.Stream
+	msgType string
+	total   int64 // Sent with the first chunk, for the client's progress bar
+	size    int64
+}
+
+func (w *chunkWriter) Write(p []byte) (int, error) {
+	for start := 0; start < len(p); start += protocol.FileChunkSize {
+		end := min(start+protocol.FileChunkSize, len(p))
+		chunk := protocol.FileDataPayload{Data: p[start:end]}
+		if w.size == 0 {
+			chunk.Total = w.total
+		}
+		payloadBytes, _ := json.Marshal(chunk)
+		if err := protocol.WriteMessage(w.stream, &protocol.Message{Type: w.msgType, Payload: payloadBytes}); err != nil {
+			return start, err
+		}
+		w.size += int64(end - start)
+	}
+	return len(p), nil
+}
+
+func handleBundleCreate(stream network.Stream, rawPayload json.RawMessage) {
+	var payload protocol.BundleCreateRequestPayload
+	json.Unmarshal(rawPayload, &payload)
+	gitLog.Info("Handling BundleCreate request", "repo", payload.RepoPath, "refs", payload.Refs)
+
+	respPayload := protocol.BundleCreateResponsePayload{}
+	repoPath, ok := resolveRepo(payload.RepoPath)
+	if !ok {
+		respPayload.Success = false
+		respPayload.Error = "unknown repository alias"
+	} else {
+		w := &chunkWriter{stream: stream, msgType: protocol.TypeBundleData}
+		err := git.CreateBundle(repoPath, payload.Refs, w)
+		respPayload.Success = (err == nil)
+		respPayload.Size = w.size
+		if err != nil {
+			respPayload.Error = err.Error()
+		}
+	}
+
+	payloadBytes, _ := json.Marshal(respPayload)
+	response := &protocol.Message{Type: protocol.TypeBundleCreateResponse, Payload: payloadBytes}
+	protocol.WriteMessage(stream, response)
+}
+
+// receiveChunks copies size bytes of data chunk messages of msgType from the
+// stream to w.
+func receiveChunks(stream network.Stream, msgType string, size int64, w io.Writer) error {
+	var received int64
+	for received < size {
+		msg, err := protocol.ReadMessage(stream)
+		if err == nil && msg.Type != msgType {
+			err = fmt.Errorf("unexpected %s message during upload", msg.Type)
+		}
+		if err != nil {
+			return err
+		}
+		var chunk protocol.FileDataPayload
+		json.Unmarshal(msg.Payload, &chunk)
+		if _, err := w.Write(chunk.Data); err != nil {
+			return err
+		}
+		received += int64(len(chunk.Data))
+	}
+	return nil
+}
+
+// receiveBundle reads size bytes of BUNDLE_DATA chunks from the stream into a
+// temporary file, returning its path.
+func receiveBundle(stream network.Stream, size int64) (string, error) {
+	f, err := os.CreateTemp("", "p2p-bundle-*.bundle")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if err := receiveChunks(stream, protocol.TypeBundleData, size, f); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+func handleBundleUpload(stream network.Stream, rawPayload json.RawMessage) {
+	var payload protocol.BundleUploadRequestPayload
+	json.Unmarshal(rawPayload, &payload)
+	gitLog.Info("Handling BundleUpload request", "repo", payload.RepoPath, "bytes", payload.Size)
+
+	respPayload := protocol.BundleUploadResponsePayload{}
+	repoPath, ok := resolveRepo(payload.RepoPath)
+	if !ok {
+		// Nothing more is read, so the client's pending chunks are simply dropped
+		respPayload.Success = false
+		respPayload.Output = "Error: unknown repository alias"
+	} else if bundlePath, err := receiveBundle(stream, payload.Size); err != nil {
+		respPayload.Success = false
+		respPayload.Output = "Error receiving bundle: " + err.Error()
+	} else {
+		out, err := git.FetchBundle(repoPath, bundlePath)
+		os.Remove(bundlePath)
+		respPayload.Success = (err == nil)
+		respPayload.Output = out
+		if err != nil {
+			respPayload.Output = err.Error()
+		}
+	}
+
+	payloadBytes, _ := json.Marshal(respPayload)
+	response := &protocol.Message{Type: protocol.TypeBundleUploadResponse, Payload: payloadBytes}
+	protocol.WriteMessage(stream, response)
+}
+
+func handleDownloadFile(stream network.Stream, rawPayload json.RawMessage) {
+	var payload protocol.DownloadFileRequestPayload
+	json.Unmarshal(rawPayload, &payload)
+	filesLog.Info("Handling DownloadFile request", "file", payload.FilePath, "repo", payload.RepoPath)
+
+	respPayload := protocol.DownloadFileResponsePayload{}
+	repoPath, ok := resolveRepo(payload.RepoPath)
+	fullPath := filepath.Join(repoPath, payload.FilePath)
+	if !ok {
+		respPayload.Success = false
+		respPayload.Error = "unknown repository alias"
+	} else if _, err := sandboxPath(payload.RepoPath, repoPath, payload.FilePath); err != nil {
+		respPayload.Success = false
+		respPayload.Error = err.Error()
+	} else if info, err := os.Stat(fullPath); err != nil {
+		respPayload.Success = false
+		respPayload.Error = err.Error()
+	} else if !info.Mode().IsRegular() {
+		respPayload.Success = false
+		respPayload.Error = fmt.Sprintf("%s is not a regular file", payload.FilePath)
+	} else if f, err := os.Open(fullPath); err != nil {
+		respPayload.Success = false
+		respPayload.Error = err.Error()
+	} else {
+		w := &chunkWriter{stream: stream, msgType: protocol.TypeFileData, total: info.Size()}
+		_, err := io.Copy(w, f)
+		f.Close()
+		respPayload.Success = (err == nil)
+		respPayload.Size = w.size
+		if err != nil {
+			respPayload.Error = err.Error()
+		}
+	}
+
+	payloadBytes, _ := json.Marshal(respPayload)
+	response := &protocol.Message{Type: protocol.TypeDownloadFileResponse, Payload: payloadBytes}
+	protocol.WriteMessage(stream, response)
+}
+
+func handleUploadFile(stream network.Stream, rawPayload json.RawMessage) {
+	var payload protocol.UploadFileRequestPayload
+	json.Unmarshal(rawPayload, &payload)
+	filesLog.Info("Handling UploadFile request", "file", payload.FilePath, "repo", payload.RepoPath, "bytes", payload.Size)
+
+	// Nothing more is read on failure, so the client's pending chunks are simply dropped
+	respPayload := protocol.UploadFileResponsePayload{}
+	repoPath, ok := resolveRepo(payload.RepoPath)
+	fullPath := filepath.Join(repoPath, payload.FilePath)
+	if !ok {
+		respPayload.Success = false
+		respPayload.Output = "Error: unknown repository alias"
+	} else if fullPath == filepath.Clean(repoPath) {
+		respPayload.Success = false
+		respPayload.Output = "no file path given"
+	} else if _, err := sandboxPath(payload.RepoPath, repoPath, payload.FilePath); err != nil {
+		respPayload.Success = false
+		respPayload.Output = err.Error()
+	} else if err := receiveFile(stream, payload.RepoPath, repoPath, payload.FilePath, payload.Size); err != nil {
+		respPayload.Success = false
+		respPayload.Output = "Error receiving file: " + err.Error()
+	} else {
+		respPayload.Success = true
+		respPayload.Output = fmt.Sprintf("Uploaded %s (%d bytes)", payload.FilePath, payload.Size)
+	}
+
+	payloadBytes, _ := json.Marshal(respPayload)
+	response := &protocol.Message{Type: protocol.TypeUploadFileResponse, Payload: payloadBytes}
+	protocol.WriteMessage(stream, response)
+}
+
+// receiveFile reads size bytes of FILE_DATA chunks from the stream into
+// filePath of a repo, creating missing parent directories. The file is only
+// replaced once everything has arrived, so an interrupted upload leaves any
+// existing file untouched.
+func receiveFile(stream network.Stream, alias, repoPath, filePath string, size int64) error {
+	fullPath := filepath.Join(repoPath, filePath)
+	if info, err := os.Stat(fullPath); err == nil && info.IsDir() {
+		return fmt.Errorf("%s is a directory", filepath.Base(fullPath))
+	}
+	backupPrevious(alias, repoPath, filePath)
+	return writeAtomic(fullPath, func(f *os.File) error {
+		return receiveChunks(stream, protocol.TypeFileData, size, f)
+	})
+}
+
+// linkedRepo returns the path of the repo linked as alias.
+func linkedRepo(alias string) (string, bool) {
+	configMu.RLock()
+	defer configMu.RUnlock()
+	repoPath, ok := linkedRepos[alias]
+	return repoPath, ok
+}
+
+// getRepoAliases returns the aliases of the linked repos, sorted.
+func getRepoAliases() []string {
+	configMu.RLock()
+	defer configMu.RUnlock()
+	keys := make([]string, 0, len(linkedRepos))
+	for k := range linkedRepos {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// copyLinkedRepos returns the linked repos as they are now, alias to path.
+func copyLinkedRepos() map[string]string {
+	configMu.RLock()
+	defer configMu.RUnlock()
+	return maps.Clone(linkedRepos)
+}
+
+func linkedRepoCount() int {
+	configMu.RLock()
+	defer configMu.RUnlock()
+	return len(linkedRepos)
+}
+
+// NEW Function: Load repos from JSON file
+func loadLinkedRepos() {
+	var err error
+	if linkedRepos, err = readLinkedRepos(); err != nil {
+		fatal("Failed to load linked repos", "err", err)
+	}
+	if len(linkedRepos) == 0 {
+		slog.Info("No linked repos yet", "file", linkedReposFile)
+		return
+	}
+	slog.Info("Loaded linked repos", "count", len(linkedRepos), "file", linkedReposFile)
+}
+
+func readLinkedRepos() (map[string]string, error) {
+	repos := make(map[string]string)
+	data, err := os.ReadFile(statePath(linkedReposFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return repos, nil
 		}
-		log.Fatalf("Failed to read linked repos file: %v", err)
+		return nil, fmt.Errorf("failed to read %s: %w", linkedReposFile, err)
 	}
-	if err := json.Unmarshal(data, &linkedRepos); err != nil {
-		log.Fatalf("Failed to parse linked repos file: %v", err)
+	if err := json.Unmarshal(data, &repos); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", linkedReposFile, err)
 	}
-	log.Printf("Loaded %d linked repos from %s", len(linkedRepos), linkedReposFile)
+	return repos, nil
 }
 
-// NEW Function: Save repos to JSON file
+// NEW Function: Save repos to JSON file. The caller holds configMu.
 func saveLinkedRepos() error {
 	data, err := json.MarshalIndent(linkedRepos, "", "  ")
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(linkedReposFile, data, 0644)
+	// A crash halfway must not lose every link
+	return writeFileAtomic(statePath(linkedReposFile), data)
 }
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/metrics"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Metrics of the requests the daemon serves. They're registered with
+// Prometheus's default registry, like libp2p's own, and served by -metrics.
+var (
+	requestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "p2pgit_requests_total",
+		Help: "Requests served, by message type, peer and result (as in the audit log).",
+	}, []string{"type", "peer", "result"})
+	requestErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "p2pgit_request_errors_total",
+		Help: "Requests that failed or were refused, by message type.",
+	}, []string{"type"})
+	requestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "p2pgit_request_duration_seconds",
+		Help:    "How long requests took, by message type and class (read, write or git).",
+		Buckets: []float64{.005, .025, .1, .5, 1, 2.5, 5, 10, 30, 60, 300},
+	}, []string{"type", "class"})
+)
+
+// observeRequest records a request a trusted peer made.
+func observeRequest(p peer.ID, msgType, result string, took time.Duration) {
+	requestsTotal.WithLabelValues(msgType, p.String(), result).Inc()
+	if result != "ok" {
+		requestErrors.WithLabelValues(msgType).Inc()
+	}
+	requestDuration.WithLabelValues(msgType, requestClass(msgType)).Observe(took.Seconds())
+}
+
+// startMetrics serves Prometheus metrics on addr, meant to be a localhost
+// one: the daemon's, libp2p's and Go's, with the bytes the host transferred
+// and how many peers are connected and trusted.
+func startMetrics(addr string, h host.Host, bandwidth *metrics.BandwidthCounter) {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+			slog.Warn("The metrics endpoint is reachable from other machines; they show peer IDs", "addr", addr)
+		}
+	}
+	promauto.NewCounterFunc(prometheus.CounterOpts{
+		Name:        "p2pgit_bytes_total",
+		Help:        "Bytes transferred over libp2p, by direction.",
+		ConstLabels: prometheus.Labels{"direction": "in"},
+	}, func() float64 { return float64(bandwidth.GetBandwidthTotals().TotalIn) })
+	promauto.NewCounterFunc(prometheus.CounterOpts{
+		Name:        "p2pgit_bytes_total",
+		Help:        "Bytes transferred over libp2p, by direction.",
+		ConstLabels: prometheus.Labels{"direction": "out"},
+	}, func() float64 { return float64(bandwidth.GetBandwidthTotals().TotalOut) })
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "p2pgit_connected_peers",
+		Help: "Peers connected, DHT peers included.",
+	}, func() float64 { return float64(len(h.Network().Peers())) })
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "p2pgit_trusted_peers",
+		Help: "Peers whose approval hasn't expired.",
+	}, func() float64 {
+		var trusted int
+		for p := range trustStore.Peers() {
+			if trustStore.IsTrusted(p) {
+				trusted++
+			}
+		}
+		return float64(trusted)
+	})
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "p2pgit_active_streams",
+		Help: "Requests being served, long-lived ones like tail included.",
+	}, func() float64 { return float64(len(listStreams())) })
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	server := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Warn("No metrics endpoint", "err", err)
+		}
+	}()
+	slog.Info("Serving metrics", "url", "http://"+addr+"/metrics")
+}
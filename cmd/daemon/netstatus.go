@@ -0,0 +1,28 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/libp2p/go-libp2p/core/network"
+
+	p2p "github.com/hemantsingh443/p2p-git-remote/internal/p2p"
+	"github.com/hemantsingh443/p2p-git-remote/internal/protocol"
+)
+
+// netStatus follows how reachable the daemon is, as found by AutoNAT.
+var netStatus *p2p.NetStatus
+
+func handleNetStatus(stream network.Stream) {
+	p2pLog.Info("Handling NetStatus request")
+
+	respPayload := protocol.NetStatusResponsePayload{}
+	if netStatus == nil {
+		respPayload.Success = false
+		respPayload.Error = "the daemon doesn't follow its reachability"
+	} else {
+		respPayload = netStatus.Report()
+	}
+	payloadBytes, _ := json.Marshal(respPayload)
+	response := &protocol.Message{Type: protocol.TypeNetStatusResponse, Payload: payloadBytes}
+	protocol.WriteMessage(stream, response)
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+
+	p2p "github.com/hemantsingh443/p2p-git-remote/internal/p2p"
+	"github.com/hemantsingh443/p2p-git-remote/internal/store"
+)
+
+// Besides the prompt on the daemon's terminal, new clients can be approved
+// without anyone there, for daemons running headless under systemd: with a
+// one-time pairing token, during an auto-approve window, or from the admin
+// socket.
+
+// headless, set by -headless, makes pairings wait for p2p-gitctl instead of
+// prompting on the terminal.
+var headless bool
+
+// pairingTimeout is how long a headless pairing waits to be approved.
+var pairingTimeout = 5 * time.Minute
+
+const pairingTokensFile = "pairing_tokens.json"
+
+// pairingToken is a one-time token a client pairs with. Only its hash is
+// stored.
+type pairingToken struct {
+	Hash    string     `json:"hash"`
+	Role    store.Role `json:"role"`
+	Expires time.Time  `json:"expires"`
+}
+
+var tokensMu sync.Mutex
+
+// loadPairingTokens reads the tokens that haven't expired. The file is read
+// every time, so tokens created by `-pairing-token` reach a running daemon.
+func loadPairingTokens() ([]pairingToken, error) {
+	data, err := os.ReadFile(statePath(pairingTokensFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var all, tokens []pairingToken
+	if err := json.Unmarshal(data, &all); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", pairingTokensFile, err)
+	}
+	for _, t := range all {
+		if time.Now().Before(t.Expires) {
+			tokens = append(tokens, t)
+		}
+	}
+	return tokens, nil
+}
+
+func savePairingTokens(tokens []pairingToken) error {
+	data, err := json.MarshalIndent(tokens, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(statePath(pairingTokensFile), data)
+}
+
+func hashPairingToken(token string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(token))))
+	return hex.EncodeToString(sum[:])
+}
+
+// newPairingToken creates a token that pairs one client with the given role,
+// if used within ttl.
+func newPairingToken(role store.Role, ttl time.Duration) (string, error) {
+	secret := make([]byte, 15)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	token := strings.ToLower(base32.StdEncoding.EncodeToString(secret))
+
+	tokensMu.Lock()
+	defer tokensMu.Unlock()
+	tokens, err := loadPairingTokens()
+	if err != nil {
+		return "", err
+	}
+	tokens = append(tokens, pairingToken{Hash: hashPairingToken(token), Role: role, Expires: time.Now().Add(ttl)})
+	if err := savePairingTokens(tokens); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// redeemPairingToken uses a token up, and returns the role it grants.
+func redeemPairingToken(token string) (store.Role, error) {
+	tokensMu.Lock()
+	defer tokensMu.Unlock()
+	tokens, err := loadPairingTokens()
+	if err != nil {
+		return "", err
+	}
+	hash := []byte(hashPairingToken(token))
+	for i, t := range tokens {
+		if subtle.ConstantTimeCompare(hash, []byte(t.Hash)) == 1 {
+			if err := savePairingTokens(append(tokens[:i], tokens[i+1:]...)); err != nil {
+				return "", err
+			}
+			return t.Role, nil
+		}
+	}
+	return "", errors.New("unknown, used or expired pairing token")
+}
+
+// autoApproveWindow approves every new client until it closes, for setting
+// up several devices in a row.
+type autoApproveWindow struct {
+	mu    sync.Mutex
+	until time.Time
+	role  store.Role
+}
+
+var autoApprove = &autoApproveWindow{}
+
+// open approves new clients with role for d from now on, or closes the
+// window if d is 0. With -gate, it opens a pairing window too, so they can
+// connect.
+func (w *autoApproveWindow) open(d time.Duration, role store.Role) time.Time {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.until, w.role = time.Now().Add(d), role
+	if d <= 0 {
+		w.until = time.Time{}
+	}
+	if gater.enabled && d > 0 {
+		gater.openPairing(d)
+	}
+	return w.until
+}
+
+// active returns the role new clients get, if the window is open.
+func (w *autoApproveWindow) active() (store.Role, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.role, time.Now().Before(w.until)
+}
+
+// pendingPairing is a client waiting to be approved from the admin socket.
+type pendingPairing struct {
+	peer     peer.ID
+	code     string
+	since    time.Time
+	decision chan store.Role // Empty to reject
+}
+
+var (
+	pairingsMu      sync.Mutex
+	pendingPairings = make(map[peer.ID]*pendingPairing)
+)
+
+// awaitPairing parks a pairing until `p2p-gitctl approve` or `reject`
+// decides on it. It returns the role the client gets, if approved.
+func awaitPairing(stream network.Stream) (store.Role, bool) {
+	remotePeer := stream.Conn().RemotePeer()
+	p := &pendingPairing{
+		peer:     remotePeer,
+		code:     p2p.PairingCode(stream.Conn().LocalPeer(), remotePeer),
+		since:    time.Now(),
+		decision: make(chan store.Role, 1),
+	}
+	pairingsMu.Lock()
+	pendingPairings[remotePeer] = p
+	pairingsMu.Unlock()
+	defer func() {
+		pairingsMu.Lock()
+		if pendingPairings[remotePeer] == p {
+			delete(pendingPairings, remotePeer)
+		}
+		pairingsMu.Unlock()
+	}()
+
+	trustLog.Info("Pairing waits for approval: run 'p2p-gitctl pending'", "peer", remotePeer)
+	select {
+	case role := <-p.decision:
+		return role, role != ""
+	case <-time.After(pairingTimeout):
+		trustLog.Info("Pairing was not approved in time", "peer", remotePeer, "after", pairingTimeout)
+		return "", false
+	}
+}
+
+// listPendingPairings returns the clients waiting to be approved, oldest
+// first.
+func listPendingPairings() []*pendingPairing {
+	pairingsMu.Lock()
+	defer pairingsMu.Unlock()
+	pending := make([]*pendingPairing, 0, len(pendingPairings))
+	for _, p := range pendingPairings {
+		pending = append(pending, p)
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].since.Before(pending[j].since) })
+	return pending
+}
+
+// decidePairing approves the pending client showing the given pairing code,
+// which proves the admin is looking at that device, or rejects the client
+// whose peer ID starts with it if role is empty.
+func decidePairing(key string, role store.Role) (peer.ID, error) {
+	pairingsMu.Lock()
+	defer pairingsMu.Unlock()
+	var match *pendingPairing
+	for _, p := range pendingPairings {
+		var matches bool
+		if role != "" {
+			matches = p2p.SamePairingCode(key, p.code)
+		} else {
+			matches = strings.HasPrefix(p.peer.String(), key)
+		}
+		if !matches {
+			continue
+		}
+		if match != nil {
+			return "", fmt.Errorf("'%s' matches several pending clients", key)
+		}
+		match = p
+	}
+	if match == nil {
+		if role != "" {
+			return "", fmt.Errorf("no pending client shows the pairing code '%s'", key)
+		}
+		return "", fmt.Errorf("no pending client '%s'", key)
+	}
+	delete(pendingPairings, match.peer)
+	match.decision <- role
+	return match.peer, nil
+}
+
+// printPairingToken is -pairing-token: it creates a token a running daemon
+// accepts too, since they share the tokens file.
+func printPairingToken(roleName string, ttl time.Duration) {
+	role, err := store.ParseRole(roleName)
+	if err != nil {
+		fatal("Invalid -pairing-token role", "err", err)
+	}
+	token, err := newPairingToken(role, ttl)
+	if err != nil {
+		fatal("Failed to create pairing token", "err", err)
+	}
+	fmt.Println(token)
+	fmt.Fprintf(os.Stderr, "Pairs one %s client until %s. On the client, set P2P_GIT_PAIRING_TOKEN to it before connecting.\n", role, time.Now().Add(ttl).Format("2006-01-02 15:04"))
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hemantsingh443/p2p-git-remote/internal/store"
+)
+
+// useStateDir points the daemon's files at a fresh directory for a test.
+func useStateDir(t *testing.T) {
+	old := stateDir
+	stateDir = t.TempDir()
+	t.Cleanup(func() { stateDir = old })
+}
+
+func TestPairingTokenWorksOnce(t *testing.T) {
+	useStateDir(t)
+	token, err := newPairingToken(store.RoleAdmin, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if role, err := redeemPairingToken(token); err != nil || role != store.RoleAdmin {
+		t.Fatalf("first use: got %q, %v; want admin", role, err)
+	}
+	if _, err := redeemPairingToken(token); err == nil {
+		t.Fatal("a token worked twice")
+	}
+}
+
+func TestPairingTokenExpires(t *testing.T) {
+	useStateDir(t)
+	token, err := newPairingToken(store.RoleReadWrite, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if _, err := redeemPairingToken(token); err == nil {
+		t.Fatal("an expired token worked")
+	}
+}
+
+func TestPairingTokenAsTyped(t *testing.T) {
+	for _, typed := range []func(string) string{
+		strings.ToUpper,
+		func(token string) string { return "  " + token + "\n" },
+	} {
+		useStateDir(t)
+		token, err := newPairingToken(store.RoleReadOnly, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := redeemPairingToken(typed(token)); err != nil {
+			t.Errorf("%q for %q: %v", typed(token), token, err)
+		}
+	}
+	useStateDir(t)
+	if _, err := redeemPairingToken(""); err == nil {
+		t.Error("an empty token worked")
+	}
+}
+
+func TestRedeemingLeavesOtherTokens(t *testing.T) {
+	useStateDir(t)
+	roles := []store.Role{store.RoleReadOnly, store.RoleReadWrite, store.RoleAdmin}
+	tokens := make([]string, len(roles))
+	for i, role := range roles {
+		var err error
+		if tokens[i], err = newPairingToken(role, time.Hour); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Out of order, so the one removed is neither first nor last each time
+	for _, i := range []int{1, 2, 0} {
+		if role, err := redeemPairingToken(tokens[i]); err != nil || role != roles[i] {
+			t.Fatalf("token %d: got %q, %v; want %s", i, role, err, roles[i])
+		}
+	}
+}
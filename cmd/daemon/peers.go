@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+
+	"github.com/hemantsingh443/p2p-git-remote/internal/protocol"
+	"github.com/hemantsingh443/p2p-git-remote/internal/store"
+)
+
+const trustedPeersFile = "trusted_peers.json"
+
+// daemonHost is the running daemon's host, so revoking a peer can also drop
+// its connections. It is nil when just running a peerCommand.
+var daemonHost host.Host
+
+// peerCommand is what the -peers, -revoke, -extend, -pin and -unpin flags
+// ask for.
+type peerCommand struct {
+	list                       bool
+	revoke, extend, pin, unpin string
+}
+
+func (c peerCommand) any() bool {
+	return c.list || c.revoke != "" || c.extend != "" || c.pin != "" || c.unpin != ""
+}
+
+// managePeers runs a peerCommand against the trust store and exits. A
+// running daemon picks up the change with its next request.
+func managePeers(cmd peerCommand, ttl time.Duration) {
+	var err error
+	trustStore, err = store.NewTrustStore(statePath(trustedPeersFile))
+	if err != nil {
+		fatal("Failed to initialize trust store", "err", err)
+	}
+	trustStore.SetDefaultTTL(ttl)
+
+	if cmd.revoke != "" {
+		p, err := revokePeer(cmd.revoke)
+		if err != nil {
+			fatal("Failed to revoke peer", "err", err)
+		}
+		fmt.Printf("Revoked peer %s. It has to pair again to reconnect.\n", p)
+	}
+	changes := []struct {
+		id     string
+		change func(peer.ID) error
+		done   string
+	}{
+		{cmd.extend, trustStore.ExtendTrust, "Renewed the approval of"},
+		{cmd.pin, func(p peer.ID) error { return trustStore.PinPeer(p, true) }, "Pinned"},
+		{cmd.unpin, func(p peer.ID) error { return trustStore.PinPeer(p, false) }, "Unpinned"},
+	}
+	for _, c := range changes {
+		if c.id == "" {
+			continue
+		}
+		p, err := trustStore.FindPeer(c.id)
+		if err == nil {
+			err = c.change(p)
+		}
+		if err != nil {
+			fatal("Failed to update peer", "err", err)
+		}
+		fmt.Printf("%s peer %s.\n", c.done, p)
+	}
+
+	if cmd.list {
+		peers := listPeers("")
+		if len(peers) == 0 {
+			fmt.Println("No trusted peers.")
+			return
+		}
+		for _, p := range peers {
+			verified := ""
+			if p.Verified {
+				verified = ", verified"
+			}
+			fmt.Printf("%-10s  %s  %s%s\n", p.Role, p.ID, describeExpiry(p), verified)
+		}
+	}
+}
+
+// describeExpiry says when a peer's approval expires.
+func describeExpiry(p protocol.PeerInfo) string {
+	switch {
+	case p.Expired:
+		return "expired " + p.ExpiresAt.Format("2006-01-02 15:04")
+	case p.ExpiresAt.IsZero():
+		return "never expires"
+	}
+	return "expires " + p.ExpiresAt.Format("2006-01-02 15:04")
+}
+
+// listPeers returns the trusted peers sorted by ID. self is the peer asking,
+// if any.
+func listPeers(self peer.ID) []protocol.PeerInfo {
+	var peers []protocol.PeerInfo
+	for p, entry := range trustStore.Peers() {
+		info := protocol.PeerInfo{
+			ID:        p.String(),
+			Role:      string(entry.Role),
+			ExpiresAt: trustStore.ExpiresAt(entry),
+			Expired:   trustStore.Expired(entry),
+			Verified:  entry.Verified,
+			Self:      p == self,
+		}
+		if daemonHost != nil {
+			info.Connected = daemonHost.Network().Connectedness(p) == network.Connected
+		}
+		peers = append(peers, info)
+	}
+	sort.Slice(peers, func(i, j int) bool { return peers[i].ID < peers[j].ID })
+	return peers
+}
+
+// revokePeer removes the peer matching id from the trust store.
+func revokePeer(id string) (peer.ID, error) {
+	p, err := trustStore.FindPeer(id)
+	if err != nil {
+		return "", err
+	}
+	if err := trustStore.RemoveTrustedPeer(p); err != nil {
+		return "", err
+	}
+	trustLog.Info("Revoked trust in peer", "peer", p)
+	unprotectPeer(p)
+	return p, nil
+}
+
+func handleListPeers(stream network.Stream) {
+	trustLog.Info("Handling ListPeers request")
+	respPayload := protocol.ListPeersResponsePayload{
+		Success: true,
+		Peers:   listPeers(stream.Conn().RemotePeer()),
+	}
+	payloadBytes, _ := json.Marshal(respPayload)
+	response := &protocol.Message{Type: protocol.TypeListPeersResponse, Payload: payloadBytes}
+	if err := protocol.WriteMessage(stream, response); err != nil {
+		trustLog.Error("Failed to send peer list", "err", err)
+	}
+}
+
+func handleRevokePeer(stream network.Stream, rawPayload json.RawMessage) {
+	var payload protocol.RevokePeerRequestPayload
+	json.Unmarshal(rawPayload, &payload)
+	trustLog.Info("Handling RevokePeer request", "peer", payload.PeerID)
+
+	respPayload := protocol.RevokePeerResponsePayload{}
+	p, err := revokePeer(payload.PeerID)
+	if err != nil {
+		respPayload.Success = false
+		respPayload.Output = err.Error()
+	} else {
+		respPayload.Success = true
+		respPayload.Output = fmt.Sprintf("Revoked peer %s. It has to pair again to reconnect.", p)
+	}
+	payloadBytes, _ := json.Marshal(respPayload)
+	response := &protocol.Message{Type: protocol.TypeRevokePeerResponse, Payload: payloadBytes}
+	protocol.WriteMessage(stream, response)
+
+	// Drop the revoked peer's connections only once it's answered, as the
+	// peer may have revoked itself
+	if err == nil && daemonHost != nil {
+		daemonHost.Network().ClosePeer(p)
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/libp2p/go-libp2p/core/network"
+
+	"github.com/hemantsingh443/p2p-git-remote/internal/protocol"
+)
+
+// handlePing answers a ping, then every further ping on the stream until the
+// client closes it, so a client measuring latency over time takes one stream
+// (and one audit entry) rather than one per ping.
+func handlePing(stream network.Stream, rawPayload json.RawMessage) {
+	p2pLog.Info("Handling Ping request")
+	for {
+		var payload protocol.PingRequestPayload
+		json.Unmarshal(rawPayload, &payload)
+		respPayload := protocol.PingResponsePayload{Success: true, Seq: payload.Seq}
+		payloadBytes, _ := json.Marshal(respPayload)
+		response := &protocol.Message{Type: protocol.TypePingResponse, Payload: payloadBytes}
+		if err := protocol.WriteMessage(stream, response); err != nil {
+			return
+		}
+
+		msg, err := protocol.ReadMessage(stream)
+		if err != nil || msg.Type != protocol.TypePingRequest {
+			return
+		}
+		rawPayload = msg.Payload
+	}
+}
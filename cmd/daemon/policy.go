@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+const policyFile = "policy.json"
+
+// Policy restricts which requests peers may send, on top of their roles. A
+// request goes through only if every rule that applies to it allows it:
+//
+//	{"rules": [
+//	  {"deny": ["GIT_RESET_REQUEST"]},
+//	  {"hours": "22:00-07:00", "allow": ["read"]},
+//	  {"peers": ["12D3KooWabc"], "deny": ["git", "GIT_CLEAN_REQUEST"]}
+//	]}
+type Policy struct {
+	Rules []PolicyRule `json:"rules"`
+}
+
+// PolicyRule allows only the requests in Allow, or forbids those in Deny.
+// Both list message types or the classes read, write and git (see
+// requestClass); * means every request.
+type PolicyRule struct {
+	Peers []string `json:"peers,omitempty"` // IDs or prefixes of them; empty means every peer
+	Hours string   `json:"hours,omitempty"` // Local time like 22:00-07:00; empty means always
+	Allow []string `json:"allow,omitempty"`
+	Deny  []string `json:"deny,omitempty"`
+}
+
+var policy Policy
+
+// currentPolicy returns the policy in force, which a reload may replace.
+func currentPolicy() Policy {
+	configMu.RLock()
+	defer configMu.RUnlock()
+	return policy
+}
+
+func loadPolicy() {
+	var err error
+	if policy, err = readPolicy(); err != nil {
+		fatal("Failed to load policy", "err", err)
+	}
+	trustLog.Info("Loaded policy rules", "count", len(policy.Rules), "file", policyFile)
+}
+
+// readPolicy reads and checks policy.json; without one, every request a
+// peer's role allows goes through.
+func readPolicy() (Policy, error) {
+	var p Policy
+	data, err := os.ReadFile(statePath(policyFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return p, nil
+		}
+		return p, fmt.Errorf("failed to read %s: %w", policyFile, err)
+	}
+	if err := json.Unmarshal(data, &p); err != nil {
+		return p, fmt.Errorf("failed to parse %s: %w", policyFile, err)
+	}
+	for i, rule := range p.Rules {
+		if err := rule.validate(); err != nil {
+			return p, fmt.Errorf("invalid rule %d in %s: %w", i+1, policyFile, err)
+		}
+	}
+	return p, nil
+}
+
+func (r PolicyRule) validate() error {
+	if (len(r.Allow) == 0) == (len(r.Deny) == 0) {
+		return fmt.Errorf("a rule needs either allow or deny")
+	}
+	if r.Hours != "" {
+		if _, _, err := parseHours(r.Hours); err != nil {
+			return err
+		}
+	}
+	for _, name := range append(r.Allow, r.Deny...) {
+		switch {
+		case name == "*", name == classRead, name == classWrite, name == classGit:
+		case strings.HasSuffix(name, "_REQUEST"):
+		default:
+			return fmt.Errorf("'%s' is neither a request type nor read, write, git or *", name)
+		}
+	}
+	return nil
+}
+
+// check returns why the policy forbids a peer's request at time t, or ""
+// if it doesn't.
+func (p Policy) check(remotePeer peer.ID, msgType string, t time.Time) string {
+	for _, rule := range p.Rules {
+		if !rule.appliesTo(remotePeer, t) {
+			continue
+		}
+		listed := ruleLists(rule.Allow, msgType)
+		if rule.Deny != nil {
+			listed = ruleLists(rule.Deny, msgType)
+		}
+		if listed == (rule.Deny != nil) {
+			when := ""
+			if rule.Hours != "" {
+				when = " between " + rule.Hours
+			}
+			return fmt.Sprintf("the daemon's policy forbids %s%s", msgType, when)
+		}
+	}
+	return ""
+}
+
+func (r PolicyRule) appliesTo(remotePeer peer.ID, t time.Time) bool {
+	if len(r.Peers) > 0 {
+		found := false
+		for _, id := range r.Peers {
+			found = found || strings.HasPrefix(remotePeer.String(), id)
+		}
+		if !found {
+			return false
+		}
+	}
+	if r.Hours != "" {
+		from, to, _ := parseHours(r.Hours)
+		now := t.Hour()*60 + t.Minute()
+		if from <= to {
+			return now >= from && now < to
+		}
+		return now >= from || now < to // Over midnight
+	}
+	return true
+}
+
+// ruleLists reports whether a rule's list names a request.
+func ruleLists(names []string, msgType string) bool {
+	for _, name := range names {
+		if name == "*" || name == msgType || name == requestClass(msgType) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseHours parses a range like 22:00-07:00 into minutes since midnight.
+func parseHours(hours string) (int, int, error) {
+	fromStr, toStr, ok := strings.Cut(hours, "-")
+	from, err1 := time.Parse("15:04", strings.TrimSpace(fromStr))
+	to, err2 := time.Parse("15:04", strings.TrimSpace(toStr))
+	if !ok || err1 != nil || err2 != nil {
+		return 0, 0, fmt.Errorf("hours '%s' should look like 22:00-07:00", hours)
+	}
+	return from.Hour()*60 + from.Minute(), to.Hour()*60 + to.Minute(), nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+
+	"github.com/hemantsingh443/p2p-git-remote/internal/protocol"
+)
+
+// Classes of requests, each with its own rate limit.
+const (
+	classRead  = "read"  // Anything a read-only peer may send
+	classWrite = "write" // Changes to files
+	classGit   = "git"   // Everything else: commits, pushes, resets, stashes...
+)
+
+// fileWriteRequests change files without running git operations on them.
+var fileWriteRequests = map[string]bool{
+	protocol.TypeWriteFileRequest:  true,
+	protocol.TypeApplyPatchRequest: true,
+	protocol.TypeBatchRequest:      true,
+	protocol.TypeUploadFileRequest: true,
+	protocol.TypeCreateFileRequest: true,
+	protocol.TypeMkdirRequest:      true,
+	protocol.TypeRenameFileRequest: true,
+	protocol.TypeDeletePathRequest: true,
+	protocol.TypeChmodRequest:      true,
+	protocol.TypeIgnoreRequest:     true,
+	protocol.TypeUnignoreRequest:   true,
+}
+
+// requestClass returns which rate limit applies to a request.
+func requestClass(msgType string) string {
+	switch {
+	case readOnlyRequests[msgType]:
+		return classRead
+	case fileWriteRequests[msgType]:
+		return classWrite
+	}
+	return classGit
+}
+
+// rateLimit allows up to count requests per period, as a token bucket of
+// count tokens refilled over period. A zero count means no limit.
+type rateLimit struct {
+	count  int
+	period time.Duration
+}
+
+// String and Set make rateLimit a flag.Value, written like 600/m.
+func (r *rateLimit) String() string {
+	if r.count == 0 {
+		return "0"
+	}
+	unit := map[time.Duration]string{time.Second: "s", time.Minute: "m", time.Hour: "h"}[r.period]
+	return fmt.Sprintf("%d/%s", r.count, unit)
+}
+
+func (r *rateLimit) Set(s string) error {
+	if s == "0" {
+		*r = rateLimit{}
+		return nil
+	}
+	countStr, unit, _ := strings.Cut(s, "/")
+	count, err := strconv.Atoi(countStr)
+	period, ok := map[string]time.Duration{"s": time.Second, "m": time.Minute, "h": time.Hour}[unit]
+	if err != nil || count < 0 || !ok {
+		return fmt.Errorf("expected a limit like 600/m (per s, m or h), or 0 for none")
+	}
+	*r = rateLimit{count: count, period: period}
+	return nil
+}
+
+// rateLimits are the limits of each class, set by the -rate-* flags.
+var rateLimits = map[string]*rateLimit{
+	classRead:  {count: 600, period: time.Minute},
+	classWrite: {count: 120, period: time.Minute},
+	classGit:   {count: 60, period: time.Minute},
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+var (
+	buckets      = make(map[peer.ID]map[string]*bucket)
+	bucketsMutex sync.Mutex
+)
+
+// takeToken spends one of the peer's tokens for a class of request. If none
+// is left, it returns how long until there is one.
+func takeToken(p peer.ID, class string) (bool, time.Duration) {
+	limit := rateLimits[class]
+	if limit == nil || limit.count == 0 {
+		return true, 0
+	}
+	perToken := limit.period / time.Duration(limit.count)
+
+	bucketsMutex.Lock()
+	defer bucketsMutex.Unlock()
+	if buckets[p] == nil {
+		buckets[p] = make(map[string]*bucket)
+	}
+	now := time.Now()
+	b := buckets[p][class]
+	if b == nil {
+		b = &bucket{tokens: float64(limit.count), last: now}
+		buckets[p][class] = b
+	}
+	b.tokens = math.Min(float64(limit.count), b.tokens+float64(now.Sub(b.last))/float64(perToken))
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) * float64(perToken))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// refuseRateLimited answers a request the peer sent too many of.
+func refuseRateLimited(stream network.Stream, msgType, class string, retryAfter time.Duration) {
+	limit := rateLimits[class]
+	payload := protocol.RateLimitedPayload{
+		Request:      msgType,
+		Class:        class,
+		RetryAfterMs: retryAfter.Milliseconds() + 1,
+		Error:        fmt.Sprintf("too many %s requests (limit %s); try again in %s", class, limit, retryAfter.Round(time.Millisecond*100)),
+	}
+	payloadBytes, _ := json.Marshal(payload)
+	protocol.WriteMessage(stream, &protocol.Message{Type: protocol.TypeRateLimited, Payload: payloadBytes})
+}
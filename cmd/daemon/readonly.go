@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"sync/atomic"
+
+	"github.com/libp2p/go-libp2p/core/network"
+
+	"github.com/hemantsingh443/p2p-git-remote/internal/protocol"
+)
+
+// readOnlyMode refuses every request that changes a repo or the daemon, so
+// repos can be exposed for review only. Set by -read-only, and toggled by
+// admins at runtime.
+var readOnlyMode atomic.Bool
+
+// readOnlyModeRequests are allowed in read-only mode besides readOnlyRequests:
+// they only look at the daemon or a repo's settings, or turn the mode off
+// again.
+var readOnlyModeRequests = map[string]bool{
+	protocol.TypeReadOnlyRequest:     true,
+	protocol.TypeListPeersRequest:    true,
+	protocol.TypeAuditLogRequest:     true,
+	protocol.TypeGitConfigGetRequest: true,
+}
+
+// refusedInReadOnlyMode reports whether read-only mode forbids a request
+// right now.
+func refusedInReadOnlyMode(msgType string) bool {
+	return readOnlyMode.Load() && !readOnlyRequests[msgType] && !readOnlyModeRequests[msgType]
+}
+
+func handleReadOnly(stream network.Stream, rawPayload json.RawMessage) {
+	var payload protocol.ReadOnlyRequestPayload
+	json.Unmarshal(rawPayload, &payload)
+	if payload.Enabled != nil {
+		readOnlyMode.Store(*payload.Enabled)
+		slog.Info("Read-only mode changed", "mode", onOff(*payload.Enabled), "peer", stream.Conn().RemotePeer())
+	} else {
+		slog.Info("Handling ReadOnly request")
+	}
+
+	respPayload := protocol.ReadOnlyResponsePayload{Success: true, ReadOnly: readOnlyMode.Load()}
+	payloadBytes, _ := json.Marshal(respPayload)
+	response := &protocol.Message{Type: protocol.TypeReadOnlyResponse, Payload: payloadBytes}
+	protocol.WriteMessage(stream, response)
+}
+
+func onOff(on bool) string {
+	if on {
+		return "on"
+	}
+	return "off"
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/hemantsingh443/p2p-git-remote/internal/systemd"
+)
+
+// reloadOnHangup reloads the daemon's files whenever it gets SIGHUP.
+func reloadOnHangup() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			systemd.Notify("RELOADING=1")
+			if summary, err := reloadConfig(); err != nil {
+				slog.Error("Reload failed, keeping the current settings", "err", err)
+			} else {
+				slog.Info("Reloaded on SIGHUP: " + summary)
+			}
+			systemd.Notify("READY=1")
+		}
+	}()
+}
+
+// reloadConfig reads linked_repos.json, repo_config.json, policy.json and
+// webhooks.json again, after they were edited by hand. Either every file is
+// valid and taken, or nothing changes. Connections and requests in flight
+// go on; trusted peers and the blocklist are reread on their own.
+func reloadConfig() (string, error) {
+	repos, err := readLinkedRepos()
+	if err != nil {
+		return "", err
+	}
+	configs, err := readRepoConfigs()
+	if err != nil {
+		return "", err
+	}
+	newPolicy, err := readPolicy()
+	if err != nil {
+		return "", err
+	}
+	hooks, err := readWebhooks()
+	if err != nil {
+		return "", err
+	}
+
+	// The watcher is told after, outside the lock, the repos that changed
+	gone, fresh := make(map[string]string), make(map[string]string)
+	linked, settings := len(repos), len(configs)
+	configMu.Lock()
+	for alias, repoPath := range linkedRepos {
+		if repos[alias] != repoPath {
+			gone[alias] = repoPath
+		}
+	}
+	for alias, repoPath := range repos {
+		if linkedRepos[alias] != repoPath {
+			fresh[alias] = repoPath
+		}
+	}
+	linkedRepos = repos
+	repoConfigs = configs
+	policy = newPolicy
+	webhooks = hooks
+	configMu.Unlock()
+
+	for _, repoPath := range gone {
+		watcher.removeRepo(repoPath)
+	}
+	for alias, repoPath := range fresh {
+		watcher.addRepo(alias, repoPath)
+	}
+
+	return fmt.Sprintf("%d linked repos (%d added, %d removed), settings of %d repos, %d policy rules and %d webhooks",
+		linked, len(fresh), len(gone), settings, len(newPolicy.Rules), len(hooks.Hooks)), nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/libp2p/go-libp2p/core/network"
+
+	"github.com/hemantsingh443/p2p-git-remote/internal/protocol"
+)
+
+// renameAlias renames a linked repo, moving its settings along, and writes
+// both files.
+func renameAlias(alias, newAlias string) error {
+	configMu.Lock()
+	err := renameLinkedRepo(alias, newAlias)
+	configMu.Unlock()
+	if err != nil {
+		return err
+	}
+	watcher.renameRepo(alias, newAlias)
+	slog.Info("Renamed repository", "alias", alias, "new_alias", newAlias)
+	return nil
+}
+
+// renameLinkedRepo is renameAlias's part under configMu, so no other request
+// can take the new alias in between.
+func renameLinkedRepo(alias, newAlias string) error {
+	repoPath, ok := linkedRepos[alias]
+	switch {
+	case !ok:
+		return fmt.Errorf("unknown repository alias '%s'", alias)
+	case newAlias == "" || strings.Contains(newAlias, "/"):
+		// "/" is reserved for addressing worktrees as <alias>/<name>
+		return fmt.Errorf("invalid alias: must be non-empty and must not contain '/'")
+	case newAlias == alias:
+		return fmt.Errorf("the repo is already called '%s'", alias)
+	}
+	if _, taken := linkedRepos[newAlias]; taken {
+		return fmt.Errorf("'%s' is already linked", newAlias)
+	}
+
+	delete(linkedRepos, alias)
+	linkedRepos[newAlias] = repoPath
+	if err := saveLinkedRepos(); err != nil {
+		// The file is as it was, so the map should be too
+		delete(linkedRepos, newAlias)
+		linkedRepos[alias] = repoPath
+		return fmt.Errorf("failed to save repo list: %w", err)
+	}
+	if cfg, ok := repoConfigs[alias]; ok {
+		delete(repoConfigs, alias)
+		repoConfigs[newAlias] = cfg
+		if err := saveRepoConfigs(); err != nil {
+			slog.Warn("Failed to move repo settings", "alias", alias, "new_alias", newAlias, "err", err)
+		}
+	}
+	return nil
+}
+
+func handleRenameAlias(stream network.Stream, rawPayload json.RawMessage) {
+	var payload protocol.RenameAliasRequestPayload
+	json.Unmarshal(rawPayload, &payload)
+	slog.Info("Handling RenameAlias request", "alias", payload.Alias, "new_alias", payload.NewAlias)
+
+	respPayload := protocol.RenameAliasResponsePayload{}
+	if err := renameAlias(payload.Alias, payload.NewAlias); err != nil {
+		respPayload.Success = false
+		respPayload.Error = err.Error()
+	} else {
+		respPayload.Success = true
+	}
+	payloadBytes, _ := json.Marshal(respPayload)
+	response := &protocol.Message{Type: protocol.TypeRenameAliasResponse, Payload: payloadBytes}
+	protocol.WriteMessage(stream, response)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/hemantsingh443/p2p-git-remote/internal/sandbox"
+)
+
+// RepoConfig holds optional per-repository daemon settings, keyed by alias in
+// repo_config.json. The file is edited by the daemon owner.
+type RepoConfig struct {
+	// Commit signing. SigningFormat is "openpgp" (default), "ssh" or "x509".
+	SigningKey    string `json:"signing_key,omitempty"`
+	SigningFormat string `json:"signing_format,omitempty"`
+
+	// HookPolicy is "warn" (default), "block" or "skip"; see git.HookPolicy.
+	HookPolicy string `json:"hook_policy,omitempty"`
+
+	// CommitTemplate pre-fills commit messages in the REPL and TUI, e.g.
+	// "[{ticket}] feat: ". {branch} and {ticket} (an ID like PROJ-123 taken
+	// from the branch name) are filled in; git's commit.template is used
+	// when empty.
+	CommitTemplate string `json:"commit_template,omitempty"`
+
+	// Remote is where commits are pushed unless a request names another
+	// remote; "origin" when empty. Clients can change it over the protocol.
+	Remote string `json:"remote,omitempty"`
+
+	// WriteBackups is how many previous versions of each file overwritten by
+	// a client are kept in the repo's git directory; none when 0.
+	WriteBackups int `json:"write_backups,omitempty"`
+
+	// Exclude lists paths peers can't read, write or list, like ".env",
+	// "*.pem" or "config/secrets"; see sandbox.New. Git's internals are
+	// always excluded.
+	Exclude []string `json:"exclude,omitempty"`
+
+	// ScanSecrets checks staged changes for credentials before committing,
+	// as -scan-secrets does for every repo. Clients can override it.
+	ScanSecrets bool `json:"scan_secrets,omitempty"`
+
+	// SSHCommand is how git runs ssh to push, e.g. to use a deploy key:
+	// "ssh -i ~/.ssh/deploy_key -o IdentitiesOnly=yes". Git's core.sshCommand
+	// or plain ssh when empty.
+	SSHCommand string `json:"ssh_command,omitempty"`
+}
+
+var repoConfigs map[string]*RepoConfig // Alias -> Config
+
+const repoConfigFile = "repo_config.json"
+
+// pushRemote returns the remote commits for a repo alias are pushed to by
+// default.
+func pushRemote(alias string) string {
+	if remote := getRepoConfig(alias).Remote; remote != "" {
+		return remote
+	}
+	return "origin"
+}
+
+// getRepoConfig returns the config for a repo alias, or an empty one.
+// Worktrees ("<alias>/<name>") share the config of their main repo. It is
+// shared, not to be changed; updateRepoConfig replaces it.
+func getRepoConfig(alias string) *RepoConfig {
+	alias, _, _ = strings.Cut(alias, "/")
+	configMu.RLock()
+	defer configMu.RUnlock()
+	if cfg, ok := repoConfigs[alias]; ok && cfg != nil {
+		return cfg
+	}
+	return &RepoConfig{}
+}
+
+// repoSandbox confines file access to a repo and the paths its settings
+// don't exclude.
+func repoSandbox(alias, repoPath string) *sandbox.Sandbox {
+	return sandbox.New(repoPath, getRepoConfig(alias).Exclude)
+}
+
+// sandboxPath returns where a path a peer sent is in a repo, or why the
+// peer can't have it.
+func sandboxPath(alias, repoPath, rel string) (string, error) {
+	return repoSandbox(alias, repoPath).Path(rel)
+}
+
+func loadRepoConfigs() {
+	var err error
+	if repoConfigs, err = readRepoConfigs(); err != nil {
+		fatal("Failed to load repo settings", "err", err)
+	}
+	slog.Info("Loaded repo settings", "count", len(repoConfigs), "file", repoConfigFile)
+}
+
+func readRepoConfigs() (map[string]*RepoConfig, error) {
+	configs := make(map[string]*RepoConfig)
+	data, err := os.ReadFile(statePath(repoConfigFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return configs, nil
+		}
+		return nil, fmt.Errorf("failed to read %s: %w", repoConfigFile, err)
+	}
+	if err := json.Unmarshal(data, &configs); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", repoConfigFile, err)
+	}
+	return configs, nil
+}
+
+// updateRepoConfig changes the config of a repo alias (the main repo's, for
+// a worktree) and writes repo_config.json.
+func updateRepoConfig(alias string, change func(cfg *RepoConfig)) error {
+	alias, _, _ = strings.Cut(alias, "/")
+	configMu.Lock()
+	defer configMu.Unlock()
+	var cfg RepoConfig
+	if old := repoConfigs[alias]; old != nil {
+		cfg = *old
+	}
+	change(&cfg)
+	repoConfigs[alias] = &cfg
+	return saveRepoConfigs()
+}
+
+// saveRepoConfigs writes repo_config.json. The caller holds configMu.
+func saveRepoConfigs() error {
+	data, err := json.MarshalIndent(repoConfigs, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(statePath(repoConfigFile), data)
+}
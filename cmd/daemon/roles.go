@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/libp2p/go-libp2p/core/network"
+
+	"github.com/hemantsingh443/p2p-git-remote/internal/protocol"
+	"github.com/hemantsingh443/p2p-git-remote/internal/store"
+)
+
+// readOnlyRequests only look at repos, so any trusted peer may send them.
+// Reading git config isn't one: remote URLs can hold credentials.
+var readOnlyRequests = map[string]bool{
+	protocol.TypeSessionRequest:           true,
+	protocol.TypeListReposRequest:         true,
+	protocol.TypeReadFileRequest:          true,
+	protocol.TypeListFilesRequest:         true,
+	protocol.TypeListDirRequest:           true,
+	protocol.TypeListBranchesRequest:      true,
+	protocol.TypeGitStatusRequest:         true,
+	protocol.TypeGitLogRequest:            true,
+	protocol.TypeFileLogRequest:           true,
+	protocol.TypeGitDiffRequest:           true,
+	protocol.TypeGitStashListRequest:      true,
+	protocol.TypeGrepRequest:              true,
+	protocol.TypeTailRequest:              true,
+	protocol.TypeListHunksRequest:         true,
+	protocol.TypeReflogRequest:            true,
+	protocol.TypeListWorktreesRequest:     true,
+	protocol.TypeConflictsListRequest:     true,
+	protocol.TypeGetCommitTemplateRequest: true,
+	protocol.TypeRepoStatsRequest:         true,
+	protocol.TypeBundleCreateRequest:      true,
+	protocol.TypeSubscribeEventsRequest:   true,
+	protocol.TypeDownloadFileRequest:      true,
+	protocol.TypeListRemotesRequest:       true,
+	protocol.TypeNetStatusRequest:         true,
+	protocol.TypePingRequest:              true,
+	protocol.TypeDaemonStatusRequest:      true,
+	protocol.TypeJobsListRequest:          true,
+	protocol.TypeJobStatusRequest:         true,
+}
+
+// adminRequests change how the daemon itself works rather than a repo's
+// content: which directories it exposes, where it pushes, git settings that
+// can run commands, who it trusts and what they did.
+var adminRequests = map[string]bool{
+	protocol.TypeLinkRepoRequest:         true,
+	protocol.TypeSetDefaultRemoteRequest: true,
+	protocol.TypeGitConfigSetRequest:     true,
+	protocol.TypeListPeersRequest:        true,
+	protocol.TypeRevokePeerRequest:       true,
+	protocol.TypeAuditLogRequest:         true,
+	protocol.TypeReadOnlyRequest:         true,
+	protocol.TypePairingWindowRequest:    true,
+	protocol.TypeBlockRequest:            true,
+	protocol.TypeApprovalsRequest:        true,
+	protocol.TypeRenameAliasRequest:      true,
+}
+
+// requiredRole returns the role a peer needs to send a request. Anything not
+// listed as read-only or admin changes a repo, and needs read-write.
+func requiredRole(msgType string) store.Role {
+	switch {
+	case readOnlyRequests[msgType]:
+		return store.RoleReadOnly
+	case adminRequests[msgType]:
+		return store.RoleAdmin
+	}
+	return store.RoleReadWrite
+}
+
+// denyRequest answers a request the peer's role or the policy doesn't allow.
+func denyRequest(stream network.Stream, msgType string, role store.Role, reason string) {
+	payload := protocol.AccessDeniedPayload{
+		Request: msgType,
+		Role:    string(role),
+		Error:   reason,
+	}
+	payloadBytes, _ := json.Marshal(payload)
+	protocol.WriteMessage(stream, &protocol.Message{Type: protocol.TypeAccessDenied, Payload: payloadBytes})
+}
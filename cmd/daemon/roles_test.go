@@ -0,0 +1,130 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/hemantsingh443/p2p-git-remote/internal/protocol"
+	"github.com/hemantsingh443/p2p-git-remote/internal/store"
+)
+
+// expectedRoles is the role each request needs, spelled out so that moving a
+// request between roles has to be done here as well as in roles.go.
+var expectedRoles = map[string]store.Role{
+	// Looking only
+	protocol.TypeListReposRequest:         store.RoleReadOnly,
+	protocol.TypeReadFileRequest:          store.RoleReadOnly,
+	protocol.TypeListFilesRequest:         store.RoleReadOnly,
+	protocol.TypeListBranchesRequest:      store.RoleReadOnly,
+	protocol.TypeGitStatusRequest:         store.RoleReadOnly,
+	protocol.TypeGitLogRequest:            store.RoleReadOnly,
+	protocol.TypeFileLogRequest:           store.RoleReadOnly,
+	protocol.TypeGitDiffRequest:           store.RoleReadOnly,
+	protocol.TypeGitStashListRequest:      store.RoleReadOnly,
+	protocol.TypeListDirRequest:           store.RoleReadOnly,
+	protocol.TypeSessionRequest:           store.RoleReadOnly,
+	protocol.TypeNetStatusRequest:         store.RoleReadOnly,
+	protocol.TypePingRequest:              store.RoleReadOnly,
+	protocol.TypeTailRequest:              store.RoleReadOnly,
+	protocol.TypeGrepRequest:              store.RoleReadOnly,
+	protocol.TypeListHunksRequest:         store.RoleReadOnly,
+	protocol.TypeReflogRequest:            store.RoleReadOnly,
+	protocol.TypeListWorktreesRequest:     store.RoleReadOnly,
+	protocol.TypeConflictsListRequest:     store.RoleReadOnly,
+	protocol.TypeGetCommitTemplateRequest: store.RoleReadOnly,
+	protocol.TypeRepoStatsRequest:         store.RoleReadOnly,
+	protocol.TypeBundleCreateRequest:      store.RoleReadOnly,
+	protocol.TypeSubscribeEventsRequest:   store.RoleReadOnly,
+	protocol.TypeDownloadFileRequest:      store.RoleReadOnly,
+	protocol.TypeListRemotesRequest:       store.RoleReadOnly,
+	protocol.TypeDaemonStatusRequest:      store.RoleReadOnly,
+	protocol.TypeJobsListRequest:          store.RoleReadOnly,
+	protocol.TypeJobStatusRequest:         store.RoleReadOnly,
+	// Changing a repo, or reading its git config
+	protocol.TypeGitCommitRequest:       store.RoleReadWrite,
+	protocol.TypeCreateBranchRequest:    store.RoleReadWrite,
+	protocol.TypeRenameFileRequest:      store.RoleReadWrite,
+	protocol.TypeWriteFileRequest:       store.RoleReadWrite,
+	protocol.TypeSwitchBranchRequest:    store.RoleReadWrite,
+	protocol.TypeGitStashSaveRequest:    store.RoleReadWrite,
+	protocol.TypeGitStashPopRequest:     store.RoleReadWrite,
+	protocol.TypeGitStashApplyRequest:   store.RoleReadWrite,
+	protocol.TypeGitStashDropRequest:    store.RoleReadWrite,
+	protocol.TypeGitResetRequest:        store.RoleReadWrite,
+	protocol.TypeGitCleanRequest:        store.RoleReadWrite,
+	protocol.TypeApplyPatchRequest:      store.RoleReadWrite,
+	protocol.TypeBatchRequest:           store.RoleReadWrite,
+	protocol.TypeChmodRequest:           store.RoleReadWrite,
+	protocol.TypeCreateFileRequest:      store.RoleReadWrite,
+	protocol.TypeMkdirRequest:           store.RoleReadWrite,
+	protocol.TypeDeletePathRequest:      store.RoleReadWrite,
+	protocol.TypeCheckoutFileRequest:    store.RoleReadWrite,
+	protocol.TypeStageHunksRequest:      store.RoleReadWrite,
+	protocol.TypeResetToReflogRequest:   store.RoleReadWrite,
+	protocol.TypeAddWorktreeRequest:     store.RoleReadWrite,
+	protocol.TypeResolveConflictRequest: store.RoleReadWrite,
+	protocol.TypeContinueMergeRequest:   store.RoleReadWrite,
+	protocol.TypeBisectStartRequest:     store.RoleReadWrite,
+	protocol.TypeBisectGoodRequest:      store.RoleReadWrite,
+	protocol.TypeBisectBadRequest:       store.RoleReadWrite,
+	protocol.TypeBisectResetRequest:     store.RoleReadWrite,
+	protocol.TypeBundleUploadRequest:    store.RoleReadWrite,
+	protocol.TypeUploadFileRequest:      store.RoleReadWrite,
+	protocol.TypeGitConfigGetRequest:    store.RoleReadWrite,
+	protocol.TypeIgnoreRequest:          store.RoleReadWrite,
+	protocol.TypeUnignoreRequest:        store.RoleReadWrite,
+	protocol.TypeRunHookRequest:         store.RoleReadWrite,
+	protocol.TypeJobCancelRequest:       store.RoleReadWrite,
+	// Managing the daemon
+	protocol.TypeLinkRepoRequest:         store.RoleAdmin,
+	protocol.TypeAuditLogRequest:         store.RoleAdmin,
+	protocol.TypeListPeersRequest:        store.RoleAdmin,
+	protocol.TypeRevokePeerRequest:       store.RoleAdmin,
+	protocol.TypeReadOnlyRequest:         store.RoleAdmin,
+	protocol.TypePairingWindowRequest:    store.RoleAdmin,
+	protocol.TypeBlockRequest:            store.RoleAdmin,
+	protocol.TypeApprovalsRequest:        store.RoleAdmin,
+	protocol.TypeRenameAliasRequest:      store.RoleAdmin,
+	protocol.TypeSetDefaultRemoteRequest: store.RoleAdmin,
+	protocol.TypeGitConfigSetRequest:     store.RoleAdmin,
+}
+
+func TestEveryHandlerHasItsRole(t *testing.T) {
+	for key := range handlers {
+		want, ok := expectedRoles[key.msgType]
+		if !ok {
+			t.Errorf("%s has a handler but no expected role", key.msgType)
+			continue
+		}
+		if got := requiredRole(key.msgType); got != want {
+			t.Errorf("%s needs %s, want %s", key.msgType, got, want)
+		}
+	}
+	v1 := handlerKey{version: protocol.ProtocolID}
+	for msgType := range expectedRoles {
+		v1.msgType = msgType
+		if handlers[v1] == nil {
+			t.Errorf("%s has an expected role but no handler", msgType)
+		}
+	}
+}
+
+func TestReadOnlyPeers(t *testing.T) {
+	for _, tc := range []struct {
+		msgType string
+		allowed bool
+	}{
+		// Remote URLs in git config can hold credentials
+		{protocol.TypeGitConfigGetRequest, false},
+		// Both only hand out what read-only peers can already read file by
+		// file or through the log: the working tree and the history
+		{protocol.TypeDownloadFileRequest, true},
+		{protocol.TypeBundleCreateRequest, true},
+		{protocol.TypeGitConfigSetRequest, false},
+		{protocol.TypeWriteFileRequest, false},
+		{protocol.TypeReadFileRequest, true},
+	} {
+		if got := store.RoleReadOnly.Allows(requiredRole(tc.msgType)); got != tc.allowed {
+			t.Errorf("read-only peer sending %s: allowed %v, want %v", tc.msgType, got, tc.allowed)
+		}
+	}
+}
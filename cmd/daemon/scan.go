@@ -0,0 +1,97 @@
+package main
+
+import (
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// scanMaxDepth is how many directories deep -scan looks for repos, so that
+// scanning a home directory doesn't walk all of it.
+const scanMaxDepth = 5
+
+// scanSkipDirs are never looked into, as they hold dependencies rather than
+// projects.
+var scanSkipDirs = map[string]bool{"node_modules": true, "vendor": true}
+
+var aliasUnsafe = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// scanRepos links every git repo under root that isn't linked yet, under an
+// alias derived from its directory name, and returns how many it linked.
+// Repos inside repos, like submodules, are left alone.
+func scanRepos(root string) int {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		fatal("Could not get absolute path for scan root", "err", err)
+	}
+	if info, err := os.Stat(root); err != nil || !info.IsDir() {
+		fatal("Cannot scan for repos: not a directory", "dir", root)
+	}
+
+	known := make(map[string]bool, len(linkedRepos))
+	for _, path := range linkedRepos {
+		known[path] = true
+	}
+	linked := 0
+	filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// An unreadable directory; the rest can still be scanned
+			slog.Debug("Skipping directory", "dir", path, "err", err)
+			return nil
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if path != root && (strings.HasPrefix(d.Name(), ".") || scanSkipDirs[d.Name()]) {
+			return filepath.SkipDir
+		}
+		if info, err := os.Stat(filepath.Join(path, ".git")); err != nil || !info.IsDir() {
+			// A .git file is a worktree's or a submodule's, linked through its repo
+			if rel, _ := filepath.Rel(root, path); strings.Count(rel, string(filepath.Separator)) >= scanMaxDepth-1 {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !known[path] {
+			alias := scanAlias(path)
+			linkedRepos[alias] = path
+			known[path] = true
+			linked++
+			slog.Info("Linked repository", "alias", alias, "path", path)
+		}
+		return filepath.SkipDir
+	})
+	slog.Info("Scanned for repositories", "dir", root, "linked", linked)
+	return linked
+}
+
+// scanAlias picks an alias for the repo at path: its directory name, or if
+// that's taken, prefixed with its parent's ("work-api"), or else numbered
+// ("api-2").
+func scanAlias(path string) string {
+	clean := func(s string) string {
+		return strings.Trim(aliasUnsafe.ReplaceAllString(s, "-"), "-.")
+	}
+	name := clean(filepath.Base(path))
+	if name == "" {
+		name = "repo"
+	}
+	if _, taken := linkedRepos[name]; !taken {
+		return name
+	}
+	if parent := clean(filepath.Base(filepath.Dir(path))); parent != "" {
+		if _, taken := linkedRepos[parent+"-"+name]; !taken {
+			return parent + "-" + name
+		}
+	}
+	for i := 2; ; i++ {
+		alias := name + "-" + strconv.Itoa(i)
+		if _, taken := linkedRepos[alias]; !taken {
+			return alias
+		}
+	}
+}
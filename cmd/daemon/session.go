@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+
+	"github.com/hemantsingh443/p2p-git-remote/internal/p2p"
+	"github.com/hemantsingh443/p2p-git-remote/internal/protocol"
+)
+
+// sessionTTL is how long a session lasts before the client has to sign a
+// new challenge, set by -session-ttl. Zero turns sessions off.
+var sessionTTL = 12 * time.Hour
+
+// issueSession returns a new session token for a peer, or "" if sessions
+// are off.
+func issueSession(remotePeer peer.ID) (string, time.Time, error) {
+	if sessionTTL == 0 {
+		return "", time.Time{}, nil
+	}
+	return p2p.IssueSession(daemonHost.Peerstore().PrivKey(daemonHost.ID()), daemonHost.ID(), remotePeer, sessionTTL)
+}
+
+// checkSession returns why a request's session token isn't valid, or "" if
+// it is.
+func checkSession(remotePeer peer.ID, token string) string {
+	if sessionTTL == 0 {
+		return ""
+	}
+	if err := p2p.VerifySession(daemonHost.Peerstore().PubKey(daemonHost.ID()), token, daemonHost.ID(), remotePeer); err != nil {
+		return err.Error()
+	}
+	return ""
+}
+
+// refuseSessionExpired answers a request sent without a valid session.
+func refuseSessionExpired(stream network.Stream, msgType, reason string) {
+	payloadBytes, _ := json.Marshal(protocol.SessionExpiredPayload{Request: msgType, Error: reason})
+	protocol.WriteMessage(stream, &protocol.Message{Type: protocol.TypeSessionExpired, Payload: payloadBytes})
+}
+
+// handleSessionRequest renews a trusted peer's session once it signs a
+// challenge with the key it paired with.
+func handleSessionRequest(stream network.Stream) {
+	remotePeer := stream.Conn().RemotePeer()
+	trustLog.Info("Handling Session request", "peer", remotePeer)
+
+	respPayload := protocol.SessionResponsePayload{}
+	fingerprint, err := challengePeer(stream)
+	if err == nil {
+		err = trustStore.CheckKey(remotePeer, fingerprint)
+	}
+	if err == nil {
+		respPayload.Token, respPayload.ExpiresAt, err = issueSession(remotePeer)
+	}
+	if err != nil {
+		trustLog.Warn("Refused a session", "peer", remotePeer, "err", err)
+		respPayload.Success = false
+		respPayload.Error = err.Error()
+	} else {
+		respPayload.Success = true
+	}
+	payloadBytes, _ := json.Marshal(respPayload)
+	response := &protocol.Message{Type: protocol.TypeSessionResponse, Payload: payloadBytes}
+	protocol.WriteMessage(stream, response)
+}
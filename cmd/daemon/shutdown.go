@@ -0,0 +1,79 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+
+	"github.com/hemantsingh443/p2p-git-remote/internal/protocol"
+	"github.com/hemantsingh443/p2p-git-remote/internal/systemd"
+)
+
+// shutdownTimeout bounds how long the daemon waits for requests in flight,
+// like a commit or a push, when asked to stop.
+var shutdownTimeout = 30 * time.Second
+
+// longLivedRequests keep their stream open until the client goes away, so
+// shutting down closes them instead of waiting. So do pairings, which wait
+// for the owner.
+var longLivedRequests = map[string]bool{
+	"handshake":                         true,
+	protocol.TypeSubscribeEventsRequest: true,
+	protocol.TypeTailRequest:            true,
+	protocol.TypeApprovalsRequest:       true,
+	protocol.TypePingRequest:            true,
+	protocol.TypeJobStatusRequest:       true, // Only while following; see followJob
+}
+
+// shutdown stops the daemon cleanly: it refuses new streams, closes the
+// long-lived ones, waits for the requests and jobs in flight to finish and
+// their audit entries to be written, and saves the repo list. The host is closed
+// by the caller.
+func shutdown(h host.Host) {
+	slog.Info("Shutting down: refusing new requests and waiting for those in flight (signal again to stop at once)")
+	systemd.Notify("STOPPING=1")
+	for _, version := range handlerVersions() {
+		h.RemoveStreamHandler(version)
+	}
+
+	streamsMu.Lock()
+	for stream, s := range activeStreams {
+		if longLivedRequests[s.request] {
+			stream.Reset()
+		}
+	}
+	streamsMu.Unlock()
+
+	deadline := time.Now().Add(shutdownTimeout)
+	for {
+		var busy []activeStream
+		for _, s := range listStreams() {
+			if !longLivedRequests[s.request] {
+				busy = append(busy, s)
+			}
+		}
+		if len(busy) == 0 && runningJobs() == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			for _, s := range busy {
+				slog.Warn("Gave up waiting for a request", "request", s.request, "peer", s.peer, "after", shutdownTimeout)
+			}
+			// Killed rather than left to run on unsupervised, so their state is right
+			cancelJobs()
+			for wait := time.Now().Add(2 * time.Second); runningJobs() > 0 && time.Now().Before(wait); {
+				time.Sleep(100 * time.Millisecond)
+			}
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	configMu.RLock()
+	if err := saveLinkedRepos(); err != nil {
+		slog.Warn("Failed to save repo list", "err", err)
+	}
+	configMu.RUnlock()
+	slog.Info("Daemon stopped")
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/hemantsingh443/p2p-git-remote/internal/store"
+)
+
+// identityKeyFile holds the daemon's private key, and so its peer ID.
+const identityKeyFile = "daemon_identity.key"
+
+// stateDir is where the daemon's files live (-state-dir).
+var stateDir = store.DefaultStateDir()
+
+// stateFiles are the files the daemon used to keep in whatever directory it
+// was started from, which move to the state directory.
+var stateFiles = []string{
+	identityKeyFile,
+	trustedPeersFile,
+	linkedReposFile,
+	repoConfigFile,
+	policyFile,
+	webhooksFile,
+	blocklistFile,
+	pairingTokensFile,
+	auditLogFile,
+	jobsFile,
+}
+
+// statePath is where the daemon keeps one of its files.
+func statePath(name string) string {
+	return filepath.Join(stateDir, name)
+}
+
+// setupStateDir creates the state directory, and moves the files of an
+// older daemon run from the working directory into it, unless it has its own
+// already. Only a working directory with the identity key or trusted peers
+// of such a daemon counts: a policy.json or jobs.json alone may well be
+// someone else's. Other paths given on the command line stay relative to
+// the working directory.
+func setupStateDir() error {
+	if err := os.MkdirAll(stateDir, 0700); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+	abs, err := filepath.Abs(stateDir)
+	if err != nil {
+		return err
+	}
+	stateDir = abs
+	cwd, err := os.Getwd()
+	if err != nil || store.SameDir(cwd, stateDir) || !hasLegacyState(cwd) {
+		return nil
+	}
+
+	slog.Info("Found the files of an older daemon in the working directory, moving them to the state directory", "dir", cwd, "state_dir", stateDir)
+	for _, name := range stateFiles {
+		from, to := filepath.Join(cwd, name), statePath(name)
+		if _, err := os.Stat(from); err != nil {
+			continue
+		}
+		if _, err := os.Stat(to); err == nil {
+			slog.Warn("Ignoring state file in the working directory, the state directory has its own", "file", from, "state_dir", stateDir)
+			continue
+		}
+		if err := store.MoveFile(from, to); err != nil {
+			return fmt.Errorf("failed to move %s to the state directory: %w", name, err)
+		}
+		slog.Info("Moved state file to the state directory", "file", name, "state_dir", stateDir)
+	}
+	return nil
+}
+
+// hasLegacyState reports whether dir has the identity key or trusted peers
+// a daemon kept in its working directory before it had a state directory.
+func hasLegacyState(dir string) bool {
+	for _, name := range []string{identityKeyFile, trustedPeersFile} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"runtime/debug"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+
+	"github.com/hemantsingh443/p2p-git-remote/internal/protocol"
+)
+
+// version is the daemon's release, set with -ldflags "-X main.version=v1.2.3".
+// Without it, the module version or VCS revision Go recorded is used.
+var version = ""
+
+// started is when the daemon started, for its uptime.
+var started = time.Now()
+
+func daemonVersion() string {
+	if version != "" {
+		return version
+	}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	if v := info.Main.Version; v != "" && v != "(devel)" {
+		return v
+	}
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" && len(setting.Value) >= 12 {
+			return "dev-" + setting.Value[:12]
+		}
+	}
+	return "dev"
+}
+
+func handleDaemonStatus(stream network.Stream) {
+	p2pLog.Info("Handling DaemonStatus request")
+
+	respPayload := protocol.DaemonStatusResponsePayload{
+		Success:         true,
+		Version:         daemonVersion(),
+		ProtocolVersion: string(stream.Protocol()),
+		Started:         started,
+		UptimeMs:        time.Since(started).Milliseconds(),
+		LinkedRepos:     linkedRepoCount(),
+		Jobs:            runningJobs(),
+		ReadOnly:        readOnlyMode.Load(),
+	}
+	for _, s := range listStreams() {
+		// A tail or an event subscription waits rather than works
+		if !longLivedRequests[s.request] {
+			respPayload.InFlight++
+		}
+	}
+	if daemonHost != nil {
+		respPayload.ConnectedPeers = len(daemonHost.Network().Peers())
+	}
+	for p := range trustStore.Peers() {
+		if !trustStore.IsTrusted(p) {
+			continue
+		}
+		respPayload.TrustedPeers++
+		if daemonHost != nil && daemonHost.Network().Connectedness(p) == network.Connected {
+			respPayload.ConnectedTrusted++
+		}
+	}
+	payloadBytes, _ := json.Marshal(respPayload)
+	response := &protocol.Message{Type: protocol.TypeDaemonStatusResponse, Payload: payloadBytes}
+	protocol.WriteMessage(stream, response)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// activeStream is a stream the daemon is serving, for the admin socket's
+// `streams` command.
+type activeStream struct {
+	peer    peer.ID
+	request string // The request type, once read
+	repo    string
+	started time.Time
+}
+
+var (
+	streamsMu     sync.Mutex
+	activeStreams = make(map[network.Stream]*activeStream)
+)
+
+// trackStream records a stream until the returned function is called.
+func trackStream(stream network.Stream) (untrack func()) {
+	streamsMu.Lock()
+	activeStreams[stream] = &activeStream{peer: stream.Conn().RemotePeer(), request: "handshake", started: time.Now()}
+	streamsMu.Unlock()
+	return func() {
+		streamsMu.Lock()
+		delete(activeStreams, stream)
+		streamsMu.Unlock()
+	}
+}
+
+// setStreamRequest records what a tracked stream asks for.
+func setStreamRequest(stream network.Stream, request, repo string) {
+	streamsMu.Lock()
+	defer streamsMu.Unlock()
+	if s, ok := activeStreams[stream]; ok {
+		s.request, s.repo = request, repo
+	}
+}
+
+// listStreams returns the streams being served, oldest first.
+func listStreams() []activeStream {
+	streamsMu.Lock()
+	defer streamsMu.Unlock()
+	streams := make([]activeStream, 0, len(activeStreams))
+	for _, s := range activeStreams {
+		streams = append(streams, *s)
+	}
+	sort.Slice(streams, func(i, j int) bool { return streams[i].started.Before(streams[j].started) })
+	return streams
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+
+	"github.com/hemantsingh443/p2p-git-remote/internal/protocol"
+)
+
+// tailPollInterval is how often a followed file is checked for new data.
+// Polling, unlike the watcher, also follows logs in ignored directories.
+const tailPollInterval = 500 * time.Millisecond
+
+// tailChunkSize bounds the data of one TAIL_DATA message.
+const tailChunkSize = 64 * 1024
+
+// handleTail sends the last lines of a file, then whatever is appended to it,
+// until the client closes the stream. Like `tail -F`, it keeps following the
+// path if the file is truncated or replaced, as log rotation does.
+func handleTail(stream network.Stream, rawPayload json.RawMessage) {
+	var payload protocol.TailRequestPayload
+	json.Unmarshal(rawPayload, &payload)
+	filesLog.Info("Handling Tail request", "file", payload.FilePath, "repo", payload.RepoPath)
+
+	respPayload := protocol.TailResponsePayload{}
+	var f *os.File
+	repoPath, ok := resolveRepo(payload.RepoPath)
+	fullPath := filepath.Join(repoPath, payload.FilePath)
+	if !ok {
+		respPayload.Success = false
+		respPayload.Error = "unknown repository alias"
+	} else if _, err := sandboxPath(payload.RepoPath, repoPath, payload.FilePath); err != nil {
+		respPayload.Success = false
+		respPayload.Error = err.Error()
+	} else if info, err := os.Stat(fullPath); err != nil || !info.Mode().IsRegular() {
+		respPayload.Success = false
+		respPayload.Error = fmt.Sprintf("'%s' is not a file", payload.FilePath)
+	} else if f, err = os.Open(fullPath); err != nil {
+		respPayload.Success = false
+		respPayload.Error = err.Error()
+	} else {
+		respPayload.Success = true
+	}
+	payloadBytes, _ := json.Marshal(respPayload)
+	response := &protocol.Message{Type: protocol.TypeTailResponse, Payload: payloadBytes}
+	if err := protocol.WriteMessage(stream, response); err != nil || !respPayload.Success {
+		if f != nil {
+			f.Close()
+		}
+		return
+	}
+	defer func() { f.Close() }()
+
+	info, _ := f.Stat()
+	offset, err := lastLinesOffset(f, info.Size(), payload.Lines)
+	if err != nil {
+		return
+	}
+	if offset, err = sendTail(stream, f, offset, info.Size(), false); err != nil {
+		return
+	}
+
+	// The client sends nothing more; a read only returns once it goes away
+	closed := make(chan struct{})
+	go func() {
+		protocol.ReadMessage(stream)
+		close(closed)
+	}()
+	ticker := time.NewTicker(tailPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-closed:
+			filesLog.Info("Stopped following file", "file", payload.FilePath, "repo", payload.RepoPath)
+			return
+		case <-ticker.C:
+		}
+
+		current, err := os.Stat(fullPath)
+		if err != nil {
+			continue // Deleted; it may come back
+		}
+		truncated := false
+		if opened, _ := f.Stat(); !os.SameFile(opened, current) {
+			// Replaced by a new file: follow that one from its start
+			newFile, err := os.Open(fullPath)
+			if err != nil {
+				continue
+			}
+			f.Close()
+			f, offset, truncated = newFile, 0, true
+		} else if current.Size() < offset {
+			offset, truncated = 0, true
+		}
+		if current.Size() > offset || truncated {
+			if offset, err = sendTail(stream, f, offset, current.Size(), truncated); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// sendTail sends the data of f between offset and size, and returns the
+// offset to continue from.
+func sendTail(stream network.Stream, f *os.File, offset, size int64, truncated bool) (int64, error) {
+	for offset < size || truncated {
+		buf := make([]byte, min(size-offset, tailChunkSize))
+		n, err := f.ReadAt(buf, offset)
+		if err != nil && err != io.EOF {
+			return offset, err
+		}
+		offset += int64(n)
+		payloadBytes, _ := json.Marshal(protocol.TailDataPayload{Data: strings.ToValidUTF8(string(buf[:n]), "�"), Truncated: truncated})
+		if err := protocol.WriteMessage(stream, &protocol.Message{Type: protocol.TypeTailData, Payload: payloadBytes}); err != nil {
+			return offset, err
+		}
+		if n == 0 {
+			break
+		}
+		truncated = false
+	}
+	return offset, nil
+}
+
+// lastLinesOffset returns the offset in f where its last n lines begin. A
+// newline ending the file ends the last line rather than starting another.
+func lastLinesOffset(f *os.File, size int64, n int) (int64, error) {
+	if n <= 0 {
+		return size, nil
+	}
+	buf := make([]byte, 4096)
+	pos, count := size, 0
+	for pos > 0 {
+		readSize := min(int64(len(buf)), pos)
+		pos -= readSize
+		if _, err := f.ReadAt(buf[:readSize], pos); err != nil && err != io.EOF {
+			return 0, err
+		}
+		for i := readSize - 1; i >= 0; i-- {
+			if buf[i] != '\n' || pos+i == size-1 {
+				continue
+			}
+			if count++; count == n {
+				return pos + i + 1, nil
+			}
+		}
+	}
+	return 0, nil
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"encoding/json"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/libp2p/go-libp2p/core/network"
+
+	"github.com/hemantsingh443/p2p-git-remote/internal/git"
+	"github.com/hemantsingh443/p2p-git-remote/internal/protocol"
+)
+
+// changeBatchDelay is how long the watcher collects changes before
+// publishing them, so saving a file or checking out a branch sends one
+// FILE_CHANGED event instead of dozens.
+const changeBatchDelay = 300 * time.Millisecond
+
+// repoWatcher watches the working trees of the linked repos and publishes
+// FILE_CHANGED events to subscribed clients, and their refs for REPO_EVENTs.
+type repoWatcher struct {
+	watcher *fsnotify.Watcher
+
+	mu        sync.Mutex
+	roots     map[string]string                                // Repo path -> alias
+	pending   map[string]map[string]bool                       // Alias -> changed paths not yet published
+	subs      map[chan protocol.FileChangedEventPayload]string // Subscriber -> alias, "" for every repo
+	states    map[string]repoState                             // Alias -> branches when last checked
+	refChecks map[string]bool                                  // Aliases whose refs are about to be checked
+	repoSubs  map[chan protocol.RepoEventPayload]string        // Subscriber -> alias, "" for every repo
+}
+
+var watcher *repoWatcher
+
+// startWatcher begins watching every linked repo. Without a watcher the
+// daemon works as before; clients just don't get change events.
+func startWatcher() {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		filesLog.Warn("File watching disabled", "err", err)
+		return
+	}
+	watcher = &repoWatcher{
+		watcher:   w,
+		roots:     make(map[string]string),
+		pending:   make(map[string]map[string]bool),
+		subs:      make(map[chan protocol.FileChangedEventPayload]string),
+		states:    make(map[string]repoState),
+		refChecks: make(map[string]bool),
+		repoSubs:  make(map[chan protocol.RepoEventPayload]string),
+	}
+	for alias, repoPath := range copyLinkedRepos() {
+		watcher.addRepo(alias, repoPath)
+	}
+	go watcher.run()
+}
+
+// addRepo watches every directory of a repo's working tree, except .git and
+// directories git ignores (node_modules, build output...).
+func (w *repoWatcher) addRepo(alias, repoPath string) {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	w.roots[repoPath] = alias
+	w.mu.Unlock()
+	w.addTree(repoPath, repoPath)
+	w.watchGitDir(alias, repoPath)
+}
+
+// removeRepo stops publishing events for a repo that was unlinked.
+func (w *repoWatcher) removeRepo(repoPath string) {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	delete(w.states, w.roots[repoPath])
+	delete(w.roots, repoPath)
+	w.mu.Unlock()
+	// Directories shared with another linked repo stay watched
+	filepath.WalkDir(repoPath, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && d.IsDir() {
+			if _, alias := w.repoFor(path); alias == "" {
+				w.watcher.Remove(path)
+			}
+		}
+		return nil
+	})
+}
+
+func (w *repoWatcher) addTree(repoPath, dir string) {
+	ignored := make(map[string]bool)
+	if paths, err := git.IgnoredPaths(repoPath, ""); err == nil {
+		for _, p := range paths {
+			ignored[strings.TrimSuffix(p, "/")] = true
+		}
+	}
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return nil
+		}
+		rel, _ := filepath.Rel(repoPath, path)
+		if d.Name() == ".git" || ignored[filepath.ToSlash(rel)] {
+			return filepath.SkipDir
+		}
+		if err := w.watcher.Add(path); err != nil {
+			filesLog.Warn("Cannot watch directory", "path", path, "err", err)
+		}
+		return nil
+	})
+}
+
+func (w *repoWatcher) run() {
+	for {
+		select {
+		case event, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+			w.handleEvent(event)
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+			filesLog.Error("File watcher error", "err", err)
+		}
+	}
+}
+
+func (w *repoWatcher) handleEvent(event fsnotify.Event) {
+	if event.Has(fsnotify.Chmod) && !event.Has(fsnotify.Write) {
+		return // Editors and indexers touch file modes constantly
+	}
+	repoPath, alias := w.repoFor(event.Name)
+	if alias == "" {
+		return
+	}
+	rel, err := filepath.Rel(repoPath, event.Name)
+	if err != nil || rel == ".git" {
+		return
+	}
+	if strings.HasPrefix(rel, ".git"+string(filepath.Separator)) {
+		if isRefChange(rel) {
+			// Branches with a slash in their name live in subdirectories
+			if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+				w.watcher.Add(event.Name)
+			}
+			w.refsChanged(alias)
+		}
+		return
+	}
+	if strings.HasPrefix(filepath.Base(rel), tempFilePrefix) {
+		return // Only the rename of a write matters
+	}
+	// Watch directories created after startup too
+	if event.Has(fsnotify.Create) {
+		if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+			w.addTree(repoPath, event.Name)
+		}
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.pending[alias] == nil {
+		w.pending[alias] = make(map[string]bool)
+		time.AfterFunc(changeBatchDelay, func() { w.publish(alias) })
+	}
+	w.pending[alias][filepath.ToSlash(rel)] = true
+}
+
+// repoFor finds the linked repo containing path.
+func (w *repoWatcher) repoFor(path string) (repoPath, alias string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for root, a := range w.roots {
+		// Prefer the deepest root, in case one repo is nested in another
+		if (path == root || strings.HasPrefix(path, root+string(filepath.Separator))) && len(root) > len(repoPath) {
+			repoPath, alias = root, a
+		}
+	}
+	return repoPath, alias
+}
+
+// publish sends the changes collected for alias to its subscribers.
+func (w *repoWatcher) publish(alias string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	event := protocol.FileChangedEventPayload{RepoPath: alias}
+	for p := range w.pending[alias] {
+		event.Paths = append(event.Paths, p)
+	}
+	sort.Strings(event.Paths)
+	delete(w.pending, alias)
+
+	for ch, filter := range w.subs {
+		if filter != "" && filter != alias {
+			continue
+		}
+		select {
+		case ch <- event:
+		default:
+			// A subscriber that can't keep up misses events rather than
+			// stalling everyone else
+		}
+	}
+}
+
+func (w *repoWatcher) subscribe(alias string) chan protocol.FileChangedEventPayload {
+	ch := make(chan protocol.FileChangedEventPayload, 16)
+	w.mu.Lock()
+	w.subs[ch] = alias
+	w.mu.Unlock()
+	return ch
+}
+
+func (w *repoWatcher) unsubscribe(ch chan protocol.FileChangedEventPayload) {
+	w.mu.Lock()
+	delete(w.subs, ch)
+	w.mu.Unlock()
+}
+
+// handleSubscribeEvents keeps the stream open and sends FILE_CHANGED events
+// for the requested repo (or every repo) until the client closes it. Events go
+// to each subscriber over its own stream rather than through gossipsub: only
+// trusted peers may see them, and every stream already went through the
+// daemon's trust and role checks, which a mesh relaying between peers can't.
+func handleSubscribeEvents(stream network.Stream, rawPayload json.RawMessage) {
+	var payload protocol.SubscribeEventsRequestPayload
+	json.Unmarshal(rawPayload, &payload)
+	filesLog.Info("Handling SubscribeEvents request", "repo", payload.RepoPath)
+
+	respPayload := protocol.SubscribeEventsResponsePayload{}
+	if payload.RepoPath != "" {
+		respPayload.Topic = protocol.EventTopic(payload.RepoPath)
+	}
+	_, ok := linkedRepo(payload.RepoPath)
+	if payload.RepoPath != "" && !ok {
+		respPayload.Success = false
+		respPayload.Error = "unknown repository alias"
+	} else if watcher == nil {
+		respPayload.Success = false
+		respPayload.Error = "file watching is not available on this daemon"
+	} else {
+		respPayload.Success = true
+	}
+	payloadBytes, _ := json.Marshal(respPayload)
+	response := &protocol.Message{Type: protocol.TypeSubscribeEventsResponse, Payload: payloadBytes}
+	if err := protocol.WriteMessage(stream, response); err != nil || !respPayload.Success {
+		return
+	}
+
+	events := watcher.subscribe(payload.RepoPath)
+	defer watcher.unsubscribe(events)
+	var repoEvents chan protocol.RepoEventPayload // Nil unless asked for, so never ready
+	if payload.RepoEvents {
+		repoEvents = watcher.subscribeRepo(payload.RepoPath)
+		defer watcher.unsubscribeRepo(repoEvents)
+	}
+
+	// The client sends nothing more; a read only returns once it goes away
+	closed := make(chan struct{})
+	go func() {
+		protocol.ReadMessage(stream)
+		close(closed)
+	}()
+	for {
+		select {
+		case event := <-events:
+			payloadBytes, _ := json.Marshal(event)
+			if err := protocol.WriteMessage(stream, &protocol.Message{Type: protocol.TypeFileChangedEvent, Payload: payloadBytes}); err != nil {
+				return
+			}
+		case event := <-repoEvents:
+			payloadBytes, _ := json.Marshal(event)
+			if err := protocol.WriteMessage(stream, &protocol.Message{Type: protocol.TypeRepoEvent, Payload: payloadBytes}); err != nil {
+				return
+			}
+		case <-closed:
+			filesLog.Info("Event subscriber disconnected", "repo", payload.RepoPath)
+			return
+		}
+	}
+}
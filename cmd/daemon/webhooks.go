@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+
+	"github.com/hemantsingh443/p2p-git-remote/internal/protocol"
+)
+
+const webhooksFile = "webhooks.json"
+
+// Webhook events
+const (
+	webhookCommit      = "commit"      // A branch of a linked repo moved, e.g. by a commit
+	webhookPush        = "push"        // A client committed and pushed
+	webhookPairing     = "pairing"     // A client was approved or rejected
+	webhookDestructive = "destructive" // A client asked for a reset, forced clean or force-push
+)
+
+// Webhooks are URLs the daemon POSTs events to, so teams can pipe its
+// activity into chat or a logging service:
+//
+//	{"webhooks": [
+//	  {"url": "https://hooks.example.com/abc", "events": ["push", "destructive"], "secret": "s3cret"}
+//	]}
+//
+// With a secret, each request has an X-P2P-Git-Signature header of
+// sha256=<hex HMAC-SHA256 of the body>, like GitHub's.
+type Webhooks struct {
+	Hooks []Webhook `json:"webhooks"`
+}
+
+// Webhook is a URL and the events sent to it; no events means all of them.
+type Webhook struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events,omitempty"`
+	Secret string   `json:"secret,omitempty"`
+}
+
+// webhookEvent is the JSON body of a webhook request.
+type webhookEvent struct {
+	Event   string    `json:"event"`
+	Time    time.Time `json:"time"`
+	Daemon  string    `json:"daemon"`
+	Peer    string    `json:"peer,omitempty"`
+	Repo    string    `json:"repo,omitempty"`
+	Branch  string    `json:"branch,omitempty"`
+	Hash    string    `json:"hash,omitempty"`
+	Subject string    `json:"subject,omitempty"`
+	Author  string    `json:"author,omitempty"`
+	Action  string    `json:"action,omitempty"` // What a destructive request does, or a pairing's role
+	Result  string    `json:"result,omitempty"` // As in the audit log: ok, failed, denied, approved...
+	Error   string    `json:"error,omitempty"`
+}
+
+var webhooks Webhooks
+
+// currentWebhooks returns the webhooks in force, which a reload may replace.
+func currentWebhooks() Webhooks {
+	configMu.RLock()
+	defer configMu.RUnlock()
+	return webhooks
+}
+
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+func loadWebhooks() {
+	var err error
+	if webhooks, err = readWebhooks(); err != nil {
+		fatal("Failed to load webhooks", "err", err)
+	}
+	slog.Info("Loaded webhooks", "count", len(webhooks.Hooks), "file", webhooksFile)
+}
+
+// readWebhooks reads and checks webhooks.json, if there is one.
+func readWebhooks() (Webhooks, error) {
+	var w Webhooks
+	data, err := os.ReadFile(statePath(webhooksFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return w, nil
+		}
+		return w, fmt.Errorf("failed to read %s: %w", webhooksFile, err)
+	}
+	if err := json.Unmarshal(data, &w); err != nil {
+		return w, fmt.Errorf("failed to parse %s: %w", webhooksFile, err)
+	}
+	for i, hook := range w.Hooks {
+		if u, err := url.Parse(hook.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return w, fmt.Errorf("invalid URL '%s' of webhook %d in %s", hook.URL, i+1, webhooksFile)
+		}
+		for _, event := range hook.Events {
+			switch event {
+			case webhookCommit, webhookPush, webhookPairing, webhookDestructive:
+			default:
+				return w, fmt.Errorf("unknown event '%s' of webhook %d: use %s, %s, %s or %s", event, i+1, webhookCommit, webhookPush, webhookPairing, webhookDestructive)
+			}
+		}
+	}
+	return w, nil
+}
+
+// fireWebhooks sends an event to the webhooks that want it, in the
+// background, retrying twice if a hook doesn't answer with 2xx.
+func fireWebhooks(event webhookEvent) {
+	hooks := currentWebhooks().Hooks
+	if len(hooks) == 0 {
+		return
+	}
+	event.Time = time.Now().UTC()
+	if daemonHost != nil {
+		event.Daemon = daemonHost.ID().String()
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	for _, hook := range hooks {
+		if len(hook.Events) > 0 && !slices.Contains(hook.Events, event.Event) {
+			continue
+		}
+		go func() {
+			wait := time.Second
+			for attempt := 1; ; attempt++ {
+				err := postWebhook(hook, event.Event, body)
+				if err == nil {
+					return
+				}
+				if attempt == 3 {
+					slog.Warn("Webhook failed", "url", hook.URL, "event", event.Event, "err", err)
+					return
+				}
+				time.Sleep(wait)
+				wait *= 4
+			}
+		}()
+	}
+}
+
+func postWebhook(hook Webhook, event string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "p2p-git-remote")
+	req.Header.Set("X-P2P-Git-Event", event)
+	if hook.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(hook.Secret))
+		mac.Write(body)
+		req.Header.Set("X-P2P-Git-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s", resp.Status)
+	}
+	return nil
+}
+
+// notifyRequest fires the webhooks for a request a client made: pushes that
+// went through, and destructive requests however they went.
+func notifyRequest(p peer.ID, msgType string, rawPayload json.RawMessage, repo, result, reason string) {
+	if action := destructiveAction(msgType, rawPayload); action != "" {
+		fireWebhooks(webhookEvent{Event: webhookDestructive, Peer: p.String(), Repo: repo, Action: action, Result: result, Error: reason})
+	}
+	if msgType == protocol.TypeGitCommitRequest && result == "ok" {
+		var payload protocol.GitCommitRequestPayload
+		json.Unmarshal(rawPayload, &payload)
+		// A background commit is announced by its job, once pushed
+		if !payload.DryRun && !payload.Background {
+			fireWebhooks(webhookEvent{Event: webhookPush, Peer: p.String(), Repo: repo, Branch: payload.Branch, Subject: payload.Message})
+		}
+	}
+}
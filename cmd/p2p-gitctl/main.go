@@ -0,0 +1,46 @@
+// p2p-gitctl administers a running daemon through its admin socket, from
+// the daemon's machine and as the user running it.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hemantsingh443/p2p-git-remote/internal/control"
+	"github.com/hemantsingh443/p2p-git-remote/internal/store"
+)
+
+// usage lists control.Commands, with their arguments lined up.
+func usage() string {
+	var b strings.Builder
+	b.WriteString("Usage: p2p-gitctl [-socket path] <command> [args]\n\nCommands:\n")
+	for _, c := range control.Commands {
+		fmt.Fprintf(&b, "  %-27s %s\n", strings.TrimSpace(c.Name+" "+c.Usage), c.Help)
+	}
+	return b.String()
+}
+
+func main() {
+	defaultSocket := filepath.Join(store.DefaultStateDir(), control.DefaultSocket)
+	socketPath := flag.String("socket", defaultSocket, "The daemon's admin socket (-admin-socket, in its -state-dir)")
+	flag.Usage = func() { fmt.Fprint(os.Stderr, usage()) }
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	resp, err := control.Call(*socketPath, control.Request{Command: flag.Arg(0), Args: flag.Args()[1:]})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if !resp.Success {
+		fmt.Fprintf(os.Stderr, "Error from daemon: %s\n", resp.Error)
+		os.Exit(1)
+	}
+	fmt.Print(resp.Output)
+}
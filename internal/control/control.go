@@ -0,0 +1,154 @@
+// Package control is the daemon's local admin interface: a unix socket that
+// only the user running the daemon can open, since the daemon's terminal is
+// taken by pairing prompts. Each connection carries one JSON request and
+// its response.
+package control
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// DefaultSocket is where the daemon listens, in its state directory.
+const DefaultSocket = "daemon.sock"
+
+// Request is an admin command, like {"command": "revoke", "args": ["12D3KooW"]}.
+type Request struct {
+	Command string   `json:"command"`
+	Args    []string `json:"args,omitempty"`
+}
+
+// Command is one the daemon answers on the admin socket.
+type Command struct {
+	Name  string
+	Usage string // Arguments, after the name
+	Help  string
+}
+
+// Commands lists the admin commands, for p2p-gitctl's usage and the daemon's
+// answer to one it doesn't know.
+var Commands = []Command{
+	{"peers", "", "List the trusted peers and whether they're connected"},
+	{"revoke", "<peer-id>", "Revoke a peer (a unique prefix is enough) and disconnect it"},
+	{"pending", "", "List the clients waiting to pair with a -headless daemon"},
+	{"approve", "<code> [role]", "Approve the client showing this pairing code (as read-write)"},
+	{"reject", "<peer-id>", "Reject a client waiting to pair"},
+	{"token", "[role] [valid-for]", "Create a one-time pairing token (read-write, valid 24h)"},
+	{"auto-approve", "<min> [role]", "Approve every new client for a while (as read-only; 0 stops)"},
+	{"repos", "", "List the linked repos"},
+	{"link", "<alias> <path>", "Link a repo"},
+	{"unlink", "<alias>", "Unlink a repo, leaving its files alone"},
+	{"rename", "<alias> <new-alias>", "Rename a linked repo"},
+	{"reload", "", "Reload the linked repos, repo settings, policy and webhooks"},
+	{"readonly", "[on|off]", "Show or set read-only mode"},
+	{"streams", "", "List the streams the daemon is serving"},
+}
+
+// Response is the outcome of a Request, as text for the terminal.
+type Response struct {
+	Success bool   `json:"success"`
+	Output  string `json:"output,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Listen serves requests on a unix socket with handle until the returned
+// listener is closed. A socket left behind by a daemon that died is
+// replaced; one a running daemon answers on is not.
+func Listen(socketPath string, handle func(Request) Response) (net.Listener, error) {
+	if _, err := os.Stat(socketPath); err == nil {
+		if conn, err := net.DialTimeout("unix", socketPath, time.Second); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("another daemon is already listening on %s", socketPath)
+		}
+		os.Remove(socketPath)
+	}
+	ln, err := listenPrivate(socketPath)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return // Closed
+			}
+			go serve(conn, handle)
+		}
+	}()
+	return ln, nil
+}
+
+// listenPrivate listens on a unix socket only this user can open. The socket
+// is created in a directory of its own, private to the user, and moved into
+// place once it's chmod-ed: created right at socketPath, it would be open to
+// anyone the umask lets in until then.
+func listenPrivate(socketPath string) (net.Listener, error) {
+	dir, err := os.MkdirTemp(filepath.Dir(socketPath), ".sock")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	tmpPath := filepath.Join(dir, "s")
+	ln, err := net.Listen("unix", tmpPath)
+	if err != nil {
+		return nil, err
+	}
+	// Closing the listener would remove tmpPath, where the socket no longer is
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	if err := os.Chmod(tmpPath, 0600); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	if err := os.Rename(tmpPath, socketPath); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return &socketListener{Listener: ln, path: socketPath}, nil
+}
+
+// socketListener removes its socket when closed.
+type socketListener struct {
+	net.Listener
+	path string
+}
+
+func (l *socketListener) Close() error {
+	err := l.Listener.Close()
+	os.Remove(l.path)
+	return err
+}
+
+func serve(conn net.Conn, handle func(Request) Response) {
+	defer conn.Close()
+	var req Request
+	if err := json.NewDecoder(conn).Decode(&req); err != nil {
+		json.NewEncoder(conn).Encode(Response{Error: "invalid request: " + err.Error()})
+		return
+	}
+	json.NewEncoder(conn).Encode(handle(req))
+}
+
+// Call sends a request to the daemon listening on socketPath.
+func Call(socketPath string, req Request) (Response, error) {
+	conn, err := net.Dial("unix", socketPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return Response{}, fmt.Errorf("no daemon is listening on %s; is it running, with this -state-dir?", socketPath)
+		}
+		return Response{}, err
+	}
+	defer conn.Close()
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return Response{}, err
+	}
+	var resp Response
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return Response{}, fmt.Errorf("failed to read the daemon's answer: %w", err)
+	}
+	return resp, nil
+}
@@ -0,0 +1,259 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Kinds of BatchOp.
+const (
+	BatchWrite  = "write"
+	BatchRename = "rename"
+	BatchDelete = "delete"
+)
+
+// BatchOp is one change of a batch: writing Content to Path, renaming Path
+// to NewPath, or deleting Path. Only regular files can be changed.
+type BatchOp struct {
+	Op      string
+	Path    string
+	NewPath string
+	Content []byte
+}
+
+// ApplyBatch applies ops in order, all or nothing. Renames and deletes of
+// tracked files go through `git mv` and `git rm` like single ones do. If an
+// op fails, every file touched so far and the index are put back as they
+// were before the batch.
+func ApplyBatch(repoPath string, ops []BatchOp) error {
+	for i, op := range ops {
+		if err := validateBatchOp(op); err != nil {
+			return fmt.Errorf("operation %d: %w", i+1, err)
+		}
+	}
+
+	b := &batch{repoPath: repoPath, saved: make(map[string]bool)}
+	if err := b.saveIndex(); err != nil {
+		return err
+	}
+	for i, op := range ops {
+		if err := b.apply(op); err != nil {
+			if rbErr := b.rollback(); rbErr != nil {
+				return fmt.Errorf("operation %d (%s %s) failed: %v; rolling back the batch also failed: %w", i+1, op.Op, op.Path, err, rbErr)
+			}
+			return fmt.Errorf("operation %d (%s %s) failed, so nothing was changed: %w", i+1, op.Op, op.Path, err)
+		}
+	}
+	return nil
+}
+
+func validateBatchOp(op BatchOp) error {
+	paths := []string{op.Path}
+	switch op.Op {
+	case BatchWrite, BatchDelete:
+	case BatchRename:
+		if op.NewPath == "" {
+			return fmt.Errorf("rename of '%s' has no new path", op.Path)
+		}
+		paths = append(paths, op.NewPath)
+	default:
+		return fmt.Errorf("unknown operation '%s'", op.Op)
+	}
+	for _, p := range paths {
+		clean := filepath.Clean(p)
+		if p == "" || clean == "." || clean == ".git" || strings.HasPrefix(clean, ".git"+string(filepath.Separator)) {
+			return fmt.Errorf("refusing to change '%s'", p)
+		}
+	}
+	return nil
+}
+
+// batch tracks what a batch changed, so it can be rolled back.
+type batch struct {
+	repoPath string
+
+	backups     []fileBackup
+	saved       map[string]bool // Paths in backups
+	createdDirs []string        // In the order they were created
+
+	indexPath string
+	index     []byte // nil if there was no index
+}
+
+// fileBackup is a file as it was before the batch touched it.
+type fileBackup struct {
+	path    string
+	existed bool
+	content []byte
+	mode    os.FileMode
+}
+
+func (b *batch) saveIndex() error {
+	indexPath, err := gitOutput(b.repoPath, "rev-parse", "--git-path", "index")
+	if err != nil {
+		return err
+	}
+	if !filepath.IsAbs(indexPath) {
+		indexPath = filepath.Join(b.repoPath, indexPath)
+	}
+	b.indexPath = indexPath
+	b.index, err = os.ReadFile(indexPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to back up the index: %w", err)
+	}
+	return nil
+}
+
+// save backs up path the first time the batch touches it.
+func (b *batch) save(path string) error {
+	path = filepath.Clean(path)
+	if b.saved[path] {
+		return nil
+	}
+
+	backup := fileBackup{path: path}
+	info, err := os.Lstat(filepath.Join(b.repoPath, path))
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return err
+	case !info.Mode().IsRegular():
+		return fmt.Errorf("'%s' is not a regular file", path)
+	default:
+		if backup.content, err = os.ReadFile(filepath.Join(b.repoPath, path)); err != nil {
+			return err
+		}
+		backup.existed = true
+		backup.mode = info.Mode().Perm()
+	}
+	b.backups = append(b.backups, backup)
+	b.saved[path] = true
+	return nil
+}
+
+// exists reports whether path exists at this point of the batch.
+func (b *batch) exists(path string) bool {
+	_, err := os.Lstat(filepath.Join(b.repoPath, path))
+	return err == nil
+}
+
+// mkdirs creates dir and any missing parents, remembering which were created.
+func (b *batch) mkdirs(dir string) error {
+	var missing []string
+	for d := dir; d != b.repoPath && d != filepath.Dir(d); d = filepath.Dir(d) {
+		if _, err := os.Stat(d); err == nil {
+			break
+		}
+		missing = append(missing, d)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for i := len(missing) - 1; i >= 0; i-- {
+		b.createdDirs = append(b.createdDirs, missing[i])
+	}
+	return nil
+}
+
+func (b *batch) apply(op BatchOp) error {
+	full := filepath.Join(b.repoPath, op.Path)
+	switch op.Op {
+	case BatchWrite:
+		if err := b.save(op.Path); err != nil {
+			return err
+		}
+		if info, err := os.Lstat(full); err == nil && !info.Mode().IsRegular() {
+			return fmt.Errorf("'%s' is not a regular file", op.Path)
+		}
+		if err := b.mkdirs(filepath.Dir(full)); err != nil {
+			return err
+		}
+		// An existing file keeps its mode
+		return os.WriteFile(full, op.Content, 0644)
+
+	case BatchRename:
+		if err := b.save(op.Path); err != nil {
+			return err
+		}
+		if err := b.save(op.NewPath); err != nil {
+			return err
+		}
+		if !b.exists(op.Path) {
+			return fmt.Errorf("'%s' does not exist", op.Path)
+		}
+		if b.exists(op.NewPath) {
+			return fmt.Errorf("'%s' already exists", op.NewPath)
+		}
+		if err := b.mkdirs(filepath.Dir(filepath.Join(b.repoPath, op.NewPath))); err != nil {
+			return err
+		}
+		if isTracked(b.repoPath, op.Path) {
+			_, err := gitOutput(b.repoPath, "mv", "--", op.Path, op.NewPath)
+			return err
+		}
+		return os.Rename(full, filepath.Join(b.repoPath, op.NewPath))
+
+	case BatchDelete:
+		if err := b.save(op.Path); err != nil {
+			return err
+		}
+		if !b.exists(op.Path) {
+			return fmt.Errorf("'%s' does not exist", op.Path)
+		}
+		if isTracked(b.repoPath, op.Path) {
+			_, err := gitOutput(b.repoPath, "rm", "-q", "-f", "--", op.Path)
+			return err
+		}
+		return os.Remove(full)
+	}
+	return fmt.Errorf("unknown operation '%s'", op.Op)
+}
+
+// rollback restores every file the batch touched, removes the directories
+// it created and puts the index back.
+func (b *batch) rollback() error {
+	var errs []error
+	for i := len(b.backups) - 1; i >= 0; i-- {
+		backup := b.backups[i]
+		full := filepath.Join(b.repoPath, backup.path)
+		if !backup.existed {
+			if err := os.Remove(full); err != nil && !errors.Is(err, os.ErrNotExist) {
+				errs = append(errs, err)
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := os.WriteFile(full, backup.content, backup.mode); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		// WriteFile keeps the mode of a file that exists
+		if err := os.Chmod(full, backup.mode); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for i := len(b.createdDirs) - 1; i >= 0; i-- {
+		os.Remove(b.createdDirs[i]) // Only succeeds if it's empty again
+	}
+
+	if b.index != nil {
+		if err := os.WriteFile(b.indexPath, b.index, 0644); err != nil {
+			errs = append(errs, fmt.Errorf("failed to restore the index: %w", err))
+		}
+	} else if err := os.Remove(b.indexPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		errs = append(errs, fmt.Errorf("failed to restore the index: %w", err))
+	}
+	return errors.Join(errs...)
+}
+
+// isTracked reports whether git tracks path.
+func isTracked(repoPath, path string) bool {
+	_, err := gitOutput(repoPath, "ls-files", "--error-unmatch", "--", path)
+	return err == nil
+}
@@ -0,0 +1,92 @@
+package git
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// BisectState is where a bisect session stands after a step.
+type BisectState struct {
+	Done      bool   // The first bad commit has been found
+	Culprit   Commit // Set when Done
+	Current   Commit // The commit checked out for testing, when not Done
+	Remaining int    // Revisions left to test after Current, as estimated by git
+	Output    string // git's own report of the step
+}
+
+// BisectStart begins a bisect between a known bad and a known good commit and
+// checks out the first candidate. An empty bad means HEAD.
+func BisectStart(repoPath, bad, good string) (BisectState, error) {
+	if good == "" {
+		return BisectState{}, fmt.Errorf("a known good commit is required")
+	}
+	if bad == "" {
+		bad = "HEAD"
+	}
+	return bisectStep(repoPath, "start", bad, good)
+}
+
+// BisectMark marks commit (or the current candidate when empty) as "good" or
+// "bad" and checks out the next candidate.
+func BisectMark(repoPath, verdict, commit string) (BisectState, error) {
+	if verdict != "good" && verdict != "bad" {
+		return BisectState{}, fmt.Errorf("unknown bisect verdict '%s'", verdict)
+	}
+	if !gitPathExists(repoPath, "BISECT_LOG") {
+		return BisectState{}, fmt.Errorf("no bisect in progress")
+	}
+	args := []string{verdict}
+	if commit != "" {
+		args = append(args, commit)
+	}
+	return bisectStep(repoPath, args...)
+}
+
+// BisectReset ends the bisect session and returns to the original branch.
+func BisectReset(repoPath string) (string, error) {
+	cmd := Command(repoPath, "bisect", "reset")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return string(out), fmt.Errorf("git bisect reset failed: %s", strings.TrimSpace(string(out)))
+	}
+	return string(out), nil
+}
+
+// bisectStep runs a bisect subcommand and works out the resulting state.
+func bisectStep(repoPath string, args ...string) (BisectState, error) {
+	cmd := Command(repoPath, append([]string{"bisect"}, args...)...)
+	out, err := cmd.CombinedOutput()
+	state := BisectState{Output: string(out)}
+	if err != nil {
+		return state, fmt.Errorf("git bisect %s failed: %s", args[0], strings.TrimSpace(string(out)))
+	}
+
+	// "<hash> is the first bad commit" ends the session
+	for _, line := range strings.Split(state.Output, "\n") {
+		if hash, found := strings.CutSuffix(strings.TrimSpace(line), " is the first bad commit"); found {
+			state.Done = true
+			state.Culprit, err = DescribeCommit(repoPath, hash)
+			return state, err
+		}
+		fmt.Sscanf(line, "Bisecting: %d revision", &state.Remaining)
+	}
+	state.Current, err = DescribeCommit(repoPath, "HEAD")
+	return state, err
+}
+
+// DescribeCommit looks up the log details of a single commit.
+func DescribeCommit(repoPath, rev string) (Commit, error) {
+	cmd := Command(repoPath, "log", "-1", "--format=%H%x1f%h%x1f%an%x1f%ae%x1f%aI%x1f%s", rev, "--")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return Commit{}, fmt.Errorf("git log failed: %s", strings.TrimSpace(string(out)))
+	}
+	fields := strings.Split(strings.TrimSpace(string(out)), "\x1f")
+	if len(fields) != 6 {
+		return Commit{}, fmt.Errorf("unexpected git log output for %s", rev)
+	}
+	c := Commit{Hash: fields[0], AbbrevHash: fields[1], AuthorName: fields[2], AuthorEmail: fields[3], Subject: fields[5]}
+	c.Date, _ = time.Parse(time.RFC3339, fields[4])
+	return c, nil
+}
@@ -0,0 +1,51 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// BundleRemote is the remote-tracking namespace branches from a fetched
+// bundle land in, so they never clobber local branches.
+const BundleRemote = "bundle"
+
+// CreateBundle writes a git bundle of refs to out. Refs may be ranges like
+// "v1.0..main"; an empty list bundles every ref.
+func CreateBundle(repoPath string, refs []string, out io.Writer) error {
+	if len(refs) == 0 {
+		refs = []string{"--all"}
+	}
+	for _, ref := range refs {
+		if strings.HasPrefix(ref, "-") && ref != "--all" {
+			return fmt.Errorf("invalid ref '%s'", ref)
+		}
+	}
+
+	var stderr bytes.Buffer
+	cmd := Command(repoPath, append([]string{"bundle", "create", "-"}, refs...)...)
+	cmd.Stdout = out
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("git bundle create failed: %s", strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// FetchBundle verifies the bundle at bundlePath and fetches its branches into
+// refs/remotes/bundle/* and its tags into refs/tags/*.
+func FetchBundle(repoPath, bundlePath string) (string, error) {
+	cmd := Command(repoPath, "bundle", "verify", bundlePath)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return string(out), fmt.Errorf("bundle verification failed: %s", strings.TrimSpace(string(out)))
+	}
+
+	cmd = Command(repoPath, "fetch", bundlePath,
+		"+refs/heads/*:refs/remotes/"+BundleRemote+"/*", "refs/tags/*:refs/tags/*")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return string(out), fmt.Errorf("git fetch from bundle failed: %s", strings.TrimSpace(string(out)))
+	}
+	return string(out), nil
+}
@@ -1,41 +1,679 @@
 package git
 
 import (
+	"errors"
 	"fmt"
-	"os/exec"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 )
 
-// CommitAndPush performs `git add`, `git commit`, and `git push`.
-func CommitAndPush(repoPath, commitMessage, remote, branch string) (string, error) {
-	// Step 1: Git Add
-	cmdAdd := exec.Command("git", "add", ".")
-	cmdAdd.Dir = repoPath
-	out, err := cmdAdd.CombinedOutput()
+// CommitOptions tweaks how CommitAndPush and AmendAndPush create the commit.
+type CommitOptions struct {
+	AuthorName  string // Overrides the commit author; requires AuthorEmail
+	AuthorEmail string
+	SignOff     bool // Adds a Signed-off-by trailer
+
+	// Sign creates a GPG/SSH signed commit. SigningKey and SigningFormat
+	// override the repo's user.signingkey and gpg.format when set.
+	Sign          bool
+	SigningKey    string
+	SigningFormat string
+
+	Hooks HookPolicy // How a failing pre-commit hook is treated; empty means HooksWarn
+
+	SetUpstream bool // Push with -u, making remote/branch the branch's upstream
+
+	StagedOnly bool // Commit only what is already in the index instead of staging everything
+
+	// ScanSecrets refuses to commit staged changes that look like they add
+	// credentials, with a *SecretsFoundError; see ScanStaged.
+	ScanSecrets bool
+
+	SSHCommand string // Runs ssh for the push, like GIT_SSH_COMMAND; git's own choice when empty
+
+	// Progress, if set, is sent git push's output and progress as it runs.
+	Progress io.Writer
+	// Abort, when closed, kills the push; its error then wraps ErrCancelled.
+	Abort <-chan struct{}
+}
+
+// commitArgs builds the arguments for `git commit` from the message and options.
+func commitArgs(commitMessage string, opts CommitOptions) ([]string, error) {
+	var args []string
+	if opts.Sign {
+		if opts.SigningFormat != "" {
+			args = append(args, "-c", "gpg.format="+opts.SigningFormat)
+		}
+		if opts.SigningKey != "" {
+			args = append(args, "-c", "user.signingkey="+opts.SigningKey)
+		}
+	}
+	args = append(args, "commit")
+	if opts.Sign {
+		args = append(args, "-S")
+	}
+	if commitMessage != "" {
+		args = append(args, "-m", commitMessage)
+	}
+	if opts.AuthorName != "" || opts.AuthorEmail != "" {
+		if opts.AuthorName == "" || opts.AuthorEmail == "" {
+			return nil, fmt.Errorf("author override requires both a name and an email")
+		}
+		args = append(args, fmt.Sprintf("--author=%s <%s>", opts.AuthorName, opts.AuthorEmail))
+	}
+	if opts.SignOff {
+		args = append(args, "--signoff")
+	}
+	return args, nil
+}
+
+// push runs `git push`. Pushing stays on the git binary so the daemon owner's
+// credential helpers and SSH config keep working.
+func push(repoPath string, opts CommitOptions, args ...string) (string, error) {
+	if opts.Progress != nil {
+		args = append([]string{"--progress"}, args...)
+	}
+	cmdPush := Command(repoPath, append([]string{"push"}, args...)...)
+	if opts.SSHCommand != "" {
+		cmdPush.Env = append(cmdPush.Env, "GIT_SSH_COMMAND="+opts.SSHCommand)
+	}
+	cmdPush.Abort = opts.Abort
+	cmdPush.Progress = opts.Progress
+	out, err := cmdPush.CombinedOutput()
+	if err != nil {
+		return string(out), fmt.Errorf("git push failed: %w", err)
+	}
+	return string(out), nil
+}
+
+// pushArgs builds the `git push` arguments for pushing branch to remote.
+// Neither is taken for an option, even if it starts with "-".
+func pushArgs(remote, branch string, opts CommitOptions) []string {
+	if opts.SetUpstream {
+		return []string{"-u", "--end-of-options", remote, branch}
+	}
+	return []string{"--end-of-options", remote, branch}
+}
+
+// signedCommit creates a signed commit of the index with the git binary,
+// since go-git can only sign with an in-memory OpenPGP key.
+func signedCommit(repoPath, commitMessage string, amend bool, opts CommitOptions) (string, error) {
+	args, err := commitArgs(commitMessage, opts)
 	if err != nil {
-		return string(out), fmt.Errorf("git add failed: %w", err)
+		return err.Error(), err
 	}
+	if amend {
+		args = append(args, "--amend")
+		if commitMessage == "" {
+			args = append(args, "--no-edit")
+		}
+	}
+	// pre-commit has already been run according to the repo's hook policy
+	args = append(args, "--no-verify")
 
-	// Step 2: Git Commit
-	cmdCommit := exec.Command("git", "commit", "-m", commitMessage)
-	cmdCommit.Dir = repoPath
-	out, err = cmdCommit.CombinedOutput()
+	cmdCommit := Command(repoPath, args...)
+	out, err := cmdCommit.CombinedOutput()
 	if err != nil {
-		// If there's nothing to commit, it's not a fatal error for our use case.
-		// We can check the output for "nothing to commit".
 		if strings.Contains(string(out), "nothing to commit") {
-			return "Working tree is clean. Nothing to commit.", nil
+			return string(out), ErrNothingToCommit
 		}
 		return string(out), fmt.Errorf("git commit failed: %w", err)
 	}
+	return string(out), nil
+}
+
+// createCommit stages all changes unless opts.StagedOnly is set, scans them
+// for secrets if asked to, runs the pre-commit hook and commits, in-process unless the commit must be signed.
+// The returned output includes the hook's report.
+func createCommit(repoPath, commitMessage string, amend bool, opts CommitOptions) (string, error) {
+	if (opts.AuthorName == "") != (opts.AuthorEmail == "") {
+		err := fmt.Errorf("author override requires both a name and an email")
+		return err.Error(), err
+	}
+	if !opts.StagedOnly {
+		if err := stageAll(repoPath); err != nil {
+			return err.Error(), err
+		}
+	}
+
+	if opts.ScanSecrets {
+		findings, err := ScanStaged(repoPath)
+		if err != nil {
+			return err.Error(), err
+		}
+		if len(findings) > 0 {
+			err := &SecretsFoundError{Findings: findings}
+			return "Commit aborted: " + err.Error() + ".", err
+		}
+	}
 
-	// Step 3: Git Push
-	cmdPush := exec.Command("git", "push", remote, branch)
-	cmdPush.Dir = repoPath
-	out, err = cmdPush.CombinedOutput()
+	policy := opts.Hooks
+	if policy == "" {
+		policy = HooksWarn
+	}
+	hookOut, err := runPreCommit(repoPath, policy)
 	if err != nil {
-		return string(out), fmt.Errorf("git push failed: %w", err)
+		return hookOut + "Commit aborted by the repository's hook policy.", err
 	}
 
-	return fmt.Sprintf("Successfully pushed to %s/%s\n%s", remote, branch, string(out)), nil
+	if opts.Sign {
+		out, err := signedCommit(repoPath, commitMessage, amend, opts)
+		return hookOut + out, err
+	}
+	if err := commit(repoPath, commitMessage, amend, opts); err != nil {
+		return hookOut + err.Error(), err
+	}
+	return hookOut, nil
+}
+
+// CommitAndPush stages all changes, commits them and pushes the branch.
+func CommitAndPush(repoPath, commitMessage, remote, branch string, opts CommitOptions) (string, error) {
+	hookOut, err := createCommit(repoPath, commitMessage, false, opts)
+	if err != nil {
+		// If there's nothing to commit, it's not a fatal error for our use case.
+		if errors.Is(err, ErrNothingToCommit) {
+			if opts.StagedOnly {
+				return "Nothing staged. Nothing to commit.", nil
+			}
+			return "Working tree is clean. Nothing to commit.", nil
+		}
+		return hookOut, err
+	}
+
+	out, err := push(repoPath, opts, pushArgs(remote, branch, opts)...)
+	if err != nil {
+		return hookOut + out, err
+	}
+	return fmt.Sprintf("%sSuccessfully pushed to %s/%s\n%s", hookOut, remote, branch, out), nil
+}
+
+// AmendAndPush stages all changes, amends the HEAD commit and pushes it.
+// An empty commitMessage keeps the existing message. Amending a commit that
+// is already on the remote is refused unless force is set, in which case the
+// push uses --force-with-lease.
+func AmendAndPush(repoPath, commitMessage, remote, branch string, force bool, opts CommitOptions) (string, error) {
+	pushed, err := IsHeadPushed(repoPath)
+	if err != nil {
+		return "", err
+	}
+	if pushed && !force {
+		return "HEAD has already been pushed. Refusing to amend without force.", fmt.Errorf("commit already pushed")
+	}
+
+	hookOut, err := createCommit(repoPath, commitMessage, true, opts)
+	if err != nil {
+		return hookOut, err
+	}
+
+	// Rewrite the remote commit if it was already pushed
+	args := pushArgs(remote, branch, opts)
+	if pushed {
+		args = append([]string{"--force-with-lease"}, args...)
+	}
+	out, err := push(repoPath, opts, args...)
+	if err != nil {
+		return hookOut + out, err
+	}
+	return fmt.Sprintf("%sSuccessfully amended and pushed to %s/%s\n%s", hookOut, remote, branch, out), nil
+}
+
+// VerifySignatures fills in the Signature status of signed commits using
+// `git log --format=%G?`, since verification needs the owner's GPG/SSH
+// trust setup. Unsigned commits are reported as "N".
+func VerifySignatures(repoPath string, commits []Commit) error {
+	var hashes []string
+	for i := range commits {
+		commits[i].Signature = "N"
+		if commits[i].Signed {
+			hashes = append(hashes, commits[i].Hash)
+		}
+	}
+	if len(hashes) == 0 {
+		return nil
+	}
+
+	args := append([]string{"log", "--no-walk=unsorted", "--format=%H %G?"}, hashes...)
+	cmd := Command(repoPath, args...)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("git log failed: %s", strings.TrimSpace(string(out)))
+	}
+	status := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		if hash, sig, found := strings.Cut(line, " "); found {
+			status[hash] = sig
+		}
+	}
+	for i := range commits {
+		if sig, ok := status[commits[i].Hash]; ok {
+			commits[i].Signature = sig
+		}
+	}
+	return nil
+}
+
+// FileLog returns the last limit commits that touched path, newest first.
+// It follows the file across renames, which go-git's log can't do, and
+// records the file's name at each commit in Commit.Path.
+func FileLog(repoPath, path string, limit int) ([]Commit, error) {
+	cmd := Command(repoPath, "log", "--follow", "--name-only", fmt.Sprintf("-n%d", limit),
+		"--format=%x1e%H%x1f%h%x1f%an%x1f%ae%x1f%aI%x1f%s", "--", path)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("git log failed: %s", strings.TrimSpace(string(out)))
+	}
+
+	var commits []Commit
+	for _, record := range strings.Split(string(out), "\x1e") {
+		header, names, _ := strings.Cut(record, "\n")
+		fields := strings.Split(header, "\x1f")
+		if len(fields) != 6 {
+			continue
+		}
+		c := Commit{
+			Hash:        fields[0],
+			AbbrevHash:  fields[1],
+			AuthorName:  fields[2],
+			AuthorEmail: fields[3],
+			Subject:     fields[5],
+			Path:        path,
+		}
+		c.Date, _ = time.Parse(time.RFC3339, fields[4])
+		for _, name := range strings.Split(names, "\n") {
+			if name = strings.TrimSpace(name); name != "" {
+				c.Path = name
+				break
+			}
+		}
+		commits = append(commits, c)
+	}
+	return commits, nil
+}
+
+// gitDiffPatch runs `git diff` on a single file for the options go-git
+// can't render. The output is colorized to match Diff's other patches.
+func gitDiffPatch(repoPath, name string, opts DiffOptions) (string, error) {
+	args := []string{"diff", "--color=always", fmt.Sprintf("-U%d", opts.contextLines())}
+	if opts.WordDiff {
+		args = append(args, "--word-diff=plain")
+	}
+	if opts.IgnoreAllSpace {
+		args = append(args, "--ignore-all-space")
+	}
+	args = append(args, "--", name)
+
+	cmd := Command(repoPath, args...)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("git diff failed: %s", strings.TrimSpace(string(out)))
+	}
+	return string(out), nil
+}
+
+// CommitTemplate returns the contents of the file configured as git's
+// commit.template, without comment lines, or "" when none is set.
+func CommitTemplate(repoPath string) (string, error) {
+	cmd := Command(repoPath, "config", "--path", "commit.template")
+	out, err := cmd.Output()
+	if err != nil {
+		// Exit code 1 just means the key isn't set
+		return "", nil
+	}
+	path := strings.TrimSpace(string(out))
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(repoPath, path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read commit template: %w", err)
+	}
+
+	var lines []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if !strings.HasPrefix(line, "#") {
+			lines = append(lines, line)
+		}
+	}
+	return strings.TrimRight(strings.Join(lines, "\n"), "\n"), nil
+}
+
+// Stash is a single entry from `git stash list`.
+type Stash struct {
+	Index   int
+	Ref     string // e.g. "stash@{0}"
+	Branch  string
+	Message string
+	Date    time.Time
+}
+
+// StashList returns the repository's stash entries, newest first.
+func StashList(repoPath string) ([]Stash, error) {
+	cmd := Command(repoPath, "stash", "list", "--format=%gd%x1f%gs%x1f%cI")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("git stash list failed: %s", strings.TrimSpace(string(out)))
+	}
+
+	var stashes []Stash
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		fields := strings.Split(line, "\x1f")
+		if len(fields) != 3 {
+			continue
+		}
+		stash := Stash{Ref: fields[0], Message: fields[1]}
+		fmt.Sscanf(fields[0], "stash@{%d}", &stash.Index)
+		stash.Date, _ = time.Parse(time.RFC3339, fields[2])
+
+		// The reflog subject looks like "On <branch>: <msg>" or "WIP on <branch>: <hash> <msg>"
+		if subject, msg, found := strings.Cut(fields[1], ": "); found {
+			subject = strings.TrimPrefix(subject, "WIP ")
+			stash.Branch = strings.TrimPrefix(subject, "On ")
+			stash.Branch = strings.TrimPrefix(stash.Branch, "on ")
+			stash.Message = msg
+		}
+		stashes = append(stashes, stash)
+	}
+	return stashes, nil
+}
+
+// ReflogEntry is a single entry from HEAD's reflog.
+type ReflogEntry struct {
+	Index   int // n in HEAD@{n}
+	Hash    string
+	Action  string // e.g. "commit", "reset", "rebase (finish)"
+	Message string
+	Date    time.Time
+}
+
+// Reflog returns up to limit entries of HEAD's reflog, newest first.
+func Reflog(repoPath string, limit int) ([]ReflogEntry, error) {
+	// With a date format, %gd prints HEAD@{<date>} instead of HEAD@{n}; the
+	// index is just the line number
+	cmd := Command(repoPath, "reflog", "show", "--date=iso-strict",
+		"--format=%H%x1f%gd%x1f%gs", "-n", strconv.Itoa(limit), "HEAD")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("git reflog failed: %s", strings.TrimSpace(string(out)))
+	}
+
+	var entries []ReflogEntry
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		fields := strings.Split(line, "\x1f")
+		if len(fields) != 3 {
+			continue
+		}
+		entry := ReflogEntry{Index: len(entries), Hash: fields[0], Message: fields[2]}
+		date := strings.TrimSuffix(strings.TrimPrefix(fields[1], "HEAD@{"), "}")
+		entry.Date, _ = time.Parse(time.RFC3339, date)
+		// Subjects look like "reset: moving to HEAD~1" or "commit (amend): msg"
+		if action, msg, found := strings.Cut(fields[2], ": "); found {
+			entry.Action, entry.Message = action, msg
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// ResetToReflog hard-resets the current branch to the commit HEAD@{index}
+// points at. expectedHash must match that commit, so a reflog that moved
+// since it was listed can't send the branch somewhere unexpected. The
+// reset itself is recorded in the reflog and can be undone the same way.
+func ResetToReflog(repoPath string, index int, expectedHash string) (string, error) {
+	hash, err := gitOutput(repoPath, "rev-parse", "--verify", "--quiet", fmt.Sprintf("HEAD@{%d}", index))
+	if err != nil {
+		return "", fmt.Errorf("no reflog entry HEAD@{%d}", index)
+	}
+	if hash != expectedHash {
+		return "", fmt.Errorf("HEAD@{%d} now points at %.7s, not %.7s; the reflog has changed, list it again", index, hash, expectedHash)
+	}
+
+	cmd := Command(repoPath, "reset", "--hard", hash)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return string(out), fmt.Errorf("git reset failed: %s", strings.TrimSpace(string(out)))
+	}
+	return string(out), nil
+}
+
+// cleanArgs builds the `git clean` flags shared by CleanPreview and Clean.
+func cleanArgs(mode string, directories, ignored bool) []string {
+	args := []string{"clean", mode}
+	if directories {
+		args = append(args, "-d")
+	}
+	if ignored {
+		args = append(args, "-x")
+	}
+	return args
+}
+
+// CleanPreview lists the untracked paths `git clean` would remove, without
+// deleting anything.
+func CleanPreview(repoPath string, directories, ignored bool) ([]string, error) {
+	cmd := Command(repoPath, cleanArgs("-n", directories, ignored)...)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("git clean -n failed: %s", strings.TrimSpace(string(out)))
+	}
+
+	var paths []string
+	for _, line := range strings.Split(string(out), "\n") {
+		// Lines look like "Would remove build/output.bin"
+		if path, found := strings.CutPrefix(line, "Would remove "); found {
+			paths = append(paths, path)
+		}
+	}
+	return paths, nil
+}
+
+// Clean deletes untracked paths with `git clean -f`.
+func Clean(repoPath string, directories, ignored bool) (string, error) {
+	cmd := Command(repoPath, cleanArgs("-f", directories, ignored)...)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return string(out), fmt.Errorf("git clean failed: %w", err)
+	}
+	return string(out), nil
+}
+
+// DiscardFile throws away all uncommitted changes to a single tracked path,
+// both staged and unstaged, restoring it to its HEAD version.
+func DiscardFile(repoPath, path string) (string, error) {
+	// Untracked files have no HEAD version to restore; that's what clean is for
+	cmd := Command(repoPath, "ls-files", "--error-unmatch", "--", path)
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("'%s' is not tracked by git", path)
+	}
+
+	cmd = Command(repoPath, "checkout", "HEAD", "--", path)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return string(out), fmt.Errorf("git checkout failed: %s", strings.TrimSpace(string(out)))
+	}
+	return string(out), nil
+}
+
+// RemovePath deletes a file or directory with `git rm -r`, staging the
+// deletion. Untracked paths, and tracked files with uncommitted changes, are
+// only deleted when force is set, since they can't be recovered from git.
+func RemovePath(repoPath, path string, force bool) (string, error) {
+	clean := filepath.Clean(path)
+	if clean == "." || clean == ".git" || strings.HasPrefix(clean, ".git"+string(filepath.Separator)) {
+		return "", fmt.Errorf("refusing to delete '%s'", path)
+	}
+	full := filepath.Join(repoPath, clean)
+	if _, err := os.Lstat(full); err != nil {
+		return "", fmt.Errorf("'%s' does not exist", path)
+	}
+
+	cmd := Command(repoPath, "ls-files", "--error-unmatch", "--", clean)
+	tracked := cmd.Run() == nil
+	if !tracked && !force {
+		return "", fmt.Errorf("'%s' is not tracked by git, so deleting it can't be undone; use force to delete it anyway", path)
+	}
+
+	var out []byte
+	if tracked {
+		args := []string{"rm", "-r"}
+		if force {
+			args = append(args, "-f")
+		}
+		cmd = Command(repoPath, append(args, "--", clean)...)
+		var err error
+		if out, err = cmd.CombinedOutput(); err != nil {
+			return string(out), fmt.Errorf("git rm failed: %s", strings.TrimSpace(string(out)))
+		}
+	}
+	// git rm leaves untracked files behind in a directory
+	if force {
+		if err := os.RemoveAll(full); err != nil {
+			return string(out), fmt.Errorf("failed to delete '%s': %w", path, err)
+		}
+	}
+	return string(out), nil
+}
+
+// Chmod changes the permissions of a file and returns the new ones. mode is
+// "+x" or "-x" to toggle the executable bit, the only permission git records,
+// or an octal mode like "644". When core.fileMode is off git doesn't notice
+// mode changes on disk, so for tracked files the executable bit is also set
+// in the index.
+func Chmod(repoPath, path, mode string) (os.FileMode, error) {
+	full := filepath.Join(repoPath, path)
+	info, err := os.Stat(full)
+	if err != nil {
+		return 0, fmt.Errorf("'%s' does not exist", path)
+	}
+	if !info.Mode().IsRegular() {
+		return 0, fmt.Errorf("'%s' is not a regular file", path)
+	}
+
+	perm := info.Mode().Perm()
+	switch mode {
+	case "+x":
+		// Like chmod +x under the usual umask: executable by whoever can read it
+		perm |= (perm & 0444) >> 2
+	case "-x":
+		perm &^= 0111
+	default:
+		n, err := strconv.ParseUint(mode, 8, 32)
+		if err != nil || n > 0777 {
+			return 0, fmt.Errorf("invalid mode '%s': use +x, -x or an octal mode like 755", mode)
+		}
+		perm = os.FileMode(n)
+	}
+	if err := os.Chmod(full, perm); err != nil {
+		return 0, fmt.Errorf("chmod failed: %w", err)
+	}
+
+	if fileMode, err := gitOutput(repoPath, "config", "--bool", "core.fileMode"); err == nil && fileMode == "false" {
+		if _, err := gitOutput(repoPath, "ls-files", "--error-unmatch", "--", path); err == nil {
+			flag := "--chmod=-x"
+			if perm&0100 != 0 {
+				flag = "--chmod=+x"
+			}
+			if _, err := gitOutput(repoPath, "update-index", flag, "--", path); err != nil {
+				return perm, err
+			}
+		}
+	}
+	return perm, nil
+}
+
+// Worktree is a single checkout from `git worktree list`.
+type Worktree struct {
+	Path     string
+	Head     string
+	Branch   string // Short branch name, empty when detached
+	Bare     bool
+	Detached bool
+}
+
+// Worktrees lists the main checkout and all linked worktrees of a repository.
+func Worktrees(repoPath string) ([]Worktree, error) {
+	cmd := Command(repoPath, "worktree", "list", "--porcelain")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("git worktree list failed: %s", strings.TrimSpace(string(out)))
+	}
+
+	// Porcelain output is one block of "key value" lines per worktree, separated by blank lines
+	var worktrees []Worktree
+	var current *Worktree
+	for _, line := range strings.Split(string(out), "\n") {
+		key, value, _ := strings.Cut(line, " ")
+		switch key {
+		case "worktree":
+			worktrees = append(worktrees, Worktree{Path: value})
+			current = &worktrees[len(worktrees)-1]
+		case "HEAD":
+			if current != nil {
+				current.Head = value
+			}
+		case "branch":
+			if current != nil {
+				current.Branch = strings.TrimPrefix(value, "refs/heads/")
+			}
+		case "bare":
+			if current != nil {
+				current.Bare = true
+			}
+		case "detached":
+			if current != nil {
+				current.Detached = true
+			}
+		}
+	}
+	return worktrees, nil
+}
+
+// AddWorktree checks out branch into a new worktree at path. With newBranch
+// set, the branch is created from the current HEAD.
+func AddWorktree(repoPath, path, branch string, newBranch bool) (string, error) {
+	args := []string{"worktree", "add"}
+	if newBranch {
+		args = append(args, "-b", branch, "--", path)
+	} else {
+		args = append(args, "--", path, branch)
+	}
+	cmd := Command(repoPath, args...)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return string(out), fmt.Errorf("git worktree add failed: %w", err)
+	}
+	return string(out), nil
+}
+
+// FileHash returns the git blob hash of a file's content as it is on disk.
+func FileHash(repoPath, path string) (string, error) {
+	// --no-filters hashes the bytes as written, without autocrlf and the like
+	return gitOutput(repoPath, "hash-object", "--no-filters", "--", path)
+}
+
+// StatusLine returns the `git status --porcelain` line of a single path, or
+// "" when git sees no change to it.
+func StatusLine(repoPath, path string) (string, error) {
+	cmd := Command(repoPath, "status", "--porcelain", "--untracked-files=all", "--", path)
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git status failed: %w", err)
+	}
+	return strings.TrimRight(string(out), "\n"), nil
+}
+
+// GitPath resolves a path inside the repository's git directory, like
+// `git rev-parse --git-path`, so it also works in worktrees.
+func GitPath(repoPath, name string) (string, error) {
+	p, err := gitOutput(repoPath, "rev-parse", "--git-path", name)
+	if err != nil {
+		return "", err
+	}
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(repoPath, p)
+	}
+	return p, nil
 }
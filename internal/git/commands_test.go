@@ -0,0 +1,16 @@
+package git
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestPushArgsEndOptions(t *testing.T) {
+	for _, opts := range []CommitOptions{{}, {SetUpstream: true}} {
+		args := pushArgs("-x", "--y", opts)
+		i := slices.Index(args, "--end-of-options")
+		if i < 0 || !slices.Equal(args[i+1:], []string{"-x", "--y"}) {
+			t.Errorf("with %+v: got %q, want the remote and branch after --end-of-options", opts, args)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package git
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// ConfigEntry is a single git config setting.
+type ConfigEntry struct {
+	Key   string
+	Value string
+	Scope string // "local", "global", "system", ...
+}
+
+// configKeys are the settings clients may read and change. Many git settings
+// make git run a command (core.sshCommand, core.hooksPath, filter drivers,
+// credential helpers...), so anything not listed here stays under the daemon
+// owner's control.
+var configKeys = map[string]bool{
+	"user.name":            true,
+	"user.email":           true,
+	"user.signingkey":      true,
+	"commit.gpgsign":       true,
+	"tag.gpgsign":          true,
+	"gpg.format":           true,
+	"pull.rebase":          true,
+	"pull.ff":              true,
+	"push.default":         true,
+	"push.autosetupremote": true,
+	"push.followtags":      true,
+	"fetch.prune":          true,
+	"rebase.autostash":     true,
+	"rebase.autosquash":    true,
+	"merge.ff":             true,
+	"merge.conflictstyle":  true,
+	"core.autocrlf":        true,
+	"core.eol":             true,
+	"core.filemode":        true,
+	"core.ignorecase":      true,
+	"init.defaultbranch":   true,
+	"diff.renames":         true,
+}
+
+// configSubsectionKeys are allowed settings of named subsections, like
+// branch.<name>.remote, keyed by "<section>.<variable>". A remote's URLs
+// aren't: they can hold credentials, and changing one sends the daemon's
+// pushes, with its owner's credentials, wherever a peer likes.
+var configSubsectionKeys = map[string]bool{
+	"branch.remote": true,
+	"branch.merge":  true,
+	"branch.rebase": true,
+	"remote.fetch":  true,
+}
+
+// ConfigKeyAllowed reports whether clients may read and change key.
+func ConfigKeyAllowed(key string) bool {
+	first := strings.Index(key, ".")
+	last := strings.LastIndex(key, ".")
+	if first <= 0 || last == len(key)-1 {
+		return false
+	}
+	// Section and variable names are case-insensitive, subsections are not
+	section, variable := strings.ToLower(key[:first]), strings.ToLower(key[last+1:])
+	if first == last {
+		return configKeys[section+"."+variable]
+	}
+	return configSubsectionKeys[section+"."+variable]
+}
+
+// GetConfig returns the effective value of key and the scope it comes from.
+// found is false when the key isn't set anywhere.
+func GetConfig(repoPath, key string) (entry ConfigEntry, found bool, err error) {
+	if !ConfigKeyAllowed(key) {
+		return entry, false, fmt.Errorf("config key '%s' is not allowed", key)
+	}
+	cmd := Command(repoPath, "config", "--show-scope", "--get", key)
+	out, err := cmd.Output()
+	if err != nil {
+		// Exit code 1 just means the key isn't set
+		if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
+			return entry, false, nil
+		}
+		return entry, false, fmt.Errorf("git config failed: %w", err)
+	}
+	// "<scope>\t<value>"
+	scope, value, _ := strings.Cut(strings.TrimRight(string(out), "\n"), "\t")
+	return ConfigEntry{Key: key, Value: value, Scope: scope}, true, nil
+}
+
+// ListConfig returns the effective values of every allowed setting that is
+// set, in any scope.
+func ListConfig(repoPath string) ([]ConfigEntry, error) {
+	cmd := Command(repoPath, "config", "--show-scope", "--list")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("git config failed: %s", strings.TrimSpace(string(out)))
+	}
+
+	// Later lines override earlier ones (system < global < local)
+	var entries []ConfigEntry
+	index := make(map[string]int)
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		// "<scope>\t<key>=<value>"
+		scope, kv, _ := strings.Cut(line, "\t")
+		key, value, _ := strings.Cut(kv, "=")
+		if !ConfigKeyAllowed(key) {
+			continue
+		}
+		entry := ConfigEntry{Key: key, Value: value, Scope: scope}
+		if i, ok := index[key]; ok {
+			entries[i] = entry
+			continue
+		}
+		index[key] = len(entries)
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// SetConfig sets key in the repository's local config.
+func SetConfig(repoPath, key, value string) error {
+	if !ConfigKeyAllowed(key) {
+		return fmt.Errorf("config key '%s' is not allowed", key)
+	}
+	return localConfig(repoPath, key, key, value)
+}
+
+// UnsetConfig removes key from the repository's local config.
+func UnsetConfig(repoPath, key string) error {
+	if !ConfigKeyAllowed(key) {
+		return fmt.Errorf("config key '%s' is not allowed", key)
+	}
+	return localConfig(repoPath, key, "--unset", key)
+}
+
+// localConfig runs `git config --local` with args.
+func localConfig(repoPath, key string, args ...string) error {
+	cmd := Command(repoPath, append([]string{"config", "--local"}, args...)...)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		// For --unset, exit code 5 means there was nothing to unset
+		if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 5 && args[0] == "--unset" {
+			return fmt.Errorf("'%s' is not set in the repository's config", key)
+		}
+		return fmt.Errorf("git config failed: %s", strings.TrimSpace(string(out)))
+	}
+	return nil
+}
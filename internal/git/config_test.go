@@ -0,0 +1,87 @@
+package git
+
+import "testing"
+
+func TestConfigKeyAllowed(t *testing.T) {
+	allowed := []string{
+		"user.name",
+		"user.email",
+		"user.signingkey",
+		"commit.gpgsign",
+		"tag.gpgsign",
+		"gpg.format",
+		"pull.rebase",
+		"pull.ff",
+		"push.default",
+		"push.autosetupremote",
+		"push.followtags",
+		"fetch.prune",
+		"rebase.autostash",
+		"rebase.autosquash",
+		"merge.ff",
+		"merge.conflictstyle",
+		"core.autocrlf",
+		"core.eol",
+		"core.filemode",
+		"core.ignorecase",
+		"init.defaultbranch",
+		"diff.renames",
+		"branch.main.remote",
+		"branch.main.merge",
+		"branch.main.rebase",
+		"branch.feature/x.merge",
+		"remote.origin.fetch",
+		// Section and variable names are case-insensitive
+		"User.Name",
+		"core.autoCRLF",
+		"Branch.Main.Remote",
+	}
+	refused := []string{
+		// Run commands
+		"core.sshcommand",
+		"core.hookspath",
+		"core.editor",
+		"core.pager",
+		"core.fsmonitor",
+		"gpg.program",
+		"credential.helper",
+		"alias.x",
+		"filter.lfs.clean",
+		"diff.x.textconv",
+		"merge.x.driver",
+		"remote.origin.receivepack",
+		"remote.origin.uploadpack",
+		"remote.origin.proxy",
+		"url.https://evil.example/.insteadof",
+		// Hold credentials or redirect pushes
+		"remote.origin.url",
+		"remote.origin.pushurl",
+		"Remote.Origin.URL",
+		"http.extraheader",
+		"include.path",
+		"includeif.gitdir:/x.path",
+		// A subsection variable without its subsection, or the reverse
+		"branch.remote",
+		"remote.fetch",
+		"user.x.name",
+		// Malformed
+		"",
+		"user",
+		".name",
+		"user.",
+		"user.name.",
+	}
+	for _, key := range allowed {
+		if !ConfigKeyAllowed(key) {
+			t.Errorf("%q is refused, want it allowed", key)
+		}
+	}
+	for _, key := range refused {
+		if ConfigKeyAllowed(key) {
+			t.Errorf("%q is allowed, want it refused", key)
+		}
+	}
+	if n := len(configKeys) + len(configSubsectionKeys); n != 26 {
+		t.Errorf("%d keys are allowed; add any new one to this test", n)
+	}
+}
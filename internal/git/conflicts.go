@@ -0,0 +1,248 @@
+package git
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// ConflictHunk is one conflicting region of a file.
+type ConflictHunk struct {
+	Line   int // 1-based line of the region in the merged output
+	Ours   string
+	Base   string
+	Theirs string
+}
+
+// Conflict is an unmerged file and its conflicting regions.
+type Conflict struct {
+	Path  string
+	Hunks []ConflictHunk
+}
+
+// ConflictedFiles lists the paths that are currently unmerged.
+func ConflictedFiles(repoPath string) ([]string, error) {
+	cmd := Command(repoPath, "diff", "--name-only", "--diff-filter=U")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("git diff failed: %s", strings.TrimSpace(string(out)))
+	}
+	var paths []string
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		if line != "" {
+			paths = append(paths, line)
+		}
+	}
+	return paths, nil
+}
+
+// Conflicts returns every unmerged file with its ours/base/theirs hunks. The
+// hunks are computed from the index stages rather than the working file, so
+// they are complete even if the file has been partly edited since.
+func Conflicts(repoPath string) ([]Conflict, error) {
+	paths, err := ConflictedFiles(repoPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var conflicts []Conflict
+	for _, path := range paths {
+		hunks, err := conflictHunks(repoPath, path)
+		if err != nil {
+			return nil, err
+		}
+		conflicts = append(conflicts, Conflict{Path: path, Hunks: hunks})
+	}
+	return conflicts, nil
+}
+
+// conflictHunks re-runs the three-way merge of path with diff3-style markers
+// and parses the conflicting regions.
+func conflictHunks(repoPath, path string) ([]ConflictHunk, error) {
+	tmpDir, err := os.MkdirTemp("", "p2p-conflict-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// Stages 1, 2 and 3 are base, ours and theirs. A missing stage (the file
+	// was added or deleted on one side) is treated as empty.
+	var files []string
+	for i, stage := range []string{"2", "1", "3"} {
+		cmd := Command(repoPath, "show", ":"+stage+":"+path)
+		content, _ := cmd.Output()
+		file := filepath.Join(tmpDir, fmt.Sprintf("stage%d", i))
+		if err := os.WriteFile(file, content, 0600); err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+
+	// merge-file exits with the number of conflicts (capped at 127); higher codes are errors
+	args := append([]string{"merge-file", "-p", "--diff3", "-L", "ours", "-L", "base", "-L", "theirs"}, files...)
+	cmd := Command(repoPath, args...)
+	out, err := cmd.Output()
+	if exitErr, ok := err.(*exec.ExitError); err != nil && (!ok || exitErr.ExitCode() > 127) {
+		return nil, fmt.Errorf("git merge-file failed for %s: %w", path, err)
+	}
+	return parseConflictMarkers(string(out)), nil
+}
+
+// parseConflictMarkers extracts the regions between diff3 conflict markers.
+func parseConflictMarkers(merged string) []ConflictHunk {
+	var hunks []ConflictHunk
+	var current *ConflictHunk
+	var section *strings.Builder
+	var ours, base, theirs strings.Builder
+
+	for i, line := range strings.SplitAfter(merged, "\n") {
+		switch {
+		case strings.HasPrefix(line, "<<<<<<< "):
+			current = &ConflictHunk{Line: i + 1}
+			ours.Reset()
+			base.Reset()
+			theirs.Reset()
+			section = &ours
+		case current != nil && strings.HasPrefix(line, "||||||| "):
+			section = &base
+		case current != nil && strings.TrimRight(line, "\n") == "=======":
+			section = &theirs
+		case current != nil && strings.HasPrefix(line, ">>>>>>> "):
+			current.Ours, current.Base, current.Theirs = ours.String(), base.String(), theirs.String()
+			hunks = append(hunks, *current)
+			current = nil
+		case current != nil:
+			section.WriteString(line)
+		}
+	}
+	return hunks
+}
+
+// ResolveConflict resolves an unmerged path by taking one side ("ours" or
+// "theirs") and staging the result. If that side deleted the file, the
+// deletion is staged instead.
+func ResolveConflict(repoPath, path, side string) (string, error) {
+	if side != "ours" && side != "theirs" {
+		return "", fmt.Errorf("unknown resolution '%s'", side)
+	}
+	stage := "2"
+	if side == "theirs" {
+		stage = "3"
+	}
+
+	check := Command(repoPath, "cat-file", "-e", ":"+stage+":"+path)
+	var cmd *Cmd
+	if check.Run() != nil {
+		cmd = Command(repoPath, "rm", "--quiet", "--", path)
+	} else {
+		checkout := Command(repoPath, "checkout", "--"+side, "--", path)
+		if out, err := checkout.CombinedOutput(); err != nil {
+			return string(out), fmt.Errorf("git checkout --%s failed: %w", side, err)
+		}
+		cmd = Command(repoPath, "add", "--", path)
+	}
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return string(out), fmt.Errorf("staging %s failed: %w", path, err)
+	}
+	return fmt.Sprintf("Resolved '%s' using %s.", path, side), nil
+}
+
+// MarkResolved stages a path whose conflict was fixed by hand.
+func MarkResolved(repoPath, path string) (string, error) {
+	cmd := Command(repoPath, "add", "--", path)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return string(out), fmt.Errorf("git add failed: %w", err)
+	}
+	return fmt.Sprintf("Marked '%s' as resolved.", path), nil
+}
+
+// ContinueMerge finishes the merge, rebase, cherry-pick or revert that stopped
+// on conflicts, once every conflict is resolved. It returns the name of the
+// operation that was continued, or "" when nothing was in progress (e.g. after
+// a conflicting stash pop, where the resolved changes simply stay staged).
+func ContinueMerge(repoPath string) (string, string, error) {
+	remaining, err := ConflictedFiles(repoPath)
+	if err != nil {
+		return "", "", err
+	}
+	if len(remaining) > 0 {
+		return "", "Unresolved conflicts remain:\n" + strings.Join(remaining, "\n"), fmt.Errorf("%d unresolved conflicts", len(remaining))
+	}
+
+	// core.editor=true accepts the prepared commit messages without prompting
+	operations := []struct {
+		marker string
+		name   string
+		args   []string
+	}{
+		{"MERGE_HEAD", "merge", []string{"commit", "--no-edit"}},
+		{"rebase-merge", "rebase", []string{"-c", "core.editor=true", "rebase", "--continue"}},
+		{"rebase-apply", "rebase", []string{"-c", "core.editor=true", "rebase", "--continue"}},
+		{"CHERRY_PICK_HEAD", "cherry-pick", []string{"-c", "core.editor=true", "cherry-pick", "--continue"}},
+		{"REVERT_HEAD", "revert", []string{"-c", "core.editor=true", "revert", "--continue"}},
+	}
+	for _, op := range operations {
+		if !gitPathExists(repoPath, op.marker) {
+			continue
+		}
+		cmd := Command(repoPath, op.args...)
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			return op.name, string(out), fmt.Errorf("git %s --continue failed: %w", op.name, err)
+		}
+		return op.name, string(out), nil
+	}
+	return "", "No merge in progress. Resolved changes are staged.", nil
+}
+
+// gitPathExists reports whether a file inside the repository's git directory
+// exists, resolving it per-worktree like git does.
+func gitPathExists(repoPath, name string) bool {
+	cmd := Command(repoPath, "rev-parse", "--git-path", name)
+	out, err := cmd.Output()
+	if err != nil {
+		return false
+	}
+	p := strings.TrimSpace(string(out))
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(repoPath, p)
+	}
+	_, err = os.Stat(p)
+	return err == nil
+}
+
+// MergeText runs a three-way merge of three versions of a file's content,
+// like `git merge-file`, without needing a repository. It returns the merged
+// content, with conflict markers labelled oursName and theirsName, and the
+// number of conflicts left in it.
+func MergeText(ours, base, theirs []byte, oursName, theirsName string) ([]byte, int, error) {
+	tmpDir, err := os.MkdirTemp("", "p2p-merge-*")
+	if err != nil {
+		return nil, 0, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	var files []string
+	for i, content := range [][]byte{ours, base, theirs} {
+		file := filepath.Join(tmpDir, fmt.Sprintf("version%d", i))
+		if err := os.WriteFile(file, content, 0600); err != nil {
+			return nil, 0, err
+		}
+		files = append(files, file)
+	}
+
+	// merge-file exits with the number of conflicts (capped at 127); higher codes are errors
+	args := append([]string{"merge-file", "-p", "-L", oursName, "-L", "base", "-L", theirsName}, files...)
+	out, err := Command("", args...).Output()
+	if err == nil {
+		return out, 0, nil
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() <= 127 {
+		return out, exitErr.ExitCode(), nil
+	}
+	return nil, 0, fmt.Errorf("git merge-file failed: %w", err)
+}
@@ -0,0 +1,211 @@
+package git
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// defaultPath is the PATH git runs with when the daemon has none, as under
+// some service managers.
+const defaultPath = "/usr/local/bin:/usr/bin:/bin"
+
+// repoEnvVars point git at another repository, index or object store than
+// the one a command runs in (see `git rev-parse --local-env-vars`). The
+// daemon may have been started from a git hook or alias that set them.
+var repoEnvVars = map[string]bool{
+	"GIT_ALTERNATE_OBJECT_DIRECTORIES": true,
+	"GIT_COMMON_DIR":                   true,
+	"GIT_CONFIG":                       true,
+	"GIT_CONFIG_COUNT":                 true,
+	"GIT_CONFIG_PARAMETERS":            true,
+	"GIT_DIR":                          true,
+	"GIT_GRAFT_FILE":                   true,
+	"GIT_IMPLICIT_WORK_TREE":           true,
+	"GIT_INDEX_FILE":                   true,
+	"GIT_INTERNAL_SUPER_PREFIX":        true,
+	"GIT_NO_REPLACE_OBJECTS":           true,
+	"GIT_OBJECT_DIRECTORY":             true,
+	"GIT_PREFIX":                       true,
+	"GIT_REPLACE_REF_BASE":             true,
+	"GIT_SHALLOW_FILE":                 true,
+	"GIT_WORK_TREE":                    true,
+}
+
+// environ is the environment git and hooks run in: the daemon's, without
+// anything that would make git use another repo, never prompting for
+// credentials (there's no one at the terminal to answer) and in the C
+// locale, so the output parsed is the same whatever the owner's language.
+// extra variables are added last, overriding the others.
+func environ(extra ...string) []string {
+	env := make([]string, 0, len(os.Environ())+len(extra)+4)
+	path := defaultPath
+	for _, kv := range os.Environ() {
+		name, value, _ := strings.Cut(kv, "=")
+		switch {
+		case repoEnvVars[name], name == "LANG", name == "LANGUAGE", strings.HasPrefix(name, "LC_"):
+			continue
+		case name == "PATH":
+			if value != "" {
+				path = value
+			}
+			continue
+		}
+		env = append(env, kv)
+	}
+	env = append(env,
+		"PATH="+path,
+		"LC_ALL=C",
+		"GIT_TERMINAL_PROMPT=0",     // HTTPS credentials
+		"GCM_INTERACTIVE=never",     // Git Credential Manager
+		"SSH_ASKPASS_REQUIRE=never", // Nor pop up a passphrase dialog on the owner's desktop
+	)
+	return append(env, extra...)
+}
+
+// Timeouts for git commands, after which they're killed: network commands
+// (push, fetch...) and hooks get NetworkTimeout, the others LocalTimeout.
+// Zero means no limit.
+var (
+	LocalTimeout   = 5 * time.Minute
+	NetworkTimeout = 10 * time.Minute
+)
+
+// networkCommands talk to a remote, whose speed the daemon doesn't control.
+var networkCommands = map[string]bool{
+	"clone": true, "fetch": true, "ls-remote": true, "pull": true, "push": true, "submodule": true,
+}
+
+// ErrTimeout is wrapped by the errors of commands killed for taking longer
+// than their timeout.
+var ErrTimeout = errors.New("TIMEOUT")
+
+// ErrCancelled is wrapped by the errors of commands killed through Abort.
+var ErrCancelled = errors.New("cancelled")
+
+// Cmd is a git command, or a hook, that is killed with everything it
+// started if it runs longer than its timeout.
+type Cmd struct {
+	*exec.Cmd
+	timeout time.Duration
+
+	Abort    <-chan struct{} // Kills the command when closed
+	Progress io.Writer       // Also sent CombinedOutput's output, as it comes
+}
+
+// Command returns a git command that runs in dir, in the environment git
+// always runs in (see environ), detached from the daemon's terminal so SSH
+// can't ask it for a passphrase either.
+func Command(dir string, args ...string) *Cmd {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = environ()
+	timeout := LocalTimeout
+	if networkCommands[subcommand(args)] {
+		timeout = NetworkTimeout
+	}
+	return newCmd(cmd, timeout)
+}
+
+func newCmd(cmd *exec.Cmd, timeout time.Duration) *Cmd {
+	detach(cmd)
+	// Don't wait forever for output from whatever outlived a killed command
+	cmd.WaitDelay = 5 * time.Second
+	return &Cmd{Cmd: cmd, timeout: timeout}
+}
+
+// subcommand returns the git command args run, skipping options like -c.
+func subcommand(args []string) string {
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "-c" || args[i] == "-C":
+			i++
+		case !strings.HasPrefix(args[i], "-"):
+			return args[i]
+		}
+	}
+	return ""
+}
+
+// Run runs the command like exec.Cmd.Run. If it times out, it's killed and
+// the error wraps ErrTimeout.
+func (c *Cmd) Run() error {
+	if err := c.Cmd.Start(); err != nil {
+		return err
+	}
+	var timedOut, aborted atomic.Bool
+	if c.timeout > 0 {
+		timer := time.AfterFunc(c.timeout, func() {
+			timedOut.Store(true)
+			kill(c.Cmd)
+		})
+		defer timer.Stop()
+	}
+	if c.Abort != nil {
+		exited := make(chan struct{})
+		defer close(exited)
+		go func() {
+			select {
+			case <-c.Abort:
+				aborted.Store(true)
+				kill(c.Cmd)
+			case <-exited:
+			}
+		}()
+	}
+	err := c.Cmd.Wait()
+	switch {
+	case timedOut.Load():
+		return fmt.Errorf("%w: %s took longer than %s and was killed", ErrTimeout, c.name(), c.timeout)
+	case aborted.Load():
+		return fmt.Errorf("%s %w", c.name(), ErrCancelled)
+	}
+	return err
+}
+
+// Output runs the command and returns its standard output, like
+// exec.Cmd.Output, standard error included in an *exec.ExitError.
+func (c *Cmd) Output() ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	c.Stdout = &stdout
+	captureStderr := c.Stderr == nil
+	if captureStderr {
+		c.Stderr = &stderr
+	}
+	err := c.Run()
+	if exitErr, ok := err.(*exec.ExitError); ok && captureStderr {
+		exitErr.Stderr = stderr.Bytes()
+	}
+	return stdout.Bytes(), err
+}
+
+// CombinedOutput runs the command and returns its standard output and
+// error. On a timeout, the output ends with why it was cut short, as callers
+// often report the output rather than the error.
+func (c *Cmd) CombinedOutput() ([]byte, error) {
+	var out bytes.Buffer
+	c.Stdout = &out
+	if c.Progress != nil {
+		c.Stdout = io.MultiWriter(&out, c.Progress)
+	}
+	c.Stderr = c.Stdout
+	err := c.Run()
+	if errors.Is(err, ErrTimeout) {
+		fmt.Fprintf(&out, "\n%s\n", err)
+	}
+	return out.Bytes(), err
+}
+
+func (c *Cmd) name() string {
+	if filepath.Base(c.Path) != "git" && filepath.Base(c.Path) != "git.exe" {
+		return filepath.Base(c.Path) + " hook"
+	}
+	return "git " + subcommand(c.Args[1:])
+}
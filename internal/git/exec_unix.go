@@ -0,0 +1,21 @@
+//go:build !windows
+
+package git
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// detach runs cmd in a session of its own, without a controlling terminal,
+// so nothing it starts can prompt on the daemon's, and a Ctrl+C meant for
+// the daemon doesn't kill a push halfway through.
+func detach(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+}
+
+// kill kills cmd and everything it started, like ssh under git push.
+func kill(cmd *exec.Cmd) {
+	// The session detach started is also a process group
+	syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
@@ -0,0 +1,13 @@
+package git
+
+import "os/exec"
+
+// detach does nothing on Windows, where git's prompts go through the
+// credential manager, which environ keeps from showing any.
+func detach(cmd *exec.Cmd) {}
+
+// kill kills cmd. What it started may live on; Cmd.WaitDelay keeps that
+// from blocking the daemon.
+func kill(cmd *exec.Cmd) {
+	cmd.Process.Kill()
+}
@@ -0,0 +1,76 @@
+package git
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// maxGrepLineLength caps the text returned per match, so a hit in a minified
+// file doesn't send the whole file back.
+const maxGrepLineLength = 300
+
+// GrepOptions controls a Grep search.
+type GrepOptions struct {
+	IgnoreCase bool
+	Paths      []string // Pathspecs to limit the search to; empty searches everything
+	MaxResults int      // Stop after this many matches; 0 means no limit
+}
+
+// GrepMatch is one line matching a Grep pattern.
+type GrepMatch struct {
+	Path string // Relative to the repo root
+	Line int
+	Text string
+}
+
+// Grep searches the working tree for lines matching the extended regular
+// expression pattern, using `git grep`. Tracked files and untracked files
+// that aren't ignored are searched; binary files are skipped. truncated is
+// true when more matches were found than opts.MaxResults allows.
+func Grep(repoPath, pattern string, opts GrepOptions) (matches []GrepMatch, truncated bool, err error) {
+	if pattern == "" {
+		return nil, false, fmt.Errorf("empty search pattern")
+	}
+	args := []string{"grep", "-n", "-z", "-I", "-E", "--untracked", "--no-color", "--full-name"}
+	if opts.IgnoreCase {
+		args = append(args, "-i")
+	}
+	// -e keeps a pattern starting with "-" from being read as an option
+	args = append(args, "-e", pattern, "--")
+	args = append(args, opts.Paths...)
+
+	cmd := Command(repoPath, args...)
+	out, err := cmd.Output()
+	if err != nil {
+		// Exit code 1 just means nothing matched
+		if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
+			return nil, false, nil
+		} else if ok {
+			return nil, false, fmt.Errorf("git grep failed: %s", strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return nil, false, fmt.Errorf("git grep failed: %w", err)
+	}
+
+	for _, line := range strings.Split(strings.TrimSuffix(string(out), "\n"), "\n") {
+		// "<path>\x00<line>\x00<text>"
+		fields := strings.SplitN(line, "\x00", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		n, err := strconv.Atoi(fields[1])
+		if err != nil {
+			continue
+		}
+		if opts.MaxResults > 0 && len(matches) == opts.MaxResults {
+			return matches, true, nil
+		}
+		text := fields[2]
+		if len(text) > maxGrepLineLength {
+			text = text[:maxGrepLineLength] + "..."
+		}
+		matches = append(matches, GrepMatch{Path: fields[0], Line: n, Text: text})
+	}
+	return matches, false, nil
+}
@@ -0,0 +1,119 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// HookPolicy decides what happens to a commit when its pre-commit hook fails.
+// Commits are made in-process, so hooks only run when the daemon runs them.
+type HookPolicy string
+
+const (
+	HooksWarn  HookPolicy = "warn"  // Run hooks and report their output, but never block (default)
+	HooksBlock HookPolicy = "block" // Abort the commit when a hook fails
+	HooksSkip  HookPolicy = "skip"  // Don't run hooks at all
+)
+
+// ErrHookNotFound is returned when the repository has no such hook installed.
+var ErrHookNotFound = errors.New("hook not installed")
+
+// HookError is returned when a hook exits with a non-zero status.
+type HookError struct {
+	Hook     string
+	ExitCode int
+}
+
+func (e *HookError) Error() string {
+	return fmt.Sprintf("%s hook failed with exit code %d", e.Hook, e.ExitCode)
+}
+
+// hookPath returns the path of an installed, executable hook, honouring
+// core.hooksPath and linked worktrees.
+func hookPath(repoPath, hook string) (string, error) {
+	cmd := Command(repoPath, "rev-parse", "--git-path", "hooks/"+hook)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("git rev-parse failed: %s", strings.TrimSpace(string(out)))
+	}
+	path := strings.TrimSpace(string(out))
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(repoPath, path)
+	}
+	info, err := os.Stat(path)
+	if err != nil || info.IsDir() || info.Mode()&0111 == 0 {
+		return "", ErrHookNotFound
+	}
+	return path, nil
+}
+
+// RunHook runs a pre-commit or pre-push hook, writing its combined output to
+// out as it is produced. pre-push is given the arguments and stdin git would
+// pass when pushing the current branch to remote ("origin" when empty).
+func RunHook(repoPath, hook, remoteName string, out io.Writer) error {
+	if hook != "pre-commit" && hook != "pre-push" {
+		return fmt.Errorf("unsupported hook '%s'", hook)
+	}
+	path, err := hookPath(repoPath, hook)
+	if err != nil {
+		return err
+	}
+
+	cmd := newCmd(exec.Command(path), NetworkTimeout) // Hooks can be slow, or push themselves
+	cmd.Dir = repoPath
+	cmd.Env = environ()
+	cmd.Stdout = out
+	cmd.Stderr = out
+	if hook == "pre-push" {
+		branch, err := CurrentBranch(repoPath)
+		if err != nil {
+			return err
+		}
+		if remoteName == "" {
+			remoteName = "origin"
+		}
+		url, _ := Command(repoPath, "remote", "get-url", remoteName).Output()
+		local, _ := Command(repoPath, "rev-parse", "HEAD").Output()
+		remote, err := Command(repoPath, "rev-parse", "--verify", "--quiet", "refs/remotes/"+remoteName+"/"+branch).Output()
+		if err != nil {
+			remote = []byte(strings.Repeat("0", 40))
+		}
+		ref := "refs/heads/" + branch
+		cmd.Args = append(cmd.Args, remoteName, strings.TrimSpace(string(url)))
+		cmd.Stdin = strings.NewReader(fmt.Sprintf("%s %s %s %s\n", ref, strings.TrimSpace(string(local)), ref, strings.TrimSpace(string(remote))))
+	}
+
+	if err := cmd.Run(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return &HookError{Hook: hook, ExitCode: exitErr.ExitCode()}
+		}
+		return fmt.Errorf("failed to run %s hook: %w", hook, err)
+	}
+	return nil
+}
+
+// runPreCommit runs the pre-commit hook according to policy. It returns the
+// hook's output for the commit report, and an error only if policy blocks.
+func runPreCommit(repoPath string, policy HookPolicy) (string, error) {
+	if policy == HooksSkip {
+		return "", nil
+	}
+	var out strings.Builder
+	err := RunHook(repoPath, "pre-commit", "", &out)
+	if errors.Is(err, ErrHookNotFound) {
+		return "", nil
+	}
+	report := "--- pre-commit ---\n" + out.String()
+	if err != nil {
+		report += err.Error() + "\n"
+		if policy == HooksBlock {
+			return report, err
+		}
+	}
+	return report, nil
+}
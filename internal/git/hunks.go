@@ -0,0 +1,163 @@
+package git
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Hunk is one hunk of a file's unstaged diff.
+type Hunk struct {
+	// ID identifies the hunk by its content rather than its position, so it
+	// stays valid while other hunks of the same file are staged.
+	ID         string
+	Header     string // The "@@ -a,b +c,d @@" line
+	Patch      string // Header plus the hunk's body lines
+	Insertions int
+	Deletions  int
+}
+
+// Hunks splits the unstaged changes to path into hunks, like `git add -p`
+// offers them. Untracked files have no hunks.
+func Hunks(repoPath, path string) ([]Hunk, error) {
+	_, hunks, err := unstagedHunks(repoPath, path)
+	return hunks, err
+}
+
+// StageHunks stages the hunks of path with the given IDs, leaving the rest
+// of the file's changes unstaged. It returns the number of hunks staged.
+func StageHunks(repoPath, path string, ids []string) (int, error) {
+	if len(ids) == 0 {
+		return 0, fmt.Errorf("no hunks selected")
+	}
+	fileHeader, hunks, err := unstagedHunks(repoPath, path)
+	if err != nil {
+		return 0, err
+	}
+
+	byID := make(map[string]Hunk, len(hunks))
+	for _, h := range hunks {
+		byID[h.ID] = h
+	}
+	wanted := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		if _, ok := byID[id]; !ok {
+			return 0, fmt.Errorf("hunk %s no longer matches %s; list its hunks again", id, path)
+		}
+		wanted[id] = true
+	}
+
+	// Keep the hunks in file order; their old-side line numbers all refer to
+	// the index, so a subset still applies
+	var patch strings.Builder
+	patch.WriteString(fileHeader)
+	for _, h := range hunks {
+		if wanted[h.ID] {
+			patch.WriteString(h.Patch)
+		}
+	}
+
+	cmd := Command(repoPath, "apply", "--cached", "--recount", "-")
+	cmd.Stdin = strings.NewReader(patch.String())
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return 0, fmt.Errorf("git apply failed: %s", strings.TrimSpace(string(out)))
+	}
+	return len(wanted), nil
+}
+
+// ErrPatchDoesNotApply means a patch's context no longer matches the file,
+// usually because it changed since the patch was made.
+var ErrPatchDoesNotApply = errors.New("patch does not apply")
+
+// ApplyPatch applies a unified diff of path to the working tree with
+// `git apply`. The patch may only touch path.
+func ApplyPatch(repoPath, path, patch string) error {
+	// List what the patch touches before letting it touch anything
+	cmd := Command(repoPath, "apply", "--numstat", "-z", "-")
+	cmd.Stdin = strings.NewReader(patch)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("invalid patch: %s", strings.TrimSpace(string(out)))
+	}
+	want := filepath.ToSlash(filepath.Clean(path))
+	files := 0
+	for _, entry := range strings.Split(strings.TrimSuffix(string(out), "\x00"), "\x00") {
+		// "<added>\t<deleted>\t<path>"
+		fields := strings.SplitN(entry, "\t", 3)
+		if len(fields) != 3 || fields[2] != want {
+			return fmt.Errorf("patch must only change %s", path)
+		}
+		files++
+	}
+	if files == 0 {
+		return fmt.Errorf("patch changes nothing")
+	}
+
+	cmd = Command(repoPath, "apply", "--whitespace=nowarn", "-")
+	cmd.Stdin = strings.NewReader(patch)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%w: %s", ErrPatchDoesNotApply, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// unstagedHunks returns the file header ("diff --git" through "+++") of
+// path's unstaged diff and its hunks.
+func unstagedHunks(repoPath, path string) (string, []Hunk, error) {
+	cmd := Command(repoPath, "diff", "--no-color", "--no-ext-diff", "-U3", "--", path)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", nil, fmt.Errorf("git diff failed: %s", strings.TrimSpace(string(out)))
+	}
+	if len(out) == 0 {
+		return "", nil, nil
+	}
+	if strings.Contains(string(out), "\nBinary files ") {
+		return "", nil, fmt.Errorf("%s is a binary file; it can only be staged whole", path)
+	}
+
+	var header strings.Builder
+	var hunks []Hunk
+	var current *strings.Builder
+	var cur Hunk
+	seen := make(map[string]int)
+	flush := func() {
+		if current == nil {
+			return
+		}
+		cur.Patch = current.String()
+		// Identical hunks within a file are told apart by their occurrence
+		body := cur.Patch[len(cur.Header)+1:]
+		sum := sha1.Sum([]byte(fmt.Sprintf("%s\x00%d\x00%s", path, seen[body], body)))
+		seen[body]++
+		cur.ID = hex.EncodeToString(sum[:])[:12]
+		hunks = append(hunks, cur)
+	}
+
+	for _, line := range strings.SplitAfter(string(out), "\n") {
+		if line == "" {
+			continue
+		}
+		switch {
+		case strings.HasPrefix(line, "@@"):
+			flush()
+			current = &strings.Builder{}
+			cur = Hunk{Header: strings.TrimRight(line, "\n")}
+			current.WriteString(line)
+		case current == nil:
+			header.WriteString(line)
+		default:
+			current.WriteString(line)
+			if strings.HasPrefix(line, "+") {
+				cur.Insertions++
+			} else if strings.HasPrefix(line, "-") {
+				cur.Deletions++
+			}
+		}
+	}
+	flush()
+	return header.String(), hunks, nil
+}
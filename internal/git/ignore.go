@@ -0,0 +1,165 @@
+package git
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// IgnoreResult reports what a newly added .gitignore pattern matches.
+type IgnoreResult struct {
+	Ignored []string // Untracked paths the pattern now hides; directories end in "/"
+	Tracked []string // Tracked files that match but stay tracked until untracked
+}
+
+// Ignore appends pattern to the repository's top-level .gitignore. With
+// untrack set, tracked files matching the pattern are also removed from the
+// index (but kept on disk) so git stops tracking them.
+func Ignore(repoPath, pattern string, untrack bool) (IgnoreResult, error) {
+	var result IgnoreResult
+	if err := validateIgnorePattern(pattern); err != nil {
+		return result, err
+	}
+	lines, err := readGitignore(repoPath)
+	if err != nil {
+		return result, err
+	}
+	for _, line := range lines {
+		if line == pattern {
+			return result, fmt.Errorf("'%s' is already in .gitignore", pattern)
+		}
+	}
+	if err := writeGitignore(repoPath, append(lines, pattern)); err != nil {
+		return result, err
+	}
+
+	// Match against this pattern alone, so paths that were already ignored
+	// by something else aren't reported
+	if result.Ignored, err = lsFiles(repoPath, "--others", "--ignored", "--directory", "--exclude="+pattern); err != nil {
+		return result, err
+	}
+	if result.Tracked, err = lsFiles(repoPath, "--cached", "--ignored", "--exclude="+pattern); err != nil {
+		return result, err
+	}
+	if untrack && len(result.Tracked) > 0 {
+		cmd := Command(repoPath, append([]string{"rm", "-r", "--cached", "--quiet", "--"}, result.Tracked...)...)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return result, fmt.Errorf("git rm --cached failed: %s", strings.TrimSpace(string(out)))
+		}
+	}
+	return result, nil
+}
+
+// Unignore removes pattern from the repository's top-level .gitignore.
+func Unignore(repoPath, pattern string) error {
+	if err := validateIgnorePattern(pattern); err != nil {
+		return err
+	}
+	lines, err := readGitignore(repoPath)
+	if err != nil {
+		return err
+	}
+	var kept []string
+	for _, line := range lines {
+		if line != pattern {
+			kept = append(kept, line)
+		}
+	}
+	if len(kept) == len(lines) {
+		return fmt.Errorf("'%s' is not in .gitignore", pattern)
+	}
+	return writeGitignore(repoPath, kept)
+}
+
+func validateIgnorePattern(pattern string) error {
+	if strings.TrimSpace(pattern) == "" || strings.HasPrefix(pattern, "#") || strings.ContainsAny(pattern, "\r\n") {
+		return fmt.Errorf("invalid ignore pattern '%s'", pattern)
+	}
+	return nil
+}
+
+// readGitignore returns the lines of the top-level .gitignore, or none if
+// the file doesn't exist yet.
+func readGitignore(repoPath string) ([]string, error) {
+	data, err := os.ReadFile(filepath.Join(repoPath, ".gitignore"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read .gitignore: %w", err)
+	}
+	content := strings.TrimSuffix(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")
+	if content == "" {
+		return nil, nil
+	}
+	return strings.Split(content, "\n"), nil
+}
+
+func writeGitignore(repoPath string, lines []string) error {
+	content := strings.Join(lines, "\n")
+	if content != "" {
+		content += "\n"
+	}
+	if err := os.WriteFile(filepath.Join(repoPath, ".gitignore"), []byte(content), 0644); err != nil {
+		return fmt.Errorf("failed to write .gitignore: %w", err)
+	}
+	return nil
+}
+
+// ListFiles lists the files of the working tree under prefix (slash-separated,
+// relative to the repo root; empty for everything): tracked files and
+// untracked files that aren't ignored, plus ignored files when includeIgnored
+// is set. Tracked files deleted from the working tree are left out.
+func ListFiles(repoPath, prefix string, includeIgnored bool) ([]string, error) {
+	var pathspec []string
+	if prefix != "" {
+		pathspec = []string{"--", prefix}
+	}
+	files, err := lsFiles(repoPath, append([]string{"--cached", "--others", "--exclude-standard"}, pathspec...)...)
+	if err != nil {
+		return nil, err
+	}
+	if includeIgnored {
+		ignored, err := lsFiles(repoPath, append([]string{"--others", "--ignored", "--exclude-standard"}, pathspec...)...)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, ignored...)
+	}
+	sort.Strings(files)
+
+	var existing []string
+	for i, f := range files {
+		if i > 0 && f == files[i-1] {
+			continue // Listed twice, e.g. staged but also in the merge stages
+		}
+		// Submodules are listed as a single entry; keep regular files only
+		if info, err := os.Lstat(filepath.Join(repoPath, f)); err == nil && !info.IsDir() {
+			existing = append(existing, f)
+		}
+	}
+	return existing, nil
+}
+
+// IgnoredPaths lists the untracked paths under dir (empty for the whole
+// repo) that git ignores, using the repo's ignore rules. Wholly ignored
+// directories are listed once, ending in "/".
+func IgnoredPaths(repoPath, dir string) ([]string, error) {
+	args := []string{"--others", "--ignored", "--exclude-standard", "--directory"}
+	if dir != "" {
+		args = append(args, "--", dir)
+	}
+	return lsFiles(repoPath, args...)
+}
+
+// lsFiles runs `git ls-files` and returns the listed paths.
+func lsFiles(repoPath string, args ...string) ([]string, error) {
+	// -z keeps unusual file names unquoted
+	out, err := gitOutput(repoPath, append([]string{"ls-files", "-z"}, args...)...)
+	out = strings.TrimSuffix(out, "\x00")
+	if err != nil || out == "" {
+		return nil, err
+	}
+	return strings.Split(out, "\x00"), nil
+}
@@ -0,0 +1,118 @@
+package git
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// PreviewFile is one path a commit would include.
+type PreviewFile struct {
+	Path       string
+	Status     string // git's name-status letter: A, M, D, T, ...
+	Insertions int
+	Deletions  int
+	Binary     bool
+}
+
+// CommitPreview is what CommitAndPush would commit right now.
+type CommitPreview struct {
+	Files []PreviewFile
+	Stat  string // `git diff --stat` summary
+}
+
+// PreviewCommit works out what staging everything, like CommitAndPush does,
+// would commit; with stagedOnly, just what is already staged. It stages into
+// a scratch copy of the index, so the real index and working tree are left
+// untouched.
+func PreviewCommit(repoPath string, stagedOnly bool) (CommitPreview, error) {
+	var preview CommitPreview
+
+	scratch, err := os.CreateTemp("", "p2p-index-*")
+	if err != nil {
+		return preview, err
+	}
+	scratch.Close()
+	defer os.Remove(scratch.Name())
+	if err := copyIndex(repoPath, scratch.Name()); err != nil {
+		return preview, err
+	}
+
+	run := func(args ...string) (string, error) {
+		cmd := Command(repoPath, args...)
+		cmd.Env = environ("GIT_INDEX_FILE=" + scratch.Name())
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			return "", fmt.Errorf("git %s failed: %s", args[0], strings.TrimSpace(string(out)))
+		}
+		return string(out), nil
+	}
+
+	if !stagedOnly {
+		if _, err := run("add", "-A"); err != nil {
+			return preview, err
+		}
+	}
+	nameStatus, err := run("diff", "--cached", "--no-renames", "--name-status")
+	if err != nil {
+		return preview, err
+	}
+	numstat, err := run("diff", "--cached", "--no-renames", "--numstat")
+	if err != nil {
+		return preview, err
+	}
+	if preview.Stat, err = run("diff", "--cached", "--no-renames", "--stat"); err != nil {
+		return preview, err
+	}
+
+	statuses := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSpace(nameStatus), "\n") {
+		if status, path, found := strings.Cut(line, "\t"); found {
+			statuses[path] = status
+		}
+	}
+	// numstat lines are "<ins>\t<del>\t<path>", with "-" counts for binary files
+	for _, line := range strings.Split(strings.TrimSpace(numstat), "\n") {
+		fields := strings.SplitN(line, "\t", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		f := PreviewFile{Path: fields[2], Status: statuses[fields[2]], Binary: fields[0] == "-"}
+		f.Insertions, _ = strconv.Atoi(fields[0])
+		f.Deletions, _ = strconv.Atoi(fields[1])
+		preview.Files = append(preview.Files, f)
+	}
+	return preview, nil
+}
+
+// copyIndex copies the repository's index to dst. A repository without an
+// index yet leaves dst empty, which git treats as an empty index.
+func copyIndex(repoPath, dst string) error {
+	cmd := Command(repoPath, "rev-parse", "--git-path", "index")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("git rev-parse failed: %s", strings.TrimSpace(string(out)))
+	}
+	src := strings.TrimSpace(string(out))
+	if !filepath.IsAbs(src) {
+		src = filepath.Join(repoPath, src)
+	}
+
+	in, err := os.Open(src)
+	if os.IsNotExist(err) {
+		return os.Remove(dst)
+	} else if err != nil {
+		return err
+	}
+	defer in.Close()
+	outFile, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer outFile.Close()
+	_, err = io.Copy(outFile, in)
+	return err
+}
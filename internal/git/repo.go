@@ -0,0 +1,772 @@
+package git
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/format/diff"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/utils/binary"
+	diffutil "github.com/go-git/go-git/v5/utils/diff"
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// The functions in this file read and write the repository in-process with
+// go-git, so they don't depend on the git binary, PATH or its locale.
+
+// ErrNothingToCommit is returned when a commit would not change anything.
+var ErrNothingToCommit = errors.New("nothing to commit")
+
+// open opens the repository at repoPath. Linked worktrees keep their objects
+// in the main repository, so .git/commondir must be followed.
+func open(repoPath string) (*gogit.Repository, error) {
+	r, err := gogit.PlainOpenWithOptions(repoPath, &gogit.PlainOpenOptions{EnableDotGitCommonDir: true})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open repository %s: %w", repoPath, err)
+	}
+	return r, nil
+}
+
+// FileStatus is the state of one changed path, using the same status letters
+// as `git status --porcelain` (' ', '?', 'M', 'A', 'D', 'R', 'C', 'U').
+type FileStatus struct {
+	Path     string
+	Staging  byte // Index vs HEAD
+	Worktree byte // Working tree vs index
+}
+
+// String formats the entry as a porcelain status line, e.g. " M README.md".
+func (f FileStatus) String() string {
+	return fmt.Sprintf("%c%c %s", f.Staging, f.Worktree, f.Path)
+}
+
+// State summarizes the entry as "conflicted", "untracked", "modified" (has
+// unstaged changes) or "staged".
+func (f FileStatus) State() string {
+	switch {
+	case f.Staging == 'U' || f.Worktree == 'U':
+		return "conflicted"
+	case f.Worktree == '?':
+		return "untracked"
+	case f.Worktree != ' ':
+		return "modified"
+	case f.Staging != ' ':
+		return "staged"
+	}
+	return ""
+}
+
+// Status returns every path that differs from HEAD or is untracked, sorted by path.
+func Status(repoPath string) ([]FileStatus, error) {
+	r, err := open(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	w, err := r.Worktree()
+	if err != nil {
+		return nil, err
+	}
+	st, err := w.Status()
+	if err != nil {
+		return nil, fmt.Errorf("status failed: %w", err)
+	}
+
+	var files []FileStatus
+	for path, s := range st {
+		if s.Staging == gogit.Unmodified && s.Worktree == gogit.Unmodified {
+			continue
+		}
+		files = append(files, FileStatus{Path: path, Staging: byte(s.Staging), Worktree: byte(s.Worktree)})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	return files, nil
+}
+
+// Tracking describes how the current branch relates to its upstream.
+type Tracking struct {
+	Branch   string
+	Upstream string // e.g. "origin/main", empty when none is configured
+	Ahead    int    // Commits on Branch that aren't on Upstream
+	Behind   int    // Commits on Upstream that aren't on Branch
+}
+
+// BranchTracking returns the current branch, its upstream and how far the
+// two have diverged, as of the last fetch.
+func BranchTracking(repoPath string) (Tracking, error) {
+	branch, err := CurrentBranch(repoPath)
+	if err != nil {
+		return Tracking{}, err
+	}
+	t := Tracking{Branch: branch}
+	if branch == "HEAD" {
+		return t, nil
+	}
+
+	r, err := open(repoPath)
+	if err != nil {
+		return t, err
+	}
+	cfg, err := r.Config()
+	if err != nil {
+		return t, fmt.Errorf("failed to read git config: %w", err)
+	}
+	bc, ok := cfg.Branches[branch]
+	if !ok || bc.Remote == "" || bc.Merge == "" {
+		return t, nil
+	}
+	upstreamRef := plumbing.ReferenceName(bc.Merge)
+	if bc.Remote != "." {
+		upstreamRef = plumbing.NewRemoteReferenceName(bc.Remote, bc.Merge.Short())
+	}
+	t.Upstream = upstreamRef.Short()
+
+	head, err := r.Head()
+	if err != nil {
+		return t, fmt.Errorf("failed to resolve HEAD: %w", err)
+	}
+	upstream, err := r.Reference(upstreamRef, true)
+	if err != nil {
+		// Configured but never fetched
+		return t, nil
+	}
+	if t.Ahead, err = countExclusive(r, head.Hash(), upstream.Hash()); err != nil {
+		return t, err
+	}
+	if t.Behind, err = countExclusive(r, upstream.Hash(), head.Hash()); err != nil {
+		return t, err
+	}
+	return t, nil
+}
+
+// Remote is a configured git remote.
+type Remote struct {
+	Name string
+	URL  string // First URL; fetch and push URLs are not distinguished
+}
+
+// Remotes lists the repository's remotes, sorted by name.
+func Remotes(repoPath string) ([]Remote, error) {
+	r, err := open(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	list, err := r.Remotes()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list remotes: %w", err)
+	}
+	remotes := make([]Remote, 0, len(list))
+	for _, rem := range list {
+		cfg := rem.Config()
+		remote := Remote{Name: cfg.Name}
+		if len(cfg.URLs) > 0 {
+			remote.URL = cfg.URLs[0]
+		}
+		remotes = append(remotes, remote)
+	}
+	sort.Slice(remotes, func(i, j int) bool { return remotes[i].Name < remotes[j].Name })
+	return remotes, nil
+}
+
+// HasRemote reports whether the repository has a remote called name.
+func HasRemote(repoPath, name string) (bool, error) {
+	remotes, err := Remotes(repoPath)
+	if err != nil {
+		return false, err
+	}
+	for _, r := range remotes {
+		if r.Name == name {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// HasUpstream reports whether branch has an upstream configured.
+func HasUpstream(repoPath, branch string) (bool, error) {
+	r, err := open(repoPath)
+	if err != nil {
+		return false, err
+	}
+	cfg, err := r.Config()
+	if err != nil {
+		return false, fmt.Errorf("failed to read git config: %w", err)
+	}
+	bc, ok := cfg.Branches[branch]
+	return ok && bc.Remote != "" && bc.Merge != "", nil
+}
+
+// countExclusive counts the commits reachable from tip but not from base.
+func countExclusive(r *gogit.Repository, tip, base plumbing.Hash) (int, error) {
+	if tip == base {
+		return 0, nil
+	}
+	baseCommit, err := r.CommitObject(base)
+	if err != nil {
+		return 0, err
+	}
+	reachable := make(map[plumbing.Hash]bool)
+	err = object.NewCommitPreorderIter(baseCommit, nil, nil).ForEach(func(c *object.Commit) error {
+		reachable[c.Hash] = true
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	tipCommit, err := r.CommitObject(tip)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	err = object.NewCommitPreorderIter(tipCommit, reachable, nil).ForEach(func(c *object.Commit) error {
+		count++
+		return nil
+	})
+	return count, err
+}
+
+// Branch is a local branch.
+type Branch struct {
+	Name    string
+	Hash    string
+	Current bool
+}
+
+// Branches lists the local branches, sorted by name.
+func Branches(repoPath string) ([]Branch, error) {
+	r, err := open(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	current, _ := CurrentBranch(repoPath)
+
+	iter, err := r.Branches()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list branches: %w", err)
+	}
+	var branches []Branch
+	iter.ForEach(func(ref *plumbing.Reference) error {
+		name := ref.Name().Short()
+		branches = append(branches, Branch{Name: name, Hash: ref.Hash().String(), Current: name == current})
+		return nil
+	})
+	sort.Slice(branches, func(i, j int) bool { return branches[i].Name < branches[j].Name })
+	return branches, nil
+}
+
+// CurrentBranch returns the checked out branch, or "HEAD" when detached.
+func CurrentBranch(repoPath string) (string, error) {
+	r, err := open(repoPath)
+	if err != nil {
+		return "", err
+	}
+	head, err := r.Reference(plumbing.HEAD, false)
+	if err != nil {
+		return "", fmt.Errorf("failed to read HEAD: %w", err)
+	}
+	if head.Type() == plumbing.SymbolicReference {
+		return head.Target().Short(), nil
+	}
+	return "HEAD", nil
+}
+
+// CreateBranch creates a branch at the current HEAD without checking it out.
+func CreateBranch(repoPath, name string) error {
+	r, err := open(repoPath)
+	if err != nil {
+		return err
+	}
+	refName := plumbing.NewBranchReferenceName(name)
+	if err := refName.Validate(); err != nil {
+		return fmt.Errorf("invalid branch name '%s'", name)
+	}
+	if _, err := r.Reference(refName, false); err == nil {
+		return fmt.Errorf("a branch named '%s' already exists", name)
+	}
+	head, err := r.Head()
+	if err != nil {
+		return fmt.Errorf("failed to resolve HEAD: %w", err)
+	}
+	return r.Storer.SetReference(plumbing.NewHashReference(refName, head.Hash()))
+}
+
+// DefaultBranch returns the branch origin/HEAD points at. Without one (no
+// remote, or it was never fetched) it falls back to a local "main" or
+// "master", and finally to the current branch.
+func DefaultBranch(repoPath string) (string, error) {
+	r, err := open(repoPath)
+	if err != nil {
+		return "", err
+	}
+	if ref, err := r.Reference(plumbing.NewRemoteHEADReferenceName("origin"), false); err == nil && ref.Type() == plumbing.SymbolicReference {
+		return strings.TrimPrefix(ref.Target().Short(), "origin/"), nil
+	}
+	for _, name := range []string{"main", "master"} {
+		if _, err := r.Reference(plumbing.NewBranchReferenceName(name), false); err == nil {
+			return name, nil
+		}
+	}
+	return CurrentBranch(repoPath)
+}
+
+// Commit is a single entry from the commit history.
+type Commit struct {
+	Hash        string
+	AbbrevHash  string
+	AuthorName  string
+	AuthorEmail string
+	Date        time.Time
+	Subject     string
+	Refs        []string
+	Signed      bool
+	Signature   string // %G? status, only filled in by VerifySignatures
+	Path        string // The file's path at this commit, only filled in by FileLog
+}
+
+// String formats the commit as a one-line log entry.
+func (c Commit) String() string {
+	refs := ""
+	if len(c.Refs) > 0 {
+		refs = "(" + strings.Join(c.Refs, ", ") + ") "
+	}
+	return fmt.Sprintf("%s - %s%s (%s) <%s>", c.AbbrevHash, refs, c.Subject, c.Date.Format("2006-01-02 15:04"), c.AuthorName)
+}
+
+// Log returns the most recent limit commits reachable from HEAD, newest first.
+func Log(repoPath string, limit int) ([]Commit, error) {
+	r, err := open(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	head, err := r.Head()
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve HEAD: %w", err)
+	}
+	decorations, err := refDecorations(r)
+	if err != nil {
+		return nil, err
+	}
+
+	iter, err := r.Log(&gogit.LogOptions{From: head.Hash(), Order: gogit.LogOrderCommitterTime})
+	if err != nil {
+		return nil, fmt.Errorf("log failed: %w", err)
+	}
+	defer iter.Close()
+
+	var commits []Commit
+	err = iter.ForEach(func(c *object.Commit) error {
+		if len(commits) >= limit {
+			return storer.ErrStop
+		}
+		hash := c.Hash.String()
+		subject, _, _ := strings.Cut(c.Message, "\n")
+		commits = append(commits, Commit{
+			Hash:        hash,
+			AbbrevHash:  hash[:7],
+			AuthorName:  c.Author.Name,
+			AuthorEmail: c.Author.Email,
+			Date:        c.Author.When,
+			Subject:     subject,
+			Refs:        decorations[c.Hash],
+			Signed:      c.PGPSignature != "",
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("log failed: %w", err)
+	}
+	return commits, nil
+}
+
+// refDecorations maps commit hashes to the ref names pointing at them, in the
+// style of `git log --decorate` ("HEAD -> main", "origin/main", "tag: v1.0").
+func refDecorations(r *gogit.Repository) (map[plumbing.Hash][]string, error) {
+	decorations := make(map[plumbing.Hash][]string)
+	head, headErr := r.Reference(plumbing.HEAD, false)
+
+	refs, err := r.References()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list references: %w", err)
+	}
+	var names []*plumbing.Reference
+	refs.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() == plumbing.HashReference {
+			names = append(names, ref)
+		}
+		return nil
+	})
+	sort.Slice(names, func(i, j int) bool { return names[i].Name() < names[j].Name() })
+
+	for _, ref := range names {
+		hash := ref.Hash()
+		label := ref.Name().Short()
+		switch {
+		case ref.Name().IsTag():
+			// Annotated tags point at a tag object rather than the commit
+			if tag, err := r.TagObject(hash); err == nil {
+				hash = tag.Target
+			}
+			label = "tag: " + label
+		case ref.Name().IsBranch() && headErr == nil && head.Type() == plumbing.SymbolicReference && head.Target() == ref.Name():
+			label = "HEAD -> " + label
+			decorations[hash] = append([]string{label}, decorations[hash]...)
+			continue
+		case !ref.Name().IsBranch() && !ref.Name().IsRemote():
+			continue
+		}
+		decorations[hash] = append(decorations[hash], label)
+	}
+	if headErr == nil && head.Type() == plumbing.HashReference {
+		decorations[head.Hash()] = append([]string{"HEAD"}, decorations[head.Hash()]...)
+	}
+	return decorations, nil
+}
+
+// FileDiff is the unstaged change to a single file.
+type FileDiff struct {
+	Path       string
+	Binary     bool
+	Patch      string // Unified diff, colorized like `git diff --color`
+	Insertions int    // Added lines, as counted by `git diff --stat`
+	Deletions  int
+}
+
+// DiffOptions tweaks how Diff renders each patch.
+type DiffOptions struct {
+	Context        int  // Lines of context around each change; 0 means the default of 3, -1 none
+	WordDiff       bool // Mark changed words inline instead of whole lines
+	IgnoreAllSpace bool // Ignore whitespace when comparing lines, like -w
+}
+
+// contextLines maps DiffOptions.Context to an actual line count.
+func (o DiffOptions) contextLines() int {
+	switch {
+	case o.Context < 0:
+		return 0
+	case o.Context == 0:
+		return diff.DefaultContextLines
+	}
+	return o.Context
+}
+
+// Diff returns the differences between the index and the working tree, like
+// `git diff`. A non-empty path limits the result to that file or directory.
+func Diff(repoPath, path string, opts DiffOptions) ([]FileDiff, error) {
+	r, err := open(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	w, err := r.Worktree()
+	if err != nil {
+		return nil, err
+	}
+	st, err := w.Status()
+	if err != nil {
+		return nil, fmt.Errorf("status failed: %w", err)
+	}
+	idx, err := r.Storer.Index()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read index: %w", err)
+	}
+
+	path = filepath.ToSlash(filepath.Clean(path))
+	var names []string
+	for name, s := range st {
+		if s.Worktree != gogit.Modified && s.Worktree != gogit.Deleted {
+			continue
+		}
+		if path != "." && name != path && !strings.HasPrefix(name, path+"/") {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var diffs []FileDiff
+	for _, name := range names {
+		entry, err := idx.Entry(name)
+		if err != nil {
+			continue
+		}
+		fd, err := diffFile(r, repoPath, name, entry.Hash, entry.Mode, opts.contextLines())
+		if err != nil {
+			return nil, err
+		}
+		if (opts.WordDiff || opts.IgnoreAllSpace) && !fd.Binary {
+			// go-git's encoder only does line diffs, so these come from git itself
+			if fd.Patch, err = gitDiffPatch(repoPath, name, opts); err != nil {
+				return nil, err
+			}
+			if fd.Patch == "" {
+				continue // Only whitespace changed
+			}
+		}
+		diffs = append(diffs, fd)
+	}
+	return diffs, nil
+}
+
+// diffFile diffs the indexed blob of name against the file on disk.
+func diffFile(r *gogit.Repository, repoPath, name string, hash plumbing.Hash, mode filemode.FileMode, context int) (FileDiff, error) {
+	blob, err := r.BlobObject(hash)
+	if err != nil {
+		return FileDiff{}, fmt.Errorf("failed to read %s from index: %w", name, err)
+	}
+	reader, err := blob.Reader()
+	if err != nil {
+		return FileDiff{}, err
+	}
+	oldContent, err := io.ReadAll(reader)
+	reader.Close()
+	if err != nil {
+		return FileDiff{}, err
+	}
+
+	from := &diffFileSide{hash: hash, mode: mode, path: name}
+	var to *diffFileSide
+	var newContent []byte
+	if data, err := os.ReadFile(filepath.Join(repoPath, filepath.FromSlash(name))); err == nil {
+		newContent = data
+		to = &diffFileSide{hash: plumbing.ComputeHash(plumbing.BlobObject, data), mode: mode, path: name}
+	} else if !os.IsNotExist(err) {
+		return FileDiff{}, err
+	}
+
+	fd := FileDiff{Path: name}
+	fp := &filePatch{from: from, to: to}
+	oldBinary, _ := binary.IsBinary(bytes.NewReader(oldContent))
+	newBinary, _ := binary.IsBinary(bytes.NewReader(newContent))
+	fp.binary = oldBinary || newBinary
+	if !fp.binary {
+		for _, d := range diffutil.Do(string(oldContent), string(newContent)) {
+			op := diff.Equal
+			switch d.Type {
+			case diffmatchpatch.DiffInsert:
+				op = diff.Add
+				fd.Insertions += countLines(d.Text)
+			case diffmatchpatch.DiffDelete:
+				op = diff.Delete
+				fd.Deletions += countLines(d.Text)
+			}
+			fp.chunks = append(fp.chunks, chunk{content: d.Text, op: op})
+		}
+	}
+
+	var sb strings.Builder
+	enc := diff.NewUnifiedEncoder(&sb, context).SetColor(diff.NewColorConfig())
+	if err := enc.Encode(patch{fp}); err != nil {
+		return FileDiff{}, fmt.Errorf("failed to encode diff for %s: %w", name, err)
+	}
+	fd.Binary = fp.binary
+	fd.Patch = sb.String()
+	return fd, nil
+}
+
+// countLines counts the lines in a line-mode diff chunk, including a final
+// line without a trailing newline.
+func countLines(text string) int {
+	n := strings.Count(text, "\n")
+	if text != "" && !strings.HasSuffix(text, "\n") {
+		n++
+	}
+	return n
+}
+
+// patch, filePatch, diffFileSide and chunk adapt a working tree comparison to
+// the interfaces go-git's unified diff encoder expects.
+type patch []diff.FilePatch
+
+func (p patch) FilePatches() []diff.FilePatch { return p }
+func (p patch) Message() string               { return "" }
+
+type filePatch struct {
+	from, to *diffFileSide
+	binary   bool
+	chunks   []diff.Chunk
+}
+
+func (f *filePatch) IsBinary() bool       { return f.binary }
+func (f *filePatch) Chunks() []diff.Chunk { return f.chunks }
+func (f *filePatch) Files() (diff.File, diff.File) {
+	// Return untyped nils so the encoder recognises added/deleted files
+	var from, to diff.File
+	if f.from != nil {
+		from = f.from
+	}
+	if f.to != nil {
+		to = f.to
+	}
+	return from, to
+}
+
+type diffFileSide struct {
+	hash plumbing.Hash
+	mode filemode.FileMode
+	path string
+}
+
+func (f *diffFileSide) Hash() plumbing.Hash     { return f.hash }
+func (f *diffFileSide) Mode() filemode.FileMode { return f.mode }
+func (f *diffFileSide) Path() string            { return f.path }
+
+type chunk struct {
+	content string
+	op      diff.Operation
+}
+
+func (c chunk) Content() string      { return c.content }
+func (c chunk) Type() diff.Operation { return c.op }
+
+// stageAll stages every change in the working tree, like `git add -A`.
+func stageAll(repoPath string) error {
+	r, err := open(repoPath)
+	if err != nil {
+		return err
+	}
+	w, err := r.Worktree()
+	if err != nil {
+		return err
+	}
+	if err := w.AddWithOptions(&gogit.AddOptions{All: true}); err != nil {
+		return fmt.Errorf("add failed: %w", err)
+	}
+	return nil
+}
+
+// Add stages a single file.
+func Add(repoPath, path string) error {
+	r, err := open(repoPath)
+	if err != nil {
+		return err
+	}
+	w, err := r.Worktree()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Add(filepath.ToSlash(path)); err != nil {
+		return fmt.Errorf("add failed: %w", err)
+	}
+	return nil
+}
+
+// commit commits the index in-process. With amend set the HEAD commit is
+// replaced, keeping its message when commitMessage is empty.
+func commit(repoPath, commitMessage string, amend bool, opts CommitOptions) error {
+	r, err := open(repoPath)
+	if err != nil {
+		return err
+	}
+	w, err := r.Worktree()
+	if err != nil {
+		return err
+	}
+
+	committer, err := configSignature(r)
+	if err != nil {
+		return err
+	}
+	commitOpts := &gogit.CommitOptions{Author: committer, Committer: committer, Amend: amend}
+	if amend {
+		head, err := r.Head()
+		if err != nil {
+			return fmt.Errorf("failed to resolve HEAD: %w", err)
+		}
+		headCommit, err := r.CommitObject(head.Hash())
+		if err != nil {
+			return err
+		}
+		// Like `git commit --amend`, keep the original author
+		author := headCommit.Author
+		commitOpts.Author = &author
+		if commitMessage == "" {
+			commitMessage = strings.TrimRight(headCommit.Message, "\n")
+		}
+	}
+	if opts.AuthorName != "" {
+		commitOpts.Author = &object.Signature{Name: opts.AuthorName, Email: opts.AuthorEmail, When: time.Now()}
+	}
+	if opts.SignOff {
+		trailer := fmt.Sprintf("Signed-off-by: %s <%s>", committer.Name, committer.Email)
+		if !strings.Contains(commitMessage, trailer) {
+			commitMessage = strings.TrimRight(commitMessage, "\n") + "\n\n" + trailer
+		}
+	}
+
+	if _, err := w.Commit(commitMessage+"\n", commitOpts); err != nil {
+		if errors.Is(err, gogit.ErrEmptyCommit) {
+			return ErrNothingToCommit
+		}
+		return fmt.Errorf("commit failed: %w", err)
+	}
+	return nil
+}
+
+// configSignature returns the user.name/user.email identity from the
+// repository, global and system git config.
+func configSignature(r *gogit.Repository) (*object.Signature, error) {
+	cfg, err := r.ConfigScoped(config.SystemScope)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read git config: %w", err)
+	}
+	name, email := cfg.Committer.Name, cfg.Committer.Email
+	if name == "" || email == "" {
+		name, email = cfg.User.Name, cfg.User.Email
+	}
+	if name == "" || email == "" {
+		return nil, fmt.Errorf("git user.name and user.email must be configured on the daemon")
+	}
+	return &object.Signature{Name: name, Email: email, When: time.Now()}, nil
+}
+
+// IsHeadPushed reports whether the current HEAD commit is already reachable
+// from any remote-tracking branch.
+func IsHeadPushed(repoPath string) (bool, error) {
+	r, err := open(repoPath)
+	if err != nil {
+		return false, err
+	}
+	head, err := r.Head()
+	if err != nil {
+		return false, fmt.Errorf("failed to resolve HEAD: %w", err)
+	}
+	headCommit, err := r.CommitObject(head.Hash())
+	if err != nil {
+		return false, err
+	}
+
+	refs, err := r.References()
+	if err != nil {
+		return false, fmt.Errorf("failed to list references: %w", err)
+	}
+	pushed := false
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		if !ref.Name().IsRemote() || ref.Type() != plumbing.HashReference {
+			return nil
+		}
+		remoteCommit, err := r.CommitObject(ref.Hash())
+		if err != nil {
+			return nil
+		}
+		if ok, _ := headCommit.IsAncestor(remoteCommit); ok {
+			pushed = true
+			return storer.ErrStop
+		}
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return pushed, nil
+}
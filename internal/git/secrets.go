@@ -0,0 +1,148 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// SecretFinding is something in the staged changes that looks like a
+// credential. Match is redacted so reporting it doesn't leak it further.
+type SecretFinding struct {
+	File  string
+	Line  int // In the new version of the file
+	Kind  string
+	Match string
+}
+
+// SecretsFoundError aborts a commit whose staged changes look like they
+// contain credentials.
+type SecretsFoundError struct {
+	Findings []SecretFinding
+}
+
+func (e *SecretsFoundError) Error() string {
+	return fmt.Sprintf("found %d possible secret(s) in the staged changes", len(e.Findings))
+}
+
+// secretPatterns are credentials with a recognizable shape.
+var secretPatterns = []struct {
+	kind string
+	re   *regexp.Regexp
+}{
+	{"AWS access key ID", regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{"AWS secret access key", regexp.MustCompile(`(?i)aws_?secret_?access_?key["']?\s*[:=]\s*["']?([A-Za-z0-9/+=]{40})`)},
+	{"private key", regexp.MustCompile(`-----BEGIN (?:[A-Z]+ )*PRIVATE KEY(?: BLOCK)?-----`)},
+	{"GitHub token", regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{60,})\b`)},
+	{"Slack token", regexp.MustCompile(`\bxox[abposr]-[A-Za-z0-9-]{10,}\b`)},
+	{"Google API key", regexp.MustCompile(`\bAIza[0-9A-Za-z_-]{35}\b`)},
+}
+
+// tokenPattern finds candidates for the entropy check: long runs of the
+// characters API tokens and base64 secrets are made of.
+var tokenPattern = regexp.MustCompile(`[A-Za-z0-9+/=_-]{24,}`)
+
+// minTokenEntropy is the Shannon entropy, in bits per character, above
+// which a token is considered random. Hex, like commit hashes, tops out at
+// 4; base64 of random bytes is close to 6.
+const minTokenEntropy = 4.5
+
+// ScanStaged looks for credentials in the lines the staged changes add.
+func ScanStaged(repoPath string) ([]SecretFinding, error) {
+	cmd := Command(repoPath, "diff", "--cached", "--no-color", "--no-ext-diff", "-U0", "--diff-filter=d")
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git diff --cached failed: %w", err)
+	}
+
+	var findings []SecretFinding
+	var file string
+	line := 0
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	scanner.Buffer(make([]byte, 64*1024), 10*1024*1024)
+	for scanner.Scan() {
+		text := scanner.Text()
+		switch {
+		case strings.HasPrefix(text, "+++ "):
+			file = strings.TrimPrefix(strings.TrimPrefix(text, "+++ "), "b/")
+		case strings.HasPrefix(text, "@@ "):
+			// @@ -a,b +c,d @@: added lines start at c
+			fields := strings.Fields(text)
+			if len(fields) > 2 {
+				start, _, _ := strings.Cut(strings.TrimPrefix(fields[2], "+"), ",")
+				line, _ = strconv.Atoi(start)
+			}
+		case strings.HasPrefix(text, "+"):
+			for _, f := range scanLine(text[1:]) {
+				f.File, f.Line = file, line
+				findings = append(findings, f)
+			}
+			line++
+		}
+	}
+	return findings, scanner.Err()
+}
+
+// scanLine returns the secrets in one line, at most one per kind.
+func scanLine(text string) []SecretFinding {
+	var findings []SecretFinding
+	for _, p := range secretPatterns {
+		m := p.re.FindStringSubmatch(text)
+		if m == nil {
+			continue
+		}
+		match := redact(m[0])
+		switch {
+		case p.kind == "private key":
+			match = m[0] // Just the header, which is no secret
+		case len(m) > 1:
+			match = redact(m[1])
+		}
+		findings = append(findings, SecretFinding{Kind: p.kind, Match: match})
+	}
+	if len(findings) > 0 {
+		return findings
+	}
+	for _, token := range tokenPattern.FindAllString(text, -1) {
+		if looksRandom(token) {
+			return []SecretFinding{{Kind: "high-entropy token", Match: redact(token)}}
+		}
+	}
+	return nil
+}
+
+// looksRandom reports whether a token mixes letters and digits with the
+// entropy of a generated secret, rather than being an identifier or path.
+func looksRandom(token string) bool {
+	hasDigit := strings.ContainsAny(token, "0123456789")
+	hasUpper := strings.ContainsAny(token, "ABCDEFGHIJKLMNOPQRSTUVWXYZ")
+	hasLower := strings.ContainsAny(token, "abcdefghijklmnopqrstuvwxyz")
+	return hasDigit && hasUpper && hasLower && entropy(token) >= minTokenEntropy
+}
+
+// entropy returns the Shannon entropy of s in bits per character.
+func entropy(s string) float64 {
+	counts := make(map[rune]int)
+	for _, r := range s {
+		counts[r]++
+	}
+	var h float64
+	n := float64(len(s))
+	for _, c := range counts {
+		p := float64(c) / n
+		h -= p * math.Log2(p)
+	}
+	return h
+}
+
+// redact keeps just enough of a secret to find it again.
+func redact(secret string) string {
+	if len(secret) <= 8 {
+		return strings.Repeat("*", len(secret))
+	}
+	return secret[:4] + strings.Repeat("*", 8) + secret[len(secret)-2:]
+}
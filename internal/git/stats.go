@@ -0,0 +1,109 @@
+package git
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Contributor is an author and their number of commits.
+type Contributor struct {
+	Name    string
+	Email   string
+	Commits int
+}
+
+// Stats summarizes a repository's size and activity.
+type Stats struct {
+	SizeBytes    int64 // On-disk size of the object store, loose and packed
+	Objects      int64
+	Branches     int
+	Tags         int
+	Contributors []Contributor // Top authors reachable from HEAD, most commits first
+	LastCommit   time.Time     // Committer date of HEAD
+	LastActivity time.Time     // Newest committer date across all local branches
+}
+
+// RepoStats gathers size and activity statistics for a repository.
+// maxContributors limits the contributor list.
+func RepoStats(repoPath string, maxContributors int) (Stats, error) {
+	var st Stats
+
+	// count-objects reports sizes in KiB
+	out, err := gitOutput(repoPath, "count-objects", "-v")
+	if err != nil {
+		return st, err
+	}
+	for _, line := range strings.Split(out, "\n") {
+		key, value, found := strings.Cut(line, ": ")
+		if !found {
+			continue
+		}
+		n, _ := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		switch key {
+		case "count", "in-pack":
+			st.Objects += n
+		case "size", "size-pack":
+			st.SizeBytes += n * 1024
+		}
+	}
+
+	out, err = gitOutput(repoPath, "for-each-ref", "--sort=-committerdate", "--format=%(refname)%09%(committerdate:iso-strict)", "refs/heads", "refs/tags")
+	if err != nil {
+		return st, err
+	}
+	for _, line := range strings.Split(out, "\n") {
+		ref, date, _ := strings.Cut(line, "\t")
+		switch {
+		case strings.HasPrefix(ref, "refs/heads/"):
+			st.Branches++
+			if t, err := time.Parse(time.RFC3339, date); err == nil && t.After(st.LastActivity) {
+				st.LastActivity = t
+			}
+		case strings.HasPrefix(ref, "refs/tags/"):
+			st.Tags++
+		}
+	}
+
+	// An empty repository has no HEAD commit and no contributors yet
+	if out, err := gitOutput(repoPath, "log", "-1", "--format=%cI", "HEAD"); err == nil {
+		st.LastCommit, _ = time.Parse(time.RFC3339, out)
+		out, err = gitOutput(repoPath, "shortlog", "-sne", "HEAD")
+		if err != nil {
+			return st, err
+		}
+		for _, line := range strings.Split(out, "\n") {
+			if len(st.Contributors) >= maxContributors {
+				break
+			}
+			// "   42\tName <email>"
+			count, author, found := strings.Cut(strings.TrimSpace(line), "\t")
+			if !found {
+				continue
+			}
+			c := Contributor{Name: author}
+			c.Commits, _ = strconv.Atoi(count)
+			if name, email, found := strings.Cut(author, " <"); found {
+				c.Name, c.Email = name, strings.TrimSuffix(email, ">")
+			}
+			st.Contributors = append(st.Contributors, c)
+		}
+	}
+	return st, nil
+}
+
+// gitOutput runs a git command and returns its trimmed standard output.
+func gitOutput(repoPath string, args ...string) (string, error) {
+	cmd := Command(repoPath, args...)
+	out, err := cmd.Output()
+	if err != nil {
+		msg := err.Error()
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			msg = strings.TrimSpace(string(exitErr.Stderr))
+		}
+		return "", fmt.Errorf("git %s failed: %s", args[0], msg)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
@@ -0,0 +1,175 @@
+// Package logging sets up structured, leveled logs with log/slog: text or
+// JSON, to stderr or a rotated file, with a level per subsystem.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Options configures the logs.
+type Options struct {
+	// Levels is a level, optionally followed by levels for scopes, e.g.
+	// "info" or "info,git=debug,p2p=warn".
+	Levels string
+	JSON   bool
+	// File is the log file, or "" for Output, or stderr if that's nil too.
+	File   string
+	Output io.Writer
+	// MaxSize is how large the file grows before it's rotated, in bytes (0:
+	// never rotate), and MaxFiles how many rotated files are kept.
+	MaxSize  int64
+	MaxFiles int
+}
+
+var (
+	scopeMu     sync.RWMutex
+	scopeLevels = make(map[string]slog.Level)
+)
+
+// Setup makes the configured logger slog's default, and the standard log
+// package's output, until the returned closer is closed.
+func Setup(opts Options) (io.Closer, error) {
+	level, scopes, err := ParseLevels(opts.Levels)
+	if err != nil {
+		return nil, err
+	}
+	var out io.WriteCloser = nopCloser{os.Stderr}
+	if opts.Output != nil {
+		out = nopCloser{opts.Output}
+	}
+	if opts.File != "" {
+		if out, err = OpenRotating(opts.File, opts.MaxSize, opts.MaxFiles); err != nil {
+			return nil, err
+		}
+	}
+
+	// Scopes with a lower level than the default get through the handler too
+	handlerLevel := level
+	for _, l := range scopes {
+		handlerLevel = min(handlerLevel, l)
+	}
+	handlerOpts := &slog.HandlerOptions{Level: handlerLevel}
+	var handler slog.Handler
+	if opts.JSON {
+		handler = slog.NewJSONHandler(out, handlerOpts)
+	} else {
+		handler = slog.NewTextHandler(out, handlerOpts)
+	}
+
+	scopeMu.Lock()
+	scopeLevels = scopes
+	scopeLevels[""] = level
+	scopeMu.Unlock()
+	// Records of scopes are filtered by scopeHandler, the others here
+	slog.SetDefault(slog.New(&levelHandler{Handler: handler, level: level}))
+	// slog.SetDefault sends the log package's output to the handler too
+	log.SetFlags(0)
+	return out, nil
+}
+
+// ParseLevels parses a default level and levels per scope, like
+// "info,git=debug".
+func ParseLevels(s string) (slog.Level, map[string]slog.Level, error) {
+	level := slog.LevelInfo
+	scopes := make(map[string]slog.Level)
+	for i, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		scope, name, scoped := strings.Cut(part, "=")
+		if !scoped {
+			name = scope
+		}
+		var l slog.Level
+		if err := l.UnmarshalText([]byte(name)); err != nil {
+			return 0, nil, fmt.Errorf("invalid log level '%s': use debug, info, warn or error", name)
+		}
+		switch {
+		case scoped:
+			scopes[scope] = l
+		case i == 0:
+			level = l
+		default:
+			return 0, nil, fmt.Errorf("the default log level must come first, not '%s'", part)
+		}
+	}
+	return level, scopes, nil
+}
+
+// Scope returns a logger for a subsystem, like "git", which tags its records
+// with scope=<name> and has its own level if one was set. It logs through
+// whatever logger is the default at the time, so it can be created before
+// Setup is called.
+func Scope(name string) *slog.Logger {
+	return slog.New(&scopeHandler{
+		scope: name,
+		wrap: func(h slog.Handler) slog.Handler {
+			return h.WithAttrs([]slog.Attr{slog.String("scope", name)})
+		},
+	})
+}
+
+type scopeHandler struct {
+	scope string
+	wrap  func(slog.Handler) slog.Handler
+}
+
+func (h *scopeHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	scopeMu.RLock()
+	threshold, ok := scopeLevels[h.scope]
+	if !ok {
+		threshold, ok = scopeLevels[""]
+	}
+	scopeMu.RUnlock()
+	if !ok {
+		// Setup wasn't called
+		return slog.Default().Enabled(ctx, level)
+	}
+	return level >= threshold
+}
+
+func (h *scopeHandler) Handle(ctx context.Context, r slog.Record) error {
+	return h.wrap(slog.Default().Handler()).Handle(ctx, r)
+}
+
+func (h *scopeHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &scopeHandler{scope: h.scope, wrap: func(base slog.Handler) slog.Handler {
+		return h.wrap(base).WithAttrs(attrs)
+	}}
+}
+
+func (h *scopeHandler) WithGroup(name string) slog.Handler {
+	return &scopeHandler{scope: h.scope, wrap: func(base slog.Handler) slog.Handler {
+		return h.wrap(base).WithGroup(name)
+	}}
+}
+
+// levelHandler drops records below a level. Handle doesn't check it, so
+// scopeHandler can log through it with a lower level.
+type levelHandler struct {
+	slog.Handler
+	level slog.Level
+}
+
+func (h *levelHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level
+}
+
+func (h *levelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &levelHandler{Handler: h.Handler.WithAttrs(attrs), level: h.level}
+}
+
+func (h *levelHandler) WithGroup(name string) slog.Handler {
+	return &levelHandler{Handler: h.Handler.WithGroup(name), level: h.level}
+}
+
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Close() error { return nil }
@@ -0,0 +1,70 @@
+package p2p
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// NonceSize is the size of a handshake challenge.
+const NonceSize = 32
+
+// NewNonce returns a random challenge for a client to sign.
+func NewNonce() ([]byte, error) {
+	nonce := make([]byte, NonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return nonce, nil
+}
+
+// challengeData is what a client signs: the nonce bound to both sides of
+// the handshake, so a signature can't be replayed to another daemon.
+func challengeData(nonce []byte, daemon, client peer.ID) []byte {
+	data := []byte("p2p-git-remote handshake\x00" + string(daemon) + "\x00" + string(client) + "\x00")
+	return append(data, nonce...)
+}
+
+// SignChallenge signs a daemon's nonce with the client's private key, and
+// returns the marshalled public key to check it with.
+func SignChallenge(privKey crypto.PrivKey, nonce []byte, daemon, client peer.ID) (pubKey, signature []byte, err error) {
+	if pubKey, err = crypto.MarshalPublicKey(privKey.GetPublic()); err != nil {
+		return nil, nil, err
+	}
+	if signature, err = privKey.Sign(challengeData(nonce, daemon, client)); err != nil {
+		return nil, nil, err
+	}
+	return pubKey, signature, nil
+}
+
+// VerifyChallenge checks that the client's public key is the one its peer
+// ID was derived from and that it signed the nonce. It returns the key's
+// fingerprint.
+func VerifyChallenge(pubKey, signature, nonce []byte, daemon, client peer.ID) (string, error) {
+	key, err := crypto.UnmarshalPublicKey(pubKey)
+	if err != nil {
+		return "", fmt.Errorf("invalid public key: %w", err)
+	}
+	if !client.MatchesPublicKey(key) {
+		return "", fmt.Errorf("the public key doesn't belong to peer %s", client)
+	}
+	ok, err := key.Verify(challengeData(nonce, daemon, client), signature)
+	if err != nil || !ok {
+		return "", fmt.Errorf("the challenge signature is invalid")
+	}
+	return KeyFingerprint(key)
+}
+
+// KeyFingerprint returns the SHA-256 of a marshalled public key, in hex.
+func KeyFingerprint(key crypto.PubKey) (string, error) {
+	data, err := crypto.MarshalPublicKey(key)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,30 @@
+package p2p
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/p2p/net/connmgr"
+)
+
+// libp2p's own connection manager settings.
+const (
+	DefaultConnsLow   = 160
+	DefaultConnsHigh  = 192
+	DefaultConnsGrace = time.Minute
+)
+
+// ConnManager makes a host close connections once it has more than high of
+// them, down to low. Connections younger than grace are spared, and so are
+// peers protected with ConnManager().Protect, like a client's daemon.
+func ConnManager(low, high int, grace time.Duration) (libp2p.Option, error) {
+	if low < 0 || high < low {
+		return nil, fmt.Errorf("the connection high watermark (%d) must be at least the low one (%d)", high, low)
+	}
+	cm, err := connmgr.NewConnManager(low, high, connmgr.WithGracePeriod(grace))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create connection manager: %w", err)
+	}
+	return libp2p.ConnectionManager(cm), nil
+}
@@ -2,7 +2,11 @@ package p2p
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"strings"
+	"sync"
 
 	"github.com/libp2p/go-libp2p"
 	dht "github.com/libp2p/go-libp2p-kad-dht"
@@ -11,23 +15,28 @@ import (
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/go-libp2p/p2p/discovery/routing"
 	"github.com/multiformats/go-multiaddr"
+
+	"github.com/hemantsingh443/p2p-git-remote/internal/protocol"
 )
 
 // CreateHost creates a new libp2p host with NAT traversal capabilities.
-// It now accepts a private key to ensure a persistent identity.
-func CreateHost(ctx context.Context, privKey crypto.PrivKey, listenPort int) (host.Host, error) {
+// It now accepts a private key to ensure a persistent identity, and extra
+// options like PrivateNetwork.
+func CreateHost(ctx context.Context, privKey crypto.PrivKey, listenPort int, opts ...libp2p.Option) (host.Host, error) {
 	// 0.0.0.0 listens on all available interfaces.
 	listenAddr := multiaddr.StringCast(fmt.Sprintf("/ip4/0.0.0.0/tcp/%d", listenPort))
 
 	// libp2p.New constructs a new libp2p Host.
 	// Other options can be added here.
-	h, err := libp2p.New(
+	h, err := libp2p.New(append([]libp2p.Option{
 		libp2p.Identity(privKey), // Use the provided private key for a persistent ID
 		libp2p.ListenAddrs(listenAddr),
 		libp2p.NATPortMap(),         // Attempt to open a port in the NAT for us.
 		libp2p.EnableHolePunching(), // Enable NAT traversal
 		libp2p.EnableRelay(),        // Enable relay capabilities
-	)
+		libp2p.EnableNATService(),   // Tell other peers whether they are reachable (AutoNAT)
+		libp2p.EnableAutoNATv2(),    // And find out which of our addresses are
+	}, opts...)...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create libp2p host: %w", err)
 	}
@@ -36,10 +45,65 @@ func CreateHost(ctx context.Context, privKey crypto.PrivKey, listenPort int) (ho
 	return h, nil
 }
 
+// Transports a host can listen on besides TCP, which it always does.
+const (
+	TransportQUIC         = "quic"
+	TransportWebTransport = "webtransport"
+	TransportWebSocket    = "ws"
+)
+
+// ListenTransports makes a host listen on the given transports too, on the
+// same port number as TCP (UDP for QUIC and WebTransport). QUIC sets up
+// connections faster, copes better with lossy mobile networks and makes hole
+// punching succeed more often; WebSocket, which shares the TCP port, lets
+// browsers connect. Only WebSocket works on a private network.
+func ListenTransports(port int, transports []string) (libp2p.Option, error) {
+	var addrs []multiaddr.Multiaddr
+	opts := []libp2p.Option{}
+	for _, t := range transports {
+		switch strings.TrimSpace(t) {
+		case "", "tcp":
+		case TransportQUIC:
+			addrs = append(addrs, multiaddr.StringCast(fmt.Sprintf("/ip4/0.0.0.0/udp/%d/quic-v1", port)))
+		case TransportWebTransport:
+			addrs = append(addrs, multiaddr.StringCast(fmt.Sprintf("/ip4/0.0.0.0/udp/%d/quic-v1/webtransport", port)))
+		case TransportWebSocket:
+			addrs = append(addrs, multiaddr.StringCast(fmt.Sprintf("/ip4/0.0.0.0/tcp/%d/ws", port)))
+			opts = append(opts, libp2p.ShareTCPListener())
+		default:
+			return nil, fmt.Errorf("unknown transport '%s' (use tcp, %s, %s or %s)", t, TransportQUIC, TransportWebTransport, TransportWebSocket)
+		}
+	}
+	return libp2p.ChainOptions(append(opts, libp2p.ListenAddrs(addrs...))...), nil
+}
+
+// IsWebSocket reports whether an address is a WebSocket one, which browsers
+// can dial.
+func IsWebSocket(addr multiaddr.Multiaddr) bool {
+	for _, code := range []int{multiaddr.P_WS, multiaddr.P_WSS} {
+		if _, err := addr.ValueForProtocol(code); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultNamespace is the rendezvous every daemon advertises itself under on
+// the DHT, unless given its own.
+const DefaultNamespace = protocol.ProtocolID
+
+// NamespaceFromSecret derives a rendezvous from a secret shared by a
+// deployment, so its daemons advertise where only those knowing the secret
+// look, apart from everyone else's.
+func NamespaceFromSecret(secret string) string {
+	sum := sha256.Sum256([]byte("p2p-git-remote discovery namespace\n" + secret))
+	return DefaultNamespace + "/" + hex.EncodeToString(sum[:16])
+}
+
 // StartDiscovery connects to the public IPFS/libp2p bootstrap nodes to join the DHT
-// and discover other peers. This is crucial for NAT traversal and finding peers
-// on the public internet.
-func StartDiscovery(ctx context.Context, h host.Host) error {
+// and discover other peers under the given namespace. This is crucial for NAT
+// traversal and finding peers on the public internet.
+func StartDiscovery(ctx context.Context, h host.Host, namespace string) error {
 	// Create a new DHT
 	kademliaDHT, err := dht.New(ctx, h)
 	if err != nil {
@@ -54,10 +118,10 @@ func StartDiscovery(ctx context.Context, h host.Host) error {
 
 	// Announce ourselves so other peers can find us
 	routingDiscovery := routing.NewRoutingDiscovery(kademliaDHT)
-	routingDiscovery.Advertise(ctx, "/p2p-git-remote/1.0.0")
+	routingDiscovery.Advertise(ctx, namespace)
 
 	// Now, look for others
-	_, err = routingDiscovery.FindPeers(ctx, "/p2p-git-remote/1.0.0")
+	_, err = routingDiscovery.FindPeers(ctx, namespace)
 	if err != nil {
 		return fmt.Errorf("failed to find peers: %w", err)
 	}
@@ -71,3 +135,38 @@ func StartDiscovery(ctx context.Context, h host.Host) error {
 
 	return nil
 }
+
+// DHTResolver returns a Resolver looking peers up on the public DHT, which
+// it joins, as a client only, on the first lookup.
+func DHTResolver(h host.Host) Resolver {
+	var once sync.Once
+	var kademliaDHT *dht.IpfsDHT
+	var joinErr error
+	return func(ctx context.Context, id peer.ID) (peer.AddrInfo, error) {
+		once.Do(func() {
+			// The DHT outlives this lookup
+			kademliaDHT, joinErr = dht.New(context.Background(), h, dht.Mode(dht.ModeClient))
+			if joinErr != nil {
+				return
+			}
+			var wg sync.WaitGroup
+			for _, addr := range dht.DefaultBootstrapPeers {
+				pi, err := peer.AddrInfoFromP2pAddr(addr)
+				if err != nil {
+					continue
+				}
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					h.Connect(ctx, *pi) // Some bootstrap nodes may be down
+				}()
+			}
+			wg.Wait()
+			joinErr = kademliaDHT.Bootstrap(context.Background())
+		})
+		if joinErr != nil {
+			return peer.AddrInfo{}, fmt.Errorf("failed to join the DHT: %w", joinErr)
+		}
+		return kademliaDHT.FindPeer(ctx, id)
+	}
+}
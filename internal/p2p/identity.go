@@ -15,9 +15,9 @@ func LoadOrGeneratePrivateKey(path string) (crypto.PrivKey, error) {
 		// If the file doesn't exist, generate a new key
 		if os.IsNotExist(err) {
 			fmt.Printf("Generating new private key at %s\n", path)
-			privKey, _, err := crypto.GenerateKeyPairWithReader(crypto.Ed25519, -1, rand.Reader)
+			privKey, err := newPrivateKey()
 			if err != nil {
-				return nil, fmt.Errorf("failed to generate key pair: %w", err)
+				return nil, err
 			}
 
 			keyBytes, err := crypto.MarshalPrivateKey(privKey)
@@ -41,3 +41,11 @@ func LoadOrGeneratePrivateKey(path string) (crypto.PrivKey, error) {
 	fmt.Printf("Loaded private key from %s\n", path)
 	return privKey, nil
 }
+
+func newPrivateKey() (crypto.PrivKey, error) {
+	privKey, _, err := crypto.GenerateKeyPairWithReader(crypto.Ed25519, -1, rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key pair: %w", err)
+	}
+	return privKey, nil
+}
@@ -0,0 +1,80 @@
+package p2p
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/zalando/go-keyring"
+)
+
+// KeychainService is what identity keys are stored under in the OS keychain
+// (Keychain on macOS, Credential Manager on Windows, the Secret Service on
+// Linux).
+const KeychainService = "p2p-git-remote"
+
+// LoadOrGenerateKeychainKey is LoadOrGeneratePrivateKey keeping the key in
+// the OS keychain instead, under the file's name, so it isn't lying around
+// on disk. A key already in the file is moved there. On headless servers
+// without a keychain, it falls back to the file.
+func LoadOrGenerateKeychainKey(path string) (crypto.PrivKey, error) {
+	// Wherever the file is, so the key is found again when it moves
+	return LoadOrGenerateKeychainKeyAs(path, filepath.Base(path))
+}
+
+// LoadOrGenerateKeychainKeyAs is LoadOrGenerateKeychainKey keeping the key
+// under another name, for programs with several identities.
+func LoadOrGenerateKeychainKeyAs(path, name string) (crypto.PrivKey, error) {
+	encoded, err := keyring.Get(KeychainService, name)
+	if err == nil {
+		keyBytes, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode private key from the keychain: %w", err)
+		}
+		privKey, err := crypto.UnmarshalPrivateKey(keyBytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal private key: %w", err)
+		}
+		fmt.Printf("Loaded private key %s from the OS keychain\n", path)
+		return privKey, nil
+	}
+	if !errors.Is(err, keyring.ErrNotFound) {
+		fmt.Printf("OS keychain unavailable (%v); using the key file instead\n", err)
+		return LoadOrGeneratePrivateKey(path)
+	}
+
+	// Not in the keychain yet: move the file's key there, or a new one
+	var privKey crypto.PrivKey
+	_, statErr := os.Stat(path)
+	if statErr == nil {
+		if privKey, err = LoadOrGeneratePrivateKey(path); err != nil {
+			return nil, err
+		}
+	} else {
+		fmt.Printf("Generating new private key %s in the OS keychain\n", path)
+		if privKey, err = newPrivateKey(); err != nil {
+			return nil, err
+		}
+	}
+	keyBytes, err := crypto.MarshalPrivateKey(privKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal private key: %w", err)
+	}
+	if err := keyring.Set(KeychainService, name, base64.StdEncoding.EncodeToString(keyBytes)); err != nil {
+		fmt.Printf("Could not store the key in the OS keychain (%v); keeping it in %s\n", err, path)
+		if statErr == nil {
+			return privKey, nil
+		}
+		if err := os.WriteFile(path, keyBytes, 0600); err != nil {
+			return nil, fmt.Errorf("failed to write key file: %w", err)
+		}
+		return privKey, nil
+	}
+	if statErr == nil {
+		fmt.Printf("Moved private key %s to the OS keychain; you can delete the file\n", path)
+	}
+	return privKey, nil
+}
@@ -0,0 +1,90 @@
+package p2p
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/discovery/mdns"
+	"github.com/libp2p/zeroconf/v2"
+	"github.com/multiformats/go-multiaddr"
+)
+
+// MDNSServiceName is what daemons announce themselves as on the local
+// network. It differs from libp2p's default so clients only find daemons,
+// not every IPFS node on the Wi-Fi.
+const MDNSServiceName = "_p2p-git-remote._udp"
+
+// ignorePeers is the mdns notifee of a daemon, which only announces itself.
+type ignorePeers struct{}
+
+func (ignorePeers) HandlePeerFound(peer.AddrInfo) {}
+
+// StartMDNS announces h on the local network, so clients on the same network
+// can find it without a DHT round trip. Close the returned service to stop.
+func StartMDNS(h host.Host) (mdns.Service, error) {
+	service := mdns.NewMdnsService(h, MDNSServiceName, ignorePeers{})
+	if err := service.Start(); err != nil {
+		return nil, err
+	}
+	return service, nil
+}
+
+// DiscoverLocal browses the local network for daemons for the given time.
+// Unlike StartMDNS it doesn't announce anything itself.
+func DiscoverLocal(ctx context.Context, wait time.Duration) ([]peer.AddrInfo, error) {
+	ctx, cancel := context.WithTimeout(ctx, wait)
+	defer cancel()
+
+	entries := make(chan *zeroconf.ServiceEntry, 64)
+	found := make(map[peer.ID]*peer.AddrInfo)
+	var mutex sync.Mutex
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for entry := range entries {
+			// Like libp2p's mdns, only the dnsaddr TXT records matter
+			var addrs []multiaddr.Multiaddr
+			for _, txt := range entry.Text {
+				addr, err := multiaddr.NewMultiaddr(strings.TrimPrefix(txt, "dnsaddr="))
+				if err == nil && strings.HasPrefix(txt, "dnsaddr=") {
+					addrs = append(addrs, addr)
+				}
+			}
+			infos, err := peer.AddrInfosFromP2pAddrs(addrs...)
+			if err != nil {
+				continue
+			}
+			mutex.Lock()
+			for _, info := range infos {
+				if len(info.Addrs) == 0 {
+					continue
+				}
+				if known, ok := found[info.ID]; ok {
+					// Daemons announce themselves again every so often
+					known.Addrs = multiaddr.Unique(append(known.Addrs, info.Addrs...))
+				} else {
+					info := info
+					found[info.ID] = &info
+				}
+			}
+			mutex.Unlock()
+		}
+	}()
+	// Browse closes entries when the context ends
+	if err := zeroconf.Browse(ctx, MDNSServiceName, "local", entries); err != nil {
+		return nil, err
+	}
+	<-done
+
+	daemons := make([]peer.AddrInfo, 0, len(found))
+	for _, info := range found {
+		daemons = append(daemons, *info)
+	}
+	sort.Slice(daemons, func(i, j int) bool { return daemons[i].ID < daemons[j].ID })
+	return daemons, nil
+}
@@ -0,0 +1,105 @@
+package p2p
+
+import (
+	"sync"
+
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/p2p/protocol/identify"
+	"github.com/multiformats/go-multiaddr"
+
+	"github.com/hemantsingh443/p2p-git-remote/internal/protocol"
+)
+
+// Reachability statuses, from most to least reachable.
+const (
+	StatusPublic    = "public"
+	StatusBehindNAT = "behind NAT"
+	StatusRelayOnly = "relay-only"
+	StatusUnknown   = "unknown"
+)
+
+// NetStatus follows what AutoNAT finds out about how reachable a host is:
+// whether peers on the internet can dial it, and which of its addresses
+// work.
+type NetStatus struct {
+	host         host.Host
+	mutex        sync.Mutex
+	reachability network.Reachability
+	reachable    []multiaddr.Multiaddr
+	unreachable  []multiaddr.Multiaddr
+}
+
+// WatchNetStatus follows a host's reachability until it's closed. onChange,
+// if not nil, is called with the new status when AutoNAT changes its mind.
+func WatchNetStatus(h host.Host, onChange func(status string)) (*NetStatus, error) {
+	sub, err := h.EventBus().Subscribe([]any{
+		new(event.EvtLocalReachabilityChanged),
+		new(event.EvtHostReachableAddrsChanged),
+	})
+	if err != nil {
+		return nil, err
+	}
+	s := &NetStatus{host: h}
+	go func() {
+		defer sub.Close()
+		for e := range sub.Out() {
+			s.mutex.Lock()
+			switch e := e.(type) {
+			case event.EvtLocalReachabilityChanged:
+				s.reachability = e.Reachability
+			case event.EvtHostReachableAddrsChanged:
+				s.reachable, s.unreachable = e.Reachable, e.Unreachable
+			}
+			s.mutex.Unlock()
+			if _, ok := e.(event.EvtLocalReachabilityChanged); ok && onChange != nil {
+				onChange(s.Report().Status)
+			}
+		}
+	}()
+	return s, nil
+}
+
+// Report describes the host's reachability and addresses.
+func (s *NetStatus) Report() protocol.NetStatusResponsePayload {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	report := protocol.NetStatusResponsePayload{
+		Success:          true,
+		Reachability:     s.reachability.String(),
+		ReachableAddrs:   addrStrings(s.reachable),
+		UnreachableAddrs: addrStrings(s.unreachable),
+	}
+	for _, addr := range s.host.Addrs() {
+		if _, err := addr.ValueForProtocol(multiaddr.P_CIRCUIT); err == nil {
+			report.RelayAddrs = append(report.RelayAddrs, addr.String())
+		} else {
+			report.ListenAddrs = append(report.ListenAddrs, addr.String())
+		}
+	}
+	if ids, ok := s.host.(interface{ IDService() identify.IDService }); ok && ids.IDService() != nil {
+		report.ObservedAddrs = addrStrings(ids.IDService().OwnObservedAddrs())
+	}
+
+	switch {
+	case s.reachability == network.ReachabilityPublic:
+		report.Status = StatusPublic
+	case len(report.RelayAddrs) > 0:
+		// Only advertised when the host can't be dialed directly
+		report.Status = StatusRelayOnly
+	case s.reachability == network.ReachabilityPrivate:
+		report.Status = StatusBehindNAT
+	default:
+		report.Status = StatusUnknown
+	}
+	return report
+}
+
+func addrStrings(addrs []multiaddr.Multiaddr) []string {
+	var strs []string
+	for _, addr := range addrs {
+		strs = append(strs, addr.String())
+	}
+	return strs
+}
@@ -0,0 +1,38 @@
+package p2p
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// PairingCode derives a 6-digit code from the IDs of both sides of a
+// handshake. The daemon and the client each compute it from their own view
+// of the connection and show it, so the owner can check they are really
+// pairing with their own device before trusting it. The order of the IDs
+// doesn't matter.
+func PairingCode(a, b peer.ID) string {
+	if a > b {
+		a, b = b, a
+	}
+	sum := sha256.Sum256([]byte("p2p-git-remote pairing\x00" + string(a) + "\x00" + string(b)))
+	n := binary.BigEndian.Uint64(sum[:8]) % 1000000
+	return fmt.Sprintf("%03d %03d", n/1000, n%1000)
+}
+
+// SamePairingCode compares a code typed by a person with the expected one,
+// ignoring spaces and dashes.
+func SamePairingCode(typed, expected string) bool {
+	clean := func(s string) string {
+		var digits []rune
+		for _, r := range s {
+			if r >= '0' && r <= '9' {
+				digits = append(digits, r)
+			}
+		}
+		return string(digits)
+	}
+	return clean(typed) != "" && clean(typed) == clean(expected)
+}
@@ -0,0 +1,37 @@
+package p2p
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+
+	"github.com/hemantsingh443/p2p-git-remote/internal/protocol"
+)
+
+// Ping sends a PING_REQUEST on a stream to the daemon and returns how long
+// the answer took. Unlike libp2p's ping, it goes through the daemon's own
+// request handling, so it measures what commands experience. Further pings
+// can be sent on the same stream.
+func Ping(stream network.Stream, seq int) (time.Duration, error) {
+	payloadBytes, _ := json.Marshal(protocol.PingRequestPayload{Seq: seq})
+	start := time.Now()
+	if err := protocol.WriteMessage(stream, &protocol.Message{Type: protocol.TypePingRequest, Payload: payloadBytes}); err != nil {
+		return 0, err
+	}
+	resp, err := protocol.ReadMessage(stream)
+	if err != nil {
+		return 0, err
+	}
+	rtt := time.Since(start)
+	var respPayload protocol.PingResponsePayload
+	json.Unmarshal(resp.Payload, &respPayload)
+	if !respPayload.Success {
+		return 0, fmt.Errorf("%s", respPayload.Error)
+	}
+	if respPayload.Seq != seq {
+		return 0, fmt.Errorf("answer to ping %d instead of %d", respPayload.Seq, seq)
+	}
+	return rtt, nil
+}
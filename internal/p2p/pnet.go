@@ -0,0 +1,54 @@
+package p2p
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/pnet"
+)
+
+// LoadOrGenerateSwarmKey loads a pre-shared swarm key from a file, or
+// generates a new one. Hosts with a swarm key only talk to peers holding the
+// same key, which is how libp2p makes a private network.
+func LoadOrGenerateSwarmKey(path string) (pnet.PSK, error) {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		fmt.Printf("Generating new swarm key at %s\n", path)
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, fmt.Errorf("failed to generate swarm key: %w", err)
+		}
+		// The format IPFS and other libp2p tools use
+		data := "/key/swarm/psk/1.0.0/\n/base16/\n" + hex.EncodeToString(key) + "\n"
+		if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+			return nil, fmt.Errorf("failed to write swarm key file: %w", err)
+		}
+	}
+	return LoadSwarmKey(path)
+}
+
+// LoadSwarmKey loads a pre-shared swarm key from a file.
+func LoadSwarmKey(path string) (pnet.PSK, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read swarm key file: %w", err)
+	}
+	psk, err := pnet.DecodeV1PSK(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse swarm key: %w", err)
+	}
+	fmt.Printf("Loaded swarm key from %s; only peers with the same key can connect\n", path)
+	return psk, nil
+}
+
+// PrivateNetwork is the host option to join the private network of a swarm
+// key. Without a key it does nothing.
+func PrivateNetwork(psk pnet.PSK) libp2p.Option {
+	if psk == nil {
+		return func(*libp2p.Config) error { return nil }
+	}
+	return libp2p.PrivateNetwork(psk)
+}
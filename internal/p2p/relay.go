@@ -0,0 +1,71 @@
+package p2p
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+)
+
+// ParseRelays parses a comma-separated list of circuit v2 relay addresses,
+// each ending in /p2p/<relay-id>.
+func ParseRelays(list string) ([]peer.AddrInfo, error) {
+	var addrs []multiaddr.Multiaddr
+	for _, s := range strings.Split(list, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		addr, err := multiaddr.NewMultiaddr(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid relay address '%s': %w", s, err)
+		}
+		addrs = append(addrs, addr)
+	}
+	relays, err := peer.AddrInfosFromP2pAddrs(addrs...)
+	if err != nil {
+		return nil, fmt.Errorf("relay addresses must end in /p2p/<relay-id>: %w", err)
+	}
+	return relays, nil
+}
+
+// StaticRelays keeps a host reachable through the given relays, for when hole
+// punching fails, like behind carrier-grade NAT. The host reserves a slot
+// on them and advertises the relayed addresses. AutoRelay only does so while
+// the host thinks it's unreachable, so this tells it it always is; direct
+// connections are still upgraded to by hole punching.
+func StaticRelays(relays []peer.AddrInfo) libp2p.Option {
+	if len(relays) == 0 {
+		return func(*libp2p.Config) error { return nil }
+	}
+	return libp2p.ChainOptions(
+		libp2p.EnableAutoRelayWithStaticRelays(relays),
+		libp2p.ForceReachabilityPrivate(),
+	)
+}
+
+// WaitForRelayAddr waits until the host has a relayed address, or the
+// timeout passes, and returns it with the host's ID, or nil.
+func WaitForRelayAddr(ctx context.Context, h host.Host, timeout time.Duration) multiaddr.Multiaddr {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	ticker := time.NewTicker(250 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		addrs, _ := peer.AddrInfoToP2pAddrs(&peer.AddrInfo{ID: h.ID(), Addrs: h.Addrs()})
+		for _, addr := range addrs {
+			if _, err := addr.ValueForProtocol(multiaddr.P_CIRCUIT); err == nil {
+				return addr
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package p2p
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+
+	"github.com/hemantsingh443/p2p-git-remote/internal/protocol"
+)
+
+// sessionClaims is what a session token says, signed by the daemon.
+type sessionClaims struct {
+	Daemon  string    `json:"daemon"`
+	Peer    string    `json:"peer"`
+	Issued  time.Time `json:"issued"`
+	Expires time.Time `json:"expires"`
+}
+
+// IssueSession returns a token letting a peer send requests to the daemon
+// owning privKey for ttl. The token is its claims and their signature, both
+// base64.
+func IssueSession(privKey crypto.PrivKey, daemon, client peer.ID, ttl time.Duration) (string, time.Time, error) {
+	now := time.Now()
+	claims, err := json.Marshal(sessionClaims{Daemon: daemon.String(), Peer: client.String(), Issued: now, Expires: now.Add(ttl)})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	signature, err := privKey.Sign(claims)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	token := base64.RawURLEncoding.EncodeToString(claims) + "." + base64.RawURLEncoding.EncodeToString(signature)
+	return token, now.Add(ttl), nil
+}
+
+// VerifySession checks that a token was issued with pubKey to a peer, for
+// this daemon, and hasn't expired.
+func VerifySession(pubKey crypto.PubKey, token string, daemon, client peer.ID) error {
+	if token == "" {
+		return fmt.Errorf("no session; authenticate again")
+	}
+	claimsStr, signatureStr, _ := strings.Cut(token, ".")
+	claims, err1 := base64.RawURLEncoding.DecodeString(claimsStr)
+	signature, err2 := base64.RawURLEncoding.DecodeString(signatureStr)
+	if err1 != nil || err2 != nil {
+		return fmt.Errorf("malformed session token")
+	}
+	if ok, err := pubKey.Verify(claims, signature); err != nil || !ok {
+		return fmt.Errorf("invalid session token")
+	}
+	var c sessionClaims
+	if err := json.Unmarshal(claims, &c); err != nil {
+		return fmt.Errorf("malformed session token")
+	}
+	if c.Daemon != daemon.String() || c.Peer != client.String() {
+		return fmt.Errorf("the session token belongs to another peer")
+	}
+	if time.Now().After(c.Expires) {
+		return fmt.Errorf("the session expired at %s", c.Expires.Local().Format("2006-01-02 15:04"))
+	}
+	return nil
+}
+
+// renewBefore is how long before it expires a session is renewed.
+const renewBefore = time.Minute
+
+// SessionKeeper keeps a client's session with a daemon current. The token
+// itself lives in the protocol package, which sends it with every message
+// to that daemon.
+type SessionKeeper struct {
+	host    host.Host
+	daemon  peer.ID
+	mutex   sync.Mutex
+	expires time.Time
+	off     bool // The daemon doesn't use sessions
+	conn    *ConnSupervisor
+}
+
+func NewSessionKeeper(h host.Host, daemon peer.ID) *SessionKeeper {
+	return &SessionKeeper{host: h, daemon: daemon}
+}
+
+// SetConn makes renewing the session reconnect to the daemon if needed.
+func (k *SessionKeeper) SetConn(conn *ConnSupervisor) {
+	k.conn = conn
+}
+
+// Set stores a session the daemon issued, like the one in the handshake
+// response.
+func (k *SessionKeeper) Set(token string, expires time.Time) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	protocol.SetSessionToken(k.daemon, token)
+	k.expires, k.off = expires, token == ""
+	if k.off {
+		k.expires = time.Now().Add(24 * time.Hour)
+	}
+}
+
+// Ensure renews the session if there is none, or it's about to expire, by
+// signing a fresh challenge from the daemon. Daemons that don't use
+// sessions answer with an empty token, which is fine.
+func (k *SessionKeeper) Ensure(ctx context.Context) error {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	if (protocol.SessionToken(k.daemon) != "" || k.off) && time.Until(k.expires) > renewBefore {
+		return nil
+	}
+
+	var stream network.Stream
+	var err error
+	if k.conn != nil {
+		stream, err = k.conn.NewStream(ctx)
+	} else {
+		stream, err = k.host.NewStream(ctx, k.daemon, protocol.ProtocolID)
+	}
+	if err != nil {
+		return err
+	}
+	stream = protocol.Buffered(stream)
+	defer stream.Close()
+
+	if err := protocol.WriteMessage(stream, &protocol.Message{Type: protocol.TypeSessionRequest}); err != nil {
+		return err
+	}
+	resp, err := protocol.ReadMessage(stream)
+	if err != nil {
+		return err
+	}
+	if resp.Type == protocol.TypeHandshakeChallenge {
+		var challenge protocol.HandshakeChallengePayload
+		json.Unmarshal(resp.Payload, &challenge)
+		pubKey, signature, err := SignChallenge(k.host.Peerstore().PrivKey(k.host.ID()), challenge.Nonce, k.daemon, k.host.ID())
+		if err != nil {
+			return err
+		}
+		payloadBytes, _ := json.Marshal(protocol.HandshakeProofPayload{PublicKey: pubKey, Signature: signature})
+		if err := protocol.WriteMessage(stream, &protocol.Message{Type: protocol.TypeHandshakeProof, Payload: payloadBytes}); err != nil {
+			return err
+		}
+		if resp, err = protocol.ReadMessage(stream); err != nil {
+			return err
+		}
+	}
+	if resp.Type != protocol.TypeSessionResponse {
+		return fmt.Errorf("unexpected response %s", resp.Type)
+	}
+
+	var payload protocol.SessionResponsePayload
+	json.Unmarshal(resp.Payload, &payload)
+	if !payload.Success {
+		return fmt.Errorf("%s", payload.Error)
+	}
+	protocol.SetSessionToken(k.daemon, payload.Token)
+	k.expires, k.off = payload.ExpiresAt, payload.Token == ""
+	if k.off {
+		k.expires = time.Now().Add(24 * time.Hour) // Ask again tomorrow
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package p2p
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// testPeer is a key pair and the peer ID it belongs to.
+type testPeer struct {
+	priv crypto.PrivKey
+	id   peer.ID
+}
+
+func newTestPeer(t *testing.T) testPeer {
+	t.Helper()
+	priv, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := peer.IDFromPrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testPeer{priv, id}
+}
+
+func issue(t *testing.T, signer testPeer, daemon, client peer.ID, ttl time.Duration) string {
+	t.Helper()
+	token, _, err := IssueSession(signer.priv, daemon, client, ttl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestVerifySession(t *testing.T) {
+	daemon, other, client := newTestPeer(t), newTestPeer(t), newTestPeer(t)
+	valid := issue(t, daemon, daemon.id, client.id, time.Hour)
+
+	// The claims of a valid token, changed to last longer but keeping its
+	// signature
+	claimsStr, signature, _ := strings.Cut(valid, ".")
+	claimsJSON, _ := base64.RawURLEncoding.DecodeString(claimsStr)
+	var claims sessionClaims
+	json.Unmarshal(claimsJSON, &claims)
+	claims.Expires = claims.Expires.Add(24 * time.Hour)
+	claimsJSON, _ = json.Marshal(claims)
+	extended := base64.RawURLEncoding.EncodeToString(claimsJSON) + "." + signature
+
+	for _, tc := range []struct {
+		name   string
+		token  string
+		daemon testPeer
+		client peer.ID
+		ok     bool
+	}{
+		{"valid", valid, daemon, client.id, true},
+		{"empty", "", daemon, client.id, false},
+		{"malformed", "not a token", daemon, client.id, false},
+		{"signed by another key", issue(t, other, daemon.id, client.id, time.Hour), daemon, client.id, false},
+		{"claims changed", extended, daemon, client.id, false},
+		{"expired", issue(t, daemon, daemon.id, client.id, -time.Second), daemon, client.id, false},
+		{"another client", valid, daemon, other.id, false},
+		// Another daemon checks with its own key and ID, even one the token
+		// names correctly but that didn't sign it
+		{"another daemon", issue(t, other, other.id, client.id, time.Hour), daemon, client.id, false},
+		{"presented to another daemon", valid, other, client.id, false},
+	} {
+		err := VerifySession(tc.daemon.priv.GetPublic(), tc.token, tc.daemon.id, tc.client)
+		if (err == nil) != tc.ok {
+			t.Errorf("%s: got %v, want ok %v", tc.name, err, tc.ok)
+		}
+	}
+}
+
+func TestVerifySessionOtherDaemonSameKey(t *testing.T) {
+	// A daemon's ID is checked too, not only its signature
+	daemon, client := newTestPeer(t), newTestPeer(t)
+	token := issue(t, daemon, daemon.id, client.id, time.Hour)
+	other := newTestPeer(t)
+	if err := VerifySession(daemon.priv.GetPublic(), token, other.id, client.id); err == nil {
+		t.Fatal("a token for one daemon ID was accepted under another")
+	}
+}
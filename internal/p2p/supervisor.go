@@ -0,0 +1,152 @@
+package p2p
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/net/swarm"
+	"github.com/multiformats/go-multiaddr"
+
+	"github.com/hemantsingh443/p2p-git-remote/internal/protocol"
+)
+
+// Reconnection backoff: the first retry comes quickly, in case the daemon
+// only restarted, later ones less and less often.
+const (
+	reconnectFirstWait = 500 * time.Millisecond
+	reconnectMaxWait   = 10 * time.Second
+	reconnectAttempts  = 7 // About 35 seconds in all
+)
+
+// Resolver looks up a peer's current addresses, like a DHT does.
+type Resolver func(ctx context.Context, id peer.ID) (peer.AddrInfo, error)
+
+// ConnSupervisor keeps a client connected to its daemon. It notices when
+// the connection drops and dials again with exponential backoff, so that
+// the daemon restarting doesn't leave every later command failing.
+type ConnSupervisor struct {
+	host     host.Host
+	daemon   peer.AddrInfo
+	notifiee network.Notifiee
+
+	mutex   sync.Mutex // Held while reconnecting
+	resolve Resolver
+
+	// Notify, if set, is told what the supervisor does, for the user.
+	Notify func(msg string)
+}
+
+// NewConnSupervisor supervises the connection of h to a daemon it's
+// connected to, which the connection manager then never closes, however
+// many DHT peers the host is connected to.
+func NewConnSupervisor(h host.Host, daemon peer.AddrInfo) *ConnSupervisor {
+	s := &ConnSupervisor{host: h, daemon: daemon}
+	h.ConnManager().Protect(daemon.ID, "daemon")
+	s.notifiee = &network.NotifyBundle{
+		DisconnectedF: func(n network.Network, c network.Conn) {
+			if c.RemotePeer() != daemon.ID || n.Connectedness(daemon.ID) == network.Connected {
+				return
+			}
+			s.notify("Lost the connection to the daemon; reconnecting...")
+			go s.Reconnect(context.Background())
+		},
+	}
+	h.Network().Notify(s.notifiee)
+	return s
+}
+
+// Stop stops supervising the connection, for a client leaving the daemon:
+// it no longer reconnects, and the connection manager may close it.
+func (s *ConnSupervisor) Stop() {
+	s.host.Network().StopNotify(s.notifiee)
+	s.host.ConnManager().Unprotect(s.daemon.ID, "daemon")
+}
+
+// SetResolver makes reconnecting look up the daemon's current addresses
+// first, in case they changed.
+func (s *ConnSupervisor) SetResolver(resolve Resolver) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.resolve = resolve
+}
+
+// NewStream opens a stream to the daemon. If that fails, it reconnects and
+// tries once more, so a command sent while the daemon restarted still goes
+// through.
+func (s *ConnSupervisor) NewStream(ctx context.Context) (network.Stream, error) {
+	stream, err := s.host.NewStream(ctx, s.daemon.ID, protocol.ProtocolID)
+	if err == nil {
+		return protocol.Buffered(stream), nil
+	}
+	if err := s.Reconnect(ctx); err != nil {
+		return nil, err
+	}
+	if stream, err = s.host.NewStream(ctx, s.daemon.ID, protocol.ProtocolID); err != nil {
+		return nil, err
+	}
+	return protocol.Buffered(stream), nil
+}
+
+// Reconnect dials the daemon until it answers, waiting longer after each
+// failure. It returns at once if the daemon is connected already.
+func (s *ConnSupervisor) Reconnect(ctx context.Context) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	wait := reconnectFirstWait
+	var err error
+	for attempt := 1; attempt <= reconnectAttempts; attempt++ {
+		if s.host.Network().Connectedness(s.daemon.ID) == network.Connected {
+			return nil
+		}
+		if err = s.dial(ctx); err == nil {
+			if attempt > 1 {
+				s.notify("Reconnected to the daemon.")
+			}
+			return nil
+		}
+		if attempt == reconnectAttempts {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+		if wait *= 2; wait > reconnectMaxWait {
+			wait = reconnectMaxWait
+		}
+	}
+	return fmt.Errorf("could not reconnect to the daemon: %w", err)
+}
+
+func (s *ConnSupervisor) dial(ctx context.Context) error {
+	info := s.daemon
+	if s.resolve != nil {
+		resolveCtx, cancel := context.WithTimeout(ctx, 15*time.Second)
+		found, err := s.resolve(resolveCtx, s.daemon.ID)
+		cancel()
+		if err == nil && len(found.Addrs) > 0 {
+			// Try the current addresses first, the known ones after
+			info.Addrs = multiaddr.Unique(append(found.Addrs, s.daemon.Addrs...))
+		}
+	}
+	// The swarm refuses to dial a peer it just failed to reach for a while
+	if sw, ok := s.host.Network().(*swarm.Swarm); ok {
+		sw.Backoff().Clear(info.ID)
+	}
+	dialCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	return s.host.Connect(dialCtx, info)
+}
+
+func (s *ConnSupervisor) notify(msg string) {
+	if s.Notify != nil {
+		s.Notify(msg)
+	}
+}
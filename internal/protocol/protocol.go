@@ -4,8 +4,12 @@ import (
 	"bufio"
 	"encoding/json"
 	"fmt"
+	"io"
+	"sync"
+	"time"
 
 	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
 )
 
 // ProtocolID is the unique identifier for our protocol.
@@ -15,22 +19,70 @@ const ProtocolID = "/p2p-git-remote/1.0.0"
 type Message struct {
 	Type    string          `json:"type"`
 	Payload json.RawMessage `json:"payload"`
+	Session string          `json:"session,omitempty"` // Token from the handshake or a SESSION_REQUEST
 }
 
 // Payloads for specific message types
+
+// HandshakeRequestPayload starts pairing. With a one-time pairing token from
+// the daemon's owner, the daemon approves the client without asking anyone.
+type HandshakeRequestPayload struct {
+	Token string `json:"token,omitempty"`
+}
+
 type HandshakeResponsePayload struct {
-	Approved bool `json:"approved"`
+	Approved bool   `json:"approved"`
+	Role     string `json:"role,omitempty"` // What the daemon allows the client to do
+
+	// The first session, if the daemon uses them
+	Session        string    `json:"session,omitempty"`
+	SessionExpires time.Time `json:"session_expires"`
 }
 
 type GitCommitRequestPayload struct {
 	RepoPath string `json:"repo_path"`
 	Message  string `json:"message"`
 	Branch   string `json:"branch"`
+	Amend    bool   `json:"amend,omitempty"`   // Amend HEAD instead of creating a new commit. An empty Message keeps the old one.
+	Force    bool   `json:"force,omitempty"`   // Allow amending a commit that has already been pushed
+	Confirm  string `json:"confirm,omitempty"` // With Amend and Force; the repo name typed again (see GitResetRequestPayload)
+
+	// Optional author override so commits aren't attributed to the daemon's git identity
+	AuthorName  string `json:"author_name,omitempty"`
+	AuthorEmail string `json:"author_email,omitempty"`
+	SignOff     bool   `json:"sign_off,omitempty"` // Add a Signed-off-by trailer
+	Sign        bool   `json:"sign,omitempty"`     // GPG/SSH sign using the repo's configured signing key
+
+	SetUpstream bool `json:"set_upstream,omitempty"` // Push with -u if the branch has no upstream yet
+	DryRun      bool `json:"dry_run,omitempty"`      // Only report what would be committed
+	StagedOnly  bool `json:"staged_only,omitempty"`  // Commit only what is already staged, e.g. with STAGE_HUNKS
+
+	Remote string `json:"remote,omitempty"` // Push here instead of the repo's default remote
+
+	AllowSecrets bool `json:"allow_secrets,omitempty"` // Commit even if the daemon's secret scan finds credentials
+
+	Background bool `json:"background,omitempty"` // Answer once the job is queued rather than when the push is done
 }
 
 type GitCommitResponsePayload struct {
-	Success bool   `json:"success"`
-	Output  string `json:"output"`
+	Success  bool   `json:"success"`
+	Output   string `json:"output"`
+	Upstream string `json:"upstream,omitempty"` // Newly set tracking branch, e.g. "origin/feature-x"
+
+	Files []DiffStat `json:"files,omitempty"` // For DryRun requests, what would be committed
+
+	Secrets []SecretFinding `json:"secrets,omitempty"` // Why the secret scan blocked the commit
+
+	JobID string `json:"job_id,omitempty"` // The job that committed and pushed; see JOB_STATUS_REQUEST
+}
+
+// SecretFinding is a possible credential in the changes being committed.
+// Match is redacted.
+type SecretFinding struct {
+	File  string `json:"file"`
+	Line  int    `json:"line"`
+	Kind  string `json:"kind"`
+	Match string `json:"match"`
 }
 
 // --- NEW MESSAGE TYPES ---
@@ -92,9 +144,242 @@ const (
 	TypeGitStashPopRequest   = "GIT_STASH_POP_REQUEST"
 	TypeGitStashPopResponse  = "GIT_STASH_POP_RESPONSE"
 
+	// New for stash management
+	TypeGitStashListRequest   = "GIT_STASH_LIST_REQUEST"
+	TypeGitStashListResponse  = "GIT_STASH_LIST_RESPONSE"
+	TypeGitStashApplyRequest  = "GIT_STASH_APPLY_REQUEST"
+	TypeGitStashApplyResponse = "GIT_STASH_APPLY_RESPONSE"
+	TypeGitStashDropRequest   = "GIT_STASH_DROP_REQUEST"
+	TypeGitStashDropResponse  = "GIT_STASH_DROP_RESPONSE"
+
 	// New for git reset
 	TypeGitResetRequest  = "GIT_RESET_REQUEST"
 	TypeGitResetResponse = "GIT_RESET_RESPONSE"
+
+	// New for git clean
+	TypeGitCleanRequest  = "GIT_CLEAN_REQUEST"
+	TypeGitCleanResponse = "GIT_CLEAN_RESPONSE"
+
+	// New for worktrees
+	TypeListWorktreesRequest  = "LIST_WORKTREES_REQUEST"
+	TypeListWorktreesResponse = "LIST_WORKTREES_RESPONSE"
+	TypeAddWorktreeRequest    = "ADD_WORKTREE_REQUEST"
+	TypeAddWorktreeResponse   = "ADD_WORKTREE_RESPONSE"
+
+	// New for merge conflict resolution
+	TypeConflictsListRequest    = "CONFLICTS_LIST_REQUEST"
+	TypeConflictsListResponse   = "CONFLICTS_LIST_RESPONSE"
+	TypeResolveConflictRequest  = "RESOLVE_CONFLICT_REQUEST"
+	TypeResolveConflictResponse = "RESOLVE_CONFLICT_RESPONSE"
+	TypeContinueMergeRequest    = "CONTINUE_MERGE_REQUEST"
+	TypeContinueMergeResponse   = "CONTINUE_MERGE_RESPONSE"
+
+	// New for running repository hooks. The daemon sends any number of
+	// RUN_HOOK_OUTPUT messages followed by a single RUN_HOOK_RESPONSE.
+	TypeRunHookRequest  = "RUN_HOOK_REQUEST"
+	TypeRunHookOutput   = "RUN_HOOK_OUTPUT"
+	TypeRunHookResponse = "RUN_HOOK_RESPONSE"
+
+	// New for per-file history
+	TypeFileLogRequest  = "FILE_LOG_REQUEST"
+	TypeFileLogResponse = "FILE_LOG_RESPONSE"
+
+	// New for driving git bisect. All four requests answer with a BisectResponsePayload.
+	TypeBisectStartRequest = "BISECT_START_REQUEST"
+	TypeBisectGoodRequest  = "BISECT_GOOD_REQUEST"
+	TypeBisectBadRequest   = "BISECT_BAD_REQUEST"
+	TypeBisectResetRequest = "BISECT_RESET_REQUEST"
+	TypeBisectResponse     = "BISECT_RESPONSE"
+
+	// New for exchanging git bundles. Bundle bytes travel as BUNDLE_DATA chunks:
+	// from the daemon before BUNDLE_CREATE_RESPONSE, and from the client after
+	// BUNDLE_UPLOAD_REQUEST until Size bytes have been sent.
+	TypeBundleCreateRequest  = "BUNDLE_CREATE_REQUEST"
+	TypeBundleCreateResponse = "BUNDLE_CREATE_RESPONSE"
+	TypeBundleUploadRequest  = "BUNDLE_UPLOAD_REQUEST"
+	TypeBundleUploadResponse = "BUNDLE_UPLOAD_RESPONSE"
+	TypeBundleData           = "BUNDLE_DATA"
+
+	// New for repository statistics
+	TypeRepoStatsRequest  = "REPO_STATS_REQUEST"
+	TypeRepoStatsResponse = "REPO_STATS_RESPONSE"
+
+	// New for commit message templates
+	TypeGetCommitTemplateRequest  = "GET_COMMIT_TEMPLATE_REQUEST"
+	TypeGetCommitTemplateResponse = "GET_COMMIT_TEMPLATE_RESPONSE"
+
+	// New for discarding changes to a single file
+	TypeCheckoutFileRequest  = "CHECKOUT_FILE_REQUEST"
+	TypeCheckoutFileResponse = "CHECKOUT_FILE_RESPONSE"
+
+	// New for reflog viewing and recovery
+	TypeReflogRequest         = "REFLOG_REQUEST"
+	TypeReflogResponse        = "REFLOG_RESPONSE"
+	TypeResetToReflogRequest  = "RESET_TO_REFLOG_REQUEST"
+	TypeResetToReflogResponse = "RESET_TO_REFLOG_RESPONSE"
+
+	// New for hunk-level staging (the remote `git add -p`)
+	TypeListHunksRequest   = "LIST_HUNKS_REQUEST"
+	TypeListHunksResponse  = "LIST_HUNKS_RESPONSE"
+	TypeStageHunksRequest  = "STAGE_HUNKS_REQUEST"
+	TypeStageHunksResponse = "STAGE_HUNKS_RESPONSE"
+
+	// New for choosing the remote commits are pushed to
+	TypeListRemotesRequest       = "LIST_REMOTES_REQUEST"
+	TypeListRemotesResponse      = "LIST_REMOTES_RESPONSE"
+	TypeSetDefaultRemoteRequest  = "SET_DEFAULT_REMOTE_REQUEST"
+	TypeSetDefaultRemoteResponse = "SET_DEFAULT_REMOTE_RESPONSE"
+
+	// New for reading and changing a repo's git config
+	TypeGitConfigGetRequest  = "GIT_CONFIG_GET_REQUEST"
+	TypeGitConfigGetResponse = "GIT_CONFIG_GET_RESPONSE"
+	TypeGitConfigSetRequest  = "GIT_CONFIG_SET_REQUEST"
+	TypeGitConfigSetResponse = "GIT_CONFIG_SET_RESPONSE"
+
+	// New for managing .gitignore
+	TypeIgnoreRequest    = "IGNORE_REQUEST"
+	TypeIgnoreResponse   = "IGNORE_RESPONSE"
+	TypeUnignoreRequest  = "UNIGNORE_REQUEST"
+	TypeUnignoreResponse = "UNIGNORE_RESPONSE"
+
+	// New for deleting files and directories
+	TypeDeletePathRequest  = "DELETE_PATH_REQUEST"
+	TypeDeletePathResponse = "DELETE_PATH_RESPONSE"
+
+	// New for creating files and directories
+	TypeCreateFileRequest  = "CREATE_FILE_REQUEST"
+	TypeCreateFileResponse = "CREATE_FILE_RESPONSE"
+	TypeMkdirRequest       = "MKDIR_REQUEST"
+	TypeMkdirResponse      = "MKDIR_RESPONSE"
+
+	// New for browsing the repo one directory at a time
+	TypeListDirRequest  = "LIST_DIR_REQUEST"
+	TypeListDirResponse = "LIST_DIR_RESPONSE"
+
+	// New for searching file contents
+	TypeGrepRequest  = "GREP_REQUEST"
+	TypeGrepResponse = "GREP_RESPONSE"
+
+	// New for copying arbitrary files. File bytes travel as FILE_DATA chunks,
+	// the same way bundles use BUNDLE_DATA: from the daemon before
+	// DOWNLOAD_FILE_RESPONSE, and from the client after UPLOAD_FILE_REQUEST
+	// until Size bytes have been sent.
+	TypeDownloadFileRequest  = "DOWNLOAD_FILE_REQUEST"
+	TypeDownloadFileResponse = "DOWNLOAD_FILE_RESPONSE"
+	TypeUploadFileRequest    = "UPLOAD_FILE_REQUEST"
+	TypeUploadFileResponse   = "UPLOAD_FILE_RESPONSE"
+	TypeFileData             = "FILE_DATA"
+
+	// New for file change notifications. After a successful
+	// SUBSCRIBE_EVENTS_RESPONSE the stream stays open and the daemon sends a
+	// FILE_CHANGED message whenever files in the watched repos change.
+	TypeSubscribeEventsRequest  = "SUBSCRIBE_EVENTS_REQUEST"
+	TypeSubscribeEventsResponse = "SUBSCRIBE_EVENTS_RESPONSE"
+	TypeFileChangedEvent        = "FILE_CHANGED"
+	TypeRepoEvent               = "REPO_EVENT"
+
+	// New for changing file permissions
+	TypeChmodRequest  = "CHMOD_REQUEST"
+	TypeChmodResponse = "CHMOD_RESPONSE"
+
+	// New for uploading edits as patches instead of whole files
+	TypeApplyPatchRequest  = "APPLY_PATCH_REQUEST"
+	TypeApplyPatchResponse = "APPLY_PATCH_RESPONSE"
+
+	// New for applying several file changes at once, all or nothing
+	TypeBatchRequest  = "BATCH_REQUEST"
+	TypeBatchResponse = "BATCH_RESPONSE"
+
+	// New for following a file. After a successful TAIL_RESPONSE the stream
+	// stays open and the daemon sends TAIL_DATA whenever the file grows,
+	// until the client closes it.
+	TypeTailRequest  = "TAIL_REQUEST"
+	TypeTailResponse = "TAIL_RESPONSE"
+	TypeTailData     = "TAIL_DATA"
+
+	// New for per-peer roles. Sent instead of the usual response when the
+	// peer's role doesn't allow a request; ReadMessage turns it into an
+	// *AccessDeniedError.
+	TypeAccessDenied = "ACCESS_DENIED"
+
+	// New for listing and revoking trusted peers (admin only)
+	TypeListPeersRequest   = "LIST_PEERS_REQUEST"
+	TypeListPeersResponse  = "LIST_PEERS_RESPONSE"
+	TypeRevokePeerRequest  = "REVOKE_PEER_REQUEST"
+	TypeRevokePeerResponse = "REVOKE_PEER_RESPONSE"
+
+	// New for reading the daemon's audit log (admin only)
+	TypeAuditLogRequest  = "AUDIT_LOG_REQUEST"
+	TypeAuditLogResponse = "AUDIT_LOG_RESPONSE"
+
+	// New for per-peer rate limits. Sent instead of the usual response when
+	// the peer sent too many requests of a kind; ReadMessage turns it into
+	// a *RateLimitedError.
+	TypeRateLimited = "RATE_LIMITED"
+
+	// New for proving key ownership during the handshake. The daemon answers
+	// a HANDSHAKE_REQUEST with a HANDSHAKE_CHALLENGE, and only asks its owner
+	// to approve the client once it has signed the nonce.
+	TypeHandshakeChallenge = "HANDSHAKE_CHALLENGE"
+	TypeHandshakeProof     = "HANDSHAKE_PROOF"
+
+	// New for session tokens. A trusted peer renews its session with a
+	// SESSION_REQUEST, answered like a handshake with a HANDSHAKE_CHALLENGE
+	// and then a SESSION_RESPONSE. Requests without a valid token get a
+	// SESSION_EXPIRED instead of their response; ReadMessage turns it into a
+	// *SessionExpiredError.
+	TypeSessionRequest  = "SESSION_REQUEST"
+	TypeSessionResponse = "SESSION_RESPONSE"
+	TypeSessionExpired  = "SESSION_EXPIRED"
+
+	// New for read-only mode. An empty request asks whether the daemon is
+	// in read-only mode; one with Enabled turns it on or off (admin only).
+	TypeReadOnlyRequest  = "READ_ONLY_REQUEST"
+	TypeReadOnlyResponse = "READ_ONLY_RESPONSE"
+
+	// New for the connection gater. Opens a window in which peers the
+	// daemon doesn't trust may connect to pair (admin only).
+	TypePairingWindowRequest  = "PAIRING_WINDOW_REQUEST"
+	TypePairingWindowResponse = "PAIRING_WINDOW_RESPONSE"
+
+	// New for the blocklist. An empty Entry only lists it (admin only).
+	TypeBlockRequest  = "BLOCK_REQUEST"
+	TypeBlockResponse = "BLOCK_RESPONSE"
+
+	// Destructive requests parked until another admin device approves them
+	TypeApprovalsRequest         = "APPROVALS_REQUEST"
+	TypeApprovalsResponse        = "APPROVALS_RESPONSE"
+	TypeApprovalRequired         = "APPROVAL_REQUIRED"
+	TypeApprovalDecision         = "APPROVAL_DECISION"
+	TypeApprovalDecisionResponse = "APPROVAL_DECISION_RESPONSE"
+	TypeApprovalPending          = "APPROVAL_PENDING"
+
+	// How reachable the daemon is, for debugging connection problems
+	TypeNetStatusRequest  = "NET_STATUS_REQUEST"
+	TypeNetStatusResponse = "NET_STATUS_RESPONSE"
+
+	// Round trips over the application protocol, to measure latency
+	TypePingRequest  = "PING_REQUEST"
+	TypePingResponse = "PING_RESPONSE"
+
+	// Renaming a linked repo's alias
+	TypeRenameAliasRequest  = "RENAME_ALIAS_REQUEST"
+	TypeRenameAliasResponse = "RENAME_ALIAS_RESPONSE"
+
+	// Daemon health at a glance
+	TypeDaemonStatusRequest  = "DAEMON_STATUS_REQUEST"
+	TypeDaemonStatusResponse = "DAEMON_STATUS_RESPONSE"
+
+	// Long operations, like pushes, run as jobs that can be listed, followed
+	// and cancelled. With Follow, a JOB_STATUS_REQUEST is answered with
+	// JOB_PROGRESS messages until the job ends, then its JOB_STATUS_RESPONSE.
+	TypeJobsListRequest   = "JOBS_LIST_REQUEST"
+	TypeJobsListResponse  = "JOBS_LIST_RESPONSE"
+	TypeJobStatusRequest  = "JOB_STATUS_REQUEST"
+	TypeJobStatusResponse = "JOB_STATUS_RESPONSE"
+	TypeJobProgress       = "JOB_PROGRESS"
+	TypeJobCancelRequest  = "JOB_CANCEL_REQUEST"
+	TypeJobCancelResponse = "JOB_CANCEL_RESPONSE"
 )
 
 // New Payloads
@@ -105,33 +390,72 @@ type ListReposResponsePayload struct {
 type ReadFileRequestPayload struct {
 	RepoPath string `json:"repo_path"`
 	FilePath string `json:"file_path"` // e.g., "README.md" or "src/main.go"
+	// Hexdump asks for the raw bytes of a binary file no larger than MaxHexdumpSize
+	Hexdump bool `json:"hexdump,omitempty"`
+	// A range of lines to send instead of the whole file. Both are 1-based
+	// and inclusive; 0 means the start or the end of the file.
+	StartLine int `json:"start_line,omitempty"`
+	EndLine   int `json:"end_line,omitempty"`
 }
 
+// MaxHexdumpSize is the largest binary file the daemon sends for a hexdump;
+// anything bigger should be downloaded.
+const MaxHexdumpSize = 64 * 1024
+
 type ReadFileResponsePayload struct {
 	Success bool   `json:"success"`
 	Content string `json:"content"`
 	Error   string `json:"error,omitempty"`
+	// Binary files aren't sent as Content, which would mangle them, but
+	// described by their size and MIME type
+	Binary   bool   `json:"binary,omitempty"`
+	Size     int64  `json:"size,omitempty"`
+	MimeType string `json:"mime_type,omitempty"`
+	Data     []byte `json:"data,omitempty"` // Raw bytes of a binary file, if a hexdump was asked for
+	// Number of lines in the whole file, set when a range was asked for
+	TotalLines int `json:"total_lines,omitempty"`
 }
 
 type WriteFileRequestPayload struct {
 	RepoPath string `json:"repo_path"`
 	FilePath string `json:"file_path"`
 	Content  string `json:"content"`
+	// BaseHash, if set, is the blob hash of the version the new content was
+	// based on. The write is rejected if the file has changed since.
+	BaseHash string `json:"base_hash,omitempty"`
 }
 
 type WriteFileResponsePayload struct {
 	Success bool   `json:"success"`
 	Error   string `json:"error,omitempty"`
+	// Set when BaseHash didn't match, with the file as it is now, so the
+	// client can merge its changes into it
+	Conflict       bool   `json:"conflict,omitempty"`
+	CurrentContent string `json:"current_content,omitempty"`
+	CurrentHash    string `json:"current_hash,omitempty"`
+	// Written is what git sees after the write, so the client can confirm it
+	Written *WrittenFile `json:"written,omitempty"`
+}
+
+// WrittenFile describes a file as it is on disk right after a write.
+type WrittenFile struct {
+	Hash   string `json:"hash"`             // git blob hash of the content
+	Status string `json:"status,omitempty"` // `git status --porcelain` line, empty if unchanged
 }
 
 type ListFilesRequestPayload struct {
-	RepoPath string `json:"repo_path"`
+	RepoPath string   `json:"repo_path"`
+	Prefix   string   `json:"prefix,omitempty"`   // Only list files under this directory
+	Patterns []string `json:"patterns,omitempty"` // Only list files matching one of these globs; "**" matches any directories
+	// Files matched by .gitignore are left out unless this is set
+	IncludeIgnored bool `json:"include_ignored,omitempty"`
 }
 
 type ListFilesResponsePayload struct {
-	Success bool     `json:"success"`
-	Files   []string `json:"files"`
-	Error   string   `json:"error,omitempty"`
+	Success bool       `json:"success"`
+	Files   []string   `json:"files"`
+	Entries []DirEntry `json:"entries,omitempty"` // Same files with metadata; Name is the path from the repo root
+	Error   string     `json:"error,omitempty"`
 }
 
 type RenameFileRequestPayload struct {
@@ -162,6 +486,8 @@ type ListBranchesRequestPayload struct {
 type ListBranchesResponsePayload struct {
 	Success  bool     `json:"success"`
 	Branches []string `json:"branches"`
+	Current  string   `json:"current,omitempty"` // Checked out branch, "HEAD" when detached
+	Default  string   `json:"default,omitempty"` // The remote's default branch, e.g. "main"
 	Error    string   `json:"error,omitempty"`
 }
 
@@ -191,34 +517,98 @@ type GitStatusRequestPayload struct {
 }
 
 type GitStatusResponsePayload struct {
-	Success bool   `json:"success"`
-	Output  string `json:"output"`
+	Success bool          `json:"success"`
+	Output  string        `json:"output"`
+	Files   []StatusEntry `json:"files,omitempty"`
+
+	Branch    string `json:"branch,omitempty"`
+	Upstream  string `json:"upstream,omitempty"` // e.g. "origin/main"
+	Ahead     int    `json:"ahead,omitempty"`
+	Behind    int    `json:"behind,omitempty"`
+	Staged    int    `json:"staged,omitempty"`
+	Unstaged  int    `json:"unstaged,omitempty"`
+	Untracked int    `json:"untracked,omitempty"`
+}
+
+// Badge summarizes the status compactly, e.g. "feature-x ↑2 ↓1 ✚3".
+func (p *GitStatusResponsePayload) Badge() string {
+	badge := p.Branch
+	if p.Ahead > 0 {
+		badge += fmt.Sprintf(" ↑%d", p.Ahead)
+	}
+	if p.Behind > 0 {
+		badge += fmt.Sprintf(" ↓%d", p.Behind)
+	}
+	if changes := p.Staged + p.Unstaged + p.Untracked; changes > 0 {
+		badge += fmt.Sprintf(" ✚%d", changes)
+	}
+	return badge
+}
+
+// StatusEntry is one changed path, with porcelain status letters
+// ("M", "A", "D", "?", ...; " " when unchanged on that side).
+type StatusEntry struct {
+	Path     string `json:"path"`
+	Staging  string `json:"staging"`
+	Worktree string `json:"worktree"`
 }
 
 // Add new payloads
 type GitLogRequestPayload struct {
-	RepoPath string `json:"repo_path"`
+	RepoPath       string `json:"repo_path"`
+	ShowSignatures bool   `json:"show_signatures,omitempty"` // Include each commit's signature status
+	Limit          int    `json:"limit,omitempty"`           // Number of commits to return, defaults to 15
 }
 
 type GitLogResponsePayload struct {
-	Success bool   `json:"success"`
-	Output  string `json:"output"`
+	Success bool       `json:"success"`
+	Output  string     `json:"output"`            // Pre-formatted graph, kept for older clients
+	Commits []LogEntry `json:"commits,omitempty"` // Structured history, newest first
+}
+
+// LogEntry is a single commit in a structured log response.
+type LogEntry struct {
+	Hash       string    `json:"hash"`
+	AbbrevHash string    `json:"abbrev_hash"`
+	Author     string    `json:"author"`
+	Email      string    `json:"email"`
+	Date       time.Time `json:"date"`
+	Subject    string    `json:"subject"`
+	Refs       []string  `json:"refs,omitempty"`
+	Signature  string    `json:"signature,omitempty"` // git's %G? status, e.g. G (good) or N (unsigned)
+	Path       string    `json:"path,omitempty"`      // The file's path at this commit, FILE_LOG only
 }
 
 // Add new payloads
 type GitDiffRequestPayload struct {
 	RepoPath string `json:"repo_path"`
 	FilePath string `json:"file_path,omitempty"` // Optional file path
+	Stat     bool   `json:"stat,omitempty"`      // Only summarize changes per file, like --stat
+
+	WordDiff       bool `json:"word_diff,omitempty"`        // Mark changed words inline, like --word-diff
+	IgnoreAllSpace bool `json:"ignore_all_space,omitempty"` // Ignore whitespace changes, like -w
+	ContextLines   int  `json:"context_lines,omitempty"`    // 0 for the default of 3, -1 for none
 }
 
 type GitDiffResponsePayload struct {
-	Success bool   `json:"success"`
-	Output  string `json:"output"`
+	Success bool       `json:"success"`
+	Output  string     `json:"output"`
+	Stats   []DiffStat `json:"stats,omitempty"` // Per-file counts, filled in for Stat requests
+}
+
+// DiffStat is the size of the unstaged change to a single file.
+type DiffStat struct {
+	Path       string `json:"path"`
+	Insertions int    `json:"insertions"`
+	Deletions  int    `json:"deletions"`
+	Binary     bool   `json:"binary,omitempty"`
+	Status     string `json:"status,omitempty"` // A, M, D, ...; only set for commit dry runs
 }
 
 // Add new payloads
 type GitStashSaveRequestPayload struct {
 	RepoPath string `json:"repo_path"`
+	Message  string `json:"message,omitempty"` // Optional stash message
 }
 
 type GitStashSaveResponsePayload struct {
@@ -228,6 +618,7 @@ type GitStashSaveResponsePayload struct {
 
 type GitStashPopRequestPayload struct {
 	RepoPath string `json:"repo_path"`
+	Index    int    `json:"index,omitempty"` // stash@{Index}, defaults to the most recent
 }
 
 type GitStashPopResponsePayload struct {
@@ -235,9 +626,55 @@ type GitStashPopResponsePayload struct {
 	Output  string `json:"output"`
 }
 
+type GitStashListRequestPayload struct {
+	RepoPath string `json:"repo_path"`
+}
+
+type GitStashListResponsePayload struct {
+	Success bool         `json:"success"`
+	Stashes []StashEntry `json:"stashes"`
+	Error   string       `json:"error,omitempty"`
+}
+
+// StashEntry is a single entry in a stash list response.
+type StashEntry struct {
+	Index   int       `json:"index"`
+	Ref     string    `json:"ref"` // e.g. "stash@{0}"
+	Branch  string    `json:"branch"`
+	Message string    `json:"message"`
+	Date    time.Time `json:"date"`
+}
+
+type GitStashApplyRequestPayload struct {
+	RepoPath string `json:"repo_path"`
+	Index    int    `json:"index"`
+}
+
+type GitStashApplyResponsePayload struct {
+	Success bool   `json:"success"`
+	Output  string `json:"output"`
+}
+
+type GitStashDropRequestPayload struct {
+	RepoPath string `json:"repo_path"`
+	Index    int    `json:"index"`
+}
+
+type GitStashDropResponsePayload struct {
+	Success bool   `json:"success"`
+	Output  string `json:"output"`
+}
+
 // Add new payloads
+
+// GitResetRequestPayload hard-resets the repo. Like the other requests that
+// throw work away (a forced clean, a reset to a reflog entry, amending with
+// force, discarding a file's changes, a forced delete), the daemon only runs
+// it if Confirm repeats RepoPath, as typed by the user, unless its owner
+// approves such requests locally instead.
 type GitResetRequestPayload struct {
 	RepoPath string `json:"repo_path"`
+	Confirm  string `json:"confirm,omitempty"` // The repo name typed again
 }
 
 type GitResetResponsePayload struct {
@@ -245,18 +682,1113 @@ type GitResetResponsePayload struct {
 	Output  string `json:"output"`
 }
 
-// ReadMessage reads a JSON message from a stream.
-func ReadMessage(stream network.Stream) (*Message, error) {
-	var msg Message
-	err := json.NewDecoder(stream).Decode(&msg)
-	if err != nil {
-		return nil, fmt.Errorf("failed to decode message: %w", err)
-	}
-	return &msg, nil
+// GitCleanRequestPayload removes untracked files. A request without Force is a
+// dry run; the daemon only deletes when Force is set and ConfirmToken matches
+// the token returned by a dry run over the same set of paths.
+type GitCleanRequestPayload struct {
+	RepoPath       string `json:"repo_path"`
+	Directories    bool   `json:"directories,omitempty"`     // Also remove untracked directories (-d)
+	IncludeIgnored bool   `json:"include_ignored,omitempty"` // Also remove ignored files (-x)
+	Force          bool   `json:"force,omitempty"`
+	ConfirmToken   string `json:"confirm_token,omitempty"`
+	Confirm        string `json:"confirm,omitempty"` // With Force; the repo name typed again (see GitResetRequestPayload)
 }
 
-// WriteMessage writes a JSON message to a stream.
-func WriteMessage(stream network.Stream, msg *Message) error {
+type GitCleanResponsePayload struct {
+	Success      bool     `json:"success"`
+	DryRun       bool     `json:"dry_run"`
+	Paths        []string `json:"paths"`                   // Paths that would be (or were) removed
+	ConfirmToken string   `json:"confirm_token,omitempty"` // Returned by a dry run
+	Output       string   `json:"output"`
+}
+
+type ListWorktreesRequestPayload struct {
+	RepoPath string `json:"repo_path"`
+}
+
+type ListWorktreesResponsePayload struct {
+	Success   bool            `json:"success"`
+	Worktrees []WorktreeEntry `json:"worktrees"`
+	Error     string          `json:"error,omitempty"`
+}
+
+// WorktreeEntry describes one checkout of a repo. Linked worktrees can be
+// targeted as "<alias>/<name>" anywhere a repo alias is accepted.
+type WorktreeEntry struct {
+	Name     string `json:"name"` // Empty for the main checkout
+	Path     string `json:"path"`
+	Branch   string `json:"branch"`
+	Head     string `json:"head"`
+	Detached bool   `json:"detached,omitempty"`
+}
+
+type AddWorktreeRequestPayload struct {
+	RepoPath  string `json:"repo_path"`
+	Path      string `json:"path,omitempty"` // Defaults to a sibling directory named <repo>-<branch>
+	Branch    string `json:"branch"`
+	NewBranch bool   `json:"new_branch,omitempty"` // Create Branch from the current HEAD
+}
+
+type AddWorktreeResponsePayload struct {
+	Success bool   `json:"success"`
+	Name    string `json:"name"` // Use as "<alias>/<name>"
+	Output  string `json:"output"`
+}
+
+type ConflictsListRequestPayload struct {
+	RepoPath string `json:"repo_path"`
+}
+
+type ConflictsListResponsePayload struct {
+	Success   bool            `json:"success"`
+	Conflicts []ConflictEntry `json:"conflicts"`
+	Error     string          `json:"error,omitempty"`
+}
+
+// ConflictEntry is an unmerged file and its conflicting regions.
+type ConflictEntry struct {
+	Path  string         `json:"path"`
+	Hunks []ConflictHunk `json:"hunks"`
+}
+
+type ConflictHunk struct {
+	Line   int    `json:"line"`
+	Ours   string `json:"ours"`
+	Base   string `json:"base"`
+	Theirs string `json:"theirs"`
+}
+
+// Resolutions for ResolveConflictRequestPayload
+const (
+	ResolveOurs   = "ours"
+	ResolveTheirs = "theirs"
+	ResolveManual = "manual" // Write Content to the file and mark it resolved
+)
+
+type ResolveConflictRequestPayload struct {
+	RepoPath   string `json:"repo_path"`
+	FilePath   string `json:"file_path"`
+	Resolution string `json:"resolution"`
+	Content    string `json:"content,omitempty"` // Merged file content for ResolveManual
+}
+
+type ResolveConflictResponsePayload struct {
+	Success bool   `json:"success"`
+	Output  string `json:"output"`
+}
+
+type ContinueMergeRequestPayload struct {
+	RepoPath string `json:"repo_path"`
+}
+
+type ContinueMergeResponsePayload struct {
+	Success   bool   `json:"success"`
+	Operation string `json:"operation,omitempty"` // "merge", "rebase", "cherry-pick", "revert" or empty
+	Output    string `json:"output"`
+}
+
+type RunHookRequestPayload struct {
+	RepoPath string `json:"repo_path"`
+	Hook     string `json:"hook"` // "pre-commit" or "pre-push"
+}
+
+// RunHookOutputPayload carries a chunk of the hook's combined stdout/stderr.
+type RunHookOutputPayload struct {
+	Data string `json:"data"`
+}
+
+type RunHookResponsePayload struct {
+	Success  bool   `json:"success"`
+	ExitCode int    `json:"exit_code"`
+	Error    string `json:"error,omitempty"`
+}
+
+type FileLogRequestPayload struct {
+	RepoPath string `json:"repo_path"`
+	FilePath string `json:"file_path"`
+	Limit    int    `json:"limit,omitempty"` // Number of commits to return, defaults to 15
+}
+
+type FileLogResponsePayload struct {
+	Success bool       `json:"success"`
+	Output  string     `json:"output"`
+	Commits []LogEntry `json:"commits,omitempty"` // Newest first; Path is set when the file was renamed
+}
+
+type BisectStartRequestPayload struct {
+	RepoPath string `json:"repo_path"`
+	Good     string `json:"good"`          // A commit known to be good
+	Bad      string `json:"bad,omitempty"` // A commit known to be bad, defaults to HEAD
+}
+
+// BisectMarkRequestPayload is sent with BISECT_GOOD and BISECT_BAD requests.
+type BisectMarkRequestPayload struct {
+	RepoPath string `json:"repo_path"`
+	Commit   string `json:"commit,omitempty"` // Defaults to the commit currently checked out
+}
+
+type BisectResetRequestPayload struct {
+	RepoPath string `json:"repo_path"`
+}
+
+type BisectResponsePayload struct {
+	Success   bool      `json:"success"`
+	Output    string    `json:"output"`
+	Current   *LogEntry `json:"current,omitempty"`   // The candidate now checked out for testing
+	Remaining int       `json:"remaining,omitempty"` // Revisions left to test after Current
+	Culprit   *LogEntry `json:"culprit,omitempty"`   // The first bad commit, once found
+}
+
+// BundleChunkSize is the largest BUNDLE_DATA chunk either side sends.
+const BundleChunkSize = 64 * 1024
+
+type BundleCreateRequestPayload struct {
+	RepoPath string   `json:"repo_path"`
+	Refs     []string `json:"refs,omitempty"` // Refs or ranges like "v1.0..main"; defaults to --all
+}
+
+type BundleCreateResponsePayload struct {
+	Success bool   `json:"success"`
+	Size    int64  `json:"size"` // Total bytes sent in BUNDLE_DATA chunks
+	Error   string `json:"error,omitempty"`
+}
+
+type BundleUploadRequestPayload struct {
+	RepoPath string `json:"repo_path"`
+	Size     int64  `json:"size"` // Bytes that will follow in BUNDLE_DATA chunks
+}
+
+type BundleUploadResponsePayload struct {
+	Success bool   `json:"success"`
+	Output  string `json:"output"` // Refs fetched, as reported by git fetch
+}
+
+// BundleDataPayload carries a chunk of a bundle file.
+type BundleDataPayload struct {
+	Data  []byte `json:"data"`
+	Total int64  `json:"total,omitempty"` // Size of the whole bundle, on the first chunk, when known
+}
+
+type RepoStatsRequestPayload struct {
+	RepoPath string `json:"repo_path,omitempty"` // Empty for every linked repo
+}
+
+type RepoStatsResponsePayload struct {
+	Success bool        `json:"success"`
+	Repos   []RepoStats `json:"repos,omitempty"` // Sorted by alias
+	Error   string      `json:"error,omitempty"`
+}
+
+// RepoStats summarizes one repository's size and activity.
+type RepoStats struct {
+	Alias        string        `json:"alias"`
+	SizeBytes    int64         `json:"size_bytes"` // Object store size
+	Objects      int64         `json:"objects"`
+	Branches     int           `json:"branches"`
+	Tags         int           `json:"tags"`
+	Contributors []Contributor `json:"contributors,omitempty"` // Most commits first
+	LastCommit   time.Time     `json:"last_commit"`            // HEAD's committer date
+	LastActivity time.Time     `json:"last_activity"`          // Newest commit on any branch
+	Error        string        `json:"error,omitempty"`        // Set if this repo's stats failed
+}
+
+type Contributor struct {
+	Name    string `json:"name"`
+	Email   string `json:"email"`
+	Commits int    `json:"commits"`
+}
+
+type GetCommitTemplateRequestPayload struct {
+	RepoPath string `json:"repo_path"`
+}
+
+type GetCommitTemplateResponsePayload struct {
+	Success  bool   `json:"success"`
+	Template string `json:"template"` // Placeholders already filled in; empty when the repo has none
+	Error    string `json:"error,omitempty"`
+}
+
+// CheckoutFileRequestPayload restores one tracked file to its HEAD version,
+// discarding its staged and unstaged changes.
+type CheckoutFileRequestPayload struct {
+	RepoPath string `json:"repo_path"`
+	FilePath string `json:"file_path"`
+	Confirm  string `json:"confirm,omitempty"` // The repo name typed again (see GitResetRequestPayload)
+}
+
+type CheckoutFileResponsePayload struct {
+	Success bool   `json:"success"`
+	Output  string `json:"output"`
+}
+
+type ReflogRequestPayload struct {
+	RepoPath string `json:"repo_path"`
+	Limit    int    `json:"limit,omitempty"` // Defaults to 20
+}
+
+type ReflogResponsePayload struct {
+	Success bool          `json:"success"`
+	Entries []ReflogEntry `json:"entries"`
+	Error   string        `json:"error,omitempty"`
+}
+
+// ReflogEntry is a single entry of HEAD's reflog.
+type ReflogEntry struct {
+	Index   int       `json:"index"` // n in HEAD@{n}
+	Hash    string    `json:"hash"`
+	Action  string    `json:"action"` // e.g. "commit", "reset", "rebase (finish)"
+	Message string    `json:"message"`
+	Date    time.Time `json:"date"`
+}
+
+// ResetToReflogRequestPayload hard-resets the current branch to HEAD@{Index}.
+// Hash must be the commit that entry pointed at when it was listed; the
+// daemon refuses the reset if the reflog has moved since.
+type ResetToReflogRequestPayload struct {
+	RepoPath string `json:"repo_path"`
+	Index    int    `json:"index"`
+	Hash     string `json:"hash"`
+	Confirm  string `json:"confirm,omitempty"` // The repo name typed again
+}
+
+type ResetToReflogResponsePayload struct {
+	Success bool   `json:"success"`
+	Output  string `json:"output"`
+}
+
+type ListHunksRequestPayload struct {
+	RepoPath string `json:"repo_path"`
+	FilePath string `json:"file_path"`
+}
+
+type ListHunksResponsePayload struct {
+	Success bool       `json:"success"`
+	Hunks   []DiffHunk `json:"hunks"` // Unstaged hunks in file order
+	Error   string     `json:"error,omitempty"`
+}
+
+// DiffHunk is one hunk of a file's unstaged diff. Its ID is derived from the
+// hunk's content, so it stays valid while other hunks of the file are staged.
+type DiffHunk struct {
+	ID         string `json:"id"`
+	Header     string `json:"header"` // "@@ -a,b +c,d @@" line
+	Patch      string `json:"patch"`  // Header plus body lines
+	Insertions int    `json:"insertions"`
+	Deletions  int    `json:"deletions"`
+}
+
+type StageHunksRequestPayload struct {
+	RepoPath string   `json:"repo_path"`
+	FilePath string   `json:"file_path"`
+	HunkIDs  []string `json:"hunk_ids"`
+}
+
+type StageHunksResponsePayload struct {
+	Success bool   `json:"success"`
+	Output  string `json:"output"`
+}
+
+type ListRemotesRequestPayload struct {
+	RepoPath string `json:"repo_path"`
+}
+
+type ListRemotesResponsePayload struct {
+	Success bool         `json:"success"`
+	Remotes []RemoteInfo `json:"remotes"`
+	Default string       `json:"default"` // Remote commits are pushed to unless overridden
+	Error   string       `json:"error,omitempty"`
+}
+
+type RemoteInfo struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+// SetDefaultRemoteRequestPayload changes the remote a repo's commits are
+// pushed to. The daemon remembers it across restarts.
+type SetDefaultRemoteRequestPayload struct {
+	RepoPath string `json:"repo_path"`
+	Remote   string `json:"remote"`
+}
+
+type SetDefaultRemoteResponsePayload struct {
+	Success bool   `json:"success"`
+	Output  string `json:"output"`
+}
+
+// GitConfigGetRequestPayload reads a git setting of a repo. Only settings
+// that can't make git run commands are available (user.name, pull.rebase,
+// remote.<name>.url, ...).
+type GitConfigGetRequestPayload struct {
+	RepoPath string `json:"repo_path"`
+	Key      string `json:"key,omitempty"` // Empty lists every available setting that is set
+}
+
+type GitConfigGetResponsePayload struct {
+	Success bool          `json:"success"`
+	Entries []ConfigEntry `json:"entries"` // Empty when the key isn't set
+	Error   string        `json:"error,omitempty"`
+}
+
+type ConfigEntry struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	Scope string `json:"scope"` // Where the effective value comes from: "local", "global", "system"
+}
+
+// GitConfigSetRequestPayload changes a setting in the repo's local config.
+type GitConfigSetRequestPayload struct {
+	RepoPath string `json:"repo_path"`
+	Key      string `json:"key"`
+	Value    string `json:"value"`
+	Unset    bool   `json:"unset,omitempty"` // Remove the key instead of setting it
+}
+
+type GitConfigSetResponsePayload struct {
+	Success bool   `json:"success"`
+	Output  string `json:"output"`
+}
+
+// IgnoreRequestPayload appends Pattern to the repo's top-level .gitignore.
+type IgnoreRequestPayload struct {
+	RepoPath string `json:"repo_path"`
+	Pattern  string `json:"pattern"`
+	Untrack  bool   `json:"untrack,omitempty"` // Also stop tracking (git rm --cached) tracked files that match
+}
+
+type IgnoreResponsePayload struct {
+	Success bool     `json:"success"`
+	Output  string   `json:"output"`
+	Ignored []string `json:"ignored,omitempty"` // Untracked paths the pattern now hides
+	Tracked []string `json:"tracked,omitempty"` // Tracked files that match; still tracked unless Untrack was set
+}
+
+// UnignoreRequestPayload removes Pattern from the repo's top-level .gitignore.
+type UnignoreRequestPayload struct {
+	RepoPath string `json:"repo_path"`
+	Pattern  string `json:"pattern"`
+}
+
+type UnignoreResponsePayload struct {
+	Success bool   `json:"success"`
+	Output  string `json:"output"`
+}
+
+// DeletePathRequestPayload deletes a file or directory with `git rm -r`.
+// Untracked paths and tracked files with uncommitted changes need Force.
+type DeletePathRequestPayload struct {
+	RepoPath string `json:"repo_path"`
+	Path     string `json:"path"`
+	Force    bool   `json:"force,omitempty"`
+	Confirm  string `json:"confirm,omitempty"` // With Force; the repo name typed again (see GitResetRequestPayload)
+}
+
+type DeletePathResponsePayload struct {
+	Success bool   `json:"success"`
+	Output  string `json:"output"`
+}
+
+// CreateFileRequestPayload creates a new file, along with any missing parent
+// directories, and stages it. An existing file is never overwritten.
+type CreateFileRequestPayload struct {
+	RepoPath string `json:"repo_path"`
+	FilePath string `json:"file_path"`
+	Content  string `json:"content,omitempty"`
+}
+
+type CreateFileResponsePayload struct {
+	Success bool   `json:"success"`
+	Output  string `json:"output"`
+}
+
+// MkdirRequestPayload creates a directory and any missing parents, like mkdir -p.
+type MkdirRequestPayload struct {
+	RepoPath string `json:"repo_path"`
+	Path     string `json:"path"`
+}
+
+type MkdirResponsePayload struct {
+	Success bool   `json:"success"`
+	Output  string `json:"output"`
+}
+
+// ListDirRequestPayload lists a single directory, unlike LIST_FILES which
+// walks the whole repository.
+type ListDirRequestPayload struct {
+	RepoPath string `json:"repo_path"`
+	Path     string `json:"path,omitempty"` // Relative to the repo root; empty for the root
+	// Entries matched by .gitignore are left out unless this is set
+	IncludeIgnored bool `json:"include_ignored,omitempty"`
+}
+
+type ListDirResponsePayload struct {
+	Success bool       `json:"success"`
+	Path    string     `json:"path"`    // The directory listed, cleaned
+	Entries []DirEntry `json:"entries"` // Directories first, then files, each sorted by name
+	Error   string     `json:"error,omitempty"`
+}
+
+type DirEntry struct {
+	Name    string    `json:"name"`
+	IsDir   bool      `json:"is_dir"`
+	Size    int64     `json:"size,omitempty"` // Files only
+	ModTime time.Time `json:"mod_time"`
+	Mode    string    `json:"mode"` // e.g. "-rw-r--r--"
+
+	// Status is "modified", "staged", "untracked", "conflicted" or empty when
+	// clean. A directory is "modified" when anything below it has changed.
+	Status string `json:"status,omitempty"`
+}
+
+// GrepRequestPayload searches the repo's files for an extended regular expression.
+type GrepRequestPayload struct {
+	RepoPath   string   `json:"repo_path"`
+	Pattern    string   `json:"pattern"`
+	IgnoreCase bool     `json:"ignore_case,omitempty"`
+	Paths      []string `json:"paths,omitempty"`       // Limit the search to these paths
+	MaxResults int      `json:"max_results,omitempty"` // 0 lets the daemon pick a limit
+}
+
+// GrepMatch is one matching line.
+type GrepMatch struct {
+	Path string `json:"path"`
+	Line int    `json:"line"`
+	Text string `json:"text"`
+}
+
+type GrepResponsePayload struct {
+	Success   bool        `json:"success"`
+	Matches   []GrepMatch `json:"matches"`
+	Truncated bool        `json:"truncated"` // More matches exist than were returned
+	Error     string      `json:"error,omitempty"`
+}
+
+// FileChunkSize is the largest FILE_DATA chunk either side sends.
+const FileChunkSize = 64 * 1024
+
+type DownloadFileRequestPayload struct {
+	RepoPath string `json:"repo_path"`
+	FilePath string `json:"file_path"`
+}
+
+type DownloadFileResponsePayload struct {
+	Success bool   `json:"success"`
+	Size    int64  `json:"size"` // Total bytes sent in FILE_DATA chunks
+	Error   string `json:"error,omitempty"`
+}
+
+type UploadFileRequestPayload struct {
+	RepoPath string `json:"repo_path"`
+	FilePath string `json:"file_path"`
+	Size     int64  `json:"size"` // Bytes that will follow in FILE_DATA chunks
+}
+
+type UploadFileResponsePayload struct {
+	Success bool   `json:"success"`
+	Output  string `json:"output"`
+}
+
+// FileDataPayload carries a chunk of a transferred file. It has the same
+// shape as BundleDataPayload.
+type FileDataPayload struct {
+	Data  []byte `json:"data"`
+	Total int64  `json:"total,omitempty"` // Size of the whole file, on the first chunk, when known
+}
+
+type SubscribeEventsRequestPayload struct {
+	RepoPath   string `json:"repo_path,omitempty"`   // Empty for every linked repo
+	RepoEvents bool   `json:"repo_events,omitempty"` // Also send REPO_EVENT commit and branch events
+}
+
+type SubscribeEventsResponsePayload struct {
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+	Topic   string `json:"topic,omitempty"` // The repo's event topic
+}
+
+// FileChangedEventPayload reports files that changed on the daemon's disk.
+type FileChangedEventPayload struct {
+	RepoPath string   `json:"repo_path"` // Alias of the repo the files are in
+	Paths    []string `json:"paths"`     // Relative to the repo root, sorted
+}
+
+// Kinds of REPO_EVENT.
+const (
+	RepoEventCommit        = "commit"         // A branch moved: a commit, pull or reset
+	RepoEventBranchCreated = "branch-created" // A branch appeared
+	RepoEventBranchDeleted = "branch-deleted" // A branch went away
+	RepoEventCheckout      = "checkout"       // Another branch was checked out
+	RepoEventRenamed       = "renamed"        // The repo's alias changed, to RepoPath
+)
+
+// RepoEventPayload reports a change to a repo's branches, whoever made it:
+// a client or someone working on the daemon's machine.
+type RepoEventPayload struct {
+	Topic    string `json:"topic"`     // Per repo, see EventTopic
+	RepoPath string `json:"repo_path"` // Alias of the repo
+	Kind     string `json:"kind"`
+	Branch   string `json:"branch"`
+	Hash     string `json:"hash,omitempty"`    // The branch's new tip, for commits and created branches
+	Subject  string `json:"subject,omitempty"` // Of the new tip, for commits
+	Author   string `json:"author,omitempty"`
+	OldAlias string `json:"old_alias,omitempty"` // For renames
+}
+
+// EventTopic is the topic a repo's events are published under, so clients
+// watching several repos can tell them apart. It only names the repo's events;
+// they are sent on each subscriber's stream, not over pubsub.
+func EventTopic(repoAlias string) string {
+	return ProtocolID + "/events/" + repoAlias
+}
+
+type ChmodRequestPayload struct {
+	RepoPath string `json:"repo_path"`
+	FilePath string `json:"file_path"`
+	Mode     string `json:"mode"` // "+x", "-x" or an octal mode like "644"
+}
+
+type ChmodResponsePayload struct {
+	Success bool   `json:"success"`
+	Output  string `json:"output"`
+}
+
+// ApplyPatchRequestPayload carries a unified diff of a single file, made
+// against the content the client read, for the daemon to `git apply`.
+type ApplyPatchRequestPayload struct {
+	RepoPath string `json:"repo_path"`
+	FilePath string `json:"file_path"`
+	Patch    string `json:"patch"`
+}
+
+type ApplyPatchResponsePayload struct {
+	Success  bool         `json:"success"`
+	Conflict bool         `json:"conflict,omitempty"` // The file changed in a way the patch can't be applied to
+	Output   string       `json:"output"`
+	Written  *WrittenFile `json:"written,omitempty"`
+}
+
+// Kinds of BatchOperation.
+const (
+	BatchWrite  = "write"
+	BatchRename = "rename"
+	BatchDelete = "delete"
+)
+
+// BatchOperation is one file change of a BATCH_REQUEST.
+type BatchOperation struct {
+	Op      string `json:"op"` // BatchWrite, BatchRename or BatchDelete
+	Path    string `json:"path"`
+	NewPath string `json:"new_path,omitempty"` // Where a rename moves Path to
+	Content []byte `json:"content,omitempty"`  // What a write puts in Path
+}
+
+// BatchRequestPayload applies several writes, renames and deletes as one:
+// if any of them fails, the daemon rolls back the others, so the working
+// tree is never left half changed.
+type BatchRequestPayload struct {
+	RepoPath   string           `json:"repo_path"`
+	Operations []BatchOperation `json:"operations"`
+}
+
+type BatchResponsePayload struct {
+	Success bool   `json:"success"`
+	Output  string `json:"output"`
+}
+
+// TailRequestPayload follows a file like `tail -f`, starting with its last
+// Lines lines.
+type TailRequestPayload struct {
+	RepoPath string `json:"repo_path"`
+	FilePath string `json:"file_path"`
+	Lines    int    `json:"lines"`
+}
+
+type TailResponsePayload struct {
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+}
+
+// TailDataPayload carries text appended to a followed file.
+type TailDataPayload struct {
+	Data string `json:"data"`
+	// Set when the file got shorter (truncated or replaced), so Data starts
+	// over from its beginning
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+type AccessDeniedPayload struct {
+	Request string `json:"request"` // Type of the denied request
+	Role    string `json:"role"`    // The peer's role
+	Error   string `json:"error"`
+}
+
+// AccessDeniedError is returned by ReadMessage when the daemon refused a
+// request because of the peer's role.
+type AccessDeniedError struct {
+	AccessDeniedPayload
+}
+
+func (e *AccessDeniedError) Error() string {
+	return "access denied: " + e.AccessDeniedPayload.Error
+}
+
+type PeerInfo struct {
+	ID        string    `json:"id"`
+	Role      string    `json:"role"`
+	ExpiresAt time.Time `json:"expires_at"`         // Zero if the approval never expires
+	Expired   bool      `json:"expired,omitempty"`  // It has to pair again
+	Verified  bool      `json:"verified,omitempty"` // Fingerprints were compared when pairing
+	Connected bool      `json:"connected"`
+	Self      bool      `json:"self,omitempty"` // The peer that asked
+}
+
+type ListPeersResponsePayload struct {
+	Success bool       `json:"success"`
+	Peers   []PeerInfo `json:"peers"`
+	Error   string     `json:"error,omitempty"`
+}
+
+type RevokePeerRequestPayload struct {
+	PeerID string `json:"peer_id"` // The full ID or a unique prefix of it
+}
+
+type RevokePeerResponsePayload struct {
+	Success bool   `json:"success"`
+	Output  string `json:"output"`
+}
+
+type AuditEntry struct {
+	Time   time.Time `json:"time"`
+	Peer   string    `json:"peer"`
+	Type   string    `json:"type"`
+	Repo   string    `json:"repo,omitempty"`
+	Args   string    `json:"args,omitempty"`
+	Result string    `json:"result"`
+	Error  string    `json:"error,omitempty"`
+}
+
+// AuditLogRequestPayload filters the audit log. Empty fields match every entry.
+type AuditLogRequestPayload struct {
+	Peer  string    `json:"peer,omitempty"` // ID or prefix of one
+	Type  string    `json:"type,omitempty"`
+	Repo  string    `json:"repo,omitempty"`
+	Since time.Time `json:"since"`
+	Limit int       `json:"limit,omitempty"` // Only the latest entries
+}
+
+type AuditLogResponsePayload struct {
+	Success bool         `json:"success"`
+	Entries []AuditEntry `json:"entries"` // Oldest first
+	Error   string       `json:"error,omitempty"`
+}
+
+type RateLimitedPayload struct {
+	Request      string `json:"request"`        // Type of the refused request
+	Class        string `json:"class"`          // read, write or git
+	RetryAfterMs int64  `json:"retry_after_ms"` // When the next one will be accepted
+	Error        string `json:"error"`
+}
+
+// RateLimitedError is returned by ReadMessage when the daemon refused a
+// request because the peer sent too many.
+type RateLimitedError struct {
+	RateLimitedPayload
+}
+
+func (e *RateLimitedError) Error() string {
+	return "rate limited: " + e.RateLimitedPayload.Error
+}
+
+// RetryAfter is how long to wait before sending the request again.
+func (e *RateLimitedError) RetryAfter() time.Duration {
+	return time.Duration(e.RetryAfterMs) * time.Millisecond
+}
+
+type HandshakeChallengePayload struct {
+	Nonce []byte `json:"nonce"`
+}
+
+type HandshakeProofPayload struct {
+	PublicKey []byte `json:"public_key"` // Marshalled libp2p public key
+	Signature []byte `json:"signature"`  // Of the nonce, bound to both peer IDs
+}
+
+type SessionResponsePayload struct {
+	Success   bool      `json:"success"`
+	Token     string    `json:"token,omitempty"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Error     string    `json:"error,omitempty"`
+}
+
+type SessionExpiredPayload struct {
+	Request string `json:"request"` // Type of the refused request
+	Error   string `json:"error"`
+}
+
+// SessionExpiredError is returned by ReadMessage when the daemon refused a
+// request because its session token was missing, invalid or expired. It
+// also forgets the token, so the next request renews the session.
+type SessionExpiredError struct {
+	SessionExpiredPayload
+}
+
+func (e *SessionExpiredError) Error() string {
+	return "session expired: " + e.SessionExpiredPayload.Error
+}
+
+// sessionTokens are the session tokens of the daemons a client talks to,
+// by peer ID, see SetSessionToken.
+var sessionTokens sync.Map
+
+// SetSessionToken sets the session token WriteMessage sends with every
+// message to a daemon that doesn't carry one; "" forgets it. Each daemon has
+// its own, so a client switching daemons never sends one the token of
+// another. A client sets them; the daemon never does.
+func SetSessionToken(daemon peer.ID, token string) {
+	if token == "" {
+		sessionTokens.Delete(daemon)
+	} else {
+		sessionTokens.Store(daemon, token)
+	}
+}
+
+// SessionToken returns the token set by SetSessionToken for a daemon.
+func SessionToken(daemon peer.ID) string {
+	token, _ := sessionTokens.Load(daemon)
+	s, _ := token.(string)
+	return s
+}
+
+// OnApprovalPending, if set, is called when ReadMessage skips an
+// APPROVAL_PENDING notice, so a client can tell its user what it waits for.
+var OnApprovalPending func(ApprovalPendingPayload)
+
+type ReadOnlyRequestPayload struct {
+	Enabled *bool `json:"enabled,omitempty"` // nil only asks
+}
+
+type ReadOnlyResponsePayload struct {
+	Success  bool   `json:"success"`
+	ReadOnly bool   `json:"read_only"`
+	Error    string `json:"error,omitempty"`
+}
+
+type PairingWindowRequestPayload struct {
+	Minutes int `json:"minutes"` // 0 closes the window
+}
+
+type PairingWindowResponsePayload struct {
+	Success bool      `json:"success"`
+	Gated   bool      `json:"gated"` // Whether the daemon refuses untrusted peers outside the window
+	Until   time.Time `json:"until"`
+	Error   string    `json:"error,omitempty"`
+}
+
+type BlockRequestPayload struct {
+	Entry   string `json:"entry,omitempty"` // A peer ID (or a unique prefix of a trusted one), an IP address or a CIDR range
+	Unblock bool   `json:"unblock,omitempty"`
+}
+
+type BlockResponsePayload struct {
+	Success bool     `json:"success"`
+	Output  string   `json:"output"`
+	Peers   []string `json:"peers,omitempty"`
+	Ranges  []string `json:"ranges,omitempty"`
+}
+
+// ApprovalsRequestPayload subscribes an admin client to the destructive
+// requests waiting for its approval. After the response the stream stays
+// open: the daemon sends APPROVAL_REQUIRED events, and the client answers
+// each with an APPROVAL_DECISION.
+type ApprovalsRequestPayload struct{}
+
+type ApprovalsResponsePayload struct {
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+}
+
+// ApprovalRequiredPayload describes a destructive request another peer sent,
+// parked until an admin decides on it or it expires.
+type ApprovalRequiredPayload struct {
+	ID       string    `json:"id"`
+	Peer     string    `json:"peer"`
+	Request  string    `json:"request"` // Its message type
+	Action   string    `json:"action"`  // Like "a hard reset"
+	RepoPath string    `json:"repo_path"`
+	Expires  time.Time `json:"expires"`
+}
+
+type ApprovalDecisionPayload struct {
+	ID      string `json:"id"`
+	Approve bool   `json:"approve"`
+}
+
+type ApprovalDecisionResponsePayload struct {
+	ID      string `json:"id"`
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+}
+
+// ApprovalPendingPayload tells the peer that sent a destructive request that
+// it waits for another device's approval. It comes before the request's
+// response, and ReadMessage passes it to OnApprovalPending.
+type ApprovalPendingPayload struct {
+	ID      string    `json:"id"`
+	Action  string    `json:"action"`
+	Expires time.Time `json:"expires"`
+}
+
+type NetStatusRequestPayload struct{}
+
+// NetStatusResponsePayload reports how reachable the daemon is, as found by
+// AutoNAT: public, behind NAT, relay-only or unknown.
+type NetStatusResponsePayload struct {
+	Success          bool     `json:"success"`
+	Status           string   `json:"status"`
+	Reachability     string   `json:"reachability"` // AutoNAT's verdict
+	ListenAddrs      []string `json:"listen_addrs,omitempty"`
+	ObservedAddrs    []string `json:"observed_addrs,omitempty"` // As other peers see the daemon
+	RelayAddrs       []string `json:"relay_addrs,omitempty"`
+	ReachableAddrs   []string `json:"reachable_addrs,omitempty"` // Confirmed by other peers dialing back
+	UnreachableAddrs []string `json:"unreachable_addrs,omitempty"`
+	Error            string   `json:"error,omitempty"`
+}
+
+// PingRequestPayload asks the daemon to answer right away. Later pings may
+// follow on the same stream.
+type PingRequestPayload struct {
+	Seq int `json:"seq"`
+}
+
+type PingResponsePayload struct {
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+	Seq     int    `json:"seq"` // Of the ping answered
+}
+
+// RenameAliasRequestPayload renames a linked repo, keeping its path and
+// settings.
+type RenameAliasRequestPayload struct {
+	Alias    string `json:"alias"`
+	NewAlias string `json:"new_alias"`
+}
+
+type RenameAliasResponsePayload struct {
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+}
+
+type DaemonStatusRequestPayload struct{}
+
+// DaemonStatusResponsePayload is how the daemon is doing: what it runs, for
+// how long, and how busy it is.
+type DaemonStatusResponsePayload struct {
+	Success          bool      `json:"success"`
+	Version          string    `json:"version"`
+	ProtocolVersion  string    `json:"protocol_version"`
+	Started          time.Time `json:"started"`
+	UptimeMs         int64     `json:"uptime_ms"`
+	LinkedRepos      int       `json:"linked_repos"`
+	ConnectedPeers   int       `json:"connected_peers"` // DHT peers included
+	ConnectedTrusted int       `json:"connected_trusted"`
+	TrustedPeers     int       `json:"trusted_peers"`
+	InFlight         int       `json:"in_flight"` // Requests being served, this one included; tails and subscriptions aren't
+	Jobs             int       `json:"jobs"`      // Queued or running, like pushes in the background
+	ReadOnly         bool      `json:"read_only"`
+	Error            string    `json:"error,omitempty"`
+}
+
+// States of a job.
+const (
+	JobQueued      = "queued" // Waiting for another job on the same repo
+	JobRunning     = "running"
+	JobDone        = "done"
+	JobFailed      = "failed"
+	JobCancelled   = "cancelled"
+	JobInterrupted = "interrupted" // The daemon stopped while it ran
+)
+
+// JobInfo describes a job: a long operation the daemon runs for a client.
+type JobInfo struct {
+	ID       string    `json:"id"`
+	Kind     string    `json:"kind"` // "push" or "amend"
+	RepoPath string    `json:"repo_path"`
+	Branch   string    `json:"branch,omitempty"`
+	Peer     string    `json:"peer"` // Who started it
+	State    string    `json:"state"`
+	Created  time.Time `json:"created"`
+	Finished time.Time `json:"finished"`         // Zero until it ends
+	Output   string    `json:"output,omitempty"` // Only in JOB_STATUS_RESPONSE
+	Error    string    `json:"error,omitempty"`
+}
+
+type JobsListRequestPayload struct {
+	All bool `json:"all,omitempty"` // Include finished jobs, not only queued and running ones
+}
+
+type JobsListResponsePayload struct {
+	Success bool      `json:"success"`
+	Jobs    []JobInfo `json:"jobs"` // Oldest first
+	Error   string    `json:"error,omitempty"`
+}
+
+type JobStatusRequestPayload struct {
+	ID     string `json:"id"`
+	Follow bool   `json:"follow,omitempty"` // Stream the job's output until it ends
+}
+
+type JobStatusResponsePayload struct {
+	Success bool    `json:"success"`
+	Job     JobInfo `json:"job"`
+	Error   string  `json:"error,omitempty"`
+}
+
+// JobProgressPayload is output a followed job printed.
+type JobProgressPayload struct {
+	ID     string `json:"id"`
+	Output string `json:"output"`
+}
+
+type JobCancelRequestPayload struct {
+	ID string `json:"id"`
+}
+
+type JobCancelResponsePayload struct {
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+}
+
+// MaxMessageSize bounds a message ReadMessage accepts, so a peer can't make
+// it buffer without end by never sending a newline. It leaves room for the
+// text files and diffs sent whole; downloads and uploads come in chunks.
+const MaxMessageSize = 64 << 20
+
+// ErrMessageTooLarge is returned by ReadMessage for a message over
+// MaxMessageSize. The stream can't be read further.
+var ErrMessageTooLarge = fmt.Errorf("message is larger than %d bytes", MaxMessageSize)
+
+// bufferedStream reads a stream through a buffer, so ReadMessage needn't
+// make a read call per byte to stop at the end of a message. What it reads
+// ahead stays in the buffer for the next one.
+type bufferedStream struct {
+	network.Stream
+	reader *bufio.Reader
+}
+
+func (s *bufferedStream) Read(p []byte) (int, error) {
+	return s.reader.Read(p)
+}
+
+// Buffered wraps a stream for reading messages, once, where it's opened or
+// accepted; reading the unwrapped stream after would skip what the buffer
+// holds. ReadMessage still works on unwrapped streams, a byte at a time.
+func Buffered(stream network.Stream) network.Stream {
+	if _, ok := stream.(*bufferedStream); ok {
+		return stream
+	}
+	return &bufferedStream{Stream: stream, reader: bufio.NewReader(stream)}
+}
+
+// ReadMessage reads a JSON message from a stream.
+func ReadMessage(stream network.Stream) (*Message, error) {
+	// Messages are newline-terminated (see WriteMessage). Read exactly one line:
+	// a json.Decoder would buffer past it and swallow the start of the next
+	// message when several are sent on the same stream.
+	var line []byte
+	var err error
+	if buffered, ok := stream.(*bufferedStream); ok {
+		line, err = readLine(buffered.reader)
+	} else {
+		line, err = readLineUnbuffered(stream)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode message: %w", err)
+	}
+	var msg Message
+	if err := json.Unmarshal(line, &msg); err != nil {
+		return nil, fmt.Errorf("failed to decode message: %w", err)
+	}
+	if msg.Type == TypeApprovalPending {
+		// The response follows once another device decides
+		if OnApprovalPending != nil {
+			var pending ApprovalPendingPayload
+			json.Unmarshal(msg.Payload, &pending)
+			OnApprovalPending(pending)
+		}
+		return ReadMessage(stream)
+	}
+	if msg.Type == TypeAccessDenied {
+		denied := &AccessDeniedError{}
+		json.Unmarshal(msg.Payload, &denied.AccessDeniedPayload)
+		return nil, denied
+	}
+	if msg.Type == TypeRateLimited {
+		limited := &RateLimitedError{}
+		json.Unmarshal(msg.Payload, &limited.RateLimitedPayload)
+		return nil, limited
+	}
+	if msg.Type == TypeSessionExpired {
+		expired := &SessionExpiredError{}
+		json.Unmarshal(msg.Payload, &expired.SessionExpiredPayload)
+		SetSessionToken(stream.Conn().RemotePeer(), "")
+		return nil, expired
+	}
+	return &msg, nil
+}
+
+// readLine reads up to and excluding the next newline.
+func readLine(r *bufio.Reader) ([]byte, error) {
+	var line []byte
+	for {
+		chunk, err := r.ReadSlice('\n')
+		if len(line)+len(chunk) > MaxMessageSize+1 {
+			return nil, ErrMessageTooLarge
+		}
+		line = append(line, chunk...)
+		switch {
+		case err == nil:
+			return line[:len(line)-1], nil
+		case err == bufio.ErrBufferFull:
+			continue
+		case err == io.EOF && len(line) > 0:
+			return line, nil
+		default:
+			return nil, err
+		}
+	}
+}
+
+// readLineUnbuffered reads up to and excluding the next newline without
+// reading ahead.
+func readLineUnbuffered(r io.Reader) ([]byte, error) {
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		n, err := r.Read(b)
+		if n == 1 {
+			if b[0] == '\n' {
+				return line, nil
+			}
+			if len(line) == MaxMessageSize {
+				return nil, ErrMessageTooLarge
+			}
+			line = append(line, b[0])
+		}
+		if err != nil {
+			if err == io.EOF && len(line) > 0 {
+				return line, nil
+			}
+			return nil, err
+		}
+	}
+}
+
+// WriteMessage writes a JSON message to a stream.
+func WriteMessage(stream network.Stream, msg *Message) error {
+	if msg.Session == "" {
+		msg.Session = SessionToken(stream.Conn().RemotePeer())
+	}
+
 	// Create a buffered writer. This gives us control over flushing.
 	writer := bufio.NewWriter(stream)
 
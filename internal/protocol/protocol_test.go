@@ -0,0 +1,101 @@
+package protocol
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// fakeStream is a stream reading from r. Only Read is used by ReadMessage.
+type fakeStream struct {
+	network.Stream
+	r io.Reader
+}
+
+func (s *fakeStream) Read(p []byte) (int, error) {
+	return s.r.Read(p)
+}
+
+// endless reads as an unending line of x, a peer that never sends a newline.
+type endless struct{}
+
+func (endless) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 'x'
+	}
+	return len(p), nil
+}
+
+func TestReadMessageKeepsNextMessage(t *testing.T) {
+	wire := `{"type":"FIRST","payload":{"a":1}}` + "\n" +
+		`{"type":"SECOND","payload":{"b":2}}` + "\n" +
+		`{"type":"THIRD","payload":null}`
+
+	for name, stream := range map[string]network.Stream{
+		"buffered":   Buffered(&fakeStream{r: strings.NewReader(wire)}),
+		"unbuffered": &fakeStream{r: strings.NewReader(wire)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			for _, want := range []string{"FIRST", "SECOND", "THIRD"} {
+				msg, err := ReadMessage(stream)
+				if err != nil {
+					t.Fatalf("reading %s: %v", want, err)
+				}
+				if msg.Type != want {
+					t.Fatalf("got %s, want %s", msg.Type, want)
+				}
+			}
+			if _, err := ReadMessage(stream); !errors.Is(err, io.EOF) {
+				t.Fatalf("after the last message: got %v, want EOF", err)
+			}
+		})
+	}
+}
+
+func TestBufferedReadsPassThroughBuffer(t *testing.T) {
+	stream := Buffered(&fakeStream{r: strings.NewReader(`{"type":"FIRST"}` + "\nrest")})
+	if _, err := ReadMessage(stream); err != nil {
+		t.Fatal(err)
+	}
+	// What ReadMessage read ahead must still come out of the stream
+	rest, err := io.ReadAll(stream)
+	if err != nil || string(rest) != "rest" {
+		t.Fatalf("got %q, %v; want \"rest\"", rest, err)
+	}
+	if Buffered(stream) != stream {
+		t.Fatal("wrapping a buffered stream again made a new buffer")
+	}
+}
+
+func TestReadMessageTooLarge(t *testing.T) {
+	for name, stream := range map[string]network.Stream{
+		"buffered":   Buffered(&fakeStream{r: endless{}}),
+		"unbuffered": &fakeStream{r: io.LimitReader(endless{}, MaxMessageSize+1)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := ReadMessage(stream); !errors.Is(err, ErrMessageTooLarge) {
+				t.Fatalf("got %v, want ErrMessageTooLarge", err)
+			}
+		})
+	}
+}
+
+func TestSessionTokenPerDaemon(t *testing.T) {
+	one, two := peer.ID("daemon-one"), peer.ID("daemon-two")
+	SetSessionToken(one, "token-one")
+	t.Cleanup(func() { SetSessionToken(one, "") })
+	if got := SessionToken(two); got != "" {
+		t.Fatalf("another daemon got %q", got)
+	}
+	if got := SessionToken(one); got != "token-one" {
+		t.Fatalf("got %q, want token-one", got)
+	}
+	SetSessionToken(one, "")
+	if got := SessionToken(one); got != "" {
+		t.Fatalf("a forgotten token is still %q", got)
+	}
+}
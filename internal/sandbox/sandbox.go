@@ -0,0 +1,156 @@
+// Package sandbox confines file access by peers to a repository. Unlike a
+// prefix check on the joined path, it follows symlinks before deciding, so
+// a link inside the repo can't lead outside of it, and it keeps peers away
+// from excluded paths like git's internals or .env files.
+package sandbox
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ErrOutside is returned for paths that lead outside the repository, by
+// themselves or through a symlink.
+var ErrOutside = errors.New("Access denied: path is outside of repository root")
+
+// AlwaysExcluded are never served to peers, whatever a repo's settings.
+var AlwaysExcluded = []string{".git"}
+
+// maxLinks bounds how many symlinks are followed, in case they loop.
+const maxLinks = 40
+
+// ExcludedError is returned for paths excluded from the sandbox.
+type ExcludedError struct {
+	Path    string
+	Pattern string
+}
+
+func (e *ExcludedError) Error() string {
+	return fmt.Sprintf("Access denied: %s is excluded (%s)", e.Path, e.Pattern)
+}
+
+// Sandbox is a repository root and the paths in it peers can't touch.
+type Sandbox struct {
+	root     string
+	realRoot string // root with its symlinks resolved
+	exclude  []string
+}
+
+// New returns a sandbox for the repo at root. Exclude patterns without a
+// slash match any file or directory of that name, like .env or *.pem;
+// patterns with one match a path from the root, like config/secrets. An
+// excluded directory excludes everything in it.
+func New(root string, exclude []string) *Sandbox {
+	root = filepath.Clean(root)
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		realRoot = root
+	}
+	return &Sandbox{
+		root:     root,
+		realRoot: realRoot,
+		exclude:  append(append([]string{}, AlwaysExcluded...), exclude...),
+	}
+}
+
+// Path returns the absolute path of rel, a path relative to the root sent
+// by a peer, if the sandbox allows it. The path may not exist yet.
+func (s *Sandbox) Path(rel string) (string, error) {
+	full := filepath.Join(s.root, rel)
+	inside, err := filepath.Rel(s.root, full)
+	if err != nil || !within(inside) {
+		return "", ErrOutside
+	}
+	if err := s.check(inside); err != nil {
+		return "", err
+	}
+
+	// Check again where it really leads
+	real, err := resolve(full)
+	if err != nil {
+		return "", err
+	}
+	inside, err = filepath.Rel(s.realRoot, real)
+	if err != nil || !within(inside) {
+		return "", ErrOutside
+	}
+	if err := s.check(inside); err != nil {
+		return "", err
+	}
+	return full, nil
+}
+
+// Excluded reports whether a path relative to the root is excluded, for
+// leaving it out of listings.
+func (s *Sandbox) Excluded(rel string) bool {
+	return s.check(rel) != nil
+}
+
+// check matches rel against the exclude patterns ignoring case: on macOS
+// and Windows .GIT/hooks is .git/hooks, and a pattern must not be dodged by
+// writing a name another way.
+func (s *Sandbox) check(rel string) error {
+	rel = filepath.ToSlash(filepath.Clean(rel))
+	if rel == "." {
+		return nil
+	}
+	parts := strings.Split(strings.ToLower(rel), "/")
+	for _, exclude := range s.exclude {
+		exclude = strings.Trim(exclude, "/")
+		pattern := strings.ToLower(exclude)
+		for i, part := range parts {
+			name := part
+			if strings.Contains(pattern, "/") {
+				name = strings.Join(parts[:i+1], "/")
+			}
+			if ok, _ := path.Match(pattern, name); ok {
+				return &ExcludedError{Path: rel, Pattern: exclude}
+			}
+		}
+	}
+	return nil
+}
+
+// within reports whether a relative path stays below its base.
+func within(rel string) bool {
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) && !filepath.IsAbs(rel)
+}
+
+// resolve follows the symlinks in p. Missing parts at its end are kept as
+// they are, so a file about to be created resolves to where it would be;
+// a dangling symlink resolves to its target.
+func resolve(p string) (string, error) {
+	rest := ""
+	for links := 0; links <= maxLinks; {
+		real, err := filepath.EvalSymlinks(p)
+		if err == nil {
+			return filepath.Join(real, rest), nil
+		}
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+		if info, err := os.Lstat(p); err == nil && info.Mode()&os.ModeSymlink != 0 {
+			target, err := os.Readlink(p)
+			if err != nil {
+				return "", err
+			}
+			if !filepath.IsAbs(target) {
+				target = filepath.Join(filepath.Dir(p), target)
+			}
+			p = target
+			links++
+			continue
+		}
+		parent := filepath.Dir(p)
+		if parent == p {
+			return filepath.Join(p, rest), nil
+		}
+		rest = filepath.Join(filepath.Base(p), rest)
+		p = parent
+	}
+	return "", fmt.Errorf("too many levels of symbolic links")
+}
@@ -0,0 +1,161 @@
+package sandbox
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newTestRepo lays out a repo with a .git directory, a few files and
+// symlinks pointing inside it, outside it, and nowhere.
+func newTestRepo(t *testing.T) (root, outside string) {
+	t.Helper()
+	base := t.TempDir()
+	root = filepath.Join(base, "repo")
+	outside = filepath.Join(base, "outside")
+	for _, dir := range []string{
+		filepath.Join(root, ".git", "hooks"),
+		filepath.Join(root, "src"),
+		filepath.Join(root, "config", "secrets"),
+		outside,
+	} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, file := range []string{
+		filepath.Join(root, "README.md"),
+		filepath.Join(root, "src", "main.go"),
+		filepath.Join(root, ".env"),
+		filepath.Join(root, ".gitignore"),
+		filepath.Join(root, "config", "secrets", "key"),
+		filepath.Join(outside, "passwd"),
+	} {
+		if err := os.WriteFile(file, []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	links := map[string]string{
+		"link-in":           "src/main.go",
+		"link-dir-in":       "src",
+		"link-out":          filepath.Join(outside, "passwd"),
+		"link-dir-out":      outside,
+		"link-relative-out": "../outside/passwd",
+		"link-git":          ".git",
+		"dangling-in":       "src/new.go",
+		"dangling-out":      filepath.Join(outside, "new"),
+		"loop":              "loop",
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(root, name)); err != nil {
+			t.Skipf("cannot create symlinks: %v", err)
+		}
+	}
+	return root, outside
+}
+
+func TestPath(t *testing.T) {
+	root, _ := newTestRepo(t)
+	s := New(root, []string{".env", "*.pem", "config/secrets"})
+
+	tests := []struct {
+		name     string
+		rel      string
+		want     string // relative to root, when allowed
+		outside  bool
+		excluded bool
+		fails    bool // any other error
+	}{
+		{name: "file", rel: "README.md", want: "README.md"},
+		{name: "nested file", rel: "src/main.go", want: "src/main.go"},
+		{name: "new file", rel: "src/new.go", want: "src/new.go"},
+		{name: "root", rel: ".", want: "."},
+		{name: "dot dot inside", rel: "src/../README.md", want: "README.md"},
+		{name: "gitignore is not .git", rel: ".gitignore", want: ".gitignore"},
+
+		{name: "parent", rel: "..", outside: true},
+		{name: "sibling", rel: "../outside/passwd", outside: true},
+		{name: "dot dot through a dir", rel: "src/../../outside/passwd", outside: true},
+
+		{name: "link inside", rel: "link-in", want: "link-in"},
+		{name: "through a link to a dir inside", rel: "link-dir-in/main.go", want: "link-dir-in/main.go"},
+		{name: "link outside", rel: "link-out", outside: true},
+		{name: "relative link outside", rel: "link-relative-out", outside: true},
+		{name: "through a link to a dir outside", rel: "link-dir-out/passwd", outside: true},
+		{name: "dangling link inside", rel: "dangling-in", want: "dangling-in"},
+		{name: "dangling link outside", rel: "dangling-out", outside: true},
+		{name: "link loop", rel: "loop", fails: true},
+
+		{name: ".git", rel: ".git", excluded: true},
+		{name: "in .git", rel: ".git/hooks/pre-commit", excluded: true},
+		{name: "link to .git", rel: "link-git/hooks/pre-commit", excluded: true},
+		{name: "upper case .GIT", rel: ".GIT/hooks/pre-commit", excluded: true},
+		{name: "mixed case .Git", rel: ".Git/config", excluded: true},
+		{name: "nested .git", rel: "src/.git/config", excluded: true},
+		{name: "name pattern", rel: ".env", excluded: true},
+		{name: "name pattern in a dir", rel: "src/.env", excluded: true},
+		{name: "name pattern, other case", rel: ".ENV", excluded: true},
+		{name: "glob pattern", rel: "certs/server.pem", excluded: true},
+		{name: "glob pattern, other case", rel: "certs/server.PEM", excluded: true},
+		{name: "path pattern", rel: "config/secrets", excluded: true},
+		{name: "in an excluded dir", rel: "config/secrets/key", excluded: true},
+		{name: "excluded dir, other case", rel: "Config/SECRETS/key", excluded: true},
+		{name: "path pattern elsewhere", rel: "src/config/secrets", want: "src/config/secrets"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.Path(tt.rel)
+			var excludedErr *ExcludedError
+			switch {
+			case tt.outside:
+				if !errors.Is(err, ErrOutside) {
+					t.Fatalf("Path(%q) = %q, %v; want ErrOutside", tt.rel, got, err)
+				}
+			case tt.excluded:
+				if !errors.As(err, &excludedErr) {
+					t.Fatalf("Path(%q) = %q, %v; want an ExcludedError", tt.rel, got, err)
+				}
+			case tt.fails:
+				if err == nil {
+					t.Fatalf("Path(%q) = %q; want an error", tt.rel, got)
+				}
+			default:
+				if err != nil {
+					t.Fatalf("Path(%q): %v", tt.rel, err)
+				}
+				if want := filepath.Join(root, tt.want); got != want {
+					t.Fatalf("Path(%q) = %q; want %q", tt.rel, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestExcluded(t *testing.T) {
+	s := New(t.TempDir(), []string{"node_modules", "Secrets/*.key"})
+
+	tests := []struct {
+		rel  string
+		want bool
+	}{
+		{".", false},
+		{"main.go", false},
+		{".git", true},
+		{".GIT", true},
+		{"sub/.git/HEAD", true},
+		{".github/workflows/ci.yml", false},
+		{"node_modules", true},
+		{"web/Node_Modules/left-pad/index.js", true},
+		{"secrets/prod.key", true},
+		{"SECRETS/PROD.KEY", true},
+		{"secrets/README", false},
+		{"other/secrets/prod.key", false},
+	}
+	for _, tt := range tests {
+		if got := s.Excluded(filepath.FromSlash(tt.rel)); got != tt.want {
+			t.Errorf("Excluded(%q) = %v; want %v", tt.rel, got, tt.want)
+		}
+	}
+}
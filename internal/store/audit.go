@@ -0,0 +1,101 @@
+package store
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AuditEntry records one request a peer sent the daemon.
+type AuditEntry struct {
+	Time   time.Time `json:"time"`
+	Peer   string    `json:"peer"`
+	Type   string    `json:"type"`
+	Repo   string    `json:"repo,omitempty"`
+	Args   string    `json:"args,omitempty"`  // Short summary of the request
+	Result string    `json:"result"`          // ok, failed, denied, approved, rejected...
+	Error  string    `json:"error,omitempty"` // Why it failed
+}
+
+// AuditQuery selects audit entries. Zero fields match everything.
+type AuditQuery struct {
+	Peer  string // ID or prefix of one
+	Type  string
+	Repo  string
+	Since time.Time
+	Limit int // Only the latest entries
+}
+
+// AuditLog is an append-only JSONL file of AuditEntry.
+type AuditLog struct {
+	path  string
+	mutex sync.Mutex
+}
+
+// NewAuditLog opens the audit log at path, creating it on the first Record.
+func NewAuditLog(path string) *AuditLog {
+	return &AuditLog{path: path}
+}
+
+// Record appends an entry to the log.
+func (l *AuditLog) Record(entry AuditEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Query returns the entries matching q, oldest first. Lines that aren't
+// valid entries are skipped.
+func (l *AuditLog) Query(q AuditQuery) ([]AuditEntry, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	f, err := os.Open(l.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []AuditEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var entry AuditEntry
+		if json.Unmarshal(scanner.Bytes(), &entry) != nil {
+			continue
+		}
+		if q.matches(entry) {
+			entries = append(entries, entry)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if q.Limit > 0 && len(entries) > q.Limit {
+		entries = entries[len(entries)-q.Limit:]
+	}
+	return entries, nil
+}
+
+func (q AuditQuery) matches(entry AuditEntry) bool {
+	return strings.HasPrefix(entry.Peer, q.Peer) &&
+		(q.Type == "" || strings.EqualFold(entry.Type, q.Type)) &&
+		(q.Repo == "" || entry.Repo == q.Repo) &&
+		!entry.Time.Before(q.Since)
+}
@@ -0,0 +1,198 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// Blocklist is a persistent list of peers and IP ranges the daemon refuses
+// to talk to at all.
+type Blocklist struct {
+	path    string
+	list    blocklistFile
+	ranges  []*net.IPNet // Parsed list.Ranges
+	modTime time.Time    // Of the file when last loaded or saved
+	mutex   sync.RWMutex
+}
+
+type blocklistFile struct {
+	Peers  []string `json:"peers"`
+	Ranges []string `json:"ranges"` // CIDRs like 203.0.113.0/24
+}
+
+// NewBlocklist creates a Blocklist, loading it from the given file path.
+func NewBlocklist(path string) (*Blocklist, error) {
+	b := &Blocklist{path: path}
+	if err := b.load(); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return b, nil
+}
+
+// ParseBlockEntry checks an entry and normalizes it: a peer ID, or an IP
+// address or CIDR range, which it returns as a range.
+func ParseBlockEntry(entry string) (isRange bool, normalized string, err error) {
+	if _, ipNet, err := net.ParseCIDR(entry); err == nil {
+		return true, ipNet.String(), nil
+	}
+	if ip := net.ParseIP(entry); ip != nil {
+		bits := 128
+		if ip.To4() != nil {
+			ip, bits = ip.To4(), 32
+		}
+		return true, (&net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}).String(), nil
+	}
+	p, err := peer.Decode(entry)
+	if err != nil {
+		return false, "", fmt.Errorf("'%s' is neither a peer ID, an IP address nor a CIDR range", entry)
+	}
+	return false, p.String(), nil
+}
+
+// Add blocks a peer ID or an IP range, and saves to disk.
+func (b *Blocklist) Add(entry string) error {
+	isRange, entry, err := ParseBlockEntry(entry)
+	if err != nil {
+		return err
+	}
+	b.refresh()
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	list := &b.list.Peers
+	if isRange {
+		list = &b.list.Ranges
+	}
+	for _, e := range *list {
+		if e == entry {
+			return nil
+		}
+	}
+	*list = append(*list, entry)
+	return b.save()
+}
+
+// Remove unblocks an entry, and saves to disk.
+func (b *Blocklist) Remove(entry string) error {
+	isRange, entry, err := ParseBlockEntry(entry)
+	if err != nil {
+		return err
+	}
+	b.refresh()
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	list := &b.list.Peers
+	if isRange {
+		list = &b.list.Ranges
+	}
+	for i, e := range *list {
+		if e == entry {
+			*list = append((*list)[:i], (*list)[i+1:]...)
+			return b.save()
+		}
+	}
+	return fmt.Errorf("%s is not blocked", entry)
+}
+
+// Entries returns the blocked peers and ranges.
+func (b *Blocklist) Entries() (peers, ranges []string) {
+	b.refresh()
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	return append([]string(nil), b.list.Peers...), append([]string(nil), b.list.Ranges...)
+}
+
+// BlocksPeer reports whether a peer is blocked.
+func (b *Blocklist) BlocksPeer(p peer.ID) bool {
+	b.refresh()
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	for _, e := range b.list.Peers {
+		if e == p.String() {
+			return true
+		}
+	}
+	return false
+}
+
+// BlocksIP reports whether an address is in a blocked range.
+func (b *Blocklist) BlocksIP(ip net.IP) bool {
+	b.refresh()
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	for _, r := range b.ranges {
+		if r.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// refresh reloads the file if something else, like the daemon's -block
+// flag, changed it.
+func (b *Blocklist) refresh() {
+	info, err := os.Stat(b.path)
+	if err != nil {
+		return
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if info.ModTime().Equal(b.modTime) {
+		return
+	}
+	old, oldRanges := b.list, b.ranges
+	if err := b.load(); err != nil {
+		b.list, b.ranges = old, oldRanges // Keep blocking what we did
+	}
+}
+
+func (b *Blocklist) load() error {
+	info, err := os.Stat(b.path)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(b.path)
+	if err != nil {
+		return err
+	}
+	var list blocklistFile
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	var ranges []*net.IPNet
+	for _, r := range list.Ranges {
+		_, ipNet, err := net.ParseCIDR(strings.TrimSpace(r))
+		if err != nil {
+			return fmt.Errorf("invalid range '%s' in %s", r, b.path)
+		}
+		ranges = append(ranges, ipNet)
+	}
+	b.list, b.ranges, b.modTime = list, ranges, info.ModTime()
+	return nil
+}
+
+func (b *Blocklist) save() error {
+	data, err := json.MarshalIndent(b.list, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(b.path, data, 0644); err != nil {
+		return err
+	}
+	b.ranges = nil
+	for _, r := range b.list.Ranges {
+		if _, ipNet, err := net.ParseCIDR(r); err == nil {
+			b.ranges = append(b.ranges, ipNet)
+		}
+	}
+	if info, err := os.Stat(b.path); err == nil {
+		b.modTime = info.ModTime()
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package store
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// DefaultStateDir is where the daemon keeps its identity, trusted peers and
+// linked repos unless told otherwise: $XDG_STATE_HOME/p2p-git-daemon when
+// that is set, ~/.p2p-git-daemon otherwise. If the home directory can't be
+// found, it's the working directory.
+func DefaultStateDir() string {
+	if xdg := os.Getenv("XDG_STATE_HOME"); filepath.IsAbs(xdg) {
+		return filepath.Join(xdg, "p2p-git-daemon")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "."
+	}
+	return filepath.Join(home, ".p2p-git-daemon")
+}
+
+// SameDir reports whether a and b are the same existing directory.
+func SameDir(a, b string) bool {
+	ai, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	bi, err := os.Stat(b)
+	return err == nil && os.SameFile(ai, bi)
+}
+
+// MoveFile renames from to to, or copies it over when they are on different
+// file systems.
+func MoveFile(from, to string) error {
+	if os.Rename(from, to) == nil {
+		return nil
+	}
+	in, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(to, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(to)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(to)
+		return err
+	}
+	return os.Remove(from)
+}
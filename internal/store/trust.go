@@ -2,16 +2,61 @@ package store
 
 import (
 	"encoding/json"
+	"fmt"
 	"os"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/libp2p/go-libp2p/core/peer"
 )
 
+// Role is what a trusted peer is allowed to do.
+type Role string
+
+const (
+	RoleReadOnly  Role = "read-only"  // Look at repos, but change nothing
+	RoleReadWrite Role = "read-write" // Edit files, commit, push, reset...
+	RoleAdmin     Role = "admin"      // Also manage the daemon: link repos, set config
+)
+
+// ParseRole checks a role name.
+func ParseRole(s string) (Role, error) {
+	switch r := Role(s); r {
+	case RoleReadOnly, RoleReadWrite, RoleAdmin:
+		return r, nil
+	}
+	return "", fmt.Errorf("unknown role '%s' (use read-only, read-write or admin)", s)
+}
+
+// Allows reports whether a peer with role r may do what needs role required.
+func (r Role) Allows(required Role) bool {
+	rank := map[Role]int{RoleReadOnly: 1, RoleReadWrite: 2, RoleAdmin: 3}
+	return rank[r] >= rank[required]
+}
+
+// TrustedPeer is what the store records about a trusted peer.
+type TrustedPeer struct {
+	Role       Role      `json:"role"`
+	ApprovedAt time.Time `json:"approved_at"`
+	// TTL is how long the approval lasts, like "720h". Empty means the
+	// store's default.
+	TTL    string `json:"ttl,omitempty"`
+	Pinned bool   `json:"pinned,omitempty"` // Never expires
+	// KeyFingerprint is the SHA-256 of the public key the peer proved it
+	// holds when it paired
+	KeyFingerprint string `json:"key_fingerprint,omitempty"`
+	// Verified is set if the owner confirmed both sides showed the same
+	// word fingerprints when pairing
+	Verified bool `json:"verified,omitempty"`
+}
+
 // TrustStore manages a list of trusted peer IDs.
 type TrustStore struct {
 	path         string
-	trustedPeers map[peer.ID]bool
+	trustedPeers map[peer.ID]TrustedPeer
+	defaultTTL   time.Duration // 0: approvals don't expire
+	modTime      time.Time     // Of the file when last loaded or saved
 	mutex        sync.RWMutex
 }
 
@@ -19,7 +64,7 @@ type TrustStore struct {
 func NewTrustStore(path string) (*TrustStore, error) {
 	ts := &TrustStore{
 		path:         path,
-		trustedPeers: make(map[peer.ID]bool),
+		trustedPeers: make(map[peer.ID]TrustedPeer),
 	}
 	if err := ts.load(); err != nil && !os.IsNotExist(err) {
 		return nil, err
@@ -27,49 +72,243 @@ func NewTrustStore(path string) (*TrustStore, error) {
 	return ts, nil
 }
 
-// IsTrusted checks if a peer is in the trust store.
+// SetDefaultTTL sets how long approvals without a TTL of their own last.
+// Zero means they never expire.
+func (ts *TrustStore) SetDefaultTTL(ttl time.Duration) {
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+	ts.defaultTTL = ttl
+}
+
+// ExpiresAt returns when a peer's approval expires, or the zero time if it
+// never does.
+func (ts *TrustStore) ExpiresAt(entry TrustedPeer) time.Time {
+	ts.mutex.RLock()
+	ttl := ts.defaultTTL
+	ts.mutex.RUnlock()
+	if entry.TTL != "" {
+		if d, err := time.ParseDuration(entry.TTL); err == nil {
+			ttl = d
+		}
+	}
+	if entry.Pinned || ttl <= 0 {
+		return time.Time{}
+	}
+	return entry.ApprovedAt.Add(ttl)
+}
+
+// Expired reports whether a peer's approval has run out.
+func (ts *TrustStore) Expired(entry TrustedPeer) bool {
+	expiry := ts.ExpiresAt(entry)
+	return !expiry.IsZero() && time.Now().After(expiry)
+}
+
+// IsTrusted checks if a peer is in the trust store and its approval hasn't
+// expired.
 func (ts *TrustStore) IsTrusted(p peer.ID) bool {
+	_, ok := ts.Role(p)
+	return ok
+}
+
+// Role returns the role of a trusted peer whose approval hasn't expired.
+func (ts *TrustStore) Role(p peer.ID) (Role, bool) {
+	ts.refresh()
+	ts.mutex.RLock()
+	entry, ok := ts.trustedPeers[p]
+	ts.mutex.RUnlock()
+	if !ok || ts.Expired(entry) {
+		return "", false
+	}
+	return entry.Role, true
+}
+
+// Peers returns every trusted peer, including those whose approval expired.
+func (ts *TrustStore) Peers() map[peer.ID]TrustedPeer {
+	ts.refresh()
 	ts.mutex.RLock()
 	defer ts.mutex.RUnlock()
-	return ts.trustedPeers[p]
+	peers := make(map[peer.ID]TrustedPeer, len(ts.trustedPeers))
+	for p, entry := range ts.trustedPeers {
+		peers[p] = entry
+	}
+	return peers
+}
+
+// FindPeer returns the trusted peer whose ID is id or starts with it.
+func (ts *TrustStore) FindPeer(id string) (peer.ID, error) {
+	var found []peer.ID
+	for p := range ts.Peers() {
+		if p.String() == id {
+			return p, nil
+		}
+		if id != "" && strings.HasPrefix(p.String(), id) {
+			found = append(found, p)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return "", fmt.Errorf("no trusted peer matches '%s'", id)
+	case 1:
+		return found[0], nil
+	}
+	return "", fmt.Errorf("'%s' matches %d trusted peers; give more of the ID", id, len(found))
+}
+
+// RemoveTrustedPeer revokes a peer's trust and saves to disk. It has to
+// handshake again to reconnect.
+func (ts *TrustStore) RemoveTrustedPeer(p peer.ID) error {
+	ts.refresh()
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+	if _, ok := ts.trustedPeers[p]; !ok {
+		return fmt.Errorf("peer %s is not trusted", p)
+	}
+	delete(ts.trustedPeers, p)
+	return ts.save()
+}
+
+// ExtendTrust approves a peer again from now on, as if it had just paired,
+// and saves to disk.
+func (ts *TrustStore) ExtendTrust(p peer.ID) error {
+	return ts.update(p, func(entry *TrustedPeer) {
+		entry.ApprovedAt = time.Now()
+	})
+}
+
+// PinPeer makes a peer's approval never expire, or expire again, and saves
+// to disk.
+func (ts *TrustStore) PinPeer(p peer.ID, pinned bool) error {
+	return ts.update(p, func(entry *TrustedPeer) {
+		entry.Pinned = pinned
+		if !pinned {
+			entry.ApprovedAt = time.Now() // Not to expire the moment it's unpinned
+		}
+	})
+}
+
+func (ts *TrustStore) update(p peer.ID, change func(*TrustedPeer)) error {
+	ts.refresh()
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+	entry, ok := ts.trustedPeers[p]
+	if !ok {
+		return fmt.Errorf("peer %s is not trusted", p)
+	}
+	change(&entry)
+	ts.trustedPeers[p] = entry
+	return ts.save()
+}
+
+// refresh reloads the file if another process, like `daemon -revoke`,
+// changed it since it was last read.
+func (ts *TrustStore) refresh() {
+	info, err := os.Stat(ts.path)
+	if err != nil {
+		return
+	}
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+	if info.ModTime().Equal(ts.modTime) {
+		return
+	}
+	old := ts.trustedPeers
+	ts.trustedPeers = make(map[peer.ID]TrustedPeer)
+	if err := ts.load(); err != nil {
+		ts.trustedPeers = old // Keep what we had rather than trusting no one
+	}
 }
 
-// AddTrustedPeer adds a peer to the trust store and saves to disk.
+// AddTrustedPeer adds a peer to the trust store as read-write and saves to disk.
 func (ts *TrustStore) AddTrustedPeer(p peer.ID) error {
+	return ts.AddTrustedPeerAs(p, TrustedPeer{Role: RoleReadWrite})
+}
+
+// AddTrustedPeerAs adds a peer to the trust store, approved from now on,
+// and saves to disk.
+func (ts *TrustStore) AddTrustedPeerAs(p peer.ID, entry TrustedPeer) error {
+	ts.refresh()
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+	entry.ApprovedAt = time.Now()
+	ts.trustedPeers[p] = entry
+	return ts.save()
+}
+
+// CheckKey checks that a trusted peer still uses the public key it paired
+// with. Peers that paired before keys were recorded get theirs recorded now.
+func (ts *TrustStore) CheckKey(p peer.ID, keyFingerprint string) error {
+	ts.refresh()
 	ts.mutex.Lock()
 	defer ts.mutex.Unlock()
-	ts.trustedPeers[p] = true
+	entry, ok := ts.trustedPeers[p]
+	switch {
+	case !ok:
+		return fmt.Errorf("peer %s is not trusted", p)
+	case entry.KeyFingerprint == keyFingerprint:
+		return nil
+	case entry.KeyFingerprint != "":
+		return fmt.Errorf("peer %s presented a different public key than the one it paired with", p)
+	}
+	entry.KeyFingerprint = keyFingerprint
+	ts.trustedPeers[p] = entry
 	return ts.save()
 }
 
 func (ts *TrustStore) load() error {
+	info, err := os.Stat(ts.path)
+	if err != nil {
+		return err
+	}
 	data, err := os.ReadFile(ts.path)
 	if err != nil {
 		return err
 	}
-	var peers []string
+	ts.modTime = info.ModTime()
+	var peers map[string]TrustedPeer
 	if err := json.Unmarshal(data, &peers); err != nil {
-		return err
+		// Older versions stored a list of IDs, all with full access
+		var ids []string
+		if json.Unmarshal(data, &ids) != nil {
+			return err
+		}
+		peers = make(map[string]TrustedPeer)
+		for _, id := range ids {
+			peers[id] = TrustedPeer{Role: RoleAdmin}
+		}
 	}
-	for _, pStr := range peers {
+	for pStr, entry := range peers {
 		p, err := peer.Decode(pStr)
 		if err != nil {
 			// Skip invalid entries
 			continue
 		}
-		ts.trustedPeers[p] = true
+		if _, err := ParseRole(string(entry.Role)); err != nil {
+			entry.Role = RoleReadOnly
+		}
+		if entry.ApprovedAt.IsZero() {
+			// Approved before approvals expired: count from the last change
+			// to the file rather than forever
+			entry.ApprovedAt = ts.modTime
+		}
+		ts.trustedPeers[p] = entry
 	}
 	return nil
 }
 
 func (ts *TrustStore) save() error {
-	var peers []string
-	for p := range ts.trustedPeers {
-		peers = append(peers, p.String())
+	peers := make(map[string]TrustedPeer)
+	for p, entry := range ts.trustedPeers {
+		peers[p.String()] = entry
 	}
 	data, err := json.MarshalIndent(peers, "", "  ")
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(ts.path, data, 0644)
+	if err := os.WriteFile(ts.path, data, 0644); err != nil {
+		return err
+	}
+	if info, err := os.Stat(ts.path); err == nil {
+		ts.modTime = info.ModTime()
+	}
+	return nil
 }
@@ -0,0 +1,28 @@
+// Package systemd tells systemd how a Type=notify service is doing, as
+// sd_notify(3) does, without linking libsystemd.
+package systemd
+
+import (
+	"net"
+	"os"
+	"strings"
+)
+
+// Notify sends systemd a state like "READY=1" or "STOPPING=1", if it started
+// the process with $NOTIFY_SOCKET. Otherwise it does nothing.
+func Notify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	if strings.HasPrefix(socket, "@") {
+		socket = "\x00" + socket[1:] // An abstract socket
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
@@ -0,0 +1,57 @@
+package tui
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+
+	"github.com/hemantsingh443/p2p-git-remote/internal/protocol"
+)
+
+// daemonStatusInterval is how often the header's daemon status is refreshed.
+const daemonStatusInterval = 30 * time.Second
+
+var headerStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("245")).Padding(0, 1)
+
+type daemonStatusTickMsg struct{}
+
+// daemonStatusMsg carries the daemon's status, or nothing if it couldn't
+// be had, in which case the header keeps the last one.
+type daemonStatusMsg struct {
+	status *protocol.DaemonStatusResponsePayload
+}
+
+func fetchDaemonStatus(state *AppState) tea.Cmd {
+	return func() tea.Msg {
+		respBytes, err := sendRequest(state, protocol.TypeDaemonStatusRequest, protocol.DaemonStatusRequestPayload{})
+		if err != nil {
+			return daemonStatusMsg{}
+		}
+		var p protocol.DaemonStatusResponsePayload
+		if json.Unmarshal(respBytes, &p) != nil || !p.Success {
+			return daemonStatusMsg{}
+		}
+		return daemonStatusMsg{status: &p}
+	}
+}
+
+func nextDaemonStatusCmd() tea.Cmd {
+	return tea.Tick(daemonStatusInterval, func(time.Time) tea.Msg { return daemonStatusTickMsg{} })
+}
+
+// renderHeader sums up the daemon, e.g.
+// "Daemon v1.2.0 · up 3h12m · 2 busy · 1/3 trusted peers online".
+func renderHeader(s *protocol.DaemonStatusResponsePayload) string {
+	if s == nil {
+		return headerStyle.Render("Daemon status unknown")
+	}
+	header := fmt.Sprintf("Daemon %s · up %s · %d busy · %d/%d trusted peers online",
+		s.Version, (time.Duration(s.UptimeMs) * time.Millisecond).Truncate(time.Minute), s.InFlight, s.ConnectedTrusted, s.TrustedPeers)
+	if s.ReadOnly {
+		header += " · read-only"
+	}
+	return headerStyle.Render(header)
+}
@@ -0,0 +1,73 @@
+package tui
+
+import (
+	"context"
+	"encoding/json"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/libp2p/go-libp2p/core/network"
+
+	"github.com/hemantsingh443/p2p-git-remote/internal/protocol"
+)
+
+// fileChangedMsg carries a FILE_CHANGED event, along with the subscription
+// stream to keep listening on.
+type fileChangedMsg struct {
+	event  protocol.FileChangedEventPayload
+	stream network.Stream
+}
+
+// repoEventMsg carries a REPO_EVENT, like a commit someone else made.
+type repoEventMsg struct {
+	event  protocol.RepoEventPayload
+	stream network.Stream
+}
+
+// subscribeEventsCmd asks the daemon for file change and repo events of the
+// current repo, so the panes can refresh themselves. If the daemon can't watch
+// files the TUI just goes without.
+func subscribeEventsCmd(state *AppState) tea.Cmd {
+	return func() tea.Msg {
+		if state.Session.Ensure(context.Background()) != nil {
+			return nil
+		}
+		stream, err := state.Conn.NewStream(context.Background())
+		if err != nil {
+			return nil
+		}
+		payloadBytes, _ := json.Marshal(protocol.SubscribeEventsRequestPayload{RepoPath: state.CurrentRepo, RepoEvents: true})
+		if err := protocol.WriteMessage(stream, &protocol.Message{Type: protocol.TypeSubscribeEventsRequest, Payload: payloadBytes}); err != nil {
+			stream.Close()
+			return nil
+		}
+		resp, err := protocol.ReadMessage(stream)
+		var p protocol.SubscribeEventsResponsePayload
+		if err == nil {
+			json.Unmarshal(resp.Payload, &p)
+		}
+		if !p.Success {
+			stream.Close()
+			return nil
+		}
+		return waitForEvent(stream)()
+	}
+}
+
+// waitForEvent waits for the next event on a subscription stream.
+func waitForEvent(stream network.Stream) tea.Cmd {
+	return func() tea.Msg {
+		msg, err := protocol.ReadMessage(stream)
+		if err != nil {
+			stream.Close()
+			return nil
+		}
+		if msg.Type == protocol.TypeRepoEvent {
+			var event protocol.RepoEventPayload
+			json.Unmarshal(msg.Payload, &event)
+			return repoEventMsg{event: event, stream: stream}
+		}
+		var event protocol.FileChangedEventPayload
+		json.Unmarshal(msg.Payload, &event)
+		return fileChangedMsg{event: event, stream: stream}
+	}
+}
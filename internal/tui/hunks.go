@@ -0,0 +1,162 @@
+package tui
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+
+	"github.com/hemantsingh443/p2p-git-remote/internal/protocol"
+)
+
+// hunkPicker is the state of the hunk staging view, the TUI's `git add -p`.
+type hunkPicker struct {
+	file     string
+	hunks    []protocol.DiffHunk
+	cursor   int
+	selected map[string]bool // Keyed by hunk ID
+}
+
+type hunksLoadedMsg struct {
+	file  string
+	hunks []protocol.DiffHunk
+}
+type hunksStagedMsg struct{ output string }
+type committedMsg struct{}
+
+var (
+	hunkAddStyle    = lipgloss.NewStyle().Foreground(lipgloss.Color("42"))
+	hunkDelStyle    = lipgloss.NewStyle().Foreground(lipgloss.Color("203"))
+	hunkHeaderStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("63"))
+	hunkCursorStyle = lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("214"))
+)
+
+// render draws every hunk with its selection box and returns the content
+// along with the line the cursor's hunk starts on, for scrolling.
+func (p *hunkPicker) render() (string, int) {
+	var b strings.Builder
+	cursorLine, line := 0, 0
+	for i, h := range p.hunks {
+		box := "[ ]"
+		if p.selected[h.ID] {
+			box = "[x]"
+		}
+		title := fmt.Sprintf("%s hunk %d/%d  +%d -%d", box, i+1, len(p.hunks), h.Insertions, h.Deletions)
+		if i == p.cursor {
+			cursorLine = line
+			title = hunkCursorStyle.Render("> " + title)
+		} else {
+			title = "  " + title
+		}
+		b.WriteString(title + "\n")
+		line++
+
+		for _, l := range strings.Split(strings.TrimRight(h.Patch, "\n"), "\n") {
+			switch {
+			case strings.HasPrefix(l, "@@"):
+				l = hunkHeaderStyle.Render(l)
+			case strings.HasPrefix(l, "+"):
+				l = hunkAddStyle.Render(l)
+			case strings.HasPrefix(l, "-"):
+				l = hunkDelStyle.Render(l)
+			}
+			b.WriteString("    " + l + "\n")
+			line++
+		}
+		b.WriteString("\n")
+		line++
+	}
+	return b.String(), cursorLine
+}
+
+// showHunkPicker redraws the picker into the content pane, keeping the
+// cursor's hunk in view.
+func (m *Model) showHunkPicker() {
+	content, cursorLine := m.hunkPicker.render()
+	m.viewport.SetContent(content)
+	m.viewport.SetYOffset(cursorLine)
+	m.statusMsg = fmt.Sprintf("Hunks of %s: j/k move, space toggle, a all, enter stage, esc cancel", m.hunkPicker.file)
+}
+
+// updateHunkPicker handles key presses while the hunk picker is open.
+func (m Model) updateHunkPicker(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	p := m.hunkPicker
+	switch msg.String() {
+	case "up", "k":
+		if p.cursor > 0 {
+			p.cursor--
+		}
+	case "down", "j":
+		if p.cursor < len(p.hunks)-1 {
+			p.cursor++
+		}
+	case " ":
+		id := p.hunks[p.cursor].ID
+		p.selected[id] = !p.selected[id]
+	case "a":
+		// Select all, or clear the selection if everything is already selected
+		all := true
+		for _, h := range p.hunks {
+			all = all && p.selected[h.ID]
+		}
+		for _, h := range p.hunks {
+			p.selected[h.ID] = !all
+		}
+	case "enter":
+		var ids []string
+		for _, h := range p.hunks {
+			if p.selected[h.ID] {
+				ids = append(ids, h.ID)
+			}
+		}
+		if len(ids) == 0 {
+			m.statusMsg = "No hunks selected. Press space to select one."
+			return m, nil
+		}
+		m.hunkPicker = nil
+		m.statusMsg = fmt.Sprintf("Staging %d hunk(s) of %s...", len(ids), p.file)
+		return m, stageHunksCmd(m.state, p.file, ids)
+	case "esc", "q":
+		m.hunkPicker = nil
+		m.statusMsg = "Hunk staging cancelled."
+		return m, nil
+	default:
+		return m, nil
+	}
+	m.showHunkPicker()
+	return m, nil
+}
+
+func fetchHunksCmd(state *AppState, filePath string) tea.Cmd {
+	return func() tea.Msg {
+		reqPayload := protocol.ListHunksRequestPayload{RepoPath: state.CurrentRepo, FilePath: filePath}
+		respBytes, err := sendRequest(state, protocol.TypeListHunksRequest, reqPayload)
+		if err != nil {
+			return errorMsg{err}
+		}
+		var p protocol.ListHunksResponsePayload
+		json.Unmarshal(respBytes, &p)
+		if !p.Success {
+			return errorMsg{fmt.Errorf(p.Error)}
+		}
+		return hunksLoadedMsg{file: filePath, hunks: p.Hunks}
+	}
+}
+
+func stageHunksCmd(state *AppState, filePath string, ids []string) tea.Cmd {
+	return func() tea.Msg {
+		reqPayload := protocol.StageHunksRequestPayload{RepoPath: state.CurrentRepo, FilePath: filePath, HunkIDs: ids}
+		respBytes, err := sendRequest(state, protocol.TypeStageHunksRequest, reqPayload)
+		if err != nil {
+			return errorMsg{err}
+		}
+		var p protocol.StageHunksResponsePayload
+		json.Unmarshal(respBytes, &p)
+		if !p.Success {
+			return errorMsg{fmt.Errorf(p.Output)}
+		}
+		return hunksStagedMsg{output: p.Output}
+	}
+}
@@ -0,0 +1,91 @@
+package tui
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/libp2p/go-libp2p/core/network"
+
+	p2p "github.com/hemantsingh443/p2p-git-remote/internal/p2p"
+)
+
+// latencyInterval is how often the TUI pings the daemon for the latency
+// shown in the status bar, and latencySamples how many pings it averages.
+const (
+	latencyInterval = 10 * time.Second
+	latencySamples  = 5
+)
+
+// latency keeps the last few round-trip times to the daemon, measured over
+// one long-lived ping stream.
+type latency struct {
+	stream  network.Stream
+	seq     int
+	samples []time.Duration
+	failed  bool
+}
+
+type pingTickMsg struct{}
+
+// pingResultMsg carries a ping's result, and the stream to ping on next.
+type pingResultMsg struct {
+	stream network.Stream
+	rtt    time.Duration
+	err    error
+}
+
+// pingCmd pings the daemon on stream, opening it first if it's nil.
+func pingCmd(state *AppState, stream network.Stream, seq int) tea.Cmd {
+	return func() tea.Msg {
+		if stream == nil {
+			if err := state.Session.Ensure(context.Background()); err != nil {
+				return pingResultMsg{err: err}
+			}
+			var err error
+			if stream, err = state.Conn.NewStream(context.Background()); err != nil {
+				return pingResultMsg{err: err}
+			}
+		}
+		rtt, err := p2p.Ping(stream, seq)
+		if err != nil {
+			stream.Close()
+			return pingResultMsg{err: err}
+		}
+		return pingResultMsg{stream: stream, rtt: rtt}
+	}
+}
+
+func nextPingCmd() tea.Cmd {
+	return tea.Tick(latencyInterval, func(time.Time) tea.Msg { return pingTickMsg{} })
+}
+
+// record takes in a ping's result. A failed ping drops the stream, so the
+// next one opens a new one.
+func (l *latency) record(msg pingResultMsg) {
+	l.stream = msg.stream
+	l.failed = msg.err != nil
+	if l.failed {
+		return
+	}
+	l.samples = append(l.samples, msg.rtt)
+	if len(l.samples) > latencySamples {
+		l.samples = l.samples[1:]
+	}
+}
+
+// String renders the average of the recent round-trip times, e.g. "⏱ 23ms".
+func (l *latency) String() string {
+	if l.failed {
+		return "⏱ ?"
+	}
+	if len(l.samples) == 0 {
+		return ""
+	}
+	var sum time.Duration
+	for _, rtt := range l.samples {
+		sum += rtt
+	}
+	return fmt.Sprintf("⏱ %dms", (sum / time.Duration(len(l.samples))).Milliseconds())
+}
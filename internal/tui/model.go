@@ -7,6 +7,8 @@ import (
 	"io"
 	"os"
 	"os/exec"
+	"path"
+	"path/filepath"
 	"strings"
 
 	"github.com/charmbracelet/bubbles/list"
@@ -18,6 +20,7 @@ import (
 	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/peer"
 
+	"github.com/hemantsingh443/p2p-git-remote/internal/p2p"
 	"github.com/hemantsingh443/p2p-git-remote/internal/protocol"
 )
 
@@ -26,6 +29,8 @@ const (
 	viewFiles = iota
 	viewCommits
 	viewBranches
+	viewStashes
+	viewSearch
 )
 
 // AppState holds the shared P2P state needed by the TUI.
@@ -34,6 +39,9 @@ type AppState struct {
 	DaemonInfo    peer.AddrInfo
 	CurrentRepo   string
 	CurrentBranch string
+	CurrentDir    string // Directory shown in the Files pane, relative to the repo root
+	Session       *p2p.SessionKeeper
+	Conn          *p2p.ConnSupervisor // Opens streams to the daemon, reconnecting if needed
 }
 
 // Model is the core state of our TUI application.
@@ -41,7 +49,8 @@ type Model struct {
 	state      *AppState
 	ready      bool
 	statusMsg  string
-	activePane int // 0 for nav, 1 for viewport
+	repoStatus string // Branch, ahead/behind and change counts, e.g. "main ↑1 ✚2"
+	activePane int    // 0 for nav, 1 for viewport
 
 	// --- NEW: Multiple lists for the navigation pane ---
 	navViews   []list.Model
@@ -55,6 +64,22 @@ type Model struct {
 	isInputting      bool            // Are we currently typing a commit message?
 	textInput        textinput.Model // The input field for commit messages
 	afterInputAction tea.Cmd         // What to do after input is done (e.g., commit)
+	isSearching      bool            // The input is a search pattern rather than a commit message
+	searchPattern    string          // Pattern of the results in the Search view
+
+	pendingConfirm tea.Cmd // Destructive action awaiting a y/n answer
+
+	// The file shown in the content pane, fetched a page at a time
+	catFile     string
+	catText     string // Lines fetched so far
+	catNext     int    // Next line to fetch, 0 once the whole file is loaded
+	catFetching bool
+
+	hunkPicker *hunkPicker // Non-nil while choosing hunks to stage
+	stagedOnly bool        // Hunks were staged, so the next commit takes only the index
+
+	latency      *latency                              // Round-trip time to the daemon, in the status bar
+	daemonStatus *protocol.DaemonStatusResponsePayload // Shown in the header
 }
 
 // --- Bubble Tea Interface Implementation ---
@@ -70,6 +95,12 @@ func NewModel(state *AppState) Model {
 	branchList := list.New([]list.Item{}, itemDelegate{}, 0, 0)
 	branchList.Title = "Branches"
 
+	stashList := list.New([]list.Item{}, itemDelegate{}, 0, 0)
+	stashList.Title = "Stashes"
+
+	searchList := list.New([]list.Item{}, itemDelegate{}, 0, 0)
+	searchList.Title = "Search"
+
 	// Setup the Glamour renderer for syntax highlighting
 	glamourRenderer, _ := glamour.NewTermRenderer(
 		glamour.WithAutoStyle(),
@@ -85,13 +116,14 @@ func NewModel(state *AppState) Model {
 
 	m := Model{
 		state:       state,
-		navViews:    []list.Model{fileList, commitList, branchList},
+		navViews:    []list.Model{fileList, commitList, branchList, stashList, searchList},
 		activeView:  viewFiles, // Start with the file view
 		statusMsg:   "Loading...",
 		activePane:  0,
 		glamour:     glamourRenderer,
 		isInputting: false,
 		textInput:   ti,
+		latency:     &latency{seq: 1}, // Init sends the first ping
 	}
 
 	// Set initial titles, including the branch
@@ -104,6 +136,10 @@ func (m Model) Init() tea.Cmd {
 		fetchListContent(m.state, viewFiles),
 		fetchListContent(m.state, viewCommits),
 		fetchListContent(m.state, viewBranches),
+		fetchListContent(m.state, viewStashes),
+		subscribeEventsCmd(m.state),
+		pingCmd(m.state, nil, 1),
+		fetchDaemonStatus(m.state),
 	)
 }
 
@@ -115,49 +151,156 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		case tea.KeyMsg:
 			switch msg.String() {
 			case "enter":
-				// Commit with the message
-				commitMsg := m.textInput.Value()
+				value := m.textInput.Value()
 				m.isInputting = false
 				m.textInput.Reset()
-				return m, commitCmd(m.state, commitMsg)
+				if m.isSearching {
+					m.isSearching = false
+					if value == "" {
+						m.statusMsg = "Search cancelled."
+						return m, nil
+					}
+					m.statusMsg = fmt.Sprintf("Searching for '%s'...", value)
+					return m, grepCmd(m.state, value)
+				}
+				// Commit with the message
+				return m, commitCmd(m.state, value, m.stagedOnly)
 			case "ctrl+c", "esc":
 				// Cancel input
 				m.isInputting = false
 				m.textInput.Reset()
 				m.statusMsg = "Commit cancelled."
+				if m.isSearching {
+					m.isSearching = false
+					m.statusMsg = "Search cancelled."
+				}
 				return m, nil
 			}
+		case commitTemplateMsg:
+			if m.isSearching {
+				return m, nil
+			}
+			// Don't clobber anything typed while the template was loading
+			if m.textInput.Value() == "" {
+				m.textInput.SetValue(msg.template)
+				m.textInput.CursorEnd()
+			}
+			return m, nil
 		}
 		m.textInput, cmd = m.textInput.Update(msg)
 		return m, cmd
 	}
+	if keyMsg, ok := msg.(tea.KeyMsg); ok && m.hunkPicker != nil {
+		return m.updateHunkPicker(keyMsg)
+	}
+	// The next key press answers a pending confirmation
+	if keyMsg, ok := msg.(tea.KeyMsg); ok && m.pendingConfirm != nil {
+		action := m.pendingConfirm
+		m.pendingConfirm = nil
+		if keyMsg.String() == "y" {
+			m.statusMsg = "Working..."
+			return m, action
+		}
+		m.statusMsg = "Cancelled."
+		return m, nil
+	}
 	// ... rest of the Update function as before ...
 	var cmds []tea.Cmd
 	var cmd tea.Cmd
 	oldIndex := m.navViews[m.activeView].Index()
 	m.navViews[m.activeView], cmd = m.navViews[m.activeView].Update(msg)
 	cmds = append(cmds, cmd)
-	if m.navViews[m.activeView].Index() != oldIndex {
-		if m.navViews[m.activeView].SelectedItem() != nil {
-			selectedItem := m.navViews[m.activeView].SelectedItem().(item)
-			cmds = append(cmds, m.fetchContent(m.state, "cat", string(selectedItem)))
+	if m.navViews[m.activeView].Index() != oldIndex && m.activeView == viewFiles {
+		if f, ok := m.selectedFile(); ok && !f.isDir {
+			cmds = append(cmds, fetchCatPage(m.state, f.path, 1))
 		}
 	}
 	switch msg := msg.(type) {
 	case tea.WindowSizeMsg:
 		h, v := appStyle.GetFrameSize()
 		for i := range m.navViews {
-			m.navViews[i].SetSize(msg.Width/3-h, msg.Height-v-3)
+			m.navViews[i].SetSize(msg.Width/3-h, msg.Height-v-4)
 		}
 		m.viewport.Width = msg.Width*2/3 - h
-		m.viewport.Height = msg.Height - v - 3
+		m.viewport.Height = msg.Height - v - 4 // Header and status bar
 		m.ready = true
 	case listLoadedMsg:
 		m.navViews[msg.viewIndex].SetItems(msg.items)
+		if msg.currentBranch != "" && msg.currentBranch != m.state.CurrentBranch {
+			m.state.CurrentBranch = msg.currentBranch
+			m.updateTitles()
+		}
+		if msg.viewIndex == viewFiles {
+			// Anything that changes the files may change the status too
+			cmds = append(cmds, fetchRepoStatus(m.state))
+		}
+	case repoStatusMsg:
+		m.repoStatus = msg.badge
+	case fileChangedMsg:
+		// Files changed on the daemon; the refresh also updates the status badge
+		cmds = append(cmds, fetchListContent(m.state, viewFiles), waitForEvent(msg.stream))
+	case pingTickMsg:
+		m.latency.seq++
+		cmds = append(cmds, pingCmd(m.state, m.latency.stream, m.latency.seq))
+	case pingResultMsg:
+		m.latency.record(msg)
+		cmds = append(cmds, nextPingCmd())
+	case daemonStatusTickMsg:
+		cmds = append(cmds, fetchDaemonStatus(m.state))
+	case daemonStatusMsg:
+		if msg.status != nil {
+			m.daemonStatus = msg.status
+		}
+		cmds = append(cmds, nextDaemonStatusCmd())
+	case repoEventMsg:
+		// Another client, or someone at the daemon, moved a branch
+		switch msg.event.Kind {
+		case protocol.RepoEventCommit:
+			m.statusMsg = fmt.Sprintf("New commit on %s: %s", msg.event.Branch, msg.event.Subject)
+		case protocol.RepoEventRenamed:
+			// Or renamed the repo, which later requests must use the new name of
+			m.state.CurrentRepo = msg.event.RepoPath
+			m.statusMsg = fmt.Sprintf("The repo was renamed from %s to %s.", msg.event.OldAlias, msg.event.RepoPath)
+		}
+		cmds = append(cmds, fetchListContent(m.state, viewCommits), fetchListContent(m.state, viewBranches), waitForEvent(msg.stream))
+	case hunksLoadedMsg:
+		if len(msg.hunks) == 0 {
+			m.statusMsg = "No unstaged hunks in " + msg.file + "."
+			break
+		}
+		m.hunkPicker = &hunkPicker{file: msg.file, hunks: msg.hunks, selected: make(map[string]bool)}
+		m.showHunkPicker()
+	case hunksStagedMsg:
+		m.stagedOnly = true
+		m.statusMsg = strings.TrimSpace(msg.output) + ". C now commits only staged changes."
+		cmds = append(cmds, fetchListContent(m.state, viewFiles))
+	case committedMsg:
+		m.stagedOnly = false
 	case contentReadyMsg:
+		m.catFile, m.catNext = "", 0
 		m.viewport.SetContent(msg.content)
+		if msg.yOffset > 0 {
+			m.viewport.SetYOffset(msg.yOffset)
+		}
 		m.statusMsg = msg.status
+	case grepResultsMsg:
+		items := make([]list.Item, len(msg.matches))
+		for i, match := range msg.matches {
+			items[i] = matchItem(match)
+		}
+		m.navViews[viewSearch].SetItems(items)
+		m.navViews[viewSearch].ResetSelected()
+		m.searchPattern = msg.pattern
+		m.activeView = viewSearch
+		m.updateTitles()
+		m.statusMsg = fmt.Sprintf("%d matches for '%s'. Enter shows a match.", len(items), msg.pattern)
+		if msg.truncated {
+			m.statusMsg = fmt.Sprintf("First %d matches for '%s'; narrow the pattern to see the rest.", len(items), msg.pattern)
+		}
+	case catPageMsg:
+		m.showCatPage(msg)
 	case errorMsg:
+		m.catFetching = false
 		m.statusMsg = "Error: " + msg.err.Error()
 	case branchSwitchedMsg:
 		m.state.CurrentBranch = msg.branchName // Solidify the state
@@ -174,39 +317,93 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		switch msg.String() {
 		case "1":
 			m.activeView = viewFiles
-			m.navViews[m.activeView].Title = "> Files"
-			m.navViews[viewCommits].Title = "  Commits"
-			m.navViews[viewBranches].Title = "  Branches"
+			m.updateTitles()
 		case "2":
 			m.activeView = viewCommits
-			m.navViews[m.activeView].Title = "> Commits"
-			m.navViews[viewFiles].Title = "  Files"
-			m.navViews[viewBranches].Title = "  Branches"
+			m.updateTitles()
 		case "3":
 			m.activeView = viewBranches
-			m.navViews[m.activeView].Title = "> Branches"
-			m.navViews[viewFiles].Title = "  Files"
-			m.navViews[viewCommits].Title = "  Commits"
+			m.updateTitles()
+		case "4":
+			m.activeView = viewStashes
+			m.updateTitles()
+		case "5":
+			m.activeView = viewSearch
+			m.updateTitles()
+		case "F":
+			m.isInputting = true
+			m.isSearching = true
+			m.textInput.Placeholder = "Search pattern (regex, case-insensitive unless it has capitals)..."
+			m.statusMsg = "Enter a search pattern (enter to search, esc to cancel)"
+			return m, nil
+		case "a", "p", "d":
+			// Stash manager actions: apply, pop, drop the selected stash
+			if m.activeView == viewStashes && m.navViews[viewStashes].SelectedItem() != nil {
+				selectedItem := m.navViews[viewStashes].SelectedItem().(item)
+				var index int
+				if _, err := fmt.Sscanf(string(selectedItem), "stash@{%d}", &index); err != nil {
+					m.statusMsg = "Could not determine stash index."
+					return m, nil
+				}
+				action := map[string]string{"a": "apply", "p": "pop", "d": "drop"}[msg.String()]
+				m.statusMsg = fmt.Sprintf("Running stash %s on stash@{%d}...", action, index)
+				return m, stashActionCmd(m.state, action, index)
+			}
 		case "e":
-			if m.activeView == viewFiles && m.navViews[viewFiles].SelectedItem() != nil {
-				selectedItem := m.navViews[viewFiles].SelectedItem().(item)
-				return m, editFileCmd(m.state, string(selectedItem))
+			if f, ok := m.selectedFile(); ok && !f.isDir {
+				return m, editFileCmd(m.state, f.path)
+			}
+		case "H":
+			if f, ok := m.selectedFile(); ok && !f.isDir {
+				return m, m.fetchContent(m.state, "history", f.path)
+			}
+		case "A":
+			if f, ok := m.selectedFile(); ok && !f.isDir {
+				m.statusMsg = "Loading hunks of " + f.path + "..."
+				return m, fetchHunksCmd(m.state, f.path)
+			}
+		case "X":
+			if f, ok := m.selectedFile(); ok && !f.isDir {
+				m.pendingConfirm = discardFileCmd(m.state, f.path)
+				m.statusMsg = fmt.Sprintf("Discard all changes to %s? (y/n)", f.path)
+				return m, nil
+			}
+		case "D":
+			if f, ok := m.selectedFile(); ok {
+				m.pendingConfirm = deletePathCmd(m.state, f.path)
+				m.statusMsg = fmt.Sprintf("Delete %s? (y/n)", f.path)
+				return m, nil
+			}
+		case "backspace":
+			if m.activeView == viewFiles && m.state.CurrentDir != "" {
+				return m, m.openDir(filepath.ToSlash(filepath.Dir(m.state.CurrentDir)))
 			}
 		case "S":
 			m.statusMsg = "Stashing changes..."
 			return m, stashCmd(m.state)
 		case "C":
 			m.isInputting = true
+			m.textInput.Placeholder = "Commit message..."
 			m.statusMsg = "Enter commit message (enter to confirm, esc to cancel)"
-			return m, nil
+			if m.stagedOnly {
+				m.statusMsg = "Enter commit message for the staged hunks (enter to confirm, esc to cancel)"
+			}
+			return m, fetchCommitTemplate(m.state)
 		case "?":
-			m.statusMsg = "1-3:Views|S:Stash|C:Commit|s:status|l:log|q:quit"
+			m.statusMsg = "1-5:Views|F:Search|S:Stash|a/p/d:Apply/Pop/Drop stash|C:Commit|H:File history|Enter/Bksp:Open/leave dir|X:Discard file|D:Delete file|A:Stage hunks|s:status|l:log|q:quit"
 		case "enter":
 			if m.activePane == 0 && m.navViews[m.activeView].SelectedItem() != nil {
-				selectedItem := m.navViews[m.activeView].SelectedItem().(item)
+				selectedItem := item(m.navViews[m.activeView].SelectedItem().FilterValue())
 				switch m.activeView {
 				case viewFiles:
+					if f, ok := m.navViews[viewFiles].SelectedItem().(fileItem); ok && f.isDir {
+						return m, m.openDir(f.path)
+					}
 					return m, m.fetchContent(m.state, "diff", string(selectedItem))
+				case viewSearch:
+					if match, ok := m.navViews[viewSearch].SelectedItem().(matchItem); ok {
+						return m, showMatchCmd(m.state, match)
+					}
 				case viewBranches:
 					branchName := string(selectedItem)
 					if branchName == m.state.CurrentBranch {
@@ -225,6 +422,11 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	}
 	m.viewport, cmd = m.viewport.Update(msg)
 	cmds = append(cmds, cmd)
+	if m.catNext > 0 && !m.catFetching && m.viewport.AtBottom() {
+		// Scrolled to the end of what's loaded of a long file
+		m.catFetching = true
+		cmds = append(cmds, fetchCatPage(m.state, m.catFile, m.catNext))
+	}
 	return m, tea.Batch(cmds...)
 }
 
@@ -246,14 +448,23 @@ func (m Model) View() string {
 	contentView := viewportStyle.Render(m.viewport.View())
 
 	mainView := lipgloss.JoinHorizontal(lipgloss.Top, navView, contentView)
-	statusBar := statusBarStyle.Render(m.statusMsg)
+	status := m.statusMsg
+	if m.repoStatus != "" {
+		status = "[" + m.repoStatus + "] " + status
+	}
+	if rtt := m.latency.String(); rtt != "" {
+		// So a slow command can be told apart from a slow network
+		status = rtt + " " + status
+	}
+	statusBar := statusBarStyle.Render(status)
+	header := renderHeader(m.daemonStatus)
 
 	// --- NEW: Render input box if active ---
 	if m.isInputting {
 		// Overlay the input box on top of the main view
-		return lipgloss.JoinVertical(lipgloss.Left, mainView, m.textInput.View(), statusBar)
+		return lipgloss.JoinVertical(lipgloss.Left, header, mainView, m.textInput.View(), statusBar)
 	}
-	return lipgloss.JoinVertical(lipgloss.Left, mainView, statusBar)
+	return lipgloss.JoinVertical(lipgloss.Left, header, mainView, statusBar)
 }
 
 // --- Helper Types for Bubble Tea ---
@@ -265,6 +476,25 @@ func (i item) FilterValue() string { return string(i) }
 func (i item) Title() string       { return string(i) }
 func (i item) Description() string { return "" }
 
+// fileItem is an entry of the directory shown in the Files view, annotated
+// with the size of its unstaged change (e.g. "+3 -1") when it has one.
+type fileItem struct {
+	path   string // Relative to the repo root
+	name   string // As shown; ".." for the parent directory
+	isDir  bool
+	status string // git status: "modified", "staged", "untracked", "conflicted" or ""
+	stat   string
+}
+
+func (f fileItem) FilterValue() string { return f.path }
+func (f fileItem) Description() string { return f.stat }
+func (f fileItem) Title() string {
+	if f.isDir {
+		return f.name + "/"
+	}
+	return f.name
+}
+
 // --- NEW: Custom Delegate for the list ---
 type itemDelegate struct{}
 
@@ -272,11 +502,25 @@ func (d itemDelegate) Height() int                               { return 1 }
 func (d itemDelegate) Spacing() int                              { return 0 }
 func (d itemDelegate) Update(msg tea.Msg, m *list.Model) tea.Cmd { return nil }
 func (d itemDelegate) Render(w io.Writer, m list.Model, index int, listItem list.Item) {
-	i, ok := listItem.(item)
-	if !ok {
+	var str string
+	switch i := listItem.(type) {
+	case item:
+		str = string(i)
+	case matchItem:
+		str = i.render()
+	case fileItem:
+		str = i.Title()
+		if i.isDir {
+			str = dirStyle.Render(str)
+		} else if style, ok := fileStatusStyles[i.status]; ok {
+			str = style.Render(str)
+		}
+		if i.stat != "" {
+			str += " " + statStyle.Render(i.stat)
+		}
+	default:
 		return
 	}
-	str := string(i)
 	fn := func(s string) string {
 		return lipgloss.NewStyle().Padding(0, 0, 0, 2).Render(s)
 	}
@@ -293,10 +537,16 @@ func (d itemDelegate) Render(w io.Writer, m list.Model, index int, listItem list
 
 // Messages are used to communicate between our async commands and the Update function.
 type listLoadedMsg struct {
-	viewIndex int
-	items     []list.Item
+	viewIndex     int
+	items         []list.Item
+	currentBranch string // Daemon's HEAD branch, sent with the branch list
+}
+type contentReadyMsg struct {
+	content, status string
+	yOffset         int // Line to scroll to, if any
 }
-type contentReadyMsg struct{ content, status string }
+type repoStatusMsg struct{ badge string }
+type commitTemplateMsg struct{ template string }
 type errorMsg struct{ err error }
 
 // --- Commands for Async P2P Operations ---
@@ -308,14 +558,17 @@ func fetchListContent(state *AppState, viewIndex int) tea.Cmd {
 
 		switch viewIndex {
 		case viewFiles:
-			reqType = protocol.TypeListFilesRequest
-			reqPayload = protocol.ListFilesRequestPayload{RepoPath: state.CurrentRepo}
+			reqType = protocol.TypeListDirRequest
+			reqPayload = protocol.ListDirRequestPayload{RepoPath: state.CurrentRepo, Path: state.CurrentDir}
 		case viewCommits:
 			reqType = protocol.TypeGitLogRequest // We reuse the log response
 			reqPayload = protocol.GitLogRequestPayload{RepoPath: state.CurrentRepo}
 		case viewBranches:
 			reqType = protocol.TypeListBranchesRequest
 			reqPayload = protocol.ListBranchesRequestPayload{RepoPath: state.CurrentRepo}
+		case viewStashes:
+			reqType = protocol.TypeGitStashListRequest
+			reqPayload = protocol.GitStashListRequestPayload{RepoPath: state.CurrentRepo}
 		}
 
 		respBytes, err := sendRequest(state, reqType, reqPayload)
@@ -324,21 +577,53 @@ func fetchListContent(state *AppState, viewIndex int) tea.Cmd {
 		}
 
 		var items []list.Item
+		var currentBranch string
 		switch viewIndex {
 		case viewFiles:
-			var p protocol.ListFilesResponsePayload
+			var p protocol.ListDirResponsePayload
 			json.Unmarshal(respBytes, &p)
-			for _, file := range p.Files {
-				items = append(items, item(file))
+			if !p.Success {
+				return errorMsg{fmt.Errorf(p.Error)}
+			}
+			// Annotate changed files with their line counts. This is best
+			// effort; the plain list is still useful without it.
+			stats := make(map[string]string)
+			if statBytes, err := sendRequest(state, protocol.TypeGitDiffRequest, protocol.GitDiffRequestPayload{RepoPath: state.CurrentRepo, Stat: true}); err == nil {
+				var sp protocol.GitDiffResponsePayload
+				json.Unmarshal(statBytes, &sp)
+				for _, st := range sp.Stats {
+					if st.Binary {
+						stats[st.Path] = "bin"
+					} else {
+						stats[st.Path] = fmt.Sprintf("+%d -%d", st.Insertions, st.Deletions)
+					}
+				}
+			}
+			if p.Path != "" {
+				items = append(items, fileItem{path: path.Dir(p.Path), name: "..", isDir: true})
+			}
+			for _, e := range p.Entries {
+				f := fileItem{path: path.Join(p.Path, e.Name), name: e.Name, isDir: e.IsDir, status: e.Status}
+				if !e.IsDir {
+					f.stat = stats[f.path]
+				} else if n := countChangedUnder(stats, f.path); n > 0 {
+					f.stat = fmt.Sprintf("%d changed", n)
+				}
+				items = append(items, f)
 			}
 		case viewCommits:
 			var p protocol.GitLogResponsePayload
 			json.Unmarshal(respBytes, &p)
-			// Split the log output into individual lines for the list
-			lines := strings.Split(p.Output, "\n")
-			for _, line := range lines {
-				if line != "" {
-					items = append(items, item(line))
+			for _, c := range p.Commits {
+				items = append(items, item(fmt.Sprintf("%s %s", c.AbbrevHash, c.Subject)))
+			}
+			if len(p.Commits) == 0 {
+				// Older daemons only send the pre-formatted graph; split it into lines
+				lines := strings.Split(p.Output, "\n")
+				for _, line := range lines {
+					if line != "" {
+						items = append(items, item(line))
+					}
 				}
 			}
 		case viewBranches:
@@ -347,8 +632,46 @@ func fetchListContent(state *AppState, viewIndex int) tea.Cmd {
 			for _, branch := range p.Branches {
 				items = append(items, item(branch))
 			}
+			currentBranch = p.Current
+		case viewStashes:
+			var p protocol.GitStashListResponsePayload
+			json.Unmarshal(respBytes, &p)
+			for _, st := range p.Stashes {
+				items = append(items, item(fmt.Sprintf("%s: [%s] %s", st.Ref, st.Branch, st.Message)))
+			}
+		}
+		return listLoadedMsg{viewIndex: viewIndex, items: items, currentBranch: currentBranch}
+	}
+}
+
+// fetchRepoStatus loads the branch/upstream summary shown in the status bar.
+func fetchRepoStatus(state *AppState) tea.Cmd {
+	return func() tea.Msg {
+		respBytes, err := sendRequest(state, protocol.TypeGitStatusRequest, protocol.GitStatusRequestPayload{RepoPath: state.CurrentRepo})
+		if err != nil {
+			return errorMsg{err}
+		}
+		var p protocol.GitStatusResponsePayload
+		json.Unmarshal(respBytes, &p)
+		if !p.Success || p.Branch == "" {
+			return repoStatusMsg{}
 		}
-		return listLoadedMsg{viewIndex: viewIndex, items: items}
+		return repoStatusMsg{badge: p.Badge()}
+	}
+}
+
+// fetchCommitTemplate loads the repo's commit template to pre-fill the
+// commit prompt. The prompt is a single line, so only the first line is used.
+func fetchCommitTemplate(state *AppState) tea.Cmd {
+	return func() tea.Msg {
+		respBytes, err := sendRequest(state, protocol.TypeGetCommitTemplateRequest, protocol.GetCommitTemplateRequestPayload{RepoPath: state.CurrentRepo})
+		if err != nil {
+			return errorMsg{err}
+		}
+		var p protocol.GetCommitTemplateResponsePayload
+		json.Unmarshal(respBytes, &p)
+		firstLine, _, _ := strings.Cut(p.Template, "\n")
+		return commitTemplateMsg{template: firstLine}
 	}
 }
 
@@ -371,10 +694,10 @@ func (m *Model) fetchContent(state *AppState, command, filePath string) tea.Cmd
 			reqType = protocol.TypeGitLogRequest
 			reqPayload = protocol.GitLogRequestPayload{RepoPath: state.CurrentRepo}
 			statusMsg = "Showing git log..."
-		case "cat":
-			reqType = protocol.TypeReadFileRequest
-			reqPayload = protocol.ReadFileRequestPayload{RepoPath: state.CurrentRepo, FilePath: filePath}
-			statusMsg = fmt.Sprintf("Showing content for %s...", filePath)
+		case "history":
+			reqType = protocol.TypeFileLogRequest
+			reqPayload = protocol.FileLogRequestPayload{RepoPath: state.CurrentRepo, FilePath: filePath}
+			statusMsg = fmt.Sprintf("Showing history for %s...", filePath)
 		default:
 			return errorMsg{fmt.Errorf("unknown TUI command: %s", command)}
 		}
@@ -409,13 +732,13 @@ func (m *Model) fetchContent(state *AppState, command, filePath string) tea.Cmd
 				return errorMsg{fmt.Errorf(p.Output)}
 			}
 			output = p.Output
-		case "cat":
-			var p protocol.ReadFileResponsePayload
+		case "history":
+			var p protocol.FileLogResponsePayload
 			json.Unmarshal(respBytes, &p)
 			if !p.Success {
-				return errorMsg{fmt.Errorf(p.Error)}
+				return errorMsg{fmt.Errorf(p.Output)}
 			}
-			output = p.Content
+			output = p.Output
 		}
 		// --- NEW: Apply Syntax Highlighting ---
 		var finalContent string
@@ -423,10 +746,8 @@ func (m *Model) fetchContent(state *AppState, command, filePath string) tea.Cmd
 		switch command {
 		case "diff":
 			finalContent, errHighlight = m.glamour.Render("```diff\n" + output + "\n```")
-		case "log":
+		case "log", "history":
 			finalContent = output
-		case "cat":
-			finalContent, errHighlight = m.glamour.Render("```go\n" + output + "\n```")
 		default:
 			finalContent = output
 		}
@@ -439,7 +760,10 @@ func (m *Model) fetchContent(state *AppState, command, filePath string) tea.Cmd
 
 // sendRequest is a generic helper to reduce code duplication.
 func sendRequest(state *AppState, reqType string, reqPayload interface{}) (json.RawMessage, error) {
-	stream, err := state.P2pHost.NewStream(context.Background(), state.DaemonInfo.ID, protocol.ProtocolID)
+	if err := state.Session.Ensure(context.Background()); err != nil {
+		return nil, fmt.Errorf("could not renew the session: %w", err)
+	}
+	stream, err := state.Conn.NewStream(context.Background())
 	if err != nil {
 		return nil, err
 	}
@@ -472,8 +796,18 @@ var (
 			Background(lipgloss.Color("235")).
 			Foreground(lipgloss.Color("250")).
 			Padding(0, 1)
+	statStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("214")) // Change counts in the Files view
+	dirStyle  = lipgloss.NewStyle().Foreground(lipgloss.Color("75"))
 )
 
+// fileStatusStyles color Files view entries by their git status.
+var fileStatusStyles = map[string]lipgloss.Style{
+	"modified":   lipgloss.NewStyle().Foreground(lipgloss.Color("220")),
+	"staged":     lipgloss.NewStyle().Foreground(lipgloss.Color("42")),
+	"untracked":  lipgloss.NewStyle().Foreground(lipgloss.Color("203")),
+	"conflicted": lipgloss.NewStyle().Foreground(lipgloss.Color("201")).Bold(true),
+}
+
 // This command quits the TUI, runs the editor, and then needs the app to be restarted.
 // A more advanced version would use tea.Exec to handle this gracefully.
 func editFileCmd(state *AppState, filePath string) tea.Cmd {
@@ -490,12 +824,49 @@ func editFileCmd(state *AppState, filePath string) tea.Cmd {
 	})
 }
 
+// selectedFile returns the selected entry of the Files view, unless it is
+// the ".." entry.
+func (m Model) selectedFile() (fileItem, bool) {
+	if m.activeView != viewFiles {
+		return fileItem{}, false
+	}
+	f, ok := m.navViews[viewFiles].SelectedItem().(fileItem)
+	return f, ok && f.name != ".."
+}
+
+// openDir switches the Files view to dir and loads its entries.
+func (m *Model) openDir(dir string) tea.Cmd {
+	if dir == "." {
+		dir = ""
+	}
+	m.state.CurrentDir = dir
+	m.navViews[viewFiles].ResetSelected()
+	m.updateTitles()
+	return fetchListContent(m.state, viewFiles)
+}
+
+// countChangedUnder counts the changed files in stats below dir.
+func countChangedUnder(stats map[string]string, dir string) int {
+	n := 0
+	for p := range stats {
+		if strings.HasPrefix(p, dir+"/") {
+			n++
+		}
+	}
+	return n
+}
+
 // This helper method updates the titles of the panes to reflect the current state
 func (m *Model) updateTitles() {
 	branchTitle := fmt.Sprintf(" Branch: %s ", m.state.CurrentBranch)
-	m.navViews[viewFiles].Title = "Files"
+	m.navViews[viewFiles].Title = "Files: /" + m.state.CurrentDir
 	m.navViews[viewCommits].Title = "Commits"
 	m.navViews[viewBranches].Title = "Branches"
+	m.navViews[viewStashes].Title = "Stashes"
+	m.navViews[viewSearch].Title = "Search"
+	if m.searchPattern != "" {
+		m.navViews[viewSearch].Title = "Search: " + m.searchPattern
+	}
 	// Mark the active view with a > and show the current branch
 	m.navViews[m.activeView].Title = "> " + m.navViews[m.activeView].Title + branchTitle
 }
@@ -541,16 +912,99 @@ func stashCmd(state *AppState) tea.Cmd {
 		return tea.Batch(
 			func() tea.Msg { return contentReadyMsg{content: p.Output, status: "Stash successful."} },
 			fetchListContent(state, viewFiles),
+			fetchListContent(state, viewStashes),
+		)()
+	}
+}
+
+// stashActionCmd applies, pops, or drops the stash at the given index.
+func stashActionCmd(state *AppState, action string, index int) tea.Cmd {
+	return func() tea.Msg {
+		var reqType string
+		var reqPayload interface{}
+		switch action {
+		case "apply":
+			reqType = protocol.TypeGitStashApplyRequest
+			reqPayload = protocol.GitStashApplyRequestPayload{RepoPath: state.CurrentRepo, Index: index}
+		case "pop":
+			reqType = protocol.TypeGitStashPopRequest
+			reqPayload = protocol.GitStashPopRequestPayload{RepoPath: state.CurrentRepo, Index: index}
+		case "drop":
+			reqType = protocol.TypeGitStashDropRequest
+			reqPayload = protocol.GitStashDropRequestPayload{RepoPath: state.CurrentRepo, Index: index}
+		default:
+			return errorMsg{fmt.Errorf("unknown stash action: %s", action)}
+		}
+
+		respBytes, err := sendRequest(state, reqType, reqPayload)
+		if err != nil {
+			return errorMsg{err}
+		}
+		// All three responses share the same shape
+		var p protocol.GitStashApplyResponsePayload
+		json.Unmarshal(respBytes, &p)
+		if !p.Success {
+			return errorMsg{fmt.Errorf(p.Output)}
+		}
+		return tea.Batch(
+			func() tea.Msg { return contentReadyMsg{content: p.Output, status: "Stash " + action + " successful."} },
+			fetchListContent(state, viewFiles),
+			fetchListContent(state, viewStashes),
 		)()
 	}
 }
 
-func commitCmd(state *AppState, message string) tea.Cmd {
+// discardFileCmd throws away the uncommitted changes to a single file. It
+// runs once the user said yes, which confirms it to the daemon.
+func discardFileCmd(state *AppState, filePath string) tea.Cmd {
+	return func() tea.Msg {
+		reqPayload := protocol.CheckoutFileRequestPayload{RepoPath: state.CurrentRepo, FilePath: filePath, Confirm: state.CurrentRepo}
+		respBytes, err := sendRequest(state, protocol.TypeCheckoutFileRequest, reqPayload)
+		if err != nil {
+			return errorMsg{err}
+		}
+		var p protocol.CheckoutFileResponsePayload
+		json.Unmarshal(respBytes, &p)
+		if !p.Success {
+			return errorMsg{fmt.Errorf(p.Output)}
+		}
+		return tea.Batch(
+			func() tea.Msg {
+				return contentReadyMsg{content: p.Output, status: "Discarded changes to " + filePath + "."}
+			},
+			fetchListContent(state, viewFiles),
+		)()
+	}
+}
+
+// deletePathCmd deletes a file with git rm. Only tracked, unmodified files
+// are deleted, so the deletion can always be undone with git.
+func deletePathCmd(state *AppState, filePath string) tea.Cmd {
+	return func() tea.Msg {
+		reqPayload := protocol.DeletePathRequestPayload{RepoPath: state.CurrentRepo, Path: filePath}
+		respBytes, err := sendRequest(state, protocol.TypeDeletePathRequest, reqPayload)
+		if err != nil {
+			return errorMsg{err}
+		}
+		var p protocol.DeletePathResponsePayload
+		json.Unmarshal(respBytes, &p)
+		if !p.Success {
+			return errorMsg{fmt.Errorf(p.Output)}
+		}
+		return tea.Batch(
+			func() tea.Msg { return contentReadyMsg{content: p.Output, status: "Deleted " + filePath + "."} },
+			fetchListContent(state, viewFiles),
+		)()
+	}
+}
+
+func commitCmd(state *AppState, message string, stagedOnly bool) tea.Cmd {
 	return func() tea.Msg {
 		reqPayload := protocol.GitCommitRequestPayload{
-			RepoPath: state.CurrentRepo,
-			Message:  message,
-			Branch:   state.CurrentBranch,
+			RepoPath:   state.CurrentRepo,
+			Message:    message,
+			Branch:     state.CurrentBranch,
+			StagedOnly: stagedOnly,
 		}
 		respBytes, err := sendRequest(state, protocol.TypeGitCommitRequest, reqPayload)
 		if err != nil {
@@ -564,6 +1018,7 @@ func commitCmd(state *AppState, message string) tea.Cmd {
 		// On success, refresh everything
 		return tea.Batch(
 			func() tea.Msg { return contentReadyMsg{content: p.Output, status: "Commit successful."} },
+			func() tea.Msg { return committedMsg{} },
 			fetchListContent(state, viewFiles),
 			fetchListContent(state, viewCommits),
 		)()
@@ -0,0 +1,85 @@
+package tui
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/hemantsingh443/p2p-git-remote/internal/protocol"
+)
+
+// catPageLines is how many lines of a file are fetched at a time. Long files
+// are loaded further as you scroll to the bottom, rather than all at once.
+const catPageLines = 500
+
+// catPageMsg is a page of lines of the file shown in the content pane.
+type catPageMsg struct {
+	path  string
+	start int // Line the page starts at
+	text  string
+	total int // Lines in the whole file, 0 if the page is all of it
+}
+
+// fetchCatPage fetches the page of filePath starting at line start.
+func fetchCatPage(state *AppState, filePath string, start int) tea.Cmd {
+	return func() tea.Msg {
+		reqPayload := protocol.ReadFileRequestPayload{
+			RepoPath:  state.CurrentRepo,
+			FilePath:  filePath,
+			StartLine: start,
+			EndLine:   start + catPageLines - 1,
+		}
+		respBytes, err := sendRequest(state, protocol.TypeReadFileRequest, reqPayload)
+		if err != nil {
+			return errorMsg{err}
+		}
+		var p protocol.ReadFileResponsePayload
+		json.Unmarshal(respBytes, &p)
+		if !p.Success {
+			return errorMsg{fmt.Errorf(p.Error)}
+		}
+		if p.Binary {
+			return contentReadyMsg{
+				content: fmt.Sprintf("%s is a binary file (%s, %d bytes).\n\nUse `download %s` in the client's REPL to copy it.", filePath, p.MimeType, p.Size, filePath),
+				status:  fmt.Sprintf("Showing content for %s...", filePath),
+			}
+		}
+		return catPageMsg{path: filePath, start: start, text: p.Content, total: p.TotalLines}
+	}
+}
+
+// showCatPage adds a page to the file in the content pane, or starts showing
+// a new file with its first page.
+func (m *Model) showCatPage(msg catPageMsg) {
+	if msg.start == 1 {
+		m.catFile, m.catText = msg.path, ""
+		m.viewport.GotoTop()
+	} else if msg.path != m.catFile || msg.start != m.catNext {
+		return // A page of a file no longer shown
+	}
+	m.catFetching = false
+	m.catText += msg.text
+	loaded := msg.start - 1 + strings.Count(msg.text, "\n")
+	if !strings.HasSuffix(msg.text, "\n") && msg.text != "" {
+		loaded++
+	}
+	m.catNext = 0
+	if loaded < msg.total {
+		m.catNext = loaded + 1
+	}
+
+	rendered, err := m.glamour.Render("```go\n" + m.catText + "\n```")
+	if err != nil {
+		m.statusMsg = "Error: " + err.Error()
+		return
+	}
+	offset := m.viewport.YOffset
+	m.viewport.SetContent(rendered)
+	m.viewport.SetYOffset(offset)
+	m.statusMsg = fmt.Sprintf("Showing content for %s...", msg.path)
+	if m.catNext > 0 {
+		m.statusMsg = fmt.Sprintf("Showing lines 1-%d of %d of %s (scroll down for more)", loaded, msg.total, msg.path)
+	}
+}
@@ -0,0 +1,93 @@
+package tui
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+
+	"github.com/hemantsingh443/p2p-git-remote/internal/protocol"
+)
+
+// matchItem is a line found by a search, shown in the Search view.
+type matchItem protocol.GrepMatch
+
+func (i matchItem) FilterValue() string { return i.Path }
+
+type grepResultsMsg struct {
+	pattern   string
+	matches   []protocol.GrepMatch
+	truncated bool
+}
+
+var (
+	matchPathStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("170"))
+	matchLineStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("42"))
+	matchHitStyle  = lipgloss.NewStyle().Background(lipgloss.Color("58"))
+)
+
+// render draws the match as "path:line text" for the Search view.
+func (i matchItem) render() string {
+	return matchPathStyle.Render(i.Path) + ":" + matchLineStyle.Render(fmt.Sprint(i.Line)) + " " + strings.TrimSpace(i.Text)
+}
+
+// grepCmd searches the repo for pattern. The search is case-insensitive
+// unless the pattern has an upper case letter ("smart case").
+func grepCmd(state *AppState, pattern string) tea.Cmd {
+	return func() tea.Msg {
+		reqPayload := protocol.GrepRequestPayload{
+			RepoPath:   state.CurrentRepo,
+			Pattern:    pattern,
+			IgnoreCase: strings.IndexFunc(pattern, unicode.IsUpper) < 0,
+		}
+		respBytes, err := sendRequest(state, protocol.TypeGrepRequest, reqPayload)
+		if err != nil {
+			return errorMsg{err}
+		}
+		var p protocol.GrepResponsePayload
+		json.Unmarshal(respBytes, &p)
+		if !p.Success {
+			return errorMsg{fmt.Errorf(p.Error)}
+		}
+		return grepResultsMsg{pattern: pattern, matches: p.Matches, truncated: p.Truncated}
+	}
+}
+
+// showMatchCmd shows the file of a search match with line numbers,
+// highlighting the matching line and scrolling to it.
+func showMatchCmd(state *AppState, match matchItem) tea.Cmd {
+	return func() tea.Msg {
+		reqPayload := protocol.ReadFileRequestPayload{RepoPath: state.CurrentRepo, FilePath: match.Path}
+		respBytes, err := sendRequest(state, protocol.TypeReadFileRequest, reqPayload)
+		if err != nil {
+			return errorMsg{err}
+		}
+		var p protocol.ReadFileResponsePayload
+		json.Unmarshal(respBytes, &p)
+		if !p.Success {
+			return errorMsg{fmt.Errorf(p.Error)}
+		}
+
+		var b strings.Builder
+		for n, line := range strings.Split(p.Content, "\n") {
+			numbered := fmt.Sprintf("%5d  %s", n+1, line)
+			if n+1 == match.Line {
+				numbered = matchHitStyle.Render(numbered)
+			}
+			b.WriteString(numbered + "\n")
+		}
+		// Leave a few lines of context above the match
+		offset := match.Line - 4
+		if offset < 0 {
+			offset = 0
+		}
+		return contentReadyMsg{
+			content: b.String(),
+			status:  fmt.Sprintf("Showing %s:%d", match.Path, match.Line),
+			yOffset: offset,
+		}
+	}
+}